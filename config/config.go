@@ -0,0 +1,336 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Redacted is shown in place of secret values that are set
+const Redacted = "[REDACTED]"
+
+// Config holds every environment-driven setting, resolved once at startup.
+// Each field names its env var; `default` applies when the var is unset and
+// `secret` fields are masked in Redacted views.
+type Config struct {
+	Host       string `env:"HOST"`
+	Port       string `env:"PORT" default:"8080"`
+	AdminToken string `env:"ADMIN_TOKEN" secret:"true"`
+	// HMAC key for login tokens; unset uses a random key, so tokens die with the process
+	JWTSecret string `env:"JWT_SECRET" secret:"true"`
+	// HMAC key for ticket QR codes; unset falls back to JWT_SECRET. Changing it voids printed tickets
+	TicketSecret string `env:"TICKET_SECRET" secret:"true"`
+	// Comma-separated emails that get the admin role when they register
+	AdminEmails string `env:"ADMIN_EMAILS"`
+	FixturesDir string `env:"FIXTURES_DIR"`
+	// A single JSON or YAML fixtures file, used in place of FIXTURES_DIR
+	FixturesFile string `env:"FIXTURES_FILE"`
+	SnapshotPath string `env:"SNAPSHOT_PATH"`
+	// Comma-separated origins allowed to call the API cross-origin; unset allows any
+	AllowedOrigins string `env:"ALLOWED_ORIGINS"`
+	// Comma-separated group=rate:burst overrides of the request rate limit for the auth,
+	// bookings and queue route groups, e.g. "queue=2:4,auth=1:5"; see RateLimits
+	RateLimitGroups string `env:"RATE_LIMITS"`
+	// Where state is persisted: memory, postgres or sqlite (see Backend)
+	StorageBackend string `env:"STORAGE_BACKEND"`
+	// PostgreSQL connection string for the postgres backend
+	DatabaseURL string `env:"DATABASE_URL" secret:"true"`
+	// Database file for the sqlite backend
+	SQLitePath string `env:"SQLITE_PATH" default:"booking.db"`
+	// Redis URL; when set, instances share their state (holds, queues, bookings) through it
+	RedisURL string `env:"REDIS_URL" secret:"true"`
+	// Stripe secret key; when set, holds are paid through Stripe PaymentIntents
+	StripeSecretKey string `env:"STRIPE_SECRET_KEY" secret:"true"`
+	// Signing secret of the Stripe webhook endpoint pointed at /api/v1/payments/webhook
+	StripeWebhookSecret string `env:"STRIPE_WEBHOOK_SECRET" secret:"true"`
+	PaymentCurrency     string `env:"PAYMENT_CURRENCY" default:"usd"`
+	// SMTP server for notification emails; unset discards them
+	SMTPHost     string `env:"SMTP_HOST"`
+	SMTPPort     string `env:"SMTP_PORT" default:"587"`
+	SMTPUsername string `env:"SMTP_USERNAME"`
+	SMTPPassword string `env:"SMTP_PASSWORD" secret:"true"`
+	EmailFrom    string `env:"EMAIL_FROM" default:"Conference Bookings <no-reply@localhost>"`
+	// OTLP/HTTP collector base URL; when set, request and store spans are exported to
+	// <endpoint>/v1/traces
+	OTLPEndpoint string `env:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+	// Full traces URL, used as is in place of OTLPEndpoint
+	OTLPTracesEndpoint string `env:"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"`
+	// Comma-separated key=value headers sent to the collector, e.g. an API key
+	OTLPHeaders string `env:"OTEL_EXPORTER_OTLP_HEADERS" secret:"true"`
+	ServiceName string `env:"OTEL_SERVICE_NAME" default:"booking-system"`
+
+	ReservationHold          time.Duration `env:"RESERVATION_HOLD" default:"15s"`
+	MaxHoldTime              time.Duration `env:"MAX_HOLD_TIME" default:"2m"`
+	PaymentHoldTime          time.Duration `env:"PAYMENT_HOLD_TIME" default:"15m"`
+	ReservationSweepInterval time.Duration `env:"RESERVATION_SWEEP_INTERVAL" default:"1s"`
+	OrphanSweepInterval      time.Duration `env:"ORPHAN_SWEEP_INTERVAL" default:"30s"`
+	SnapshotInterval         time.Duration `env:"SNAPSHOT_INTERVAL" default:"0s"` // 0 saves SNAPSHOT_PATH only on shutdown
+	JWTTTL                   time.Duration `env:"JWT_TTL" default:"24h"`
+	QueueOfferWindow         time.Duration `env:"QUEUE_OFFER_WINDOW" default:"30s"`
+	QueueClaimWindow         time.Duration `env:"QUEUE_CLAIM_WINDOW" default:"30s"`
+	ShutdownTimeout          time.Duration `env:"SHUTDOWN_TIMEOUT" default:"10s"`
+	ShutdownDrainDelay       time.Duration `env:"SHUTDOWN_DRAIN_DELAY" default:"0s"`
+	EmailExpiryWarning       time.Duration `env:"EMAIL_EXPIRY_WARNING" default:"5s"`
+	WebhookRetryDelay        time.Duration `env:"WEBHOOK_RETRY_DELAY" default:"5s"`
+	WebhookTimeout           time.Duration `env:"WEBHOOK_TIMEOUT" default:"10s"`
+	MaxTicketsPerUser        int           `env:"MAX_TICKETS_PER_USER" default:"10"`
+	MaxTicketsPerUserGlobal  int           `env:"MAX_TICKETS_PER_USER_GLOBAL" default:"0"`
+	MaxFailedClaims          int           `env:"MAX_FAILED_CLAIMS" default:"3"`
+	QueueClaimRequeues       int           `env:"QUEUE_CLAIM_REQUEUES" default:"0"`
+	MaxReservationExtensions int           `env:"MAX_RESERVATION_EXTENSIONS" default:"2"`
+	RateLimitPerSecond       int           `env:"RATE_LIMIT_PER_SECOND" default:"5"`
+	RateLimitBurst           int           `env:"RATE_LIMIT_BURST" default:"10"`
+	RateLimitIPMultiplier    int           `env:"RATE_LIMIT_IP_MULTIPLIER" default:"4"`
+	MaxBodyBytes             int           `env:"MAX_BODY_BYTES" default:"1048576"`
+	QueueCapFactor           int           `env:"QUEUE_CAP_FACTOR" default:"2"`
+	MaxQueueLength           int           `env:"MAX_QUEUE_LENGTH" default:"10000"`
+	MaxAuditEntries          int           `env:"AUDIT_MAX_ENTRIES" default:"100000"`
+	PaymentFailPercent       int           `env:"PAYMENT_FAIL_PERCENT" default:"0"`
+	TraceSamplePercent       int           `env:"TRACE_SAMPLE_PERCENT" default:"100"`
+	EmailWorkers             int           `env:"EMAIL_WORKERS" default:"2"`
+	WebhookWorkers           int           `env:"WEBHOOK_WORKERS" default:"4"`
+	WebhookMaxAttempts       int           `env:"WEBHOOK_MAX_ATTEMPTS" default:"5"`
+}
+
+// Load reads the configuration from the environment
+func Load() (*Config, error) {
+	cfg := &Config{}
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		raw, ok := os.LookupEnv(field.Tag.Get("env"))
+		if !ok || raw == "" {
+			raw = field.Tag.Get("default")
+		}
+		if raw == "" {
+			continue
+		}
+		if err := setField(v.Field(i), raw); err != nil {
+			return nil, fmt.Errorf("%s: %w", field.Tag.Get("env"), err)
+		}
+	}
+	if err := cfg.checkDurations(); err != nil {
+		return nil, err
+	}
+
+	// Support both local development and cloud deployment
+	if cfg.Host == "" {
+		cfg.Host = "127.0.0.1"
+		if os.Getenv("RAILWAY_ENVIRONMENT") != "" || os.Getenv("RENDER") != "" || os.Getenv("DOCKER_ENV") == "true" {
+			cfg.Host = "0.0.0.0" // Listen on all interfaces for cloud deployment or Docker
+		}
+	}
+	return cfg, nil
+}
+
+// checkDurations rejects durations out of range: the ones that drive tickers, windows and
+// timeouts must be positive, and the ones where 0 turns something off can't be negative
+func (c *Config) checkDurations() error {
+	positive := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"RESERVATION_HOLD", c.ReservationHold},
+		{"RESERVATION_SWEEP_INTERVAL", c.ReservationSweepInterval},
+		{"ORPHAN_SWEEP_INTERVAL", c.OrphanSweepInterval},
+		{"JWT_TTL", c.JWTTTL},
+		{"QUEUE_CLAIM_WINDOW", c.QueueClaimWindow},
+		{"SHUTDOWN_TIMEOUT", c.ShutdownTimeout},
+		{"WEBHOOK_TIMEOUT", c.WebhookTimeout},
+	}
+	for _, d := range positive {
+		if d.value <= 0 {
+			return fmt.Errorf("%s: must be positive, got %s", d.name, d.value)
+		}
+	}
+	optional := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"MAX_HOLD_TIME", c.MaxHoldTime},
+		{"PAYMENT_HOLD_TIME", c.PaymentHoldTime},
+		{"SNAPSHOT_INTERVAL", c.SnapshotInterval},
+		{"QUEUE_OFFER_WINDOW", c.QueueOfferWindow},
+		{"SHUTDOWN_DRAIN_DELAY", c.ShutdownDrainDelay},
+		{"EMAIL_EXPIRY_WARNING", c.EmailExpiryWarning},
+		{"WEBHOOK_RETRY_DELAY", c.WebhookRetryDelay},
+	}
+	for _, d := range optional {
+		if d.value < 0 {
+			return fmt.Errorf("%s: can't be negative, got %s", d.name, d.value)
+		}
+	}
+	return nil
+}
+
+// setField parses raw into a string, int or duration field
+func setField(f reflect.Value, raw string) error {
+	switch {
+	case f.Type() == reflect.TypeOf(time.Duration(0)):
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		f.SetInt(int64(d))
+	case f.Kind() == reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return err
+		}
+		f.SetInt(int64(n))
+	case f.Kind() == reflect.String:
+		f.SetString(raw)
+	default:
+		return fmt.Errorf("unsupported config type %s", f.Type())
+	}
+	return nil
+}
+
+// Fixtures is the fixture set to start from: FixturesFile, else FixturesDir; empty means the
+// built-in sample data
+func (c *Config) Fixtures() string {
+	if c.FixturesFile != "" {
+		return c.FixturesFile
+	}
+	return c.FixturesDir
+}
+
+// Origins splits AllowedOrigins into a list, dropping blanks; empty means any origin
+func (c *Config) Origins() []string {
+	return splitList(c.AllowedOrigins)
+}
+
+// Admins splits AdminEmails into a list, dropping blanks
+func (c *Config) Admins() []string {
+	return splitList(c.AdminEmails)
+}
+
+// TracesURL is where spans are exported, empty when tracing is off
+func (c *Config) TracesURL() string {
+	if c.OTLPTracesEndpoint != "" {
+		return c.OTLPTracesEndpoint
+	}
+	if c.OTLPEndpoint == "" {
+		return ""
+	}
+	return strings.TrimRight(c.OTLPEndpoint, "/") + "/v1/traces"
+}
+
+// CollectorHeaders parses OTLPHeaders into a map, skipping entries without a key
+func (c *Config) CollectorHeaders() map[string]string {
+	headers := make(map[string]string)
+	for _, item := range splitList(c.OTLPHeaders) {
+		key, value, _ := strings.Cut(item, "=")
+		if key = strings.TrimSpace(key); key != "" {
+			headers[key] = strings.TrimSpace(value)
+		}
+	}
+	return headers
+}
+
+// RateLimit is a request rate per second and burst size
+type RateLimit struct {
+	PerSecond float64
+	Burst     int
+}
+
+// RateLimits parses RateLimitGroups into each group's limit; a group left out uses
+// RATE_LIMIT_PER_SECOND and RATE_LIMIT_BURST, and a burst left out (queue=2) is the rate's
+func (c *Config) RateLimits() (map[string]RateLimit, error) {
+	limits := make(map[string]RateLimit)
+	for _, item := range splitList(c.RateLimitGroups) {
+		group, spec, ok := strings.Cut(item, "=")
+		group = strings.TrimSpace(group)
+		if !ok || group == "" {
+			return nil, fmt.Errorf("RATE_LIMITS: %q isn't group=rate:burst", item)
+		}
+		rate, burst, hasBurst := strings.Cut(strings.TrimSpace(spec), ":")
+		perSecond, err := strconv.ParseFloat(rate, 64)
+		if err != nil || perSecond < 0 {
+			return nil, fmt.Errorf("RATE_LIMITS: bad rate %q for %s", rate, group)
+		}
+		limit := RateLimit{PerSecond: perSecond, Burst: int(math.Ceil(perSecond))}
+		if hasBurst {
+			if limit.Burst, err = strconv.Atoi(burst); err != nil || limit.Burst < 1 {
+				return nil, fmt.Errorf("RATE_LIMITS: bad burst %q for %s", burst, group)
+			}
+		}
+		limits[group] = limit
+	}
+	return limits, nil
+}
+
+// splitList splits a comma-separated setting, trimming entries and dropping blanks
+func splitList(raw string) []string {
+	var items []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Storage backends accepted in STORAGE_BACKEND
+const (
+	BackendMemory   = "memory"
+	BackendPostgres = "postgres"
+	BackendSQLite   = "sqlite"
+)
+
+// Backend resolves StorageBackend. Unset means postgres when DATABASE_URL is given (as before
+// STORAGE_BACKEND existed) and memory otherwise. A durable backend can't be combined with
+// REDIS_URL: every instance would write its own copy.
+func (c *Config) Backend() (string, error) {
+	if c.RedisURL != "" {
+		if b := strings.ToLower(strings.TrimSpace(c.StorageBackend)); b != "" && b != BackendMemory {
+			return "", fmt.Errorf("STORAGE_BACKEND=%s can't be combined with REDIS_URL", c.StorageBackend)
+		}
+	}
+	switch strings.ToLower(strings.TrimSpace(c.StorageBackend)) {
+	case "":
+		if c.DatabaseURL != "" && c.RedisURL == "" {
+			return BackendPostgres, nil
+		}
+		return BackendMemory, nil
+	case BackendMemory:
+		return BackendMemory, nil
+	case BackendPostgres:
+		if c.DatabaseURL == "" {
+			return "", fmt.Errorf("STORAGE_BACKEND=postgres needs DATABASE_URL")
+		}
+		return BackendPostgres, nil
+	case BackendSQLite:
+		return BackendSQLite, nil
+	}
+	return "", fmt.Errorf("STORAGE_BACKEND: unknown backend %q (want memory, postgres or sqlite)", c.StorageBackend)
+}
+
+// Addr returns the host:port the server listens on
+func (c *Config) Addr() string {
+	return fmt.Sprintf("%s:%s", c.Host, c.Port)
+}
+
+// Redacted returns the resolved values keyed by env var, with secrets masked
+func (c *Config) Redacted() map[string]interface{} {
+	view := make(map[string]interface{})
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		value := v.Field(i).Interface()
+		if field.Tag.Get("secret") == "true" && !v.Field(i).IsZero() {
+			value = Redacted
+		}
+		if d, ok := value.(time.Duration); ok {
+			value = d.String()
+		}
+		view[field.Tag.Get("env")] = value
+	}
+	return view
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedactedShowsValuesAndMasksSecrets(t *testing.T) {
+	t.Setenv("PORT", "9090")
+	t.Setenv("MAX_FAILED_CLAIMS", "5")
+	t.Setenv("ADMIN_TOKEN", "s3cret")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	view := cfg.Redacted()
+	if view["PORT"] != "9090" {
+		t.Fatalf("expected PORT 9090, got %v", view["PORT"])
+	}
+	if view["MAX_FAILED_CLAIMS"] != 5 {
+		t.Fatalf("expected MAX_FAILED_CLAIMS 5, got %v", view["MAX_FAILED_CLAIMS"])
+	}
+	if view["ADMIN_TOKEN"] != Redacted {
+		t.Fatalf("expected ADMIN_TOKEN redacted, got %v", view["ADMIN_TOKEN"])
+	}
+}
+
+func TestLoadRejectsMalformedValues(t *testing.T) {
+	t.Setenv("MAX_TICKETS_PER_USER", "ten")
+	if _, err := Load(); err == nil {
+		t.Fatalf("expected error for non-numeric MAX_TICKETS_PER_USER")
+	}
+}
+
+func TestLoadRejectsDurationsOutOfRange(t *testing.T) {
+	cases := []struct {
+		env, value string
+		wantErr    bool
+	}{
+		{"ORPHAN_SWEEP_INTERVAL", "0s", true},
+		{"RESERVATION_SWEEP_INTERVAL", "-2s", true},
+		{"QUEUE_CLAIM_WINDOW", "0s", true},
+		{"QUEUE_OFFER_WINDOW", "-1s", true},
+		{"SHUTDOWN_TIMEOUT", "0s", true},
+		{"SHUTDOWN_DRAIN_DELAY", "-1s", true},
+		{"QUEUE_OFFER_WINDOW", "0s", false},
+		{"SHUTDOWN_DRAIN_DELAY", "0s", false},
+		{"RESERVATION_SWEEP_INTERVAL", "500ms", false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.env+"="+tc.value, func(t *testing.T) {
+			t.Setenv(tc.env, tc.value)
+			_, err := Load()
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Load() with %s=%s: error %v, want error %v", tc.env, tc.value, err, tc.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), tc.env) {
+				t.Fatalf("expected the error to name %s, got %v", tc.env, err)
+			}
+		})
+	}
+}
+
+func TestBackendDefaultsFromDatabaseURL(t *testing.T) {
+	cases := []struct {
+		backend, url, redis string
+		want                string
+		wantErr             bool
+	}{
+		{"", "", "", BackendMemory, false},
+		{"", "postgres://localhost/booking", "", BackendPostgres, false},
+		{"SQLite", "", "", BackendSQLite, false},
+		{"memory", "postgres://localhost/booking", "", BackendMemory, false},
+		{"postgres", "", "", "", true},
+		{"redis", "", "", "", true},
+		{"", "postgres://localhost/booking", "redis://localhost:6379", BackendMemory, false},
+		{"sqlite", "", "redis://localhost:6379", "", true},
+	}
+	for _, tc := range cases {
+		cfg := &Config{StorageBackend: tc.backend, DatabaseURL: tc.url, RedisURL: tc.redis}
+		got, err := cfg.Backend()
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("Backend(%q, %q, %q) = %q, %v; want %q (error %v)", tc.backend, tc.url, tc.redis, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+func TestRateLimitsParsesGroupOverrides(t *testing.T) {
+	cfg := &Config{RateLimitGroups: "queue=2:4, auth=0.5"}
+	limits, err := cfg.RateLimits()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if limits["queue"] != (RateLimit{PerSecond: 2, Burst: 4}) || limits["auth"] != (RateLimit{PerSecond: 0.5, Burst: 1}) || len(limits) != 2 {
+		t.Fatalf("unexpected limits %+v", limits)
+	}
+	for _, raw := range []string{"queue", "queue=fast", "queue=2:0", "=2:4"} {
+		cfg.RateLimitGroups = raw
+		if _, err := cfg.RateLimits(); err == nil {
+			t.Errorf("expected an error for %q", raw)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package database
+
+import "strings"
+
+// ConferenceAnalytics summarizes sales for one conference
+type ConferenceAnalytics struct {
+	ConferenceID       string  `json:"conference_id"`
+	Name               string  `json:"name"`
+	TicketsSold        int     `json:"tickets_sold"`        // confirmed bookings only
+	Revenue            float64 `json:"revenue"`             // sum of confirmed booking amounts
+	FillPercent        float64 `json:"fill_percent"`        // TicketsSold as a percent of TotalTickets
+	ActiveReservations int     `json:"active_reservations"` // unexpired holds awaiting payment
+	QueueLength        int     `json:"queue_length"`
+	// Tiers breaks the figures down by ticket tier, in the conference's tier order; sales
+	// outside every tier only count toward the totals above
+	Tiers []TierAnalytics `json:"tiers,omitempty"`
+}
+
+// TierAnalytics summarizes sales for one ticket tier of a conference
+type TierAnalytics struct {
+	Name               string  `json:"name"`
+	TicketsSold        int     `json:"tickets_sold"`
+	Revenue            float64 `json:"revenue"`
+	FillPercent        float64 `json:"fill_percent"` // TicketsSold as a percent of the tier's tickets
+	AvailableTickets   int     `json:"available_tickets"`
+	ActiveReservations int     `json:"active_reservations"`
+	QueueLength        int     `json:"queue_length"` // entries waiting for this tier
+}
+
+// tierAnalytics returns the entry of s for the named tier, nil for the aggregate pool
+func (s *ConferenceAnalytics) tierAnalytics(name string) *TierAnalytics {
+	for i := range s.Tiers {
+		if name != "" && strings.EqualFold(s.Tiers[i].Name, name) {
+			return &s.Tiers[i]
+		}
+	}
+	return nil
+}
+
+// GetConferenceAnalytics computes sales analytics for every conference, keyed by conference ID.
+// Cancelled bookings and expired holds are excluded.
+func (db *Database) GetConferenceAnalytics() map[string]ConferenceAnalytics {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	stats := make(map[string]ConferenceAnalytics, len(db.Conferences))
+	for id, conf := range db.Conferences {
+		s := ConferenceAnalytics{ConferenceID: id, Name: conf.Name, QueueLength: len(db.WaitQueues[id])}
+		for _, tier := range conf.Tiers {
+			s.Tiers = append(s.Tiers, TierAnalytics{Name: tier.Name, AvailableTickets: tier.AvailableTickets})
+		}
+		for _, e := range db.WaitQueues[id] {
+			if t := s.tierAnalytics(e.Tier); t != nil {
+				t.QueueLength++
+			}
+		}
+		stats[id] = s
+	}
+	for _, b := range db.Bookings {
+		s, ok := stats[b.ConferenceID]
+		if !ok || b.Status != "confirmed" {
+			continue
+		}
+		s.TicketsSold += b.TicketsBooked
+		s.Revenue += b.TotalAmount
+		if t := s.tierAnalytics(b.Tier); t != nil {
+			t.TicketsSold += b.TicketsBooked
+			t.Revenue += b.TotalAmount
+		}
+		stats[b.ConferenceID] = s
+	}
+	now := db.now()
+	for _, r := range db.Reservations {
+		s, ok := stats[r.ConferenceID]
+		if !ok || !now.Before(r.ExpiresAt) {
+			continue
+		}
+		s.ActiveReservations++
+		if t := s.tierAnalytics(r.Tier); t != nil {
+			t.ActiveReservations++
+		}
+		stats[r.ConferenceID] = s
+	}
+	for id, s := range stats {
+		conf := db.Conferences[id]
+		if conf.TotalTickets > 0 {
+			s.FillPercent = float64(s.TicketsSold) * 100 / float64(conf.TotalTickets)
+		}
+		for i, tier := range conf.Tiers {
+			if tier.TotalTickets > 0 {
+				s.Tiers[i].FillPercent = float64(s.Tiers[i].TicketsSold) * 100 / float64(tier.TotalTickets)
+			}
+		}
+		stats[id] = s
+	}
+	return stats
+}
@@ -0,0 +1,571 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"booking-system/models"
+)
+
+// Audit operation names
+const (
+	OpUserCreate         = "user.create"
+	OpUserRole           = "user.role"
+	OpUserPriority       = "user.priority"
+	OpBookingCreate      = "booking.create"
+	OpBookingCancel      = "booking.cancel"
+	OpReservationCreate  = "reservation.create"
+	OpReservationConfirm = "reservation.confirm"
+	OpReservationCancel  = "reservation.cancel"
+	OpReservationExpire  = "reservation.expire"
+	OpReservationExtend  = "reservation.extend"
+	OpReservationRenew   = "reservation.renew"
+	OpReservationPayment = "reservation.payment"
+	OpReservationNames   = "reservation.attendees"
+	OpQueueEnqueue       = "queue.enqueue"
+	OpQueueClaim         = "queue.claim"
+	OpQueueDrop          = "queue.drop"
+	OpQueueRequeue       = "queue.requeue"
+	OpQueueLeave         = "queue.leave"
+	OpQueueNotify        = "queue.notify"
+	OpQueueOffer         = "queue.offer"
+	OpOfferAccept        = "offer.accept"
+	OpHoldbackRelease    = "holdback.release"
+	OpConferenceCreate   = "conference.create"
+	OpConferenceUpdate   = "conference.update"
+	OpConferenceDelete   = "conference.delete"
+	OpCartUpdate         = "cart.update"
+	OpTicketCheckIn      = "ticket.checkin"
+)
+
+// ActorSystem is the actor of changes the server makes on its own: expiring holds, offering
+// freed seats down the queue, notifying and dropping queue heads
+const ActorSystem = "system"
+
+// systemOps are the operations only the server itself performs
+var systemOps = map[string]bool{
+	OpReservationExpire: true,
+	OpQueueOffer:        true,
+	OpQueueNotify:       true,
+	OpQueueDrop:         true,
+	OpQueueRequeue:      true,
+}
+
+// Change is a count before and after an audited change
+type Change struct {
+	Before int `json:"before"`
+	After  int `json:"after"`
+}
+
+// AuditEntry records one state change with enough detail to replay it
+type AuditEntry struct {
+	Seq           int              `json:"seq"`
+	Op            string           `json:"op"`
+	At            time.Time        `json:"at"`
+	UserID        string           `json:"user_id,omitempty"`
+	ConferenceID  string           `json:"conference_id,omitempty"`
+	ReservationID string           `json:"reservation_id,omitempty"`
+	BookingID     string           `json:"booking_id,omitempty"`
+	EntryID       string           `json:"entry_id,omitempty"`
+	TicketCount   int              `json:"ticket_count,omitempty"`
+	Amount        float64          `json:"amount,omitempty"`
+	Name          string           `json:"name,omitempty"`
+	Email         string           `json:"email,omitempty"`
+	Role          string           `json:"role,omitempty"`
+	ExpiresAt     time.Time        `json:"expires_at,omitempty"`
+	Attendees     []string         `json:"attendees,omitempty"`
+	Location      string           `json:"location,omitempty"`
+	Date          time.Time        `json:"date,omitempty"`
+	PromoCode     string           `json:"promo_code,omitempty"`
+	Tier          string           `json:"tier,omitempty"`
+	HoldSeconds   int              `json:"hold_seconds,omitempty"`
+	Description   string           `json:"description,omitempty"`
+	Tags          []string         `json:"tags,omitempty"`
+	Tiers         []models.Tier    `json:"tiers,omitempty"`    // a created conference's ticket tiers
+	Sections      []models.Section `json:"sections,omitempty"` // a created conference's seat map
+	SeatIDs       []string         `json:"seat_ids,omitempty"` // seats a hold or booking took
+	Cart          *models.Cart     `json:"cart,omitempty"`     // a cart's state after the change
+	CheckIn       *models.CheckIn  `json:"check_in,omitempty"` // a ticket admitted at the door
+	PaymentID     string           `json:"payment_id,omitempty"`
+	RequestID     string           `json:"request_id,omitempty"` // API request that caused it, when known
+	// Who made the change: the signed-in user asking when the request said so, otherwise the
+	// user it concerns, or ActorSystem
+	Actor string `json:"actor,omitempty"`
+	// The conference's available tickets before and after, when the change moved them
+	Available *Change `json:"available,omitempty"`
+	// A conference's waiting room settings once created or updated, nil when it has none
+	WaitingRoom *models.WaitingRoom `json:"waiting_room,omitempty"`
+	// A queue entry's priority class once enqueued; see WaitEntry.Priority
+	Priority int `json:"priority,omitempty"`
+	// An offered or requeued entry's missed turns; see WaitEntry.MissedClaims
+	MissedClaims int `json:"missed_claims,omitempty"`
+}
+
+// recordLocked appends an entry to the audit log and folds it into the inventory stream;
+// caller must hold write lock
+func (db *Database) recordLocked(entry AuditEntry) {
+	entry.Seq = db.lastSeqLocked() + 1
+	if entry.RequestID == "" {
+		entry.RequestID = db.requestID
+	}
+	if entry.At.IsZero() {
+		entry.At = db.now()
+	}
+	switch {
+	case entry.Actor != "":
+	case systemOps[entry.Op]:
+		entry.Actor = ActorSystem
+	case db.actor != "":
+		entry.Actor = db.actor
+	default:
+		entry.Actor = entry.UserID
+	}
+	db.foldLocked(&entry)
+	db.appendAuditLocked(entry)
+	db.writes++
+	db.countLocked(entry.Op)
+	db.queueEventLocked(entry)
+}
+
+// appendAuditLocked adds an entry to the audit log, trimming the oldest once it is full; caller
+// must hold write lock
+func (db *Database) appendAuditLocked(entry AuditEntry) {
+	db.Audit = append(db.Audit, entry)
+	db.trimAuditLocked()
+}
+
+// lastSeqLocked is the Seq of the newest audit entry, or where the inventory stream starts
+// when the log is empty; caller must hold the lock
+func (db *Database) lastSeqLocked() int {
+	if n := len(db.Audit); n > 0 {
+		return db.Audit[n-1].Seq
+	}
+	return db.ledger.start
+}
+
+// GetAuditLog returns a copy of the audit log in recording order
+func (db *Database) GetAuditLog() []AuditEntry {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	entries := make([]AuditEntry, len(db.Audit))
+	copy(entries, db.Audit)
+	return entries
+}
+
+// AuditFilter narrows the entries QueryAuditLog returns; zero fields match everything
+type AuditFilter struct {
+	Op            string // an operation name, or a prefix ending in "." such as "reservation."
+	UserID        string // entries concerning this user
+	ConferenceID  string
+	ReservationID string
+	BookingID     string
+	Actor         string // entries this user (or ActorSystem) made
+	RequestID     string
+	From          time.Time // inclusive lower bound on At
+	To            time.Time // inclusive upper bound on At
+	AfterSeq      int       // only entries recorded after this one, for following the log
+}
+
+// matches reports whether e passes the filter
+func (f AuditFilter) matches(e *AuditEntry) bool {
+	switch {
+	case f.Op != "" && e.Op != f.Op && !(strings.HasSuffix(f.Op, ".") && strings.HasPrefix(e.Op, f.Op)):
+		return false
+	case f.UserID != "" && e.UserID != f.UserID,
+		f.ConferenceID != "" && e.ConferenceID != f.ConferenceID,
+		f.ReservationID != "" && e.ReservationID != f.ReservationID,
+		f.BookingID != "" && e.BookingID != f.BookingID,
+		f.Actor != "" && e.Actor != f.Actor,
+		f.RequestID != "" && e.RequestID != f.RequestID:
+		return false
+	case !f.From.IsZero() && e.At.Before(f.From), !f.To.IsZero() && e.At.After(f.To):
+		return false
+	}
+	return e.Seq > f.AfterSeq
+}
+
+// QueryAuditLog returns a page of the audit entries matching filter, newest first, and how many
+// match in all
+func (db *Database) QueryAuditLog(filter AuditFilter, limit, offset int) ([]AuditEntry, int) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	matched := make([]AuditEntry, 0)
+	for i := len(db.Audit) - 1; i >= 0; i-- {
+		if filter.matches(&db.Audit[i]) {
+			matched = append(matched, db.Audit[i])
+		}
+	}
+	start, end := pageBounds(len(matched), limit, offset)
+	return matched[start:end:end], len(matched)
+}
+
+// ErrAuditTrimmed is returned when replaying an audit log whose oldest entries were dropped
+// to keep it within MaxAuditEntries
+var ErrAuditTrimmed = errors.New("audit log no longer starts at its first entry")
+
+// ReplayAudit resets the database to sample data and re-applies each entry in order,
+// reproducing the recorded IDs, amounts and timestamps. It fails on the first entry that
+// cannot be applied, which points at where the log and the state it describes diverge.
+func (db *Database) ReplayAudit(entries []AuditEntry) error {
+	if len(entries) > 0 && entries[0].Seq > 1 {
+		return fmt.Errorf("%w: it starts at entry %d", ErrAuditTrimmed, entries[0].Seq)
+	}
+	db.ResetDatabase()
+
+	db.lock()
+	defer db.unlock()
+
+	for _, e := range entries {
+		if err := db.applyLocked(e); err != nil {
+			return fmt.Errorf("replay entry %d (%s): %w", e.Seq, e.Op, err)
+		}
+		db.foldLocked(&e)
+		db.appendAuditLocked(e)
+	}
+	return nil
+}
+
+// applyLocked applies a single audit entry; caller must hold write lock
+func (db *Database) applyLocked(e AuditEntry) error {
+	switch e.Op {
+	case OpUserCreate:
+		db.Users[e.UserID] = &models.User{ID: e.UserID, Name: e.Name, Email: e.Email, Created: e.At, Role: roleOrUser(e.Role)}
+
+	case OpUserRole:
+		user, ok := db.Users[e.UserID]
+		if !ok {
+			return fmt.Errorf("user %s not found", e.UserID)
+		}
+		user.Role = e.Role
+
+	case OpUserPriority:
+		user, ok := db.Users[e.UserID]
+		if !ok {
+			return fmt.Errorf("user %s not found", e.UserID)
+		}
+		user.QueuePriority = e.Priority
+
+	case OpBookingCreate:
+		if _, ok := db.Conferences[e.ConferenceID]; !ok {
+			return fmt.Errorf("conference %s not found", e.ConferenceID)
+		}
+		db.Bookings[e.BookingID] = &models.Booking{
+			ID:            e.BookingID,
+			UserID:        e.UserID,
+			ConferenceID:  e.ConferenceID,
+			TicketsBooked: e.TicketCount,
+			TotalAmount:   e.Amount,
+			Status:        "confirmed",
+			BookedAt:      e.At,
+			Attendees:     attendeesOrEmpty(e.Attendees),
+			Tier:          e.Tier,
+			SeatIDs:       e.SeatIDs,
+		}
+
+	case OpBookingCancel:
+		booking, ok := db.Bookings[e.BookingID]
+		if !ok {
+			return fmt.Errorf("booking %s not found", e.BookingID)
+		}
+		if booking.Status == "cancelled" {
+			return fmt.Errorf("booking %s already cancelled", e.BookingID)
+		}
+		db.cancelBookingLocked(booking)
+
+	case OpReservationCreate, OpQueueClaim, OpQueueOffer:
+		if _, ok := db.Conferences[e.ConferenceID]; !ok {
+			return fmt.Errorf("conference %s not found", e.ConferenceID)
+		}
+		db.Reservations[e.ReservationID] = &models.SeatReservation{
+			ID:           e.ReservationID,
+			UserID:       e.UserID,
+			ConferenceID: e.ConferenceID,
+			TicketCount:  e.TicketCount,
+			TotalAmount:  e.Amount,
+			ExpiresAt:    e.ExpiresAt,
+			CreatedAt:    e.At,
+			Source:       models.ReservationSourceDirect,
+			Status:       models.ReservationStatusActive,
+			PromoCode:    e.PromoCode,
+			Tier:         e.Tier,
+			HoldSeconds:  e.HoldSeconds,
+			SeatIDs:      e.SeatIDs,
+		}
+		if e.Op == OpQueueOffer {
+			db.Reservations[e.ReservationID].Status = models.ReservationStatusOffered
+			db.Reservations[e.ReservationID].QueueTurn = &models.QueueTurn{EntryID: e.EntryID, Priority: e.Priority, MissedClaims: e.MissedClaims}
+		}
+		if e.Op == OpQueueClaim || e.Op == OpQueueOffer {
+			db.Reservations[e.ReservationID].Source = models.ReservationSourceQueue
+			q := db.WaitQueues[e.ConferenceID]
+			if len(q) == 0 || q[0].UserID != e.UserID {
+				return fmt.Errorf("user %s is not at the head of the queue", e.UserID)
+			}
+			db.WaitQueues[e.ConferenceID] = q[1:]
+		}
+
+	case OpReservationConfirm:
+		res, ok := db.Reservations[e.ReservationID]
+		if !ok {
+			return fmt.Errorf("reservation %s not found", e.ReservationID)
+		}
+		if _, ok := db.Conferences[res.ConferenceID]; !ok {
+			return fmt.Errorf("conference %s not found", res.ConferenceID)
+		}
+		db.Bookings[e.BookingID] = &models.Booking{
+			ID:            e.BookingID,
+			UserID:        res.UserID,
+			ConferenceID:  res.ConferenceID,
+			TicketsBooked: res.TicketCount,
+			TotalAmount:   res.TotalAmount,
+			Status:        "confirmed",
+			BookedAt:      e.At,
+			Attendees:     attendeesOrEmpty(e.Attendees),
+			Tier:          res.Tier,
+			PaymentID:     res.PaymentID,
+			SeatIDs:       res.SeatIDs,
+		}
+		db.redeemPromoLocked(res)
+		db.retireReservationLocked(res, models.ReservationStatusConfirmed)
+
+	case OpReservationCancel, OpReservationExpire:
+		res, ok := db.Reservations[e.ReservationID]
+		if !ok {
+			return fmt.Errorf("reservation %s not found", e.ReservationID)
+		}
+		if e.Op == OpReservationExpire {
+			db.retireReservationLocked(res, models.ReservationStatusExpired)
+			db.expired[e.ReservationID] = res.ExpiresAt
+		} else {
+			db.retireReservationLocked(res, models.ReservationStatusCancelled)
+		}
+
+	case OpReservationExtend:
+		res, ok := db.Reservations[e.ReservationID]
+		if !ok {
+			return fmt.Errorf("reservation %s not found", e.ReservationID)
+		}
+		res.ExpiresAt = e.ExpiresAt
+		res.Extended = true
+
+	case OpReservationPayment:
+		res, ok := db.Reservations[e.ReservationID]
+		if !ok {
+			return fmt.Errorf("reservation %s not found", e.ReservationID)
+		}
+		res.PaymentID = e.PaymentID
+		if !e.ExpiresAt.IsZero() {
+			res.ExpiresAt, res.HoldSeconds = e.ExpiresAt, e.HoldSeconds
+		}
+
+	case OpReservationNames:
+		res, ok := db.Reservations[e.ReservationID]
+		if !ok {
+			return fmt.Errorf("reservation %s not found", e.ReservationID)
+		}
+		res.Attendees = e.Attendees
+
+	case OpOfferAccept:
+		res, ok := db.Reservations[e.ReservationID]
+		if !ok {
+			return fmt.Errorf("reservation %s not found", e.ReservationID)
+		}
+		res.Status = models.ReservationStatusActive
+		res.ExpiresAt = e.ExpiresAt
+		res.HoldSeconds = e.HoldSeconds
+
+	case OpReservationRenew:
+		res, ok := db.Reservations[e.ReservationID]
+		if !ok {
+			return fmt.Errorf("reservation %s not found", e.ReservationID)
+		}
+		res.ExpiresAt = e.ExpiresAt
+		res.ExtensionCount++
+
+	case OpQueueEnqueue:
+		q := db.WaitQueues[e.ConferenceID]
+		for _, entry := range q {
+			if entry.UserID == e.UserID {
+				entry.TicketCount, entry.Tier = e.TicketCount, e.Tier
+				if e.Priority > entry.Priority {
+					db.raisePriorityLocked(entry, e.Priority)
+				}
+				return nil
+			}
+		}
+		db.insertQueueEntryLocked(&WaitEntry{
+			ID:           e.EntryID,
+			UserID:       e.UserID,
+			ConferenceID: e.ConferenceID,
+			TicketCount:  e.TicketCount,
+			Tier:         e.Tier,
+			Priority:     e.Priority,
+			EnqueuedAt:   e.At,
+		})
+
+	case OpQueueDrop, OpQueueLeave:
+		if !db.removeQueueEntryLocked(e.ConferenceID, e.UserID) {
+			return fmt.Errorf("user %s is not queued for %s", e.UserID, e.ConferenceID)
+		}
+
+	case OpQueueRequeue:
+		if e.ReservationID != "" { // a lapsed offer, whose entry already left the queue
+			db.insertQueueEntryLocked(&WaitEntry{
+				ID:           e.EntryID,
+				UserID:       e.UserID,
+				ConferenceID: e.ConferenceID,
+				TicketCount:  e.TicketCount,
+				Tier:         e.Tier,
+				EnqueuedAt:   e.At,
+				MissedClaims: e.MissedClaims,
+				Priority:     e.Priority,
+			})
+			return nil
+		}
+		q := db.WaitQueues[e.ConferenceID]
+		if len(q) == 0 || q[0].UserID != e.UserID {
+			return fmt.Errorf("user %s is not at the head of the queue", e.UserID)
+		}
+		db.requeueLocked(q[0])
+
+	case OpQueueNotify:
+		q := db.WaitQueues[e.ConferenceID]
+		if len(q) == 0 || q[0].UserID != e.UserID {
+			return fmt.Errorf("user %s is not at the head of the queue", e.UserID)
+		}
+		q[0].FailedClaims = 0
+		q[0].ClaimableUntil = e.ExpiresAt
+
+	case OpHoldbackRelease:
+		conf, ok := db.Conferences[e.ConferenceID]
+		if !ok {
+			return fmt.Errorf("conference %s not found", e.ConferenceID)
+		}
+		conf.ReservedHoldback -= e.TicketCount
+
+	case OpConferenceCreate:
+		db.Conferences[e.ConferenceID] = &models.Conference{
+			ID:               e.ConferenceID,
+			Name:             e.Name,
+			Location:         e.Location,
+			TotalTickets:     e.TicketCount,
+			AvailableTickets: e.TicketCount,
+			Price:            e.Amount,
+			Date:             e.Date,
+			OrganizerID:      e.UserID,
+			Description:      e.Description,
+			Tags:             e.Tags,
+			Tiers:            append([]models.Tier(nil), e.Tiers...),
+			Sections:         append([]models.Section(nil), e.Sections...),
+			WaitingRoom:      copyWaitingRoom(e.WaitingRoom),
+		}
+
+	case OpConferenceUpdate:
+		conf, ok := db.Conferences[e.ConferenceID]
+		if !ok {
+			return fmt.Errorf("conference %s not found", e.ConferenceID)
+		}
+		applyConferenceUpdate(conf, e)
+
+	case OpConferenceDelete:
+		if _, ok := db.Conferences[e.ConferenceID]; !ok {
+			return fmt.Errorf("conference %s not found", e.ConferenceID)
+		}
+		delete(db.WaitQueues, e.ConferenceID)
+		delete(db.WaitingRooms, e.ConferenceID)
+		delete(db.turnover, e.ConferenceID)
+		delete(db.Conferences, e.ConferenceID)
+
+	case OpCartUpdate:
+		if e.Cart == nil {
+			return fmt.Errorf("cart state missing")
+		}
+		db.Carts[e.Cart.ID] = snapshotCart(e.Cart)
+
+	case OpTicketCheckIn:
+		if e.CheckIn == nil {
+			return fmt.Errorf("check-in missing")
+		}
+		if _, ok := db.Bookings[e.CheckIn.BookingID]; !ok {
+			return fmt.Errorf("booking %s not found", e.CheckIn.BookingID)
+		}
+		in := *e.CheckIn
+		db.CheckIns[in.TicketID] = &in
+
+	default:
+		return fmt.Errorf("unknown operation")
+	}
+	return nil
+}
+
+// attendeesOrEmpty keeps replayed bookings serialising attendees as [] rather than null
+func attendeesOrEmpty(names []string) []string {
+	if names == nil {
+		return []string{}
+	}
+	return names
+}
+
+// Drift compares this database with another (typically one rebuilt by ReplayAudit) and
+// describes every conference, booking, reservation or queue that differs
+func (db *Database) Drift(other *Database) []string {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	other.mutex.RLock()
+	defer other.mutex.RUnlock()
+
+	var drift []string
+	for id, conf := range db.Conferences {
+		o, ok := other.Conferences[id]
+		if !ok {
+			drift = append(drift, fmt.Sprintf("conference %s missing after replay", id))
+		} else if o.AvailableTickets != conf.AvailableTickets {
+			drift = append(drift, fmt.Sprintf("conference %s available %d, replayed %d", id, conf.AvailableTickets, o.AvailableTickets))
+		}
+	}
+	for id, b := range db.Bookings {
+		o, ok := other.Bookings[id]
+		if !ok {
+			drift = append(drift, fmt.Sprintf("booking %s missing after replay", id))
+		} else if o.TicketsBooked != b.TicketsBooked || o.TotalAmount != b.TotalAmount || o.Status != b.Status {
+			drift = append(drift, fmt.Sprintf("booking %s differs after replay", id))
+		}
+	}
+	for id := range other.Bookings {
+		if _, ok := db.Bookings[id]; !ok {
+			drift = append(drift, fmt.Sprintf("booking %s only exists after replay", id))
+		}
+	}
+	for id := range db.Reservations {
+		if _, ok := other.Reservations[id]; !ok {
+			drift = append(drift, fmt.Sprintf("reservation %s missing after replay", id))
+		}
+	}
+	for id := range other.Reservations {
+		if _, ok := db.Reservations[id]; !ok {
+			drift = append(drift, fmt.Sprintf("reservation %s only exists after replay", id))
+		}
+	}
+	for id, q := range db.WaitQueues {
+		if len(other.WaitQueues[id]) != len(q) {
+			drift = append(drift, fmt.Sprintf("queue %s length %d, replayed %d", id, len(q), len(other.WaitQueues[id])))
+		}
+	}
+	for id, cart := range db.Carts {
+		if o, ok := other.Carts[id]; !ok || o.Status != cart.Status || len(o.Items) != len(cart.Items) {
+			drift = append(drift, fmt.Sprintf("cart %s differs after replay", id))
+		}
+	}
+	for id := range db.CheckIns {
+		if _, ok := other.CheckIns[id]; !ok {
+			drift = append(drift, fmt.Sprintf("check-in of ticket %s missing after replay", id))
+		}
+	}
+	sort.Strings(drift)
+	return drift
+}
@@ -0,0 +1,62 @@
+package database
+
+import (
+	"errors"
+	"sync"
+
+	"booking-system/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrInvalidCredentials is returned by Authenticate for an unknown email or a wrong password;
+// the two are not told apart so logins can't be used to probe for accounts
+var ErrInvalidCredentials = errors.New("invalid email or password")
+
+// dummyHash is compared against when the account doesn't exist, so a failed login takes as
+// long either way
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+	return hash
+})
+
+// RegisterUser creates a user with role who can log in with password. The password is hashed
+// before the write lock is taken, since bcrypt is deliberately slow.
+func (db *Database) RegisterUser(name, email, password, role string) (*models.User, error) {
+	if err := checkRole(role); err != nil {
+		return nil, err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	db.lock()
+	defer db.unlock()
+	user, err := db.createUserLocked(name, email, role)
+	if err != nil {
+		return nil, err
+	}
+	db.credentials[user.ID] = string(hash)
+	return user, nil
+}
+
+// Authenticate returns the user registered with email if password matches. Users created
+// without a password (CreateUser, bulk import) can't log in.
+func (db *Database) Authenticate(email, password string) (*models.User, error) {
+	user, ok := db.GetUserByEmail(email)
+	var hash string
+	if ok {
+		db.mutex.RLock()
+		hash = db.credentials[user.ID]
+		db.mutex.RUnlock()
+	}
+	if hash == "" {
+		bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return user, nil
+}
@@ -0,0 +1,66 @@
+package database
+
+import (
+	"fmt"
+
+	"booking-system/models"
+)
+
+// ReservationItem is one conference's share of a reservation bundle
+type ReservationItem struct {
+	ConferenceID string `json:"conference_id" binding:"required"`
+	TicketCount  int    `json:"ticket_count" binding:"required,min=1"`
+	Tier         string `json:"tier"` // optional; empty uses the aggregate pool
+}
+
+// CreateReservationBundle holds seats at several conferences at once. Every item is checked
+// against the usual hold rules before any seat is held, so either all reservations are
+// created or none are. A bundle may name each conference only once. Items carry their own
+// tier; of opts only RequestID and ActorID apply, to every hold in the bundle.
+func (db *Database) CreateReservationBundle(userID string, items []ReservationItem, opts ReservationOptions) ([]*models.SeatReservation, error) {
+	if len(items) == 0 {
+		return nil, fmt.Errorf("bundle must contain at least one item")
+	}
+
+	db.lock()
+	defer db.unlock()
+	db.tagLocked(opts.RequestID, opts.ActorID)
+	reservations, err := db.createReservationBundleLocked(userID, items)
+	return snapshotReservations(reservations), err
+}
+
+// createReservationBundleLocked checks and holds every item, or none; caller must hold the
+// write lock
+func (db *Database) createReservationBundleLocked(userID string, items []ReservationItem) ([]*models.SeatReservation, error) {
+	db.cleanupExpiredReservationsLocked()
+
+	seen := make(map[string]bool, len(items))
+	confs := make([]*models.Conference, len(items))
+	tiers := make([]*models.Tier, len(items))
+	total := 0
+	for i, item := range items {
+		if item.TicketCount <= 0 {
+			return nil, fmt.Errorf("item %d: ticket count must be greater than 0", i+1)
+		}
+		if seen[item.ConferenceID] {
+			return nil, conflictf("item %d: conference %s appears more than once in the bundle", i+1, item.ConferenceID)
+		}
+		seen[item.ConferenceID] = true
+		conf, tier, err := db.checkReservationLocked(userID, item.ConferenceID, item.TicketCount, item.Tier)
+		if err != nil {
+			return nil, fmt.Errorf("item %d (%s): %w", i+1, item.ConferenceID, err)
+		}
+		confs[i], tiers[i] = conf, tier
+		total += item.TicketCount
+	}
+	// Items are checked one at a time, so the global cap has to see the bundle as a whole
+	if a := db.allowanceLocked(userID, ""); a.Global != nil && total > *a.Global {
+		return nil, fmt.Errorf("%w: you may book %d more tickets in total", ErrTicketLimit, *a.Global)
+	}
+
+	reservations := make([]*models.SeatReservation, len(items))
+	for i, item := range items {
+		reservations[i] = db.holdSeatsLocked(userID, confs[i], tiers[i], item.TicketCount, nil, nil)
+	}
+	return reservations, nil
+}
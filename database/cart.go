@@ -0,0 +1,358 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"booking-system/models"
+
+	"github.com/google/uuid"
+)
+
+// MaxCartItems is how many conferences one cart may hold tickets for
+const MaxCartItems = 10
+
+// ErrCartNotFound is returned for an unknown cart ID
+var ErrCartNotFound = fmt.Errorf("cart %w", ErrNotFound)
+
+// ErrCartNotOpen is returned when changing or checking out a cart that was already checked out
+var ErrCartNotOpen = conflictf("cart is no longer open")
+
+// ErrCartNotReserved is returned when confirming a cart that holds no seats
+var ErrCartNotReserved = conflictf("cart has not been checked out")
+
+// snapshotCart copies a cart so callers can't reach the database's copy
+func snapshotCart(cart *models.Cart) *models.Cart {
+	cp := *cart
+	cp.Items = append([]models.CartItem(nil), cart.Items...)
+	cp.ReservationIDs = append([]string(nil), cart.ReservationIDs...)
+	cp.BookingIDs = append([]string(nil), cart.BookingIDs...)
+	return &cp
+}
+
+// saveCartLocked stamps and records a changed cart; caller must hold write lock
+func (db *Database) saveCartLocked(cart *models.Cart) *models.Cart {
+	cart.UpdatedAt = db.now()
+	db.recordLocked(AuditEntry{Op: OpCartUpdate, At: cart.UpdatedAt, UserID: cart.UserID, Cart: snapshotCart(cart)})
+	return snapshotCart(cart)
+}
+
+// openCartLocked returns the cart if it can still be changed; caller must hold the lock
+func (db *Database) openCartLocked(cartID string) (*models.Cart, error) {
+	cart, ok := db.Carts[cartID]
+	if !ok {
+		return nil, ErrCartNotFound
+	}
+	if cart.Status != models.CartStatusOpen {
+		return nil, ErrCartNotOpen
+	}
+	return cart, nil
+}
+
+// CreateCart starts an empty cart for a user
+func (db *Database) CreateCart(userID string) (*models.Cart, error) {
+	db.lock()
+	defer db.unlock()
+
+	if _, ok := db.Users[userID]; !ok {
+		return nil, ErrUserNotFound
+	}
+	now := db.now()
+	cart := &models.Cart{
+		ID:        "cart-" + uuid.New().String(),
+		UserID:    userID,
+		Items:     []models.CartItem{},
+		Status:    models.CartStatusOpen,
+		CreatedAt: now,
+	}
+	db.Carts[cart.ID] = cart
+	return db.saveCartLocked(cart), nil
+}
+
+// GetCart returns a cart
+func (db *Database) GetCart(cartID string) (*models.Cart, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	cart, ok := db.Carts[cartID]
+	if !ok {
+		return nil, ErrCartNotFound
+	}
+	return snapshotCart(cart), nil
+}
+
+// SetCartItem puts item in an open cart, replacing the line for the same conference if there
+// is one. Nothing is held until checkout; the conference and tier only have to exist.
+func (db *Database) SetCartItem(cartID string, item ReservationItem) (*models.Cart, error) {
+	if item.TicketCount <= 0 {
+		return nil, fmt.Errorf("ticket count must be greater than 0")
+	}
+	db.lock()
+	defer db.unlock()
+
+	cart, err := db.openCartLocked(cartID)
+	if err != nil {
+		return nil, err
+	}
+	conf, ok := db.Conferences[item.ConferenceID]
+	if !ok {
+		return nil, ErrConferenceNotFound
+	}
+	tier, _, err := pickTier(conf, item.Tier)
+	if err != nil {
+		return nil, err
+	}
+	line := models.CartItem{ConferenceID: conf.ID, TicketCount: item.TicketCount}
+	if tier != nil {
+		line.Tier = tier.Name
+	}
+	for i, existing := range cart.Items {
+		if existing.ConferenceID == line.ConferenceID {
+			cart.Items[i] = line
+			return db.saveCartLocked(cart), nil
+		}
+	}
+	if len(cart.Items) >= MaxCartItems {
+		return nil, fmt.Errorf("a cart holds tickets for at most %d conferences", MaxCartItems)
+	}
+	cart.Items = append(cart.Items, line)
+	return db.saveCartLocked(cart), nil
+}
+
+// RemoveCartItem drops a conference's line from an open cart
+func (db *Database) RemoveCartItem(cartID, conferenceID string) (*models.Cart, error) {
+	db.lock()
+	defer db.unlock()
+
+	cart, err := db.openCartLocked(cartID)
+	if err != nil {
+		return nil, err
+	}
+	for i, item := range cart.Items {
+		if item.ConferenceID == conferenceID {
+			cart.Items = append(cart.Items[:i:i], cart.Items[i+1:]...)
+			return db.saveCartLocked(cart), nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s is not in the cart", ErrNotFound, conferenceID)
+}
+
+// CheckoutCart holds every item of an open cart at once, like CreateReservationBundle: either
+// all the holds are made or none are and the cart stays open. The cart then waits for
+// ConfirmCart (or the provider's payment) until its first hold lapses.
+func (db *Database) CheckoutCart(cartID string) (*models.Cart, []*models.SeatReservation, error) {
+	db.lock()
+	defer db.unlock()
+
+	cart, err := db.openCartLocked(cartID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(cart.Items) == 0 {
+		return nil, nil, fmt.Errorf("cart is empty")
+	}
+	items := make([]ReservationItem, len(cart.Items))
+	for i, item := range cart.Items {
+		items[i] = ReservationItem{ConferenceID: item.ConferenceID, TicketCount: item.TicketCount, Tier: item.Tier}
+	}
+	reservations, err := db.createReservationBundleLocked(cart.UserID, items)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cart.Status = models.CartStatusReserved
+	cart.ReservationIDs, cart.TotalAmount = nil, 0
+	for i, r := range reservations {
+		cart.ReservationIDs = append(cart.ReservationIDs, r.ID)
+		cart.TotalAmount += r.TotalAmount
+		if i == 0 || r.ExpiresAt.Before(cart.ExpiresAt) {
+			cart.ExpiresAt = r.ExpiresAt
+		}
+	}
+	return db.saveCartLocked(cart), snapshotReservations(reservations), nil
+}
+
+// ConfirmCart books every hold of a checked-out cart after charging their total through
+// Payments in one go. Every hold is checked first: if any has lapsed or its seats are gone,
+// nothing is booked, every hold in the cart is released and the cart is open again for
+// another checkout. A declined payment leaves the holds in place for a retry.
+func (db *Database) ConfirmCart(cartID string, opts BookingOptions) (*models.Cart, []*models.Booking, error) {
+	db.lock()
+	defer db.unlock()
+	db.tagLocked(opts.RequestID, opts.ActorID)
+
+	cart, ok := db.Carts[cartID]
+	if !ok {
+		return nil, nil, ErrCartNotFound
+	}
+	if cart.Status != models.CartStatusReserved {
+		return nil, nil, ErrCartNotReserved
+	}
+	bookings, err := db.confirmCartLocked(cart, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	return snapshotCart(cart), snapshotBookings(bookings), nil
+}
+
+// confirmCartLocked books a reserved cart's holds, all or none, charging the total first when
+// charge is set; caller must hold write lock
+func (db *Database) confirmCartLocked(cart *models.Cart, charge bool) ([]*models.Booking, error) {
+	now := db.now()
+	reservations := make([]*models.SeatReservation, len(cart.ReservationIDs))
+	for i, id := range cart.ReservationIDs {
+		r, ok := db.Reservations[id]
+		var err error
+		switch {
+		case !ok:
+			err = db.missingReservationErrLocked(id)
+		case now.After(r.ExpiresAt):
+			err = &ReservationExpiredError{ExpiredAt: r.ExpiresAt}
+		default:
+			if conf, ok := db.Conferences[r.ConferenceID]; !ok {
+				err = ErrConferenceNotFound
+			} else {
+				err = db.checkSeatsLeftLocked(conf, r)
+			}
+		}
+		if err != nil {
+			db.rollbackCartLocked(cart)
+			return nil, fmt.Errorf("item %d (%s): %w; every hold in the cart was released, check out again", i+1, cart.Items[i].ConferenceID, err)
+		}
+		reservations[i] = r
+	}
+	if charge {
+		if err := db.chargeLocked(cart.TotalAmount, cart.UserID); err != nil {
+			return nil, err
+		}
+	}
+
+	// Every hold passed the checks above under this lock, so none of these can fail
+	bookings := make([]*models.Booking, len(reservations))
+	cart.BookingIDs = nil
+	for i, r := range reservations {
+		booking, err := db.confirmLocked(r, BookingOptions{}, false)
+		if err != nil {
+			return nil, err
+		}
+		bookings[i] = booking
+		cart.BookingIDs = append(cart.BookingIDs, booking.ID)
+	}
+	cart.Status = models.CartStatusCompleted
+	db.saveCartLocked(cart)
+	return bookings, nil
+}
+
+// rollbackCartLocked releases whatever holds a checked-out cart still has and opens it again;
+// caller must hold write lock
+func (db *Database) rollbackCartLocked(cart *models.Cart) {
+	db.releaseCartHoldsLocked(cart)
+	cart.Status = models.CartStatusOpen
+	cart.ReservationIDs, cart.TotalAmount, cart.ExpiresAt = nil, 0, time.Time{}
+	cart.PaymentID, cart.PaymentClientSecret = "", ""
+	db.saveCartLocked(cart)
+}
+
+// releaseCartHoldsLocked cancels the cart's holds that are still active, expiring the ones
+// that have lapsed; caller must hold write lock
+func (db *Database) releaseCartHoldsLocked(cart *models.Cart) {
+	now := db.now()
+	for _, id := range cart.ReservationIDs {
+		r, ok := db.Reservations[id]
+		if !ok {
+			continue
+		}
+		if now.After(r.ExpiresAt) {
+			db.expireReservationLocked(r, now)
+		} else {
+			db.retireReservationLocked(r, models.ReservationStatusCancelled)
+			db.recordLocked(AuditEntry{Op: OpReservationCancel, ReservationID: id, UserID: r.UserID, ConferenceID: r.ConferenceID})
+		}
+		db.promoteHeadLocked(r.ConferenceID, now)
+	}
+}
+
+// CancelCart abandons a cart, releasing its holds if it was checked out. A completed cart's
+// bookings are cancelled one by one with CancelBooking instead.
+func (db *Database) CancelCart(cartID string) (*models.Cart, error) {
+	db.lock()
+	defer db.unlock()
+
+	cart, ok := db.Carts[cartID]
+	if !ok {
+		return nil, ErrCartNotFound
+	}
+	switch cart.Status {
+	case models.CartStatusCancelled:
+		return snapshotCart(cart), nil
+	case models.CartStatusCompleted:
+		return nil, conflictf("cart is already booked; cancel its bookings instead")
+	}
+	db.releaseCartHoldsLocked(cart)
+	cart.Status = models.CartStatusCancelled
+	return db.saveCartLocked(cart), nil
+}
+
+// AttachCartPayment records the provider payment intent a checked-out cart is paid through.
+// Every hold in the cart carries it too, so none of them can be paid for on its own, and
+// CompletePayment books them together. Attaching the same intent again is a no-op.
+func (db *Database) AttachCartPayment(cartID, paymentID, clientSecret string) (*models.Cart, error) {
+	db.lock()
+	defer db.unlock()
+
+	cart, ok := db.Carts[cartID]
+	if !ok {
+		return nil, ErrCartNotFound
+	}
+	if cart.Status != models.CartStatusReserved {
+		return nil, ErrCartNotReserved
+	}
+	if cart.PaymentID == paymentID {
+		return snapshotCart(cart), nil
+	}
+	if cart.PaymentID != "" {
+		return nil, ErrPaymentMismatch
+	}
+	for _, id := range cart.ReservationIDs {
+		r, ok := db.Reservations[id]
+		if !ok {
+			continue // confirming rolls the cart back
+		}
+		if r.PaymentID != "" && !strings.EqualFold(r.PaymentID, paymentID) {
+			return nil, ErrPaymentMismatch
+		}
+		db.attachPaymentLocked(r, paymentID, clientSecret)
+		db.holdForPaymentLocked(r)
+	}
+	// the holds now last for the payment; the cart lapses with the first of them
+	for i, id := range cart.ReservationIDs {
+		if r, ok := db.Reservations[id]; ok && (i == 0 || r.ExpiresAt.Before(cart.ExpiresAt)) {
+			cart.ExpiresAt = r.ExpiresAt
+		}
+	}
+	cart.PaymentID, cart.PaymentClientSecret = paymentID, clientSecret
+	return db.saveCartLocked(cart), nil
+}
+
+// completeCartPaymentLocked books the cart paid through paymentID, if there is one; found is
+// false when no cart uses it. Caller must hold write lock.
+func (db *Database) completeCartPaymentLocked(paymentID string) (booking *models.Booking, found bool, err error) {
+	for _, cart := range db.Carts {
+		if cart.PaymentID != paymentID {
+			continue
+		}
+		if cart.Status == models.CartStatusCompleted && len(cart.BookingIDs) > 0 {
+			return db.Bookings[cart.BookingIDs[0]], true, nil
+		}
+		if cart.Status != models.CartStatusReserved {
+			return nil, true, ErrCartNotReserved
+		}
+		bookings, err := db.confirmCartLocked(cart, false)
+		if err != nil {
+			return nil, true, err
+		}
+		return bookings[0], true, nil
+	}
+	return nil, false, nil
+}
@@ -0,0 +1,134 @@
+package database
+
+import (
+	"sort"
+	"time"
+
+	"booking-system/models"
+)
+
+// RecentCheckIns is how many of the latest check-ins CheckInStats lists
+const RecentCheckIns = 20
+
+var (
+	// ErrAlreadyCheckedIn is returned when a ticket that was already admitted is scanned again
+	ErrAlreadyCheckedIn = conflictf("ticket is already checked in")
+	// ErrWrongConference is returned when a ticket is scanned at another conference's door
+	ErrWrongConference = conflictf("ticket is for another conference")
+)
+
+// CheckInOptions carries the optional inputs of CheckInTicket
+type CheckInOptions struct {
+	ConferenceID string // when set, tickets for other conferences are turned away
+	StaffID      string // who scanned the ticket
+	RequestID    string // API request checking in, recorded in the audit log
+}
+
+// CheckInCount is how many of a group's tickets were issued and how many were admitted
+type CheckInCount struct {
+	Tickets   int `json:"tickets"`
+	CheckedIn int `json:"checked_in"`
+}
+
+// CheckInStats is the live attendance of a conference. Only tickets of confirmed bookings
+// count; a check-in whose booking was cancelled since is left out.
+type CheckInStats struct {
+	ConferenceID  string                  `json:"conference_id"`
+	Tickets       int                     `json:"tickets"`
+	CheckedIn     int                     `json:"checked_in"`
+	NotArrived    int                     `json:"not_arrived"`
+	ByTier        map[string]CheckInCount `json:"by_tier,omitempty"` // tiered tickets only
+	LastCheckInAt *time.Time              `json:"last_check_in_at,omitempty"`
+	Recent        []models.CheckIn        `json:"recent"` // latest first, at most RecentCheckIns
+}
+
+// CheckInTicket admits ticket ticketID. Each ticket gets in once: scanning it again returns the
+// ticket, showing when it was admitted, with ErrAlreadyCheckedIn. Tickets of cancelled bookings
+// get ErrTicketVoid.
+func (db *Database) CheckInTicket(ticketID string, opts CheckInOptions) (*models.Ticket, error) {
+	db.lock()
+	defer db.unlock()
+	db.tagLocked(opts.RequestID, opts.StaffID)
+
+	ticket, err := db.ticketLocked(ticketID)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case opts.ConferenceID != "" && ticket.ConferenceID != opts.ConferenceID:
+		return nil, ErrWrongConference
+	case ticket.Status == models.TicketStatusVoid:
+		return nil, ErrTicketVoid
+	case ticket.Status == models.TicketStatusCheckedIn:
+		return ticket, ErrAlreadyCheckedIn
+	}
+
+	in := &models.CheckIn{
+		TicketID:     ticket.ID,
+		BookingID:    ticket.BookingID,
+		ConferenceID: ticket.ConferenceID,
+		UserID:       ticket.UserID,
+		StaffID:      opts.StaffID,
+		CheckedInAt:  db.now(),
+	}
+	db.CheckIns[in.TicketID] = in
+	cp := *in
+	db.recordLocked(AuditEntry{
+		Op: OpTicketCheckIn, At: in.CheckedInAt, UserID: in.UserID, ConferenceID: in.ConferenceID,
+		BookingID: in.BookingID, TicketCount: 1, CheckIn: &cp,
+	})
+
+	at := in.CheckedInAt
+	ticket.CheckedInAt = &at
+	ticket.Status = models.TicketStatusCheckedIn
+	return ticket, nil
+}
+
+// CheckInStats counts a conference's tickets and how many have been admitted so far
+func (db *Database) CheckInStats(conferenceID string) (*CheckInStats, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	if _, ok := db.Conferences[conferenceID]; !ok {
+		return nil, ErrConferenceNotFound
+	}
+	stats := &CheckInStats{ConferenceID: conferenceID, ByTier: make(map[string]CheckInCount), Recent: []models.CheckIn{}}
+	for _, b := range db.Bookings {
+		if b.ConferenceID != conferenceID || b.Status != "confirmed" {
+			continue
+		}
+		for _, t := range db.ticketsForLocked(b) {
+			stats.Tickets++
+			tier := stats.ByTier[t.Tier]
+			tier.Tickets++
+			if t.Status == models.TicketStatusCheckedIn {
+				stats.CheckedIn++
+				tier.CheckedIn++
+				stats.Recent = append(stats.Recent, *db.CheckIns[t.ID])
+			}
+			if t.Tier != "" {
+				stats.ByTier[t.Tier] = tier
+			}
+		}
+	}
+	stats.NotArrived = stats.Tickets - stats.CheckedIn
+	if len(stats.ByTier) == 0 {
+		stats.ByTier = nil
+	}
+
+	sort.Slice(stats.Recent, func(i, j int) bool {
+		a, b := stats.Recent[i], stats.Recent[j]
+		if !a.CheckedInAt.Equal(b.CheckedInAt) {
+			return a.CheckedInAt.After(b.CheckedInAt)
+		}
+		return a.TicketID < b.TicketID
+	})
+	if len(stats.Recent) > 0 {
+		last := stats.Recent[0].CheckedInAt
+		stats.LastCheckInAt = &last
+	}
+	if len(stats.Recent) > RecentCheckIns {
+		stats.Recent = stats.Recent[:RecentCheckIns]
+	}
+	return stats, nil
+}
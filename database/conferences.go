@@ -0,0 +1,259 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"booking-system/models"
+
+	"github.com/google/uuid"
+)
+
+var ErrCapacityBelowSold = conflictf("capacity cannot drop below tickets already sold")
+
+// ErrConferencePast is returned when booking, holding or queueing for a conference whose date has passed
+var ErrConferencePast = errors.New("conference has already taken place")
+
+// checkUpcoming rejects conferences whose date is before now; an unset date never expires
+func checkUpcoming(conf *models.Conference, now time.Time) error {
+	if !conf.Date.IsZero() && now.After(conf.Date) {
+		return ErrConferencePast
+	}
+	return nil
+}
+
+// ConferenceOptions carries the optional parts of a new conference
+type ConferenceOptions struct {
+	Description string
+	Tags        []string            // normalized with normalizeTags
+	Tiers       []models.Tier       // named ticket types; checked with normalizeTiers
+	Sections    []models.Section    // seat map for assigned seating; checked with normalizeSections
+	WaitingRoom *models.WaitingRoom // sell only to visitors let in through a waiting room
+}
+
+// normalizeTags lower-cases and trims tags, dropping blanks and repeats
+func normalizeTags(tags []string) []string {
+	var out []string
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			out = append(out, tag)
+		}
+	}
+	return out
+}
+
+// CreateConference adds a conference at runtime with every ticket available. organizerID is
+// the user who may manage it, empty when an admin creates it with the admin token.
+func (db *Database) CreateConference(name, location string, totalTickets int, price float64, date time.Time, organizerID string, opts ConferenceOptions) (*models.Conference, error) {
+	name, location = strings.TrimSpace(name), strings.TrimSpace(location)
+	description, tags := strings.TrimSpace(opts.Description), normalizeTags(opts.Tags)
+	switch {
+	case name == "":
+		return nil, fmt.Errorf("conference name is required")
+	case totalTickets <= 0:
+		return nil, fmt.Errorf("total tickets must be greater than 0")
+	case price < 0:
+		return nil, fmt.Errorf("price cannot be negative")
+	case date.IsZero():
+		return nil, fmt.Errorf("conference date is required")
+	}
+	tiers, err := normalizeTiers(opts.Tiers, totalTickets)
+	if err != nil {
+		return nil, err
+	}
+	sections, err := normalizeSections(opts.Sections, totalTickets)
+	if err != nil {
+		return nil, err
+	}
+	room, err := normalizeWaitingRoom(opts.WaitingRoom)
+	if err != nil {
+		return nil, err
+	}
+
+	db.lock()
+	defer db.unlock()
+	if date.Before(db.now()) {
+		return nil, fmt.Errorf("conference date must be in the future")
+	}
+
+	conf := &models.Conference{
+		ID:               "conf-" + uuid.New().String(),
+		Name:             name,
+		Location:         location,
+		TotalTickets:     totalTickets,
+		AvailableTickets: totalTickets,
+		Price:            price,
+		Date:             date,
+		OrganizerID:      organizerID,
+		Description:      description,
+		Tags:             tags,
+		Tiers:            tiers,
+		Sections:         sections,
+		WaitingRoom:      room,
+	}
+	db.Conferences[conf.ID] = conf
+	db.recordLocked(AuditEntry{
+		Op: OpConferenceCreate, ConferenceID: conf.ID, Name: name, Location: location,
+		TicketCount: totalTickets, Amount: price, Date: date, UserID: organizerID,
+		Description: description, Tags: tags, Tiers: append([]models.Tier(nil), tiers...),
+		Sections: append([]models.Section(nil), sections...), WaitingRoom: copyWaitingRoom(room),
+	})
+	return snapshotConference(conf), nil
+}
+
+// ConferenceUpdate lists the adjustable conference fields; nil leaves a field unchanged
+type ConferenceUpdate struct {
+	Name         *string
+	Location     *string
+	Date         *time.Time
+	Price        *float64
+	TotalTickets *int
+	HoldSeconds  *int // zero goes back to the server default
+	Description  *string
+	Tags         *[]string // replaces every tag; an empty list clears them
+	// Replaces the waiting room settings; AdmitPerMinute zero turns the room off
+	WaitingRoom *models.WaitingRoom
+	// Reject with ErrVersionConflict unless the conference is at this version, so a capacity
+	// change isn't based on a sold count that has moved since it was read
+	ExpectedVersion *int
+}
+
+// UpdateConference changes a conference's details, price, capacity and/or hold time. A new
+// date must be in the future; a new hold time applies to reservations made after the change. Capacity may shrink only as far as the tickets already sold plus the
+// held-back seats; availability moves with it.
+func (db *Database) UpdateConference(conferenceID string, update ConferenceUpdate) (*models.Conference, error) {
+	db.lock()
+	defer db.unlock()
+
+	conf, exists := db.Conferences[conferenceID]
+	if !exists {
+		return nil, ErrConferenceNotFound
+	}
+	if err := checkVersion(conf, update.ExpectedVersion); err != nil {
+		return nil, err
+	}
+
+	entry := AuditEntry{
+		Op: OpConferenceUpdate, ConferenceID: conferenceID, Name: conf.Name, Location: conf.Location, Date: conf.Date,
+		HoldSeconds: conf.HoldSeconds, Description: conf.Description, Tags: conf.Tags, WaitingRoom: copyWaitingRoom(conf.WaitingRoom),
+	}
+	if update.Name != nil {
+		if entry.Name = strings.TrimSpace(*update.Name); entry.Name == "" {
+			return nil, fmt.Errorf("conference name is required")
+		}
+	}
+	if update.Location != nil {
+		entry.Location = strings.TrimSpace(*update.Location)
+	}
+	if update.Date != nil {
+		if update.Date.IsZero() || update.Date.Before(db.now()) {
+			return nil, fmt.Errorf("conference date must be in the future")
+		}
+		entry.Date = *update.Date
+	}
+	if update.Description != nil {
+		entry.Description = strings.TrimSpace(*update.Description)
+	}
+	if update.Tags != nil {
+		entry.Tags = normalizeTags(*update.Tags)
+	}
+	if update.WaitingRoom != nil {
+		room, err := normalizeWaitingRoom(update.WaitingRoom)
+		if err != nil {
+			return nil, err
+		}
+		entry.WaitingRoom = room
+	}
+	if update.HoldSeconds != nil {
+		if *update.HoldSeconds < 0 {
+			return nil, fmt.Errorf("hold time cannot be negative")
+		}
+		entry.HoldSeconds = *update.HoldSeconds
+	}
+	price, total := conf.Price, conf.TotalTickets
+	if update.Price != nil {
+		if *update.Price < 0 {
+			return nil, fmt.Errorf("price cannot be negative")
+		}
+		price = *update.Price
+	}
+	if update.TotalTickets != nil && *update.TotalTickets != conf.TotalTickets && len(conf.Sections) > 0 {
+		return nil, fmt.Errorf("capacity of a conference with a seat map is its %d seats", conf.TotalTickets)
+	}
+	if update.TotalTickets != nil {
+		total = *update.TotalTickets
+		sold := conf.TotalTickets - db.availableLocked(conf)
+		if total < sold+conf.ReservedHoldback {
+			return nil, fmt.Errorf("%w: %d sold and %d held back", ErrCapacityBelowSold, sold, conf.ReservedHoldback)
+		}
+	}
+
+	entry.TicketCount, entry.Amount = total, price
+	applyConferenceUpdate(conf, entry)
+	db.recordLocked(entry)
+	db.promoteHeadLocked(conferenceID, db.now())
+	return snapshotConference(conf), nil
+}
+
+// applyConferenceUpdate sets the details, price, capacity and hold time recorded in e; the
+// inventory stream shifts availability by the capacity change. Entries from before details
+// were editable carry no name, so their details are left alone.
+func applyConferenceUpdate(conf *models.Conference, e AuditEntry) {
+	conf.TotalTickets = e.TicketCount
+	conf.Price = e.Amount
+	conf.HoldSeconds = e.HoldSeconds
+	if e.Name != "" {
+		conf.Name, conf.Location, conf.Date = e.Name, e.Location, e.Date
+		conf.Description, conf.Tags = e.Description, append([]string(nil), e.Tags...)
+		conf.WaitingRoom = copyWaitingRoom(e.WaitingRoom)
+	}
+}
+
+// DeleteConference removes a conference. One with confirmed bookings can't be deleted (cancel
+// them first so the tickets are refunded); its active holds are cancelled and its wait queue
+// is dropped.
+func (db *Database) DeleteConference(conferenceID string) error {
+	db.lock()
+	defer db.unlock()
+
+	if _, exists := db.Conferences[conferenceID]; !exists {
+		return ErrConferenceNotFound
+	}
+	confirmed := 0
+	for _, b := range db.Bookings {
+		if b.ConferenceID == conferenceID && b.Status == "confirmed" {
+			confirmed++
+		}
+	}
+	if confirmed > 0 {
+		return conflictf("conference has %d confirmed bookings; cancel them before deleting it", confirmed)
+	}
+
+	var holds []*models.SeatReservation
+	for _, r := range db.Reservations {
+		if r.ConferenceID == conferenceID {
+			holds = append(holds, r)
+		}
+	}
+	sort.Slice(holds, func(i, j int) bool { return holds[i].ID < holds[j].ID })
+	for _, r := range holds {
+		db.retireReservationLocked(r, models.ReservationStatusCancelled)
+		db.recordLocked(AuditEntry{Op: OpReservationCancel, ReservationID: r.ID, UserID: r.UserID, ConferenceID: conferenceID})
+	}
+	for _, e := range db.WaitQueues[conferenceID] {
+		db.recordLocked(AuditEntry{Op: OpQueueDrop, UserID: e.UserID, ConferenceID: conferenceID, EntryID: e.ID})
+	}
+
+	delete(db.WaitQueues, conferenceID)
+	delete(db.WaitingRooms, conferenceID)
+	delete(db.turnover, conferenceID)
+	delete(db.Conferences, conferenceID)
+	db.recordLocked(AuditEntry{Op: OpConferenceDelete, ConferenceID: conferenceID})
+	return nil
+}
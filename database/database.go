@@ -1,8 +1,10 @@
 package database
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -13,6 +15,121 @@ import (
 	"github.com/google/uuid"
 )
 
+// ReservationHold is how long a reservation holds seats before it expires unless HoldTime or
+// the conference's HoldSeconds says otherwise
+const ReservationHold = 15 * time.Second
+
+// JanitorInterval is how often the background janitor purges expired reservations unless
+// changed with SetJanitorInterval
+const JanitorInterval = time.Second
+
+// AutoExtendWindow is how close to expiry a reservation must be to get its one-time extension
+const AutoExtendWindow = 3 * time.Second
+
+// ExpiredTombstoneTTL is how long an expired reservation ID is remembered so lookups can
+// report "expired" rather than "not found"
+const ExpiredTombstoneTTL = 5 * time.Minute
+
+// DefaultMaxExtensions is how many times a hold may be extended unless configured otherwise
+const DefaultMaxExtensions = 2
+
+// DefaultMaxHoldTime caps how long a hold can last in total, counting extensions, unless
+// configured otherwise; see Database.MaxHoldTime
+const DefaultMaxHoldTime = 2 * time.Minute
+
+// DefaultPaymentHoldTime is how long a hold lasts once checkout starts its provider payment
+// unless configured otherwise; see Database.PaymentHoldTime
+const DefaultPaymentHoldTime = 15 * time.Minute
+
+// DefaultMaxAuditEntries is how many entries the audit log keeps unless configured otherwise;
+// see Database.MaxAuditEntries
+const DefaultMaxAuditEntries = 100000
+
+// Default wait-queue caps; see Database.QueueCapFactor and Database.MaxQueueLength
+const (
+	DefaultQueueCapFactor = 2
+	DefaultMaxQueueLength = 10000
+)
+
+// DefaultClaimWindow is how long a queue head has to claim once it is told seats were freed
+// unless configured otherwise; see Database.ClaimWindow
+const DefaultClaimWindow = 30 * time.Second
+
+// MaxQueuePriority is the highest priority class a wait queue entry may have; see WaitEntry.Priority
+const MaxQueuePriority = 9
+
+// DefaultOfferWindow is how long an automatic queue offer stays open unless configured otherwise;
+// see Database.OfferWindow
+const DefaultOfferWindow = 30 * time.Second
+
+// Reservation lookup errors
+var (
+	ErrReservationNotFound = fmt.Errorf("reservation %w", ErrNotFound)
+	ErrReservationExpired  = errors.New("reservation has expired")
+)
+
+// ReservationExpiredError wraps ErrReservationExpired with the time the hold lapsed
+type ReservationExpiredError struct {
+	ExpiredAt time.Time
+}
+
+func (e *ReservationExpiredError) Error() string { return ErrReservationExpired.Error() }
+
+func (e *ReservationExpiredError) Unwrap() error { return ErrReservationExpired }
+
+// ErrNotYourTurn is returned when someone other than the head of the queue tries to claim
+var ErrNotYourTurn = conflictf("not your turn yet")
+
+// ErrDroppedFromQueue is returned when a queue head is removed after too many failed claims
+var ErrDroppedFromQueue = errors.New("removed from the queue")
+
+// ErrAlreadyHasSeats is returned when a user with a confirmed booking or active reservation
+// for a conference tries to join its wait queue
+var ErrAlreadyHasSeats = conflictf("you already have seats for this conference")
+
+// ErrQueueFull is returned when a conference's wait queue has reached its cap
+var ErrQueueFull = conflictf("waitlist closed: the queue is full")
+
+// ErrTooManyHeld is returned when a new hold would push a conference past its held-ticket cap
+var ErrTooManyHeld = errors.New("too many tickets currently held in reservations")
+
+// ErrExtensionsExhausted is returned when a reservation has used all MaxExtensions
+var ErrExtensionsExhausted = conflictf("reservation has no extensions left")
+
+// ErrMaxHoldReached is returned when extending a reservation that already holds its seats
+// until MaxHoldTime after it was made
+var ErrMaxHoldReached = conflictf("reservation has reached its maximum hold time")
+
+// ErrAlreadyExtended is returned when a reservation has used its one automatic extension
+var ErrAlreadyExtended = conflictf("reservation has already been extended")
+
+// ErrNotInExtendWindow is returned when asking for the automatic extension before the last
+// AutoExtendWindow of a hold
+var ErrNotInExtendWindow = conflictf("reservation can only be extended in its last %d seconds", int(AutoExtendWindow/time.Second))
+
+// ErrHoldOversold is returned when extending a reservation whose seats the conference can no
+// longer cover, e.g. after its capacity was cut
+var ErrHoldOversold = conflictf("not enough tickets left to keep holding these seats")
+
+// ErrInvalidAttendees is returned when attendee names don't line up with the tickets booked
+var ErrInvalidAttendees = errors.New("invalid attendee names")
+
+// HeldCapError reports a held-cap rejection together with a hint for when to retry
+type HeldCapError struct {
+	RetryAfter time.Duration // time until the soonest active hold on the conference lapses
+}
+
+func (e *HeldCapError) Error() string {
+	return fmt.Sprintf("%s, retry in %ds", ErrTooManyHeld, e.RetryAfterSeconds())
+}
+
+// RetryAfterSeconds rounds the retry hint up to whole seconds
+func (e *HeldCapError) RetryAfterSeconds() int {
+	return int((e.RetryAfter + time.Second - 1) / time.Second)
+}
+
+func (e *HeldCapError) Unwrap() error { return ErrTooManyHeld }
+
 // Database represents an in-memory database for the booking system
 type Database struct {
 	Users         map[string]*models.User
@@ -20,98 +137,245 @@ type Database struct {
 	Bookings      map[string]*models.Booking
 	Reservations  map[string]*models.SeatReservation
 	WaitQueues    map[string][]*WaitEntry // per-conference wait queues
+	WaitingRooms  map[string]*WaitingRoomLine // conference ID -> visitors in its waiting room
+	PromoCodes    map[string]*models.PromoCode // upper-cased code -> discount
+	Carts         map[string]*models.Cart
+	CheckIns      map[string]*models.CheckIn // ticket ID -> when it was admitted
+	Audit         []AuditEntry     // append-only log of state changes
 	StartTime     time.Time        // Track when the database was initialized
+	now           func() time.Time // clock for holds, expiry and timestamps; see SetClock
+	fixtures      *Fixtures        // demo data restored on reset instead of the built-in samples
+	expired       map[string]time.Time // recently expired reservation ID -> ExpiresAt
+	history       map[string][]*models.SeatReservation // user ID -> finished reservations, oldest first
+	turnover      map[string]float64   // conference ID -> moving average seconds a held seat takes to confirm or expire
+	idempotency   map[string]idempotentRequest // user-scoped Idempotency-Key -> booking or reservation it created
+	credentials   map[string]string    // user ID -> bcrypt password hash; see RegisterUser
+	done          chan struct{}        // closed by Close to stop background goroutines
+	janitorEvery  chan time.Duration   // new intervals for the running janitor; see SetJanitorInterval
+	closeOnce     sync.Once
+	events        eventHub             // live event subscribers
+	pendingEvents []Event              // published by unlock once the write lock is released
+	requestID     string               // request the current write is for; see tagLocked
+	actor         string               // who asked for the current write; see tagLocked
+	ledger        inventoryLedger      // the inventory stream availability is read from
+	counters      counters             // lifetime totals for /metrics
+	writes        uint64               // bumped by every recorded change, reset and restore
+	epoch         uint64               // bumped when the state is replaced wholesale; see ChangesSince
+	sharedExpiry  bool                 // lapsed holds wait for SweepConference; see SetSharedExpiry
+
+	// Per-user ticket caps counting confirmed bookings plus active reservations; zero disables
+	MaxTicketsPerUser       int // per conference
+	MaxTicketsPerUserGlobal int // across all conferences
+
+	// Failed claims after which the queue head is dropped so it can't starve the line; zero disables
+	MaxFailedClaims int
+
+	// How long a reservation holds seats for conferences without their own HoldSeconds; zero
+	// falls back to ReservationHold
+	HoldTime time.Duration
+
+	// Times ExtendReservation may push a hold back by another hold period; zero disables extensions
+	MaxExtensions int
+
+	// Longest a reservation may hold seats counting extensions, measured from when it was made;
+	// an extension that would go past it is cut short. Zero disables the cap.
+	MaxHoldTime time.Duration
+
+	// How long a hold lasts from when checkout starts its provider payment, so a payment that
+	// finishes after the normal hold time still books the seats. It only ever lengthens a hold
+	// and stops at MaxHoldTime; zero falls back to DefaultPaymentHoldTime.
+	PaymentHoldTime time.Duration
+
+	// When seats free up, the queue head is handed them as an offer (a reservation in the offered
+	// state) that lapses after OfferWindow, and the next entry gets the next offer. Zero instead
+	// notifies the head, which has ClaimWindow to call ClaimNext.
+	OfferWindow time.Duration
+
+	// A notified head that lets ClaimWindow pass, or a user who lets an offer lapse, goes to the
+	// back of the queue up to MaxClaimRequeues times and is then dropped; either way the next
+	// entry is told instead. Zero drops it the first time.
+	ClaimWindow      time.Duration
+	MaxClaimRequeues int
+
+	// Wait-queue caps in entries: QueueCapFactor entries per unsold seat and one fewer per sold
+	// seat (only a cancellation frees those), never more than MaxQueueLength; zero disables either
+	QueueCapFactor int
+	MaxQueueLength int
+
+	// Entries the audit log keeps; once full its oldest quarter is dropped, folded into where the
+	// inventory stream starts. Zero keeps every entry.
+	MaxAuditEntries int
+
+	// Charges confirmations; nil confirms without charging. A declined charge leaves the hold in place.
+	Payments PaymentProcessor
 	mutex         sync.RWMutex     // Thread-safe operations
+	lockTracer    func(LockTiming) // told how long each write held the lock; see SetLockTracer
+	lockTiming    LockTiming       // the current write's timing; see lock
 }
 
 // WaitEntry represents a queued request for tickets
 type WaitEntry struct {
-	ID           string
-	UserID       string
-	ConferenceID string
-	TicketCount  int
-	EnqueuedAt   time.Time
+	ID           string    `json:"id"`
+	UserID       string    `json:"user_id"`
+	ConferenceID string    `json:"conference_id"`
+	TicketCount  int       `json:"ticket_count"`
+	Tier         string    `json:"tier,omitempty"` // tier the seats are wanted in; empty is the aggregate pool
+	EnqueuedAt   time.Time `json:"enqueued_at"`
+	FailedClaims int       `json:"failed_claims"`           // consecutive ClaimNext failures while at the head
+	MissedClaims int       `json:"missed_claims,omitempty"` // claim windows let pass; see Database.MaxClaimRequeues
+	// Priority is the entry's class, 0 (general) to MaxQueuePriority: the queue is served
+	// highest class first and first come, first served within a class
+	Priority int `json:"priority,omitempty"`
+	// ClaimableUntil is set when the head is told seats were freed; zero means it was never told
+	ClaimableUntil time.Time `json:"claimable_until,omitempty"`
+}
+
+// claimWindowLapsed reports whether the entry was told seats were free and let the window pass
+func (e *WaitEntry) claimWindowLapsed(now time.Time) bool {
+	return !e.ClaimableUntil.IsZero() && now.After(e.ClaimableUntil)
 }
 
 // NewDatabase creates a new database instance with sample data
 func NewDatabase() *Database {
 	db := &Database{
-		Users:         make(map[string]*models.User),
-		Conferences:   make(map[string]*models.Conference),
-		Bookings:      make(map[string]*models.Booking),
-		Reservations:  make(map[string]*models.SeatReservation),
-		WaitQueues:    make(map[string][]*WaitEntry),
-		StartTime:     time.Now(),
+		Users:             make(map[string]*models.User),
+		Conferences:       make(map[string]*models.Conference),
+		Bookings:          make(map[string]*models.Booking),
+		Reservations:      make(map[string]*models.SeatReservation),
+		WaitQueues:        make(map[string][]*WaitEntry),
+		WaitingRooms:      make(map[string]*WaitingRoomLine),
+		PromoCodes:        make(map[string]*models.PromoCode),
+		Carts:             make(map[string]*models.Cart),
+		CheckIns:          make(map[string]*models.CheckIn),
+		StartTime:         time.Now(),
+		now:               time.Now,
+		expired:           make(map[string]time.Time),
+		idempotency:       make(map[string]idempotentRequest),
+		credentials:       make(map[string]string),
+		history:           make(map[string][]*models.SeatReservation),
+		turnover:          make(map[string]float64),
+		MaxFailedClaims:   3,
+		ClaimWindow:       DefaultClaimWindow,
+		HoldTime:          ReservationHold,
+		MaxExtensions:     DefaultMaxExtensions,
+		MaxHoldTime:       DefaultMaxHoldTime,
+		QueueCapFactor:    DefaultQueueCapFactor,
+		MaxQueueLength:    DefaultMaxQueueLength,
+		MaxAuditEntries:   DefaultMaxAuditEntries,
+		MaxTicketsPerUser: DefaultMaxTicketsPerUser,
+		done:              make(chan struct{}),
+		janitorEvery:      make(chan time.Duration),
 	}
 	
 	// Add sample data
 	db.addSampleData()
+	db.openLedgerLocked()
+	
+	// Expire holds in the background so they stop blocking availability even when idle
+	go db.runJanitor(JanitorInterval)
 	return db
 }
 
-// addSampleData populates the database with sample conferences
-func (db *Database) addSampleData() {
-	// Add sample conferences
-	conf1 := &models.Conference{
-		ID:               "conf-1",
-		Name:             "Go Conference 2024",
-		Location:         "San Francisco",
-		TotalTickets:     100,
-		AvailableTickets: 100,
-		Price:            299.99,
-		Date:             time.Now().AddDate(0, 2, 0), // 2 months from now
+// runJanitor purges expired reservations every interval until Close is called. Each purged
+// hold is recorded as a reservation.expire change, so subscribers get an event for it.
+func (db *Database) runJanitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			db.cleanupExpiredReservations()
+		case interval := <-db.janitorEvery:
+			if interval > 0 {
+				ticker.Reset(interval)
+			} else {
+				ticker.Stop()
+			}
+		case <-db.done:
+			return
+		}
 	}
-	
-	conf2 := &models.Conference{
-		ID:               "conf-2",
-		Name:             "DevOps Summit",
-		Location:         "New York",
-		TotalTickets:     75,
-		AvailableTickets: 75,
-		Price:            399.99,
-		Date:             time.Now().AddDate(0, 3, 0), // 3 months from now
+}
+
+// SetJanitorInterval changes how often the background janitor purges expired reservations;
+// zero or less pauses it, leaving expired holds to be purged lazily by the calls that look at
+// them. It does nothing once Close has been called.
+func (db *Database) SetJanitorInterval(interval time.Duration) {
+	select {
+	case db.janitorEvery <- interval:
+	case <-db.done:
 	}
-	
-	conf3 := &models.Conference{
-		ID:               "conf-3",
-		Name:             "Cloud Native Expo",
-		Location:         "Seattle",
-		TotalTickets:     150,
-		AvailableTickets: 150,
-		Price:            199.99,
-		Date:             time.Now().AddDate(0, 1, 15), // 1.5 months from now
+}
+
+// SetClock replaces the clock used for holds, expiry and timestamps, letting tests move time
+// forward without sleeping. Pass nil to go back to time.Now.
+func (db *Database) SetClock(now func() time.Time) {
+	if now == nil {
+		now = time.Now
 	}
+	db.lock()
+	db.now = now
+	db.unlock()
+}
+
+// Close stops the background janitor and sweepers; it is safe to call more than once
+func (db *Database) Close() {
+	db.closeOnce.Do(func() { close(db.done) })
+}
+
+// addSampleData populates the database with the fixture set in use, or else the built-in
+// sample conferences (sample.json) and promo codes
+func (db *Database) addSampleData() {
+	if db.fixtures != nil {
+		db.addFixtureData(db.fixtures)
+		slog.Info("Loaded fixtures", "conferences", len(db.fixtures.Conferences), "users", len(db.fixtures.Users),
+			"bookings", len(db.fixtures.Bookings))
+		return
+	}
+	sample, err := sampleFixtures()
+	if err != nil {
+		panic(fmt.Sprintf("built-in sample data: %v", err)) // embedded and covered by tests
+	}
+	db.addFixtureData(sample)
+	db.addSamplePromoCodes()
 	
-	db.Conferences[conf1.ID] = conf1
-	db.Conferences[conf2.ID] = conf2
-	db.Conferences[conf3.ID] = conf3
-	
-	log.Printf("Added %d sample conferences to database", len(db.Conferences))
+	slog.Info("Added sample conferences", "count", len(db.Conferences))
 }
 
 // CreateUser creates a new user in the database
 func (db *Database) CreateUser(name, email string) (*models.User, error) {
-	db.mutex.Lock()
-	defer db.mutex.Unlock()
+	db.lock()
+	defer db.unlock()
+	return db.createUserLocked(name, email, models.RoleUser)
+}
+
+// normalizeEmail is the form emails are stored and compared in: trimmed and lower-cased
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
 
+// createUserLocked creates a user after checking the email is unused; caller must hold write lock
+func (db *Database) createUserLocked(name, email, role string) (*models.User, error) {
 	// Normalize email for uniqueness (case-insensitive)
-	norm := strings.ToLower(strings.TrimSpace(email))
+	norm := normalizeEmail(email)
 
 	// Check if user with email already exists
 	for _, user := range db.Users {
-		if strings.ToLower(strings.TrimSpace(user.Email)) == norm {
-			return nil, fmt.Errorf("user with email %s already exists", email)
+		if normalizeEmail(user.Email) == norm {
+			return nil, conflictf("user with email %s already exists", email)
 		}
 	}
 
 	user := &models.User{
 		ID:      uuid.New().String(),
-		Name:    name,
+		Name:    models.NormalizeName(name),
 		Email:   norm,
-		Created: time.Now(),
+		Created: db.now(),
+		Role:    role,
 	}
 	
 	db.Users[user.ID] = user
+	db.recordLocked(AuditEntry{Op: OpUserCreate, At: user.Created, UserID: user.ID, Name: user.Name, Email: user.Email, Role: user.Role})
 	return user, nil
 }
 
@@ -122,7 +386,7 @@ func (db *Database) GetUser(userID string) (*models.User, error) {
 	
 	user, exists := db.Users[userID]
 	if !exists {
-		return nil, fmt.Errorf("user not found")
+		return nil, ErrUserNotFound
 	}
 	
 	return user, nil
@@ -130,45 +394,284 @@ func (db *Database) GetUser(userID string) (*models.User, error) {
 
 // GetAllConferences returns all conferences
 func (db *Database) GetAllConferences() []*models.Conference {
+	return db.QueryConferences(ConferenceQuery{})
+}
+
+// Conference sort keys accepted by QueryConferences
+const (
+	SortByID    = "id"
+	SortByName  = "name"
+	SortByPrice = "price"
+	SortByDate  = "date"
+)
+
+// ValidConferenceSort reports whether key is one of the conference sort keys
+func ValidConferenceSort(key string) bool {
+	switch key {
+	case SortByID, SortByName, SortByPrice, SortByDate:
+		return true
+	}
+	return false
+}
+
+// ConferenceQuery filters and orders the conferences returned by QueryConferences
+type ConferenceQuery struct {
+	Location      string // case-insensitive substring match; empty matches any
+	AvailableOnly bool   // only conferences with tickets left
+	Sort          string // one of the SortBy keys; anything else sorts by ID
+	Descending    bool
+
+	From     time.Time // inclusive lower bound on Date; zero means unbounded
+	To       time.Time // inclusive upper bound on Date; zero means unbounded
+	MinPrice *float64  // inclusive bounds on Price; nil means unbounded
+	MaxPrice *float64
+}
+
+// matches reports whether a conference passes the query's filters
+func (q ConferenceQuery) matches(conf *models.Conference, location string) bool {
+	switch {
+	case location != "" && !strings.Contains(strings.ToLower(conf.Location), location),
+		q.AvailableOnly && conf.AvailableTickets <= 0,
+		!q.From.IsZero() && conf.Date.Before(q.From),
+		!q.To.IsZero() && conf.Date.After(q.To),
+		q.MinPrice != nil && conf.Price < *q.MinPrice,
+		q.MaxPrice != nil && conf.Price > *q.MaxPrice:
+		return false
+	}
+	return true
+}
+
+// QueryConferences returns snapshots of the conferences matching the query, sorted as asked
+// with ties broken by ID
+func (db *Database) QueryConferences(q ConferenceQuery) []*models.Conference {
 	db.mutex.RLock()
 	defer db.mutex.RUnlock()
-	
+
+	location := strings.ToLower(strings.TrimSpace(q.Location))
 	var conferences []*models.Conference
 	for _, conf := range db.Conferences {
-		conferences = append(conferences, conf)
+		if q.matches(conf, location) {
+			conferences = append(conferences, snapshotConference(conf))
+		}
+	}
+
+	less := func(a, b *models.Conference) bool { return a.ID < b.ID }
+	switch q.Sort {
+	case SortByName:
+		less = func(a, b *models.Conference) bool { return a.Name < b.Name }
+	case SortByPrice:
+		less = func(a, b *models.Conference) bool { return a.Price < b.Price }
+	case SortByDate:
+		less = func(a, b *models.Conference) bool { return a.Date.Before(b.Date) }
 	}
-	// Keep conferences sorted by ID for convenience
 	sort.Slice(conferences, func(i, j int) bool {
-		return conferences[i].ID < conferences[j].ID
+		a, b := conferences[i], conferences[j]
+		if q.Descending {
+			a, b = b, a
+		}
+		if less(a, b) || less(b, a) {
+			return less(a, b)
+		}
+		return a.ID < b.ID
 	})
 	return conferences
 }
 
-// GetConference retrieves a conference by ID
+// GetConference retrieves a snapshot of a conference by ID
 func (db *Database) GetConference(conferenceID string) (*models.Conference, error) {
 	db.mutex.RLock()
 	defer db.mutex.RUnlock()
 	
 	conference, exists := db.Conferences[conferenceID]
 	if !exists {
-		return nil, fmt.Errorf("conference not found")
+		return nil, ErrConferenceNotFound
 	}
 	
-	return conference, nil
+	return snapshotConference(conference), nil
 }
 
-// CreateBooking creates a new booking
-func (db *Database) CreateBooking(userID, conferenceID string, ticketCount int) (*models.Booking, error) {
-	db.mutex.Lock()
-	defer db.mutex.Unlock()
-	
+// snapshotConference copies a conference so callers never hold a pointer into the live maps.
+// Mutations only ever touch the stored instance under the write lock, so a snapshot handed out
+// before a ResetDatabase is never written to afterwards.
+func snapshotConference(conf *models.Conference) *models.Conference {
+	if conf == nil {
+		return nil
+	}
+	cp := *conf
+	cp.Tiers = append([]models.Tier(nil), conf.Tiers...)
+	cp.Tags = append([]string(nil), conf.Tags...)
+	cp.Sections = append([]models.Section(nil), conf.Sections...)
+	cp.WaitingRoom = copyWaitingRoom(conf.WaitingRoom)
+	return &cp
+}
+
+// snapshotReservation copies a reservation for callers outside the lock, who would otherwise
+// race the writes that expire, extend or confirm it
+func snapshotReservation(r *models.SeatReservation) *models.SeatReservation {
+	if r == nil {
+		return nil
+	}
+	cp := *r
+	cp.SeatIDs = slices.Clone(r.SeatIDs)
+	cp.Attendees = slices.Clone(r.Attendees)
+	if r.QueueTurn != nil {
+		turn := *r.QueueTurn
+		cp.QueueTurn = &turn
+	}
+	return &cp
+}
+
+// snapshotReservations copies each of reservations; see snapshotReservation
+func snapshotReservations(reservations []*models.SeatReservation) []*models.SeatReservation {
+	out := make([]*models.SeatReservation, len(reservations))
+	for i, r := range reservations {
+		out[i] = snapshotReservation(r)
+	}
+	return out
+}
+
+// snapshotBooking copies a booking for callers outside the lock, who would otherwise race
+// the write that cancels it
+func snapshotBooking(b *models.Booking) *models.Booking {
+	if b == nil {
+		return nil
+	}
+	cp := *b
+	cp.Attendees = slices.Clone(b.Attendees)
+	cp.SeatIDs = slices.Clone(b.SeatIDs)
+	return &cp
+}
+
+// snapshotBookings copies each of bookings; see snapshotBooking
+func snapshotBookings(bookings []*models.Booking) []*models.Booking {
+	out := make([]*models.Booking, len(bookings))
+	for i, b := range bookings {
+		out[i] = snapshotBooking(b)
+	}
+	return out
+}
+
+// ReleaseHoldback moves count held-back seats into general availability
+func (db *Database) ReleaseHoldback(conferenceID string, count int) (*models.Conference, error) {
+	db.lock()
+	defer db.unlock()
+
+	conference, exists := db.Conferences[conferenceID]
+	if !exists {
+		return nil, ErrConferenceNotFound
+	}
+	if count <= 0 || count > conference.ReservedHoldback {
+		return nil, fmt.Errorf("can release between 1 and %d held-back seats", conference.ReservedHoldback)
+	}
+	conference.ReservedHoldback -= count
+	db.recordLocked(AuditEntry{Op: OpHoldbackRelease, ConferenceID: conferenceID, TicketCount: count})
+	db.promoteHeadLocked(conferenceID, db.now())
+	return snapshotConference(conference), nil
+}
+
+// normalizeAttendees trims attendee names and checks there is one per ticket;
+// no names at all is allowed and yields an empty list
+func normalizeAttendees(names []string, ticketCount int) ([]string, error) {
+	if len(names) == 0 {
+		return []string{}, nil
+	}
+	if len(names) != ticketCount {
+		return nil, fmt.Errorf("%w: got %d names for %d tickets", ErrInvalidAttendees, len(names), ticketCount)
+	}
+	out := make([]string, len(names))
+	for i, name := range names {
+		out[i] = strings.TrimSpace(name)
+		if out[i] == "" {
+			return nil, fmt.Errorf("%w: name %d is empty", ErrInvalidAttendees, i+1)
+		}
+	}
+	return out, nil
+}
+
+// BookingOptions carries the optional parts of a booking request
+type BookingOptions struct {
+	Attendees       []string // one name per ticket, or none
+	ExpectedVersion *int     // reject with ErrVersionConflict unless the conference is at this version
+	Tier            string   // book from this tier at its price; empty uses the aggregate pool
+	RequestID       string   // the API request asking, recorded on the audit entries it causes
+	ActorID         string   // the signed-in user asking, recorded likewise; empty credits the user booking
+}
+
+// ErrVersionConflict is returned when a conference changed since the client read its version
+var ErrVersionConflict = conflictf("conference availability changed since it was read")
+
+// VersionConflict is the ErrVersionConflict a compare-and-swap fails with, carrying the
+// conference's current version so a client can re-read and retry
+type VersionConflict struct {
+	Expected, Current int
+}
+
+func (e *VersionConflict) Error() string {
+	return fmt.Sprintf("%v: expected version %d, now %d", ErrVersionConflict, e.Expected, e.Current)
+}
+
+func (e *VersionConflict) Unwrap() error { return ErrVersionConflict }
+
+// checkVersion compares a conference against the version a client expects, if any
+func checkVersion(conf *models.Conference, expected *int) error {
+	if expected != nil && *expected != conf.Version {
+		return &VersionConflict{Expected: *expected, Current: conf.Version}
+	}
+	return nil
+}
+
+// CreateBooking creates a new booking; attendees is optional but must have one name per ticket if given
+func (db *Database) CreateBooking(userID, conferenceID string, ticketCount int, opts BookingOptions) (*models.Booking, error) {
+	attendees, err := normalizeAttendees(opts.Attendees, ticketCount)
+	if err != nil {
+		return nil, err
+	}
+	opts.Attendees = attendees
+
+	db.lock()
+	defer db.unlock()
+	db.tagLocked(opts.RequestID, opts.ActorID)
+	booking, err := db.createBookingLocked(userID, conferenceID, ticketCount, opts)
+	return snapshotBooking(booking), err
+}
+
+// createBookingLocked books tickets directly; opts.Attendees must already be normalized.
+// Caller must hold write lock.
+func (db *Database) createBookingLocked(userID, conferenceID string, ticketCount int, opts BookingOptions) (*models.Booking, error) {
 	conference, exists := db.Conferences[conferenceID]
 	if !exists {
-		return nil, fmt.Errorf("conference not found")
+		return nil, ErrConferenceNotFound
+	}
+	if err := checkUpcoming(conference, db.now()); err != nil {
+		return nil, err
+	}
+	if err := checkVersion(conference, opts.ExpectedVersion); err != nil {
+		return nil, err
+	}
+	tier, price, err := pickTier(conference, opts.Tier)
+	if err != nil {
+		return nil, err
 	}
 	
-	if conference.AvailableTickets < ticketCount {
-		return nil, fmt.Errorf("not enough tickets available")
+	// Seats held by active reservations are spoken for, same as in checkReservationLocked
+	if db.availableLocked(conference)-conference.ReservedHoldback-db.reservedForConferenceLocked(conferenceID) < ticketCount {
+		return nil, ErrSoldOut
+	}
+	tierName := ""
+	if tier != nil {
+		if db.tierAvailableLocked(conference, tier)-db.reservedForTierLocked(conferenceID, tier.Name) < ticketCount {
+			return nil, soldOutf("not enough %s tickets available", tier.Name)
+		}
+		tierName = tier.Name
+	} else if err := db.checkUntieredLocked(conference, ticketCount); err != nil {
+		return nil, err
+	}
+	if err := db.checkUserCapLocked(userID, conferenceID, ticketCount); err != nil {
+		return nil, err
+	}
+	seats, err := db.pickSeatsLocked(conference, ticketCount, nil)
+	if err != nil {
+		return nil, err
 	}
 	
 	booking := &models.Booking{
@@ -176,20 +679,25 @@ func (db *Database) CreateBooking(userID, conferenceID string, ticketCount int)
 		UserID:        userID,
 		ConferenceID:  conferenceID,
 		TicketsBooked: ticketCount,
-		TotalAmount:   conference.Price * float64(ticketCount),
+		TotalAmount:   price * float64(ticketCount),
 		Status:        "confirmed",
-		BookedAt:      time.Now(),
+		BookedAt:      db.now(),
+		Attendees:     opts.Attendees,
+		Tier:          tierName,
+		SeatIDs:       seats,
 	}
 	
-	// Update available tickets
-	conference.AvailableTickets -= ticketCount
-	
 	db.Bookings[booking.ID] = booking
+	db.recordLocked(AuditEntry{
+		Op: OpBookingCreate, At: booking.BookedAt, UserID: userID, ConferenceID: conferenceID,
+		BookingID: booking.ID, TicketCount: ticketCount, Amount: booking.TotalAmount, Attendees: booking.Attendees,
+		Tier: tierName, SeatIDs: seats,
+	})
 	return booking, nil
 }
 
-// GetUserBookings returns all bookings for a user
-func (db *Database) GetUserBookings(userID string) []*models.Booking {
+// GetUserBookings returns one page of a user's bookings, newest first, and the user's total
+func (db *Database) GetUserBookings(userID string, limit, offset int) ([]*models.Booking, int) {
 	db.mutex.RLock()
 	defer db.mutex.RUnlock()
 	
@@ -199,7 +707,9 @@ func (db *Database) GetUserBookings(userID string) []*models.Booking {
 			bookings = append(bookings, booking)
 		}
 	}
-	return bookings
+	sortBookingsNewestFirst(bookings)
+	start, end := pageBounds(len(bookings), limit, offset)
+	return snapshotBookings(bookings[start:end]), len(bookings)
 }
 
 // GetBooking retrieves a booking by ID
@@ -207,52 +717,207 @@ func (db *Database) GetBooking(id string) *models.Booking {
 	db.mutex.RLock()
 	defer db.mutex.RUnlock()
 	
-	booking, exists := db.Bookings[id]
+	return snapshotBooking(db.Bookings[id])
+}
+
+// ErrBookingCancelled is returned when cancelling a booking that is already cancelled
+var ErrBookingCancelled = conflictf("booking is already cancelled")
+
+// CancelBooking cancels a confirmed booking and returns its tickets to the conference. A
+// provider payment is refunded as a whole, so the other bookings paid through the same one (a
+// cart's) are cancelled along with it.
+func (db *Database) CancelBooking(bookingID string) (*models.Booking, error) {
+	db.lock()
+	defer db.unlock()
+
+	booking, exists := db.Bookings[bookingID]
 	if !exists {
-		return nil
+		return nil, ErrBookingNotFound
 	}
-	
-	return booking
+	if booking.Status == "cancelled" {
+		return nil, ErrBookingCancelled
+	}
+	cancelled := []*models.Booking{booking}
+	if booking.PaymentID != "" {
+		for _, other := range db.Bookings {
+			if other != booking && other.PaymentID == booking.PaymentID && other.Status != "cancelled" {
+				cancelled = append(cancelled, other)
+			}
+		}
+	}
+	now := db.now()
+	for _, b := range cancelled {
+		db.cancelBookingLocked(b)
+		db.recordLocked(AuditEntry{
+			Op: OpBookingCancel, UserID: b.UserID, ConferenceID: b.ConferenceID,
+			BookingID: b.ID, TicketCount: b.TicketsBooked, Tier: b.Tier,
+		})
+		db.promoteHeadLocked(b.ConferenceID, now)
+	}
+	return snapshotBooking(booking), nil
+}
+
+// cancelBookingLocked marks a booking cancelled; its booking.cancel entry puts the tickets back
+// on sale. Caller must hold write lock.
+func (db *Database) cancelBookingLocked(booking *models.Booking) {
+	booking.Status = "cancelled"
+}
+
+// BookingFilter narrows the bookings returned by GetAllBookings
+type BookingFilter struct {
+	From   time.Time // inclusive lower bound on BookedAt; zero means unbounded
+	To     time.Time // inclusive upper bound on BookedAt; zero means unbounded
+	Status string    // empty matches any status
+}
+
+// matches reports whether a booking passes the filter
+func (f BookingFilter) matches(b *models.Booking) bool {
+	if !f.From.IsZero() && b.BookedAt.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && b.BookedAt.After(f.To) {
+		return false
+	}
+	if f.Status != "" && b.Status != f.Status {
+		return false
+	}
+	return true
 }
 
-// GetAllBookings returns all bookings with user and conference details
-func (db *Database) GetAllBookings() []map[string]interface{} {
+// GetAllBookings returns one page of the bookings matching the filter with user and conference
+// details, newest first, along with the number of matching bookings
+func (db *Database) GetAllBookings(filter BookingFilter, limit, offset int) ([]map[string]interface{}, int) {
 	db.mutex.RLock()
 	defer db.mutex.RUnlock()
 	
-	var result []map[string]interface{}
-	
+	var bookings []*models.Booking
 	for _, booking := range db.Bookings {
+		if filter.matches(booking) {
+			bookings = append(bookings, booking)
+		}
+	}
+	sortBookingsNewestFirst(bookings)
+	start, end := pageBounds(len(bookings), limit, offset)
+	
+	result := make([]map[string]interface{}, 0, end-start)
+	for _, booking := range bookings[start:end] {
 		user := db.Users[booking.UserID]
-		conference := db.Conferences[booking.ConferenceID]
+		conference := snapshotConference(db.Conferences[booking.ConferenceID])
 		
 		bookingData := map[string]interface{}{
-			"booking":    booking,
+			"booking":    snapshotBooking(booking),
 			"user":       user,
 			"conference": conference,
 		}
 		result = append(result, bookingData)
 	}
 	
-	return result
+	return result, len(bookings)
+}
+
+// pageBounds clamps limit/offset to a slice of n items; an offset past the end yields an
+// empty page and a non-positive limit means no limit
+func pageBounds(n, limit, offset int) (start, end int) {
+	start = min(max(offset, 0), n)
+	end = n
+	if limit > 0 && start+limit < n {
+		end = start + limit
+	}
+	return start, end
+}
+
+// sortBookingsNewestFirst orders bookings by BookedAt descending, breaking ties by ID
+func sortBookingsNewestFirst(bookings []*models.Booking) {
+	sort.Slice(bookings, func(i, j int) bool {
+		if !bookings[i].BookedAt.Equal(bookings[j].BookedAt) {
+			return bookings[i].BookedAt.After(bookings[j].BookedAt)
+		}
+		return bookings[i].ID < bookings[j].ID
+	})
 }
 
-// GetAllUsers returns all users
+// GetAllUsers returns every user, oldest first (ties broken by ID)
 func (db *Database) GetAllUsers() []*models.User {
 	db.mutex.RLock()
 	defer db.mutex.RUnlock()
 	
-	var users []*models.User
+	users := make([]*models.User, 0, len(db.Users))
 	for _, user := range db.Users {
 		users = append(users, user)
 	}
+	sort.Slice(users, func(i, j int) bool {
+		if !users[i].Created.Equal(users[j].Created) {
+			return users[i].Created.Before(users[j].Created)
+		}
+		return users[i].ID < users[j].ID
+	})
 	return users
 }
 
-// ResetDatabase clears all data and reinitializes with sample data
+// SetUserRole changes a user's role to models.RoleUser, RoleOrganizer or RoleAdmin
+func (db *Database) SetUserRole(userID, role string) (*models.User, error) {
+	if err := checkRole(role); err != nil {
+		return nil, err
+	}
+	db.lock()
+	defer db.unlock()
+	
+	user, exists := db.Users[userID]
+	if !exists {
+		return nil, ErrUserNotFound
+	}
+	if roleOrUser(user.Role) != role {
+		user.Role = role
+		db.recordLocked(AuditEntry{Op: OpUserRole, UserID: user.ID, Role: role})
+	}
+	return user, nil
+}
+
+// SetUserPriority sets the queue priority class the user's own queue joins get, 0 (general)
+// to MaxQueuePriority. Entries already waiting keep their class until the user joins again.
+func (db *Database) SetUserPriority(userID string, priority int) (*models.User, error) {
+	if priority < 0 || priority > MaxQueuePriority {
+		return nil, fmt.Errorf("queue priority must be between 0 and %d", MaxQueuePriority)
+	}
+	db.lock()
+	defer db.unlock()
+	
+	user, exists := db.Users[userID]
+	if !exists {
+		return nil, ErrUserNotFound
+	}
+	if user.QueuePriority != priority {
+		user.QueuePriority = priority
+		db.recordLocked(AuditEntry{Op: OpUserPriority, UserID: user.ID, Priority: priority})
+	}
+	return user, nil
+}
+
+// checkRole rejects anything but the known roles
+func checkRole(role string) error {
+	switch role {
+	case models.RoleUser, models.RoleOrganizer, models.RoleAdmin:
+		return nil
+	}
+	return fmt.Errorf("role must be %q, %q or %q", models.RoleUser, models.RoleOrganizer, models.RoleAdmin)
+}
+
+// roleOrUser reads an empty role, from records saved before roles existed, as RoleUser
+func roleOrUser(role string) string {
+	if role == "" {
+		return models.RoleUser
+	}
+	return role
+}
+
+// ResetDatabase clears all data and reinitializes with sample data.
+// Every mutation runs start-to-finish under the write lock, so operations already in flight
+// complete against the old maps before the swap, and later ones referencing pre-reset IDs
+// fail with "not found" instead of touching orphaned records. The janitor and sweepers take
+// the same lock, so they keep running across a reset without being stopped.
 func (db *Database) ResetDatabase() {
-	db.mutex.Lock()
-	defer db.mutex.Unlock()
+	db.lock()
+	defer db.unlock()
 	
 	// Clear all maps
 	db.Users = make(map[string]*models.User)
@@ -261,78 +926,201 @@ func (db *Database) ResetDatabase() {
 	db.Reservations = make(map[string]*models.SeatReservation)
 	// admin sessions removed
 	db.WaitQueues = make(map[string][]*WaitEntry)
+	db.WaitingRooms = make(map[string]*WaitingRoomLine)
+	db.PromoCodes = make(map[string]*models.PromoCode)
+	db.Carts = make(map[string]*models.Cart)
+	db.CheckIns = make(map[string]*models.CheckIn)
+	db.expired = make(map[string]time.Time)
+	db.idempotency = make(map[string]idempotentRequest)
+	db.credentials = make(map[string]string)
+	db.history = make(map[string][]*models.SeatReservation)
+	db.turnover = make(map[string]float64)
+	db.Audit = nil
+	db.writes++
+	db.epoch++
 	
 	// Reset start time
-	db.StartTime = time.Now()
+	db.StartTime = db.now()
 	
 	// Repopulate with sample data
 	db.addSampleData()
+	db.openLedgerLocked()
 }
 
-// CreateReservation creates a temporary seat reservation
-func (db *Database) CreateReservation(userID, conferenceID string, ticketCount int) (*models.SeatReservation, error) {
-	db.mutex.Lock()
-	defer db.mutex.Unlock()
+// CreateReservation creates a temporary seat reservation, in opts.Tier when given and discounted
+// when opts names a valid promo code
+func (db *Database) CreateReservation(userID, conferenceID string, ticketCount int, opts ReservationOptions) (*models.SeatReservation, error) {
+	db.lock()
+	defer db.unlock()
 
 	// Clean up expired reservations first (already holding write lock)
 	db.cleanupExpiredReservationsLocked()
-	
+	db.tagLocked(opts.RequestID, opts.ActorID)
+	reservation, err := db.createReservationLocked(userID, conferenceID, ticketCount, opts)
+	return snapshotReservation(reservation), err
+}
+
+// createReservationLocked checks and makes a direct hold for CreateReservation and
+// CreateReservationIdempotent; caller must hold write lock
+func (db *Database) createReservationLocked(userID, conferenceID string, ticketCount int, opts ReservationOptions) (*models.SeatReservation, error) {
+	conference, tier, err := db.checkReservationLocked(userID, conferenceID, ticketCount, opts.Tier)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkVersion(conference, opts.ExpectedVersion); err != nil {
+		return nil, err
+	}
+	promo, err := db.promoForLocked(opts.PromoCode, conferenceID)
+	if err != nil {
+		return nil, err
+	}
+	seats, err := db.pickSeatsLocked(conference, ticketCount, opts.SeatIDs)
+	if err != nil {
+		return nil, err
+	}
+	return db.holdSeatsLocked(userID, conference, tier, ticketCount, promo, seats), nil
+}
+
+// checkReservationLocked applies the rules for a new direct hold and returns the conference
+// and the named tier (nil for the aggregate pool); caller must hold write lock
+func (db *Database) checkReservationLocked(userID, conferenceID string, ticketCount int, tierName string) (*models.Conference, *models.Tier, error) {
 	conference, exists := db.Conferences[conferenceID]
 	if !exists {
-		return nil, fmt.Errorf("conference not found")
+		return nil, nil, ErrConferenceNotFound
+	}
+	if err := checkUpcoming(conference, db.now()); err != nil {
+		return nil, nil, err
+	}
+	tier, _, err := pickTier(conference, tierName)
+	if err != nil {
+		return nil, nil, err
 	}
 	
 	// Ensure user has no other active reservation for this conference
 	for _, reservation := range db.Reservations {
 		if reservation.UserID == userID && reservation.ConferenceID == conferenceID {
-			if time.Now().Before(reservation.ExpiresAt) {
-				return nil, fmt.Errorf("you already have an active reservation for this conference")
+			if db.now().Before(reservation.ExpiresAt) {
+				return nil, nil, conflictf("you already have an active reservation for this conference")
 			}
 		}
 	}
 
 	// Calculate total reserved tickets for this conference
-	reservedTickets := 0
-	for _, reservation := range db.Reservations {
-		if reservation.ConferenceID == conferenceID {
-			reservedTickets += reservation.TicketCount
-		}
-	}
+	reservedTickets := db.reservedForConferenceLocked(conferenceID)
 	
 	// Check if enough tickets are available (considering reservations)
-	availableForReservation := conference.AvailableTickets - reservedTickets
+	availableForReservation := db.availableLocked(conference) - conference.ReservedHoldback - reservedTickets
 	if availableForReservation < ticketCount {
-		return nil, fmt.Errorf("not enough tickets available for reservation")
+		return nil, nil, soldOutf("not enough tickets available for reservation")
 	}
-	
+	if tier != nil && db.tierAvailableLocked(conference, tier)-db.reservedForTierLocked(conferenceID, tier.Name) < ticketCount {
+		return nil, nil, soldOutf("not enough %s tickets available for reservation", tier.Name)
+	}
+	if tier == nil {
+		if err := db.checkUntieredLocked(conference, ticketCount); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := db.checkHeldCapLocked(conference, reservedTickets, ticketCount); err != nil {
+		return nil, nil, err
+	}
+	if err := db.checkUserCapLocked(userID, conferenceID, ticketCount); err != nil {
+		return nil, nil, err
+	}
+	return conference, tier, nil
+}
+
+// holdSeatsLocked creates and records a direct reservation; caller must hold write lock
+// and have checked it with checkReservationLocked. A non-nil tier sets the price and a non-nil
+// promo discounts the total. seats come from pickSeatsLocked; nil at a conference with a seat
+// map takes the first free seats, which the availability checks guarantee there are.
+func (db *Database) holdSeatsLocked(userID string, conference *models.Conference, tier *models.Tier, ticketCount int, promo *models.PromoCode, seats []string) *models.SeatReservation {
+	if seats == nil {
+		seats, _ = db.pickSeatsLocked(conference, ticketCount, nil)
+	}
+	conferenceID := conference.ID
+	price, tierName := conference.Price, ""
+	if tier != nil {
+		price, tierName = tier.Price, tier.Name
+	}
+	now, hold := db.now(), db.holdFor(conference)
 	reservation := &models.SeatReservation{
 		ID:           uuid.New().String(),
 		UserID:       userID,
 		ConferenceID: conferenceID,
 		TicketCount:  ticketCount,
-		TotalAmount:  conference.Price * float64(ticketCount),
-		ExpiresAt:    time.Now().Add(15 * time.Second),
-		CreatedAt:    time.Now(),
+		TotalAmount:  price * float64(ticketCount),
+		ExpiresAt:    now.Add(hold),
+		CreatedAt:    now,
+		Source:       models.ReservationSourceDirect,
+		Status:       models.ReservationStatusActive,
+		Tier:         tierName,
+		HoldSeconds:  holdSeconds(hold),
+		SeatIDs:      seats,
+	}
+	
+	if promo != nil {
+		reservation.TotalAmount -= promo.Discount(reservation.TotalAmount)
+		reservation.PromoCode = promo.Code
 	}
 	
 	db.Reservations[reservation.ID] = reservation
-	return reservation, nil
+	db.recordLocked(AuditEntry{
+		Op: OpReservationCreate, At: reservation.CreatedAt, UserID: userID, ConferenceID: conferenceID,
+		ReservationID: reservation.ID, TicketCount: ticketCount, Amount: reservation.TotalAmount,
+		ExpiresAt: reservation.ExpiresAt, PromoCode: reservation.PromoCode, Tier: tierName, HoldSeconds: reservation.HoldSeconds,
+		SeatIDs: seats,
+	})
+	return reservation
 }
 
-// ConfirmReservation converts a reservation to a booking
-func (db *Database) ConfirmReservation(reservationID string) (*models.Booking, error) {
-	db.mutex.Lock()
-	defer db.mutex.Unlock()
+// ConfirmReservation converts a reservation to a booking after charging its total through
+// Payments; opts follows the CreateBooking rules
+func (db *Database) ConfirmReservation(reservationID string, opts BookingOptions) (*models.Booking, error) {
+	db.lock()
+	defer db.unlock()
+	db.tagLocked(opts.RequestID, opts.ActorID)
 	
 	reservation, exists := db.Reservations[reservationID]
 	if !exists {
-		return nil, fmt.Errorf("reservation not found")
+		return nil, db.missingReservationErrLocked(reservationID)
 	}
+	booking, err := db.confirmLocked(reservation, opts, true)
+	return snapshotBooking(booking), err
+}
+
+// confirmLocked books an unexpired reservation, charging it through Payments first when charge
+// is set; caller must hold write lock
+func (db *Database) confirmLocked(reservation *models.SeatReservation, opts BookingOptions, charge bool) (*models.Booking, error) {
+	reservationID := reservation.ID
 	
 	// Check if reservation has expired
-	if time.Now().After(reservation.ExpiresAt) {
-		delete(db.Reservations, reservationID)
-		return nil, fmt.Errorf("reservation has expired")
+	if now := db.now(); now.After(reservation.ExpiresAt) {
+		db.expireReservationLocked(reservation, now)
+		return nil, &ReservationExpiredError{ExpiredAt: reservation.ExpiresAt}
+	}
+	
+	conference, exists := db.Conferences[reservation.ConferenceID]
+	if !exists {
+		db.retireReservationLocked(reservation, models.ReservationStatusCancelled)
+		db.recordLocked(AuditEntry{Op: OpReservationCancel, ReservationID: reservationID, UserID: reservation.UserID, ConferenceID: reservation.ConferenceID})
+		return nil, ErrConferenceNotFound
+	}
+	if err := checkVersion(conference, opts.ExpectedVersion); err != nil {
+		return nil, err
+	}
+	if err := db.checkSeatsLeftLocked(conference, reservation); err != nil {
+		return nil, err
+	}
+	attendees, err := normalizeAttendees(opts.Attendees, reservation.TicketCount)
+	if err != nil {
+		return nil, err
+	}
+	// Charge last so a declined payment leaves the reservation untouched for a retry
+	if charge {
+		if err := db.chargeLocked(reservation.TotalAmount, reservation.UserID); err != nil {
+			return nil, err
+		}
 	}
 	
 	// Create the booking
@@ -343,33 +1131,148 @@ func (db *Database) ConfirmReservation(reservationID string) (*models.Booking, e
 		TicketsBooked: reservation.TicketCount,
 		TotalAmount:   reservation.TotalAmount,
 		Status:        "confirmed",
-		BookedAt:      time.Now(),
+		BookedAt:      db.now(),
+		Attendees:     attendees,
+		Tier:          reservation.Tier,
+		PaymentID:     reservation.PaymentID,
+		SeatIDs:       reservation.SeatIDs,
 	}
 	
-	// Update conference availability
-	conference := db.Conferences[reservation.ConferenceID]
-	conference.AvailableTickets -= reservation.TicketCount
-	
 	// Store booking and remove reservation
 	db.Bookings[booking.ID] = booking
-	delete(db.Reservations, reservationID)
+	db.redeemPromoLocked(reservation)
+	db.recordTurnoverLocked(reservation, booking.BookedAt)
+	db.retireReservationLocked(reservation, models.ReservationStatusConfirmed)
+	db.recordLocked(AuditEntry{
+		Op: OpReservationConfirm, At: booking.BookedAt, UserID: booking.UserID, ConferenceID: booking.ConferenceID,
+		ReservationID: reservationID, BookingID: booking.ID, TicketCount: booking.TicketsBooked, Amount: booking.TotalAmount,
+		Attendees: attendees, PromoCode: reservation.PromoCode,
+	})
 	
 	return booking, nil
 }
 
+// checkSeatsLeftLocked reports whether conference still has the seats reservation holds, in its
+// tier if it has one; caller must hold the lock
+func (db *Database) checkSeatsLeftLocked(conference *models.Conference, reservation *models.SeatReservation) error {
+	if db.availableLocked(conference) < reservation.TicketCount {
+		return ErrSoldOut
+	}
+	if tier := findTier(conference, reservation.Tier); reservation.Tier != "" && (tier == nil || db.tierAvailableLocked(conference, tier) < reservation.TicketCount) {
+		return soldOutf("not enough %s tickets available", reservation.Tier)
+	}
+	return nil
+}
+
 // CancelReservation removes a reservation
 func (db *Database) CancelReservation(reservationID string) error {
-	db.mutex.Lock()
-	defer db.mutex.Unlock()
+	db.lock()
+	defer db.unlock()
 	
-	if _, exists := db.Reservations[reservationID]; !exists {
-		return fmt.Errorf("reservation not found")
+	reservation, exists := db.Reservations[reservationID]
+	if !exists {
+		return ErrReservationNotFound
 	}
 	
-	delete(db.Reservations, reservationID)
+	db.retireReservationLocked(reservation, models.ReservationStatusCancelled)
+	db.recordLocked(AuditEntry{Op: OpReservationCancel, ReservationID: reservationID, UserID: reservation.UserID, ConferenceID: reservation.ConferenceID})
+	db.promoteHeadLocked(reservation.ConferenceID, db.now())
+	return nil
+}
+
+// ExtendReservationOnce grants a single extra hold period to a reservation that is about to
+// expire while its owner is still paying; a second extension is refused
+func (db *Database) ExtendReservationOnce(reservationID string) (*models.SeatReservation, error) {
+	db.lock()
+	defer db.unlock()
+	db.cleanupExpiredReservationsLocked()
+
+	reservation, exists := db.Reservations[reservationID]
+	if !exists {
+		return nil, db.missingReservationErrLocked(reservationID)
+	}
+	if reservation.Status == models.ReservationStatusOffered {
+		return nil, ErrOfferPending
+	}
+	if reservation.Extended {
+		return nil, ErrAlreadyExtended
+	}
+	if reservation.ExpiresAt.Sub(db.now()) > AutoExtendWindow {
+		return nil, ErrNotInExtendWindow
+	}
+
+	if err := db.extendLocked(reservation); err != nil {
+		return nil, err
+	}
+	reservation.Extended = true
+	db.recordLocked(AuditEntry{Op: OpReservationExtend, ReservationID: reservationID, ExpiresAt: reservation.ExpiresAt})
+	return snapshotReservation(reservation), nil
+}
+
+// ExtendReservation pushes an active reservation's expiry back by another hold period so its
+// owner can finish paying. Each hold may be extended at most MaxExtensions times and never
+// past MaxHoldTime.
+func (db *Database) ExtendReservation(reservationID string) (*models.SeatReservation, error) {
+	db.lock()
+	defer db.unlock()
+	db.cleanupExpiredReservationsLocked()
+
+	reservation, exists := db.Reservations[reservationID]
+	if !exists {
+		return nil, db.missingReservationErrLocked(reservationID)
+	}
+	if reservation.Status == models.ReservationStatusOffered {
+		return nil, ErrOfferPending
+	}
+	if reservation.ExtensionCount >= db.MaxExtensions {
+		return nil, fmt.Errorf("%w (%d of %d used)", ErrExtensionsExhausted, reservation.ExtensionCount, db.MaxExtensions)
+	}
+
+	if err := db.extendLocked(reservation); err != nil {
+		return nil, err
+	}
+	reservation.ExtensionCount++
+	db.recordLocked(AuditEntry{Op: OpReservationRenew, ReservationID: reservationID, ExpiresAt: reservation.ExpiresAt})
+	return snapshotReservation(reservation), nil
+}
+
+// extendLocked moves an active reservation's expiry back by its hold period, cut short at
+// MaxHoldTime. The conference must still have the seats for every unexpired hold on it, since
+// a capacity cut can leave more held than is left to sell. Caller must hold write lock.
+func (db *Database) extendLocked(reservation *models.SeatReservation) error {
+	expires := reservation.ExpiresAt.Add(db.holdForReservationLocked(reservation))
+	if db.MaxHoldTime > 0 {
+		if limit := reservation.CreatedAt.Add(db.MaxHoldTime); expires.After(limit) {
+			expires = limit
+		}
+	}
+	if !expires.After(reservation.ExpiresAt) {
+		return ErrMaxHoldReached
+	}
+	conf, ok := db.Conferences[reservation.ConferenceID]
+	if !ok {
+		return ErrConferenceNotFound
+	}
+	if db.availableLocked(conf)-conf.ReservedHoldback < db.reservedForConferenceLocked(conf.ID) {
+		return ErrHoldOversold
+	}
+	if tier := findTier(conf, reservation.Tier); reservation.Tier != "" && (tier == nil || db.tierAvailableLocked(conf, tier) < reservation.TicketCount) {
+		return ErrHoldOversold
+	}
+	reservation.ExpiresAt = expires
 	return nil
 }
 
+// ExtensionsLeft is how many more times ExtendReservation will accept the reservation
+func (db *Database) ExtensionsLeft(reservation *models.SeatReservation) int {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	if left := db.MaxExtensions - reservation.ExtensionCount; left > 0 {
+		return left
+	}
+	return 0
+}
+
 // GetReservation gets a reservation by ID
 func (db *Database) GetReservation(reservationID string) (*models.SeatReservation, error) {
 	// Clean up expired reservations first with exclusive lock
@@ -380,10 +1283,10 @@ func (db *Database) GetReservation(reservationID string) (*models.SeatReservatio
 	
 	reservation, exists := db.Reservations[reservationID]
 	if !exists {
-		return nil, fmt.Errorf("reservation not found")
+		return nil, db.missingReservationErrLocked(reservationID)
 	}
 	
-	return reservation, nil
+	return snapshotReservation(reservation), nil
 }
 
 // GetUserReservations gets all active reservations for a user
@@ -397,36 +1300,388 @@ func (db *Database) GetUserReservations(userID string) []*models.SeatReservation
 	var reservations []*models.SeatReservation
 	for _, reservation := range db.Reservations {
 		if reservation.UserID == userID {
-			reservations = append(reservations, reservation)
+			reservations = append(reservations, snapshotReservation(reservation))
+		}
+	}
+	return reservations
+}
+
+// ReservationFilter narrows ListReservations; empty fields match everything
+type ReservationFilter struct {
+	ConferenceID string
+	UserID       string
+}
+
+// ListReservations returns the active reservations matching filter, soonest to expire first
+func (db *Database) ListReservations(filter ReservationFilter) []*models.SeatReservation {
+	// Clean up expired reservations first
+	db.cleanupExpiredReservations()
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	reservations := make([]*models.SeatReservation, 0)
+	for _, reservation := range db.Reservations {
+		if filter.ConferenceID != "" && reservation.ConferenceID != filter.ConferenceID {
+			continue
 		}
+		if filter.UserID != "" && reservation.UserID != filter.UserID {
+			continue
+		}
+		reservations = append(reservations, snapshotReservation(reservation))
 	}
+	sort.Slice(reservations, func(i, j int) bool {
+		a, b := reservations[i], reservations[j]
+		if !a.ExpiresAt.Equal(b.ExpiresAt) {
+			return a.ExpiresAt.Before(b.ExpiresAt)
+		}
+		return a.ID < b.ID
+	})
 	return reservations
 }
 
+// reservedForConferenceLocked sums the tickets held by a conference's unexpired reservations.
+// Every availability check goes through it so they all agree on what counts as held, even
+// before the janitor has removed lapsed holds. Caller must hold the lock.
+func (db *Database) reservedForConferenceLocked(conferenceID string) int {
+	now := db.now()
+	reserved := 0
+	for _, r := range db.Reservations {
+		if r.ConferenceID == conferenceID && now.Before(r.ExpiresAt) {
+			reserved += r.TicketCount
+		}
+	}
+	return reserved
+}
+
+// checkHeldCapLocked rejects a new hold of ticketCount when it would exceed the conference's
+// held-ticket cap; caller must hold the lock
+func (db *Database) checkHeldCapLocked(conf *models.Conference, reserved, ticketCount int) error {
+	limit := -1
+	if conf.MaxHeldTickets > 0 {
+		limit = conf.MaxHeldTickets
+	}
+	if conf.MaxHeldPercent > 0 {
+		byPercent := db.availableLocked(conf) * conf.MaxHeldPercent / 100
+		if limit < 0 || byPercent < limit {
+			limit = byPercent
+		}
+	}
+	if limit < 0 || reserved+ticketCount <= limit {
+		return nil
+	}
+
+	// Suggest retrying once the soonest active hold on this conference lapses
+	now := db.now()
+	var retryAfter time.Duration
+	for _, r := range db.Reservations {
+		if r.ConferenceID != conf.ID || !now.Before(r.ExpiresAt) {
+			continue
+		}
+		if wait := r.ExpiresAt.Sub(now); retryAfter == 0 || wait < retryAfter {
+			retryAfter = wait
+		}
+	}
+	return &HeldCapError{RetryAfter: retryAfter}
+}
+
 // cleanupExpiredReservations removes expired reservations (internal method)
 func (db *Database) cleanupExpiredReservations() {
-	db.mutex.Lock()
-	defer db.mutex.Unlock()
+	db.lock()
+	defer db.unlock()
 	db.cleanupExpiredReservationsLocked()
 }
 
-// cleanupExpiredReservationsLocked removes expired reservations; caller must hold write lock
+// cleanupExpiredReservationsLocked removes expired reservations; caller must hold write lock.
+// With shared expiry on they are left to SweepConference.
 func (db *Database) cleanupExpiredReservationsLocked() {
-	now := time.Now()
+	now := db.now()
+	if !db.sharedExpiry {
+		db.expireLapsedLocked("", now)
+	}
+	for id, expiredAt := range db.expired {
+		if now.Sub(expiredAt) > ExpiredTombstoneTTL {
+			delete(db.expired, id)
+		}
+	}
+	db.pruneIdempotencyKeysLocked(now)
+}
+
+// expireLapsedLocked expires the lapsed holds and queue turn of conferenceID, or of every
+// conference when it is empty, and promotes the queues that freed up; caller must hold write lock
+func (db *Database) expireLapsedLocked(conferenceID string, now time.Time) {
+	promote := make(map[string]bool)
+	for _, reservation := range db.Reservations {
+		if (conferenceID == "" || reservation.ConferenceID == conferenceID) && now.After(reservation.ExpiresAt) {
+			db.expireReservationLocked(reservation, now)
+			promote[reservation.ConferenceID] = true
+		}
+	}
+	for id, q := range db.WaitQueues {
+		if (conferenceID == "" || id == conferenceID) && len(q) > 0 && q[0].claimWindowLapsed(now) {
+			db.lapseHeadLocked(q[0], now)
+			promote[id] = true
+		}
+	}
+	for id := range promote {
+		db.promoteHeadLocked(id, now)
+	}
+}
+
+// SetSharedExpiry, when on, leaves lapsed holds and queue turns to SweepConference and to the
+// calls on the hold itself, instead of expiring them in every call that comes across one.
+// Instances sharing their state turn it on (see database/redisstore): each of them only holds
+// some conferences at a time, and a hold must be expired once, by whichever holds its conference.
+func (db *Database) SetSharedExpiry(on bool) {
+	db.lock()
+	db.sharedExpiry = on
+	db.unlock()
+}
+
+// SweepConference expires the conference's lapsed holds and queue turn and releases its holds
+// whose user no longer exists, offering the freed seats down the queue; what the janitor and the
+// orphan sweeper do across every conference
+func (db *Database) SweepConference(conferenceID string) {
+	db.lock()
+	defer db.unlock()
+
+	now := db.now()
+	db.expireLapsedLocked(conferenceID, now)
+	db.releaseOrphansLocked(conferenceID, now)
+}
+
+// LapsedConferences lists, sorted, the conferences SweepConference would change: those with a
+// lapsed hold or queue turn, or a hold whose user no longer exists
+func (db *Database) LapsedConferences() []string {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	now := db.now()
+	lapsed := make(map[string]bool)
+	for _, reservation := range db.Reservations {
+		if _, ok := db.Users[reservation.UserID]; !ok || now.After(reservation.ExpiresAt) {
+			lapsed[reservation.ConferenceID] = true
+		}
+	}
+	for id, q := range db.WaitQueues {
+		if len(q) > 0 && q[0].claimWindowLapsed(now) {
+			lapsed[id] = true
+		}
+	}
+	ids := make([]string, 0, len(lapsed))
+	for id := range lapsed {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// ReleaseAndPromote frees lapsed holds that were claimed from the conference's queue and
+// re-offers the seats to the next queue entry. It returns how many holds were released and the
+// entry now at the head (nil when the queue is empty).
+func (db *Database) ReleaseAndPromote(conferenceID string) (int, *WaitEntry) {
+	db.lock()
+	defer db.unlock()
+
+	now := db.now()
+	released := 0
+	for _, reservation := range db.Reservations {
+		if reservation.ConferenceID == conferenceID && reservation.Source == models.ReservationSourceQueue &&
+			now.After(reservation.ExpiresAt) {
+			db.expireReservationLocked(reservation, now)
+			released++
+		}
+	}
+	head := db.promoteHeadLocked(conferenceID, now)
+	if head == nil {
+		return released, nil
+	}
+	cp := *head
+	return released, &cp
+}
+
+// promoteHeadLocked tells the queue head that seats were freed: failures that happened while the
+// seats were held no longer count against it, and it has ClaimWindow to claim before it loses
+// its turn (see lapseHeadLocked).
+// A head still inside its window keeps it, and nobody is told while no seats are free. With
+// OfferWindow set the freed seats are offered instead (see offerSeatsLocked). Returns the head,
+// nil when the queue is empty. Caller must hold write lock.
+func (db *Database) promoteHeadLocked(conferenceID string, now time.Time) *WaitEntry {
+	if db.OfferWindow > 0 {
+		db.offerSeatsLocked(conferenceID, now)
+		if q := db.WaitQueues[conferenceID]; len(q) > 0 {
+			return q[0]
+		}
+		return nil
+	}
+	q := db.WaitQueues[conferenceID]
+	conf, ok := db.Conferences[conferenceID]
+	if len(q) == 0 || !ok {
+		return nil
+	}
+	head := q[0]
+	if now.Before(head.ClaimableUntil) {
+		return head
+	}
+	if db.queueRoomLocked(conf, head) < 1 {
+		return head
+	}
+	head.FailedClaims = 0
+	head.ClaimableUntil = now.Add(db.claimWindow())
+	db.recordLocked(AuditEntry{
+		Op: OpQueueNotify, At: now, UserID: head.UserID, ConferenceID: conferenceID,
+		EntryID: head.ID, TicketCount: head.TicketCount, ExpiresAt: head.ClaimableUntil,
+	})
+	db.logLocked(slog.LevelInfo, "Queue head may claim freed seats", "conference_id", conferenceID, "user_id", head.UserID, "claimable_until", head.ClaimableUntil)
+	return head
+}
+
+// claimWindow is how long a notified queue head has to claim
+func (db *Database) claimWindow() time.Duration {
+	if db.ClaimWindow > 0 {
+		return db.ClaimWindow
+	}
+	return DefaultClaimWindow
+}
+
+// lapseHeadLocked takes the turn from a queue head that let its claim window pass: it goes to
+// the back of the queue while it has requeues left (MaxClaimRequeues), otherwise it is dropped.
+// Reports whether it was dropped; caller must hold write lock.
+func (db *Database) lapseHeadLocked(entry *WaitEntry, now time.Time) bool {
+	if entry.MissedClaims < db.MaxClaimRequeues {
+		db.requeueLocked(entry)
+		db.recordLocked(AuditEntry{Op: OpQueueRequeue, At: now, UserID: entry.UserID, ConferenceID: entry.ConferenceID, EntryID: entry.ID})
+		db.logLocked(slog.LevelInfo, "Queue head missed the claim window; moved to the back", "conference_id", entry.ConferenceID,
+			"user_id", entry.UserID, "missed_claims", entry.MissedClaims)
+		return false
+	}
+	db.removeQueueEntryLocked(entry.ConferenceID, entry.UserID)
+	db.recordLocked(AuditEntry{Op: OpQueueDrop, UserID: entry.UserID, ConferenceID: entry.ConferenceID, EntryID: entry.ID})
+	db.logLocked(slog.LevelInfo, "Queue head missed the claim window", "conference_id", entry.ConferenceID, "user_id", entry.UserID)
+	return true
+}
+
+// requeueLocked moves a queue entry to the back of its priority class with its window closed
+// and a missed claim counted; caller must hold write lock
+func (db *Database) requeueLocked(entry *WaitEntry) {
+	db.removeQueueEntryLocked(entry.ConferenceID, entry.UserID)
+	entry.MissedClaims++
+	entry.FailedClaims = 0
+	entry.ClaimableUntil = time.Time{}
+	db.insertQueueEntryLocked(entry)
+}
+
+// SweepOrphanedReservations releases active reservations held by users that no longer exist
+// and returns how many were released. Expired holds are left to the normal expiry path.
+func (db *Database) SweepOrphanedReservations() int {
+	db.lock()
+	defer db.unlock()
+	return db.releaseOrphansLocked("", db.now())
+}
+
+// releaseOrphansLocked releases the unexpired holds of conferenceID, or of every conference when
+// it is empty, whose user no longer exists and returns how many; caller must hold write lock
+func (db *Database) releaseOrphansLocked(conferenceID string, now time.Time) int {
+	released := 0
 	for id, reservation := range db.Reservations {
-		if now.After(reservation.ExpiresAt) {
-			delete(db.Reservations, id)
+		if conferenceID != "" && reservation.ConferenceID != conferenceID || now.After(reservation.ExpiresAt) {
+			continue
+		}
+		if _, ok := db.Users[reservation.UserID]; ok {
+			continue
+		}
+		db.retireReservationLocked(reservation, models.ReservationStatusCancelled)
+		db.recordLocked(AuditEntry{Op: OpReservationCancel, At: now, ReservationID: id, UserID: reservation.UserID,
+			ConferenceID: reservation.ConferenceID, Actor: ActorSystem})
+		slog.Info("Released orphaned reservation: its user no longer exists", "reservation_id", id,
+			"ticket_count", reservation.TicketCount, "conference_id", reservation.ConferenceID, "user_id", reservation.UserID)
+		db.promoteHeadLocked(reservation.ConferenceID, now)
+		released++
+	}
+	return released
+}
+
+// StartOrphanSweeper runs SweepOrphanedReservations every interval until the returned stop func
+// or Close is called
+func (db *Database) StartOrphanSweeper(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				db.SweepOrphanedReservations()
+			case <-done:
+				return
+			case <-db.done:
+				return
+			}
 		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// expireReservationLocked drops an expired reservation and leaves a tombstone; caller must hold write lock.
+// The entry names the user, conference and seats so the expiry event says what was freed.
+func (db *Database) expireReservationLocked(reservation *models.SeatReservation, now time.Time) {
+	offered := reservation.Status == models.ReservationStatusOffered
+	db.recordTurnoverLocked(reservation, reservation.ExpiresAt)
+	db.retireReservationLocked(reservation, models.ReservationStatusExpired)
+	db.expired[reservation.ID] = reservation.ExpiresAt
+	db.recordLocked(AuditEntry{Op: OpReservationExpire, At: now, ReservationID: reservation.ID,
+		UserID: reservation.UserID, ConferenceID: reservation.ConferenceID, TicketCount: reservation.TicketCount})
+	if offered {
+		db.requeueLapsedOfferLocked(reservation, now)
+	}
+}
+
+// holdFor is how long a new reservation for conf holds seats
+func (db *Database) holdFor(conf *models.Conference) time.Duration {
+	if hold := conf.HoldDuration(); hold > 0 {
+		return hold
+	}
+	if db.HoldTime > 0 {
+		return db.HoldTime
+	}
+	return ReservationHold
+}
+
+// holdForReservationLocked is the hold period an extension adds to reservation: the one it was
+// created with, or its conference's current hold for reservations restored from before holds
+// were recorded; caller must hold the lock
+func (db *Database) holdForReservationLocked(reservation *models.SeatReservation) time.Duration {
+	if reservation.HoldSeconds > 0 {
+		return time.Duration(reservation.HoldSeconds) * time.Second
 	}
+	if conf, ok := db.Conferences[reservation.ConferenceID]; ok {
+		return db.holdFor(conf)
+	}
+	return ReservationHold
+}
+
+// holdSeconds rounds a hold period up to whole seconds for HoldSeconds
+func holdSeconds(hold time.Duration) int {
+	return int((hold + time.Second - 1) / time.Second)
+}
+
+// missingReservationErrLocked distinguishes a recently expired reservation from an unknown one;
+// caller must hold the lock
+func (db *Database) missingReservationErrLocked(reservationID string) error {
+	if expiredAt, ok := db.expired[reservationID]; ok {
+		return &ReservationExpiredError{ExpiredAt: expiredAt}
+	}
+	return ErrReservationNotFound
 }
 
 // GetUserByEmail returns a user by email (case-insensitive)
 func (db *Database) GetUserByEmail(email string) (*models.User, bool) {
 	db.mutex.RLock()
 	defer db.mutex.RUnlock()
-	norm := strings.ToLower(strings.TrimSpace(email))
+	norm := normalizeEmail(email)
 	for _, u := range db.Users {
-		if strings.ToLower(strings.TrimSpace(u.Email)) == norm {
+		if normalizeEmail(u.Email) == norm {
 			return u, true
 		}
 	}
@@ -435,38 +1690,167 @@ func (db *Database) GetUserByEmail(email string) (*models.User, bool) {
 
 
 
-// GetConferenceStats returns reserved count and queue length per conference
-func (db *Database) GetConferenceStats() map[string]struct{ Reserved int; Queue int } {
+// ConferenceStats is a conference's live reservation and queue load
+type ConferenceStats struct {
+	Reserved int
+	Queue    int
+	QueueCap int // most entries the queue accepts; 0 means unlimited
+}
+
+// GetConferenceStats returns reserved count, queue length and queue cap per conference
+func (db *Database) GetConferenceStats() map[string]ConferenceStats {
 	db.mutex.RLock()
 	defer db.mutex.RUnlock()
-	// compute reserved counts ignoring expired
-	now := time.Now()
-	stats := make(map[string]struct{ Reserved int; Queue int })
-	for id := range db.Conferences {
-		stats[id] = struct{ Reserved int; Queue int }{Reserved: 0, Queue: len(db.WaitQueues[id])}
+	stats := make(map[string]ConferenceStats)
+	for id, conf := range db.Conferences {
+		stats[id] = ConferenceStats{
+			Reserved: db.reservedForConferenceLocked(id),
+			Queue:    len(db.WaitQueues[id]),
+			QueueCap: db.queueCapLocked(conf),
+		}
+	}
+	return stats
+}
+
+// queueCapLocked is how many entries a conference's queue may hold, or 0 for no cap: every
+// unsold seat allows QueueCapFactor waiters and every sold seat one fewer, bounded by
+// MaxQueueLength. Caller must hold the lock.
+func (db *Database) queueCapLocked(conf *models.Conference) int {
+	limit := 0
+	if db.QueueCapFactor > 0 {
+		sold := conf.TotalTickets - db.availableLocked(conf)
+		limit = db.QueueCapFactor*conf.TotalTickets - sold
+		if limit < 1 {
+			limit = 1
+		}
+	}
+	if db.MaxQueueLength > 0 && (limit == 0 || limit > db.MaxQueueLength) {
+		limit = db.MaxQueueLength
+	}
+	return limit
+}
+
+// checkQueueRoomLocked rejects a new queue entry once the conference's queue is at its cap;
+// users already queued may still update their request. Caller must hold the lock.
+func (db *Database) checkQueueRoomLocked(userID string, conf *models.Conference) error {
+	limit := db.queueCapLocked(conf)
+	q := db.WaitQueues[conf.ID]
+	if limit == 0 || len(q) < limit {
+		return nil
+	}
+	for _, e := range q {
+		if e.UserID == userID {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w (%d waiting)", ErrQueueFull, len(q))
+}
+
+// EnqueueWait adds a user to the conference wait queue and returns the 1-based position.
+// The request counts against the per-user caps like a booking would. With a tier the user
+// waits for seats in that tier and is offered them at its price; the queue stays first come,
+// first served across tiers. The entry gets the user's QueuePriority class.
+func (db *Database) EnqueueWait(userID, conferenceID string, ticketCount int, tier string) (int, error) {
+	db.lock()
+	defer db.unlock()
+	conference, exists := db.Conferences[conferenceID]
+	if !exists {
+		return 0, ErrConferenceNotFound
+	}
+	if err := checkUpcoming(conference, db.now()); err != nil {
+		return 0, err
+	}
+	if err := db.checkNoSeatsLocked(userID, conferenceID); err != nil {
+		return 0, err
+	}
+	if err := db.checkUserCapLocked(userID, conferenceID, ticketCount); err != nil {
+		return 0, err
+	}
+	if err := db.checkQueueRoomLocked(userID, conference); err != nil {
+		return 0, err
+	}
+	tierName, err := queueTier(conference, tier)
+	if err != nil {
+		return 0, err
 	}
+	priority := 0
+	if user, ok := db.Users[userID]; ok {
+		priority = user.QueuePriority
+	}
+	return db.enqueueLocked(userID, conferenceID, ticketCount, tierName, priority), nil
+}
+
+// checkNoSeatsLocked rejects users who already hold a confirmed booking or an unexpired
+// reservation for the conference, so the queue cannot hand them a second allocation.
+// Caller must hold the lock.
+func (db *Database) checkNoSeatsLocked(userID, conferenceID string) error {
+	for _, b := range db.Bookings {
+		if b.UserID == userID && b.ConferenceID == conferenceID && b.Status == "confirmed" {
+			return fmt.Errorf("%w: booking %s", ErrAlreadyHasSeats, b.ID)
+		}
+	}
+	now := db.now()
 	for _, r := range db.Reservations {
-		if now.Before(r.ExpiresAt) {
-			s := stats[r.ConferenceID]
-			s.Reserved += r.TicketCount
-			stats[r.ConferenceID] = s
+		if r.UserID == userID && r.ConferenceID == conferenceID && now.Before(r.ExpiresAt) {
+			return fmt.Errorf("%w: reservation %s", ErrAlreadyHasSeats, r.ID)
 		}
 	}
-	return stats
+	return nil
+}
+
+// QueueRequest is one user's entry in a bulk enqueue
+type QueueRequest struct {
+	UserID      string `json:"user_id" binding:"required"`
+	TicketCount int    `json:"ticket_count" binding:"required,min=1"`
+	Tier        string `json:"tier"` // optional; empty waits for the aggregate pool
+	Priority    int    `json:"priority" binding:"min=0,max=9"` // see WaitEntry.Priority; 0 is general
+}
+
+// BulkEnqueue appends the requests to a conference queue in order under a single lock,
+// deduplicating users like EnqueueWait, and returns each resulting 1-based position. Entries
+// with a Priority join behind the others of their class but ahead of every lower one; it is
+// the only way to give an entry one.
+func (db *Database) BulkEnqueue(conferenceID string, requests []QueueRequest) ([]int, error) {
+	db.lock()
+	defer db.unlock()
+	conf, ok := db.Conferences[conferenceID]
+	if !ok {
+		return nil, ErrConferenceNotFound
+	}
+	tiers := make([]string, len(requests))
+	for i, r := range requests {
+		if r.Priority < 0 || r.Priority > MaxQueuePriority {
+			return nil, fmt.Errorf("queue priority must be between 0 and %d", MaxQueuePriority)
+		}
+		tier, err := queueTier(conf, r.Tier)
+		if err != nil {
+			return nil, err
+		}
+		tiers[i] = tier
+	}
+	positions := make([]int, len(requests))
+	for i, r := range requests {
+		positions[i] = db.enqueueLocked(r.UserID, conferenceID, r.TicketCount, tiers[i], r.Priority)
+	}
+	return positions, nil
 }
 
-// EnqueueWait adds a user to the conference wait queue, returns 1-based position
-func (db *Database) EnqueueWait(userID, conferenceID string, ticketCount int) int {
-	db.mutex.Lock()
-	defer db.mutex.Unlock()
+// enqueueLocked adds or updates a queue entry and returns its position. An entry already
+// queued keeps its place unless priority raises its class; it is never lowered. Caller must
+// hold write lock.
+func (db *Database) enqueueLocked(userID, conferenceID string, ticketCount int, tier string, priority int) int {
 	q := db.WaitQueues[conferenceID]
 	// avoid duplicate entries for same user+conference; keep earliest
 	for i, e := range q {
 		if e.UserID == userID {
-			// update ticketCount to latest request
-			q[i].TicketCount = ticketCount
-			db.WaitQueues[conferenceID] = q
-			return i + 1
+			// update ticketCount and tier to latest request
+			q[i].TicketCount, q[i].Tier = ticketCount, tier
+			position := i + 1
+			if priority > e.Priority {
+				position = db.raisePriorityLocked(e, priority)
+			}
+			db.recordLocked(AuditEntry{Op: OpQueueEnqueue, UserID: userID, ConferenceID: conferenceID, EntryID: e.ID, TicketCount: ticketCount, Tier: tier, Priority: e.Priority})
+			return position
 		}
 	}
 	entry := &WaitEntry{
@@ -474,64 +1858,226 @@ func (db *Database) EnqueueWait(userID, conferenceID string, ticketCount int) in
 		UserID:       userID,
 		ConferenceID: conferenceID,
 		TicketCount:  ticketCount,
-		EnqueuedAt:   time.Now(),
+		Tier:         tier,
+		Priority:     priority,
+		EnqueuedAt:   db.now(),
+	}
+	position := db.insertQueueEntryLocked(entry)
+	db.recordLocked(AuditEntry{
+		Op: OpQueueEnqueue, At: entry.EnqueuedAt, UserID: userID, ConferenceID: conferenceID,
+		EntryID: entry.ID, TicketCount: ticketCount, Tier: tier, Priority: priority,
+	})
+	return position
+}
+
+// insertQueueEntryLocked puts an entry at the back of its priority class, ahead of every
+// lower one, and returns its position. A head that was told it may claim keeps its turn
+// whatever joins. Caller must hold write lock.
+func (db *Database) insertQueueEntryLocked(entry *WaitEntry) int {
+	q := db.WaitQueues[entry.ConferenceID]
+	i := len(q)
+	for i > 0 && q[i-1].Priority < entry.Priority {
+		i--
+	}
+	if i == 0 && len(q) > 0 && !q[0].ClaimableUntil.IsZero() {
+		i = 1
 	}
-	q = append(q, entry)
-	db.WaitQueues[conferenceID] = q
-	return len(q)
+	q = append(q, nil)
+	copy(q[i+1:], q[i:])
+	q[i] = entry
+	db.WaitQueues[entry.ConferenceID] = q
+	return i + 1
 }
 
-// GetQueuePosition returns 1-based position, or 0 if not present
-func (db *Database) GetQueuePosition(userID, conferenceID string) int {
+// raisePriorityLocked moves a queued entry up into a higher class, at the back of it, and
+// returns its new position; caller must hold write lock
+func (db *Database) raisePriorityLocked(entry *WaitEntry, priority int) int {
+	q := db.WaitQueues[entry.ConferenceID]
+	if len(q) > 0 && q[0] == entry && !entry.ClaimableUntil.IsZero() {
+		entry.Priority = priority // already being served
+		return 1
+	}
+	db.removeQueueEntryLocked(entry.ConferenceID, entry.UserID)
+	entry.Priority = priority
+	return db.insertQueueEntryLocked(entry)
+}
+
+// DequeueWait removes a user from a conference wait queue wherever they are, reporting whether
+// an entry was removed; everyone behind them moves up one place. When the head leaves, the new
+// head is told about any seats that are free rather than waiting for more to be freed.
+func (db *Database) DequeueWait(userID, conferenceID string) bool {
+	db.lock()
+	defer db.unlock()
+	q := db.WaitQueues[conferenceID]
+	wasHead := len(q) > 0 && q[0].UserID == userID
+	if !db.removeQueueEntryLocked(conferenceID, userID) {
+		return false
+	}
+	db.recordLocked(AuditEntry{Op: OpQueueLeave, UserID: userID, ConferenceID: conferenceID})
+	if wasHead {
+		db.promoteHeadLocked(conferenceID, db.now())
+	}
+	return true
+}
+
+// QueueStatus describes a user's place in a wait queue; Position 0 means not queued
+type QueueStatus struct {
+	Position       int        `json:"position"`     // 1-based
+	TicketCount    int        `json:"ticket_count"` // tickets the entry currently asks for
+	Tier           string     `json:"tier,omitempty"`
+	AheadCount     int        `json:"ahead_count"`  // tickets requested by the entries in front
+	// EstimatedWaitSeconds is AheadCount times the conference's average per-seat turnover
+	EstimatedWaitSeconds int `json:"estimated_wait_seconds"`
+	Claimable      bool       `json:"claimable"`    // at the head and not past a claim window
+	ClaimableUntil *time.Time `json:"claimable_until,omitempty"`
+	MissedClaims   int        `json:"missed_claims,omitempty"` // times moved to the back for letting the window pass
+	Priority       int        `json:"priority,omitempty"`
+	// Offer is the seats the queue handed the user, once they have left it; see Database.OfferWindow
+	Offer *models.SeatReservation `json:"offer,omitempty"`
+}
+
+// GetQueuePosition reports the user's queue status; Position is zero if not present, with
+// Offer set when the user left the queue with a pending offer
+func (db *Database) GetQueuePosition(userID, conferenceID string) QueueStatus {
 	db.mutex.RLock()
 	defer db.mutex.RUnlock()
-	q := db.WaitQueues[conferenceID]
-	for i, e := range q {
+	ahead := 0
+	for i, e := range db.WaitQueues[conferenceID] {
 		if e.UserID == userID {
-			return i + 1
+			status := QueueStatus{Position: i + 1, TicketCount: e.TicketCount, Tier: e.Tier, AheadCount: ahead, MissedClaims: e.MissedClaims, Priority: e.Priority}
+			status.EstimatedWaitSeconds = db.estimateWaitLocked(conferenceID, ahead)
+			status.Claimable = i == 0 && !e.claimWindowLapsed(db.now())
+			if !e.ClaimableUntil.IsZero() {
+				until := e.ClaimableUntil
+				status.ClaimableUntil = &until
+			}
+			return status
 		}
+		ahead += e.TicketCount
 	}
-	return 0
+	return QueueStatus{Offer: snapshotReservation(db.pendingOfferLocked(userID, conferenceID))}
+}
+
+// ListQueue returns copies of a conference's wait queue entries, head first
+func (db *Database) ListQueue(conferenceID string) ([]WaitEntry, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	if _, ok := db.Conferences[conferenceID]; !ok {
+		return nil, ErrConferenceNotFound
+	}
+	q := db.WaitQueues[conferenceID]
+	entries := make([]WaitEntry, 0, len(q))
+	for _, e := range q {
+		entries = append(entries, *e)
+	}
+	return entries, nil
 }
 
 // ClaimNext attempts to create a reservation for the first-in-queue user if they are the caller.
-func (db *Database) ClaimNext(userID, conferenceID string) (*models.SeatReservation, error) {
-	db.mutex.Lock()
-	defer db.mutex.Unlock()
+// With partial set and fewer seats free than requested (but at least one), it holds what is free
+// and re-queues the shortfall at the back; the reservation's TicketCount is what was granted.
+// A head that was told seats were freed must claim before its ClaimableUntil or it loses its
+// turn: moved to the back (ErrNotYourTurn) or dropped (ErrDroppedFromQueue), see lapseHeadLocked.
+func (db *Database) ClaimNext(userID, conferenceID string, partial bool) (*models.SeatReservation, error) {
+	db.lock()
+	defer db.unlock()
+	if q := db.WaitQueues[conferenceID]; len(q) > 0 && q[0].UserID == userID && q[0].claimWindowLapsed(db.now()) {
+		closed := q[0].ClaimableUntil
+		dropped := db.lapseHeadLocked(q[0], db.now())
+		db.promoteHeadLocked(conferenceID, db.now())
+		if !dropped {
+			return nil, fmt.Errorf("claim window closed at %s and you were moved to the back of the queue; %w", closed.Format(time.RFC3339), ErrNotYourTurn)
+		}
+		return nil, fmt.Errorf("claim window closed at %s; %w", closed.Format(time.RFC3339), ErrDroppedFromQueue)
+	}
 	db.cleanupExpiredReservationsLocked()
 	q := db.WaitQueues[conferenceID]
 	if len(q) == 0 || q[0].UserID != userID {
-		return nil, fmt.Errorf("not your turn yet")
+		return nil, ErrNotYourTurn
 	}
 	conf, ok := db.Conferences[conferenceID]
 	if !ok {
-		return nil, fmt.Errorf("conference not found")
+		return nil, ErrConferenceNotFound
 	}
-	// compute currently reserved for this conf
-	reserved := 0
-	now := time.Now()
-	for _, r := range db.Reservations {
-		if r.ConferenceID == conferenceID && now.Before(r.ExpiresAt) {
-			reserved += r.TicketCount
-		}
+	if err := checkUpcoming(conf, db.now()); err != nil {
+		return nil, err
+	}
+	_, price, err := pickTier(conf, q[0].Tier)
+	if err != nil {
+		return nil, db.failClaimLocked(q[0], err)
 	}
-	available := conf.AvailableTickets - reserved
+	// compute currently reserved for this conf
+	reserved := db.reservedForConferenceLocked(conferenceID)
+	available := db.queueRoomLocked(conf, q[0])
 	need := q[0].TicketCount
 	if available < need {
-		return nil, fmt.Errorf("not enough tickets available")
+		if !partial || available < 1 {
+			return nil, db.failClaimLocked(q[0], ErrSoldOut)
+		}
+		need = available
+	}
+	shortfall, tier, priority := q[0].TicketCount-need, q[0].Tier, q[0].Priority
+	if err := db.checkHeldCapLocked(conf, reserved, need); err != nil {
+		return nil, err
+	}
+	if err := db.checkUserCapLocked(userID, conferenceID, need); err != nil {
+		return nil, db.failClaimLocked(q[0], err)
+	}
+	seats, err := db.pickSeatsLocked(conf, need, nil)
+	if err != nil {
+		return nil, db.failClaimLocked(q[0], err)
 	}
 	// create reservation
+	now, hold := db.now(), db.holdFor(conf)
 	res := &models.SeatReservation{
 		ID:           uuid.New().String(),
 		UserID:       userID,
 		ConferenceID: conferenceID,
 		TicketCount:  need,
-		TotalAmount:  conf.Price * float64(need),
-		ExpiresAt:    time.Now().Add(15 * time.Second),
-		CreatedAt:    time.Now(),
+		TotalAmount:  price * float64(need),
+		ExpiresAt:    now.Add(hold),
+		CreatedAt:    now,
+		Source:       models.ReservationSourceQueue,
+		Status:       models.ReservationStatusActive,
+		Tier:         tier,
+		HoldSeconds:  holdSeconds(hold),
+		SeatIDs:      seats,
 	}
 	db.Reservations[res.ID] = res
 	// pop queue head
 	db.WaitQueues[conferenceID] = q[1:]
-	return res, nil
-}
\ No newline at end of file
+	db.recordLocked(AuditEntry{
+		Op: OpQueueClaim, At: res.CreatedAt, UserID: userID, ConferenceID: conferenceID,
+		ReservationID: res.ID, TicketCount: need, Amount: res.TotalAmount, ExpiresAt: res.ExpiresAt, HoldSeconds: res.HoldSeconds,
+		Tier: tier, SeatIDs: seats,
+	})
+	if shortfall > 0 {
+		db.enqueueLocked(userID, conferenceID, shortfall, tier, priority)
+	}
+	return snapshotReservation(res), nil
+}
+
+// failClaimLocked counts a failed claim by the queue head and drops the entry once it reaches
+// MaxFailedClaims; caller must hold write lock
+func (db *Database) failClaimLocked(entry *WaitEntry, cause error) error {
+	entry.FailedClaims++
+	if db.MaxFailedClaims <= 0 || entry.FailedClaims < db.MaxFailedClaims {
+		return cause
+	}
+	db.removeQueueEntryLocked(entry.ConferenceID, entry.UserID)
+	db.recordLocked(AuditEntry{Op: OpQueueDrop, UserID: entry.UserID, ConferenceID: entry.ConferenceID, EntryID: entry.ID})
+	return fmt.Errorf("%v; %w after %d failed claims", cause, ErrDroppedFromQueue, entry.FailedClaims)
+}
+
+// removeQueueEntryLocked removes a user's entry from a conference queue, reporting whether
+// one was found; caller must hold write lock
+func (db *Database) removeQueueEntryLocked(conferenceID, userID string) bool {
+	q := db.WaitQueues[conferenceID]
+	for i, e := range q {
+		if e.UserID == userID {
+			db.WaitQueues[conferenceID] = append(q[:i:i], q[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
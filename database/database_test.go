@@ -1,13 +1,39 @@
 package database
 
 import (
+	"booking-system/config"
 	"booking-system/models"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
-// helper to build DB with a user and conference
-func makeDBWithUserAndConf() (*Database, *models.User, *models.Conference) {
+// newTestDB builds a DB whose background janitor stops when the test ends
+func newTestDB(t *testing.T) *Database {
+	t.Helper()
 	db := NewDatabase()
+	t.Cleanup(db.Close)
+	return db
+}
+
+// helper to build DB with a user and conference
+// setAvailable stands in for conf having been loaded with only n seats left, as from a snapshot
+func setAvailable(db *Database, conf *models.Conference, n int) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	conf.AvailableTickets = n
+	db.openLedgerLocked()
+}
+
+func makeDBWithUserAndConf(t *testing.T) (*Database, *models.User, *models.Conference) {
+	db := newTestDB(t)
 	u, _ := db.CreateUser("Alice", "alice@example.com")
 	var conf *models.Conference
 	for _, c := range db.Conferences {
@@ -27,7 +53,7 @@ func makeDBWithUserAndConf() (*Database, *models.User, *models.Conference) {
 }
 
 func TestUserEmailUniqueness(t *testing.T) {
-	db := NewDatabase()
+	db := newTestDB(t)
 	_, err := db.CreateUser("A", "Test@Example.com")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
@@ -39,14 +65,2932 @@ func TestUserEmailUniqueness(t *testing.T) {
 }
 
 func TestSingleActiveReservationPerUserPerConference(t *testing.T) {
-	db, user, conf := makeDBWithUserAndConf()
+	db, user, conf := makeDBWithUserAndConf(t)
 	// first reservation should succeed
-	res1, err := db.CreateReservation(user.ID, conf.ID, 1)
+	res1, err := db.CreateReservation(user.ID, conf.ID, 1, ReservationOptions{})
 	if err != nil || res1 == nil {
 		t.Fatalf("expected first reservation ok, got err=%v", err)
 	}
 	// second reservation for same conference should fail while first alive
-	if _, err := db.CreateReservation(user.ID, conf.ID, 1); err == nil {
+	if _, err := db.CreateReservation(user.ID, conf.ID, 1, ReservationOptions{}); err == nil {
 		t.Fatalf("expected error for duplicate active reservation")
 	}
 }
+
+func TestGetAllBookingsDateRange(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	inside, err := db.CreateBooking(user.ID, conf.ID, 1, BookingOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	outside, err := db.CreateBooking(user.ID, conf.ID, 1, BookingOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	now := time.Now()
+	db.Bookings[inside.ID].BookedAt = now.Add(-12 * time.Hour)
+	db.Bookings[outside.ID].BookedAt = now.Add(-72 * time.Hour)
+
+	got, _ := db.GetAllBookings(BookingFilter{From: now.Add(-24 * time.Hour), To: now}, 0, 0)
+	if len(got) != 1 {
+		t.Fatalf("expected 1 booking in range, got %d", len(got))
+	}
+	if b := got[0]["booking"].(*models.Booking); b.ID != inside.ID {
+		t.Fatalf("expected booking %s, got %s", inside.ID, b.ID)
+	}
+}
+
+func TestGetAllBookingsPagination(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	base := time.Now()
+	var ids []string
+	for i := 0; i < 5; i++ {
+		b, err := db.CreateBooking(user.ID, conf.ID, 1, BookingOptions{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		db.Bookings[b.ID].BookedAt = base.Add(-time.Duration(i) * time.Minute) // ids[0] is the newest
+		ids = append(ids, b.ID)
+	}
+
+	page, total := db.GetAllBookings(BookingFilter{}, 2, 1)
+	if total != 5 || len(page) != 2 {
+		t.Fatalf("expected 2 of 5, got %d of %d", len(page), total)
+	}
+	for i, row := range page {
+		if b := row["booking"].(*models.Booking); b.ID != ids[i+1] {
+			t.Fatalf("row %d: expected %s, got %s", i, ids[i+1], b.ID)
+		}
+	}
+	if page, total := db.GetAllBookings(BookingFilter{}, 2, 10); len(page) != 0 || total != 5 {
+		t.Fatalf("expected an empty page past the end, got %d of %d", len(page), total)
+	}
+	mine, total := db.GetUserBookings(user.ID, 10, 3)
+	if total != 5 || len(mine) != 2 || mine[0].ID != ids[3] {
+		t.Fatalf("expected the last two user bookings, got %d of %d", len(mine), total)
+	}
+}
+
+func TestReadsReturnCopiesThatLaterWritesLeaveAlone(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	res, err := db.CreateReservation(user.ID, conf.ID, 1, ReservationOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	read, _ := db.GetReservation(res.ID)
+	listed := db.GetUserReservations(user.ID)
+
+	// Reading the copies while the hold is extended must not race (go test -race)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			db.ExtendReservation(res.ID)
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		_ = read.ExpiresAt.String() + listed[0].ExpiresAt.String()
+	}
+	wg.Wait()
+	if !read.ExpiresAt.Equal(res.ExpiresAt) || read.ExtensionCount != 0 {
+		t.Fatalf("expected the copy read before the extensions to keep its expiry, got %+v", read)
+	}
+
+	booking, err := db.ConfirmReservation(res.ID, BookingOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fetched := db.GetBooking(booking.ID)
+	if _, err := db.CancelBooking(booking.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if booking.Status != "confirmed" || fetched.Status != "confirmed" || db.GetBooking(booking.ID).Status != "cancelled" {
+		t.Fatalf("expected earlier copies to stay confirmed and a new read to see the cancellation")
+	}
+}
+
+func TestResetDuringReservationTraffic(t *testing.T) {
+	db := newTestDB(t)
+	var wg sync.WaitGroup
+
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				u, err := db.CreateUser("load", fmt.Sprintf("load-%d-%d@example.com", w, i))
+				if err != nil {
+					continue
+				}
+				res, err := db.CreateReservation(u.ID, "conf-1", 2, ReservationOptions{})
+				if err != nil {
+					continue
+				}
+				if i%2 == 0 {
+					db.ConfirmReservation(res.ID, BookingOptions{})
+				} else {
+					db.CancelReservation(res.ID)
+				}
+			}
+		}(w)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for resetting := true; resetting; {
+		select {
+		case <-done:
+			resetting = false
+		default:
+			db.ResetDatabase()
+			for _, c := range db.GetAllConferences() {
+				if c.AvailableTickets < 0 {
+					t.Errorf("conference %s has negative availability %d", c.ID, c.AvailableTickets)
+				}
+			}
+		}
+	}
+
+	for _, c := range db.GetAllConferences() {
+		if c.AvailableTickets < 0 || c.AvailableTickets > c.TotalTickets {
+			t.Fatalf("conference %s availability out of range: %d", c.ID, c.AvailableTickets)
+		}
+	}
+}
+
+func TestHeldCapBelowRawAvailability(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	conf.MaxHeldPercent = 50 // 50 of 100 available may be held at once
+	db.MaxTicketsPerUser = 0
+	other, _ := db.CreateUser("Bob", "bob@example.com")
+
+	if _, err := db.CreateReservation(user.ID, conf.ID, 40, ReservationOptions{}); err != nil {
+		t.Fatalf("expected first hold ok, got %v", err)
+	}
+	_, err := db.CreateReservation(other.ID, conf.ID, 20, ReservationOptions{})
+	if !errors.Is(err, ErrTooManyHeld) {
+		t.Fatalf("expected ErrTooManyHeld with 60 raw seats left, got %v", err)
+	}
+	var capErr *HeldCapError
+	if !errors.As(err, &capErr) || capErr.RetryAfter <= 0 {
+		t.Fatalf("expected a positive retry hint, got %v", err)
+	}
+	if _, err := db.CreateReservation(other.ID, conf.ID, 10, ReservationOptions{}); err != nil {
+		t.Fatalf("expected hold within cap ok, got %v", err)
+	}
+}
+
+func TestReplayAuditReproducesConfirmedBooking(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	res, err := db.CreateReservation(user.ID, conf.ID, 3, ReservationOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	booking, err := db.ConfirmReservation(res.ID, BookingOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	replayed := newTestDB(t)
+	if err := replayed.ReplayAudit(db.GetAuditLog()); err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	got := replayed.GetBooking(booking.ID)
+	if got == nil {
+		t.Fatalf("booking %s missing after replay", booking.ID)
+	}
+	if got.UserID != booking.UserID || got.TicketsBooked != 3 || got.TotalAmount != booking.TotalAmount {
+		t.Fatalf("replayed booking differs: %+v vs %+v", got, booking)
+	}
+	if drift := db.Drift(replayed); len(drift) != 0 {
+		t.Fatalf("expected no drift, got %v", drift)
+	}
+}
+
+func TestExtendReservationOnce(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	res, err := db.CreateReservation(user.ID, conf.ID, 1, ReservationOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := db.ExtendReservationOnce(res.ID); !errors.Is(err, ErrNotInExtendWindow) || !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected a conflict outside the final window, got %v", err)
+	}
+
+	db.Reservations[res.ID].ExpiresAt = time.Now().Add(2 * time.Second)
+	extended, err := db.ExtendReservationOnce(res.ID)
+	if err != nil {
+		t.Fatalf("expected first extension granted, got %v", err)
+	}
+	if !extended.Extended || time.Until(extended.ExpiresAt) <= ReservationHold {
+		t.Fatalf("expected a full extra hold period, expires in %v", time.Until(extended.ExpiresAt))
+	}
+
+	db.Reservations[res.ID].ExpiresAt = time.Now().Add(2 * time.Second)
+	if _, err := db.ExtendReservationOnce(res.ID); !errors.Is(err, ErrAlreadyExtended) || !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected a conflict for a second extension, got %v", err)
+	}
+}
+
+func writeFixture(t *testing.T, dir, name, body string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+		t.Fatalf("write %s: %v", name, err)
+	}
+}
+
+func TestLoadFixturesConsistency(t *testing.T) {
+	dir := t.TempDir()
+	writeFixture(t, dir, "conferences.json", `[{"id":"demo-1","name":"Demo","location":"Berlin","total_tickets":10,"price":50}]`)
+	writeFixture(t, dir, "users.json", `[{"id":"u-1","name":"Ann","email":"Ann@Example.com"}]`)
+	writeFixture(t, dir, "bookings.json", `[{"id":"b-1","user_id":"u-1","conference_id":"demo-1","tickets_booked":3}]`)
+
+	fx, err := LoadFixtures(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	db := newTestDB(t)
+	db.UseFixtures(fx)
+
+	conf, err := db.GetConference("demo-1")
+	if err != nil {
+		t.Fatalf("fixture conference missing: %v", err)
+	}
+	if conf.AvailableTickets != 7 {
+		t.Fatalf("expected 7 available after 3 booked, got %d", conf.AvailableTickets)
+	}
+	if b := db.GetBooking("b-1"); b == nil || b.TotalAmount != 150 {
+		t.Fatalf("expected booking b-1 with derived amount 150, got %+v", b)
+	}
+	if _, ok := db.GetUserByEmail("ann@example.com"); !ok {
+		t.Fatalf("expected fixture user to be found by email")
+	}
+
+	db.ResetDatabase()
+	if len(db.GetAllConferences()) != 1 || db.GetBooking("b-1") == nil {
+		t.Fatalf("expected reset to restore the fixture set")
+	}
+
+	writeFixture(t, dir, "bookings.json", `[{"id":"b-2","user_id":"ghost","conference_id":"demo-1","tickets_booked":1}]`)
+	if _, err := LoadFixtures(dir); err == nil {
+		t.Fatalf("expected error for booking referencing unknown user")
+	}
+}
+
+func TestLoadFixturesFromOneYAMLFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFixture(t, dir, "demo.yaml", `
+conferences:
+  - id: demo-1
+    name: Demo
+    location: Berlin
+    total_tickets: 10
+    price: 50
+    starts_in_days: 30
+  - id: demo-2
+    name: Later
+    location: Oslo
+    total_tickets: 5
+    price: 20
+    date: 2030-05-01T09:00:00Z
+users:
+  - {id: u-1, name: Ann, email: ann@example.com}
+bookings:
+  - {id: b-1, user_id: u-1, conference_id: demo-2, tickets_booked: 2}
+`)
+	fx, err := LoadFixtures(filepath.Join(dir, "demo.yaml"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	db := newTestDB(t)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	db.SetClock(func() time.Time { return now })
+	db.UseFixtures(fx)
+
+	if conf, err := db.GetConference("demo-1"); err != nil || !conf.Date.Equal(now.AddDate(0, 0, 30)) {
+		t.Fatalf("expected demo-1 dated 30 days from the load, got %+v (%v)", conf, err)
+	}
+	if conf, _ := db.GetConference("demo-2"); conf.AvailableTickets != 3 || conf.Date.Year() != 2030 {
+		t.Fatalf("expected demo-2 in 2030 with 3 left, got %+v", conf)
+	}
+	if _, err := db.GetConference("conf-1"); err == nil {
+		t.Fatalf("expected the fixtures to replace the sample data")
+	}
+
+	writeFixture(t, dir, "bad.yml", "conferences:\n  - {id: x, name: X, location: Y, total_tickets: 1, starts_in_days: 3, date: 2030-01-01T00:00:00Z}\n")
+	if _, err := LoadFixtures(filepath.Join(dir, "bad.yml")); err == nil || !strings.Contains(err.Error(), "bad.yml: conferences") {
+		t.Fatalf("expected an error naming the file, got %v", err)
+	}
+	writeFixture(t, dir, "empty.json", `{"users": []}`)
+	if _, err := LoadFixtures(filepath.Join(dir, "empty.json")); err == nil {
+		t.Fatalf("expected an error for a file without conferences")
+	}
+}
+
+func TestAllowanceZeroAtPerConferenceCap(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	db.MaxTicketsPerUser = 4
+	if _, err := db.CreateBooking(user.ID, conf.ID, 4, BookingOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	a, err := db.GetAllowance(user.ID, conf.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if a.Conference == nil || *a.Conference != 0 {
+		t.Fatalf("expected allowance 0, got %v", a.Conference)
+	}
+	if a.Global != nil {
+		t.Fatalf("expected no global cap, got %v", *a.Global)
+	}
+	if _, err := db.CreateBooking(user.ID, conf.ID, 1, BookingOptions{}); !errors.Is(err, ErrTicketLimit) {
+		t.Fatalf("expected ErrTicketLimit, got %v", err)
+	}
+}
+
+func TestUnsatisfiableQueueHeadIsDropped(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	db.MaxFailedClaims = 2
+	db.MaxTicketsPerUser = 0 // let the head ask for more than the conference has
+	next, _ := db.CreateUser("Bob", "bob@example.com")
+
+	db.EnqueueWait(user.ID, conf.ID, conf.AvailableTickets+1, "")
+	db.EnqueueWait(next.ID, conf.ID, 1, "")
+
+	if _, err := db.ClaimNext(user.ID, conf.ID, false); err == nil || errors.Is(err, ErrDroppedFromQueue) {
+		t.Fatalf("expected a plain failure on the first attempt, got %v", err)
+	}
+	if _, err := db.ClaimNext(user.ID, conf.ID, false); !errors.Is(err, ErrDroppedFromQueue) {
+		t.Fatalf("expected head to be dropped on the second failure, got %v", err)
+	}
+	if pos := db.GetQueuePosition(user.ID, conf.ID).Position; pos != 0 {
+		t.Fatalf("expected dropped user out of the queue, got position %d", pos)
+	}
+	if _, err := db.ClaimNext(next.ID, conf.ID, false); err != nil {
+		t.Fatalf("expected next user to claim, got %v", err)
+	}
+}
+
+func TestSweeperReleasesOrphanedReservation(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	res, err := db.CreateReservation(user.ID, conf.ID, 2, ReservationOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// remove the user record directly, bypassing any clean delete path
+	db.mutex.Lock()
+	delete(db.Users, user.ID)
+	db.mutex.Unlock()
+
+	if n := db.SweepOrphanedReservations(); n != 1 {
+		t.Fatalf("expected 1 orphaned reservation released, got %d", n)
+	}
+	if _, err := db.GetReservation(res.ID); !errors.Is(err, ErrReservationNotFound) {
+		t.Fatalf("expected reservation to be released, got %v", err)
+	}
+	if n := db.SweepOrphanedReservations(); n != 0 {
+		t.Fatalf("expected nothing left to sweep, got %d", n)
+	}
+}
+
+func TestHoldbackBlocksPublicUntilReleased(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	conf.ReservedHoldback = conf.AvailableTickets
+
+	if _, err := db.CreateBooking(user.ID, conf.ID, 1, BookingOptions{}); err == nil {
+		t.Fatalf("expected booking to be blocked by holdback")
+	}
+	if _, err := db.CreateReservation(user.ID, conf.ID, 1, ReservationOptions{}); err == nil {
+		t.Fatalf("expected reservation to be blocked by holdback")
+	}
+	if _, err := db.ReleaseHoldback(conf.ID, 5); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := db.CreateBooking(user.ID, conf.ID, 1, BookingOptions{}); err != nil {
+		t.Fatalf("expected booking after release, got %v", err)
+	}
+}
+
+func TestBulkEnqueuePositions(t *testing.T) {
+	db, _, conf := makeDBWithUserAndConf(t)
+	positions, err := db.BulkEnqueue(conf.ID, []QueueRequest{
+		{UserID: "u1", TicketCount: 1},
+		{UserID: "u2", TicketCount: 2},
+		{UserID: "u3", TicketCount: 1},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i, pos := range positions {
+		if pos != i+1 {
+			t.Fatalf("expected positions 1,2,3, got %v", positions)
+		}
+	}
+	if _, err := db.BulkEnqueue("missing", []QueueRequest{{UserID: "u1", TicketCount: 1}}); err == nil {
+		t.Fatalf("expected error for unknown conference")
+	}
+}
+
+func TestJanitorPurgesExpiredReservations(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	res, err := db.CreateReservation(user.ID, conf.ID, 1, ReservationOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	db.mutex.Lock()
+	db.Reservations[res.ID].ExpiresAt = time.Now().Add(-time.Second)
+	db.mutex.Unlock()
+
+	deadline := time.Now().Add(3 * JanitorInterval)
+	for {
+		db.mutex.RLock()
+		_, exists := db.Reservations[res.ID]
+		db.mutex.RUnlock()
+		if !exists {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected janitor to purge the expired reservation")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	db.Close()
+	db.Close() // idempotent
+}
+
+func TestHoldTimeComesFromTheConferenceOrTheDefault(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	db.Close()
+	clock := time.Now().Truncate(time.Second)
+	db.SetClock(func() time.Time { return clock })
+	db.HoldTime = time.Minute
+
+	res, err := db.CreateReservation(user.ID, conf.ID, 1, ReservationOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.HoldSeconds != 60 || !res.ExpiresAt.Equal(clock.Add(time.Minute)) {
+		t.Fatalf("expected the server default of 60s, got %ds until %s", res.HoldSeconds, res.ExpiresAt)
+	}
+
+	hold := 5
+	if _, err := db.UpdateConference(conf.ID, ConferenceUpdate{HoldSeconds: &hold}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	other, _ := db.CreateUser("Bob", "bob@example.com")
+	short, err := db.CreateReservation(other.ID, conf.ID, 1, ReservationOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if short.HoldSeconds != 5 || !short.ExpiresAt.Equal(clock.Add(5*time.Second)) {
+		t.Fatalf("expected the conference's 5s hold, got %ds until %s", short.HoldSeconds, short.ExpiresAt)
+	}
+	// extensions add the hold the reservation was made with
+	if extended, err := db.ExtendReservation(res.ID); err != nil || !extended.ExpiresAt.Equal(clock.Add(2*time.Minute)) {
+		t.Fatalf("expected a 60s extension, got %v, %v", extended, err)
+	}
+	if extended, err := db.ExtendReservation(short.ID); err != nil || !extended.ExpiresAt.Equal(clock.Add(10*time.Second)) {
+		t.Fatalf("expected a 5s extension, got %v, %v", extended, err)
+	}
+
+	negative := -1
+	if _, err := db.UpdateConference(conf.ID, ConferenceUpdate{HoldSeconds: &negative}); err == nil {
+		t.Fatalf("expected a negative hold time to be rejected")
+	}
+
+	replayed := newTestDB(t)
+	if err := replayed.ReplayAudit(db.GetAuditLog()); err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if got := replayed.Conferences[conf.ID].HoldSeconds; got != 5 {
+		t.Fatalf("expected the hold time to survive replay, got %d", got)
+	}
+	if got := replayed.Reservations[short.ID].HoldSeconds; got != 5 {
+		t.Fatalf("expected the reservation's hold to survive replay, got %d", got)
+	}
+}
+
+func TestJanitorIntervalCanBeChangedAndEmitsExpiryEvents(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	defer db.Close()
+	db.SetJanitorInterval(0) // paused
+	events, unsubscribe := db.Subscribe()
+	defer unsubscribe()
+
+	res, err := db.CreateReservation(user.ID, conf.ID, 2, ReservationOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	db.mutex.Lock()
+	db.Reservations[res.ID].ExpiresAt = time.Now().Add(-time.Second)
+	db.mutex.Unlock()
+	time.Sleep(200 * time.Millisecond)
+	db.mutex.RLock()
+	_, exists := db.Reservations[res.ID]
+	db.mutex.RUnlock()
+	if !exists {
+		t.Fatalf("expected a paused janitor to leave the expired hold alone")
+	}
+
+	db.SetJanitorInterval(10 * time.Millisecond)
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case e := <-events:
+			if e.Type != OpReservationExpire {
+				continue
+			}
+			if e.ReservationID != res.ID || e.UserID != user.ID || e.ConferenceID != conf.ID || e.TicketCount != 2 {
+				t.Fatalf("expected the expiry event to describe the freed hold, got %+v", e)
+			}
+			if got := db.GetConferenceStats()[conf.ID].Reserved; got != 0 {
+				t.Fatalf("expected no stale holds in the stats, got %d reserved", got)
+			}
+			return
+		case <-timeout:
+			t.Fatalf("expected the janitor to expire the hold and emit an event")
+		}
+	}
+}
+
+func TestExpiredQueueClaimPromotesNextUser(t *testing.T) {
+	db, first, conf := makeDBWithUserAndConf(t)
+	db.Close() // keep the janitor from expiring the hold before ReleaseAndPromote does
+	second, _ := db.CreateUser("Bob", "bob@example.com")
+	setAvailable(db, conf, 2)
+
+	db.EnqueueWait(first.ID, conf.ID, 2, "")
+	db.EnqueueWait(second.ID, conf.ID, 2, "")
+	res, err := db.ClaimNext(first.ID, conf.ID, false)
+	if err != nil {
+		t.Fatalf("expected first user to claim, got %v", err)
+	}
+	if res.Source != models.ReservationSourceQueue {
+		t.Fatalf("expected queue source, got %q", res.Source)
+	}
+	if _, err := db.ClaimNext(second.ID, conf.ID, false); err == nil {
+		t.Fatalf("expected second claim to fail while seats are held")
+	}
+
+	db.mutex.Lock()
+	db.Reservations[res.ID].ExpiresAt = time.Now().Add(-time.Second)
+	db.mutex.Unlock()
+
+	released, head := db.ReleaseAndPromote(conf.ID)
+	if released != 1 || head == nil || head.UserID != second.ID || head.FailedClaims != 0 {
+		t.Fatalf("expected 1 hold released and a fresh second user at the head, got %d %+v", released, head)
+	}
+	if _, err := db.ClaimNext(second.ID, conf.ID, false); err != nil {
+		t.Fatalf("expected second user to claim after expiry, got %v", err)
+	}
+}
+
+func TestDequeueWaitShiftsQueue(t *testing.T) {
+	db, _, conf := makeDBWithUserAndConf(t)
+	db.EnqueueWait("u1", conf.ID, 1, "")
+	db.EnqueueWait("u2", conf.ID, 1, "")
+	db.EnqueueWait("u3", conf.ID, 1, "")
+
+	if !db.DequeueWait("u1", conf.ID) {
+		t.Fatalf("expected head to be removed")
+	}
+	if pos := db.GetQueuePosition("u2", conf.ID).Position; pos != 1 {
+		t.Fatalf("expected u2 to move to the head, got %d", pos)
+	}
+	if pos := db.GetQueuePosition("u3", conf.ID).Position; pos != 2 {
+		t.Fatalf("expected u3 at position 2, got %d", pos)
+	}
+	if db.DequeueWait("u1", conf.ID) {
+		t.Fatalf("expected removing a missing entry to report false")
+	}
+}
+
+func TestSnapshotRoundTripDropsExpiredReservations(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	db.Close()
+	booking, err := db.CreateBooking(user.ID, conf.ID, 2, BookingOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	live, _ := db.CreateReservation(user.ID, conf.ID, 1, ReservationOptions{})
+	other, _ := db.CreateUser("Bob", "bob@example.com")
+	stale, _ := db.CreateReservation(other.ID, conf.ID, 1, ReservationOptions{})
+	db.Reservations[stale.ID].ExpiresAt = time.Now().Add(-time.Second)
+
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	if err := db.SaveSnapshot(path); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+
+	restored := newTestDB(t)
+	if err := restored.LoadSnapshot(path); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if restored.GetBooking(booking.ID) == nil {
+		t.Fatalf("expected booking to survive the round trip")
+	}
+	if _, err := restored.GetUser(other.ID); err != nil {
+		t.Fatalf("expected user to survive the round trip: %v", err)
+	}
+	if _, err := restored.GetReservation(live.ID); err != nil {
+		t.Fatalf("expected active reservation to survive: %v", err)
+	}
+	if _, err := restored.GetReservation(stale.ID); err == nil {
+		t.Fatalf("expected expired reservation to be dropped on load")
+	}
+	if c, _ := restored.GetConference(conf.ID); c.AvailableTickets != conf.AvailableTickets {
+		t.Fatalf("expected availability %d, got %d", conf.AvailableTickets, c.AvailableTickets)
+	}
+}
+
+func TestRestoreCountsAsWriteAndKeepsPromoCodesWhenAbsent(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	if _, err := db.CreateBooking(user.ID, conf.ID, 1, BookingOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	snap := db.Snapshot()
+	snap.PromoCodes = nil
+
+	restored := newTestDB(t)
+	before := restored.Writes()
+	restored.Restore(snap)
+	if restored.Writes() == before {
+		t.Fatalf("expected restore to count as a write")
+	}
+	if len(restored.Snapshot().PromoCodes) == 0 {
+		t.Fatalf("expected built-in promo codes to be kept when the snapshot has none")
+	}
+	if got, _ := restored.GetUserBookings(user.ID, 0, 0); len(got) != 1 {
+		t.Fatalf("expected the booking to be restored, got %d", len(got))
+	}
+}
+
+func TestBookingAttendeesMustMatchTickets(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	if _, err := db.CreateBooking(user.ID, conf.ID, 2, BookingOptions{Attendees: []string{"Alice"}}); !errors.Is(err, ErrInvalidAttendees) {
+		t.Fatalf("expected ErrInvalidAttendees for a short list, got %v", err)
+	}
+	if _, err := db.CreateBooking(user.ID, conf.ID, 2, BookingOptions{Attendees: []string{"Alice", "  "}}); !errors.Is(err, ErrInvalidAttendees) {
+		t.Fatalf("expected ErrInvalidAttendees for a blank name, got %v", err)
+	}
+	booking, err := db.CreateBooking(user.ID, conf.ID, 2, BookingOptions{Attendees: []string{" Alice ", "Bob"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(booking.Attendees) != 2 || booking.Attendees[0] != "Alice" {
+		t.Fatalf("expected trimmed attendees, got %q", booking.Attendees)
+	}
+
+	res, _ := db.CreateReservation(user.ID, conf.ID, 1, ReservationOptions{})
+	plain, err := db.ConfirmReservation(res.ID, BookingOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if plain.Attendees == nil || len(plain.Attendees) != 0 {
+		t.Fatalf("expected an empty attendee list, got %#v", plain.Attendees)
+	}
+}
+
+func TestUpdateConferenceCapacityGuard(t *testing.T) {
+	db := newTestDB(t)
+	conf, err := db.CreateConference("GopherCon", "Berlin", 10, 100, time.Now().AddDate(0, 1, 0), "", ConferenceOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if conf.AvailableTickets != 10 {
+		t.Fatalf("expected all 10 tickets available, got %d", conf.AvailableTickets)
+	}
+	if _, err := db.CreateConference("Free", "Online", 0, 0, time.Now(), "", ConferenceOptions{}); err == nil {
+		t.Fatalf("expected zero capacity to be rejected")
+	}
+
+	user, _ := db.CreateUser("Alice", "alice@example.com")
+	if _, err := db.CreateBooking(user.ID, conf.ID, 6, BookingOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	five := 5
+	if _, err := db.UpdateConference(conf.ID, ConferenceUpdate{TotalTickets: &five}); !errors.Is(err, ErrCapacityBelowSold) {
+		t.Fatalf("expected ErrCapacityBelowSold, got %v", err)
+	}
+	six := 6
+	updated, err := db.UpdateConference(conf.ID, ConferenceUpdate{TotalTickets: &six})
+	if err != nil {
+		t.Fatalf("expected shrinking to exactly the sold count to work: %v", err)
+	}
+	if updated.AvailableTickets != 0 {
+		t.Fatalf("expected no tickets left, got %d", updated.AvailableTickets)
+	}
+	twenty, price := 20, 80.0
+	updated, err = db.UpdateConference(conf.ID, ConferenceUpdate{TotalTickets: &twenty, Price: &price})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if updated.AvailableTickets != 14 || updated.Price != 80 {
+		t.Fatalf("expected 14 available at 80, got %d at %.2f", updated.AvailableTickets, updated.Price)
+	}
+	if _, err := db.UpdateConference("missing", ConferenceUpdate{Price: &price}); !errors.Is(err, ErrConferenceNotFound) {
+		t.Fatalf("expected ErrConferenceNotFound, got %v", err)
+	}
+
+	replayed := newTestDB(t)
+	if err := replayed.ReplayAudit(db.GetAuditLog()); err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if drift := db.Drift(replayed); len(drift) != 0 {
+		t.Fatalf("expected no drift, got %v", drift)
+	}
+}
+
+func TestSubscribeReceivesReservationEvents(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	events, unsubscribe := db.Subscribe()
+
+	res, err := db.CreateReservation(user.ID, conf.ID, 2, ReservationOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := db.ConfirmReservation(res.ID, BookingOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, want := range []string{OpReservationCreate, OpReservationConfirm} {
+		select {
+		case ev := <-events:
+			if ev.Type != want || ev.ReservationID != res.ID || ev.ConferenceID != conf.ID {
+				t.Fatalf("expected %s for %s, got %+v", want, res.ID, ev)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %s", want)
+		}
+	}
+
+	unsubscribe()
+	unsubscribe()
+	if _, ok := <-events; ok {
+		t.Fatalf("expected the channel to be closed after unsubscribing")
+	}
+}
+
+func TestDefaultPerUserCapCoversBookingsReservationsAndQueue(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	if _, err := db.CreateBooking(user.ID, conf.ID, DefaultMaxTicketsPerUser-3, BookingOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := db.CreateReservation(user.ID, conf.ID, 4, ReservationOptions{}); !errors.Is(err, ErrTicketLimit) {
+		t.Fatalf("expected ErrTicketLimit over the cap, got %v", err)
+	} else if !strings.Contains(err.Error(), "3 more") {
+		t.Fatalf("expected the remaining allowance in the message, got %q", err)
+	}
+	if _, err := db.CreateReservation(user.ID, conf.ID, 3, ReservationOptions{}); err != nil {
+		t.Fatalf("expected to reserve up to the cap, got %v", err)
+	}
+
+	if _, err := db.CreateBooking(user.ID, conf.ID, 1, BookingOptions{}); !errors.Is(err, ErrTicketLimit) {
+		t.Fatalf("expected booking past the cap to fail, got %v", err)
+	}
+	other, _ := db.CreateUser("Bob", "bob@example.com")
+	if _, err := db.EnqueueWait(other.ID, conf.ID, DefaultMaxTicketsPerUser+1, ""); !errors.Is(err, ErrTicketLimit) {
+		t.Fatalf("expected enqueue past the cap to fail, got %v", err)
+	}
+	if pos := db.GetQueuePosition(other.ID, conf.ID).Position; pos != 0 {
+		t.Fatalf("expected a rejected request to stay out of the queue, got position %d", pos)
+	}
+}
+
+func TestCancelBookingRestoresAvailabilityOnce(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	before := conf.AvailableTickets
+	booking, err := db.CreateBooking(user.ID, conf.ID, 3, BookingOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cancelled, err := db.CancelBooking(booking.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cancelled.Status != "cancelled" {
+		t.Fatalf("expected status cancelled, got %s", cancelled.Status)
+	}
+	if c, _ := db.GetConference(conf.ID); c.AvailableTickets != before {
+		t.Fatalf("expected availability back at %d, got %d", before, c.AvailableTickets)
+	}
+
+	if _, err := db.CancelBooking(booking.ID); !errors.Is(err, ErrBookingCancelled) {
+		t.Fatalf("expected ErrBookingCancelled, got %v", err)
+	}
+	if c, _ := db.GetConference(conf.ID); c.AvailableTickets != before {
+		t.Fatalf("expected a second cancel not to credit tickets, got %d", c.AvailableTickets)
+	}
+	if _, err := db.CancelBooking("missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	replayed := newTestDB(t)
+	if err := replayed.ReplayAudit(db.GetAuditLog()); err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if drift := db.Drift(replayed); len(drift) != 0 {
+		t.Fatalf("expected no drift, got %v", drift)
+	}
+}
+
+func TestConferenceAnalyticsExcludeCancelledBookings(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	kept, _ := db.CreateBooking(user.ID, conf.ID, 2, BookingOptions{})
+	dropped, _ := db.CreateBooking(user.ID, conf.ID, 3, BookingOptions{})
+	if _, err := db.CancelBooking(dropped.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	other, _ := db.CreateUser("Bob", "bob@example.com")
+	if _, err := db.CreateReservation(other.ID, conf.ID, 1, ReservationOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := db.GetConferenceAnalytics()[conf.ID]
+	if got.TicketsSold != 2 || got.Revenue != kept.TotalAmount {
+		t.Fatalf("expected 2 sold for %.2f, got %d for %.2f", kept.TotalAmount, got.TicketsSold, got.Revenue)
+	}
+	if want := 200.0 / float64(conf.TotalTickets); got.FillPercent != want {
+		t.Fatalf("expected fill %.2f%%, got %.2f%%", want, got.FillPercent)
+	}
+	if got.ActiveReservations != 1 {
+		t.Fatalf("expected 1 active reservation, got %d", got.ActiveReservations)
+	}
+}
+
+func TestPastConferenceRejectsBookingsHoldsAndQueue(t *testing.T) {
+	db, user, _ := makeDBWithUserAndConf(t)
+	db.Conferences["past"] = &models.Conference{
+		ID: "past", Name: "Yesterday's News", TotalTickets: 10, AvailableTickets: 10,
+		Price: 50, Date: time.Now().Add(-24 * time.Hour),
+	}
+
+	if _, err := db.CreateBooking(user.ID, "past", 1, BookingOptions{}); !errors.Is(err, ErrConferencePast) {
+		t.Fatalf("expected ErrConferencePast from CreateBooking, got %v", err)
+	}
+	if _, err := db.CreateReservation(user.ID, "past", 1, ReservationOptions{}); !errors.Is(err, ErrConferencePast) {
+		t.Fatalf("expected ErrConferencePast from CreateReservation, got %v", err)
+	}
+	if _, err := db.EnqueueWait(user.ID, "past", 1, ""); !errors.Is(err, ErrConferencePast) {
+		t.Fatalf("expected ErrConferencePast from EnqueueWait, got %v", err)
+	}
+	if _, err := db.CreateConference("Retro", "Online", 10, 0, time.Now().Add(-time.Hour), "", ConferenceOptions{}); err == nil {
+		t.Fatalf("expected a past date to be rejected on create")
+	}
+}
+
+func TestIdempotentBookingBooksOnce(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	before := conf.AvailableTickets
+
+	first, replayed, err := db.CreateBookingIdempotent("retry-1", user.ID, conf.ID, 2, BookingOptions{})
+	if err != nil || replayed {
+		t.Fatalf("expected a fresh booking, got replayed=%v err=%v", replayed, err)
+	}
+	second, replayed, err := db.CreateBookingIdempotent("retry-1", user.ID, conf.ID, 2, BookingOptions{})
+	if err != nil || !replayed || second.ID != first.ID {
+		t.Fatalf("expected the original booking back, got replayed=%v err=%v", replayed, err)
+	}
+	if _, total := db.GetUserBookings(user.ID, 0, 0); total != 1 {
+		t.Fatalf("expected exactly one booking, got %d", total)
+	}
+	if c, _ := db.GetConference(conf.ID); c.AvailableTickets != before-2 {
+		t.Fatalf("expected availability to drop once to %d, got %d", before-2, c.AvailableTickets)
+	}
+
+	if _, _, err := db.CreateBookingIdempotent("retry-1", user.ID, conf.ID, 3, BookingOptions{}); !errors.Is(err, ErrIdempotencyMismatch) {
+		t.Fatalf("expected ErrIdempotencyMismatch for a different request, got %v", err)
+	}
+	other, _ := db.CreateUser("Bob", "bob@example.com")
+	if _, replayed, err := db.CreateBookingIdempotent("retry-1", other.ID, conf.ID, 2, BookingOptions{}); err != nil || replayed {
+		t.Fatalf("expected keys to be scoped per user, got replayed=%v err=%v", replayed, err)
+	}
+}
+
+func TestIdempotentReservationHoldsOnce(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	db.Close()
+	clock := time.Now()
+	db.SetClock(func() time.Time { return clock })
+
+	first, replayed, err := db.CreateReservationIdempotent("hold-1", user.ID, conf.ID, 2, ReservationOptions{})
+	if err != nil || replayed {
+		t.Fatalf("expected a fresh hold, got replayed=%v err=%v", replayed, err)
+	}
+	second, replayed, err := db.CreateReservationIdempotent("hold-1", user.ID, conf.ID, 2, ReservationOptions{})
+	if err != nil || !replayed || second.ID != first.ID {
+		t.Fatalf("expected the original hold back, got replayed=%v err=%v", replayed, err)
+	}
+	if held := db.GetUserReservations(user.ID); len(held) != 1 {
+		t.Fatalf("expected exactly one hold, got %d", len(held))
+	}
+	if _, _, err := db.CreateReservationIdempotent("hold-1", user.ID, conf.ID, 1, ReservationOptions{}); !errors.Is(err, ErrIdempotencyMismatch) {
+		t.Fatalf("expected ErrIdempotencyMismatch for a different request, got %v", err)
+	}
+	if _, _, err := db.CreateBookingIdempotent("hold-1", user.ID, conf.ID, 2, BookingOptions{}); !errors.Is(err, ErrIdempotencyMismatch) {
+		t.Fatalf("expected a reservation key to be refused for a booking, got %v", err)
+	}
+
+	// A confirmed hold is still replayed rather than held again
+	if _, err := db.ConfirmReservation(first.ID, BookingOptions{}); err != nil {
+		t.Fatalf("confirm: %v", err)
+	}
+	if again, replayed, err := db.CreateReservationIdempotent("hold-1", user.ID, conf.ID, 2, ReservationOptions{}); err != nil || !replayed || again.Status != models.ReservationStatusConfirmed {
+		t.Fatalf("expected the confirmed hold back, got replayed=%v err=%v", replayed, err)
+	}
+
+	// An expired hold leaves nothing to replay, so the key holds seats again
+	other, _ := db.CreateUser("Bob", "bob@example.com")
+	lapsed, _, err := db.CreateReservationIdempotent("hold-2", other.ID, conf.ID, 1, ReservationOptions{})
+	if err != nil {
+		t.Fatalf("hold: %v", err)
+	}
+	clock = clock.Add(db.holdFor(conf) + time.Second)
+	fresh, replayed, err := db.CreateReservationIdempotent("hold-2", other.ID, conf.ID, 1, ReservationOptions{})
+	if err != nil || replayed || fresh.ID == lapsed.ID {
+		t.Fatalf("expected a new hold after the first expired, got replayed=%v err=%v", replayed, err)
+	}
+
+	// Keys are forgotten after IdempotencyKeyTTL
+	clock = clock.Add(IdempotencyKeyTTL)
+	if _, replayed, err := db.CreateReservationIdempotent("hold-1", user.ID, conf.ID, 1, ReservationOptions{}); replayed || errors.Is(err, ErrIdempotencyMismatch) {
+		t.Fatalf("expected the key to have lapsed, got replayed=%v err=%v", replayed, err)
+	}
+}
+
+func TestPartialClaimRequeuesShortfall(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	db.Close()
+	setAvailable(db, conf, 3)
+	other, _ := db.CreateUser("Bob", "bob@example.com")
+	if _, err := db.EnqueueWait(user.ID, conf.ID, 5, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := db.EnqueueWait(other.ID, conf.ID, 1, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := db.ClaimNext(user.ID, conf.ID, false); err == nil {
+		t.Fatalf("expected a full claim to fail with only 3 seats free")
+	}
+	res, err := db.ClaimNext(user.ID, conf.ID, true)
+	if err != nil {
+		t.Fatalf("expected partial claim to succeed, got %v", err)
+	}
+	if res.TicketCount != 3 {
+		t.Fatalf("expected 3 tickets granted, got %d", res.TicketCount)
+	}
+	if pos := db.GetQueuePosition(other.ID, conf.ID).Position; pos != 1 {
+		t.Fatalf("expected Bob at the head, got %d", pos)
+	}
+	if pos := db.GetQueuePosition(user.ID, conf.ID).Position; pos != 2 {
+		t.Fatalf("expected the shortfall re-queued at the back, got %d", pos)
+	}
+	if q := db.WaitQueues[conf.ID]; q[1].TicketCount != 2 {
+		t.Fatalf("expected a re-queued request for 2 tickets, got %d", q[1].TicketCount)
+	}
+
+	replayed := newTestDB(t)
+	if err := replayed.ReplayAudit(db.GetAuditLog()); err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+}
+
+func TestQueueStatusCountsTicketsAhead(t *testing.T) {
+	db, _, conf := makeDBWithUserAndConf(t)
+	db.EnqueueWait("u1", conf.ID, 2, "")
+	db.EnqueueWait("u2", conf.ID, 3, "")
+	db.EnqueueWait("u3", conf.ID, 1, "")
+	db.EnqueueWait("u1", conf.ID, 4, "") // re-enqueue updates the count but keeps the spot
+
+	got := db.GetQueuePosition("u3", conf.ID)
+	if got != (QueueStatus{Position: 3, TicketCount: 1, AheadCount: 7, EstimatedWaitSeconds: 7 * int(ReservationHold.Seconds())}) {
+		t.Fatalf("expected position 3 with 7 tickets ahead, got %+v", got)
+	}
+	if got := db.GetQueuePosition("u1", conf.ID); got.Position != 1 || got.AheadCount != 0 || got.TicketCount != 4 {
+		t.Fatalf("expected u1 at the head asking for 4, got %+v", got)
+	}
+	if got := db.GetQueuePosition("nobody", conf.ID); got != (QueueStatus{}) {
+		t.Fatalf("expected the zero status for a user not queued, got %+v", got)
+	}
+}
+
+func TestCreateUsersReportsEachRow(t *testing.T) {
+	db := newTestDB(t)
+	if _, err := db.CreateUser("Existing", "taken@example.com"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	results := db.CreateUsers([]UserInput{
+		{Name: "Ann", Email: "ann@example.com"},
+		{Name: "Dup", Email: "TAKEN@example.com"},
+		{Name: "Bad", Email: "not-an-email"},
+		{Name: "Ann Again", Email: "ann@example.com"},
+		{Name: "", Email: "anon@example.com"},
+		{Name: "Ben", Email: "ben@example.com"},
+	})
+	created := 0
+	for _, r := range results {
+		if r.User != nil {
+			created++
+		}
+	}
+	if created != 2 || results[0].User == nil || results[5].User == nil {
+		t.Fatalf("expected rows 0 and 5 to succeed, got %+v", results)
+	}
+	for _, i := range []int{1, 2, 3, 4} {
+		if results[i].Error == "" || results[i].Index != i {
+			t.Fatalf("expected row %d to fail with a reason, got %+v", i, results[i])
+		}
+	}
+	if _, ok := db.GetUserByEmail("ben@example.com"); !ok {
+		t.Fatalf("expected Ben to be stored")
+	}
+}
+
+func TestExpectedVersionCatchesLostUpdate(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	other, _ := db.CreateUser("Bob", "bob@example.com")
+
+	// Both clients read the conference before either writes
+	first, _ := db.GetConference(conf.ID)
+	second, _ := db.GetConference(conf.ID)
+
+	if _, err := db.CreateBooking(user.ID, conf.ID, 1, BookingOptions{ExpectedVersion: &first.Version}); err != nil {
+		t.Fatalf("expected the first write to succeed, got %v", err)
+	}
+	if _, err := db.CreateBooking(other.ID, conf.ID, 1, BookingOptions{ExpectedVersion: &second.Version}); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("expected ErrVersionConflict for the stale write, got %v", err)
+	}
+
+	current, _ := db.GetConference(conf.ID)
+	if current.Version != first.Version+1 || current.AvailableTickets != first.AvailableTickets-1 {
+		t.Fatalf("expected exactly one write applied, got version %d with %d available", current.Version, current.AvailableTickets)
+	}
+	res, _ := db.CreateReservation(other.ID, conf.ID, 1, ReservationOptions{})
+	if _, err := db.ConfirmReservation(res.ID, BookingOptions{ExpectedVersion: &second.Version}); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("expected ConfirmReservation to check the version too, got %v", err)
+	}
+	if _, err := db.ConfirmReservation(res.ID, BookingOptions{ExpectedVersion: &current.Version}); err != nil {
+		t.Fatalf("expected a fresh version to confirm, got %v", err)
+	}
+}
+
+func TestExpectedVersionGuardsHoldsAndCapacityChanges(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	stale := conf.Version
+	if _, err := db.CreateBooking(user.ID, conf.ID, 1, BookingOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// A capacity change worked out from the stale sold count is refused, with the current version
+	total := 50
+	_, err := db.UpdateConference(conf.ID, ConferenceUpdate{TotalTickets: &total, ExpectedVersion: &stale})
+	var conflict *VersionConflict
+	if !errors.As(err, &conflict) || !errors.Is(err, ErrVersionConflict) || conflict.Current != stale+1 {
+		t.Fatalf("expected a version conflict reporting version %d, got %v", stale+1, err)
+	}
+	if _, err := db.CreateReservation(user.ID, conf.ID, 1, ReservationOptions{ExpectedVersion: &stale}); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("expected a stale hold to be refused, got %v", err)
+	}
+	if _, _, err := db.CreateReservationIdempotent("key-1", user.ID, conf.ID, 1, ReservationOptions{ExpectedVersion: &stale}); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("expected a stale keyed hold to be refused, got %v", err)
+	}
+
+	if _, err := db.UpdateConference(conf.ID, ConferenceUpdate{TotalTickets: &total, ExpectedVersion: &conflict.Current}); err != nil {
+		t.Fatalf("expected the retry at the current version to apply, got %v", err)
+	}
+	current, _ := db.GetConference(conf.ID)
+	if _, err := db.CreateReservation(user.ID, conf.ID, 1, ReservationOptions{ExpectedVersion: &current.Version}); err != nil {
+		t.Fatalf("expected a hold at the current version to go through, got %v", err)
+	}
+}
+
+func TestMissedClaimWindowDropsHeadAndNotifiesNext(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	db.Close() // drive expiry by hand
+	bob, _ := db.CreateUser("Bob", "bob@example.com")
+	carol, _ := db.CreateUser("Carol", "carol@example.com")
+
+	booking, err := db.CreateBooking(user.ID, conf.ID, 2, BookingOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	setAvailable(db, conf, 0) // sold out
+	db.EnqueueWait(bob.ID, conf.ID, 1, "")
+	db.EnqueueWait(carol.ID, conf.ID, 1, "")
+	if st := db.GetQueuePosition(bob.ID, conf.ID); !st.Claimable || st.ClaimableUntil != nil {
+		t.Fatalf("expected an un-notified claimable head, got %+v", st)
+	}
+
+	if _, err := db.CancelBooking(booking.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	st := db.GetQueuePosition(bob.ID, conf.ID)
+	if st.ClaimableUntil == nil || time.Until(*st.ClaimableUntil) <= 0 {
+		t.Fatalf("expected bob to be notified with an open window, got %+v", st)
+	}
+
+	db.mutex.Lock()
+	db.WaitQueues[conf.ID][0].ClaimableUntil = time.Now().Add(-time.Second)
+	db.mutex.Unlock()
+	if st := db.GetQueuePosition(bob.ID, conf.ID); st.Claimable {
+		t.Fatalf("expected lapsed window to be unclaimable, got %+v", st)
+	}
+	if _, err := db.ClaimNext(bob.ID, conf.ID, false); !errors.Is(err, ErrDroppedFromQueue) {
+		t.Fatalf("expected bob to be dropped, got %v", err)
+	}
+	if pos := db.GetQueuePosition(bob.ID, conf.ID).Position; pos != 0 {
+		t.Fatalf("expected bob out of the queue, got position %d", pos)
+	}
+	st = db.GetQueuePosition(carol.ID, conf.ID)
+	if st.Position != 1 || st.ClaimableUntil == nil || !st.Claimable {
+		t.Fatalf("expected carol notified at the head, got %+v", st)
+	}
+
+	// the janitor path drops a lapsed head without it calling ClaimNext
+	db.mutex.Lock()
+	db.WaitQueues[conf.ID][0].ClaimableUntil = time.Now().Add(-time.Second)
+	db.mutex.Unlock()
+	db.cleanupExpiredReservations()
+	if pos := db.GetQueuePosition(carol.ID, conf.ID).Position; pos != 0 {
+		t.Fatalf("expected carol dropped by the janitor, got position %d", pos)
+	}
+}
+
+func TestMissedClaimWindowMovesHeadToTheBackBeforeDropping(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	db.Close() // drive expiry by hand
+	clock := time.Now()
+	db.SetClock(func() time.Time { return clock })
+	db.ClaimWindow, db.MaxClaimRequeues = time.Minute, 1
+	bob, _ := db.CreateUser("Bob", "bob@example.com")
+	carol, _ := db.CreateUser("Carol", "carol@example.com")
+
+	booking, err := db.CreateBooking(user.ID, conf.ID, 2, BookingOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	setAvailable(db, conf, 0) // sold out
+	db.EnqueueWait(bob.ID, conf.ID, 1, "")
+	db.EnqueueWait(carol.ID, conf.ID, 1, "")
+	if _, err := db.CancelBooking(booking.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if st := db.GetQueuePosition(bob.ID, conf.ID); st.ClaimableUntil == nil || !st.ClaimableUntil.Equal(clock.Add(time.Minute)) {
+		t.Fatalf("expected bob to get the configured window, got %+v", st)
+	}
+
+	clock = clock.Add(time.Minute + time.Second)
+	if _, err := db.ClaimNext(bob.ID, conf.ID, false); !errors.Is(err, ErrNotYourTurn) {
+		t.Fatalf("expected bob moved back rather than dropped, got %v", err)
+	}
+	if st := db.GetQueuePosition(bob.ID, conf.ID); st.Position != 2 || st.MissedClaims != 1 || st.ClaimableUntil != nil {
+		t.Fatalf("expected bob at the back with a missed claim, got %+v", st)
+	}
+	if st := db.GetQueuePosition(carol.ID, conf.ID); st.Position != 1 || st.ClaimableUntil == nil {
+		t.Fatalf("expected carol notified at the head, got %+v", st)
+	}
+
+	// the janitor moves carol back too; bob has used his requeue, so next time he is dropped
+	clock = clock.Add(time.Minute + time.Second)
+	db.cleanupExpiredReservations()
+	if st := db.GetQueuePosition(bob.ID, conf.ID); st.Position != 1 || st.ClaimableUntil == nil {
+		t.Fatalf("expected bob notified at the head again, got %+v", st)
+	}
+	clock = clock.Add(time.Minute + time.Second)
+	db.cleanupExpiredReservations()
+	if pos := db.GetQueuePosition(bob.ID, conf.ID).Position; pos != 0 {
+		t.Fatalf("expected bob dropped after his requeue, got position %d", pos)
+	}
+	if st := db.GetQueuePosition(carol.ID, conf.ID); st.Position != 1 || st.MissedClaims != 1 {
+		t.Fatalf("expected carol alone at the head, got %+v", st)
+	}
+
+	replayed := newTestDB(t)
+	if err := replayed.ReplayAudit(db.GetAuditLog()); err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if got := replayed.WaitQueues[conf.ID]; len(got) != 1 || got[0].UserID != carol.ID || got[0].MissedClaims != 1 {
+		t.Fatalf("expected the replayed queue to hold just carol, got %+v", got)
+	}
+}
+
+func TestLapsedOffersAreRequeuedWithTheDefaultConfig(t *testing.T) {
+	t.Setenv("QUEUE_CLAIM_REQUEUES", "1")
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	db, user, conf := makeDBWithUserAndConf(t)
+	db.Close() // drive expiry by hand
+	clock := time.Now()
+	db.SetClock(func() time.Time { return clock })
+	db.OfferWindow, db.ClaimWindow, db.MaxClaimRequeues = cfg.QueueOfferWindow, cfg.QueueClaimWindow, cfg.QueueClaimRequeues
+	if db.OfferWindow <= 0 {
+		t.Fatalf("expected offers to be on by default, got %v", db.OfferWindow)
+	}
+	bob, _ := db.CreateUser("Bob", "bob@example.com")
+	carol, _ := db.CreateUser("Carol", "carol@example.com")
+
+	booking, err := db.CreateBooking(user.ID, conf.ID, 1, BookingOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	setAvailable(db, conf, 0) // sold out
+	db.EnqueueWait(bob.ID, conf.ID, 1, "")
+	db.EnqueueWait(carol.ID, conf.ID, 1, "")
+	if _, err := db.CancelBooking(booking.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if st := db.GetQueuePosition(bob.ID, conf.ID); st.Offer == nil {
+		t.Fatalf("expected bob offered the freed seat, got %+v", st)
+	}
+
+	// bob lets the offer lapse: back of the line, and carol gets the seat
+	clock = clock.Add(db.OfferWindow + time.Second)
+	db.cleanupExpiredReservations()
+	if st := db.GetQueuePosition(bob.ID, conf.ID); st.Position != 1 || st.MissedClaims != 1 {
+		t.Fatalf("expected bob back in line with a missed turn, got %+v", st)
+	}
+	if st := db.GetQueuePosition(carol.ID, conf.ID); st.Offer == nil {
+		t.Fatalf("expected carol offered the seat next, got %+v", st)
+	}
+
+	// carol lets hers lapse too, so bob gets another offer; lapsing that one drops him
+	clock = clock.Add(db.OfferWindow + time.Second)
+	db.cleanupExpiredReservations()
+	if st := db.GetQueuePosition(bob.ID, conf.ID); st.Offer == nil {
+		t.Fatalf("expected bob offered the seat again, got %+v", st)
+	}
+	clock = clock.Add(db.OfferWindow + time.Second)
+	db.cleanupExpiredReservations()
+	if st := db.GetQueuePosition(bob.ID, conf.ID); st.Position != 0 || st.Offer != nil {
+		t.Fatalf("expected bob out of the queue after his requeue, got %+v", st)
+	}
+	offer := db.GetQueuePosition(carol.ID, conf.ID).Offer
+	if offer == nil || offer.QueueTurn == nil || offer.QueueTurn.MissedClaims != 1 {
+		t.Fatalf("expected carol offered the seat once more on her last turn, got %+v", offer)
+	}
+
+	replayed := newTestDB(t)
+	if err := replayed.ReplayAudit(db.GetAuditLog()); err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if got := replayed.Reservations[offer.ID]; len(replayed.WaitQueues[conf.ID]) != 0 || got == nil || *got.QueueTurn != *offer.QueueTurn {
+		t.Fatalf("expected the replay to leave just carol's offer, got %+v and %+v", replayed.WaitQueues[conf.ID], got)
+	}
+}
+
+func TestHeadLeavingTheQueueTellsTheNextEntry(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	db.Close() // drive expiry by hand
+	bob, _ := db.CreateUser("Bob", "bob@example.com")
+	carol, _ := db.CreateUser("Carol", "carol@example.com")
+
+	booking, _ := db.CreateBooking(user.ID, conf.ID, 1, BookingOptions{})
+	setAvailable(db, conf, 0) // sold out
+	db.EnqueueWait(bob.ID, conf.ID, 1, "")
+	db.EnqueueWait(carol.ID, conf.ID, 1, "")
+	db.CancelBooking(booking.ID)
+	if st := db.GetQueuePosition(carol.ID, conf.ID); st.ClaimableUntil != nil {
+		t.Fatalf("expected only bob to be told, got %+v", st)
+	}
+
+	if !db.DequeueWait(bob.ID, conf.ID) {
+		t.Fatalf("expected bob to leave the queue")
+	}
+	if st := db.GetQueuePosition(carol.ID, conf.ID); st.Position != 1 || st.ClaimableUntil == nil {
+		t.Fatalf("expected carol told about the freed seat once bob left, got %+v", st)
+	}
+}
+
+func TestReservationBundleIsAllOrNothing(t *testing.T) {
+	db, user, _ := makeDBWithUserAndConf(t)
+	items := []ReservationItem{
+		{ConferenceID: "conf-1", TicketCount: 1},
+		{ConferenceID: "conf-2", TicketCount: 2},
+		{ConferenceID: "conf-3", TicketCount: db.Conferences["conf-3"].AvailableTickets + 1, Tier: "General"},
+	}
+	if _, err := db.CreateReservationBundle(user.ID, items, ReservationOptions{}); err == nil {
+		t.Fatalf("expected the bundle to fail on the third item")
+	}
+	if n := len(db.GetUserReservations(user.ID)); n != 0 {
+		t.Fatalf("expected no reservations after a failed bundle, got %d", n)
+	}
+
+	items[2].TicketCount = 1
+	reservations, err := db.CreateReservationBundle(user.ID, items, ReservationOptions{RequestID: "req-1", ActorID: "admin-1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(reservations) != 3 || len(db.GetUserReservations(user.ID)) != 3 {
+		t.Fatalf("expected 3 reservations, got %d", len(reservations))
+	}
+	if got, _ := db.QueryAuditLog(AuditFilter{RequestID: "req-1"}, 0, 0); len(got) != 3 || got[0].Actor != "admin-1" {
+		t.Fatalf("expected every hold in the bundle tagged with the request and actor, got %+v", got)
+	}
+	if _, err := db.CreateReservationBundle(user.ID, items[:1], ReservationOptions{}); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected the per-conference hold rule to apply, got %v", err)
+	}
+}
+
+func TestCartChecksOutAllOrNothingAndPaysOnce(t *testing.T) {
+	db, user, _ := makeDBWithUserAndConf(t)
+	payments := &stubPayments{}
+	db.Payments = payments
+
+	cart, err := db.CreateCart(user.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := db.SetCartItem(cart.ID, ReservationItem{ConferenceID: "conf-1", TicketCount: 1}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := db.SetCartItem(cart.ID, ReservationItem{ConferenceID: "conf-2", TicketCount: 1}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// setting a conference again replaces its line
+	if cart, err = db.SetCartItem(cart.ID, ReservationItem{ConferenceID: "conf-2", TicketCount: 2}); err != nil || len(cart.Items) != 2 || cart.Items[1].TicketCount != 2 {
+		t.Fatalf("expected two lines with conf-2 at 2 tickets, got %+v %v", cart, err)
+	}
+
+	cart, reservations, err := db.CheckoutCart(cart.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cart.Status != models.CartStatusReserved || len(reservations) != 2 || cart.TotalAmount != reservations[0].TotalAmount+reservations[1].TotalAmount {
+		t.Fatalf("expected both items held under one total, got %+v", cart)
+	}
+	if _, err := db.SetCartItem(cart.ID, ReservationItem{ConferenceID: "conf-3", TicketCount: 1}); !errors.Is(err, ErrCartNotOpen) {
+		t.Fatalf("expected a checked-out cart to refuse changes, got %v", err)
+	}
+
+	// one hold going away releases the other and reopens the cart, without charging
+	if err := db.CancelReservation(reservations[1].ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, _, err := db.ConfirmCart(cart.ID, BookingOptions{}); !errors.Is(err, ErrReservationNotFound) {
+		t.Fatalf("expected the missing hold reported, got %v", err)
+	}
+	if holds := db.GetUserReservations(user.ID); len(holds) != 0 || len(payments.charged) != 0 || len(db.Bookings) != 0 {
+		t.Fatalf("expected nothing held, charged or booked after the rollback, got %d holds, %v", len(holds), payments.charged)
+	}
+	if got, _ := db.GetCart(cart.ID); got.Status != models.CartStatusOpen || len(got.ReservationIDs) != 0 {
+		t.Fatalf("expected the cart open again, got %+v", got)
+	}
+
+	// a declined charge keeps the holds for a retry; the retry pays for everything at once
+	if cart, _, err = db.CheckoutCart(cart.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	payments.declining = true
+	if _, _, err := db.ConfirmCart(cart.ID, BookingOptions{}); !errors.Is(err, ErrPaymentFailed) {
+		t.Fatalf("expected ErrPaymentFailed, got %v", err)
+	}
+	if holds := db.GetUserReservations(user.ID); len(holds) != 2 {
+		t.Fatalf("expected the holds kept after a decline, got %d", len(holds))
+	}
+	payments.declining = false
+	cart, bookings, err := db.ConfirmCart(cart.ID, BookingOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cart.Status != models.CartStatusCompleted || len(bookings) != 2 || len(cart.BookingIDs) != 2 {
+		t.Fatalf("expected both items booked, got %+v", cart)
+	}
+	if len(payments.charged) != 2 || payments.charged[1] != cart.TotalAmount {
+		t.Fatalf("expected one charge for the cart total per attempt, got %v", payments.charged)
+	}
+
+	replayed := newTestDB(t)
+	if err := replayed.ReplayAudit(db.GetAuditLog()); err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if drift := db.Drift(replayed); len(drift) != 0 {
+		t.Fatalf("expected no drift after replay, got %v", drift)
+	}
+}
+
+func TestSeatMapLocksPickedSeats(t *testing.T) {
+	db := newTestDB(t)
+	alice, _ := db.CreateUser("Alice", "alice@example.com")
+	bob, _ := db.CreateUser("Bob", "bob@example.com")
+	if _, err := db.CreateConference("Seated", "Berlin", 10, 50, time.Now().AddDate(0, 1, 0), "", ConferenceOptions{
+		Sections: []models.Section{{Name: "A", Rows: 2, SeatsPerRow: 3}},
+	}); err == nil {
+		t.Fatalf("expected a seat map smaller than the capacity rejected")
+	}
+	conf, err := db.CreateConference("Seated", "Berlin", 10, 50, time.Now().AddDate(0, 1, 0), "", ConferenceOptions{
+		Sections: []models.Section{{Name: "A", Rows: 2, SeatsPerRow: 3}, {Name: "B", Rows: 1, SeatsPerRow: 4}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	res, err := db.CreateReservation(alice.ID, conf.ID, 2, ReservationOptions{SeatIDs: []string{"A-1-2", "B-1-4"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(res.SeatIDs, ",") != "A-1-2,B-1-4" {
+		t.Fatalf("expected the picked seats held, got %v", res.SeatIDs)
+	}
+	if _, err := db.CreateReservation(bob.ID, conf.ID, 2, ReservationOptions{SeatIDs: []string{"A-1-1", "A-1-2"}}); !errors.Is(err, ErrSeatTaken) {
+		t.Fatalf("expected ErrSeatTaken, got %v", err)
+	}
+	if _, err := db.CreateReservation(bob.ID, conf.ID, 1, ReservationOptions{SeatIDs: []string{"C-1-1"}}); !errors.Is(err, ErrSeatNotFound) {
+		t.Fatalf("expected ErrSeatNotFound, got %v", err)
+	}
+	if _, err := db.CreateReservation(bob.ID, conf.ID, 2, ReservationOptions{SeatIDs: []string{"A-1-1"}}); err == nil {
+		t.Fatalf("expected one seat per ticket to be required")
+	}
+	// without seat_ids the first free seats are taken
+	booking, err := db.CreateBooking(bob.ID, conf.ID, 2, BookingOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(booking.SeatIDs, ",") != "A-1-1,A-1-3" {
+		t.Fatalf("expected the first free seats, got %v", booking.SeatIDs)
+	}
+
+	confirmed, err := db.ConfirmReservation(res.ID, BookingOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(confirmed.SeatIDs, ",") != "A-1-2,B-1-4" {
+		t.Fatalf("expected the booking to keep the held seats, got %v", confirmed.SeatIDs)
+	}
+	seats, err := db.SeatMap(conf.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(seats.Seats) != 10 || seats.Booked != 4 || seats.Held != 0 || seats.Free != 6 || seats.Seats[1].State != SeatBooked {
+		t.Fatalf("expected 4 of 10 seats booked, got %+v", seats)
+	}
+
+	// concurrent picks of the same seat: exactly one wins
+	var wg sync.WaitGroup
+	wins := make(chan string, 5)
+	for i := 0; i < 5; i++ {
+		user, _ := db.CreateUser(fmt.Sprintf("U%d", i), fmt.Sprintf("u%d@example.com", i))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if r, err := db.CreateReservation(user.ID, conf.ID, 1, ReservationOptions{SeatIDs: []string{"B-1-1"}}); err == nil {
+				wins <- r.ID
+			} else if !errors.Is(err, ErrSeatTaken) {
+				t.Errorf("expected ErrSeatTaken, got %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(wins)
+	if len(wins) != 1 {
+		t.Fatalf("expected exactly one hold on the seat, got %d", len(wins))
+	}
+
+	replayed := newTestDB(t)
+	if err := replayed.ReplayAudit(db.GetAuditLog()); err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if again, _ := replayed.SeatMap(conf.ID); again.Booked != 4 || again.Held != 1 {
+		t.Fatalf("expected the replayed seat map to match, got %+v", again)
+	}
+}
+
+func TestTicketsAreCheckedInExactlyOnce(t *testing.T) {
+	db, user, _ := makeDBWithUserAndConf(t)
+	conf := db.Conferences["conf-3"]
+	booking, err := db.CreateBooking(user.ID, conf.ID, 2, BookingOptions{Tier: "VIP"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	other, _ := db.CreateBooking(user.ID, "conf-2", 1, BookingOptions{})
+	tickets, _ := db.BookingTickets(booking.ID)
+
+	// concurrent scans of the same ticket: exactly one admits it
+	var wg sync.WaitGroup
+	admitted := make(chan struct{}, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := db.CheckInTicket(tickets[0].ID, CheckInOptions{StaffID: "staff-1"}); err == nil {
+				admitted <- struct{}{}
+			} else if !errors.Is(err, ErrAlreadyCheckedIn) {
+				t.Errorf("expected ErrAlreadyCheckedIn, got %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(admitted)
+	if len(admitted) != 1 {
+		t.Fatalf("expected exactly one check-in, got %d", len(admitted))
+	}
+	again, err := db.CheckInTicket(tickets[0].ID, CheckInOptions{})
+	if !errors.Is(err, ErrAlreadyCheckedIn) || again.Status != models.TicketStatusCheckedIn || again.CheckedInAt == nil {
+		t.Fatalf("expected the admitted ticket with ErrAlreadyCheckedIn, got %+v, %v", again, err)
+	}
+
+	if _, err := db.CheckInTicket(ticketID(other.ID, 1), CheckInOptions{ConferenceID: conf.ID}); !errors.Is(err, ErrWrongConference) {
+		t.Fatalf("expected ErrWrongConference, got %v", err)
+	}
+	if _, err := db.CheckInTicket(ticketID(booking.ID, 3), CheckInOptions{}); !errors.Is(err, ErrTicketNotFound) {
+		t.Fatalf("expected ErrTicketNotFound, got %v", err)
+	}
+	if _, err := db.CancelBooking(other.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := db.CheckInTicket(ticketID(other.ID, 1), CheckInOptions{}); !errors.Is(err, ErrTicketVoid) {
+		t.Fatalf("expected ErrTicketVoid, got %v", err)
+	}
+
+	stats, err := db.CheckInStats(conf.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats.Tickets != 2 || stats.CheckedIn != 1 || stats.NotArrived != 1 || stats.ByTier["VIP"] != (CheckInCount{Tickets: 2, CheckedIn: 1}) ||
+		len(stats.Recent) != 1 || stats.Recent[0].StaffID != "staff-1" || stats.LastCheckInAt == nil {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	replayed := newTestDB(t)
+	if err := replayed.ReplayAudit(db.GetAuditLog()); err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if drift := db.Drift(replayed); len(drift) != 0 {
+		t.Fatalf("unexpected drift: %v", drift)
+	}
+}
+
+func TestQueryConferencesFiltersAndSorts(t *testing.T) {
+	db := newTestDB(t)
+	ids := func(confs []*models.Conference) string {
+		var out []string
+		for _, c := range confs {
+			out = append(out, c.ID)
+		}
+		return strings.Join(out, ",")
+	}
+
+	if got := ids(db.QueryConferences(ConferenceQuery{Sort: SortByPrice, Descending: true})); got != "conf-2,conf-1,conf-3" {
+		t.Fatalf("expected price descending, got %s", got)
+	}
+	if got := ids(db.QueryConferences(ConferenceQuery{Location: "new york"})); got != "conf-2" {
+		t.Fatalf("expected location filter to match conf-2, got %s", got)
+	}
+	setAvailable(db, db.Conferences["conf-1"], 0)
+	if got := ids(db.QueryConferences(ConferenceQuery{AvailableOnly: true, Sort: "bogus"})); got != "conf-2,conf-3" {
+		t.Fatalf("expected sold-out conf-1 filtered and ID order, got %s", got)
+	}
+
+	low, high := 200.0, 300.0
+	if got := ids(db.QueryConferences(ConferenceQuery{MinPrice: &low, MaxPrice: &high})); got != "conf-1" {
+		t.Fatalf("expected only conf-1 in the price range, got %s", got)
+	}
+	if got := ids(db.QueryConferences(ConferenceQuery{From: db.now().AddDate(0, 1, 20), Sort: SortByDate})); got != "conf-1,conf-2" {
+		t.Fatalf("expected the date bound to drop conf-3, got %s", got)
+	}
+	if got := ids(db.QueryConferences(ConferenceQuery{To: db.now().AddDate(0, 2, 0)})); got != "conf-1,conf-3" {
+		t.Fatalf("expected the upper date bound to drop conf-2, got %s", got)
+	}
+}
+
+func TestExtendReservationIsCapped(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	res, err := db.CreateReservation(user.ID, conf.ID, 1, ReservationOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	original := res.ExpiresAt
+
+	for i := 1; i <= db.MaxExtensions; i++ {
+		extended, err := db.ExtendReservation(res.ID)
+		if err != nil {
+			t.Fatalf("extension %d: unexpected error: %v", i, err)
+		}
+		if want := original.Add(time.Duration(i) * ReservationHold); !extended.ExpiresAt.Equal(want) || extended.ExtensionCount != i {
+			t.Fatalf("extension %d: expected expiry %v and count %d, got %v and %d", i, want, i, extended.ExpiresAt, extended.ExtensionCount)
+		}
+	}
+	if _, err := db.ExtendReservation(res.ID); !errors.Is(err, ErrExtensionsExhausted) {
+		t.Fatalf("expected ErrExtensionsExhausted, got %v", err)
+	}
+}
+
+func TestExtensionStopsAtMaxHoldTimeAndWhenOversold(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	db.Close()
+	clock := time.Now().Truncate(time.Second)
+	db.SetClock(func() time.Time { return clock })
+	db.MaxExtensions = 5
+	db.MaxHoldTime = 40 * time.Second
+
+	res, err := db.CreateReservation(user.ID, conf.ID, 1, ReservationOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := db.ExtendReservation(res.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	extended, err := db.ExtendReservation(res.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := clock.Add(40 * time.Second); !extended.ExpiresAt.Equal(want) {
+		t.Fatalf("expected the second extension cut short at %v, got %v", want, extended.ExpiresAt)
+	}
+	if _, err := db.ExtendReservation(res.ID); !errors.Is(err, ErrMaxHoldReached) {
+		t.Fatalf("expected ErrMaxHoldReached, got %v", err)
+	}
+
+	// a capacity cut leaves the held seat without a ticket behind it
+	other, _ := db.CreateUser("Bob", "bob@example.com")
+	held, err := db.CreateReservation(other.ID, conf.ID, 1, ReservationOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sold := conf.TotalTickets - conf.AvailableTickets
+	total := sold + conf.ReservedHoldback + 1
+	if _, err := db.UpdateConference(conf.ID, ConferenceUpdate{TotalTickets: &total}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := db.ExtendReservation(held.ID); !errors.Is(err, ErrHoldOversold) {
+		t.Fatalf("expected ErrHoldOversold, got %v", err)
+	}
+	if got, _ := db.GetReservation(held.ID); !got.ExpiresAt.Equal(held.ExpiresAt) || got.ExtensionCount != 0 {
+		t.Fatalf("expected a refused extension to leave the hold alone, got %+v", got)
+	}
+}
+
+func TestExtendExpiredReservationFails(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	res, err := db.CreateReservation(user.ID, conf.ID, 1, ReservationOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	db.mutex.Lock()
+	db.Reservations[res.ID].ExpiresAt = time.Now().Add(-time.Second)
+	db.mutex.Unlock()
+
+	if _, err := db.ExtendReservation(res.ID); !errors.Is(err, ErrReservationExpired) {
+		t.Fatalf("expected ErrReservationExpired, got %v", err)
+	}
+}
+
+func TestFakeClockExpiresReservationWithoutSleeping(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	db.Close() // the janitor must not read the clock while the test moves it
+	clock := time.Now().Truncate(time.Second)
+	db.SetClock(func() time.Time { return clock })
+
+	res, err := db.CreateReservation(user.ID, conf.ID, 2, ReservationOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !res.ExpiresAt.Equal(clock.Add(ReservationHold)) {
+		t.Fatalf("expected expiry from the fake clock, got %v", res.ExpiresAt)
+	}
+
+	clock = res.ExpiresAt.Add(time.Second)
+	if _, err := db.ConfirmReservation(res.ID, BookingOptions{}); err == nil || err.Error() != "reservation has expired" {
+		t.Fatalf("expected reservation has expired, got %v", err)
+	}
+	db.cleanupExpiredReservations()
+	if _, ok := db.Reservations[res.ID]; ok {
+		t.Fatalf("expected cleanup to remove the expired reservation")
+	}
+	if _, err := db.GetReservation(res.ID); !errors.Is(err, ErrReservationExpired) {
+		t.Fatalf("expected lookup to report expiry, got %v", err)
+	}
+}
+
+func TestExpiredReservationStaysInHistory(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	db.Close()
+	clock := time.Now()
+	db.SetClock(func() time.Time { return clock })
+
+	res, err := db.CreateReservation(user.ID, conf.ID, 2, ReservationOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	clock = res.ExpiresAt.Add(time.Second)
+
+	again, err := db.CreateReservation(user.ID, conf.ID, 1, ReservationOptions{})
+	if err != nil {
+		t.Fatalf("expected the lapsed hold not to block a new one, got %v", err)
+	}
+	history := db.GetUserReservationHistory(user.ID)
+	if len(history) != 2 {
+		t.Fatalf("expected 2 reservations in history, got %d", len(history))
+	}
+	if history[0].ID != again.ID || history[0].Status != models.ReservationStatusActive {
+		t.Fatalf("expected the new active hold first, got %+v", history[0])
+	}
+	if history[1].ID != res.ID || history[1].Status != models.ReservationStatusExpired {
+		t.Fatalf("expected the lapsed hold marked expired, got %+v", history[1])
+	}
+	if active := db.GetUserReservations(user.ID); len(active) != 1 {
+		t.Fatalf("expected only the new hold to be active, got %d", len(active))
+	}
+}
+
+func TestPromoCodeDiscountsAndCountsUses(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	db.PromoCodes["HALF"] = &models.PromoCode{Code: "HALF", PercentOff: 50, MaxUses: 1}
+
+	res, err := db.CreateReservation(user.ID, conf.ID, 2, ReservationOptions{PromoCode: " half "})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := conf.Price; res.TotalAmount != want || res.PromoCode != "HALF" {
+		t.Fatalf("expected half of %v with HALF applied, got %v %q", 2*conf.Price, res.TotalAmount, res.PromoCode)
+	}
+	booking, err := db.ConfirmReservation(res.ID, BookingOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if booking.TotalAmount != res.TotalAmount || db.PromoCodes["HALF"].Uses != 1 {
+		t.Fatalf("expected discounted booking and one use, got %v and %d", booking.TotalAmount, db.PromoCodes["HALF"].Uses)
+	}
+}
+
+func TestExpiredPromoCodeIsRejected(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	db.PromoCodes["OLD"] = &models.PromoCode{Code: "OLD", AmountOff: 10, ExpiresAt: time.Now().Add(-time.Hour)}
+
+	_, err := db.CreateReservation(user.ID, conf.ID, 1, ReservationOptions{PromoCode: "OLD"})
+	if !errors.Is(err, ErrInvalidPromoCode) || !strings.Contains(err.Error(), "expired") {
+		t.Fatalf("expected an expired promo code error, got %v", err)
+	}
+	if n := len(db.GetUserReservations(user.ID)); n != 0 {
+		t.Fatalf("expected nothing reserved, got %d", n)
+	}
+}
+
+func TestExhaustedPromoCodeIsRejected(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	other, _ := db.CreateUser("Bob", "bob@example.com")
+	db.PromoCodes["ONCE"] = &models.PromoCode{Code: "ONCE", AmountOff: 10, MaxUses: 1}
+
+	if _, err := db.CreateReservation(user.ID, conf.ID, 1, ReservationOptions{PromoCode: "ONCE"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the active hold already claims the only use
+	_, err := db.CreateReservation(other.ID, conf.ID, 1, ReservationOptions{PromoCode: "ONCE"})
+	if !errors.Is(err, ErrInvalidPromoCode) || !strings.Contains(err.Error(), "used up") {
+		t.Fatalf("expected a used-up promo code error, got %v", err)
+	}
+}
+
+func TestStatsAndReservationsAgreeOnReservedSeats(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	db.Close()
+	bob, _ := db.CreateUser("Bob", "bob@example.com")
+	carol, _ := db.CreateUser("Carol", "carol@example.com")
+	setAvailable(db, conf, 5)
+
+	if _, err := db.CreateReservation(user.ID, conf.ID, 2, ReservationOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lapsed, err := db.CreateReservation(bob.ID, conf.ID, 2, ReservationOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	db.mutex.Lock()
+	db.Reservations[lapsed.ID].ExpiresAt = time.Now().Add(-time.Second) // expired but not yet cleaned up
+	db.mutex.Unlock()
+
+	if got := db.GetConferenceStats()[conf.ID].Reserved; got != 2 {
+		t.Fatalf("expected stats to count only the active hold, got %d", got)
+	}
+	free := conf.AvailableTickets - conf.ReservedHoldback - db.GetConferenceStats()[conf.ID].Reserved
+	if _, err := db.CreateReservation(carol.ID, conf.ID, free, ReservationOptions{}); err != nil {
+		t.Fatalf("expected the %d seats stats reports free to be reservable, got %v", free, err)
+	}
+}
+
+func TestVIPTierSellsOutWhileGeneralRemains(t *testing.T) {
+	db := newTestDB(t)
+	db.MaxTicketsPerUser = 0
+	user, _ := db.CreateUser("Alice", "alice@example.com")
+	conf := db.Conferences["conf-3"]
+	vip := findTier(conf, "VIP")
+
+	booking, err := db.CreateBooking(user.ID, conf.ID, vip.TotalTickets-1, BookingOptions{Tier: "vip"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if booking.Tier != "VIP" || booking.TotalAmount != vip.Price*float64(vip.TotalTickets-1) {
+		t.Fatalf("expected a VIP booking at the VIP price, got %q %v", booking.Tier, booking.TotalAmount)
+	}
+	res, err := db.CreateReservation(user.ID, conf.ID, 1, ReservationOptions{Tier: "VIP"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	other, _ := db.CreateUser("Bob", "bob@example.com")
+	if _, err := db.CreateReservation(other.ID, conf.ID, 1, ReservationOptions{Tier: "VIP"}); err == nil {
+		t.Fatalf("expected the last VIP seat to be held already")
+	}
+	if _, err := db.ConfirmReservation(res.ID, BookingOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if vip.AvailableTickets != 0 || conf.AvailableTickets != conf.TotalTickets-vip.TotalTickets {
+		t.Fatalf("expected VIP sold out and the aggregate down by %d, got %d and %d", vip.TotalTickets, vip.AvailableTickets, conf.AvailableTickets)
+	}
+	if _, err := db.CreateBooking(other.ID, conf.ID, 1, BookingOptions{Tier: "VIP"}); err == nil {
+		t.Fatalf("expected VIP to be sold out")
+	}
+
+	general, err := db.CreateBooking(other.ID, conf.ID, 2, BookingOptions{Tier: "General"})
+	if err != nil || general.TotalAmount != 2*findTier(conf, "General").Price {
+		t.Fatalf("expected General to remain on sale, got %v %v", general, err)
+	}
+	if _, err := db.CreateBooking(other.ID, conf.ID, 1, BookingOptions{Tier: "Balcony"}); !errors.Is(err, ErrTierNotFound) {
+		t.Fatalf("expected ErrTierNotFound, got %v", err)
+	}
+}
+
+func TestUntieredSalesStayOutOfTheTiers(t *testing.T) {
+	db := newTestDB(t)
+	db.MaxTicketsPerUser = 0
+	user, _ := db.CreateUser("Alice", "alice@example.com")
+
+	// every seat of conf-3 is in a tier, so nothing sells without one
+	full := db.Conferences["conf-3"]
+	if _, err := db.CreateBooking(user.ID, full.ID, full.TotalTickets, BookingOptions{}); !errors.Is(err, ErrSoldOut) {
+		t.Fatalf("expected an untiered booking refused, got %v", err)
+	}
+	if _, err := db.CreateReservation(user.ID, full.ID, 1, ReservationOptions{}); !errors.Is(err, ErrSoldOut) {
+		t.Fatalf("expected an untiered hold refused, got %v", err)
+	}
+	if full.AvailableTickets != full.TotalTickets || full.Tiers[0].AvailableTickets != full.Tiers[0].TotalTickets {
+		t.Fatalf("expected nothing sold, got %d and %+v", full.AvailableTickets, full.Tiers)
+	}
+
+	// seats outside the tiers sell at the flat price, and no further
+	conf, err := db.CreateConference("Tiered", "Austin", 10, 100, time.Now().AddDate(0, 1, 0), "", ConferenceOptions{
+		Tiers: []models.Tier{{Name: "VIP", Price: 300, TotalTickets: 4}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	res, err := db.CreateReservation(user.ID, conf.ID, 2, ReservationOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := db.CreateBooking(user.ID, conf.ID, 5, BookingOptions{}); !errors.Is(err, ErrSoldOut) {
+		t.Fatalf("expected the held seats to count against the untiered room, got %v", err)
+	}
+	booking, err := db.CreateBooking(user.ID, conf.ID, 4, BookingOptions{})
+	if err != nil || booking.TotalAmount != 400 {
+		t.Fatalf("expected 4 untiered seats at the flat price, got %+v %v", booking, err)
+	}
+	if _, err := db.ConfirmReservation(res.ID, BookingOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := db.CreateBooking(user.ID, conf.ID, 1, BookingOptions{}); !errors.Is(err, ErrSoldOut) {
+		t.Fatalf("expected the untiered seats sold out, got %v", err)
+	}
+	if _, err := db.CreateBooking(user.ID, conf.ID, 4, BookingOptions{Tier: "VIP"}); err != nil {
+		t.Fatalf("expected the VIP seats still on sale, got %v", err)
+	}
+}
+
+func TestWaitlistAndAnalyticsFollowTiers(t *testing.T) {
+	db := newTestDB(t)
+	db.MaxTicketsPerUser = 0
+	date := time.Now().AddDate(0, 1, 0)
+	if _, err := db.CreateConference("Tiered", "Austin", 10, 100, date, "", ConferenceOptions{
+		Tiers: []models.Tier{{Name: "VIP", Price: 300, TotalTickets: 6}, {Name: "Student", Price: 50, TotalTickets: 5}},
+	}); err == nil {
+		t.Fatalf("expected tiers with more seats than the conference to be rejected")
+	}
+	if _, err := db.CreateConference("Tiered", "Austin", 10, 100, date, "", ConferenceOptions{
+		Tiers: []models.Tier{{Name: "VIP", Price: 300, TotalTickets: 2}, {Name: "vip", Price: 50, TotalTickets: 3}},
+	}); err == nil {
+		t.Fatalf("expected a repeated tier name to be rejected")
+	}
+	conf, err := db.CreateConference("Tiered", "Austin", 10, 100, date, "", ConferenceOptions{
+		Tiers: []models.Tier{{Name: " VIP ", Price: 300, TotalTickets: 2}, {Name: "Student", Price: 50, TotalTickets: 3}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(conf.Tiers) != 2 || conf.Tiers[0].Name != "VIP" || conf.Tiers[0].AvailableTickets != 2 {
+		t.Fatalf("expected trimmed tiers with every seat available, got %+v", conf.Tiers)
+	}
+
+	alice, _ := db.CreateUser("Alice", "alice@example.com")
+	bob, _ := db.CreateUser("Bob", "bob@example.com")
+	carol, _ := db.CreateUser("Carol", "carol@example.com")
+	vipBooking, err := db.CreateBooking(alice.ID, conf.ID, 2, BookingOptions{Tier: "VIP"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := db.EnqueueWait(bob.ID, conf.ID, 1, "Balcony"); !errors.Is(err, ErrTierNotFound) {
+		t.Fatalf("expected ErrTierNotFound, got %v", err)
+	}
+	if _, err := db.EnqueueWait(bob.ID, conf.ID, 1, "vip"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status := db.GetQueuePosition(bob.ID, conf.ID); status.Tier != "VIP" {
+		t.Fatalf("expected bob to wait for VIP, got %+v", status)
+	}
+
+	// The conference has seats, but none in the tier bob waits for
+	if _, err := db.ClaimNext(bob.ID, conf.ID, false); !errors.Is(err, ErrSoldOut) {
+		t.Fatalf("expected ErrSoldOut while VIP is sold out, got %v", err)
+	}
+	if _, err := db.CancelBooking(vipBooking.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	res, err := db.ClaimNext(bob.ID, conf.ID, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Tier != "VIP" || res.TotalAmount != 300 {
+		t.Fatalf("expected a VIP hold at the VIP price, got %q %v", res.Tier, res.TotalAmount)
+	}
+	if _, err := db.CreateBooking(carol.ID, conf.ID, 2, BookingOptions{Tier: "Student"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := db.EnqueueWait(alice.ID, conf.ID, 1, "Student"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	stats := db.GetConferenceAnalytics()[conf.ID]
+	if len(stats.Tiers) != 2 {
+		t.Fatalf("expected analytics for both tiers, got %+v", stats.Tiers)
+	}
+	vip, student := stats.Tiers[0], stats.Tiers[1]
+	if vip.TicketsSold != 0 || vip.ActiveReservations != 1 || vip.AvailableTickets != 2 {
+		t.Fatalf("expected VIP to show bob's hold and no sales, got %+v", vip)
+	}
+	if student.TicketsSold != 2 || student.Revenue != 100 || student.QueueLength != 1 || student.AvailableTickets != 1 {
+		t.Fatalf("expected Student to show carol's booking and alice waiting, got %+v", student)
+	}
+	if stats.TicketsSold != 2 || stats.QueueLength != 1 {
+		t.Fatalf("expected the totals to include the tiers, got %+v", stats)
+	}
+
+	replayed := newTestDB(t)
+	if err := replayed.ReplayAudit(db.GetAuditLog()); err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if drift := db.Drift(replayed); len(drift) != 0 {
+		t.Fatalf("expected no drift, got %v", drift)
+	}
+	if got := replayed.Conferences[conf.ID].Tiers; len(got) != 2 || got[1].AvailableTickets != 1 {
+		t.Fatalf("expected the tiers to survive replay, got %+v", got)
+	}
+	if got := replayed.WaitQueues[conf.ID]; len(got) != 1 || got[0].Tier != "Student" {
+		t.Fatalf("expected the queued tier to survive replay, got %+v", got)
+	}
+}
+
+func TestListReservationsFiltersAndSortsByExpiry(t *testing.T) {
+	db := newTestDB(t)
+	clock := time.Now()
+	db.SetClock(func() time.Time { return clock })
+	alice, _ := db.CreateUser("Alice", "alice@example.com")
+	bob, _ := db.CreateUser("Bob", "bob@example.com")
+
+	first, err := db.CreateReservation(alice.ID, "conf-1", 1, ReservationOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	clock = clock.Add(time.Second)
+	other, err := db.CreateReservation(alice.ID, "conf-2", 1, ReservationOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	clock = clock.Add(time.Second)
+	second, err := db.CreateReservation(bob.ID, "conf-1", 2, ReservationOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if all := db.ListReservations(ReservationFilter{}); len(all) != 3 {
+		t.Fatalf("expected 3 active reservations, got %d", len(all))
+	}
+	got := db.ListReservations(ReservationFilter{ConferenceID: "conf-1"})
+	if len(got) != 2 || got[0].ID != first.ID || got[1].ID != second.ID {
+		t.Fatalf("expected conf-1 holds soonest to expire first, got %+v", got)
+	}
+	got = db.ListReservations(ReservationFilter{ConferenceID: "conf-1", UserID: bob.ID})
+	if len(got) != 1 || got[0].ID != second.ID {
+		t.Fatalf("expected only bob's conf-1 hold, got %+v", got)
+	}
+
+	// once the first two lapse only bob's hold is listed
+	clock = other.ExpiresAt.Add(time.Millisecond)
+	if got := db.ListReservations(ReservationFilter{}); len(got) != 1 || got[0].ID != second.ID {
+		t.Fatalf("expected lapsed holds cleaned up before listing, got %+v", got)
+	}
+}
+
+func TestEnqueueRejectsUsersWhoAlreadyHaveSeats(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	reserver, _ := db.CreateUser("Bob", "bob@example.com")
+	waiter, _ := db.CreateUser("Carol", "carol@example.com")
+
+	if _, err := db.CreateBooking(user.ID, conf.ID, 1, BookingOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := db.EnqueueWait(user.ID, conf.ID, 1, ""); !errors.Is(err, ErrAlreadyHasSeats) || !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected a booked user to be refused as a conflict, got %v", err)
+	}
+
+	if _, err := db.CreateReservation(reserver.ID, conf.ID, 1, ReservationOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := db.EnqueueWait(reserver.ID, conf.ID, 1, ""); !errors.Is(err, ErrAlreadyHasSeats) {
+		t.Fatalf("expected a user holding a reservation to be refused, got %v", err)
+	}
+	if _, err := db.EnqueueWait(reserver.ID, "conf-2", 1, ""); err != nil {
+		t.Fatalf("expected seats at one conference not to block another queue, got %v", err)
+	}
+
+	if pos, err := db.EnqueueWait(waiter.ID, conf.ID, 1, ""); err != nil || pos != 1 {
+		t.Fatalf("expected first enqueue at position 1, got %d %v", pos, err)
+	}
+	if pos, err := db.EnqueueWait(waiter.ID, conf.ID, 3, ""); err != nil || pos != 1 {
+		t.Fatalf("expected re-enqueue to keep the spot, got %d %v", pos, err)
+	}
+	if q := db.WaitQueues[conf.ID]; len(q) != 1 || q[0].TicketCount != 3 {
+		t.Fatalf("expected one entry with the updated count, got %+v", q)
+	}
+}
+
+func TestQueueWaitEstimateFollowsTurnover(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	db.Close()
+	clock := time.Now()
+	db.SetClock(func() time.Time { return clock })
+	waiter, _ := db.CreateUser("Bob", "bob@example.com")
+	db.EnqueueWait(user.ID, "conf-2", 2, "")
+	db.EnqueueWait(waiter.ID, "conf-2", 1, "")
+
+	if got := db.GetQueuePosition(waiter.ID, "conf-2").EstimatedWaitSeconds; got != 2*int(ReservationHold.Seconds()) {
+		t.Fatalf("expected the hold time per seat ahead before any turnover, got %d", got)
+	}
+	if got := db.GetQueuePosition(user.ID, "conf-2").EstimatedWaitSeconds; got != 0 {
+		t.Fatalf("expected no wait at the head, got %d", got)
+	}
+
+	// quick confirmations at conf-2 pull its estimate down; conf-1 is unaffected
+	before := db.GetQueuePosition(waiter.ID, "conf-2").EstimatedWaitSeconds
+	for i, took := range []time.Duration{4 * time.Second, time.Second} {
+		buyer, _ := db.CreateUser(fmt.Sprintf("Buyer %d", i), fmt.Sprintf("buyer%d@example.com", i))
+		res, err := db.CreateReservation(buyer.ID, "conf-2", 1, ReservationOptions{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		clock = clock.Add(took)
+		if _, err := db.ConfirmReservation(res.ID, BookingOptions{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		after := db.GetQueuePosition(waiter.ID, "conf-2").EstimatedWaitSeconds
+		if after >= before {
+			t.Fatalf("expected confirmation %d to lower the estimate below %d, got %d", i+1, before, after)
+		}
+		before = after
+	}
+	if got := db.secondsPerSeatLocked(conf.ID); got != ReservationHold.Seconds() {
+		t.Fatalf("expected conf-1 to keep the fallback, got %v", got)
+	}
+
+	// a hold that runs out pushes the estimate back up
+	slow, _ := db.CreateUser("Slow", "slow@example.com")
+	if _, err := db.CreateReservation(slow.ID, "conf-2", 1, ReservationOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	clock = clock.Add(ReservationHold + time.Second)
+	db.cleanupExpiredReservations()
+	if after := db.GetQueuePosition(waiter.ID, "conf-2").EstimatedWaitSeconds; after <= before {
+		t.Fatalf("expected an expiry to raise the estimate above %d, got %d", before, after)
+	}
+}
+
+func TestQueueRejectsEnqueuesPastItsCap(t *testing.T) {
+	db := newTestDB(t)
+	conf, err := db.CreateConference("Tiny", "Remote", 2, 10, time.Now().Add(24*time.Hour), "", ConferenceOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := db.GetConferenceStats()[conf.ID].QueueCap; got != 4 {
+		t.Fatalf("expected 2 waiters per unsold seat, got cap %d", got)
+	}
+
+	buyer, _ := db.CreateUser("Buyer", "buyer@example.com")
+	if _, err := db.CreateBooking(buyer.ID, conf.ID, 2, BookingOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// sold out: each sold seat only leaves room for one waiter
+	var waiters []string
+	for i := 0; i < 2; i++ {
+		u, _ := db.CreateUser(fmt.Sprintf("Waiter %d", i), fmt.Sprintf("waiter%d@example.com", i))
+		if _, err := db.EnqueueWait(u.ID, conf.ID, 1, ""); err != nil {
+			t.Fatalf("unexpected error filling the queue: %v", err)
+		}
+		waiters = append(waiters, u.ID)
+	}
+	stats := db.GetConferenceStats()[conf.ID]
+	if stats.Queue != 2 || stats.QueueCap != 2 {
+		t.Fatalf("expected a full queue of 2, got %+v", stats)
+	}
+
+	late, _ := db.CreateUser("Late", "late@example.com")
+	if _, err := db.EnqueueWait(late.ID, conf.ID, 1, ""); !errors.Is(err, ErrQueueFull) || !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected ErrQueueFull once the queue is at its cap, got %v", err)
+	}
+	if pos, err := db.EnqueueWait(waiters[0], conf.ID, 2, ""); err != nil || pos != 1 {
+		t.Fatalf("expected a queued user to still update their request, got %d %v", pos, err)
+	}
+
+	db.MaxQueueLength = 1
+	if got := db.GetConferenceStats()["conf-1"].QueueCap; got != 1 {
+		t.Fatalf("expected MaxQueueLength to bound every queue, got %d", got)
+	}
+}
+
+func TestDirectBookingCannotTakeReservedSeats(t *testing.T) {
+	db := newTestDB(t)
+	conf, err := db.CreateConference("Small", "Remote", 5, 10, time.Now().Add(24*time.Hour), "", ConferenceOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	holder, _ := db.CreateUser("Holder", "holder@example.com")
+	buyer, _ := db.CreateUser("Buyer", "buyer@example.com")
+
+	res, err := db.CreateReservation(holder.ID, conf.ID, 4, ReservationOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := db.CreateBooking(buyer.ID, conf.ID, 2, BookingOptions{}); err == nil {
+		t.Fatalf("expected a booking past the unreserved remainder to fail")
+	}
+	if _, err := db.CreateBooking(buyer.ID, conf.ID, 1, BookingOptions{}); err != nil {
+		t.Fatalf("expected the one unreserved seat to be bookable, got %v", err)
+	}
+	if _, err := db.ConfirmReservation(res.ID, BookingOptions{}); err != nil {
+		t.Fatalf("expected the held seats to still be confirmable, got %v", err)
+	}
+	if got, _ := db.GetConference(conf.ID); got.AvailableTickets != 0 {
+		t.Fatalf("expected exactly the capacity sold, %d left", got.AvailableTickets)
+	}
+}
+
+// stubPayments declines while declining is set and records every charge it sees
+type stubPayments struct {
+	declining bool
+	charged   []float64
+}
+
+func (p *stubPayments) Charge(amount float64, userID string) error {
+	p.charged = append(p.charged, amount)
+	if p.declining {
+		return errors.New("insufficient funds")
+	}
+	return nil
+}
+
+func TestDeclinedPaymentKeepsReservation(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	payments := &stubPayments{declining: true}
+	db.Payments = payments
+	available := conf.AvailableTickets
+
+	res, err := db.CreateReservation(user.ID, conf.ID, 2, ReservationOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := db.ConfirmReservation(res.ID, BookingOptions{}); !errors.Is(err, ErrPaymentFailed) {
+		t.Fatalf("expected ErrPaymentFailed, got %v", err)
+	}
+	if got, err := db.GetReservation(res.ID); err != nil || got.Status != models.ReservationStatusActive {
+		t.Fatalf("expected the reservation to stay active after a declined charge, got %+v %v", got, err)
+	}
+	if c, _ := db.GetConference(conf.ID); c.AvailableTickets != available || len(db.Bookings) != 0 {
+		t.Fatalf("expected no seats sold on a declined charge")
+	}
+
+	payments.declining = false
+	booking, err := db.ConfirmReservation(res.ID, BookingOptions{})
+	if err != nil {
+		t.Fatalf("expected the retry to succeed, got %v", err)
+	}
+	if booking.TotalAmount != res.TotalAmount || len(payments.charged) != 2 || payments.charged[1] != res.TotalAmount {
+		t.Fatalf("expected the reservation total charged on each attempt, got %v", payments.charged)
+	}
+	if _, err := db.GetReservation(res.ID); err == nil {
+		t.Fatalf("expected a paid reservation to be consumed")
+	}
+}
+
+func TestCompletePaymentBooksTheHoldWithoutCharging(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	payments := &stubPayments{declining: true}
+	db.Payments = payments
+	clock := time.Now()
+	db.SetClock(func() time.Time { return clock })
+	res, err := db.CreateReservation(user.ID, conf.ID, 2, ReservationOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	db.MaxHoldTime = 10 * time.Minute
+	paying, err := db.AttachPayment(res.ID, "pi_1", "pi_1_secret")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !paying.ExpiresAt.Equal(res.ExpiresAt) {
+		t.Fatalf("expected attaching the intent to leave the hold at %v, got %v", res.ExpiresAt, paying.ExpiresAt)
+	}
+	paying, err = db.PrepareConfirmation(res.ID, BookingOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := res.CreatedAt.Add(db.MaxHoldTime); !paying.ExpiresAt.Equal(want) {
+		t.Fatalf("expected the hold kept for the payment until MaxHoldTime at %v, got %v", want, paying.ExpiresAt)
+	}
+	if _, err := db.AttachPayment(res.ID, "pi_1", "pi_1_secret"); err != nil {
+		t.Fatalf("expected attaching the same intent again to be a no-op, got %v", err)
+	}
+	if _, err := db.AttachPayment(res.ID, "pi_2", "pi_2_secret"); !errors.Is(err, ErrPaymentMismatch) {
+		t.Fatalf("expected ErrPaymentMismatch, got %v", err)
+	}
+	if _, err := db.CompletePayment("pi_unknown"); !errors.Is(err, ErrReservationNotFound) {
+		t.Fatalf("expected ErrReservationNotFound for an unknown payment, got %v", err)
+	}
+
+	// the payment finishes long after a normal hold would have lapsed
+	clock = clock.Add(5 * time.Minute)
+	db.cleanupExpiredReservations()
+	booking, err := db.CompletePayment("pi_1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if booking.PaymentID != "pi_1" || len(payments.charged) != 0 {
+		t.Fatalf("expected a booking tied to the payment and no charge, got %+v after %v", booking, payments.charged)
+	}
+
+	replayed := newTestDB(t)
+	if err := replayed.ReplayAudit(db.GetAuditLog()); err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if drift := db.Drift(replayed); len(drift) != 0 {
+		t.Fatalf("expected no drift, got %v", drift)
+	}
+	if got := replayed.Bookings[booking.ID].PaymentID; got != "pi_1" {
+		t.Fatalf("expected the payment to survive replay, got %q", got)
+	}
+}
+
+func TestSimulatedPaymentsDeclineListedAmounts(t *testing.T) {
+	p := NewSimulatedPayments(0, 50)
+	if err := p.Charge(50, "u1"); err == nil {
+		t.Fatalf("expected a listed amount to be declined")
+	}
+	if err := p.Charge(49.99, "u1"); err != nil {
+		t.Fatalf("expected other amounts to pass at 0%%, got %v", err)
+	}
+	if err := NewSimulatedPayments(100).Charge(10, "u1"); err == nil {
+		t.Fatalf("expected every charge to fail at 100%%")
+	}
+}
+
+func TestRegisterUserCanAuthenticateAndSurvivesRestore(t *testing.T) {
+	db := newTestDB(t)
+	user, err := db.RegisterUser("Ann", "ann@example.com", "correct horse", models.RoleUser)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := db.CreateUser("Ben", "ben@example.com"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got, err := db.Authenticate("ANN@example.com", "correct horse"); err != nil || got.ID != user.ID {
+		t.Fatalf("expected Ann to log in, got %v, %v", got, err)
+	}
+	for _, tc := range []struct{ email, password string }{
+		{"ann@example.com", "wrong password"},
+		{"nobody@example.com", "correct horse"},
+		{"ben@example.com", ""},
+	} {
+		if _, err := db.Authenticate(tc.email, tc.password); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("%s: expected ErrInvalidCredentials, got %v", tc.email, err)
+		}
+	}
+
+	restored := newTestDB(t)
+	restored.Restore(db.Snapshot())
+	if _, err := restored.Authenticate("ann@example.com", "correct horse"); err != nil {
+		t.Fatalf("expected credentials to survive a snapshot, got %v", err)
+	}
+}
+
+func TestSetUserRoleIsAuditedAndReplayed(t *testing.T) {
+	db, user, _ := makeDBWithUserAndConf(t)
+	if user.IsAdmin() {
+		t.Fatalf("expected new users to start without the admin role")
+	}
+	if _, err := db.SetUserRole(user.ID, "owner"); err == nil {
+		t.Fatalf("expected an unknown role to be rejected")
+	}
+	if _, err := db.SetUserRole("missing", models.RoleAdmin); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for an unknown user, got %v", err)
+	}
+	writes := db.Writes()
+	promoted, err := db.SetUserRole(user.ID, models.RoleAdmin)
+	if err != nil || !promoted.IsAdmin() {
+		t.Fatalf("expected the user to be promoted, got %v, %v", promoted, err)
+	}
+	if db.Writes() != writes+1 {
+		t.Fatalf("expected the promotion to count as one write")
+	}
+	if _, err := db.SetUserRole(user.ID, models.RoleAdmin); err != nil || db.Writes() != writes+1 {
+		t.Fatalf("expected setting the same role again to be a no-op, got %v", err)
+	}
+
+	replayed := newTestDB(t)
+	if err := replayed.ReplayAudit(db.GetAuditLog()); err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if got, err := replayed.GetUser(user.ID); err != nil || !got.IsAdmin() {
+		t.Fatalf("expected the admin role to survive replay, got %v, %v", got, err)
+	}
+}
+
+func TestUpdateConferenceDetailsAndDelete(t *testing.T) {
+	db := newTestDB(t)
+	conf, err := db.CreateConference("GopherCon", "Berlin", 10, 100, time.Now().AddDate(0, 1, 0), "org-1", ConferenceOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if conf.OrganizerID != "org-1" {
+		t.Fatalf("expected the organizer to be recorded, got %q", conf.OrganizerID)
+	}
+
+	past, blank := time.Now().Add(-time.Hour), "  "
+	if _, err := db.UpdateConference(conf.ID, ConferenceUpdate{Date: &past}); err == nil {
+		t.Fatalf("expected a past date to be rejected")
+	}
+	if _, err := db.UpdateConference(conf.ID, ConferenceUpdate{Name: &blank}); err == nil {
+		t.Fatalf("expected a blank name to be rejected")
+	}
+	name, location, date := "GopherCon EU", "Amsterdam", time.Now().AddDate(0, 2, 0).UTC()
+	updated, err := db.UpdateConference(conf.ID, ConferenceUpdate{Name: &name, Location: &location, Date: &date})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if updated.Name != name || updated.Location != location || !updated.Date.Equal(date) || updated.TotalTickets != 10 {
+		t.Fatalf("expected only the details to change, got %+v", updated)
+	}
+
+	user, _ := db.CreateUser("Alice", "alice@example.com")
+	other, _ := db.CreateUser("Bob", "bob@example.com")
+	booking, err := db.CreateBooking(user.ID, conf.ID, 2, BookingOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	hold, err := db.CreateReservation(other.ID, conf.ID, 1, ReservationOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	waiter, _ := db.CreateUser("Cat", "cat@example.com")
+	if _, err := db.EnqueueWait(waiter.ID, conf.ID, 1, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := db.DeleteConference(conf.ID); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected a conference with confirmed bookings to be kept, got %v", err)
+	}
+	if _, err := db.CancelBooking(booking.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := db.DeleteConference(conf.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := db.GetConference(conf.ID); !errors.Is(err, ErrConferenceNotFound) {
+		t.Fatalf("expected the conference to be gone, got %v", err)
+	}
+	if _, err := db.GetReservation(hold.ID); err == nil {
+		t.Fatalf("expected the hold to be cancelled with the conference")
+	}
+	if status := db.GetQueuePosition(waiter.ID, conf.ID); status.Position != 0 {
+		t.Fatalf("expected the wait queue to be dropped with the conference")
+	}
+	if err := db.DeleteConference(conf.ID); !errors.Is(err, ErrConferenceNotFound) {
+		t.Fatalf("expected ErrConferenceNotFound deleting twice, got %v", err)
+	}
+
+	replayed := newTestDB(t)
+	if err := replayed.ReplayAudit(db.GetAuditLog()); err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if drift := db.Drift(replayed); len(drift) != 0 {
+		t.Fatalf("expected no drift, got %v", drift)
+	}
+}
+
+func TestFreedSeatsAreOfferedDownTheQueue(t *testing.T) {
+	db := newTestDB(t)
+	db.Close() // drive expiry by hand
+	clock := time.Now().Truncate(time.Second)
+	db.SetClock(func() time.Time { return clock })
+	db.OfferWindow = 20 * time.Second
+	conf, err := db.CreateConference("Tiny", "Remote", 2, 10, time.Now().Add(24*time.Hour), "", ConferenceOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	user, _ := db.CreateUser("Alice", "alice@example.com")
+	bob, _ := db.CreateUser("Bob", "bob@example.com")
+	carol, _ := db.CreateUser("Carol", "carol@example.com")
+
+	booking, err := db.CreateBooking(user.ID, conf.ID, 2, BookingOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	db.EnqueueWait(bob.ID, conf.ID, 2, "")
+	db.EnqueueWait(carol.ID, conf.ID, 2, "")
+
+	if _, err := db.CancelBooking(booking.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	st := db.GetQueuePosition(bob.ID, conf.ID)
+	if st.Position != 0 || st.Offer == nil {
+		t.Fatalf("expected bob to leave the queue with an offer, got %+v", st)
+	}
+	offer := st.Offer
+	if offer.Status != models.ReservationStatusOffered || offer.TicketCount != 2 || !offer.ExpiresAt.Equal(clock.Add(20*time.Second)) {
+		t.Fatalf("expected a 2-seat offer open for 20s, got %+v", offer)
+	}
+	if st := db.GetQueuePosition(carol.ID, conf.ID); st.Position != 1 || st.Offer != nil {
+		t.Fatalf("expected carol to move to the head without an offer, got %+v", st)
+	}
+	if _, err := db.ExtendReservation(offer.ID); !errors.Is(err, ErrOfferPending) {
+		t.Fatalf("expected ErrOfferPending extending an offer, got %v", err)
+	}
+
+	// Bob lets the offer lapse; the seats go to carol
+	clock = clock.Add(21 * time.Second)
+	db.cleanupExpiredReservations()
+	if _, err := db.AcceptOffer(offer.ID); !errors.Is(err, ErrReservationExpired) {
+		t.Fatalf("expected the lapsed offer to be expired, got %v", err)
+	}
+	st = db.GetQueuePosition(carol.ID, conf.ID)
+	if st.Offer == nil {
+		t.Fatalf("expected carol to be offered the seats bob let go, got %+v", st)
+	}
+
+	accepted, err := db.AcceptOffer(st.Offer.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if accepted.Status != models.ReservationStatusActive || !accepted.ExpiresAt.Equal(clock.Add(ReservationHold)) {
+		t.Fatalf("expected an active hold for ReservationHold, got %+v", accepted)
+	}
+	if _, err := db.AcceptOffer(accepted.ID); !errors.Is(err, ErrNotAnOffer) {
+		t.Fatalf("expected ErrNotAnOffer accepting twice, got %v", err)
+	}
+	if _, err := db.ConfirmReservation(accepted.ID, BookingOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	replayed := newTestDB(t)
+	if err := replayed.ReplayAudit(db.GetAuditLog()); err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if drift := db.Drift(replayed); len(drift) != 0 {
+		t.Fatalf("expected no drift, got %v", drift)
+	}
+}
+
+func TestSearchConferencesRanksByRelevance(t *testing.T) {
+	db := newTestDB(t)
+	conf, err := db.CreateConference("Gopher Meetup", "Berlin", 10, 0, time.Now().AddDate(0, 1, 0), "",
+		ConferenceOptions{Description: "An evening of lightning talks", Tags: []string{" Go ", "community", "go"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(conf.Tags, ",") != "go,community" {
+		t.Fatalf("expected tags trimmed, lower-cased and deduplicated, got %v", conf.Tags)
+	}
+
+	ids := func(matches []ConferenceMatch) string {
+		var out []string
+		for _, m := range matches {
+			out = append(out, m.Conference.ID)
+		}
+		return strings.Join(out, ",")
+	}
+	// The sample Go Conference has "go" in its name and tags; the meetup only in its tags
+	if got := ids(db.SearchConferences("Go")); got != "conf-1,"+conf.ID {
+		t.Fatalf("expected the name match to rank first, got %s", got)
+	}
+	// Every word must match somewhere, and a word prefix counts
+	if got := ids(db.SearchConferences("lightning berl")); got != conf.ID {
+		t.Fatalf("expected description and location prefix to find the meetup, got %s", got)
+	}
+	if got := ids(db.SearchConferences("go seattle")); got != "" {
+		t.Fatalf("expected no conference to match both words, got %s", got)
+	}
+	if db.SearchConferences(" -- ") != nil {
+		t.Fatal("expected a query without words to match nothing")
+	}
+
+	description, tags := "Talks and a hack night", []string{"Go", "Hack"}
+	if _, err := db.UpdateConference(conf.ID, ConferenceUpdate{Description: &description, Tags: &tags}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := ids(db.SearchConferences("hack")); got != conf.ID {
+		t.Fatalf("expected the updated tags to be searched, got %s", got)
+	}
+	replayed := newTestDB(t)
+	if err := replayed.ReplayAudit(db.GetAuditLog()); err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if got, _ := replayed.GetConference(conf.ID); got.Description != description || strings.Join(got.Tags, ",") != "go,hack" {
+		t.Fatalf("expected replay to restore description and tags, got %q %v", got.Description, got.Tags)
+	}
+}
+
+func TestAuditEntriesSayWhoActedAndHowAvailabilityMoved(t *testing.T) {
+	db := newTestDB(t)
+	db.OfferWindow = time.Minute
+	alice, _ := db.CreateUser("Alice", "alice@example.com")
+	bob, _ := db.CreateUser("Bob", "bob@example.com")
+	conf, _ := db.CreateConference("Tiny", "Berlin", 2, 10, time.Now().AddDate(0, 1, 0), "", ConferenceOptions{})
+
+	res, err := db.CreateReservation(alice.ID, conf.ID, 2, ReservationOptions{RequestID: "req-1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := db.EnqueueWait(bob.ID, conf.ID, 1, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	booking, err := db.ConfirmReservation(res.ID, BookingOptions{RequestID: "req-2", ActorID: "admin-1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := db.CancelBooking(booking.ID); err != nil { // offers bob a seat
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entries, total := db.QueryAuditLog(AuditFilter{ConferenceID: conf.ID}, 0, 0)
+	if total != 6 || len(entries) != 6 {
+		t.Fatalf("expected 6 entries for the conference, got %d: %+v", total, entries)
+	}
+	for i, want := range []struct {
+		op, actor string
+		available *Change
+	}{
+		{OpQueueOffer, ActorSystem, nil}, // held, not sold
+		{OpBookingCancel, alice.ID, &Change{Before: 0, After: 2}},
+		{OpReservationConfirm, "admin-1", &Change{Before: 2, After: 0}},
+		{OpQueueEnqueue, bob.ID, nil},
+		{OpReservationCreate, alice.ID, nil},
+		{OpConferenceCreate, "", nil},
+	} {
+		e := entries[i]
+		if e.Op != want.op || e.Actor != want.actor {
+			t.Errorf("entry %d: expected %s by %q, got %s by %q", i, want.op, want.actor, e.Op, e.Actor)
+		}
+		if (e.Available == nil) != (want.available == nil) || (e.Available != nil && *e.Available != *want.available) {
+			t.Errorf("entry %d (%s): expected available %+v, got %+v", i, e.Op, want.available, e.Available)
+		}
+	}
+
+	if got, _ := db.QueryAuditLog(AuditFilter{Op: "queue.", UserID: bob.ID}, 0, 0); len(got) != 2 {
+		t.Fatalf("expected bob's enqueue and offer, got %+v", got)
+	}
+	if got, _ := db.QueryAuditLog(AuditFilter{Actor: ActorSystem}, 0, 0); len(got) != 1 || got[0].Op != OpQueueOffer {
+		t.Fatalf("expected only the offer by the system, got %+v", got)
+	}
+	if got, _ := db.QueryAuditLog(AuditFilter{RequestID: "req-2"}, 0, 0); len(got) != 1 || got[0].BookingID != booking.ID {
+		t.Fatalf("expected the confirmation for req-2, got %+v", got)
+	}
+	page, total := db.QueryAuditLog(AuditFilter{ConferenceID: conf.ID, AfterSeq: entries[2].Seq}, 1, 0)
+	if total != 2 || len(page) != 1 || page[0].Op != OpQueueOffer {
+		t.Fatalf("expected the newest of the 2 later entries, got %d %+v", total, page)
+	}
+}
+
+func TestInventoryReplaysToTheLiveCountsUnderLoad(t *testing.T) {
+	db := newTestDB(t)
+	conf, _ := db.CreateConference("Busy", "Oslo", 60, 10, time.Now().AddDate(0, 1, 0), "", ConferenceOptions{})
+	gone, _ := db.CreateConference("Gone", "Oslo", 10, 10, time.Now().AddDate(0, 1, 0), "", ConferenceOptions{})
+	var wg sync.WaitGroup
+
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				u, err := db.CreateUser("load", fmt.Sprintf("inv-%d-%d@example.com", w, i))
+				if err != nil {
+					continue
+				}
+				switch i % 4 {
+				case 0, 1:
+					res, err := db.CreateReservation(u.ID, conf.ID, 1+i%2, ReservationOptions{})
+					if err != nil {
+						continue
+					}
+					if i%4 == 0 {
+						db.ConfirmReservation(res.ID, BookingOptions{})
+					} else {
+						db.CancelReservation(res.ID)
+					}
+				case 2:
+					if b, err := db.CreateBooking(u.ID, conf.ID, 1, BookingOptions{}); err == nil && i%8 == 2 {
+						db.CancelBooking(b.ID)
+					}
+				case 3:
+					db.CreateReservation(u.ID, conf.ID, 1, ReservationOptions{}) // left to expire
+				}
+			}
+		}(w)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			total := 60 + 20*(i%2)
+			db.UpdateConference(conf.ID, ConferenceUpdate{TotalTickets: &total}) // may be refused once too much is sold
+		}
+	}()
+	wg.Wait()
+	if err := db.DeleteConference(gone.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	db.SetClock(func() time.Time { return time.Now().Add(time.Hour) })
+	db.cleanupExpiredReservations()
+
+	if problems := db.CheckInventory(); len(problems) != 0 {
+		t.Fatalf("expected the stream to account for every seat, got %v", problems)
+	}
+	report, err := db.Inventory(conf.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sold := 0
+	for _, b := range db.Bookings {
+		if b.ConferenceID == conf.ID && b.Status == "confirmed" {
+			sold += b.TicketsBooked
+		}
+	}
+	if !report.Consistent || report.Derived.Held != 0 || report.Derived.Sold != sold || report.Events[0].Kind != InventoryOpen {
+		t.Fatalf("expected no holds and %d sold, got %+v", sold, report.Derived)
+	}
+
+	replayed := newTestDB(t)
+	if err := replayed.ReplayAudit(db.GetAuditLog()); err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if problems := replayed.CheckInventory(); len(problems) != 0 {
+		t.Fatalf("expected the replayed stream to account for every seat, got %v", problems)
+	}
+	if drift := db.Drift(replayed); len(drift) != 0 {
+		t.Fatalf("unexpected drift: %v", drift)
+	}
+
+	// a change that skips the log is caught
+	db.mutex.Lock()
+	db.Conferences[conf.ID].AvailableTickets--
+	db.mutex.Unlock()
+	if problems := db.CheckInventory(); len(problems) != 1 || !strings.Contains(problems[0], conf.ID) {
+		t.Fatalf("expected the untracked change to be reported, got %v", problems)
+	}
+}
+
+func TestAvailabilityIsReadOffTheLedgerAndTheAuditLogStaysBounded(t *testing.T) {
+	db := newTestDB(t)
+	db.MaxAuditEntries = 8
+	conf, _ := db.CreateConference("Small", "Oslo", 4, 10, time.Now().AddDate(0, 1, 0), "", ConferenceOptions{
+		Tiers: []models.Tier{{Name: "VIP", TotalTickets: 2, Price: 50}},
+	})
+	user, _ := db.CreateUser("Ann", "ann@example.com")
+	for i := 0; i < 10; i++ {
+		b, err := db.CreateBooking(user.ID, conf.ID, 1, BookingOptions{Tier: "vip"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := db.CancelBooking(b.ID); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if _, err := db.CreateBooking(user.ID, conf.ID, 2, BookingOptions{Tier: "VIP"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	log := db.GetAuditLog()
+	if len(log) > db.MaxAuditEntries || log[len(log)-1].Seq != 23 {
+		t.Fatalf("expected at most %d entries ending at 23, got %d ending at %d", db.MaxAuditEntries, len(log), log[len(log)-1].Seq)
+	}
+	if problems := db.CheckInventory(); len(problems) != 0 {
+		t.Fatalf("expected the trimmed entries folded into the ledger, got %v", problems)
+	}
+	report, err := db.Inventory(conf.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !report.Consistent || report.Derived.Sold != 2 || report.Derived.Available != 2 {
+		t.Fatalf("expected 2 of 4 sold, got %+v", report)
+	}
+	if got, _ := db.GetConference(conf.ID); got.AvailableTickets != 2 || got.Tiers[0].AvailableTickets != 0 {
+		t.Fatalf("expected the published counts to follow the ledger, got %+v", got)
+	}
+	if err := newTestDB(t).ReplayAudit(log); !errors.Is(err, ErrAuditTrimmed) {
+		t.Fatalf("expected ErrAuditTrimmed, got %v", err)
+	}
+
+	// the published count is only a copy: overwriting it sells nothing
+	db.mutex.Lock()
+	db.Conferences[conf.ID].Tiers[0].AvailableTickets = 2
+	db.mutex.Unlock()
+	if _, err := db.CreateBooking(user.ID, conf.ID, 1, BookingOptions{Tier: "VIP"}); !errors.Is(err, ErrSoldOut) {
+		t.Fatalf("expected the VIP tier sold out, got %v", err)
+	}
+}
+
+func TestImportRefusesInconsistentSnapshotsAndSyncsToAFile(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	booking, err := db.CreateBooking(user.ID, conf.ID, 2, BookingOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	snap := db.Snapshot()
+
+	target := newTestDB(t)
+	bad := target.Snapshot()
+	bad.Conferences["conf-1"].AvailableTickets++ // more than its bookings leave
+	if err := target.Import(bad); !errors.Is(err, ErrInvalidSnapshot) {
+		t.Fatalf("expected ErrInvalidSnapshot, got %v", err)
+	}
+	if err := target.Import(Snapshot{}); !errors.Is(err, ErrInvalidSnapshot) {
+		t.Fatalf("expected ErrInvalidSnapshot for an empty snapshot, got %v", err)
+	}
+	if target.GetBooking(booking.ID) != nil {
+		t.Fatalf("expected a refused import to change nothing")
+	}
+	if err := target.Import(snap); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if target.GetBooking(booking.ID) == nil || len(target.GetAuditLog()) != 0 || len(target.CheckInventory()) != 0 {
+		t.Fatalf("expected the booking imported with a fresh audit log")
+	}
+
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	file := SnapshotFile(path)
+	if empty, err := file.Empty(context.Background()); err != nil || !empty {
+		t.Fatalf("expected no snapshot yet, got %v %v", empty, err)
+	}
+	stop := db.SyncTo(file, 10*time.Millisecond)
+	defer stop()
+	db.CancelBooking(booking.ID)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		saved, err := file.Load(context.Background())
+		if err == nil && saved.Bookings[booking.ID].Status == "cancelled" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the cancellation to be saved, got %v", err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWaitingRoomAdmitsVisitorsAtItsRate(t *testing.T) {
+	db, alice, conf := makeDBWithUserAndConf(t)
+	bob, _ := db.CreateUser("Bob", "bob@example.com")
+	carol, _ := db.CreateUser("Carol", "carol@example.com")
+	clock := time.Now()
+	db.SetClock(func() time.Time { return clock })
+
+	if _, err := db.JoinWaitingRoom(alice.ID, conf.ID); !errors.Is(err, ErrNoWaitingRoom) {
+		t.Fatalf("expected ErrNoWaitingRoom, got %v", err)
+	}
+	room := &models.WaitingRoom{AdmitPerMinute: 6, AdmissionSeconds: 60} // one every 10s
+	if _, err := db.UpdateConference(conf.ID, ConferenceUpdate{WaitingRoom: room}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	first, _ := db.JoinWaitingRoom(alice.ID, conf.ID)
+	second, _ := db.JoinWaitingRoom(bob.ID, conf.ID)
+	third, _ := db.JoinWaitingRoom(carol.ID, conf.ID)
+	if !first.Admitted || second.Admitted || second.Position != 1 || third.Position != 2 || third.EstimatedWaitSeconds != 20 {
+		t.Fatalf("expected alice in and bob, carol 10s apart, got %+v %+v %+v", first, second, third)
+	}
+	if again, _ := db.JoinWaitingRoom(carol.ID, conf.ID); again.Position != 2 {
+		t.Fatalf("expected joining again to keep the place, got %+v", again)
+	}
+
+	clock = clock.Add(10 * time.Second)
+	if status, _ := db.WaitingRoomStatus(bob.ID, conf.ID); !status.Admitted || status.Waiting != 1 {
+		t.Fatalf("expected bob let in with carol still waiting, got %+v", status)
+	}
+	clock = clock.Add(55 * time.Second) // alice's minute is up
+	if status, _ := db.WaitingRoomStatus(alice.ID, conf.ID); status.Admitted || status.Position != 0 {
+		t.Fatalf("expected alice's admission to lapse, got %+v", status)
+	}
+	if status, _ := db.JoinWaitingRoom(alice.ID, conf.ID); !status.Admitted {
+		t.Fatalf("expected alice let straight back in once the line had cleared, got %+v", status)
+	}
+
+	// the line survives a snapshot and the settings survive a replay
+	restored := newTestDB(t)
+	restored.SetClock(func() time.Time { return clock })
+	restored.Restore(db.Snapshot())
+	if status, _ := restored.WaitingRoomStatus(carol.ID, conf.ID); !status.Admitted {
+		t.Fatalf("expected carol admitted after a restore, got %+v", status)
+	}
+	replayed := newTestDB(t)
+	if err := replayed.ReplayAudit(db.GetAuditLog()); err != nil {
+		t.Fatalf("unexpected replay error: %v", err)
+	}
+	if got := replayed.Conferences[conf.ID].WaitingRoom; got == nil || *got != *room {
+		t.Fatalf("expected the waiting room replayed, got %+v", got)
+	}
+	if _, err := db.UpdateConference(conf.ID, ConferenceUpdate{WaitingRoom: &models.WaitingRoom{}}); err != nil || db.Conferences[conf.ID].WaitingRoom != nil {
+		t.Fatalf("expected admit_per_minute 0 to turn the room off, got %v", err)
+	}
+}
+
+func TestQueueServesHigherPriorityClassesFirst(t *testing.T) {
+	db, user, conf := makeDBWithUserAndConf(t)
+	var ids []string
+	for _, name := range []string{"gen1", "gen2", "member1", "member2", "vip"} {
+		u, _ := db.CreateUser(name, name+"@example.com")
+		ids = append(ids, u.ID)
+	}
+	gen1, gen2, member1, member2, vip := ids[0], ids[1], ids[2], ids[3], ids[4]
+
+	db.EnqueueWait(gen1, conf.ID, 1, "")
+	db.EnqueueWait(gen2, conf.ID, 1, "")
+	positions, err := db.BulkEnqueue(conf.ID, []QueueRequest{
+		{UserID: member1, TicketCount: 1, Priority: 1},
+		{UserID: vip, TicketCount: 1, Priority: 2},
+		{UserID: member2, TicketCount: 1, Priority: 1},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []int{1, 1, 3}; !slices.Equal(positions, want) {
+		t.Fatalf("expected positions %v, got %v", want, positions)
+	}
+	order := func(db *Database) []string {
+		var got []string
+		for _, e := range db.WaitQueues[conf.ID] {
+			got = append(got, e.UserID)
+		}
+		return got
+	}
+	if want := []string{vip, member1, member2, gen1, gen2}; !slices.Equal(order(db), want) {
+		t.Fatalf("expected classes highest first and FIFO within each, got %v", order(db))
+	}
+
+	// queuing again never lowers a class; a higher one moves the entry to the back of it
+	if pos, _ := db.EnqueueWait(member1, conf.ID, 2, ""); pos != 2 {
+		t.Fatalf("expected member1 to keep their place, got %d", pos)
+	}
+	if positions, _ := db.BulkEnqueue(conf.ID, []QueueRequest{{UserID: gen2, TicketCount: 1, Priority: 1}}); positions[0] != 4 {
+		t.Fatalf("expected gen2 raised to the back of the members, got %v", positions)
+	}
+	if st := db.GetQueuePosition(gen2, conf.ID); st.Priority != 1 || st.Position != 4 {
+		t.Fatalf("expected gen2 a member at 4, got %+v", st)
+	}
+	if _, err := db.BulkEnqueue(conf.ID, []QueueRequest{{UserID: gen1, TicketCount: 1, Priority: MaxQueuePriority + 1}}); err == nil {
+		t.Fatalf("expected a priority above %d to be rejected", MaxQueuePriority)
+	}
+
+	// a head told it may claim keeps its turn
+	booking, _ := db.CreateBooking(user.ID, conf.ID, 1, BookingOptions{})
+	setAvailable(db, conf, 0) // sold out
+	db.CancelBooking(booking.ID)
+	if st := db.GetQueuePosition(vip, conf.ID); st.ClaimableUntil == nil {
+		t.Fatalf("expected the head told about the freed seat, got %+v", st)
+	}
+	if positions, _ := db.BulkEnqueue(conf.ID, []QueueRequest{{UserID: gen1, TicketCount: 1, Priority: MaxQueuePriority}}); positions[0] != 2 {
+		t.Fatalf("expected gen1 behind the notified head, got %v", positions)
+	}
+
+	replayed := newTestDB(t)
+	if err := replayed.ReplayAudit(db.GetAuditLog()); err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if got, want := order(replayed), order(db); !slices.Equal(got, want) {
+		t.Fatalf("expected the replayed queue %v, got %v", want, got)
+	}
+}
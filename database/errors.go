@@ -0,0 +1,43 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Error kinds the HTTP layer maps to status codes; match them with errors.Is
+var (
+	ErrNotFound    = errors.New("not found")
+	ErrConflict    = errors.New("conflict")
+	ErrUnavailable = errors.New("storage unavailable") // a shared or durable backend could not be reached
+)
+
+var (
+	ErrUserNotFound       = fmt.Errorf("user %w", ErrNotFound)
+	ErrConferenceNotFound = fmt.Errorf("conference %w", ErrNotFound)
+	ErrBookingNotFound    = fmt.Errorf("booking %w", ErrNotFound)
+)
+
+// kindError keeps its own message while matching one of the error kinds above
+type kindError struct {
+	msg  string
+	kind error
+}
+
+func (e *kindError) Error() string { return e.msg }
+
+func (e *kindError) Unwrap() error { return e.kind }
+
+// conflictf formats an error that matches ErrConflict
+func conflictf(format string, args ...interface{}) error {
+	return &kindError{msg: fmt.Sprintf(format, args...), kind: ErrConflict}
+}
+
+// ErrSoldOut is returned when too few tickets are left for a booking, hold or claim. It is a
+// conflict: trying again later may find seats freed by a cancellation or an expired hold.
+var ErrSoldOut = conflictf("not enough tickets available")
+
+// soldOutf formats an error that matches ErrSoldOut (and so ErrConflict)
+func soldOutf(format string, args ...interface{}) error {
+	return &kindError{msg: fmt.Sprintf(format, args...), kind: ErrSoldOut}
+}
@@ -0,0 +1,170 @@
+package database
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventBuffer is how many undelivered events a subscriber may fall behind by; further
+// events are dropped for that subscriber rather than blocking writers
+const EventBuffer = 64
+
+// Event is a live notification of a state change, published for every audited operation
+// except user creation. Type is the audit operation name, e.g. "reservation.create".
+type Event struct {
+	Type          string    `json:"type"`
+	At            time.Time `json:"at"`
+	UserID        string    `json:"user_id,omitempty"`
+	ConferenceID  string    `json:"conference_id,omitempty"`
+	ReservationID string    `json:"reservation_id,omitempty"`
+	BookingID     string    `json:"booking_id,omitempty"`
+	TicketCount   int       `json:"ticket_count,omitempty"`
+	RequestID     string    `json:"request_id,omitempty"`
+}
+
+// eventHub fans events out to subscribers; it has its own lock so publishing never
+// needs the database lock
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+// Subscribe registers for live events. The returned func unsubscribes and closes the
+// channel; it is safe to call more than once.
+func (db *Database) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, EventBuffer)
+	db.events.mu.Lock()
+	if db.events.subs == nil {
+		db.events.subs = make(map[chan Event]struct{})
+	}
+	db.events.subs[ch] = struct{}{}
+	db.events.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			db.events.mu.Lock()
+			delete(db.events.subs, ch)
+			close(ch)
+			db.events.mu.Unlock()
+		})
+	}
+}
+
+// queueEventLocked turns an audit entry into an event to publish once the write lock is
+// released; caller must hold write lock
+func (db *Database) queueEventLocked(e AuditEntry) {
+	if ev, ok := EventFor(e); ok {
+		db.pendingEvents = append(db.pendingEvents, ev)
+	}
+}
+
+// EventFor is the event published for the change e records; ok is false for user changes,
+// which publish none
+func EventFor(e AuditEntry) (ev Event, ok bool) {
+	if e.Op == OpUserCreate || e.Op == OpUserRole || e.Op == OpUserPriority {
+		return ev, false
+	}
+	return Event{
+		Type:          e.Op,
+		At:            e.At,
+		UserID:        e.UserID,
+		ConferenceID:  e.ConferenceID,
+		ReservationID: e.ReservationID,
+		BookingID:     e.BookingID,
+		TicketCount:   e.TicketCount,
+		RequestID:     e.RequestID,
+	}, true
+}
+
+// tagLocked records requestID and actor (the user who asked; empty when unknown) on every audit
+// entry and event until the write lock is released, so changes a call sets off (queue offers,
+// turnover) are traced back to the request too; caller must hold write lock
+func (db *Database) tagLocked(requestID, actor string) {
+	db.requestID = requestID
+	db.actor = actor
+}
+
+// logLocked logs msg with args, adding the request_id set by tagLocked if any; caller must
+// hold write lock
+func (db *Database) logLocked(level slog.Level, msg string, args ...any) {
+	if db.requestID != "" {
+		args = append(args, "request_id", db.requestID)
+	}
+	slog.Log(context.Background(), level, msg, args...)
+}
+
+// LockTiming is how long one call waited for and then held the write lock
+type LockTiming struct {
+	Method    string // the Database method that took the lock
+	RequestID string // set when the call was tagged with one; see tagLocked
+	Requested time.Time
+	Acquired  time.Time
+	Released  time.Time
+}
+
+// SetLockTracer has trace called, after the lock is released, with the timing of every write
+// that took the lock; nil stops it
+func (db *Database) SetLockTracer(trace func(LockTiming)) {
+	db.lock()
+	db.lockTracer = trace
+	db.unlock()
+}
+
+// lock takes the write lock, timing it when a lock tracer is set; release it with unlock
+func (db *Database) lock() {
+	requested := time.Now()
+	db.mutex.Lock()
+	if db.lockTracer != nil {
+		db.lockTiming = LockTiming{Method: callerName(), Requested: requested, Acquired: time.Now()}
+	}
+}
+
+// callerName names the method that called lock, e.g. "CreateReservation"
+func callerName() string {
+	pc, _, _, ok := runtime.Caller(2)
+	if !ok {
+		return "unknown"
+	}
+	name := runtime.FuncForPC(pc).Name()
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// unlock releases the write lock and then publishes the events queued while it was held,
+// so subscribers never run under the database lock
+func (db *Database) unlock() {
+	events := db.pendingEvents
+	db.pendingEvents = nil
+	timing := db.lockTiming
+	db.lockTiming = LockTiming{}
+	timing.RequestID = db.requestID
+	db.requestID = ""
+	db.actor = ""
+	trace := db.lockTracer
+	if !timing.Acquired.IsZero() {
+		timing.Released = time.Now()
+	}
+	db.mutex.Unlock()
+
+	if trace != nil && !timing.Released.IsZero() {
+		trace(timing)
+	}
+
+	if len(events) == 0 {
+		return
+	}
+	db.events.mu.Lock()
+	defer db.events.mu.Unlock()
+	for _, ev := range events {
+		for ch := range db.events.subs {
+			select {
+			case ch <- ev:
+			default: // slow subscriber; drop rather than stall writers
+			}
+		}
+	}
+}
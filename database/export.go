@@ -0,0 +1,57 @@
+package database
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"booking-system/models"
+)
+
+// BookingExportColumns heads the rows returned by ExportBookings
+var BookingExportColumns = []string{
+	"booking_id", "user_name", "user_email", "conference_name", "tickets", "total_amount", "status", "booked_at",
+}
+
+// ExportBookings returns one row per booking for a conference (every conference when
+// conferenceID is empty), oldest first, laid out as BookingExportColumns. A booking whose user
+// or conference no longer exists gets blank name and email fields instead.
+func (db *Database) ExportBookings(conferenceID string) [][]string {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	var bookings []*models.Booking
+	for _, b := range db.Bookings {
+		if conferenceID == "" || b.ConferenceID == conferenceID {
+			bookings = append(bookings, b)
+		}
+	}
+	sort.Slice(bookings, func(i, j int) bool {
+		if !bookings[i].BookedAt.Equal(bookings[j].BookedAt) {
+			return bookings[i].BookedAt.Before(bookings[j].BookedAt)
+		}
+		return bookings[i].ID < bookings[j].ID
+	})
+
+	rows := make([][]string, 0, len(bookings))
+	for _, b := range bookings {
+		var userName, userEmail, conferenceName string
+		if u, ok := db.Users[b.UserID]; ok {
+			userName, userEmail = u.Name, u.Email
+		}
+		if conf, ok := db.Conferences[b.ConferenceID]; ok {
+			conferenceName = conf.Name
+		}
+		rows = append(rows, []string{
+			b.ID,
+			userName,
+			userEmail,
+			conferenceName,
+			strconv.Itoa(b.TicketsBooked),
+			strconv.FormatFloat(b.TotalAmount, 'f', 2, 64),
+			b.Status,
+			b.BookedAt.Format(time.RFC3339),
+		})
+	}
+	return rows
+}
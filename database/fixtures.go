@@ -0,0 +1,265 @@
+package database
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"booking-system/models"
+
+	"github.com/goccy/go-yaml"
+)
+
+// Fixtures is a demo data set: conferences, and optionally users and their bookings. See
+// LoadFixtures for the files it is read from; sample.json is the built-in set.
+type Fixtures struct {
+	Conferences []*models.Conference
+	Users       []*models.User
+	Bookings    []*models.Booking
+	startsIn    map[string]int // conference ID -> days from the load until it starts
+}
+
+//go:embed sample.json
+var sampleJSON []byte
+
+// sampleFixtures is the built-in data set the database starts from without fixtures
+var sampleFixtures = sync.OnceValues(func() (*Fixtures, error) {
+	return parseFixtures("sample.json", sampleJSON)
+})
+
+// fixtureSet is a data set as a fixture file spells it, before it is checked
+type fixtureSet struct {
+	Conferences []conferenceFixture `json:"conferences"`
+	Users       []*models.User      `json:"users"`
+	Bookings    []*models.Booking   `json:"bookings"`
+}
+
+// conferenceFixture lets a fixture omit available_tickets so it can be derived from bookings,
+// and give its date as days from when it is loaded so a demo data set never goes stale
+type conferenceFixture struct {
+	models.Conference
+	AvailableTickets *int `json:"available_tickets"`
+	StartsInDays     *int `json:"starts_in_days"`
+}
+
+// LoadFixtures reads and validates a fixture set from path: either a directory of JSON files
+// (conferences.json, required; users.json and bookings.json, optional) or a single JSON or
+// YAML (.yaml, .yml) file with conferences, users and bookings lists
+func LoadFixtures(path string) (*Fixtures, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("fixtures: %w", err)
+	}
+	if !info.IsDir() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("fixtures: %w", err)
+		}
+		return parseFixtures(filepath.Base(path), data)
+	}
+
+	var set fixtureSet
+	if err := readFixtureFile(path, "conferences.json", &set.Conferences, true); err != nil {
+		return nil, err
+	}
+	if err := readFixtureFile(path, "users.json", &set.Users, false); err != nil {
+		return nil, err
+	}
+	if err := readFixtureFile(path, "bookings.json", &set.Bookings, false); err != nil {
+		return nil, err
+	}
+	return buildFixtures(set, func(part string) string { return part + ".json" })
+}
+
+// parseFixtures decodes and validates a single-file fixture set; name's extension says
+// whether it is YAML
+func parseFixtures(name string, data []byte) (*Fixtures, error) {
+	if ext := strings.ToLower(filepath.Ext(name)); ext == ".yaml" || ext == ".yml" {
+		converted, err := yaml.YAMLToJSON(data)
+		if err != nil {
+			return nil, fmt.Errorf("fixtures: %s: %w", name, err)
+		}
+		data = converted
+	}
+	var set fixtureSet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("fixtures: %s: %w", name, err)
+	}
+	if len(set.Conferences) == 0 {
+		return nil, fmt.Errorf("fixtures: %s: no conferences", name)
+	}
+	return buildFixtures(set, func(part string) string { return name + ": " + part })
+}
+
+// buildFixtures checks a decoded set and derives what it leaves out: available tickets from
+// the bookings, booking amounts from the price. label names a part of the set (conferences,
+// users, bookings) in errors.
+func buildFixtures(set fixtureSet, label func(part string) string) (*Fixtures, error) {
+	confs := set.Conferences
+	fx := &Fixtures{Users: set.Users, Bookings: set.Bookings, startsIn: make(map[string]int)}
+
+	confByID := make(map[string]*models.Conference)
+	declared := make(map[string]*int)
+	for i := range confs {
+		conf := confs[i].Conference
+		switch {
+		case conf.ID == "":
+			return nil, fmt.Errorf("%s: entry %d has no id", label("conferences"), i)
+		case confByID[conf.ID] != nil:
+			return nil, fmt.Errorf("%s: duplicate conference id %s", label("conferences"), conf.ID)
+		case conf.TotalTickets <= 0:
+			return nil, fmt.Errorf("%s: conference %s must have total_tickets > 0", label("conferences"), conf.ID)
+		case conf.Price < 0:
+			return nil, fmt.Errorf("%s: conference %s has a negative price", label("conferences"), conf.ID)
+		case confs[i].StartsInDays != nil && !conf.Date.IsZero():
+			return nil, fmt.Errorf("%s: conference %s sets both date and starts_in_days", label("conferences"), conf.ID)
+		}
+		tierTotal := 0
+		for j := range conf.Tiers {
+			tier := &conf.Tiers[j]
+			if tier.Name == "" || tier.TotalTickets <= 0 || tier.Price < 0 {
+				return nil, fmt.Errorf("%s: conference %s has a tier without a name, tickets or a valid price", label("conferences"), conf.ID)
+			}
+			tier.AvailableTickets = tier.TotalTickets // bookings below draw it down
+			tierTotal += tier.TotalTickets
+		}
+		if tierTotal > conf.TotalTickets {
+			return nil, fmt.Errorf("%s: conference %s tiers hold %d tickets of %d", label("conferences"), conf.ID, tierTotal, conf.TotalTickets)
+		}
+		if days := confs[i].StartsInDays; days != nil {
+			fx.startsIn[conf.ID] = *days
+		}
+		confByID[conf.ID] = &conf
+		declared[conf.ID] = confs[i].AvailableTickets
+		fx.Conferences = append(fx.Conferences, &conf)
+	}
+
+	userByID := make(map[string]bool)
+	emails := make(map[string]bool)
+	for i, u := range fx.Users {
+		email := strings.ToLower(strings.TrimSpace(u.Email))
+		switch {
+		case u.ID == "":
+			return nil, fmt.Errorf("%s: entry %d has no id", label("users"), i)
+		case userByID[u.ID]:
+			return nil, fmt.Errorf("%s: duplicate user id %s", label("users"), u.ID)
+		case email == "":
+			return nil, fmt.Errorf("%s: user %s has no email", label("users"), u.ID)
+		case emails[email]:
+			return nil, fmt.Errorf("%s: duplicate email %s", label("users"), email)
+		}
+		u.Email = email
+		if u.Created.IsZero() {
+			u.Created = time.Now()
+		}
+		userByID[u.ID] = true
+		emails[email] = true
+	}
+
+	booked := make(map[string]int)
+	bookingIDs := make(map[string]bool)
+	for i, b := range fx.Bookings {
+		conf := confByID[b.ConferenceID]
+		switch {
+		case b.ID == "":
+			return nil, fmt.Errorf("%s: entry %d has no id", label("bookings"), i)
+		case bookingIDs[b.ID]:
+			return nil, fmt.Errorf("%s: duplicate booking id %s", label("bookings"), b.ID)
+		case !userByID[b.UserID]:
+			return nil, fmt.Errorf("%s: booking %s references unknown user %s", label("bookings"), b.ID, b.UserID)
+		case conf == nil:
+			return nil, fmt.Errorf("%s: booking %s references unknown conference %s", label("bookings"), b.ID, b.ConferenceID)
+		case b.TicketsBooked <= 0:
+			return nil, fmt.Errorf("%s: booking %s must book at least one ticket", label("bookings"), b.ID)
+		case b.Tier != "" && findTier(conf, b.Tier) == nil:
+			return nil, fmt.Errorf("%s: booking %s references unknown tier %s", label("bookings"), b.ID, b.Tier)
+		}
+		if b.Status == "" {
+			b.Status = "confirmed"
+		}
+		if b.TotalAmount == 0 {
+			b.TotalAmount = conf.Price * float64(b.TicketsBooked)
+		}
+		if b.BookedAt.IsZero() {
+			b.BookedAt = time.Now()
+		}
+		if len(b.Attendees) != 0 && len(b.Attendees) != b.TicketsBooked {
+			return nil, fmt.Errorf("%s: booking %s lists %d attendees for %d tickets", label("bookings"), b.ID, len(b.Attendees), b.TicketsBooked)
+		}
+		b.Attendees = attendeesOrEmpty(b.Attendees)
+		if b.Status == "confirmed" {
+			booked[b.ConferenceID] += b.TicketsBooked
+			if tier := findTier(conf, b.Tier); tier != nil {
+				tier.AvailableTickets -= b.TicketsBooked
+			}
+		}
+		bookingIDs[b.ID] = true
+	}
+
+	for _, conf := range fx.Conferences {
+		remaining := conf.TotalTickets - booked[conf.ID]
+		if remaining < 0 {
+			return nil, fmt.Errorf("conference %s is overbooked: %d tickets booked of %d", conf.ID, booked[conf.ID], conf.TotalTickets)
+		}
+		for _, tier := range conf.Tiers {
+			if tier.AvailableTickets < 0 {
+				return nil, fmt.Errorf("conference %s tier %s is overbooked", conf.ID, tier.Name)
+			}
+		}
+		if d := declared[conf.ID]; d != nil && *d != remaining {
+			return nil, fmt.Errorf("conference %s declares %d available tickets but bookings leave %d", conf.ID, *d, remaining)
+		}
+		conf.AvailableTickets = remaining
+	}
+	return fx, nil
+}
+
+// readFixtureFile decodes one JSON fixture file; optional files may be absent
+func readFixtureFile(dir, name string, v interface{}, required bool) error {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		if !required && errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("fixtures: %w", err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("fixtures: %s: %w", name, err)
+	}
+	return nil
+}
+
+// UseFixtures replaces the current data with the fixture set; ResetDatabase restores it afterwards
+func (db *Database) UseFixtures(fx *Fixtures) {
+	db.lock()
+	db.fixtures = fx
+	db.unlock()
+	db.ResetDatabase()
+}
+
+// addFixtureData copies a fixture set into the maps, dating conferences given starts_in_days
+// from now; caller must hold write lock
+func (db *Database) addFixtureData(fx *Fixtures) {
+	for _, c := range fx.Conferences {
+		conf := snapshotConference(c)
+		if days, ok := fx.startsIn[conf.ID]; ok {
+			conf.Date = db.now().AddDate(0, 0, days)
+		}
+		db.Conferences[conf.ID] = conf
+	}
+	for _, u := range fx.Users {
+		cp := *u
+		db.Users[cp.ID] = &cp
+	}
+	for _, b := range fx.Bookings {
+		cp := *b
+		cp.Attendees = append([]string{}, b.Attendees...)
+		db.Bookings[cp.ID] = &cp
+	}
+}
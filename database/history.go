@@ -0,0 +1,70 @@
+package database
+
+import (
+	"sort"
+
+	"booking-system/models"
+)
+
+// ReservationHistoryLimit is how many finished reservations are kept per user; older ones
+// are forgotten so the history cannot grow without bound
+const ReservationHistoryLimit = 50
+
+// retireReservationLocked takes a reservation out of the active set, marks it with its final
+// status and keeps it in the owner's history; caller must hold write lock
+func (db *Database) retireReservationLocked(reservation *models.SeatReservation, status string) {
+	delete(db.Reservations, reservation.ID)
+	reservation.Status = status
+	db.keepInHistoryLocked(reservation)
+}
+
+// keepInHistoryLocked adds a finished reservation to its owner's history, replacing an earlier
+// copy of it; caller must hold write lock
+func (db *Database) keepInHistoryLocked(reservation *models.SeatReservation) {
+	h := db.history[reservation.UserID]
+	for i, r := range h {
+		if r.ID == reservation.ID {
+			h = append(h[:i:i], h[i+1:]...)
+			break
+		}
+	}
+	h = append(h, reservation)
+	if len(h) > ReservationHistoryLimit {
+		h = append(h[:0:0], h[len(h)-ReservationHistoryLimit:]...)
+	}
+	db.history[reservation.UserID] = h
+}
+
+// retiredLocked returns a copy of reservation id from userID's history, nil if it isn't
+// there; caller must hold the lock
+func (db *Database) retiredLocked(userID, id string) *models.SeatReservation {
+	h := db.history[userID]
+	for i := len(h) - 1; i >= 0; i-- {
+		if h[i].ID == id {
+			return snapshotReservation(h[i])
+		}
+	}
+	return nil
+}
+
+// GetUserReservationHistory returns a user's active reservations together with the last
+// ReservationHistoryLimit finished ones (expired, confirmed or cancelled), newest first
+func (db *Database) GetUserReservationHistory(userID string) []*models.SeatReservation {
+	// Expire lapsed holds first so they show up as expired rather than active
+	db.cleanupExpiredReservations()
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	reservations := make([]*models.SeatReservation, 0, len(db.history[userID]))
+	for _, reservation := range db.Reservations {
+		if reservation.UserID == userID {
+			reservations = append(reservations, snapshotReservation(reservation))
+		}
+	}
+	reservations = append(reservations, snapshotReservations(db.history[userID])...)
+	sort.SliceStable(reservations, func(i, j int) bool {
+		return reservations[i].CreatedAt.After(reservations[j].CreatedAt)
+	})
+	return reservations
+}
@@ -0,0 +1,174 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"booking-system/models"
+)
+
+// IdempotencyKeyTTL is how long an Idempotency-Key is remembered
+const IdempotencyKeyTTL = 24 * time.Hour
+
+// ErrIdempotencyMismatch is returned when a key is reused for a different request
+var ErrIdempotencyMismatch = conflictf("idempotency key was already used for a different request")
+
+// idempotentRequest remembers what a key produced and a fingerprint of what was asked for
+type idempotentRequest struct {
+	resourceID  string
+	fingerprint string
+	createdAt   time.Time
+}
+
+// idempotencyScope namespaces a key by user so different users can't collide
+func idempotencyScope(userID, key string) string {
+	return userID + "\x00" + key
+}
+
+// requestFingerprint sums up a create request so a reused key can be told apart from a retry.
+// The kind keeps a booking and a reservation from sharing a key.
+func requestFingerprint(kind, conferenceID string, ticketCount int, tier, promoCode string, seats []string) string {
+	return fmt.Sprintf("%s\x00%s\x00%d\x00%s\x00%s\x00%s", kind, conferenceID, ticketCount,
+		strings.ToLower(strings.TrimSpace(tier)), normalizePromoCode(promoCode), strings.Join(seats, ","))
+}
+
+// previousRequestLocked returns the live record for scope, or ErrIdempotencyMismatch if the key
+// was used for a request with another fingerprint; caller must hold write lock
+func (db *Database) previousRequestLocked(scope, fingerprint string) (idempotentRequest, bool, error) {
+	prev, ok := db.idempotency[scope]
+	if !ok || db.now().Sub(prev.createdAt) >= IdempotencyKeyTTL {
+		return idempotentRequest{}, false, nil
+	}
+	if prev.fingerprint != fingerprint {
+		return idempotentRequest{}, false, ErrIdempotencyMismatch
+	}
+	return prev, true, nil
+}
+
+// CreateBookingIdempotent behaves like CreateBooking, but a repeated call with the same user
+// and key within IdempotencyKeyTTL returns the original booking with replayed set instead of
+// booking again. Failed attempts are not remembered, so a client may retry them with the same key.
+// An empty key disables the check.
+func (db *Database) CreateBookingIdempotent(key, userID, conferenceID string, ticketCount int, opts BookingOptions) (booking *models.Booking, replayed bool, err error) {
+	if key == "" {
+		booking, err = db.CreateBooking(userID, conferenceID, ticketCount, opts)
+		return booking, false, err
+	}
+	opts.Attendees, err = normalizeAttendees(opts.Attendees, ticketCount)
+	if err != nil {
+		return nil, false, err
+	}
+
+	db.lock()
+	defer db.unlock()
+	db.tagLocked(opts.RequestID, opts.ActorID)
+
+	scope := idempotencyScope(userID, key)
+	fingerprint := requestFingerprint("booking", conferenceID, ticketCount, opts.Tier, "", nil)
+	prev, ok, err := db.previousRequestLocked(scope, fingerprint)
+	if err != nil {
+		return nil, false, err
+	}
+	if ok {
+		if original, ok := db.Bookings[prev.resourceID]; ok {
+			return original, true, nil
+		}
+	}
+
+	booking, err = db.createBookingLocked(userID, conferenceID, ticketCount, opts)
+	if err != nil {
+		return nil, false, err
+	}
+	db.idempotency[scope] = idempotentRequest{resourceID: booking.ID, fingerprint: fingerprint, createdAt: booking.BookedAt}
+	return booking, false, nil
+}
+
+// CreateReservationIdempotent behaves like CreateReservation, but a repeated call with the
+// same user and key within IdempotencyKeyTTL returns the original hold with replayed set
+// instead of holding seats again. The hold is replayed while it is active and after it was
+// confirmed; once it has expired or been cancelled the key makes a fresh hold, as nothing is
+// held for it any more. An empty key disables the check.
+func (db *Database) CreateReservationIdempotent(key, userID, conferenceID string, ticketCount int, opts ReservationOptions) (res *models.SeatReservation, replayed bool, err error) {
+	if key == "" {
+		res, err = db.CreateReservation(userID, conferenceID, ticketCount, opts)
+		return res, false, err
+	}
+
+	db.lock()
+	defer db.unlock()
+	db.cleanupExpiredReservationsLocked()
+	db.tagLocked(opts.RequestID, opts.ActorID)
+
+	scope := idempotencyScope(userID, key)
+	fingerprint := requestFingerprint("reservation", conferenceID, ticketCount, opts.Tier, opts.PromoCode, opts.SeatIDs)
+	prev, ok, err := db.previousRequestLocked(scope, fingerprint)
+	if err != nil {
+		return nil, false, err
+	}
+	if ok {
+		if original := db.replayableReservationLocked(userID, prev.resourceID); original != nil {
+			return original, true, nil
+		}
+	}
+
+	res, err = db.createReservationLocked(userID, conferenceID, ticketCount, opts)
+	if err != nil {
+		return nil, false, err
+	}
+	db.idempotency[scope] = idempotentRequest{resourceID: res.ID, fingerprint: fingerprint, createdAt: res.CreatedAt}
+	return res, false, nil
+}
+
+// replayableReservationLocked finds reservation id if it is still held or was confirmed;
+// caller must hold write lock
+func (db *Database) replayableReservationLocked(userID, id string) *models.SeatReservation {
+	if res, ok := db.Reservations[id]; ok {
+		return res
+	}
+	for _, res := range db.history[userID] {
+		if res.ID == id && res.Status == models.ReservationStatusConfirmed {
+			return res
+		}
+	}
+	return nil
+}
+
+// IdempotencyRecord is what an Idempotency-Key produced, for instances sharing their state to
+// hand each other (see database/redisstore)
+type IdempotencyRecord struct {
+	ResourceID  string    `json:"resource_id"` // the booking or reservation made
+	Fingerprint string    `json:"fingerprint"` // what was asked for; see requestFingerprint
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// IdempotencyRecord returns what userID's key produced, if it is still remembered
+func (db *Database) IdempotencyRecord(userID, key string) (IdempotencyRecord, bool) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	prev, ok := db.idempotency[idempotencyScope(userID, key)]
+	if !ok || db.now().Sub(prev.createdAt) >= IdempotencyKeyTTL {
+		return IdempotencyRecord{}, false
+	}
+	return IdempotencyRecord{ResourceID: prev.resourceID, Fingerprint: prev.fingerprint, CreatedAt: prev.createdAt}, true
+}
+
+// RememberIdempotency records what userID's key produced on another instance, so a retry
+// sent here is answered the same way
+func (db *Database) RememberIdempotency(userID, key string, rec IdempotencyRecord) {
+	db.lock()
+	defer db.unlock()
+	db.idempotency[idempotencyScope(userID, key)] = idempotentRequest{
+		resourceID: rec.ResourceID, fingerprint: rec.Fingerprint, createdAt: rec.CreatedAt,
+	}
+}
+
+// pruneIdempotencyKeysLocked forgets keys older than IdempotencyKeyTTL; caller must hold write lock
+func (db *Database) pruneIdempotencyKeysLocked(now time.Time) {
+	for scope, prev := range db.idempotency {
+		if now.Sub(prev.createdAt) >= IdempotencyKeyTTL {
+			delete(db.idempotency, scope)
+		}
+	}
+}
@@ -0,0 +1,372 @@
+package database
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"booking-system/models"
+)
+
+// Inventory event kinds: how an audited change moved a conference's seats. The audit log is
+// the append-only stream they are read from; ledgerEvent maps its operations onto them.
+const (
+	InventoryOpen    = "open"    // a conference created; Tickets is its capacity
+	InventoryResize  = "resize"  // capacity changed; Tickets is the new capacity
+	InventoryReserve = "reserve" // seats put on hold
+	InventoryRelease = "release" // a hold let go: cancelled or expired
+	InventoryConfirm = "confirm" // a hold turned into a booking
+	InventorySell    = "sell"    // seats booked outright, without a hold
+	InventoryRefund  = "refund"  // a cancelled booking's seats back on sale
+	InventoryClose   = "close"   // a conference deleted
+)
+
+// InventoryEvent is one change to a conference's seats
+type InventoryEvent struct {
+	Seq           int       `json:"seq"` // the audit entry it was read from
+	At            time.Time `json:"at"`
+	Kind          string    `json:"kind"`
+	ConferenceID  string    `json:"conference_id"`
+	ReservationID string    `json:"reservation_id,omitempty"`
+	BookingID     string    `json:"booking_id,omitempty"`
+	Tier          string    `json:"tier,omitempty"`
+	Tickets       int       `json:"tickets"`
+}
+
+// Inventory is what a conference's seats add up to. Held seats are still available: a hold
+// only takes them off sale when it is confirmed.
+type Inventory struct {
+	ConferenceID string `json:"conference_id"`
+	Capacity     int    `json:"capacity"`
+	Sold         int    `json:"sold"`
+	Held         int    `json:"held"`
+	Available    int    `json:"available"` // Capacity - Sold
+}
+
+// apply folds one event into the counts
+func (inv *Inventory) apply(ev InventoryEvent) {
+	switch ev.Kind {
+	case InventoryOpen, InventoryResize:
+		inv.Available += ev.Tickets - inv.Capacity
+		inv.Capacity = ev.Tickets
+	case InventoryReserve:
+		inv.Held += ev.Tickets
+	case InventoryRelease:
+		inv.Held -= ev.Tickets
+	case InventoryConfirm:
+		inv.Held -= ev.Tickets
+		inv.Sold += ev.Tickets
+		inv.Available -= ev.Tickets
+	case InventorySell:
+		inv.Sold += ev.Tickets
+		inv.Available -= ev.Tickets
+	case InventoryRefund:
+		inv.Sold -= ev.Tickets
+		inv.Available += ev.Tickets
+	}
+}
+
+// InventoryReport sets a conference's inventory as replaying the stream derives it against
+// the published counts on the conference; any difference is a change that bypassed the log
+type InventoryReport struct {
+	Derived    Inventory        `json:"derived"`
+	Live       Inventory        `json:"live"`
+	Consistent bool             `json:"consistent"`
+	Events     []InventoryEvent `json:"events"` // in the audit log still kept, oldest first
+}
+
+// inventoryHold is the seats an open hold has; cancellations don't repeat them
+type inventoryHold struct {
+	conferenceID string
+	tier         string
+	tickets      int
+}
+
+// ledgerState is the inventory stream folded up to some entry: every conference's counts, the
+// seats sold in each of its tiers and the holds still open
+type ledgerState struct {
+	conferences map[string]Inventory
+	tierSold    map[string]map[string]int // conference ID -> lower-cased tier name -> seats sold
+	holds       map[string]inventoryHold
+}
+
+func (s ledgerState) clone() ledgerState {
+	c := ledgerState{
+		conferences: maps.Clone(s.conferences),
+		tierSold:    make(map[string]map[string]int, len(s.tierSold)),
+		holds:       maps.Clone(s.holds),
+	}
+	for id, sold := range s.tierSold {
+		c.tierSold[id] = maps.Clone(sold)
+	}
+	return c
+}
+
+// fold takes one audit entry into the counts and returns the event read from it with the
+// conference's counts before and after; ok is false when it moved no seats of a conference
+// still open
+func (s *ledgerState) fold(e *AuditEntry) (ev InventoryEvent, before, after Inventory, ok bool) {
+	ev, ok = ledgerEvent(e, s.holds)
+	if !ok {
+		return ev, before, after, false
+	}
+	inv, known := s.conferences[ev.ConferenceID]
+	switch {
+	case ev.Kind == InventoryOpen:
+		inv = Inventory{ConferenceID: ev.ConferenceID}
+		s.tierSold[ev.ConferenceID] = make(map[string]int)
+	case ev.Kind == InventoryClose:
+		delete(s.conferences, ev.ConferenceID)
+		delete(s.tierSold, ev.ConferenceID)
+		return ev, inv, Inventory{}, false
+	case !known:
+		return ev, before, after, false // a booking of a deleted conference
+	}
+	before, after = inv, inv
+	after.apply(ev)
+	s.conferences[ev.ConferenceID] = after
+	if ev.Tier != "" {
+		switch ev.Kind {
+		case InventoryConfirm, InventorySell:
+			s.tierSold[ev.ConferenceID][strings.ToLower(ev.Tier)] += ev.Tickets
+		case InventoryRefund:
+			s.tierSold[ev.ConferenceID][strings.ToLower(ev.Tier)] -= ev.Tickets
+		}
+	}
+	return ev, before, after, true
+}
+
+// inventoryLedger is the inventory stream every availability check reads from. It opens on
+// the state last loaded (sample data, fixtures or a snapshot), takes each audit entry as it is
+// recorded, and absorbs the entries trimmed off the front of the audit log.
+type inventoryLedger struct {
+	start int         // Seq of the last entry folded into base
+	base  ledgerState // the counts as of start, which Inventory and CheckInventory replay from
+	head  ledgerState // the counts after the newest entry
+}
+
+// openLedgerLocked starts the inventory stream afresh from the current state; caller must
+// hold write lock. Called wherever the state is replaced rather than changed.
+func (db *Database) openLedgerLocked() {
+	base := ledgerState{
+		conferences: make(map[string]Inventory, len(db.Conferences)),
+		tierSold:    make(map[string]map[string]int, len(db.Conferences)),
+		holds:       make(map[string]inventoryHold, len(db.Reservations)),
+	}
+	for id, conf := range db.Conferences {
+		base.conferences[id] = db.liveInventoryLocked(id)
+		sold := make(map[string]int, len(conf.Tiers))
+		for _, tier := range conf.Tiers {
+			sold[strings.ToLower(tier.Name)] = tier.TotalTickets - tier.AvailableTickets
+		}
+		base.tierSold[id] = sold
+	}
+	for id, r := range db.Reservations {
+		if _, ok := base.conferences[r.ConferenceID]; ok {
+			base.holds[id] = inventoryHold{conferenceID: r.ConferenceID, tier: r.Tier, tickets: r.TicketCount}
+		}
+	}
+	db.ledger = inventoryLedger{start: db.lastSeqLocked(), base: base, head: base.clone()}
+}
+
+// foldLocked takes a recorded or replayed entry into the inventory stream and publishes the
+// conference's new counts on it, bumping its version when its availability moved. An entry
+// that doesn't say how it moved the availability gets the step the stream took. Caller must
+// hold write lock.
+func (db *Database) foldLocked(e *AuditEntry) {
+	ev, before, after, ok := db.ledger.head.fold(e)
+	if !ok {
+		return
+	}
+	if ev.Kind != InventoryOpen && before.Available != after.Available {
+		if e.Available == nil {
+			e.Available = &Change{Before: before.Available, After: after.Available}
+		}
+		if conf, ok := db.Conferences[ev.ConferenceID]; ok {
+			conf.Version++
+		}
+	}
+	db.publishInventoryLocked(ev.ConferenceID)
+}
+
+// publishInventoryLocked copies a conference's available counts off the inventory stream onto
+// the conference and its tiers, where they are served from; caller must hold write lock
+func (db *Database) publishInventoryLocked(conferenceID string) {
+	conf, ok := db.Conferences[conferenceID]
+	if !ok {
+		return
+	}
+	conf.AvailableTickets = db.availableLocked(conf)
+	for i := range conf.Tiers {
+		conf.Tiers[i].AvailableTickets = db.tierAvailableLocked(conf, &conf.Tiers[i])
+	}
+}
+
+// availableLocked is how many of a conference's seats are unsold, read off the inventory
+// stream; caller must hold the lock
+func (db *Database) availableLocked(conf *models.Conference) int {
+	return db.ledger.head.conferences[conf.ID].Available
+}
+
+// tierAvailableLocked is how many of a tier's seats are unsold, read off the inventory stream;
+// caller must hold the lock
+func (db *Database) tierAvailableLocked(conf *models.Conference, tier *models.Tier) int {
+	return tier.TotalTickets - db.ledger.head.tierSold[conf.ID][strings.ToLower(tier.Name)]
+}
+
+// trimAuditLocked keeps the audit log within MaxAuditEntries by dropping its oldest quarter
+// once it is full, folding the dropped entries into where the inventory stream starts; caller
+// must hold write lock
+func (db *Database) trimAuditLocked() {
+	if db.MaxAuditEntries <= 0 || len(db.Audit) <= db.MaxAuditEntries {
+		return
+	}
+	drop := len(db.Audit) - db.MaxAuditEntries + db.MaxAuditEntries/4
+	for i := range db.Audit[:drop] {
+		if e := &db.Audit[i]; e.Seq > db.ledger.start {
+			db.ledger.base.fold(e)
+			db.ledger.start = e.Seq
+		}
+	}
+	db.Audit = slices.Clone(db.Audit[drop:])
+}
+
+// liveInventoryLocked reads a conference's inventory off its published counts and the holds on
+// it; caller must hold the lock
+func (db *Database) liveInventoryLocked(conferenceID string) Inventory {
+	conf := db.Conferences[conferenceID]
+	inv := Inventory{ConferenceID: conferenceID, Capacity: conf.TotalTickets, Available: conf.AvailableTickets}
+	inv.Sold = inv.Capacity - inv.Available
+	for _, r := range db.Reservations {
+		if r.ConferenceID == conferenceID {
+			inv.Held += r.TicketCount
+		}
+	}
+	return inv
+}
+
+// ledgerEvent reads the inventory event out of an audit entry, if it moved any seats. holds
+// tracks the open holds so releases and confirmations know what they let go.
+func ledgerEvent(e *AuditEntry, holds map[string]inventoryHold) (InventoryEvent, bool) {
+	ev := InventoryEvent{Seq: e.Seq, At: e.At, ConferenceID: e.ConferenceID, ReservationID: e.ReservationID, BookingID: e.BookingID, Tier: e.Tier, Tickets: e.TicketCount}
+	switch e.Op {
+	case OpConferenceCreate:
+		ev.Kind = InventoryOpen
+	case OpConferenceUpdate:
+		ev.Kind = InventoryResize
+	case OpConferenceDelete:
+		ev.Kind = InventoryClose
+	case OpReservationCreate, OpQueueClaim, OpQueueOffer:
+		ev.Kind = InventoryReserve
+		holds[e.ReservationID] = inventoryHold{conferenceID: e.ConferenceID, tier: e.Tier, tickets: e.TicketCount}
+	case OpReservationCancel, OpReservationExpire, OpReservationConfirm:
+		hold, ok := holds[e.ReservationID]
+		if !ok {
+			return ev, false
+		}
+		delete(holds, e.ReservationID)
+		ev.Kind, ev.ConferenceID, ev.Tier, ev.Tickets = InventoryRelease, hold.conferenceID, hold.tier, hold.tickets
+		if e.Op == OpReservationConfirm {
+			ev.Kind = InventoryConfirm
+		}
+	case OpBookingCreate:
+		ev.Kind = InventorySell
+	case OpBookingCancel:
+		ev.Kind = InventoryRefund
+	default:
+		return ev, false
+	}
+	return ev, true
+}
+
+// replayInventoryLocked folds the audit entries kept since the ledger's start into every
+// conference's inventory, calling visit (if not nil) with each entry, the event read from it
+// and the conference's counts before and after; caller must hold the lock
+func (db *Database) replayInventoryLocked(visit func(e *AuditEntry, ev InventoryEvent, before, after Inventory)) map[string]Inventory {
+	state := db.ledger.base.clone()
+	for i := range db.Audit {
+		e := &db.Audit[i]
+		if e.Seq <= db.ledger.start {
+			continue
+		}
+		ev, before, after, ok := state.fold(e)
+		if ok && visit != nil {
+			visit(e, ev, before, after)
+		}
+	}
+	return state.conferences
+}
+
+// Inventory replays the inventory stream of one conference and compares what it derives with
+// the counts published on the conference
+func (db *Database) Inventory(conferenceID string) (*InventoryReport, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	if _, ok := db.Conferences[conferenceID]; !ok {
+		return nil, ErrConferenceNotFound
+	}
+	report := &InventoryReport{Events: make([]InventoryEvent, 0)}
+	inventories := db.replayInventoryLocked(func(_ *AuditEntry, ev InventoryEvent, _, _ Inventory) {
+		if ev.ConferenceID == conferenceID {
+			report.Events = append(report.Events, ev)
+		}
+	})
+	if derived, ok := inventories[conferenceID]; ok {
+		report.Derived = derived
+	}
+	report.Live = db.liveInventoryLocked(conferenceID)
+	report.Consistent = report.Derived == report.Live
+	return report, nil
+}
+
+// CheckInventory replays the inventory stream and describes every way the live state breaks
+// it: a conference whose published counts or ledger differ from what the stream adds up to,
+// an audit entry whose recorded before/after availability isn't what the stream moved, more
+// seats sold than exist or more released than were held. Empty means every seat is accounted for.
+func (db *Database) CheckInventory() []string {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	var problems []string
+	inventories := db.replayInventoryLocked(func(e *AuditEntry, ev InventoryEvent, before, after Inventory) {
+		moved := before.Available != after.Available && ev.Kind != InventoryOpen
+		switch {
+		case e.Available == nil && moved:
+			problems = append(problems, fmt.Sprintf("entry %d (%s) moved %s's availability %d -> %d without recording it",
+				e.Seq, e.Op, ev.ConferenceID, before.Available, after.Available))
+		case e.Available != nil && (e.Available.Before != before.Available || e.Available.After != after.Available):
+			problems = append(problems, fmt.Sprintf("entry %d (%s) recorded %s's availability %d -> %d, the stream has %d -> %d",
+				e.Seq, e.Op, ev.ConferenceID, e.Available.Before, e.Available.After, before.Available, after.Available))
+		}
+		if after.Held < 0 {
+			problems = append(problems, fmt.Sprintf("entry %d (%s) released more of %s than was held", e.Seq, e.Op, ev.ConferenceID))
+		}
+	})
+	for id := range db.Conferences {
+		derived, ok := inventories[id]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("conference %s has no inventory stream", id))
+			continue
+		}
+		if live := db.liveInventoryLocked(id); derived != live {
+			problems = append(problems, fmt.Sprintf("conference %s live %+v, replayed %+v", id, live, derived))
+		}
+		if head := db.ledger.head.conferences[id]; derived != head {
+			problems = append(problems, fmt.Sprintf("conference %s ledger %+v, replayed %+v", id, head, derived))
+		}
+		if derived.Available < 0 {
+			problems = append(problems, fmt.Sprintf("conference %s sold %d of %d tickets", id, derived.Sold, derived.Capacity))
+		}
+	}
+	for id := range inventories {
+		if _, ok := db.Conferences[id]; !ok {
+			problems = append(problems, fmt.Sprintf("conference %s is deleted but its inventory stream isn't closed", id))
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
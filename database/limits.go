@@ -0,0 +1,84 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DefaultMaxTicketsPerUser is the per-conference cap a new Database starts with
+const DefaultMaxTicketsPerUser = 10
+
+// ErrTicketLimit is returned when a request would take a user past a per-user ticket cap
+var ErrTicketLimit = errors.New("per-user ticket limit reached")
+
+// Allowance reports how many more tickets a user may still acquire; nil means uncapped
+type Allowance struct {
+	UserID       string `json:"user_id"`
+	ConferenceID string `json:"conference_id"`
+	Conference   *int   `json:"conference"` // remaining for this conference
+	Global       *int   `json:"global"`     // remaining across all conferences
+}
+
+// userTicketsLocked sums a user's confirmed bookings and active reservations, for one
+// conference or for all of them when conferenceID is empty; caller must hold the lock
+func (db *Database) userTicketsLocked(userID, conferenceID string) int {
+	total := 0
+	for _, b := range db.Bookings {
+		if b.UserID == userID && b.Status == "confirmed" && (conferenceID == "" || b.ConferenceID == conferenceID) {
+			total += b.TicketsBooked
+		}
+	}
+	now := db.now()
+	for _, r := range db.Reservations {
+		if r.UserID == userID && now.Before(r.ExpiresAt) && (conferenceID == "" || r.ConferenceID == conferenceID) {
+			total += r.TicketCount
+		}
+	}
+	return total
+}
+
+// allowanceLocked computes the remaining per-conference and global allowance; caller must hold the lock
+func (db *Database) allowanceLocked(userID, conferenceID string) Allowance {
+	a := Allowance{UserID: userID, ConferenceID: conferenceID}
+	if db.MaxTicketsPerUser > 0 {
+		left := remaining(db.MaxTicketsPerUser, db.userTicketsLocked(userID, conferenceID))
+		a.Conference = &left
+	}
+	if db.MaxTicketsPerUserGlobal > 0 {
+		left := remaining(db.MaxTicketsPerUserGlobal, db.userTicketsLocked(userID, ""))
+		a.Global = &left
+	}
+	return a
+}
+
+// remaining returns cap minus used, floored at zero
+func remaining(limit, used int) int {
+	if used >= limit {
+		return 0
+	}
+	return limit - used
+}
+
+// checkUserCapLocked rejects ticketCount more tickets when they exceed the user's allowance;
+// caller must hold the lock
+func (db *Database) checkUserCapLocked(userID, conferenceID string, ticketCount int) error {
+	a := db.allowanceLocked(userID, conferenceID)
+	if a.Conference != nil && ticketCount > *a.Conference {
+		return fmt.Errorf("%w: you may book %d more tickets for this conference", ErrTicketLimit, *a.Conference)
+	}
+	if a.Global != nil && ticketCount > *a.Global {
+		return fmt.Errorf("%w: you may book %d more tickets in total", ErrTicketLimit, *a.Global)
+	}
+	return nil
+}
+
+// GetAllowance returns how many more tickets a user may still book for a conference
+func (db *Database) GetAllowance(userID, conferenceID string) (Allowance, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	if _, ok := db.Conferences[conferenceID]; !ok {
+		return Allowance{}, ErrConferenceNotFound
+	}
+	return db.allowanceLocked(userID, conferenceID), nil
+}
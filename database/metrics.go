@@ -0,0 +1,97 @@
+package database
+
+import "sort"
+
+// counters are process-lifetime totals; they survive ResetDatabase so they only ever grow
+type counters struct {
+	bookingsCreated       uint64
+	reservationsCreated   uint64
+	reservationsConfirmed uint64
+	reservationsExpired   uint64
+	reservationsCancelled uint64
+}
+
+// countLocked bumps the counters for an audited operation; caller must hold write lock.
+// Every state change goes through recordLocked, so counting there keeps the totals in step
+// with the audit log whichever code path made the change.
+func (db *Database) countLocked(op string) {
+	db.counters.add(op, 1)
+}
+
+// add counts n audited operations of kind op
+func (c *counters) add(op string, n uint64) {
+	switch op {
+	case OpBookingCreate:
+		c.bookingsCreated += n
+	case OpReservationCreate, OpQueueClaim, OpQueueOffer:
+		c.reservationsCreated += n
+	case OpReservationConfirm:
+		c.reservationsConfirmed += n
+		c.bookingsCreated += n
+	case OpReservationExpire:
+		c.reservationsExpired += n
+	case OpReservationCancel:
+		c.reservationsCancelled += n
+	}
+}
+
+// QueueGauge is the current queue length of one conference
+type QueueGauge struct {
+	ConferenceID string
+	Length       int
+}
+
+// Metrics is a point-in-time view of the counters and gauges
+type Metrics struct {
+	BookingsCreated       uint64
+	ReservationsCreated   uint64
+	ReservationsConfirmed uint64
+	ReservationsExpired   uint64
+	ReservationsCancelled uint64
+	ActiveReservations    int
+	QueueLengths          []QueueGauge // one per conference, sorted by conference ID
+}
+
+// WithOpCounts returns m with its counters taken from ops, a count per audited operation name
+// such as instances sharing their state keep together (see database/redisstore); the gauges
+// are kept
+func (m Metrics) WithOpCounts(ops map[string]uint64) Metrics {
+	var c counters
+	for op, n := range ops {
+		c.add(op, n)
+	}
+	m.BookingsCreated = c.bookingsCreated
+	m.ReservationsCreated = c.reservationsCreated
+	m.ReservationsConfirmed = c.reservationsConfirmed
+	m.ReservationsExpired = c.reservationsExpired
+	m.ReservationsCancelled = c.reservationsCancelled
+	return m
+}
+
+// GetMetrics returns the current counters plus active-reservation and queue-length gauges
+func (db *Database) GetMetrics() Metrics {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	m := Metrics{
+		BookingsCreated:       db.counters.bookingsCreated,
+		ReservationsCreated:   db.counters.reservationsCreated,
+		ReservationsConfirmed: db.counters.reservationsConfirmed,
+		ReservationsExpired:   db.counters.reservationsExpired,
+		ReservationsCancelled: db.counters.reservationsCancelled,
+		QueueLengths:          make([]QueueGauge, 0, len(db.Conferences)),
+	}
+	now := db.now()
+	for _, r := range db.Reservations {
+		if now.Before(r.ExpiresAt) {
+			m.ActiveReservations++
+		}
+	}
+	for id := range db.Conferences {
+		m.QueueLengths = append(m.QueueLengths, QueueGauge{ConferenceID: id, Length: len(db.WaitQueues[id])})
+	}
+	sort.Slice(m.QueueLengths, func(i, j int) bool {
+		return m.QueueLengths[i].ConferenceID < m.QueueLengths[j].ConferenceID
+	})
+	return m
+}
@@ -0,0 +1,156 @@
+package database
+
+import (
+	"log/slog"
+	"time"
+
+	"booking-system/models"
+
+	"github.com/google/uuid"
+)
+
+// ErrNotAnOffer is returned by AcceptOffer for a reservation that is not a pending offer
+var ErrNotAnOffer = conflictf("reservation is not a pending offer")
+
+// ErrOfferPending is returned when extending an offer that hasn't been accepted yet
+var ErrOfferPending = conflictf("accept the offer before extending it")
+
+// offerSeatsLocked hands freed seats to the queue: while the head's whole request fits, the head
+// is taken off the queue and given a reservation in the offered state that lapses after
+// OfferWindow unless accepted or confirmed, in the tier it waited for and at that tier's price.
+// A head the per-user cap no longer lets hold seats is dropped. Caller must hold write lock.
+func (db *Database) offerSeatsLocked(conferenceID string, now time.Time) {
+	conf, ok := db.Conferences[conferenceID]
+	if !ok || checkUpcoming(conf, now) != nil {
+		return
+	}
+	for {
+		q := db.WaitQueues[conferenceID]
+		if len(q) == 0 {
+			return
+		}
+		head := q[0]
+		reserved := db.reservedForConferenceLocked(conferenceID)
+		if db.queueRoomLocked(conf, head) < head.TicketCount {
+			return
+		}
+		if db.checkHeldCapLocked(conf, reserved, head.TicketCount) != nil {
+			return
+		}
+		if err := db.checkUserCapLocked(head.UserID, conferenceID, head.TicketCount); err != nil {
+			db.removeQueueEntryLocked(conferenceID, head.UserID)
+			db.recordLocked(AuditEntry{Op: OpQueueDrop, UserID: head.UserID, ConferenceID: conferenceID, EntryID: head.ID})
+			db.logLocked(slog.LevelWarn, "Dropped queue head instead of offering seats", "conference_id", conferenceID, "user_id", head.UserID, "error", err)
+			continue
+		}
+		_, price, err := pickTier(conf, head.Tier)
+		if err != nil {
+			db.removeQueueEntryLocked(conferenceID, head.UserID)
+			db.recordLocked(AuditEntry{Op: OpQueueDrop, UserID: head.UserID, ConferenceID: conferenceID, EntryID: head.ID})
+			db.logLocked(slog.LevelWarn, "Dropped queue head instead of offering seats", "conference_id", conferenceID, "user_id", head.UserID, "error", err)
+			continue
+		}
+		seats, err := db.pickSeatsLocked(conf, head.TicketCount, nil)
+		if err != nil {
+			return
+		}
+
+		offer := &models.SeatReservation{
+			ID:           uuid.New().String(),
+			UserID:       head.UserID,
+			ConferenceID: conferenceID,
+			TicketCount:  head.TicketCount,
+			TotalAmount:  price * float64(head.TicketCount),
+			ExpiresAt:    now.Add(db.OfferWindow),
+			CreatedAt:    now,
+			Source:       models.ReservationSourceQueue,
+			Status:       models.ReservationStatusOffered,
+			Tier:         head.Tier,
+			HoldSeconds:  holdSeconds(db.OfferWindow),
+			SeatIDs:      seats,
+			QueueTurn:    &models.QueueTurn{EntryID: head.ID, Priority: head.Priority, MissedClaims: head.MissedClaims},
+		}
+		db.Reservations[offer.ID] = offer
+		db.WaitQueues[conferenceID] = q[1:]
+		db.recordLocked(AuditEntry{
+			Op: OpQueueOffer, At: now, UserID: offer.UserID, ConferenceID: conferenceID, EntryID: head.ID,
+			ReservationID: offer.ID, TicketCount: offer.TicketCount, Amount: offer.TotalAmount, ExpiresAt: offer.ExpiresAt,
+			HoldSeconds: offer.HoldSeconds, Tier: offer.Tier, SeatIDs: seats, Priority: head.Priority, MissedClaims: head.MissedClaims,
+		})
+		db.logLocked(slog.LevelInfo, "Offered freed seats to queue head", "conference_id", conferenceID, "ticket_count", offer.TicketCount, "user_id", offer.UserID, "expires_at", offer.ExpiresAt)
+	}
+}
+
+// requeueLapsedOfferLocked gives the user whose offer lapsed unanswered the same second chances
+// as a notified head that lets its claim window pass (see lapseHeadLocked): back of its priority
+// class while it has requeues left (MaxClaimRequeues), otherwise out of the queue for good.
+// Caller must hold write lock.
+func (db *Database) requeueLapsedOfferLocked(offer *models.SeatReservation, now time.Time) {
+	turn := offer.QueueTurn
+	if turn == nil || turn.MissedClaims >= db.MaxClaimRequeues {
+		return
+	}
+	for _, e := range db.WaitQueues[offer.ConferenceID] {
+		if e.UserID == offer.UserID {
+			return // joined again while the offer was open
+		}
+	}
+	entry := &WaitEntry{
+		ID:           turn.EntryID,
+		UserID:       offer.UserID,
+		ConferenceID: offer.ConferenceID,
+		TicketCount:  offer.TicketCount,
+		Tier:         offer.Tier,
+		EnqueuedAt:   now,
+		MissedClaims: turn.MissedClaims + 1,
+		Priority:     turn.Priority,
+	}
+	db.insertQueueEntryLocked(entry)
+	db.recordLocked(AuditEntry{
+		Op: OpQueueRequeue, At: now, UserID: entry.UserID, ConferenceID: entry.ConferenceID, EntryID: entry.ID,
+		ReservationID: offer.ID, TicketCount: entry.TicketCount, Tier: entry.Tier, Priority: entry.Priority, MissedClaims: entry.MissedClaims,
+	})
+	db.logLocked(slog.LevelInfo, "Queue offer lapsed; moved the user to the back", "conference_id", entry.ConferenceID,
+		"user_id", entry.UserID, "missed_claims", entry.MissedClaims)
+}
+
+// AcceptOffer turns a pending queue offer into a regular hold with the conference's hold time to pay.
+// Confirming an offer directly accepts it too; cancelling it declines it and the seats go to
+// the next in line.
+func (db *Database) AcceptOffer(reservationID string) (*models.SeatReservation, error) {
+	db.lock()
+	defer db.unlock()
+	db.cleanupExpiredReservationsLocked()
+
+	reservation, exists := db.Reservations[reservationID]
+	if !exists {
+		return nil, db.missingReservationErrLocked(reservationID)
+	}
+	if reservation.Status != models.ReservationStatusOffered {
+		return nil, ErrNotAnOffer
+	}
+	hold := ReservationHold
+	if conf, ok := db.Conferences[reservation.ConferenceID]; ok {
+		hold = db.holdFor(conf)
+	}
+	reservation.Status = models.ReservationStatusActive
+	reservation.ExpiresAt = db.now().Add(hold)
+	reservation.HoldSeconds = holdSeconds(hold)
+	db.recordLocked(AuditEntry{
+		Op: OpOfferAccept, ReservationID: reservationID, UserID: reservation.UserID, ConferenceID: reservation.ConferenceID,
+		ExpiresAt: reservation.ExpiresAt, HoldSeconds: reservation.HoldSeconds,
+	})
+	return snapshotReservation(reservation), nil
+}
+
+// pendingOfferLocked returns the user's unexpired offer for a conference, nil if none; caller
+// must hold the lock
+func (db *Database) pendingOfferLocked(userID, conferenceID string) *models.SeatReservation {
+	now := db.now()
+	for _, r := range db.Reservations {
+		if r.UserID == userID && r.ConferenceID == conferenceID && r.Status == models.ReservationStatusOffered && now.Before(r.ExpiresAt) {
+			return r
+		}
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+
+	"booking-system/models"
+)
+
+// ErrPaymentFailed wraps every charge a PaymentProcessor refuses; the reservation is kept so
+// the user can retry before it expires
+var ErrPaymentFailed = errors.New("payment failed")
+
+// PaymentProcessor charges a user when a reservation is confirmed. Charge runs under the
+// database write lock, so implementations should answer quickly.
+type PaymentProcessor interface {
+	Charge(amount float64, userID string) error
+}
+
+// SimulatedPayments is an in-memory PaymentProcessor for load tests: it declines FailPercent
+// of charges at random, and always declines the amounts listed in FailAmounts
+type SimulatedPayments struct {
+	FailPercent float64
+	FailAmounts []float64
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// NewSimulatedPayments returns a processor declining failPercent (0-100) of charges at random
+// plus every charge for one of failAmounts
+func NewSimulatedPayments(failPercent float64, failAmounts ...float64) *SimulatedPayments {
+	return &SimulatedPayments{
+		FailPercent: failPercent,
+		FailAmounts: failAmounts,
+		rng:         rand.New(rand.NewSource(rand.Int63())),
+	}
+}
+
+// Charge declines listed amounts and a random FailPercent of the rest
+func (p *SimulatedPayments) Charge(amount float64, userID string) error {
+	for _, a := range p.FailAmounts {
+		if a == amount {
+			return fmt.Errorf("card declined for %.2f", amount)
+		}
+	}
+	if p.FailPercent <= 0 {
+		return nil
+	}
+	p.mu.Lock()
+	roll := p.rng.Float64() * 100
+	p.mu.Unlock()
+	if roll < p.FailPercent {
+		return fmt.Errorf("card declined (simulated %g%% failure rate)", p.FailPercent)
+	}
+	return nil
+}
+
+// chargeLocked runs the reservation's amount through the configured processor, if any;
+// caller must hold write lock
+func (db *Database) chargeLocked(amount float64, userID string) error {
+	if db.Payments == nil {
+		return nil
+	}
+	if err := db.Payments.Charge(amount, userID); err != nil {
+		return fmt.Errorf("%w: %w", ErrPaymentFailed, err)
+	}
+	return nil
+}
+
+// ErrPaymentMismatch is returned when attaching a payment to a reservation already paying
+// through another one
+var ErrPaymentMismatch = conflictf("reservation already has a different payment")
+
+// AttachPayment records the provider payment intent a reservation will be paid through, along
+// with the client secret the owner completes it with. From then on the reservation is booked by
+// CompletePayment. Attaching the same intent again is a no-op.
+func (db *Database) AttachPayment(reservationID, paymentID, clientSecret string) (*models.SeatReservation, error) {
+	db.lock()
+	defer db.unlock()
+
+	reservation, exists := db.Reservations[reservationID]
+	if !exists {
+		return nil, db.missingReservationErrLocked(reservationID)
+	}
+	if now := db.now(); now.After(reservation.ExpiresAt) {
+		db.expireReservationLocked(reservation, now)
+		return nil, &ReservationExpiredError{ExpiredAt: reservation.ExpiresAt}
+	}
+	if reservation.PaymentID == paymentID {
+		return snapshotReservation(reservation), nil
+	}
+	if reservation.PaymentID != "" {
+		return nil, ErrPaymentMismatch
+	}
+	db.attachPaymentLocked(reservation, paymentID, clientSecret)
+	return snapshotReservation(reservation), nil
+}
+
+// attachPaymentLocked records a reservation's payment intent; the hold keeps its expiry until
+// checkout starts the payment. Caller must hold write lock.
+func (db *Database) attachPaymentLocked(reservation *models.SeatReservation, paymentID, clientSecret string) {
+	reservation.PaymentID, reservation.PaymentClientSecret = paymentID, clientSecret
+	db.recordLocked(AuditEntry{
+		Op: OpReservationPayment, ReservationID: reservation.ID, UserID: reservation.UserID,
+		ConferenceID: reservation.ConferenceID, PaymentID: paymentID,
+	})
+}
+
+// holdForPaymentLocked keeps a hold whose payment has started for PaymentHoldTime from now, so
+// the seats are still there when the provider reports the payment. It only ever lengthens the
+// hold and stops at MaxHoldTime after it was made. Caller must hold write lock.
+func (db *Database) holdForPaymentLocked(reservation *models.SeatReservation) {
+	hold := db.PaymentHoldTime
+	if hold <= 0 {
+		hold = DefaultPaymentHoldTime
+	}
+	until := db.now().Add(hold)
+	if db.MaxHoldTime > 0 {
+		if limit := reservation.CreatedAt.Add(db.MaxHoldTime); until.After(limit) {
+			until = limit
+		}
+	}
+	if !until.After(reservation.ExpiresAt) {
+		return
+	}
+	reservation.ExpiresAt, reservation.HoldSeconds = until, holdSeconds(hold)
+	db.recordLocked(AuditEntry{
+		Op: OpReservationPayment, ReservationID: reservation.ID, UserID: reservation.UserID,
+		ConferenceID: reservation.ConferenceID, PaymentID: reservation.PaymentID,
+		ExpiresAt: reservation.ExpiresAt, HoldSeconds: reservation.HoldSeconds,
+	})
+}
+
+// PrepareConfirmation takes a confirm call for a reservation paid through the provider, whose
+// booking is only made once the payment is reported: it checks ExpectedVersion now, against
+// the conference the caller saw (the version moves with every sale, so it can't wait for the
+// payment), keeps the hold for the payment and keeps the attendees for CompletePayment to book
+// with
+func (db *Database) PrepareConfirmation(reservationID string, opts BookingOptions) (*models.SeatReservation, error) {
+	db.lock()
+	defer db.unlock()
+	db.tagLocked(opts.RequestID, opts.ActorID)
+
+	reservation, exists := db.Reservations[reservationID]
+	if !exists {
+		return nil, db.missingReservationErrLocked(reservationID)
+	}
+	if now := db.now(); now.After(reservation.ExpiresAt) {
+		db.expireReservationLocked(reservation, now)
+		return nil, &ReservationExpiredError{ExpiredAt: reservation.ExpiresAt}
+	}
+	if conf, ok := db.Conferences[reservation.ConferenceID]; ok {
+		if err := checkVersion(conf, opts.ExpectedVersion); err != nil {
+			return nil, err
+		}
+	}
+	db.holdForPaymentLocked(reservation)
+	if len(opts.Attendees) == 0 {
+		return snapshotReservation(reservation), nil
+	}
+	attendees, err := normalizeAttendees(opts.Attendees, reservation.TicketCount)
+	if err != nil {
+		return nil, err
+	}
+	reservation.Attendees = attendees
+	db.recordLocked(AuditEntry{
+		Op: OpReservationNames, ReservationID: reservationID, UserID: reservation.UserID,
+		ConferenceID: reservation.ConferenceID, Attendees: attendees,
+	})
+	return snapshotReservation(reservation), nil
+}
+
+// CompletePayment books the reservation paid through paymentID once the provider reports the
+// payment succeeded. Nothing is charged here; the provider already took the money. Providers
+// deliver at least once, so a payment that already booked returns that booking again. A cart's
+// payment books every hold in the cart and returns the first booking.
+func (db *Database) CompletePayment(paymentID string) (*models.Booking, error) {
+	db.lock()
+	defer db.unlock()
+
+	if paymentID == "" {
+		return nil, ErrReservationNotFound
+	}
+	if booking, found, err := db.completeCartPaymentLocked(paymentID); found {
+		return snapshotBooking(booking), err
+	}
+	for _, booking := range db.Bookings {
+		if booking.PaymentID == paymentID {
+			return snapshotBooking(booking), nil
+		}
+	}
+	for _, reservation := range db.Reservations {
+		if reservation.PaymentID == paymentID {
+			booking, err := db.confirmLocked(reservation, BookingOptions{Attendees: reservation.Attendees}, false)
+			return snapshotBooking(booking), err
+		}
+	}
+	return nil, ErrReservationNotFound
+}
+
+// FindPayment returns copies of the cart, or else the active reservation, paying through
+// paymentID; both are nil when neither is. It lets a store tell which records CompletePayment
+// will touch before calling it.
+func (db *Database) FindPayment(paymentID string) (*models.Cart, *models.SeatReservation) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	if paymentID == "" {
+		return nil, nil
+	}
+	for _, cart := range db.Carts {
+		if cart.PaymentID == paymentID {
+			return snapshotCart(cart), nil
+		}
+	}
+	for _, reservation := range db.Reservations {
+		if reservation.PaymentID == paymentID {
+			return nil, snapshotReservation(reservation)
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,214 @@
+package database
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"booking-system/models"
+)
+
+// PersistTimeout bounds a single load or save against a Persister
+const PersistTimeout = 10 * time.Second
+
+// Persister is a durable home for snapshots: a file (SnapshotFile), or the SQL tables that
+// database/sqlstore also writes each change to as it happens.
+type Persister interface {
+	Save(ctx context.Context, snap Snapshot) error
+	Load(ctx context.Context) (Snapshot, error)
+	// Empty reports whether nothing has been saved yet
+	Empty(ctx context.Context) (bool, error)
+	Close() error
+}
+
+// LoadFrom restores the state saved in p, or seeds p with the current state when it is empty
+// so a fresh install keeps its sample data. seeded reports which of the two happened.
+func (db *Database) LoadFrom(ctx context.Context, p Persister) (seeded bool, err error) {
+	empty, err := p.Empty(ctx)
+	if err != nil {
+		return false, err
+	}
+	if empty {
+		return true, p.Save(ctx, db.Snapshot())
+	}
+	snap, err := p.Load(ctx)
+	if err != nil {
+		return false, err
+	}
+	db.Restore(snap)
+	return false, nil
+}
+
+// SyncTo saves the state to p every interval when it has changed since the last save, until
+// the returned stop func is called. Failed saves are logged and retried on the next tick.
+func (db *Database) SyncTo(p Persister, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	ticker := time.NewTicker(interval)
+	saved := db.Writes() // read before returning, so a change made right after is saved
+	go func() {
+		defer close(stopped)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				writes := db.Writes()
+				if writes == saved {
+					continue
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), PersistTimeout)
+				err := p.Save(ctx, db.Snapshot())
+				cancel()
+				if err != nil {
+					slog.Error("Storage sync failed", "error", err)
+					continue
+				}
+				saved = writes
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+		<-stopped
+	}
+}
+
+// ChangeCursor marks how much of the state a store has written through; see ChangesSince
+type ChangeCursor struct {
+	epoch uint64 // the state it belongs to; restores and resets start a new one
+	seq   int    // the last audit entry written
+}
+
+// Cursor marks the current state, for a store that has just saved all of it
+func (db *Database) Cursor() ChangeCursor {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	return ChangeCursor{epoch: db.epoch, seq: db.lastSeqLocked()}
+}
+
+// Changes is what ChangesSince collects
+type Changes struct {
+	// Delta holds the records the changes touched, as they are now: a nil value is one since
+	// removed (a hold confirmed, cancelled or expired, a conference deleted), and a touched
+	// conference's wait queue is there whole, empty once drained. Expired has the tombstones of
+	// the holds that expired.
+	Delta Snapshot
+	// Retired are the reservations the changes took out of the active set, with their final
+	// status, as kept in their users' history
+	Retired []*models.SeatReservation
+	// Entries are the audit entries of the changes, oldest first
+	Entries []AuditEntry
+	// Next marks the state Delta reflects
+	Next ChangeCursor
+}
+
+// ChangesSince collects the changes made after c, for a store that writes only what changed
+// (see database/sqlstore and database/redisstore). ok is false when the audit log no longer
+// reaches back to c (it was trimmed past it, or the state was restored or reset since), and the
+// caller has to save everything instead; Next is set either way.
+func (db *Database) ChangesSince(c ChangeCursor) (changes Changes, ok bool) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	changes.Next = ChangeCursor{epoch: db.epoch, seq: db.lastSeqLocked()}
+	if c.epoch != db.epoch || c.seq > changes.Next.seq {
+		return changes, false
+	}
+	first := changes.Next.seq + 1
+	if len(db.Audit) > 0 {
+		first = db.Audit[0].Seq
+	}
+	if c.seq < first-1 {
+		return changes, false
+	}
+
+	delta := Snapshot{
+		SavedAt:      db.now(),
+		Users:        make(map[string]*models.User),
+		Conferences:  make(map[string]*models.Conference),
+		Bookings:     make(map[string]*models.Booking),
+		Reservations: make(map[string]*models.SeatReservation),
+		WaitQueues:   make(map[string][]*WaitEntry),
+		PromoCodes:   make(map[string]*models.PromoCode),
+		Carts:        make(map[string]*models.Cart),
+		CheckIns:     make(map[string]*models.CheckIn),
+		Expired:      make(map[string]time.Time),
+		Credentials:  make(map[string]string),
+	}
+	touchConference := func(id string) {
+		if _, done := delta.Conferences[id]; done {
+			return
+		}
+		delta.Conferences[id] = snapshotConference(db.Conferences[id])
+		queue := make([]*WaitEntry, len(db.WaitQueues[id]))
+		for i, e := range db.WaitQueues[id] {
+			cp := *e
+			queue[i] = &cp
+		}
+		delta.WaitQueues[id] = queue
+	}
+	changes.Entries = append([]AuditEntry(nil), db.Audit[c.seq-first+1:]...)
+	for _, e := range changes.Entries {
+		switch e.Op {
+		case OpUserCreate, OpUserRole, OpUserPriority: // other entries only name the user
+			var user *models.User
+			if u, ok := db.Users[e.UserID]; ok {
+				cp := *u
+				user = &cp
+			}
+			delta.Users[e.UserID] = user
+			if hash, ok := db.credentials[e.UserID]; ok {
+				delta.Credentials[e.UserID] = hash
+			}
+		case OpReservationConfirm, OpReservationCancel, OpReservationExpire:
+			if r := db.retiredLocked(e.UserID, e.ReservationID); r != nil {
+				changes.Retired = append(changes.Retired, r)
+			}
+			if at, ok := db.expired[e.ReservationID]; ok && e.Op == OpReservationExpire {
+				delta.Expired[e.ReservationID] = at
+			}
+		}
+		if e.ConferenceID != "" {
+			touchConference(e.ConferenceID)
+		}
+		if e.BookingID != "" {
+			b := db.Bookings[e.BookingID]
+			delta.Bookings[e.BookingID] = snapshotBooking(b)
+			if b != nil {
+				touchConference(b.ConferenceID)
+			}
+		}
+		if e.ReservationID != "" {
+			delta.Reservations[e.ReservationID] = snapshotReservation(db.Reservations[e.ReservationID])
+		}
+		if e.PromoCode != "" {
+			var promo *models.PromoCode
+			if p, ok := db.PromoCodes[e.PromoCode]; ok {
+				cp := *p
+				promo = &cp
+			}
+			delta.PromoCodes[e.PromoCode] = promo
+		}
+		if e.Cart != nil {
+			var cart *models.Cart
+			if got, ok := db.Carts[e.Cart.ID]; ok {
+				cart = snapshotCart(got)
+			}
+			delta.Carts[e.Cart.ID] = cart
+		}
+		if e.CheckIn != nil {
+			var in *models.CheckIn
+			if got, ok := db.CheckIns[e.CheckIn.TicketID]; ok {
+				cp := *got
+				in = &cp
+			}
+			delta.CheckIns[e.CheckIn.TicketID] = in
+		}
+	}
+	changes.Delta = delta
+	return changes, true
+}
@@ -0,0 +1,125 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// migrations are applied in order and never edited once released; schema changes go in a
+// new entry at the end. Each row keeps its full JSON form in data, with the columns the
+// schema indexes or constrains pulled out alongside it.
+var migrations = []string{
+	// 1: initial schema
+	`CREATE TABLE users (
+		id         text PRIMARY KEY,
+		email      text NOT NULL UNIQUE,
+		created_at timestamptz NOT NULL,
+		data       jsonb NOT NULL
+	);
+	CREATE TABLE conferences (
+		id   text PRIMARY KEY,
+		name text NOT NULL,
+		date timestamptz,
+		data jsonb NOT NULL
+	);
+	CREATE TABLE bookings (
+		id            text PRIMARY KEY,
+		user_id       text NOT NULL,
+		conference_id text NOT NULL,
+		status        text NOT NULL,
+		booked_at     timestamptz NOT NULL,
+		data          jsonb NOT NULL
+	);
+	CREATE INDEX bookings_user_id_idx ON bookings (user_id);
+	CREATE INDEX bookings_conference_id_idx ON bookings (conference_id);
+	CREATE TABLE reservations (
+		id            text PRIMARY KEY,
+		user_id       text NOT NULL,
+		conference_id text NOT NULL,
+		expires_at    timestamptz NOT NULL,
+		data          jsonb NOT NULL
+	);
+	CREATE INDEX reservations_conference_id_idx ON reservations (conference_id);
+	CREATE TABLE wait_queue (
+		conference_id text NOT NULL,
+		position      integer NOT NULL,
+		id            text NOT NULL UNIQUE,
+		user_id       text NOT NULL,
+		data          jsonb NOT NULL,
+		PRIMARY KEY (conference_id, position)
+	);
+	CREATE TABLE promo_codes (
+		code text PRIMARY KEY,
+		data jsonb NOT NULL
+	);`,
+	// 2: login passwords (see database.RegisterUser)
+	`CREATE TABLE credentials (
+		user_id       text PRIMARY KEY,
+		password_hash text NOT NULL
+	);`,
+	// 3: multi-conference carts (see database.CheckoutCart)
+	`CREATE TABLE carts (
+		id         text PRIMARY KEY,
+		user_id    text NOT NULL,
+		status     text NOT NULL,
+		updated_at timestamptz NOT NULL,
+		data       jsonb NOT NULL
+	);
+	CREATE INDEX carts_user_id_idx ON carts (user_id);`,
+	// 4: tickets admitted at the door (see database.CheckInTicket)
+	`CREATE TABLE check_ins (
+		ticket_id     text PRIMARY KEY,
+		conference_id text NOT NULL,
+		checked_in_at timestamptz NOT NULL,
+		data          jsonb NOT NULL
+	);
+	CREATE INDEX check_ins_conference_id_idx ON check_ins (conference_id);`,
+}
+
+// migrationLock is the advisory lock key that keeps two servers from migrating at once
+const migrationLock = 7_305_118_224
+
+// Migrate brings the schema up to date, applying each pending migration in its own
+// transaction and recording it in schema_migrations
+func Migrate(ctx context.Context, db *sql.DB) error {
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    integer PRIMARY KEY,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+	for i, stmt := range migrations {
+		if err := applyMigration(ctx, db, i+1, stmt); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// applyMigration runs one migration unless it is already recorded
+func applyMigration(ctx context.Context, db *sql.DB, version int, stmt string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationLock); err != nil {
+		return err
+	}
+	var applied bool
+	if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)`, version).Scan(&applied); err != nil {
+		return err
+	}
+	if applied {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, stmt); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,32 @@
+// Package postgres keeps the booking state in PostgreSQL so it survives restarts. Open returns
+// the tables for sqlstore.Open, whose Store commits every change to them before answering
+// while the in-memory database.Database runs the locking and booking rules.
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"booking-system/database/sqlstore"
+
+	_ "github.com/lib/pq" // registers the "postgres" driver
+)
+
+// Open connects to the database at dsn (a postgres:// URL or key=value string) and applies
+// any pending migrations
+func Open(ctx context.Context, dsn string) (*sqlstore.Persister, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open postgres: %w", err)
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("connect to postgres: %w", err)
+	}
+	if err := Migrate(ctx, db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return sqlstore.New(db, `TRUNCATE users, conferences, bookings, reservations, wait_queue, promo_codes, credentials, carts, check_ins`), nil
+}
@@ -0,0 +1,129 @@
+package postgres
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"booking-system/database"
+	"booking-system/database/sqlstore"
+)
+
+// openTestPersister connects to POSTGRES_TEST_DSN, skipping the test when it is unset.
+// The database it names is wiped, so point it at a throwaway one.
+func openTestPersister(t *testing.T) *sqlstore.Persister {
+	t.Helper()
+	dsn := os.Getenv("POSTGRES_TEST_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_TEST_DSN not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	p, err := Open(ctx, dsn)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+func TestSaveAndLoadRoundTrip(t *testing.T) {
+	p := openTestPersister(t)
+	ctx := context.Background()
+
+	db := database.NewDatabase()
+	defer db.Close()
+	user, _ := db.CreateUser("Alice", "alice@example.com")
+	waiter, _ := db.CreateUser("Bob", "bob@example.com")
+	booking, err := db.CreateBooking(user.ID, "conf-1", 2, database.BookingOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	res, err := db.CreateReservation(user.ID, "conf-2", 1, database.ReservationOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	db.EnqueueWait(waiter.ID, "conf-1", 1, "")
+
+	if err := p.Save(ctx, db.Snapshot()); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	// migrating again is a no-op
+	if err := Migrate(ctx, p.DB()); err != nil {
+		t.Fatalf("re-migrate: %v", err)
+	}
+	snap, err := p.Load(ctx)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	restored := database.NewDatabase()
+	defer restored.Close()
+	restored.Restore(snap)
+	if _, err := restored.GetUser(user.ID); err != nil {
+		t.Fatalf("expected the user back, got %v", err)
+	}
+	if got := restored.GetBooking(booking.ID); got == nil || got.TicketsBooked != 2 {
+		t.Fatalf("expected the booking back, got %+v", got)
+	}
+	if _, err := restored.GetReservation(res.ID); err != nil {
+		t.Fatalf("expected the active reservation back, got %v", err)
+	}
+	if pos := restored.GetQueuePosition(waiter.ID, "conf-1").Position; pos != 1 {
+		t.Fatalf("expected the queue entry back, got position %d", pos)
+	}
+	orig, _ := db.GetConference("conf-1")
+	if got, _ := restored.GetConference("conf-1"); got.AvailableTickets != orig.AvailableTickets {
+		t.Fatalf("expected availability %d, got %d", orig.AvailableTickets, got.AvailableTickets)
+	}
+	if empty, err := p.Empty(ctx); err != nil || empty {
+		t.Fatalf("expected a saved database not to be empty, got %v %v", empty, err)
+	}
+}
+
+func TestStoreWritesChangesThrough(t *testing.T) {
+	p := openTestPersister(t)
+	ctx := context.Background()
+	if _, err := p.DB().ExecContext(ctx, `TRUNCATE users, conferences, bookings, reservations, wait_queue, promo_codes, credentials, carts, check_ins`); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+
+	local := database.NewDatabase()
+	store, seeded, err := sqlstore.Open(ctx, local, p)
+	if err != nil || !seeded {
+		t.Fatalf("expected an empty database to be seeded, got seeded=%v err=%v", seeded, err)
+	}
+	defer store.Close()
+	user, _ := store.RegisterUser("Alice", "alice@example.com", "secret123", "user")
+	res, err := store.CreateReservation(user.ID, "conf-1", 2, database.ReservationOptions{PromoCode: "GOPHER50"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	booking, err := store.ConfirmReservation(res.ID, database.BookingOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// what a crash right now would leave behind
+	snap, err := p.Load(ctx)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if _, held := snap.Reservations[res.ID]; held {
+		t.Fatalf("expected the confirmed hold's row deleted")
+	}
+	if got := snap.Bookings[booking.ID]; got == nil || got.TicketsBooked != 2 {
+		t.Fatalf("expected the booking stored, got %+v", got)
+	}
+	if snap.Credentials[user.ID] == "" {
+		t.Fatalf("expected the password hash stored")
+	}
+	if promo := snap.PromoCodes["GOPHER50"]; promo == nil || promo.Uses != 1 {
+		t.Fatalf("expected the promo code's use stored, got %+v", promo)
+	}
+	orig, _ := local.GetConference("conf-1")
+	if got := snap.Conferences["conf-1"]; got.AvailableTickets != orig.AvailableTickets {
+		t.Fatalf("expected availability %d stored, got %d", orig.AvailableTickets, got.AvailableTickets)
+	}
+}
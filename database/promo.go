@@ -0,0 +1,79 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"booking-system/models"
+)
+
+// ErrInvalidPromoCode is returned when a promo code is unknown, expired, used up or not valid
+// for the conference being reserved
+var ErrInvalidPromoCode = errors.New("invalid promo code")
+
+// ReservationOptions carries the optional parts of a reservation request
+type ReservationOptions struct {
+	PromoCode       string // case-insensitive; empty means no discount
+	Tier            string // hold seats in this tier at its price; empty uses the aggregate pool
+	RequestID       string // the API request asking, recorded on the audit entries it causes
+	ActorID         string // the signed-in user asking, recorded likewise; empty credits the user holding
+	ExpectedVersion *int   // reject with ErrVersionConflict unless the conference is at this version
+	// Seats to lock at a conference with a seat map, one per ticket; empty takes the first free ones
+	SeatIDs []string
+}
+
+// normalizePromoCode makes promo code lookups case- and whitespace-insensitive
+func normalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// promoForLocked returns the promo code to apply to a new hold on conferenceID, nil when code
+// is empty. Active holds carrying the code count against MaxUses so it can't be oversold.
+// Caller must hold the lock.
+func (db *Database) promoForLocked(code, conferenceID string) (*models.PromoCode, error) {
+	code = normalizePromoCode(code)
+	if code == "" {
+		return nil, nil
+	}
+	promo, ok := db.PromoCodes[code]
+	now := db.now()
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("%w: %s does not exist", ErrInvalidPromoCode, code)
+	case !promo.ExpiresAt.IsZero() && !now.Before(promo.ExpiresAt):
+		return nil, fmt.Errorf("%w: %s has expired", ErrInvalidPromoCode, code)
+	case promo.ConferenceID != "" && promo.ConferenceID != conferenceID:
+		return nil, fmt.Errorf("%w: %s does not apply to this conference", ErrInvalidPromoCode, code)
+	}
+	if promo.MaxUses > 0 {
+		held := 0
+		for _, r := range db.Reservations {
+			if r.PromoCode == code && now.Before(r.ExpiresAt) {
+				held++
+			}
+		}
+		if promo.Uses+held >= promo.MaxUses {
+			return nil, fmt.Errorf("%w: %s has been used up", ErrInvalidPromoCode, code)
+		}
+	}
+	return promo, nil
+}
+
+// redeemPromoLocked counts a confirmed use of the reservation's promo code, if any;
+// caller must hold write lock
+func (db *Database) redeemPromoLocked(reservation *models.SeatReservation) {
+	if promo, ok := db.PromoCodes[reservation.PromoCode]; ok {
+		promo.Uses++
+	}
+}
+
+// addSamplePromoCodes seeds the demo promo codes; caller must hold write lock
+func (db *Database) addSamplePromoCodes() {
+	for _, p := range []*models.PromoCode{
+		{Code: "EARLYBIRD", PercentOff: 20, MaxUses: 100, ExpiresAt: db.now().AddDate(0, 1, 0)},
+		{Code: "GOPHER50", AmountOff: 50, ConferenceID: "conf-1", MaxUses: 10},
+	} {
+		db.PromoCodes[p.Code] = p
+	}
+}
@@ -0,0 +1,626 @@
+package redisstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"booking-system/database"
+	"booking-system/models"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// The shared state is kept one record per key, named by kind and ID (booking:conference:<id>,
+// booking:queue:<conference id>, ...). Each publish writes the records a write changed, bumps
+// seqKey and appends an entry numbered seqKey to the change stream in one transaction. The
+// entry lists the keys written, the reservations retired into their users' history and the
+// events to deliver. An instance takes in the entries after the last one it saw by reading
+// the keys they list; one that fell further behind than the stream reaches, or that sees a
+// reset entry, reads every record instead.
+
+// ChangeLogLength is about how many entries the change stream keeps
+const ChangeLogLength = 10000
+
+// Record kinds, the first part of a record's key after KeyPrefix
+const (
+	recUser        = "user"
+	recCredentials = "credentials"
+	recConference  = "conference"
+	recBooking     = "booking"
+	recReservation = "reservation"
+	recQueue       = "queue"
+	recRoom        = "room"
+	recPromo       = "promo"
+	recCart        = "cart"
+	recCheckIn     = "checkin"
+	recExpired     = "expired"     // a reservation tombstone
+	recHistory     = "history"     // a list of a user's finished reservations
+	recIdempotency = "idempotency" // what a user's Idempotency-Key produced
+)
+
+// recordKinds are the kinds a full load reads
+var recordKinds = []string{recUser, recCredentials, recConference, recBooking, recReservation, recQueue,
+	recRoom, recPromo, recCart, recCheckIn, recExpired}
+
+func reservationKey(id string) string { return key(recReservation, id) }
+
+// errSeeded means another instance seeded the shared state first
+var errSeeded = errors.New("shared state already seeded")
+
+// record is a value to write; nil data deletes the key
+type record struct {
+	data     []byte
+	expireAt time.Time // zero keeps it until overwritten
+}
+
+// batch is one publish
+type batch struct {
+	records map[string]record
+	retired []*models.SeatReservation
+	events  []database.Event
+	ops     map[string]int64  // audited operations, for the shared metrics counters
+	reset   bool              // the records replace the whole state
+	seed    bool              // only commit while there is no shared state yet
+	other   map[string]record // written but not announced to followers (idempotency keys, reset deletions)
+}
+
+func newBatch() *batch {
+	return &batch{records: make(map[string]record), ops: make(map[string]int64), other: make(map[string]record)}
+}
+
+func (b *batch) empty() bool {
+	return len(b.records) == 0 && len(b.retired) == 0 && len(b.other) == 0 && !b.reset
+}
+
+// set encodes v under key, or deletes key when v is nil
+func (b *batch) set(key string, v interface{}, expireAt time.Time) error {
+	if v == nil {
+		b.records[key] = record{}
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encode %s: %w", key, err)
+	}
+	b.records[key] = record{data: data, expireAt: expireAt}
+	return nil
+}
+
+// addDelta adds the records of a delta (see database.Changes); nil values delete theirs
+func (b *batch) addDelta(delta database.Snapshot) error {
+	var err error
+	add := func(key string, v interface{}, isNil bool, expireAt time.Time) {
+		if err != nil {
+			return
+		}
+		if isNil {
+			v = nil
+		}
+		err = b.set(key, v, expireAt)
+	}
+	for id, u := range delta.Users {
+		add(key(recUser, id), u, u == nil, time.Time{})
+	}
+	for id, hash := range delta.Credentials {
+		add(key(recCredentials, id), hash, false, time.Time{})
+	}
+	for id, c := range delta.Conferences {
+		add(key(recConference, id), c, c == nil, time.Time{})
+		if c == nil {
+			add(key(recQueue, id), nil, true, time.Time{})
+			add(key(recRoom, id), nil, true, time.Time{})
+		}
+	}
+	for id, bk := range delta.Bookings {
+		add(key(recBooking, id), bk, bk == nil, time.Time{})
+	}
+	for id, r := range delta.Reservations {
+		if r == nil {
+			add(reservationKey(id), nil, true, time.Time{})
+		} else {
+			add(reservationKey(id), r, false, r.ExpiresAt.Add(ExpiryGrace))
+		}
+	}
+	for id, q := range delta.WaitQueues {
+		if _, deleted := b.records[key(recQueue, id)]; !deleted {
+			add(key(recQueue, id), q, len(q) == 0, time.Time{})
+		}
+	}
+	for id, line := range delta.WaitingRooms {
+		add(key(recRoom, id), line, line == nil, time.Time{})
+	}
+	for code, p := range delta.PromoCodes {
+		add(key(recPromo, code), p, p == nil, time.Time{})
+	}
+	for id, cart := range delta.Carts {
+		add(key(recCart, id), cart, cart == nil, time.Time{})
+	}
+	for id, in := range delta.CheckIns {
+		add(key(recCheckIn, id), in, in == nil, time.Time{})
+	}
+	for id, at := range delta.Expired {
+		add(key(recExpired, id), at, false, at.Add(database.ExpiredTombstoneTTL))
+	}
+	return err
+}
+
+// write queues the batch's commands on p
+func (b *batch) write(ctx context.Context, p redis.Pipeliner) {
+	for _, records := range []map[string]record{b.records, b.other} {
+		for k, r := range records {
+			switch {
+			case r.data == nil:
+				p.Del(ctx, k)
+			case r.expireAt.IsZero():
+				p.Set(ctx, k, r.data, 0)
+			default:
+				p.Set(ctx, k, r.data, 0)
+				p.PExpireAt(ctx, k, r.expireAt)
+			}
+		}
+	}
+	for _, r := range b.retired {
+		if data, err := json.Marshal(r); err == nil {
+			p.RPush(ctx, key(recHistory, r.UserID), data)
+			p.LTrim(ctx, key(recHistory, r.UserID), -database.ReservationHistoryLimit, -1)
+		}
+	}
+	for op, n := range b.ops {
+		p.HIncrBy(ctx, metricsKey, op, n)
+	}
+}
+
+// entry is the batch's change stream entry
+func (b *batch) entry(instance string) (map[string]interface{}, error) {
+	keys := make([]string, 0, len(b.records))
+	for k := range b.records {
+		keys = append(keys, k)
+	}
+	encoded := make(map[string]interface{}, 5)
+	encoded["instance"] = instance
+	for field, v := range map[string]interface{}{"keys": keys, "retired": b.retired, "events": b.events} {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("encode change %s: %w", field, err)
+		}
+		encoded[field] = data
+	}
+	if b.reset {
+		encoded["reset"] = "1"
+	}
+	return encoded, nil
+}
+
+// publishLocked publishes the local changes since the last publish, along with the waiting
+// room line and Idempotency-Key sc names; caller must hold s.mu and sc's locks
+func (s *Shared) publishLocked(ctx context.Context, sc scope, locks []string, token string) error {
+	changes, ok := s.local.ChangesSince(s.cursor)
+	if !ok {
+		return errors.New("local changes no longer in the audit log")
+	}
+	b := newBatch()
+	if err := b.addDelta(changes.Delta); err != nil {
+		return err
+	}
+	b.retired = changes.Retired
+	for _, e := range changes.Entries {
+		b.ops[e.Op]++
+		if ev, ok := database.EventFor(e); ok {
+			b.events = append(b.events, ev)
+		}
+	}
+	if sc.room != "" {
+		if line := s.local.WaitingRoomLine(sc.room); line == nil {
+			b.records[key(recRoom, sc.room)] = record{}
+		} else if err := b.set(key(recRoom, sc.room), line, time.Time{}); err != nil {
+			return err
+		}
+	}
+	if k := sc.idempotency; k != nil {
+		if rec, ok := s.local.IdempotencyRecord(k.userID, k.key); ok {
+			data, err := json.Marshal(rec)
+			if err != nil {
+				return fmt.Errorf("encode idempotency key: %w", err)
+			}
+			b.other[idempotencyRecordKey(*k)] = record{data: data, expireAt: rec.CreatedAt.Add(database.IdempotencyKeyTTL)}
+		}
+	}
+	if b.empty() {
+		s.cursor = changes.Next
+		return nil
+	}
+	if err := s.commit(ctx, b, locks, token); err != nil {
+		return err
+	}
+	s.cursor = changes.Next
+	return nil
+}
+
+// publishAllLocked replaces the shared state with the local one; caller must hold s.mu and
+// the lock on the whole state
+func (s *Shared) publishAllLocked(ctx context.Context, locks []string, token string) error {
+	next := s.local.Cursor()
+	b, err := s.resetBatch(ctx)
+	if err != nil {
+		return err
+	}
+	if err := s.commit(ctx, b, locks, token); err != nil {
+		return err
+	}
+	s.cursor = next
+	s.loaded = true
+	return nil
+}
+
+// resetBatch writes the local state in place of every shared record
+func (s *Shared) resetBatch(ctx context.Context) (*batch, error) {
+	b := newBatch()
+	b.reset = true
+	for _, kind := range append(recordKinds, recHistory, recIdempotency) {
+		keys, err := scanKeys(ctx, s.rdb, key(kind, "*"))
+		if err != nil {
+			return nil, fmt.Errorf("list %s keys: %w", kind, err)
+		}
+		for _, k := range keys {
+			b.other[k] = record{}
+		}
+	}
+	if err := b.addDelta(s.local.Snapshot()); err != nil {
+		return nil, err
+	}
+	for k := range b.records {
+		delete(b.other, k)
+	}
+	return b, nil
+}
+
+// commit writes b, bumps the change number and appends b's entry in one transaction, provided
+// every one of locks still holds token
+func (s *Shared) commit(ctx context.Context, b *batch, locks []string, token string) error {
+	values, err := b.entry(s.id)
+	if err != nil {
+		return err
+	}
+	for {
+		err := s.rdb.Watch(ctx, func(tx *redis.Tx) error {
+			if len(locks) > 0 {
+				held, err := tx.MGet(ctx, locks...).Result()
+				if err != nil {
+					return err
+				}
+				for _, v := range held {
+					if v != token {
+						return errLockLost
+					}
+				}
+			}
+			seq, err := tx.Get(ctx, seqKey).Int64()
+			switch {
+			case errors.Is(err, redis.Nil):
+				seq = 0
+			case err != nil:
+				return err
+			case b.seed:
+				return errSeeded
+			}
+			_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+				b.write(ctx, p)
+				p.Set(ctx, seqKey, seq+1, 0)
+				p.XAdd(ctx, &redis.XAddArgs{
+					Stream: changesKey, MaxLen: ChangeLogLength, Approx: true,
+					ID: changeID(seq + 1), Values: values,
+				})
+				return nil
+			})
+			return err
+		}, append([]string{seqKey}, locks...)...)
+		if !errors.Is(err, redis.TxFailedErr) {
+			if err != nil && !errors.Is(err, errSeeded) {
+				return fmt.Errorf("publish: %w", err)
+			}
+			return err
+		}
+		// another instance published meanwhile; our locks may still be fine
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("publish: %w", err)
+		}
+	}
+}
+
+// changeID is the stream ID of change seq
+func changeID(seq int64) string {
+	return strconv.FormatInt(seq, 10) + "-0"
+}
+
+// syncLocked takes in the changes other instances published since the last look, or loads the
+// whole shared state when it can't (first call, fell behind, reset, failed publish); caller
+// must hold s.mu
+func (s *Shared) syncLocked(ctx context.Context) error {
+	if !s.loaded {
+		return s.loadLocked(ctx)
+	}
+	var head *redis.StringCmd
+	var entries *redis.XMessageSliceCmd
+	_, err := s.rdb.Pipelined(ctx, func(p redis.Pipeliner) error {
+		head = p.Get(ctx, seqKey)
+		entries = p.XRange(ctx, changesKey, changeID(s.seq+1), "+")
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return fmt.Errorf("read changes: %w", err)
+	}
+	last, err := head.Int64()
+	if errors.Is(err, redis.Nil) {
+		last, err = 0, nil
+	}
+	if err != nil {
+		return fmt.Errorf("read changes: %w", err)
+	}
+	msgs := entries.Val()
+	switch {
+	case last < s.seq: // Redis lost the state
+		return s.loadLocked(ctx)
+	case last == s.seq && len(msgs) == 0:
+		return nil
+	case len(msgs) == 0 || msgs[0].ID != changeID(s.seq+1): // trimmed past us
+		return s.loadLocked(ctx)
+	}
+
+	var keys []string
+	var retired []*models.SeatReservation
+	var events []database.Event
+	seen := make(map[string]bool)
+	for _, m := range msgs {
+		if m.Values["instance"] == s.id {
+			continue
+		}
+		if m.Values["reset"] == "1" {
+			return s.loadLocked(ctx)
+		}
+		var c struct {
+			Keys    []string                  `json:"keys"`
+			Retired []*models.SeatReservation `json:"retired"`
+			Events  []database.Event          `json:"events"`
+		}
+		for field, v := range map[string]interface{}{"keys": &c.Keys, "retired": &c.Retired, "events": &c.Events} {
+			raw, _ := m.Values[field].(string)
+			if err := json.Unmarshal([]byte(raw), v); err != nil {
+				return fmt.Errorf("decode change %s: %w", m.ID, err)
+			}
+		}
+		for _, k := range c.Keys {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+		retired = append(retired, c.Retired...)
+		events = append(events, c.Events...)
+	}
+	if len(keys) > 0 || len(retired) > 0 {
+		delta, err := fetch(ctx, s.rdb, keys, true)
+		if err != nil {
+			return err
+		}
+		s.local.Apply(delta, retired)
+	}
+	seq, err := strconv.ParseInt(strings.TrimSuffix(msgs[len(msgs)-1].ID, "-0"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("read changes: bad entry ID %s", msgs[len(msgs)-1].ID)
+	}
+	s.seq = seq
+	s.events.publish(events)
+	return nil
+}
+
+// loadLocked replaces the local state with the shared one, or seeds the shared state with
+// the local one when there is none yet; caller must hold s.mu
+func (s *Shared) loadLocked(ctx context.Context) error {
+	seq, err := s.rdb.Get(ctx, seqKey).Int64()
+	if errors.Is(err, redis.Nil) {
+		return s.seedLocked(ctx)
+	}
+	if err != nil {
+		return fmt.Errorf("read changes: %w", err)
+	}
+
+	var keys []string
+	for _, kind := range recordKinds {
+		found, err := scanKeys(ctx, s.rdb, key(kind, "*"))
+		if err != nil {
+			return fmt.Errorf("list %s keys: %w", kind, err)
+		}
+		keys = append(keys, found...)
+	}
+	snap, err := fetch(ctx, s.rdb, keys, false)
+	if err != nil {
+		return err
+	}
+	retired, err := loadHistory(ctx, s.rdb)
+	if err != nil {
+		return err
+	}
+	s.local.Adopt(snap)
+	s.local.Apply(database.Snapshot{}, retired)
+	s.seq, s.cursor, s.loaded = seq, s.local.Cursor(), true
+	return nil
+}
+
+// seedLocked publishes the local state as the shared one, unless another instance got there
+// first; caller must hold s.mu. It takes no locks: the commit only goes through while there is
+// no shared state, so it can't overwrite another instance's.
+func (s *Shared) seedLocked(ctx context.Context) error {
+	next := s.local.Cursor()
+	b, err := s.resetBatch(ctx)
+	if err != nil {
+		return err
+	}
+	b.seed = true
+	b.other[changesKey] = record{} // left over from a state that was lost; numbering starts again
+	switch err := s.commit(ctx, b, nil, ""); {
+	case errors.Is(err, errSeeded):
+		return s.loadLocked(ctx)
+	case err != nil:
+		return err
+	}
+	s.seq, s.cursor, s.loaded = 0, next, true
+	return nil
+}
+
+// fetch reads the records under keys into a snapshot. With removed set, a key that is gone
+// is a record since removed (a nil value, see database.Database.Apply); otherwise it is left
+// out.
+func fetch(ctx context.Context, rdb *redis.Client, keys []string, removed bool) (database.Snapshot, error) {
+	snap := database.Snapshot{
+		Users:        make(map[string]*models.User),
+		Conferences:  make(map[string]*models.Conference),
+		Bookings:     make(map[string]*models.Booking),
+		Reservations: make(map[string]*models.SeatReservation),
+		WaitQueues:   make(map[string][]*database.WaitEntry),
+		WaitingRooms: make(map[string]*database.WaitingRoomLine),
+		PromoCodes:   make(map[string]*models.PromoCode),
+		Carts:        make(map[string]*models.Cart),
+		CheckIns:     make(map[string]*models.CheckIn),
+		Expired:      make(map[string]time.Time),
+		Credentials:  make(map[string]string),
+	}
+	const chunk = 500
+	for start := 0; start < len(keys); start += chunk {
+		part := keys[start:min(start+chunk, len(keys))]
+		values, err := rdb.MGet(ctx, part...).Result()
+		if err != nil {
+			return snap, fmt.Errorf("read records: %w", err)
+		}
+		for i, v := range values {
+			raw, ok := v.(string)
+			if !ok && !removed {
+				continue
+			}
+			if err := decode(&snap, part[i], raw, ok); err != nil {
+				return snap, err
+			}
+		}
+	}
+	return snap, nil
+}
+
+// decode files the record under k into snap; present false files it as removed
+func decode(snap *database.Snapshot, k, raw string, present bool) error {
+	kind, id, _ := strings.Cut(strings.TrimPrefix(k, KeyPrefix), ":")
+	into := func(v interface{}) error {
+		if !present {
+			return nil
+		}
+		if err := json.Unmarshal([]byte(raw), v); err != nil {
+			return fmt.Errorf("decode %s: %w", k, err)
+		}
+		return nil
+	}
+	var err error
+	switch kind {
+	case recUser:
+		var u *models.User
+		err = into(&u)
+		snap.Users[id] = u
+	case recCredentials:
+		if present {
+			var hash string
+			err = into(&hash)
+			snap.Credentials[id] = hash
+		}
+	case recConference:
+		var c *models.Conference
+		err = into(&c)
+		snap.Conferences[id] = c
+	case recBooking:
+		var b *models.Booking
+		err = into(&b)
+		snap.Bookings[id] = b
+	case recReservation:
+		var r *models.SeatReservation
+		err = into(&r)
+		snap.Reservations[id] = r
+	case recQueue:
+		var q []*database.WaitEntry
+		err = into(&q)
+		snap.WaitQueues[id] = q
+	case recRoom:
+		var line *database.WaitingRoomLine
+		err = into(&line)
+		snap.WaitingRooms[id] = line
+	case recPromo:
+		var p *models.PromoCode
+		err = into(&p)
+		snap.PromoCodes[id] = p
+	case recCart:
+		var c *models.Cart
+		err = into(&c)
+		snap.Carts[id] = c
+	case recCheckIn:
+		var in *models.CheckIn
+		err = into(&in)
+		snap.CheckIns[id] = in
+	case recExpired:
+		if present { // a lapsed tombstone is simply gone
+			var at time.Time
+			err = into(&at)
+			snap.Expired[id] = at
+		}
+	}
+	return err
+}
+
+// loadHistory reads every user's finished reservations
+func loadHistory(ctx context.Context, rdb *redis.Client) ([]*models.SeatReservation, error) {
+	keys, err := scanKeys(ctx, rdb, key(recHistory, "*"))
+	if err != nil {
+		return nil, fmt.Errorf("list history keys: %w", err)
+	}
+	lists := make([]*redis.StringSliceCmd, len(keys))
+	if _, err := rdb.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, k := range keys {
+			lists[i] = p.LRange(ctx, k, 0, -1)
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("read history: %w", err)
+	}
+	var retired []*models.SeatReservation
+	for i, list := range lists {
+		for _, raw := range list.Val() {
+			r := &models.SeatReservation{}
+			if err := json.Unmarshal([]byte(raw), r); err != nil {
+				return nil, fmt.Errorf("decode %s: %w", keys[i], err)
+			}
+			retired = append(retired, r)
+		}
+	}
+	return retired, nil
+}
+
+func idempotencyRecordKey(k idempotencyKey) string {
+	return key(recIdempotency, k.userID, k.key)
+}
+
+// recallLocked lets the local state know what the shared Idempotency-Key k produced, if it
+// was used on any instance; caller must hold s.mu and k's lock
+func (s *Shared) recallLocked(ctx context.Context, k idempotencyKey) error {
+	data, err := s.rdb.Get(ctx, idempotencyRecordKey(k)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read idempotency key: %w", err)
+	}
+	var rec database.IdempotencyRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return fmt.Errorf("decode idempotency key: %w", err)
+	}
+	s.local.RememberIdempotency(k.userID, k.key, rec)
+	return nil
+}
@@ -0,0 +1,141 @@
+package redisstore
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"booking-system/database"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// eventHub fans the events of every instance out to this instance's subscribers
+type eventHub struct {
+	mu     sync.Mutex
+	subs   map[chan database.Event]struct{}
+	closed bool
+}
+
+func (h *eventHub) subscribe() (<-chan database.Event, func()) {
+	ch := make(chan database.Event, database.EventBuffer)
+	h.mu.Lock()
+	if h.closed {
+		close(ch)
+		h.mu.Unlock()
+		return ch, func() {}
+	}
+	if h.subs == nil {
+		h.subs = make(map[chan database.Event]struct{})
+	}
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			if _, ok := h.subs[ch]; ok {
+				delete(h.subs, ch)
+				close(ch)
+			}
+			h.mu.Unlock()
+		})
+	}
+}
+
+func (h *eventHub) publish(events []database.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, ev := range events {
+		for ch := range h.subs {
+			select {
+			case ch <- ev:
+			default: // slow subscriber; drop rather than stall the follower
+			}
+		}
+	}
+}
+
+// close closes every subscriber's channel
+func (h *eventHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		close(ch)
+	}
+	h.subs = nil
+	h.closed = true
+}
+
+// Subscribe streams the events of every instance sharing the state: this instance's as they
+// happen, the others' once this instance takes their changes in (within followBlock). Each
+// event is delivered once per subscriber, so a consumer that acts on events (sends mail, calls
+// webhooks) should subscribe to one instance's local database instead, or every instance would
+// act on every event.
+func (s *Shared) Subscribe() (<-chan database.Event, func()) {
+	return s.events.subscribe()
+}
+
+// forward passes this instance's own events on to the subscribers until Close
+func (s *Shared) forward(own <-chan database.Event, unsubscribe func()) {
+	defer s.workers.Done()
+	defer unsubscribe()
+	for {
+		select {
+		case ev := <-own:
+			s.events.publish([]database.Event{ev})
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// follow takes in other instances' changes as they are published, so reads and events don't
+// wait for this instance's next call, until Close
+func (s *Shared) follow() {
+	defer s.workers.Done()
+	for {
+		s.mu.Lock()
+		from := changeID(s.seq)
+		s.mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), followBlock+OpTimeout)
+		_, err := s.rdb.XRead(ctx, &redis.XReadArgs{
+			Streams: []string{changesKey, from},
+			Count:   1,
+			Block:   followBlock,
+		}).Result()
+		cancel()
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+		switch {
+		case err == nil:
+			s.refresh()
+		case errors.Is(err, redis.Nil): // nothing new
+		default:
+			slog.Warn("Failed to follow shared changes", "error", err)
+			select {
+			case <-s.done:
+				return
+			case <-time.After(followBlock):
+			}
+		}
+	}
+}
+
+// refresh takes in other instances' changes
+func (s *Shared) refresh() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), OpTimeout)
+	defer cancel()
+	if err := s.syncLocked(ctx); err != nil {
+		slog.Warn("Failed to take in shared changes", "error", err)
+	}
+}
@@ -0,0 +1,334 @@
+// Package redisstore lets several server instances behind a load balancer share one booking
+// state through Redis, so a hold taken on one instance blocks those seats on every other and
+// the wait queue has a single order.
+//
+// Each instance keeps running the in-memory database.Database (its locking, holds, queue and
+// booking rules are unchanged) on its copy of the shared state. Shared wraps it as a
+// database.Store and runs every write under Redis locks on just the records the write may
+// touch: the conferences, users, promo codes and carts involved, each lock its own key with a
+// TTL, so writes to different conferences go ahead side by side. A write first takes in what
+// other instances changed, then runs locally and publishes the records it changed, one key
+// each, together with an entry in a change stream (see changes.go) that the other instances
+// follow. A reservation's key expires, after a grace period, with its hold.
+//
+// Lapsed holds are expired by one elected instance, which sweeps their conferences one at a
+// time under the conference's lock (see sweep.go); a write that holds a conference expires its
+// lapsed holds first too. Each expiry so happens once, and its event is published once.
+// Subscribe streams the events of every instance, and the metrics counters and idempotency
+// keys are kept in Redis; the audit log stays per instance.
+package redisstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"booking-system/database"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Timing for the shared locks and the operations run under them
+const (
+	// OpTimeout bounds one call, including waiting for its locks
+	OpTimeout = 5 * time.Second
+	// LockTTL releases a lock if its holder dies mid-call; a publish after it lapsed is refused
+	LockTTL = 5 * time.Second
+	// ExpiryGrace keeps a reservation key past the hold's expiry so the sweeping instance still
+	// expires it properly (tombstone, queue promotion) instead of losing it
+	ExpiryGrace = time.Minute
+	// LeaseTTL is how long the sweeping instance's lease lasts unless renewed; when it dies,
+	// another instance takes over within that time
+	LeaseTTL = 10 * time.Second
+
+	lockRetry   = 5 * time.Millisecond
+	followBlock = time.Second // longest a follower waits on the change stream before looking again
+)
+
+// KeyPrefix namespaces every key this package writes
+const KeyPrefix = "booking:"
+
+// errLockLost means a lock lapsed, and may have been taken by another instance, before the
+// write holding it published
+var errLockLost = errors.New("shared lock lost before publishing (lock expired?)")
+
+// acquireScript takes every lock in KEYS but the last, or none of them when one is already held
+// or the last one (the whole-state lock, see lockAll) is; ARGV is the token and the TTL in ms
+var acquireScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[#KEYS]) == 1 then return 0 end
+for i = 1, #KEYS - 1 do
+	if redis.call("EXISTS", KEYS[i]) == 1 then return 0 end
+end
+for i = 1, #KEYS - 1 do
+	redis.call("SET", KEYS[i], ARGV[1], "PX", ARGV[2])
+end
+return 1`)
+
+// releaseScript deletes the locks in KEYS that still hold the token in ARGV[1]
+var releaseScript = redis.NewScript(`
+for i = 1, #KEYS do
+	if redis.call("GET", KEYS[i]) == ARGV[1] then redis.call("DEL", KEYS[i]) end
+end
+return 0`)
+
+// Shared is a database.Store whose state is shared with other instances through Redis
+type Shared struct {
+	local *database.Database
+	rdb   *redis.Client
+	id    string // names this instance's entries in the change stream
+
+	mu     sync.Mutex            // one call at a time per instance, so each publish holds exactly what it did
+	loaded bool                  // the local state is a copy of the shared one; false reloads it
+	seq    int64                 // the last shared change taken in
+	cursor database.ChangeCursor // the local changes already published
+
+	events     eventHub
+	sweepEvery chan time.Duration
+	done       chan struct{}
+	workers    sync.WaitGroup
+	closeOnce  sync.Once
+}
+
+var _ database.Store = (*Shared)(nil)
+
+// Open connects to the Redis server at url (redis://[:password@]host:port/db) and joins the
+// shared state: the first instance seeds it with local's data, later ones take in what is there
+func Open(ctx context.Context, url string, local *database.Database) (*Shared, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("parse redis url: %w", err)
+	}
+	rdb := redis.NewClient(opts)
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		rdb.Close()
+		return nil, fmt.Errorf("connect to redis: %w", err)
+	}
+	s := New(rdb, local)
+	s.mu.Lock()
+	err = s.syncLocked(ctx)
+	s.mu.Unlock()
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("join shared state: %w", err)
+	}
+	return s, nil
+}
+
+// New wraps local and a connected client; the shared state is taken in on the first call. It
+// pauses local's janitor and turns on shared expiry (see database.Database.SetSharedExpiry),
+// leaving lapsed holds to the elected sweeper, and starts following the other instances.
+func New(rdb *redis.Client, local *database.Database) *Shared {
+	s := &Shared{
+		local:      local,
+		rdb:        rdb,
+		id:         uuid.NewString(),
+		sweepEvery: make(chan time.Duration),
+		done:       make(chan struct{}),
+	}
+	local.SetJanitorInterval(0)
+	local.SetSharedExpiry(true)
+
+	own, unsubscribe := local.Subscribe()
+	s.workers.Add(3)
+	go s.forward(own, unsubscribe)
+	go s.follow()
+	go s.runSweeper(database.JanitorInterval)
+	return s
+}
+
+// Close stops following the other instances, hands the sweeper lease on, stops the local
+// database's background workers and disconnects from Redis
+func (s *Shared) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		if err := releaseScript.Run(ctx, s.rdb, []string{leaseKey}, s.id).Err(); err != nil {
+			slog.Warn("Failed to hand on the sweeper lease", "expires_in", LeaseTTL.String(), "error", err)
+		}
+		cancel()
+		s.rdb.Close() // wakes the follower from its blocking read
+		s.workers.Wait()
+		s.local.Close()
+		s.events.close()
+	})
+}
+
+func key(parts ...string) string {
+	return KeyPrefix + strings.Join(parts, ":")
+}
+
+var (
+	seqKey     = key("seq")     // the number of the last change published
+	changesKey = key("changes") // the change stream; see changes.go
+	metricsKey = key("metrics") // audited operation -> count, for GetMetrics
+	leaseKey   = key("sweeper") // the elected sweeper's ID; see sweep.go
+	allLockKey = key("lock", "all")
+)
+
+// lockKey is the key of the lock named name (such as "conference:<id>"; see scope)
+func lockKey(name string) string { return key("lock", name) }
+
+// unavailable marks err as a failure to reach the shared state
+func unavailable(err error) error {
+	return fmt.Errorf("%w: %w", database.ErrUnavailable, err)
+}
+
+// do runs fn against the shared state under the locks scopeOf names: it locks them, takes in
+// other instances' changes, expires the lapsed holds of the locked conferences, runs fn and
+// publishes what changed. scopeOf looks at the local state, so it is asked again once that is
+// caught up and the locks are taken again if they no longer cover the call. An error means
+// fn's changes (if it ran) were not published and have been discarded.
+func (s *Shared) do(scopeOf func() scope, fn func()) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), OpTimeout)
+	defer cancel()
+
+	sc := scopeOf().and(kindInstance, s.id)
+	var locks []string
+	var token string
+	for {
+		var err error
+		locks, token, err = s.lock(ctx, sc)
+		if err != nil {
+			return unavailable(err)
+		}
+		if err := s.syncLocked(ctx); err != nil {
+			s.unlock(locks, token)
+			return unavailable(err)
+		}
+		wider := sc.union(scopeOf())
+		if sc.covers(wider) {
+			break
+		}
+		s.unlock(locks, token)
+		sc = wider
+	}
+	defer s.unlock(locks, token)
+
+	if err := s.runLocked(ctx, sc, locks, token, fn); err != nil {
+		s.loaded = false // reload on the next call, dropping what fn did
+		return unavailable(err)
+	}
+	return nil
+}
+
+// runLocked runs fn and publishes its changes; caller must hold s.mu and sc's locks
+func (s *Shared) runLocked(ctx context.Context, sc scope, locks []string, token string, fn func()) error {
+	if sc.all {
+		fn()
+		return s.publishAllLocked(ctx, locks, token)
+	}
+	for _, id := range sc.ids(kindConference) {
+		s.local.SweepConference(id)
+	}
+	if sc.idempotency != nil {
+		if err := s.recallLocked(ctx, *sc.idempotency); err != nil {
+			return err
+		}
+	}
+	fn()
+	return s.publishLocked(ctx, sc, locks, token)
+}
+
+// view runs a read-only fn once the local state has taken in other instances' changes, or
+// on this instance's last copy when Redis can't be reached
+func (s *Shared) view(fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), OpTimeout)
+	defer cancel()
+	if err := s.syncLocked(ctx); err != nil {
+		slog.Warn("Shared state unavailable, answering from local copy", "error", err)
+	}
+	fn()
+}
+
+// lock takes sc's locks, waiting while any of them is held elsewhere. All or none are taken
+// at a time, so two calls can't each hold a lock the other waits for.
+func (s *Shared) lock(ctx context.Context, sc scope) (locks []string, token string, err error) {
+	token = uuid.NewString()
+	if sc.all {
+		return []string{allLockKey}, token, s.lockAll(ctx, token)
+	}
+	locks = make([]string, len(sc.locks))
+	for i, name := range sc.locks {
+		locks[i] = lockKey(name)
+	}
+	for {
+		ok, err := acquireScript.Run(ctx, s.rdb, append(locks[:len(locks):len(locks)], allLockKey), token, LockTTL.Milliseconds()).Bool()
+		if err != nil {
+			return nil, "", fmt.Errorf("take locks: %w", err)
+		}
+		if ok {
+			return locks, token, nil
+		}
+		if err := pause(ctx, lockRetry); err != nil {
+			return nil, "", fmt.Errorf("take locks: %w", err)
+		}
+	}
+}
+
+// lockAll takes the lock on the whole state, which keeps new calls from taking theirs, and
+// waits for the calls holding locks to finish
+func (s *Shared) lockAll(ctx context.Context, token string) error {
+	for {
+		ok, err := s.rdb.SetNX(ctx, allLockKey, token, LockTTL).Result()
+		if err != nil {
+			return fmt.Errorf("take lock: %w", err)
+		}
+		if ok {
+			break
+		}
+		if err := pause(ctx, lockRetry); err != nil {
+			return fmt.Errorf("take lock: %w", err)
+		}
+	}
+	for {
+		held, err := scanKeys(ctx, s.rdb, lockKey("*"))
+		if err != nil {
+			s.unlock([]string{allLockKey}, token)
+			return fmt.Errorf("wait for locks: %w", err)
+		}
+		if len(held) == 1 && held[0] == allLockKey || len(held) == 0 {
+			return nil
+		}
+		if err := pause(ctx, lockRetry); err != nil {
+			s.unlock([]string{allLockKey}, token)
+			return fmt.Errorf("wait for locks: %w", err)
+		}
+	}
+}
+
+func (s *Shared) unlock(locks []string, token string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := releaseScript.Run(ctx, s.rdb, locks, token).Err(); err != nil {
+		slog.Warn("Failed to release shared locks", "locks", locks, "expires_in", LockTTL.String(), "error", err)
+	}
+}
+
+// pause waits d, or fails once ctx is done
+func pause(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
+// scanKeys lists the keys matching pattern
+func scanKeys(ctx context.Context, rdb *redis.Client, pattern string) ([]string, error) {
+	var keys []string
+	iter := rdb.Scan(ctx, 0, pattern, 1000).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	return keys, iter.Err()
+}
@@ -0,0 +1,224 @@
+package redisstore
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"booking-system/database"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// newInstances starts n server instances sharing one in-process Redis
+func newInstances(t *testing.T, n int) (*miniredis.Miniredis, []*Shared) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	instances := make([]*Shared, n)
+	for i := range instances {
+		s := New(redis.NewClient(&redis.Options{Addr: mr.Addr()}), database.NewDatabase())
+		t.Cleanup(s.Close)
+		instances[i] = s
+	}
+	return mr, instances
+}
+
+func TestHoldsAndQueueAreSharedAcrossInstances(t *testing.T) {
+	mr, inst := newInstances(t, 2)
+	a, b := inst[0], inst[1]
+
+	alice, err := a.CreateUser("Alice", "alice@example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	bob, err := b.CreateUser("Bob", "bob@example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := b.GetUser(alice.ID); err != nil {
+		t.Fatalf("expected a user created on one instance to be visible on the other, got %v", err)
+	}
+	if _, err := a.CreateUser("Alice again", "alice@example.com"); !errors.Is(err, database.ErrConflict) {
+		t.Fatalf("expected the duplicate email to be caught across instances, got %v", err)
+	}
+
+	conf, err := a.CreateConference("Tiny", "Remote", 2, 10, time.Now().Add(24*time.Hour), "", database.ConferenceOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	res, err := a.CreateReservation(alice.ID, conf.ID, 2, database.ReservationOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := b.CreateReservation(bob.ID, conf.ID, 1, database.ReservationOptions{}); err == nil {
+		t.Fatalf("expected seats held on one instance to be unavailable on the other")
+	}
+	if ttl := mr.TTL(reservationKey(res.ID)); ttl <= 0 || ttl > time.Until(res.ExpiresAt)+ExpiryGrace+time.Second {
+		t.Fatalf("expected the reservation key to expire with the hold, got TTL %s", ttl)
+	}
+
+	if _, err := b.EnqueueWait(bob.ID, conf.ID, 1, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pos := a.GetQueuePosition(bob.ID, conf.ID).Position; pos != 1 {
+		t.Fatalf("expected the queue entry to be visible on the other instance, got position %d", pos)
+	}
+
+	// confirming on the other instance books the seats everywhere
+	if _, err := b.ConfirmReservation(res.ID, database.BookingOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, _ := a.GetConference(conf.ID); got.AvailableTickets != 0 {
+		t.Fatalf("expected no seats left, got %d", got.AvailableTickets)
+	}
+	if mr.Exists(reservationKey(res.ID)) {
+		t.Fatalf("expected the confirmed reservation's key to be removed")
+	}
+}
+
+func TestUnreachableRedisFailsWritesAndServesReadsLocally(t *testing.T) {
+	mr, inst := newInstances(t, 1)
+	s := inst[0]
+	if _, err := s.CreateUser("Alice", "alice@example.com"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mr.Close()
+	if _, err := s.CreateUser("Bob", "bob@example.com"); !errors.Is(err, database.ErrUnavailable) {
+		t.Fatalf("expected ErrUnavailable, got %v", err)
+	}
+	if len(s.GetAllConferences()) == 0 {
+		t.Fatalf("expected reads to fall back to the local copy")
+	}
+}
+
+func TestPublishRefusesOnceALockWasLost(t *testing.T) {
+	mr, inst := newInstances(t, 1)
+	s := inst[0]
+	conf := s.GetAllConferences()[0]
+
+	// the lock lapsed mid-call and another instance took it
+	name := "Renamed"
+	err := s.do(fixed(on(kindConference, conf.ID)), func() {
+		s.local.UpdateConference(conf.ID, database.ConferenceUpdate{Name: &name})
+		mr.Set(lockKey(kindConference+":"+conf.ID), "someone else")
+	})
+	if !errors.Is(err, database.ErrUnavailable) || !errors.Is(err, errLockLost) {
+		t.Fatalf("expected ErrUnavailable from a lost lock, got %v", err)
+	}
+	mr.Del(lockKey(kindConference + ":" + conf.ID))
+	if got, _ := s.GetConference(conf.ID); got.Name == "Renamed" {
+		t.Fatalf("expected the unpublished change to be dropped")
+	}
+}
+
+func TestWritesToOtherConferencesGoAheadWhileOneIsLocked(t *testing.T) {
+	mr, inst := newInstances(t, 2)
+	a, b := inst[0], inst[1]
+	confs := a.GetAllConferences()
+	busy, free := confs[0], confs[1]
+	alice, err := a.CreateUser("Alice", "alice@example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// another instance is in the middle of a call on busy
+	mr.Set(lockKey(kindConference+":"+busy.ID), "someone else")
+	if _, err := b.CreateReservation(alice.ID, free.ID, 1, database.ReservationOptions{}); err != nil {
+		t.Fatalf("expected a hold on another conference to go ahead, got %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := b.CreateReservation(alice.ID, busy.ID, 1, database.ReservationOptions{})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("expected the hold on the locked conference to wait, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	mr.Del(lockKey(kindConference + ":" + busy.ID))
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestLapsedHoldExpiresOnceAcrossInstances(t *testing.T) {
+	_, inst := newInstances(t, 2)
+	a, b := inst[0], inst[1]
+	var offset atomic.Int64
+	for _, s := range inst {
+		s.SetSweepInterval(0)
+		s.local.SetClock(func() time.Time { return time.Now().Add(time.Duration(offset.Load())) })
+	}
+	alice, err := a.CreateUser("Alice", "alice@example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conf := a.GetAllConferences()[0]
+	res, err := a.CreateReservation(alice.ID, conf.ID, 1, database.ReservationOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	eventsA, stopA := a.Subscribe()
+	defer stopA()
+	eventsB, stopB := b.Subscribe()
+	defer stopB()
+
+	offset.Store(int64(time.Until(res.ExpiresAt) + time.Second))
+	a.sweep()
+	b.sweep() // not the sweeper: a holds the lease
+
+	for name, events := range map[string]<-chan database.Event{"a": eventsA, "b": eventsB} {
+		if n := countExpiries(events, res.ID); n != 1 {
+			t.Fatalf("expected instance %s to see the expiry once, got %d", name, n)
+		}
+	}
+	if n := b.GetMetrics().ReservationsExpired; n != 1 {
+		t.Fatalf("expected one expiry counted across instances, got %d", n)
+	}
+	if _, err := b.GetReservation(res.ID); err == nil {
+		t.Fatalf("expected the lapsed hold to be gone on every instance")
+	}
+}
+
+// countExpiries counts the expiry events for reservationID until the stream goes quiet
+func countExpiries(events <-chan database.Event, reservationID string) int {
+	n := 0
+	for {
+		select {
+		case ev := <-events:
+			if ev.Type == database.OpReservationExpire && ev.ReservationID == reservationID {
+				n++
+			}
+		case <-time.After(2 * followBlock):
+			return n
+		}
+	}
+}
+
+func TestIdempotencyKeysAreSharedAcrossInstances(t *testing.T) {
+	_, inst := newInstances(t, 2)
+	a, b := inst[0], inst[1]
+	alice, err := a.CreateUser("Alice", "alice@example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conf := a.GetAllConferences()[0]
+
+	first, replayed, err := a.CreateReservationIdempotent("key-1", alice.ID, conf.ID, 1, database.ReservationOptions{})
+	if err != nil || replayed {
+		t.Fatalf("unexpected result: replayed=%v err=%v", replayed, err)
+	}
+	// the client's retry lands on the other instance
+	again, replayed, err := b.CreateReservationIdempotent("key-1", alice.ID, conf.ID, 1, database.ReservationOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !replayed || again.ID != first.ID {
+		t.Fatalf("expected the retry to replay hold %s, got %s (replayed=%v)", first.ID, again.ID, replayed)
+	}
+}
@@ -0,0 +1,219 @@
+package redisstore
+
+import (
+	"sort"
+	"strings"
+)
+
+// Kinds of lock a write takes; see scope
+const (
+	kindConference  = "conference" // the conference, its wait queue and waiting room, its holds, bookings and check-ins
+	kindUser        = "user"       // the user's record and their caps across conferences
+	kindEmail       = "email"      // an address being registered, so two instances can't both take it
+	kindPromo       = "promo"
+	kindCart        = "cart"
+	kindIdempotency = "idempotency" // a user's Idempotency-Key
+	kindInstance    = "instance"    // held by every write, so lockAll waits for writes with nothing else to lock
+)
+
+// scope is what a write may touch, and so what it locks. Holding a conference's lock covers
+// every record filed under it, so a write only publishes records whose locks it holds and no
+// other instance publishes them meanwhile.
+type scope struct {
+	locks []string // lock names such as "conference:<id>", sorted
+	all   bool     // the write replaces the whole state; see Shared.lockAll
+
+	idempotency *idempotencyKey // shared Idempotency-Key the write may use or set
+	room        string          // conference whose waiting room line the write changes
+}
+
+// idempotencyKey is a user's Idempotency-Key
+type idempotencyKey struct{ userID, key string }
+
+// on is a scope locking ids of kind; empty ids are skipped
+func on(kind string, ids ...string) scope {
+	return scope{}.and(kind, ids...)
+}
+
+// everything is the scope of a write replacing the whole state
+func everything() scope {
+	return scope{all: true}
+}
+
+// and adds the locks on ids of kind to sc; empty ids are skipped
+func (sc scope) and(kind string, ids ...string) scope {
+	locks := append([]string(nil), sc.locks...)
+	for _, id := range ids {
+		if id == "" {
+			continue
+		}
+		name := kind + ":" + id
+		i := sort.SearchStrings(locks, name)
+		if i < len(locks) && locks[i] == name {
+			continue
+		}
+		locks = append(locks, "")
+		copy(locks[i+1:], locks[i:])
+		locks[i] = name
+	}
+	sc.locks = locks
+	return sc
+}
+
+// promo adds the lock on a promo code, named as database files it
+func (sc scope) promo(code string) scope {
+	return sc.and(kindPromo, strings.ToUpper(strings.TrimSpace(code)))
+}
+
+// email adds the lock on an address, named as database compares them
+func (sc scope) email(addresses ...string) scope {
+	for _, a := range addresses {
+		sc = sc.and(kindEmail, strings.ToLower(strings.TrimSpace(a)))
+	}
+	return sc
+}
+
+// idempotent adds the lock on userID's Idempotency-Key, when key is set
+func (sc scope) idempotent(userID, key string) scope {
+	if key == "" {
+		return sc
+	}
+	sc = sc.and(kindIdempotency, userID+":"+key)
+	sc.idempotency = &idempotencyKey{userID: userID, key: key}
+	return sc
+}
+
+// withRoom marks the write as changing a conference's waiting room line
+func (sc scope) withRoom(conferenceID string) scope {
+	sc.room = conferenceID
+	return sc
+}
+
+// union is what sc and other lock between them
+func (sc scope) union(other scope) scope {
+	for _, name := range other.locks {
+		kind, id, _ := strings.Cut(name, ":")
+		sc = sc.and(kind, id)
+	}
+	sc.all = sc.all || other.all
+	if sc.idempotency == nil {
+		sc.idempotency = other.idempotency
+	}
+	if sc.room == "" {
+		sc.room = other.room
+	}
+	return sc
+}
+
+// covers reports whether sc locks everything other does
+func (sc scope) covers(other scope) bool {
+	if sc.all {
+		return true
+	}
+	if other.all {
+		return false
+	}
+	for _, name := range other.locks {
+		i := sort.SearchStrings(sc.locks, name)
+		if i == len(sc.locks) || sc.locks[i] != name {
+			return false
+		}
+	}
+	return true
+}
+
+// ids lists the IDs of the locks of kind
+func (sc scope) ids(kind string) []string {
+	var ids []string
+	for _, name := range sc.locks {
+		if k, id, _ := strings.Cut(name, ":"); k == kind {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// The scopes of calls whose records have to be looked up first. The lookups read the local
+// copy, which may be behind; do asks again once it has caught up.
+
+// reservationScope covers a call on a hold: its user, conference and promo code
+func (s *Shared) reservationScope(reservationID string) func() scope {
+	return func() scope {
+		res, err := s.local.GetReservation(reservationID)
+		if err != nil {
+			return scope{}
+		}
+		return on(kindUser, res.UserID).and(kindConference, res.ConferenceID).promo(res.PromoCode)
+	}
+}
+
+// bookingScope covers a call on a booking: its user and conference, and those of the other
+// bookings paid through the same payment, which a cancellation refunds with it
+func (s *Shared) bookingScope(bookingID string) func() scope {
+	return func() scope {
+		booking := s.local.GetBooking(bookingID)
+		if booking == nil {
+			return scope{}
+		}
+		sc := on(kindUser, booking.UserID).and(kindConference, booking.ConferenceID)
+		if booking.PaymentID == "" {
+			return sc
+		}
+		bookings, _ := s.local.GetUserBookings(booking.UserID, 0, 0)
+		for _, b := range bookings {
+			if b.PaymentID == booking.PaymentID {
+				sc = sc.and(kindConference, b.ConferenceID)
+			}
+		}
+		return sc
+	}
+}
+
+// cartScope covers a call on a cart: the cart, its user and the conferences of its items and
+// holds
+func (s *Shared) cartScope(cartID string) func() scope {
+	return func() scope {
+		cart, err := s.local.GetCart(cartID)
+		if err != nil {
+			return scope{}
+		}
+		sc := on(kindCart, cart.ID).and(kindUser, cart.UserID)
+		for _, item := range cart.Items {
+			sc = sc.and(kindConference, item.ConferenceID)
+		}
+		for _, id := range cart.ReservationIDs {
+			sc = sc.union(s.reservationScope(id)())
+		}
+		return sc
+	}
+}
+
+// ticketScope covers checking a ticket in: its conference
+func (s *Shared) ticketScope(ticketID string) func() scope {
+	return func() scope {
+		ticket, err := s.local.GetTicket(ticketID)
+		if err != nil {
+			return scope{}
+		}
+		return on(kindConference, ticket.ConferenceID)
+	}
+}
+
+// paymentScope covers completing a provider payment: the cart or hold paying through it
+func (s *Shared) paymentScope(paymentID string) func() scope {
+	return func() scope {
+		cart, res := s.local.FindPayment(paymentID)
+		switch {
+		case cart != nil:
+			return s.cartScope(cart.ID)()
+		case res != nil:
+			return s.reservationScope(res.ID)()
+		}
+		return scope{}
+	}
+}
+
+// fixed is the scope func of a call whose scope is known up front
+func fixed(sc scope) func() scope {
+	return func() scope { return sc }
+}
@@ -0,0 +1,511 @@
+package redisstore
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"booking-system/database"
+	"booking-system/models"
+)
+
+// Each method runs the local Database's method through do (or view for reads), so the
+// booking rules stay in one place, under the locks of the records it may touch (see scope).
+// Calls that cannot report an error log it instead.
+
+func (s *Shared) CreateUser(name, email string) (user *models.User, err error) {
+	if lockErr := s.do(fixed(scope{}.email(email)), func() { user, err = s.local.CreateUser(name, email) }); lockErr != nil {
+		return nil, lockErr
+	}
+	return user, err
+}
+
+func (s *Shared) CreateUsers(inputs []database.UserInput) (results []database.UserResult) {
+	if err := s.do(fixed(scope{}.email(emails(inputs)...)), func() { results = s.local.CreateUsers(inputs) }); err != nil {
+		results = make([]database.UserResult, len(inputs))
+		for i := range inputs {
+			results[i] = database.UserResult{Index: i, Error: err.Error()}
+		}
+	}
+	return results
+}
+
+func (s *Shared) RegisterUser(name, email, password, role string) (user *models.User, err error) {
+	if lockErr := s.do(fixed(scope{}.email(email)), func() { user, err = s.local.RegisterUser(name, email, password, role) }); lockErr != nil {
+		return nil, lockErr
+	}
+	return user, err
+}
+
+// Authenticate picks up accounts registered on other instances, then checks the password
+// without holding the shared lock (bcrypt is slow on purpose)
+func (s *Shared) Authenticate(email, password string) (*models.User, error) {
+	s.view(func() {})
+	return s.local.Authenticate(email, password)
+}
+
+func (s *Shared) GetUser(userID string) (user *models.User, err error) {
+	s.view(func() { user, err = s.local.GetUser(userID) })
+	return user, err
+}
+
+func (s *Shared) GetUserByEmail(email string) (user *models.User, ok bool) {
+	s.view(func() { user, ok = s.local.GetUserByEmail(email) })
+	return user, ok
+}
+
+func (s *Shared) GetAllUsers() (users []*models.User) {
+	s.view(func() { users = s.local.GetAllUsers() })
+	return users
+}
+
+func (s *Shared) SetUserRole(userID, role string) (user *models.User, err error) {
+	if lockErr := s.do(fixed(on(kindUser, userID)), func() { user, err = s.local.SetUserRole(userID, role) }); lockErr != nil {
+		return nil, lockErr
+	}
+	return user, err
+}
+
+func (s *Shared) SetUserPriority(userID string, priority int) (user *models.User, err error) {
+	if lockErr := s.do(fixed(on(kindUser, userID)), func() { user, err = s.local.SetUserPriority(userID, priority) }); lockErr != nil {
+		return nil, lockErr
+	}
+	return user, err
+}
+
+func (s *Shared) GetAllowance(userID, conferenceID string) (allowance database.Allowance, err error) {
+	s.view(func() { allowance, err = s.local.GetAllowance(userID, conferenceID) })
+	return allowance, err
+}
+
+func (s *Shared) CreateConference(name, location string, totalTickets int, price float64, date time.Time, organizerID string, opts database.ConferenceOptions) (conf *models.Conference, err error) {
+	if lockErr := s.do(fixed(scope{}), func() {
+		conf, err = s.local.CreateConference(name, location, totalTickets, price, date, organizerID, opts)
+	}); lockErr != nil {
+		return nil, lockErr
+	}
+	return conf, err
+}
+
+func (s *Shared) UpdateConference(conferenceID string, update database.ConferenceUpdate) (conf *models.Conference, err error) {
+	if lockErr := s.do(fixed(on(kindConference, conferenceID)), func() { conf, err = s.local.UpdateConference(conferenceID, update) }); lockErr != nil {
+		return nil, lockErr
+	}
+	return conf, err
+}
+
+func (s *Shared) DeleteConference(conferenceID string) (err error) {
+	if lockErr := s.do(fixed(on(kindConference, conferenceID)), func() { err = s.local.DeleteConference(conferenceID) }); lockErr != nil {
+		return lockErr
+	}
+	return err
+}
+
+func (s *Shared) GetConference(conferenceID string) (conf *models.Conference, err error) {
+	s.view(func() { conf, err = s.local.GetConference(conferenceID) })
+	return conf, err
+}
+
+func (s *Shared) GetAllConferences() (confs []*models.Conference) {
+	s.view(func() { confs = s.local.GetAllConferences() })
+	return confs
+}
+
+func (s *Shared) QueryConferences(q database.ConferenceQuery) (confs []*models.Conference) {
+	s.view(func() { confs = s.local.QueryConferences(q) })
+	return confs
+}
+
+func (s *Shared) SearchConferences(query string) (matches []database.ConferenceMatch) {
+	s.view(func() { matches = s.local.SearchConferences(query) })
+	return matches
+}
+
+func (s *Shared) SeatMap(conferenceID string) (m *database.SeatMap, err error) {
+	s.view(func() { m, err = s.local.SeatMap(conferenceID) })
+	return m, err
+}
+
+func (s *Shared) GetConferenceStats() (stats map[string]database.ConferenceStats) {
+	s.view(func() { stats = s.local.GetConferenceStats() })
+	return stats
+}
+
+func (s *Shared) GetConferenceAnalytics() (analytics map[string]database.ConferenceAnalytics) {
+	s.view(func() { analytics = s.local.GetConferenceAnalytics() })
+	return analytics
+}
+
+func (s *Shared) ReleaseHoldback(conferenceID string, count int) (conf *models.Conference, err error) {
+	if lockErr := s.do(fixed(on(kindConference, conferenceID)), func() { conf, err = s.local.ReleaseHoldback(conferenceID, count) }); lockErr != nil {
+		return nil, lockErr
+	}
+	return conf, err
+}
+
+func (s *Shared) CreateBooking(userID, conferenceID string, ticketCount int, opts database.BookingOptions) (booking *models.Booking, err error) {
+	if lockErr := s.do(fixed(on(kindUser, userID).and(kindConference, conferenceID)), func() { booking, err = s.local.CreateBooking(userID, conferenceID, ticketCount, opts) }); lockErr != nil {
+		return nil, lockErr
+	}
+	return booking, err
+}
+
+func (s *Shared) CreateBookingIdempotent(key, userID, conferenceID string, ticketCount int, opts database.BookingOptions) (booking *models.Booking, replayed bool, err error) {
+	if lockErr := s.do(fixed(on(kindUser, userID).and(kindConference, conferenceID).idempotent(userID, key)), func() {
+		booking, replayed, err = s.local.CreateBookingIdempotent(key, userID, conferenceID, ticketCount, opts)
+	}); lockErr != nil {
+		return nil, false, lockErr
+	}
+	return booking, replayed, err
+}
+
+func (s *Shared) GetBooking(id string) (booking *models.Booking) {
+	s.view(func() { booking = s.local.GetBooking(id) })
+	return booking
+}
+
+func (s *Shared) BookingTickets(bookingID string) (tickets []models.Ticket, err error) {
+	s.view(func() { tickets, err = s.local.BookingTickets(bookingID) })
+	return tickets, err
+}
+
+func (s *Shared) GetTicket(id string) (ticket *models.Ticket, err error) {
+	s.view(func() { ticket, err = s.local.GetTicket(id) })
+	return ticket, err
+}
+
+func (s *Shared) CheckInTicket(ticketID string, opts database.CheckInOptions) (ticket *models.Ticket, err error) {
+	if lockErr := s.do(s.ticketScope(ticketID), func() { ticket, err = s.local.CheckInTicket(ticketID, opts) }); lockErr != nil {
+		return nil, lockErr
+	}
+	return ticket, err
+}
+
+func (s *Shared) CheckInStats(conferenceID string) (stats *database.CheckInStats, err error) {
+	s.view(func() { stats, err = s.local.CheckInStats(conferenceID) })
+	return stats, err
+}
+
+func (s *Shared) GetUserBookings(userID string, limit, offset int) (bookings []*models.Booking, total int) {
+	s.view(func() { bookings, total = s.local.GetUserBookings(userID, limit, offset) })
+	return bookings, total
+}
+
+func (s *Shared) GetAllBookings(filter database.BookingFilter, limit, offset int) (bookings []map[string]interface{}, total int) {
+	s.view(func() { bookings, total = s.local.GetAllBookings(filter, limit, offset) })
+	return bookings, total
+}
+
+func (s *Shared) CancelBooking(bookingID string) (booking *models.Booking, err error) {
+	if lockErr := s.do(s.bookingScope(bookingID), func() { booking, err = s.local.CancelBooking(bookingID) }); lockErr != nil {
+		return nil, lockErr
+	}
+	return booking, err
+}
+
+func (s *Shared) ExportBookings(conferenceID string) (rows [][]string) {
+	s.view(func() { rows = s.local.ExportBookings(conferenceID) })
+	return rows
+}
+
+func (s *Shared) CreateReservation(userID, conferenceID string, ticketCount int, opts database.ReservationOptions) (res *models.SeatReservation, err error) {
+	if lockErr := s.do(fixed(on(kindUser, userID).and(kindConference, conferenceID).promo(opts.PromoCode)), func() { res, err = s.local.CreateReservation(userID, conferenceID, ticketCount, opts) }); lockErr != nil {
+		return nil, lockErr
+	}
+	return res, err
+}
+
+func (s *Shared) CreateReservationIdempotent(key, userID, conferenceID string, ticketCount int, opts database.ReservationOptions) (res *models.SeatReservation, replayed bool, err error) {
+	if lockErr := s.do(fixed(on(kindUser, userID).and(kindConference, conferenceID).promo(opts.PromoCode).idempotent(userID, key)), func() {
+		res, replayed, err = s.local.CreateReservationIdempotent(key, userID, conferenceID, ticketCount, opts)
+	}); lockErr != nil {
+		return nil, false, lockErr
+	}
+	return res, replayed, err
+}
+
+func (s *Shared) CreateReservationBundle(userID string, items []database.ReservationItem, opts database.ReservationOptions) (res []*models.SeatReservation, err error) {
+	if lockErr := s.do(fixed(bundleScope(userID, items, opts)), func() { res, err = s.local.CreateReservationBundle(userID, items, opts) }); lockErr != nil {
+		return nil, lockErr
+	}
+	return res, err
+}
+
+func (s *Shared) GetReservation(reservationID string) (res *models.SeatReservation, err error) {
+	s.view(func() { res, err = s.local.GetReservation(reservationID) })
+	return res, err
+}
+
+func (s *Shared) GetUserReservations(userID string) (res []*models.SeatReservation) {
+	s.view(func() { res = s.local.GetUserReservations(userID) })
+	return res
+}
+
+func (s *Shared) GetUserReservationHistory(userID string) (res []*models.SeatReservation) {
+	s.view(func() { res = s.local.GetUserReservationHistory(userID) })
+	return res
+}
+
+func (s *Shared) ListReservations(filter database.ReservationFilter) (res []*models.SeatReservation) {
+	s.view(func() { res = s.local.ListReservations(filter) })
+	return res
+}
+
+func (s *Shared) ConfirmReservation(reservationID string, opts database.BookingOptions) (booking *models.Booking, err error) {
+	if lockErr := s.do(s.reservationScope(reservationID), func() { booking, err = s.local.ConfirmReservation(reservationID, opts) }); lockErr != nil {
+		return nil, lockErr
+	}
+	return booking, err
+}
+
+func (s *Shared) CancelReservation(reservationID string) (err error) {
+	if lockErr := s.do(s.reservationScope(reservationID), func() { err = s.local.CancelReservation(reservationID) }); lockErr != nil {
+		return lockErr
+	}
+	return err
+}
+
+func (s *Shared) ExtendReservationOnce(reservationID string) (res *models.SeatReservation, err error) {
+	if lockErr := s.do(s.reservationScope(reservationID), func() { res, err = s.local.ExtendReservationOnce(reservationID) }); lockErr != nil {
+		return nil, lockErr
+	}
+	return res, err
+}
+
+func (s *Shared) ExtendReservation(reservationID string) (res *models.SeatReservation, err error) {
+	if lockErr := s.do(s.reservationScope(reservationID), func() { res, err = s.local.ExtendReservation(reservationID) }); lockErr != nil {
+		return nil, lockErr
+	}
+	return res, err
+}
+
+func (s *Shared) AcceptOffer(reservationID string) (res *models.SeatReservation, err error) {
+	if lockErr := s.do(s.reservationScope(reservationID), func() { res, err = s.local.AcceptOffer(reservationID) }); lockErr != nil {
+		return nil, lockErr
+	}
+	return res, err
+}
+
+func (s *Shared) AttachPayment(reservationID, paymentID, clientSecret string) (res *models.SeatReservation, err error) {
+	if lockErr := s.do(s.reservationScope(reservationID), func() { res, err = s.local.AttachPayment(reservationID, paymentID, clientSecret) }); lockErr != nil {
+		return nil, lockErr
+	}
+	return res, err
+}
+
+func (s *Shared) PrepareConfirmation(reservationID string, opts database.BookingOptions) (res *models.SeatReservation, err error) {
+	if lockErr := s.do(s.reservationScope(reservationID), func() { res, err = s.local.PrepareConfirmation(reservationID, opts) }); lockErr != nil {
+		return nil, lockErr
+	}
+	return res, err
+}
+
+func (s *Shared) CompletePayment(paymentID string) (booking *models.Booking, err error) {
+	if lockErr := s.do(s.paymentScope(paymentID), func() { booking, err = s.local.CompletePayment(paymentID) }); lockErr != nil {
+		return nil, lockErr
+	}
+	return booking, err
+}
+
+func (s *Shared) CreateCart(userID string) (cart *models.Cart, err error) {
+	if lockErr := s.do(fixed(on(kindUser, userID)), func() { cart, err = s.local.CreateCart(userID) }); lockErr != nil {
+		return nil, lockErr
+	}
+	return cart, err
+}
+
+func (s *Shared) GetCart(cartID string) (cart *models.Cart, err error) {
+	s.view(func() { cart, err = s.local.GetCart(cartID) })
+	return cart, err
+}
+
+func (s *Shared) SetCartItem(cartID string, item database.ReservationItem) (cart *models.Cart, err error) {
+	if lockErr := s.do(s.cartScope(cartID), func() { cart, err = s.local.SetCartItem(cartID, item) }); lockErr != nil {
+		return nil, lockErr
+	}
+	return cart, err
+}
+
+func (s *Shared) RemoveCartItem(cartID, conferenceID string) (cart *models.Cart, err error) {
+	if lockErr := s.do(s.cartScope(cartID), func() { cart, err = s.local.RemoveCartItem(cartID, conferenceID) }); lockErr != nil {
+		return nil, lockErr
+	}
+	return cart, err
+}
+
+func (s *Shared) CheckoutCart(cartID string) (cart *models.Cart, res []*models.SeatReservation, err error) {
+	if lockErr := s.do(s.cartScope(cartID), func() { cart, res, err = s.local.CheckoutCart(cartID) }); lockErr != nil {
+		return nil, nil, lockErr
+	}
+	return cart, res, err
+}
+
+func (s *Shared) ConfirmCart(cartID string, opts database.BookingOptions) (cart *models.Cart, bookings []*models.Booking, err error) {
+	if lockErr := s.do(s.cartScope(cartID), func() { cart, bookings, err = s.local.ConfirmCart(cartID, opts) }); lockErr != nil {
+		return nil, nil, lockErr
+	}
+	return cart, bookings, err
+}
+
+func (s *Shared) AttachCartPayment(cartID, paymentID, clientSecret string) (cart *models.Cart, err error) {
+	if lockErr := s.do(s.cartScope(cartID), func() { cart, err = s.local.AttachCartPayment(cartID, paymentID, clientSecret) }); lockErr != nil {
+		return nil, lockErr
+	}
+	return cart, err
+}
+
+func (s *Shared) CancelCart(cartID string) (cart *models.Cart, err error) {
+	if lockErr := s.do(s.cartScope(cartID), func() { cart, err = s.local.CancelCart(cartID) }); lockErr != nil {
+		return nil, lockErr
+	}
+	return cart, err
+}
+
+func (s *Shared) ExtensionsLeft(reservation *models.SeatReservation) int {
+	return s.local.ExtensionsLeft(reservation)
+}
+
+func (s *Shared) EnqueueWait(userID, conferenceID string, ticketCount int, tier string) (position int, err error) {
+	if lockErr := s.do(fixed(on(kindConference, conferenceID).and(kindUser, userID)), func() { position, err = s.local.EnqueueWait(userID, conferenceID, ticketCount, tier) }); lockErr != nil {
+		return 0, lockErr
+	}
+	return position, err
+}
+
+func (s *Shared) BulkEnqueue(conferenceID string, requests []database.QueueRequest) (positions []int, err error) {
+	if lockErr := s.do(fixed(queueScope(conferenceID, requests)), func() { positions, err = s.local.BulkEnqueue(conferenceID, requests) }); lockErr != nil {
+		return nil, lockErr
+	}
+	return positions, err
+}
+
+func (s *Shared) DequeueWait(userID, conferenceID string) (removed bool) {
+	if err := s.do(fixed(on(kindConference, conferenceID).and(kindUser, userID)), func() { removed = s.local.DequeueWait(userID, conferenceID) }); err != nil {
+		slog.Error("Dequeue not applied", "user_id", userID, "conference_id", conferenceID, "error", err)
+		return false
+	}
+	return removed
+}
+
+func (s *Shared) GetQueuePosition(userID, conferenceID string) (status database.QueueStatus) {
+	s.view(func() { status = s.local.GetQueuePosition(userID, conferenceID) })
+	return status
+}
+
+func (s *Shared) ListQueue(conferenceID string) (entries []database.WaitEntry, err error) {
+	s.view(func() { entries, err = s.local.ListQueue(conferenceID) })
+	return entries, err
+}
+
+func (s *Shared) ClaimNext(userID, conferenceID string, partial bool) (res *models.SeatReservation, err error) {
+	if lockErr := s.do(fixed(on(kindConference, conferenceID).and(kindUser, userID)), func() { res, err = s.local.ClaimNext(userID, conferenceID, partial) }); lockErr != nil {
+		return nil, lockErr
+	}
+	return res, err
+}
+
+func (s *Shared) JoinWaitingRoom(userID, conferenceID string) (status database.RoomStatus, err error) {
+	if lockErr := s.do(fixed(on(kindConference, conferenceID).and(kindUser, userID).withRoom(conferenceID)), func() { status, err = s.local.JoinWaitingRoom(userID, conferenceID) }); lockErr != nil {
+		return database.RoomStatus{}, lockErr
+	}
+	return status, err
+}
+
+func (s *Shared) WaitingRoomStatus(userID, conferenceID string) (status database.RoomStatus, err error) {
+	s.view(func() { status, err = s.local.WaitingRoomStatus(userID, conferenceID) })
+	return status, err
+}
+
+// GetMetrics reports the counters of every instance together, with the gauges of the shared
+// state, or this instance's own counters when Redis can't be reached
+func (s *Shared) GetMetrics() (m database.Metrics) {
+	s.view(func() { m = s.local.GetMetrics() })
+	ctx, cancel := context.WithTimeout(context.Background(), OpTimeout)
+	defer cancel()
+	counts, err := s.rdb.HGetAll(ctx, metricsKey).Result()
+	if err != nil {
+		slog.Warn("Shared metrics unavailable, reporting this instance's", "error", err)
+		return m
+	}
+	ops := make(map[string]uint64, len(counts))
+	for op, n := range counts {
+		ops[op], _ = strconv.ParseUint(n, 10, 64)
+	}
+	return m.WithOpCounts(ops)
+}
+
+// GetAuditLog returns the changes made through this instance
+func (s *Shared) GetAuditLog() []database.AuditEntry {
+	return s.local.GetAuditLog()
+}
+
+// QueryAuditLog searches the changes made through this instance
+func (s *Shared) QueryAuditLog(filter database.AuditFilter, limit, offset int) ([]database.AuditEntry, int) {
+	return s.local.QueryAuditLog(filter, limit, offset)
+}
+
+// Drift compares this instance's copy of the shared state with other
+func (s *Shared) Drift(other *database.Database) (drift []string) {
+	s.view(func() { drift = s.local.Drift(other) })
+	return drift
+}
+
+// Inventory replays this instance's changes since it last took in another instance's, which
+// start the stream afresh
+func (s *Shared) Inventory(conferenceID string) (report *database.InventoryReport, err error) {
+	s.view(func() { report, err = s.local.Inventory(conferenceID) })
+	return report, err
+}
+
+func (s *Shared) CheckInventory() (problems []string) {
+	s.view(func() { problems = s.local.CheckInventory() })
+	return problems
+}
+
+// ResetDatabase resets the shared state for every instance
+func (s *Shared) ResetDatabase() {
+	if err := s.do(fixed(everything()), s.local.ResetDatabase); err != nil {
+		slog.Error("Reset not applied", "error", err)
+	}
+}
+
+func (s *Shared) Snapshot() (snap database.Snapshot) {
+	s.view(func() { snap = s.local.Snapshot() })
+	return snap
+}
+
+// Import replaces the shared state for every instance
+func (s *Shared) Import(snap database.Snapshot) (err error) {
+	if doErr := s.do(fixed(everything()), func() { err = s.local.Import(snap) }); doErr != nil {
+		return doErr
+	}
+	return err
+}
+
+// emails lists the addresses of inputs
+func emails(inputs []database.UserInput) []string {
+	addrs := make([]string, len(inputs))
+	for i, in := range inputs {
+		addrs[i] = in.Email
+	}
+	return addrs
+}
+
+// bundleScope covers holding seats at several conferences for one user
+func bundleScope(userID string, items []database.ReservationItem, opts database.ReservationOptions) scope {
+	sc := on(kindUser, userID).promo(opts.PromoCode)
+	for _, item := range items {
+		sc = sc.and(kindConference, item.ConferenceID)
+	}
+	return sc
+}
+
+// queueScope covers queueing several users for one conference
+func queueScope(conferenceID string, requests []database.QueueRequest) scope {
+	sc := on(kindConference, conferenceID)
+	for _, r := range requests {
+		sc = sc.and(kindUser, r.UserID)
+	}
+	return sc
+}
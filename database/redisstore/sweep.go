@@ -0,0 +1,96 @@
+package redisstore
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Lapsed holds are expired by one instance at a time: whichever holds the sweeper lease,
+// leaseKey, which it renews on every sweep. Expiring a hold offers its seats down the wait
+// queue and publishes an event, so doing it on one instance, under the conference's lock, has
+// each hold expire once.
+
+// renewScript extends the lease in KEYS[1] if it is still held by ARGV[1]; ARGV[2] is the TTL
+// in ms
+var renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// SetSweepInterval changes how often this instance, while it is the elected sweeper, looks for
+// lapsed holds; zero or less pauses it (and lets the lease go to another instance)
+func (s *Shared) SetSweepInterval(interval time.Duration) {
+	select {
+	case s.sweepEvery <- interval:
+	case <-s.done:
+	}
+}
+
+// runSweeper sweeps every interval until Close
+func (s *Shared) runSweeper(interval time.Duration) {
+	defer s.workers.Done()
+	var tick <-chan time.Time
+	var ticker *time.Ticker
+	reset := func(d time.Duration) {
+		if ticker != nil {
+			ticker.Stop()
+			ticker, tick = nil, nil
+		}
+		if d > 0 {
+			ticker = time.NewTicker(d)
+			tick = ticker.C
+		}
+	}
+	reset(interval)
+	defer reset(0)
+	for {
+		select {
+		case <-tick:
+			s.sweep()
+		case d := <-s.sweepEvery:
+			reset(d)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// sweep expires the lapsed holds across the shared state if this instance is the elected
+// sweeper, one conference at a time so writes to the others go on
+func (s *Shared) sweep() {
+	ctx, cancel := context.WithTimeout(context.Background(), OpTimeout)
+	leader, err := s.lead(ctx)
+	cancel()
+	if err != nil {
+		slog.Warn("Failed to claim the sweeper lease", "error", err)
+		return
+	}
+	if !leader {
+		return
+	}
+	s.refresh()
+	s.mu.Lock()
+	lapsed := s.local.LapsedConferences()
+	s.mu.Unlock()
+	for _, id := range lapsed {
+		// do expires the conference's lapsed holds once it holds the lock
+		if err := s.do(fixed(on(kindConference, id)), func() {}); err != nil {
+			slog.Error("Failed to expire lapsed holds", "conference_id", id, "error", err)
+		}
+	}
+}
+
+// lead takes the sweeper lease if nobody holds it, or renews it if this instance does, and
+// reports whether this instance is the sweeper
+func (s *Shared) lead(ctx context.Context) (bool, error) {
+	ok, err := s.rdb.SetNX(ctx, leaseKey, s.id, LeaseTTL).Result()
+	if err != nil || ok {
+		return ok, err
+	}
+	renewed, err := renewScript.Run(ctx, s.rdb, []string{leaseKey}, s.id, LeaseTTL.Milliseconds()).Int()
+	return renewed == 1, err
+}
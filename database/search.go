@@ -0,0 +1,99 @@
+package database
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"booking-system/models"
+)
+
+// How much a query term matching each conference field counts toward relevance. A term that
+// only matches the start of a word (a search box mid-typing) counts half.
+const (
+	searchWeightName        = 3.0
+	searchWeightTag         = 2.0
+	searchWeightLocation    = 2.0
+	searchWeightDescription = 1.0
+)
+
+// ConferenceMatch is one search result and how relevant it is; higher scores rank first
+type ConferenceMatch struct {
+	Conference *models.Conference
+	Score      float64
+}
+
+// searchTokens splits text into lower-case words of letters and digits
+func searchTokens(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// termScore is how well term matches words: the full weight for a whole word, half for a
+// prefix, nothing otherwise
+func termScore(term string, words []string, weight float64) float64 {
+	best := 0.0
+	for _, word := range words {
+		if word == term {
+			return weight
+		}
+		if strings.HasPrefix(word, term) {
+			best = weight / 2
+		}
+	}
+	return best
+}
+
+// scoreConference sums how well each term matches conf, zero unless every term matches
+// somewhere
+func scoreConference(conf *models.Conference, terms []string) float64 {
+	name, location, description := searchTokens(conf.Name), searchTokens(conf.Location), searchTokens(conf.Description)
+	var tags []string
+	for _, tag := range conf.Tags {
+		tags = append(tags, searchTokens(tag)...)
+	}
+	total := 0.0
+	for _, term := range terms {
+		score := termScore(term, name, searchWeightName) +
+			termScore(term, tags, searchWeightTag) +
+			termScore(term, location, searchWeightLocation) +
+			termScore(term, description, searchWeightDescription)
+		if score == 0 {
+			return 0
+		}
+		total += score
+	}
+	return total
+}
+
+// SearchConferences finds the conferences whose name, location, tags or description contain
+// every word of query (whole words, or word prefixes for partial input), most relevant first;
+// ties go to the sooner conference. A query without words matches nothing.
+func (db *Database) SearchConferences(query string) []ConferenceMatch {
+	terms := searchTokens(query)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	var matches []ConferenceMatch
+	for _, conf := range db.Conferences {
+		if score := scoreConference(conf, terms); score > 0 {
+			matches = append(matches, ConferenceMatch{Conference: snapshotConference(conf), Score: score})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if !a.Conference.Date.Equal(b.Conference.Date) {
+			return a.Conference.Date.Before(b.Conference.Date)
+		}
+		return a.Conference.ID < b.Conference.ID
+	})
+	return matches
+}
@@ -0,0 +1,208 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+
+	"booking-system/models"
+)
+
+// MaxSections caps how many sections a seat map may have
+const MaxSections = 50
+
+// Seat states in a SeatMap
+const (
+	SeatFree   = "free"
+	SeatHeld   = "held"
+	SeatBooked = "booked"
+)
+
+// ErrSeatNotFound is returned when a hold names a seat the conference's map doesn't have
+var ErrSeatNotFound = fmt.Errorf("seat %w", ErrNotFound)
+
+// ErrSeatTaken is returned when a hold names a seat someone else holds or has booked
+var ErrSeatTaken = conflictf("seat is already taken")
+
+// Seat is one seat of a conference's seat map and whether it can be picked
+type Seat struct {
+	ID      string `json:"id"`
+	Section string `json:"section"`
+	Row     int    `json:"row"`
+	Number  int    `json:"number"`
+	State   string `json:"state"` // SeatFree, SeatHeld or SeatBooked
+}
+
+// SeatMap is every seat of a conference in section, row and seat order, with counts by state
+type SeatMap struct {
+	ConferenceID string           `json:"conference_id"`
+	Sections     []models.Section `json:"sections"`
+	Seats        []Seat           `json:"seats"`
+	Free         int              `json:"free"`
+	Held         int              `json:"held"`
+	Booked       int              `json:"booked"`
+}
+
+// seatID names a seat; see models.Section
+func seatID(section string, row, number int) string {
+	return fmt.Sprintf("%s-%d-%d", section, row, number)
+}
+
+// seatCount is how many seats sections lay out
+func seatCount(sections []models.Section) int {
+	n := 0
+	for _, s := range sections {
+		n += s.Rows * s.SeatsPerRow
+	}
+	return n
+}
+
+// normalizeSections checks the seat map a conference is created with and returns it trimmed.
+// Section names must be unique (case-insensitive) and the map must have exactly one seat per
+// ticket, so every ticket sold can be given a seat.
+func normalizeSections(sections []models.Section, totalTickets int) ([]models.Section, error) {
+	if len(sections) == 0 {
+		return nil, nil
+	}
+	if len(sections) > MaxSections {
+		return nil, fmt.Errorf("a seat map has at most %d sections", MaxSections)
+	}
+	var out []models.Section
+	for _, s := range sections {
+		s.Name = strings.TrimSpace(s.Name)
+		switch {
+		case s.Name == "":
+			return nil, fmt.Errorf("section name is required")
+		case s.Rows <= 0 || s.SeatsPerRow <= 0:
+			return nil, fmt.Errorf("section %s must have at least one row and one seat per row", s.Name)
+		}
+		for _, seen := range out {
+			if strings.EqualFold(seen.Name, s.Name) {
+				return nil, fmt.Errorf("section %s is listed twice", s.Name)
+			}
+		}
+		out = append(out, s)
+	}
+	if seats := seatCount(out); seats != totalTickets {
+		return nil, fmt.Errorf("seat map has %d seats but the conference has %d tickets", seats, totalTickets)
+	}
+	return out, nil
+}
+
+// takenSeatsLocked maps each seat of a conference that an unexpired hold or a confirmed
+// booking has to SeatHeld or SeatBooked; caller must hold the lock
+func (db *Database) takenSeatsLocked(conferenceID string) map[string]string {
+	taken := make(map[string]string)
+	now := db.now()
+	for _, r := range db.Reservations {
+		if r.ConferenceID == conferenceID && now.Before(r.ExpiresAt) {
+			for _, id := range r.SeatIDs {
+				taken[id] = SeatHeld
+			}
+		}
+	}
+	for _, b := range db.Bookings {
+		if b.ConferenceID == conferenceID && b.Status == "confirmed" {
+			for _, id := range b.SeatIDs {
+				taken[id] = SeatBooked
+			}
+		}
+	}
+	return taken
+}
+
+// pickSeatsLocked chooses the seats for a new hold or booking of count tickets. Conferences
+// without a seat map get none. wanted, when given, must name count free seats of the map;
+// otherwise the first free seats in map order are taken. Caller must hold write lock, so two
+// holds picking the same seat are decided by whichever gets the lock first.
+func (db *Database) pickSeatsLocked(conf *models.Conference, count int, wanted []string) ([]string, error) {
+	if len(conf.Sections) == 0 {
+		if len(wanted) > 0 {
+			return nil, fmt.Errorf("conference %s has no seat map to pick seats from", conf.ID)
+		}
+		return nil, nil
+	}
+	taken := db.takenSeatsLocked(conf.ID)
+
+	if len(wanted) == 0 {
+		var seats []string
+		for _, s := range conf.Sections {
+			for row := 1; row <= s.Rows; row++ {
+				for n := 1; n <= s.SeatsPerRow && len(seats) < count; n++ {
+					if id := seatID(s.Name, row, n); taken[id] == "" {
+						seats = append(seats, id)
+					}
+				}
+			}
+		}
+		if len(seats) < count {
+			return nil, soldOutf("only %d seats are free", len(seats))
+		}
+		return seats, nil
+	}
+
+	if len(wanted) != count {
+		return nil, fmt.Errorf("pick %d seats, one per ticket; got %d", count, len(wanted))
+	}
+	exists := make(map[string]bool, seatCount(conf.Sections))
+	for _, s := range conf.Sections {
+		for row := 1; row <= s.Rows; row++ {
+			for n := 1; n <= s.SeatsPerRow; n++ {
+				exists[seatID(s.Name, row, n)] = true
+			}
+		}
+	}
+	seats := make([]string, 0, count)
+	picked := make(map[string]bool, count)
+	for _, id := range wanted {
+		id = strings.TrimSpace(id)
+		switch {
+		case !exists[id]:
+			return nil, fmt.Errorf("%w: %s", ErrSeatNotFound, id)
+		case picked[id]:
+			return nil, fmt.Errorf("seat %s is picked twice", id)
+		case taken[id] != "":
+			return nil, fmt.Errorf("seat %s: %w", id, ErrSeatTaken)
+		}
+		picked[id] = true
+		seats = append(seats, id)
+	}
+	return seats, nil
+}
+
+// SeatMap returns every seat of a conference with its current state. A conference without a
+// seat map returns an empty map.
+func (db *Database) SeatMap(conferenceID string) (*SeatMap, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	conf, ok := db.Conferences[conferenceID]
+	if !ok {
+		return nil, ErrConferenceNotFound
+	}
+	taken := db.takenSeatsLocked(conferenceID)
+	m := &SeatMap{
+		ConferenceID: conferenceID,
+		Sections:     append([]models.Section{}, conf.Sections...),
+		Seats:        make([]Seat, 0, seatCount(conf.Sections)),
+	}
+	for _, s := range conf.Sections {
+		for row := 1; row <= s.Rows; row++ {
+			for n := 1; n <= s.SeatsPerRow; n++ {
+				seat := Seat{ID: seatID(s.Name, row, n), Section: s.Name, Row: row, Number: n, State: SeatFree}
+				if state := taken[seat.ID]; state != "" {
+					seat.State = state
+				}
+				switch seat.State {
+				case SeatFree:
+					m.Free++
+				case SeatHeld:
+					m.Held++
+				default:
+					m.Booked++
+				}
+				m.Seats = append(m.Seats, seat)
+			}
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,421 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"booking-system/models"
+)
+
+// Snapshot is the saved form of the database state, written to disk by SaveSnapshot or to
+// another store (see database/postgres)
+type Snapshot struct {
+	SavedAt      time.Time                          `json:"saved_at"`
+	Users        map[string]*models.User            `json:"users"`
+	Conferences  map[string]*models.Conference      `json:"conferences"`
+	Bookings     map[string]*models.Booking         `json:"bookings"`
+	Reservations map[string]*models.SeatReservation `json:"reservations"`
+	WaitQueues   map[string][]*WaitEntry            `json:"wait_queues"`
+	WaitingRooms map[string]*WaitingRoomLine        `json:"waiting_rooms,omitempty"`
+	PromoCodes   map[string]*models.PromoCode       `json:"promo_codes,omitempty"`
+	Carts        map[string]*models.Cart            `json:"carts,omitempty"`
+	CheckIns     map[string]*models.CheckIn         `json:"check_ins,omitempty"`
+	Expired      map[string]time.Time               `json:"expired,omitempty"`     // reservation tombstones
+	Credentials  map[string]string                  `json:"credentials,omitempty"` // user ID -> password hash
+}
+
+// Snapshot copies the current state so it can be saved without holding the lock
+func (db *Database) Snapshot() Snapshot {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	snap := Snapshot{
+		SavedAt:      db.now(),
+		Users:        make(map[string]*models.User, len(db.Users)),
+		Conferences:  make(map[string]*models.Conference, len(db.Conferences)),
+		Bookings:     make(map[string]*models.Booking, len(db.Bookings)),
+		Reservations: make(map[string]*models.SeatReservation, len(db.Reservations)),
+		WaitQueues:   make(map[string][]*WaitEntry, len(db.WaitQueues)),
+		WaitingRooms: make(map[string]*WaitingRoomLine, len(db.WaitingRooms)),
+		PromoCodes:   make(map[string]*models.PromoCode, len(db.PromoCodes)),
+		Carts:        make(map[string]*models.Cart, len(db.Carts)),
+		CheckIns:     make(map[string]*models.CheckIn, len(db.CheckIns)),
+		Expired:      make(map[string]time.Time, len(db.expired)),
+		Credentials:  make(map[string]string, len(db.credentials)),
+	}
+	for id, u := range db.Users {
+		cp := *u
+		snap.Users[id] = &cp
+	}
+	for id, c := range db.Conferences {
+		snap.Conferences[id] = snapshotConference(c)
+	}
+	for id, b := range db.Bookings {
+		snap.Bookings[id] = snapshotBooking(b)
+	}
+	for id, r := range db.Reservations {
+		snap.Reservations[id] = snapshotReservation(r)
+	}
+	for id, q := range db.WaitQueues {
+		entries := make([]*WaitEntry, len(q))
+		for i, e := range q {
+			cp := *e
+			entries[i] = &cp
+		}
+		snap.WaitQueues[id] = entries
+	}
+	for id, line := range db.WaitingRooms {
+		snap.WaitingRooms[id] = line.clone()
+	}
+	for code, p := range db.PromoCodes {
+		cp := *p
+		snap.PromoCodes[code] = &cp
+	}
+	for id, cart := range db.Carts {
+		snap.Carts[id] = snapshotCart(cart)
+	}
+	for id, in := range db.CheckIns {
+		cp := *in
+		snap.CheckIns[id] = &cp
+	}
+	for id, at := range db.expired {
+		snap.Expired[id] = at
+	}
+	for id, hash := range db.credentials {
+		snap.Credentials[id] = hash
+	}
+	return snap
+}
+
+// SaveSnapshot writes users, conferences, bookings, reservations, wait queues, promo codes and carts
+// to path as JSON. The file is written to a temporary name first and renamed so a crash never
+// leaves it half-written.
+func (db *Database) SaveSnapshot(path string) error {
+	return writeSnapshot(path, db.Snapshot())
+}
+
+// writeSnapshot writes snap to path through a temporary file; see SaveSnapshot
+func writeSnapshot(path string, snap Snapshot) error {
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode snapshot: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	return nil
+}
+
+// LoadSnapshot replaces the current state with the snapshot at path; see Restore
+func (db *Database) LoadSnapshot(path string) error {
+	snap, err := readSnapshot(path)
+	if err != nil {
+		return err
+	}
+	db.Restore(snap)
+	return nil
+}
+
+// readSnapshot decodes the snapshot at path
+func readSnapshot(path string) (Snapshot, error) {
+	var snap Snapshot
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return snap, fmt.Errorf("read snapshot: %w", err)
+	}
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return snap, fmt.Errorf("decode snapshot: %w", err)
+	}
+	return snap, nil
+}
+
+// SnapshotFile is a Persister keeping the snapshot in a JSON file, so SyncTo can save it
+// periodically rather than only on shutdown
+type SnapshotFile string
+
+func (f SnapshotFile) Save(_ context.Context, snap Snapshot) error {
+	return writeSnapshot(string(f), snap)
+}
+
+func (f SnapshotFile) Load(_ context.Context) (Snapshot, error) {
+	return readSnapshot(string(f))
+}
+
+func (f SnapshotFile) Empty(_ context.Context) (bool, error) {
+	_, err := os.Stat(string(f))
+	if errors.Is(err, os.ErrNotExist) {
+		return true, nil
+	}
+	return false, err
+}
+
+func (f SnapshotFile) Close() error { return nil }
+
+// ErrInvalidSnapshot is returned by Import for a snapshot that doesn't hang together
+var ErrInvalidSnapshot = errors.New("invalid snapshot")
+
+// Import replaces the current state with snap, as Restore does, once it has checked that
+// every booking, hold and queue entry belongs to a known user and conference and that each
+// conference's available tickets are its capacity less what its bookings sold. Nothing
+// changes when it fails.
+func (db *Database) Import(snap Snapshot) error {
+	if err := snap.check(); err != nil {
+		return err
+	}
+	db.Restore(snap)
+	return nil
+}
+
+// check reports the first way snap contradicts itself
+func (snap *Snapshot) check() error {
+	invalid := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%w: %s", ErrInvalidSnapshot, fmt.Sprintf(format, args...))
+	}
+	if len(snap.Conferences) == 0 {
+		return invalid("no conferences")
+	}
+	sold := make(map[string]int, len(snap.Conferences))
+	for id, b := range snap.Bookings {
+		switch {
+		case b == nil || b.ID != id:
+			return invalid("booking %s is keyed under another ID", id)
+		case snap.Users[b.UserID] == nil:
+			return invalid("booking %s belongs to unknown user %s", id, b.UserID)
+		case b.Status == "confirmed":
+			sold[b.ConferenceID] += b.TicketsBooked
+		}
+	}
+	for id, r := range snap.Reservations {
+		switch {
+		case r == nil || r.ID != id:
+			return invalid("reservation %s is keyed under another ID", id)
+		case snap.Users[r.UserID] == nil || snap.Conferences[r.ConferenceID] == nil:
+			return invalid("reservation %s names an unknown user or conference", id)
+		}
+	}
+	for confID, q := range snap.WaitQueues {
+		if snap.Conferences[confID] == nil {
+			return invalid("queue for unknown conference %s", confID)
+		}
+		for _, e := range q {
+			if e == nil || snap.Users[e.UserID] == nil {
+				return invalid("queue for %s has an entry of an unknown user", confID)
+			}
+		}
+	}
+	for id, c := range snap.Conferences {
+		switch {
+		case c == nil || c.ID != id:
+			return invalid("conference %s is keyed under another ID", id)
+		case c.AvailableTickets < 0 || c.AvailableTickets != c.TotalTickets-sold[id]:
+			return invalid("conference %s has %d of %d tickets available but its bookings leave %d",
+				id, c.AvailableTickets, c.TotalTickets, c.TotalTickets-sold[id])
+		}
+	}
+	for id, u := range snap.Users {
+		if u == nil || u.ID != id {
+			return invalid("user %s is keyed under another ID", id)
+		}
+	}
+	return nil
+}
+
+// Restore replaces the current state with snap. Reservations that expired while the server
+// was down are dropped, and snapshots without promo codes keep the current ones. The audit
+// log starts afresh from the restored state.
+func (db *Database) Restore(snap Snapshot) {
+	db.lock()
+	defer db.unlock()
+
+	db.restoreLocked(snap, false)
+	db.Audit = nil
+	db.openLedgerLocked()
+	db.writes++
+}
+
+// Adopt replaces the current state with snap taken from the state shared with other instances
+// (see database/redisstore). Unlike Restore, holds past their expiry are kept for whichever
+// instance sweeps their conference (see SetSharedExpiry), and the audit log carries on.
+func (db *Database) Adopt(snap Snapshot) {
+	db.lock()
+	defer db.unlock()
+
+	db.restoreLocked(snap, true)
+	db.openLedgerLocked()
+	db.writes++
+}
+
+// Apply takes in changes another instance sharing the state made (see ChangesSince and
+// database/redisstore): each record in delta replaces the local one, or is removed where nil,
+// as is each wait queue and waiting room line in it, expired tombstones are added, and retired
+// reservations join their users' history. Nothing is recorded in the audit log, as the changes
+// were recorded where they were made; the inventory stream opens afresh on the result.
+func (db *Database) Apply(delta Snapshot, retired []*models.SeatReservation) {
+	db.lock()
+	defer db.unlock()
+
+	for id, u := range delta.Users {
+		if u == nil {
+			delete(db.Users, id)
+		} else {
+			db.Users[id] = u
+		}
+	}
+	for id, hash := range delta.Credentials {
+		db.credentials[id] = hash
+	}
+	for id, c := range delta.Conferences {
+		if c == nil {
+			delete(db.Conferences, id)
+			delete(db.WaitQueues, id)
+			delete(db.WaitingRooms, id)
+			delete(db.turnover, id)
+		} else {
+			db.Conferences[id] = c
+		}
+	}
+	for id, b := range delta.Bookings {
+		if b == nil {
+			delete(db.Bookings, id)
+		} else {
+			db.Bookings[id] = b
+		}
+	}
+	for id, r := range delta.Reservations {
+		if r == nil {
+			delete(db.Reservations, id)
+		} else {
+			db.Reservations[id] = r
+		}
+	}
+	for id, q := range delta.WaitQueues {
+		if len(q) == 0 {
+			delete(db.WaitQueues, id)
+		} else {
+			db.WaitQueues[id] = q
+		}
+	}
+	for id, line := range delta.WaitingRooms {
+		if line == nil {
+			delete(db.WaitingRooms, id)
+		} else {
+			db.WaitingRooms[id] = line
+		}
+	}
+	for code, p := range delta.PromoCodes {
+		if p == nil {
+			delete(db.PromoCodes, code)
+		} else {
+			db.PromoCodes[code] = p
+		}
+	}
+	for id, cart := range delta.Carts {
+		if cart == nil {
+			delete(db.Carts, id)
+		} else {
+			db.Carts[id] = cart
+		}
+	}
+	for id, in := range delta.CheckIns {
+		if in == nil {
+			delete(db.CheckIns, id)
+		} else {
+			db.CheckIns[id] = in
+		}
+	}
+	for id, at := range delta.Expired {
+		db.expired[id] = at
+	}
+	for _, r := range retired {
+		db.keepInHistoryLocked(r)
+	}
+	db.openLedgerLocked()
+	db.writes++
+}
+
+// restoreLocked swaps in the maps from snap, keeping expired reservations if asked; caller
+// must hold write lock
+func (db *Database) restoreLocked(snap Snapshot, keepExpired bool) {
+	db.epoch++
+	db.Users = make(map[string]*models.User)
+	db.Conferences = make(map[string]*models.Conference)
+	db.Bookings = make(map[string]*models.Booking)
+	db.Reservations = make(map[string]*models.SeatReservation)
+	db.WaitQueues = make(map[string][]*WaitEntry)
+	db.WaitingRooms = make(map[string]*WaitingRoomLine)
+	db.Carts = make(map[string]*models.Cart)
+	db.CheckIns = make(map[string]*models.CheckIn)
+	db.expired = make(map[string]time.Time)
+	db.credentials = make(map[string]string)
+
+	for id, u := range snap.Users {
+		db.Users[id] = u
+	}
+	for id, c := range snap.Conferences {
+		db.Conferences[id] = c
+	}
+	for id, b := range snap.Bookings {
+		db.Bookings[id] = b
+	}
+	now := db.now()
+	for id, r := range snap.Reservations {
+		if keepExpired || now.Before(r.ExpiresAt) {
+			if r.Status == "" {
+				r.Status = models.ReservationStatusActive // older snapshots carry no status
+			}
+			db.Reservations[id] = r
+		}
+	}
+	for id, q := range snap.WaitQueues {
+		db.WaitQueues[id] = q
+	}
+	for id, line := range snap.WaitingRooms {
+		if _, ok := db.Conferences[id]; ok && line != nil {
+			db.WaitingRooms[id] = line
+		}
+	}
+	for id, cart := range snap.Carts {
+		db.Carts[id] = cart
+	}
+	for id, in := range snap.CheckIns {
+		db.CheckIns[id] = in
+	}
+	for id, at := range snap.Expired {
+		db.expired[id] = at
+	}
+	for id, hash := range snap.Credentials {
+		db.credentials[id] = hash
+	}
+	if snap.PromoCodes != nil {
+		db.PromoCodes = make(map[string]*models.PromoCode, len(snap.PromoCodes))
+		for code, p := range snap.PromoCodes {
+			db.PromoCodes[code] = p
+		}
+	}
+}
+
+// Writes counts state changes since startup; it differs between two calls exactly when
+// something changed in between, so savers can skip idle intervals
+func (db *Database) Writes() uint64 {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	return db.writes
+}
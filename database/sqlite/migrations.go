@@ -0,0 +1,119 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// migrations mirror database/postgres's, in SQLite types: applied in order and never edited
+// once released, with schema changes going in a new entry at the end
+var migrations = []string{
+	// 1: initial schema
+	`CREATE TABLE users (
+		id         TEXT PRIMARY KEY,
+		email      TEXT NOT NULL UNIQUE,
+		created_at TIMESTAMP NOT NULL,
+		data       TEXT NOT NULL
+	);
+	CREATE TABLE conferences (
+		id   TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		date TIMESTAMP,
+		data TEXT NOT NULL
+	);
+	CREATE TABLE bookings (
+		id            TEXT PRIMARY KEY,
+		user_id       TEXT NOT NULL,
+		conference_id TEXT NOT NULL,
+		status        TEXT NOT NULL,
+		booked_at     TIMESTAMP NOT NULL,
+		data          TEXT NOT NULL
+	);
+	CREATE INDEX bookings_user_id_idx ON bookings (user_id);
+	CREATE INDEX bookings_conference_id_idx ON bookings (conference_id);
+	CREATE TABLE reservations (
+		id            TEXT PRIMARY KEY,
+		user_id       TEXT NOT NULL,
+		conference_id TEXT NOT NULL,
+		expires_at    TIMESTAMP NOT NULL,
+		data          TEXT NOT NULL
+	);
+	CREATE INDEX reservations_conference_id_idx ON reservations (conference_id);
+	CREATE TABLE wait_queue (
+		conference_id TEXT NOT NULL,
+		position      INTEGER NOT NULL,
+		id            TEXT NOT NULL UNIQUE,
+		user_id       TEXT NOT NULL,
+		data          TEXT NOT NULL,
+		PRIMARY KEY (conference_id, position)
+	);
+	CREATE TABLE promo_codes (
+		code TEXT PRIMARY KEY,
+		data TEXT NOT NULL
+	);`,
+	// 2: login passwords (see database.RegisterUser)
+	`CREATE TABLE credentials (
+		user_id       TEXT PRIMARY KEY,
+		password_hash TEXT NOT NULL
+	);`,
+	// 3: multi-conference carts (see database.CheckoutCart)
+	`CREATE TABLE carts (
+		id         TEXT PRIMARY KEY,
+		user_id    TEXT NOT NULL,
+		status     TEXT NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		data       TEXT NOT NULL
+	);
+	CREATE INDEX carts_user_id_idx ON carts (user_id);`,
+	// 4: tickets admitted at the door (see database.CheckInTicket)
+	`CREATE TABLE check_ins (
+		ticket_id     TEXT PRIMARY KEY,
+		conference_id TEXT NOT NULL,
+		checked_in_at TIMESTAMP NOT NULL,
+		data          TEXT NOT NULL
+	);
+	CREATE INDEX check_ins_conference_id_idx ON check_ins (conference_id);`,
+}
+
+// Migrate brings the schema up to date, applying each pending migration in its own
+// transaction and recording it in schema_migrations. SQLite serializes writers, so no
+// separate lock is needed when two processes start against the same file.
+func Migrate(ctx context.Context, db *sql.DB) error {
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+	for i, stmt := range migrations {
+		if err := applyMigration(ctx, db, i+1, stmt); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// applyMigration runs one migration unless it is already recorded
+func applyMigration(ctx context.Context, db *sql.DB, version int, stmt string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var applied bool
+	if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)`, version).Scan(&applied); err != nil {
+		return err
+	}
+	if applied {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, stmt); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,40 @@
+// Package sqlite persists the booking database to a local SQLite file, for single-binary
+// deployments that want durability without running a database server. Like database/postgres
+// it returns the tables for sqlstore.Open, which commits every change to the file before
+// answering. The driver uses cgo, so builds need a C compiler.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"booking-system/database/sqlstore"
+
+	_ "github.com/mattn/go-sqlite3" // registers the "sqlite3" driver
+)
+
+// Open opens (creating if needed) the SQLite file at path and applies any pending migrations
+func Open(ctx context.Context, path string) (*sqlstore.Persister, error) {
+	// WAL keeps a commit from blocking readers of the file; the busy timeout covers a second
+	// process holding the write lock
+	dsn := path + "?_journal_mode=WAL&_busy_timeout=5000"
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite %s: %w", path, err)
+	}
+	// a single connection serializes commits instead of failing them with SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("open sqlite %s: %w", path, err)
+	}
+	if err := Migrate(ctx, db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return sqlstore.New(db,
+		`DELETE FROM users`, `DELETE FROM conferences`, `DELETE FROM bookings`,
+		`DELETE FROM reservations`, `DELETE FROM wait_queue`, `DELETE FROM promo_codes`,
+		`DELETE FROM credentials`, `DELETE FROM carts`, `DELETE FROM check_ins`), nil
+}
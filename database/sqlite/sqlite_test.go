@@ -0,0 +1,283 @@
+package sqlite
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"booking-system/database"
+	"booking-system/database/sqlstore"
+	"booking-system/models"
+)
+
+func TestSaveAndLoadRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "booking.db")
+	p, err := Open(ctx, path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	db := database.NewDatabase()
+	defer db.Close()
+	if empty, err := p.Empty(ctx); err != nil || !empty {
+		t.Fatalf("expected a new file to be empty, got %v %v", empty, err)
+	}
+	user, _ := db.CreateUser("Alice", "alice@example.com")
+	waiter, _ := db.CreateUser("Bob", "bob@example.com")
+	booking, err := db.CreateBooking(user.ID, "conf-1", 2, database.BookingOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	res, err := db.CreateReservation(user.ID, "conf-2", 1, database.ReservationOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := db.EnqueueWait(waiter.ID, "conf-1", 1, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tickets, _ := db.BookingTickets(booking.ID)
+	if _, err := db.CheckInTicket(tickets[0].ID, database.CheckInOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := p.Save(ctx, db.Snapshot()); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	// saving again replaces rather than duplicates
+	if err := p.Save(ctx, db.Snapshot()); err != nil {
+		t.Fatalf("second save: %v", err)
+	}
+	p.Close()
+
+	// reopening runs the migrations again as a no-op and reads the same file back
+	p, err = Open(ctx, path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer p.Close()
+	restored := database.NewDatabase()
+	defer restored.Close()
+	if seeded, err := restored.LoadFrom(ctx, p); err != nil || seeded {
+		t.Fatalf("expected state to be loaded, got seeded=%v err=%v", seeded, err)
+	}
+	if _, err := restored.GetUser(user.ID); err != nil {
+		t.Fatalf("expected the user back, got %v", err)
+	}
+	if got := restored.GetBooking(booking.ID); got == nil || got.TicketsBooked != 2 {
+		t.Fatalf("expected the booking back, got %+v", got)
+	}
+	if _, err := restored.GetReservation(res.ID); err != nil {
+		t.Fatalf("expected the active reservation back, got %v", err)
+	}
+	if pos := restored.GetQueuePosition(waiter.ID, "conf-1").Position; pos != 1 {
+		t.Fatalf("expected the queue entry back, got position %d", pos)
+	}
+	if got, _ := restored.GetTicket(tickets[0].ID); got == nil || got.CheckedInAt == nil {
+		t.Fatalf("expected the check-in back, got %+v", got)
+	}
+	if got, _ := restored.GetTicket(tickets[1].ID); got == nil || got.CheckedInAt != nil {
+		t.Fatalf("expected the other ticket still not admitted, got %+v", got)
+	}
+	orig, _ := db.GetConference("conf-1")
+	if got, _ := restored.GetConference("conf-1"); got.AvailableTickets != orig.AvailableTickets {
+		t.Fatalf("expected availability %d, got %d", orig.AvailableTickets, got.AvailableTickets)
+	}
+}
+
+// requireStored fails unless the tables hold exactly the local state
+func requireStored(t *testing.T, p *sqlstore.Persister, local *database.Database) {
+	t.Helper()
+	stored, err := p.Load(context.Background())
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	want := local.Snapshot()
+	for id, q := range want.WaitQueues {
+		if len(q) == 0 {
+			delete(want.WaitQueues, id) // a drained queue has no rows
+		}
+	}
+	for _, table := range []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"users", stored.Users, want.Users},
+		{"credentials", stored.Credentials, want.Credentials},
+		{"conferences", stored.Conferences, want.Conferences},
+		{"bookings", stored.Bookings, want.Bookings},
+		{"reservations", stored.Reservations, want.Reservations},
+		{"wait_queue", stored.WaitQueues, want.WaitQueues},
+		{"promo_codes", stored.PromoCodes, want.PromoCodes},
+		{"carts", stored.Carts, want.Carts},
+		{"check_ins", stored.CheckIns, want.CheckIns},
+	} {
+		got, _ := json.Marshal(table.got)
+		exp, _ := json.Marshal(table.want)
+		if string(got) != string(exp) {
+			t.Fatalf("%s out of step with the local state:\n got %s\nwant %s", table.name, got, exp)
+		}
+	}
+}
+
+func TestStoreCommitsEveryChangeBeforeAnswering(t *testing.T) {
+	ctx := context.Background()
+	p, err := Open(ctx, filepath.Join(t.TempDir(), "booking.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	local := database.NewDatabase()
+	store, seeded, err := sqlstore.Open(ctx, local, p)
+	if err != nil || !seeded {
+		t.Fatalf("expected a new file to be seeded, got seeded=%v err=%v", seeded, err)
+	}
+	defer store.Close()
+	requireStored(t, p, local)
+
+	// a row no change below touches keeps its rowid unless the tables are rewritten wholesale
+	rowid := func() (id int64) {
+		if err := p.DB().QueryRow(`SELECT rowid FROM conferences WHERE id = 'conf-3'`).Scan(&id); err != nil {
+			t.Fatalf("rowid: %v", err)
+		}
+		return id
+	}
+	untouched := rowid()
+
+	alice, err := store.RegisterUser("Alice", "alice@example.com", "secret123", "user")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	bob, _ := store.CreateUser("Bob", "bob@example.com")
+	if _, err := store.SetUserRole(bob.ID, "organizer"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conf, err := store.CreateConference("Tiny", "Lisbon", 2, 100, time.Now().AddDate(0, 1, 0), bob.ID, database.ConferenceOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	booking, err := store.CreateBooking(alice.ID, conf.ID, 2, database.BookingOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := store.EnqueueWait(bob.ID, conf.ID, 1, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	requireStored(t, p, local)
+
+	// cancelling offers the freed seat to the queue head in the same commit
+	if _, err := store.CancelBooking(booking.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	requireStored(t, p, local)
+
+	res, err := store.CreateReservation(alice.ID, "conf-1", 1, database.ReservationOptions{PromoCode: "GOPHER50"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	paid, err := store.ConfirmReservation(res.ID, database.BookingOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tickets, _ := store.BookingTickets(paid.ID)
+	if _, err := store.CheckInTicket(tickets[0].ID, database.CheckInOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cart, _ := store.CreateCart(bob.ID)
+	if _, err := store.SetCartItem(cart.ID, database.ReservationItem{ConferenceID: "conf-2", TicketCount: 1}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, _, err := store.CheckoutCart(cart.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	requireStored(t, p, local)
+
+	if got, _ := store.GetCart(cart.ID); got == nil || got.Status != models.CartStatusReserved {
+		t.Fatalf("expected the checked-out cart read back, got %+v", got)
+	}
+	if got, total := store.GetUserBookings(alice.ID, 1, 0); total != 2 || len(got) != 1 || got[0].ID != paid.ID {
+		t.Fatalf("expected alice's newest booking first of 2, got %d %+v", total, got)
+	}
+	if got := rowid(); got != untouched {
+		t.Fatalf("expected conf-3's row left alone, rowid %d became %d", untouched, got)
+	}
+
+	// an import replaces every table
+	if err := store.Import(store.Snapshot()); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	requireStored(t, p, local)
+}
+
+func TestStoreCommitsHoldsTheServerExpires(t *testing.T) {
+	ctx := context.Background()
+	p, err := Open(ctx, filepath.Join(t.TempDir(), "booking.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	local := database.NewDatabase()
+	local.HoldTime = 50 * time.Millisecond
+	local.SetJanitorInterval(10 * time.Millisecond)
+	store, _, err := sqlstore.Open(ctx, local, p)
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer store.Close()
+
+	user, _ := store.CreateUser("Alice", "alice@example.com")
+	if _, err := store.CreateReservation(user.ID, "conf-1", 1, database.ReservationOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		var held int
+		if err := p.DB().QueryRow(`SELECT COUNT(*) FROM reservations`).Scan(&held); err != nil {
+			t.Fatalf("count: %v", err)
+		}
+		if held == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the expired hold's row deleted, %d left", held)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStoreDiscardsChangesThatFailToCommit(t *testing.T) {
+	ctx := context.Background()
+	p, err := Open(ctx, filepath.Join(t.TempDir(), "booking.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	local := database.NewDatabase()
+	store, _, err := sqlstore.Open(ctx, local, p)
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer store.Close()
+	user, _ := store.CreateUser("Alice", "alice@example.com")
+	before, _ := store.GetConference("conf-1")
+
+	if _, err := p.DB().Exec(`CREATE TRIGGER full BEFORE INSERT ON bookings BEGIN SELECT RAISE(ABORT, 'disk full'); END`); err != nil {
+		t.Fatalf("create trigger: %v", err)
+	}
+	if _, err := store.CreateBooking(user.ID, "conf-1", 2, database.BookingOptions{}); !errors.Is(err, database.ErrUnavailable) {
+		t.Fatalf("expected ErrUnavailable, got %v", err)
+	}
+	if bookings, total := local.GetUserBookings(user.ID, 0, 0); total != 0 {
+		t.Fatalf("expected the uncommitted booking dropped locally too, got %+v", bookings)
+	}
+	if got, _ := local.GetConference("conf-1"); got.AvailableTickets != before.AvailableTickets {
+		t.Fatalf("expected availability back at %d, got %d", before.AvailableTickets, got.AvailableTickets)
+	}
+
+	if _, err := p.DB().Exec(`DROP TRIGGER full`); err != nil {
+		t.Fatalf("drop trigger: %v", err)
+	}
+	if _, err := store.CreateBooking(user.ID, "conf-1", 2, database.BookingOptions{}); err != nil {
+		t.Fatalf("expected the retry to commit, got %v", err)
+	}
+	requireStored(t, p, local)
+}
@@ -0,0 +1,429 @@
+// Package sqlstore keeps the booking state in the table layout shared by the SQL backends
+// (database/postgres and database/sqlite). Each row keeps its full JSON form in a data column;
+// the backends own their schema migrations and connection setup.
+//
+// Store is the database.Store the server runs on: every call that changes something writes
+// the rows it touched in its own transaction before returning, and lookups by ID read the
+// tables. Persister underneath it loads the state at startup and saves it whole, which Store
+// only falls back to when the change it has to write can't be told apart from the rest (after
+// an import or reset).
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"booking-system/database"
+	"booking-system/models"
+)
+
+// Persister is a database.Persister over any driver that accepts $N placeholders
+type Persister struct {
+	db    *sql.DB
+	clear []string
+}
+
+var _ database.Persister = (*Persister)(nil)
+
+// New wraps an open, migrated connection pool. clear holds the statements that empty every
+// table before a save (TRUNCATE on Postgres, DELETE FROM on SQLite).
+func New(db *sql.DB, clear ...string) *Persister {
+	return &Persister{db: db, clear: clear}
+}
+
+// DB returns the underlying connection pool
+func (p *Persister) DB() *sql.DB {
+	return p.db
+}
+
+// Close releases the connection pool
+func (p *Persister) Close() error {
+	return p.db.Close()
+}
+
+// Save replaces the stored state with snap in a single transaction, so readers see either
+// the previous save or this one
+func (p *Persister) Save(ctx context.Context, snap database.Snapshot) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("save: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range p.clear {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("save: clear tables: %w", err)
+		}
+	}
+	for _, u := range snap.Users {
+		if err := insert(ctx, tx, `INSERT INTO users (id, email, created_at, data) VALUES ($1, $2, $3, $4)`,
+			u, u.ID, u.Email, u.Created); err != nil {
+			return fmt.Errorf("save user %s: %w", u.ID, err)
+		}
+	}
+	for _, c := range snap.Conferences {
+		if err := insert(ctx, tx, `INSERT INTO conferences (id, name, date, data) VALUES ($1, $2, $3, $4)`,
+			c, c.ID, c.Name, nullTime(c.Date)); err != nil {
+			return fmt.Errorf("save conference %s: %w", c.ID, err)
+		}
+	}
+	for _, b := range snap.Bookings {
+		if err := insert(ctx, tx, `INSERT INTO bookings (id, user_id, conference_id, status, booked_at, data) VALUES ($1, $2, $3, $4, $5, $6)`,
+			b, b.ID, b.UserID, b.ConferenceID, b.Status, b.BookedAt); err != nil {
+			return fmt.Errorf("save booking %s: %w", b.ID, err)
+		}
+	}
+	for _, r := range snap.Reservations {
+		if err := insert(ctx, tx, `INSERT INTO reservations (id, user_id, conference_id, expires_at, data) VALUES ($1, $2, $3, $4, $5)`,
+			r, r.ID, r.UserID, r.ConferenceID, r.ExpiresAt); err != nil {
+			return fmt.Errorf("save reservation %s: %w", r.ID, err)
+		}
+	}
+	for confID, q := range snap.WaitQueues {
+		for i, e := range q {
+			if err := insert(ctx, tx, `INSERT INTO wait_queue (conference_id, position, id, user_id, data) VALUES ($1, $2, $3, $4, $5)`,
+				e, confID, i+1, e.ID, e.UserID); err != nil {
+				return fmt.Errorf("save queue entry %s: %w", e.ID, err)
+			}
+		}
+	}
+	for code, promo := range snap.PromoCodes {
+		if err := insert(ctx, tx, `INSERT INTO promo_codes (code, data) VALUES ($1, $2)`, promo, code); err != nil {
+			return fmt.Errorf("save promo code %s: %w", code, err)
+		}
+	}
+	for userID, hash := range snap.Credentials {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO credentials (user_id, password_hash) VALUES ($1, $2)`, userID, hash); err != nil {
+			return fmt.Errorf("save credentials for %s: %w", userID, err)
+		}
+	}
+	for _, cart := range snap.Carts {
+		if err := insert(ctx, tx, `INSERT INTO carts (id, user_id, status, updated_at, data) VALUES ($1, $2, $3, $4, $5)`,
+			cart, cart.ID, cart.UserID, cart.Status, cart.UpdatedAt); err != nil {
+			return fmt.Errorf("save cart %s: %w", cart.ID, err)
+		}
+	}
+	for _, in := range snap.CheckIns {
+		if err := insert(ctx, tx, `INSERT INTO check_ins (ticket_id, conference_id, checked_in_at, data) VALUES ($1, $2, $3, $4)`,
+			in, in.TicketID, in.ConferenceID, in.CheckedInAt); err != nil {
+			return fmt.Errorf("save check-in %s: %w", in.TicketID, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("save: %w", err)
+	}
+	return nil
+}
+
+// Write applies a delta from database.ChangesSince in a single transaction: every record in
+// it is upserted, or deleted where it is nil, and every conference's wait queue in it replaces
+// the stored one
+func (p *Persister) Write(ctx context.Context, delta database.Snapshot) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	defer tx.Rollback()
+
+	for id, u := range delta.Users {
+		if u == nil {
+			err = remove(ctx, tx, `DELETE FROM users WHERE id = $1`, id)
+		} else {
+			err = insert(ctx, tx, `INSERT INTO users (id, email, created_at, data) VALUES ($1, $2, $3, $4)
+				ON CONFLICT (id) DO UPDATE SET email = excluded.email, created_at = excluded.created_at, data = excluded.data`,
+				u, u.ID, u.Email, u.Created)
+		}
+		if err != nil {
+			return fmt.Errorf("write user %s: %w", id, err)
+		}
+	}
+	for userID, hash := range delta.Credentials {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO credentials (user_id, password_hash) VALUES ($1, $2)
+			ON CONFLICT (user_id) DO UPDATE SET password_hash = excluded.password_hash`, userID, hash); err != nil {
+			return fmt.Errorf("write credentials for %s: %w", userID, err)
+		}
+	}
+	for id, c := range delta.Conferences {
+		if c == nil {
+			err = remove(ctx, tx, `DELETE FROM conferences WHERE id = $1`, id)
+		} else {
+			err = insert(ctx, tx, `INSERT INTO conferences (id, name, date, data) VALUES ($1, $2, $3, $4)
+				ON CONFLICT (id) DO UPDATE SET name = excluded.name, date = excluded.date, data = excluded.data`,
+				c, c.ID, c.Name, nullTime(c.Date))
+		}
+		if err != nil {
+			return fmt.Errorf("write conference %s: %w", id, err)
+		}
+	}
+	for id, b := range delta.Bookings {
+		if b == nil {
+			err = remove(ctx, tx, `DELETE FROM bookings WHERE id = $1`, id)
+		} else {
+			err = insert(ctx, tx, `INSERT INTO bookings (id, user_id, conference_id, status, booked_at, data) VALUES ($1, $2, $3, $4, $5, $6)
+				ON CONFLICT (id) DO UPDATE SET status = excluded.status, data = excluded.data`,
+				b, b.ID, b.UserID, b.ConferenceID, b.Status, b.BookedAt)
+		}
+		if err != nil {
+			return fmt.Errorf("write booking %s: %w", id, err)
+		}
+	}
+	for id, r := range delta.Reservations {
+		if r == nil {
+			err = remove(ctx, tx, `DELETE FROM reservations WHERE id = $1`, id)
+		} else {
+			err = insert(ctx, tx, `INSERT INTO reservations (id, user_id, conference_id, expires_at, data) VALUES ($1, $2, $3, $4, $5)
+				ON CONFLICT (id) DO UPDATE SET expires_at = excluded.expires_at, data = excluded.data`,
+				r, r.ID, r.UserID, r.ConferenceID, r.ExpiresAt)
+		}
+		if err != nil {
+			return fmt.Errorf("write reservation %s: %w", id, err)
+		}
+	}
+	for confID, q := range delta.WaitQueues {
+		if err := remove(ctx, tx, `DELETE FROM wait_queue WHERE conference_id = $1`, confID); err != nil {
+			return fmt.Errorf("write wait queue %s: %w", confID, err)
+		}
+		for i, e := range q {
+			if err := insert(ctx, tx, `INSERT INTO wait_queue (conference_id, position, id, user_id, data) VALUES ($1, $2, $3, $4, $5)`,
+				e, confID, i+1, e.ID, e.UserID); err != nil {
+				return fmt.Errorf("write queue entry %s: %w", e.ID, err)
+			}
+		}
+	}
+	for code, promo := range delta.PromoCodes {
+		if promo == nil {
+			err = remove(ctx, tx, `DELETE FROM promo_codes WHERE code = $1`, code)
+		} else {
+			err = insert(ctx, tx, `INSERT INTO promo_codes (code, data) VALUES ($1, $2)
+				ON CONFLICT (code) DO UPDATE SET data = excluded.data`, promo, code)
+		}
+		if err != nil {
+			return fmt.Errorf("write promo code %s: %w", code, err)
+		}
+	}
+	for id, cart := range delta.Carts {
+		if cart == nil {
+			err = remove(ctx, tx, `DELETE FROM carts WHERE id = $1`, id)
+		} else {
+			err = insert(ctx, tx, `INSERT INTO carts (id, user_id, status, updated_at, data) VALUES ($1, $2, $3, $4, $5)
+				ON CONFLICT (id) DO UPDATE SET status = excluded.status, updated_at = excluded.updated_at, data = excluded.data`,
+				cart, cart.ID, cart.UserID, cart.Status, cart.UpdatedAt)
+		}
+		if err != nil {
+			return fmt.Errorf("write cart %s: %w", id, err)
+		}
+	}
+	for ticketID, in := range delta.CheckIns {
+		if in == nil {
+			err = remove(ctx, tx, `DELETE FROM check_ins WHERE ticket_id = $1`, ticketID)
+		} else {
+			err = insert(ctx, tx, `INSERT INTO check_ins (ticket_id, conference_id, checked_in_at, data) VALUES ($1, $2, $3, $4)
+				ON CONFLICT (ticket_id) DO UPDATE SET checked_in_at = excluded.checked_in_at, data = excluded.data`,
+				in, in.TicketID, in.ConferenceID, in.CheckedInAt)
+		}
+		if err != nil {
+			return fmt.Errorf("write check-in %s: %w", ticketID, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	return nil
+}
+
+// remove runs a DELETE keyed by id
+func remove(ctx context.Context, tx *sql.Tx, stmt, id string) error {
+	_, err := tx.ExecContext(ctx, stmt, id)
+	return err
+}
+
+// insert runs stmt with args followed by record encoded as JSON
+func insert(ctx context.Context, tx *sql.Tx, stmt string, record interface{}, args ...interface{}) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, stmt, append(args, string(data))...)
+	return err
+}
+
+// nullTime stores an unset time as NULL
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// Load reads the stored state. A database that was never saved to yields an empty snapshot
+// with nil PromoCodes, so restoring it keeps the built-in promo codes.
+func (p *Persister) Load(ctx context.Context) (database.Snapshot, error) {
+	snap := database.Snapshot{
+		SavedAt:      time.Now(),
+		Users:        make(map[string]*models.User),
+		Conferences:  make(map[string]*models.Conference),
+		Bookings:     make(map[string]*models.Booking),
+		Reservations: make(map[string]*models.SeatReservation),
+		WaitQueues:   make(map[string][]*database.WaitEntry),
+		Credentials:  make(map[string]string),
+		Carts:        make(map[string]*models.Cart),
+		CheckIns:     make(map[string]*models.CheckIn),
+	}
+	fail := func(table string, err error) (database.Snapshot, error) {
+		return database.Snapshot{}, fmt.Errorf("load %s: %w", table, err)
+	}
+
+	if err := load(ctx, p.db, `SELECT data FROM users`, func() interface{} { return &models.User{} }, func(v interface{}) {
+		u := v.(*models.User)
+		snap.Users[u.ID] = u
+	}); err != nil {
+		return fail("users", err)
+	}
+	if err := load(ctx, p.db, `SELECT data FROM conferences`, func() interface{} { return &models.Conference{} }, func(v interface{}) {
+		c := v.(*models.Conference)
+		snap.Conferences[c.ID] = c
+	}); err != nil {
+		return fail("conferences", err)
+	}
+	if err := load(ctx, p.db, `SELECT data FROM bookings`, func() interface{} { return &models.Booking{} }, func(v interface{}) {
+		b := v.(*models.Booking)
+		snap.Bookings[b.ID] = b
+	}); err != nil {
+		return fail("bookings", err)
+	}
+	if err := load(ctx, p.db, `SELECT data FROM reservations`, func() interface{} { return &models.SeatReservation{} }, func(v interface{}) {
+		r := v.(*models.SeatReservation)
+		snap.Reservations[r.ID] = r
+	}); err != nil {
+		return fail("reservations", err)
+	}
+	if err := load(ctx, p.db, `SELECT data FROM wait_queue ORDER BY conference_id, position`, func() interface{} { return &database.WaitEntry{} }, func(v interface{}) {
+		e := v.(*database.WaitEntry)
+		snap.WaitQueues[e.ConferenceID] = append(snap.WaitQueues[e.ConferenceID], e)
+	}); err != nil {
+		return fail("wait queue", err)
+	}
+	if err := load(ctx, p.db, `SELECT data FROM promo_codes`, func() interface{} { return &models.PromoCode{} }, func(v interface{}) {
+		promo := v.(*models.PromoCode)
+		if snap.PromoCodes == nil {
+			snap.PromoCodes = make(map[string]*models.PromoCode)
+		}
+		snap.PromoCodes[promo.Code] = promo
+	}); err != nil {
+		return fail("promo codes", err)
+	}
+	if err := loadCredentials(ctx, p.db, snap.Credentials); err != nil {
+		return fail("credentials", err)
+	}
+	if err := load(ctx, p.db, `SELECT data FROM carts`, func() interface{} { return &models.Cart{} }, func(v interface{}) {
+		cart := v.(*models.Cart)
+		snap.Carts[cart.ID] = cart
+	}); err != nil {
+		return fail("carts", err)
+	}
+	if err := load(ctx, p.db, `SELECT data FROM check_ins`, func() interface{} { return &models.CheckIn{} }, func(v interface{}) {
+		in := v.(*models.CheckIn)
+		snap.CheckIns[in.TicketID] = in
+	}); err != nil {
+		return fail("check-ins", err)
+	}
+	return snap, nil
+}
+
+// User reads one user; found is false when there is none
+func (p *Persister) User(ctx context.Context, id string) (user *models.User, found bool, err error) {
+	user = &models.User{}
+	found, err = get(ctx, p.db, user, `SELECT data FROM users WHERE id = $1`, id)
+	return user, found, err
+}
+
+// Conference reads one conference; found is false when there is none
+func (p *Persister) Conference(ctx context.Context, id string) (conf *models.Conference, found bool, err error) {
+	conf = &models.Conference{}
+	found, err = get(ctx, p.db, conf, `SELECT data FROM conferences WHERE id = $1`, id)
+	return conf, found, err
+}
+
+// Booking reads one booking; found is false when there is none
+func (p *Persister) Booking(ctx context.Context, id string) (booking *models.Booking, found bool, err error) {
+	booking = &models.Booking{}
+	found, err = get(ctx, p.db, booking, `SELECT data FROM bookings WHERE id = $1`, id)
+	return booking, found, err
+}
+
+// UserBookings reads every booking of one user, in no particular order
+func (p *Persister) UserBookings(ctx context.Context, userID string) ([]*models.Booking, error) {
+	var bookings []*models.Booking
+	err := load(ctx, p.db, `SELECT data FROM bookings WHERE user_id = $1`, func() interface{} { return &models.Booking{} }, func(v interface{}) {
+		bookings = append(bookings, v.(*models.Booking))
+	}, userID)
+	return bookings, err
+}
+
+// Cart reads one cart; found is false when there is none
+func (p *Persister) Cart(ctx context.Context, id string) (cart *models.Cart, found bool, err error) {
+	cart = &models.Cart{}
+	found, err = get(ctx, p.db, cart, `SELECT data FROM carts WHERE id = $1`, id)
+	return cart, found, err
+}
+
+// get decodes the JSON data column of the one row query returns into record
+func get(ctx context.Context, db *sql.DB, record interface{}, query string, args ...interface{}) (bool, error) {
+	var data []byte
+	err := db.QueryRowContext(ctx, query, args...).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(data, record)
+}
+
+// load decodes the JSON data column of every row query returns into a fresh value from
+// newRecord and hands it to add; args fill in the query's placeholders
+func load(ctx context.Context, db *sql.DB, query string, newRecord func() interface{}, add func(interface{}), args ...interface{}) error {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return err
+		}
+		record := newRecord()
+		if err := json.Unmarshal(data, record); err != nil {
+			return err
+		}
+		add(record)
+	}
+	return rows.Err()
+}
+
+// loadCredentials adds every stored password hash to into, keyed by user ID
+func loadCredentials(ctx context.Context, db *sql.DB, into map[string]string) error {
+	rows, err := db.QueryContext(ctx, `SELECT user_id, password_hash FROM credentials`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var userID, hash string
+		if err := rows.Scan(&userID, &hash); err != nil {
+			return err
+		}
+		into[userID] = hash
+	}
+	return rows.Err()
+}
+
+// Empty reports whether nothing has been saved yet, so a fresh install can keep its sample
+// data instead of restoring an empty state
+func (p *Persister) Empty(ctx context.Context) (bool, error) {
+	var exists bool
+	err := p.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM conferences)`).Scan(&exists)
+	return !exists, err
+}
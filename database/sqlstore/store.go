@@ -0,0 +1,524 @@
+package sqlstore
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"time"
+
+	"booking-system/database"
+	"booking-system/models"
+)
+
+// Each method runs the local Database's method, through write when it changes something, so
+// the booking rules stay in one place. Calls that cannot report an error log it instead.
+
+func (s *Store) CreateUser(name, email string) (user *models.User, err error) {
+	if writeErr := s.write(func() { user, err = s.local.CreateUser(name, email) }); writeErr != nil {
+		return nil, writeErr
+	}
+	return user, err
+}
+
+func (s *Store) CreateUsers(inputs []database.UserInput) (results []database.UserResult) {
+	if err := s.write(func() { results = s.local.CreateUsers(inputs) }); err != nil {
+		results = make([]database.UserResult, len(inputs))
+		for i := range inputs {
+			results[i] = database.UserResult{Index: i, Error: err.Error()}
+		}
+	}
+	return results
+}
+
+func (s *Store) RegisterUser(name, email, password, role string) (user *models.User, err error) {
+	if writeErr := s.write(func() { user, err = s.local.RegisterUser(name, email, password, role) }); writeErr != nil {
+		return nil, writeErr
+	}
+	return user, err
+}
+
+func (s *Store) Authenticate(email, password string) (*models.User, error) {
+	return s.local.Authenticate(email, password)
+}
+
+func (s *Store) GetUser(userID string) (user *models.User, err error) {
+	s.read("user", func(ctx context.Context) error {
+		got, found, readErr := s.p.User(ctx, userID)
+		if readErr != nil {
+			return readErr
+		}
+		if !found {
+			user, err = nil, database.ErrUserNotFound
+		} else {
+			user, err = got, nil
+		}
+		return nil
+	}, func() { user, err = s.local.GetUser(userID) })
+	return user, err
+}
+
+func (s *Store) GetUserByEmail(email string) (user *models.User, ok bool) {
+	user, ok = s.local.GetUserByEmail(email)
+	return user, ok
+}
+
+func (s *Store) GetAllUsers() (users []*models.User) {
+	users = s.local.GetAllUsers()
+	return users
+}
+
+func (s *Store) SetUserRole(userID, role string) (user *models.User, err error) {
+	if writeErr := s.write(func() { user, err = s.local.SetUserRole(userID, role) }); writeErr != nil {
+		return nil, writeErr
+	}
+	return user, err
+}
+
+func (s *Store) SetUserPriority(userID string, priority int) (user *models.User, err error) {
+	if writeErr := s.write(func() { user, err = s.local.SetUserPriority(userID, priority) }); writeErr != nil {
+		return nil, writeErr
+	}
+	return user, err
+}
+
+func (s *Store) GetAllowance(userID, conferenceID string) (allowance database.Allowance, err error) {
+	allowance, err = s.local.GetAllowance(userID, conferenceID)
+	return allowance, err
+}
+
+func (s *Store) CreateConference(name, location string, totalTickets int, price float64, date time.Time, organizerID string, opts database.ConferenceOptions) (conf *models.Conference, err error) {
+	if writeErr := s.write(func() {
+		conf, err = s.local.CreateConference(name, location, totalTickets, price, date, organizerID, opts)
+	}); writeErr != nil {
+		return nil, writeErr
+	}
+	return conf, err
+}
+
+func (s *Store) UpdateConference(conferenceID string, update database.ConferenceUpdate) (conf *models.Conference, err error) {
+	if writeErr := s.write(func() { conf, err = s.local.UpdateConference(conferenceID, update) }); writeErr != nil {
+		return nil, writeErr
+	}
+	return conf, err
+}
+
+func (s *Store) DeleteConference(conferenceID string) (err error) {
+	if writeErr := s.write(func() { err = s.local.DeleteConference(conferenceID) }); writeErr != nil {
+		return writeErr
+	}
+	return err
+}
+
+func (s *Store) GetConference(conferenceID string) (conf *models.Conference, err error) {
+	s.read("conference", func(ctx context.Context) error {
+		got, found, readErr := s.p.Conference(ctx, conferenceID)
+		if readErr != nil {
+			return readErr
+		}
+		if !found {
+			conf, err = nil, database.ErrConferenceNotFound
+		} else {
+			conf, err = got, nil
+		}
+		return nil
+	}, func() { conf, err = s.local.GetConference(conferenceID) })
+	return conf, err
+}
+
+func (s *Store) GetAllConferences() (confs []*models.Conference) {
+	confs = s.local.GetAllConferences()
+	return confs
+}
+
+func (s *Store) QueryConferences(q database.ConferenceQuery) (confs []*models.Conference) {
+	confs = s.local.QueryConferences(q)
+	return confs
+}
+
+func (s *Store) SearchConferences(query string) (matches []database.ConferenceMatch) {
+	matches = s.local.SearchConferences(query)
+	return matches
+}
+
+func (s *Store) SeatMap(conferenceID string) (m *database.SeatMap, err error) {
+	m, err = s.local.SeatMap(conferenceID)
+	return m, err
+}
+
+func (s *Store) GetConferenceStats() (stats map[string]database.ConferenceStats) {
+	stats = s.local.GetConferenceStats()
+	return stats
+}
+
+func (s *Store) GetConferenceAnalytics() (analytics map[string]database.ConferenceAnalytics) {
+	analytics = s.local.GetConferenceAnalytics()
+	return analytics
+}
+
+func (s *Store) ReleaseHoldback(conferenceID string, count int) (conf *models.Conference, err error) {
+	if writeErr := s.write(func() { conf, err = s.local.ReleaseHoldback(conferenceID, count) }); writeErr != nil {
+		return nil, writeErr
+	}
+	return conf, err
+}
+
+func (s *Store) CreateBooking(userID, conferenceID string, ticketCount int, opts database.BookingOptions) (booking *models.Booking, err error) {
+	if writeErr := s.write(func() { booking, err = s.local.CreateBooking(userID, conferenceID, ticketCount, opts) }); writeErr != nil {
+		return nil, writeErr
+	}
+	return booking, err
+}
+
+func (s *Store) CreateBookingIdempotent(key, userID, conferenceID string, ticketCount int, opts database.BookingOptions) (booking *models.Booking, replayed bool, err error) {
+	if writeErr := s.write(func() {
+		booking, replayed, err = s.local.CreateBookingIdempotent(key, userID, conferenceID, ticketCount, opts)
+	}); writeErr != nil {
+		return nil, false, writeErr
+	}
+	return booking, replayed, err
+}
+
+func (s *Store) GetBooking(id string) (booking *models.Booking) {
+	s.read("booking", func(ctx context.Context) error {
+		got, found, err := s.p.Booking(ctx, id)
+		if found {
+			booking = got
+		}
+		return err
+	}, func() { booking = s.local.GetBooking(id) })
+	return booking
+}
+
+func (s *Store) BookingTickets(bookingID string) (tickets []models.Ticket, err error) {
+	tickets, err = s.local.BookingTickets(bookingID)
+	return tickets, err
+}
+
+func (s *Store) GetTicket(id string) (ticket *models.Ticket, err error) {
+	ticket, err = s.local.GetTicket(id)
+	return ticket, err
+}
+
+func (s *Store) CheckInTicket(ticketID string, opts database.CheckInOptions) (ticket *models.Ticket, err error) {
+	if writeErr := s.write(func() { ticket, err = s.local.CheckInTicket(ticketID, opts) }); writeErr != nil {
+		return nil, writeErr
+	}
+	return ticket, err
+}
+
+func (s *Store) CheckInStats(conferenceID string) (stats *database.CheckInStats, err error) {
+	stats, err = s.local.CheckInStats(conferenceID)
+	return stats, err
+}
+
+// GetUserBookings pages through the user's bookings as GetUserBookings on the local state
+// does: newest first, ties broken by ID
+func (s *Store) GetUserBookings(userID string, limit, offset int) (bookings []*models.Booking, total int) {
+	s.read("user bookings", func(ctx context.Context) error {
+		all, err := s.p.UserBookings(ctx, userID)
+		if err != nil {
+			return err
+		}
+		sort.Slice(all, func(i, j int) bool {
+			if !all[i].BookedAt.Equal(all[j].BookedAt) {
+				return all[i].BookedAt.After(all[j].BookedAt)
+			}
+			return all[i].ID < all[j].ID
+		})
+		start := min(max(offset, 0), len(all))
+		end := len(all)
+		if limit > 0 && start+limit < end {
+			end = start + limit
+		}
+		bookings, total = all[start:end], len(all)
+		return nil
+	}, func() { bookings, total = s.local.GetUserBookings(userID, limit, offset) })
+	return bookings, total
+}
+
+func (s *Store) GetAllBookings(filter database.BookingFilter, limit, offset int) (bookings []map[string]interface{}, total int) {
+	bookings, total = s.local.GetAllBookings(filter, limit, offset)
+	return bookings, total
+}
+
+func (s *Store) CancelBooking(bookingID string) (booking *models.Booking, err error) {
+	if writeErr := s.write(func() { booking, err = s.local.CancelBooking(bookingID) }); writeErr != nil {
+		return nil, writeErr
+	}
+	return booking, err
+}
+
+func (s *Store) ExportBookings(conferenceID string) (rows [][]string) {
+	rows = s.local.ExportBookings(conferenceID)
+	return rows
+}
+
+func (s *Store) CreateReservation(userID, conferenceID string, ticketCount int, opts database.ReservationOptions) (res *models.SeatReservation, err error) {
+	if writeErr := s.write(func() { res, err = s.local.CreateReservation(userID, conferenceID, ticketCount, opts) }); writeErr != nil {
+		return nil, writeErr
+	}
+	return res, err
+}
+
+func (s *Store) CreateReservationIdempotent(key, userID, conferenceID string, ticketCount int, opts database.ReservationOptions) (res *models.SeatReservation, replayed bool, err error) {
+	if writeErr := s.write(func() {
+		res, replayed, err = s.local.CreateReservationIdempotent(key, userID, conferenceID, ticketCount, opts)
+	}); writeErr != nil {
+		return nil, false, writeErr
+	}
+	return res, replayed, err
+}
+
+func (s *Store) CreateReservationBundle(userID string, items []database.ReservationItem, opts database.ReservationOptions) (res []*models.SeatReservation, err error) {
+	if writeErr := s.write(func() { res, err = s.local.CreateReservationBundle(userID, items, opts) }); writeErr != nil {
+		return nil, writeErr
+	}
+	return res, err
+}
+
+func (s *Store) GetReservation(reservationID string) (res *models.SeatReservation, err error) {
+	res, err = s.local.GetReservation(reservationID)
+	return res, err
+}
+
+func (s *Store) GetUserReservations(userID string) (res []*models.SeatReservation) {
+	res = s.local.GetUserReservations(userID)
+	return res
+}
+
+func (s *Store) GetUserReservationHistory(userID string) (res []*models.SeatReservation) {
+	res = s.local.GetUserReservationHistory(userID)
+	return res
+}
+
+func (s *Store) ListReservations(filter database.ReservationFilter) (res []*models.SeatReservation) {
+	res = s.local.ListReservations(filter)
+	return res
+}
+
+func (s *Store) ConfirmReservation(reservationID string, opts database.BookingOptions) (booking *models.Booking, err error) {
+	if writeErr := s.write(func() { booking, err = s.local.ConfirmReservation(reservationID, opts) }); writeErr != nil {
+		return nil, writeErr
+	}
+	return booking, err
+}
+
+func (s *Store) CancelReservation(reservationID string) (err error) {
+	if writeErr := s.write(func() { err = s.local.CancelReservation(reservationID) }); writeErr != nil {
+		return writeErr
+	}
+	return err
+}
+
+func (s *Store) ExtendReservationOnce(reservationID string) (res *models.SeatReservation, err error) {
+	if writeErr := s.write(func() { res, err = s.local.ExtendReservationOnce(reservationID) }); writeErr != nil {
+		return nil, writeErr
+	}
+	return res, err
+}
+
+func (s *Store) ExtendReservation(reservationID string) (res *models.SeatReservation, err error) {
+	if writeErr := s.write(func() { res, err = s.local.ExtendReservation(reservationID) }); writeErr != nil {
+		return nil, writeErr
+	}
+	return res, err
+}
+
+func (s *Store) AcceptOffer(reservationID string) (res *models.SeatReservation, err error) {
+	if writeErr := s.write(func() { res, err = s.local.AcceptOffer(reservationID) }); writeErr != nil {
+		return nil, writeErr
+	}
+	return res, err
+}
+
+func (s *Store) AttachPayment(reservationID, paymentID, clientSecret string) (res *models.SeatReservation, err error) {
+	if writeErr := s.write(func() { res, err = s.local.AttachPayment(reservationID, paymentID, clientSecret) }); writeErr != nil {
+		return nil, writeErr
+	}
+	return res, err
+}
+
+func (s *Store) PrepareConfirmation(reservationID string, opts database.BookingOptions) (res *models.SeatReservation, err error) {
+	if writeErr := s.write(func() { res, err = s.local.PrepareConfirmation(reservationID, opts) }); writeErr != nil {
+		return nil, writeErr
+	}
+	return res, err
+}
+
+func (s *Store) CompletePayment(paymentID string) (booking *models.Booking, err error) {
+	if writeErr := s.write(func() { booking, err = s.local.CompletePayment(paymentID) }); writeErr != nil {
+		return nil, writeErr
+	}
+	return booking, err
+}
+
+func (s *Store) CreateCart(userID string) (cart *models.Cart, err error) {
+	if writeErr := s.write(func() { cart, err = s.local.CreateCart(userID) }); writeErr != nil {
+		return nil, writeErr
+	}
+	return cart, err
+}
+
+func (s *Store) GetCart(cartID string) (cart *models.Cart, err error) {
+	s.read("cart", func(ctx context.Context) error {
+		got, found, readErr := s.p.Cart(ctx, cartID)
+		if readErr != nil {
+			return readErr
+		}
+		if !found {
+			cart, err = nil, database.ErrCartNotFound
+		} else {
+			cart, err = got, nil
+		}
+		return nil
+	}, func() { cart, err = s.local.GetCart(cartID) })
+	return cart, err
+}
+
+func (s *Store) SetCartItem(cartID string, item database.ReservationItem) (cart *models.Cart, err error) {
+	if writeErr := s.write(func() { cart, err = s.local.SetCartItem(cartID, item) }); writeErr != nil {
+		return nil, writeErr
+	}
+	return cart, err
+}
+
+func (s *Store) RemoveCartItem(cartID, conferenceID string) (cart *models.Cart, err error) {
+	if writeErr := s.write(func() { cart, err = s.local.RemoveCartItem(cartID, conferenceID) }); writeErr != nil {
+		return nil, writeErr
+	}
+	return cart, err
+}
+
+func (s *Store) CheckoutCart(cartID string) (cart *models.Cart, res []*models.SeatReservation, err error) {
+	if writeErr := s.write(func() { cart, res, err = s.local.CheckoutCart(cartID) }); writeErr != nil {
+		return nil, nil, writeErr
+	}
+	return cart, res, err
+}
+
+func (s *Store) ConfirmCart(cartID string, opts database.BookingOptions) (cart *models.Cart, bookings []*models.Booking, err error) {
+	if writeErr := s.write(func() { cart, bookings, err = s.local.ConfirmCart(cartID, opts) }); writeErr != nil {
+		return nil, nil, writeErr
+	}
+	return cart, bookings, err
+}
+
+func (s *Store) AttachCartPayment(cartID, paymentID, clientSecret string) (cart *models.Cart, err error) {
+	if writeErr := s.write(func() { cart, err = s.local.AttachCartPayment(cartID, paymentID, clientSecret) }); writeErr != nil {
+		return nil, writeErr
+	}
+	return cart, err
+}
+
+func (s *Store) CancelCart(cartID string) (cart *models.Cart, err error) {
+	if writeErr := s.write(func() { cart, err = s.local.CancelCart(cartID) }); writeErr != nil {
+		return nil, writeErr
+	}
+	return cart, err
+}
+
+func (s *Store) ExtensionsLeft(reservation *models.SeatReservation) int {
+	return s.local.ExtensionsLeft(reservation)
+}
+
+func (s *Store) EnqueueWait(userID, conferenceID string, ticketCount int, tier string) (position int, err error) {
+	if writeErr := s.write(func() { position, err = s.local.EnqueueWait(userID, conferenceID, ticketCount, tier) }); writeErr != nil {
+		return 0, writeErr
+	}
+	return position, err
+}
+
+func (s *Store) BulkEnqueue(conferenceID string, requests []database.QueueRequest) (positions []int, err error) {
+	if writeErr := s.write(func() { positions, err = s.local.BulkEnqueue(conferenceID, requests) }); writeErr != nil {
+		return nil, writeErr
+	}
+	return positions, err
+}
+
+func (s *Store) DequeueWait(userID, conferenceID string) (removed bool) {
+	if err := s.write(func() { removed = s.local.DequeueWait(userID, conferenceID) }); err != nil {
+		slog.Error("Dequeue not applied", "user_id", userID, "conference_id", conferenceID, "error", err)
+		return false
+	}
+	return removed
+}
+
+func (s *Store) GetQueuePosition(userID, conferenceID string) (status database.QueueStatus) {
+	status = s.local.GetQueuePosition(userID, conferenceID)
+	return status
+}
+
+func (s *Store) ListQueue(conferenceID string) (entries []database.WaitEntry, err error) {
+	entries, err = s.local.ListQueue(conferenceID)
+	return entries, err
+}
+
+func (s *Store) ClaimNext(userID, conferenceID string, partial bool) (res *models.SeatReservation, err error) {
+	if writeErr := s.write(func() { res, err = s.local.ClaimNext(userID, conferenceID, partial) }); writeErr != nil {
+		return nil, writeErr
+	}
+	return res, err
+}
+
+func (s *Store) JoinWaitingRoom(userID, conferenceID string) (status database.RoomStatus, err error) {
+	if writeErr := s.write(func() { status, err = s.local.JoinWaitingRoom(userID, conferenceID) }); writeErr != nil {
+		return database.RoomStatus{}, writeErr
+	}
+	return status, err
+}
+
+func (s *Store) WaitingRoomStatus(userID, conferenceID string) (status database.RoomStatus, err error) {
+	status, err = s.local.WaitingRoomStatus(userID, conferenceID)
+	return status, err
+}
+
+func (s *Store) Subscribe() (<-chan database.Event, func()) {
+	return s.local.Subscribe()
+}
+
+func (s *Store) GetMetrics() database.Metrics {
+	return s.local.GetMetrics()
+}
+
+func (s *Store) GetAuditLog() []database.AuditEntry {
+	return s.local.GetAuditLog()
+}
+
+func (s *Store) QueryAuditLog(filter database.AuditFilter, limit, offset int) ([]database.AuditEntry, int) {
+	return s.local.QueryAuditLog(filter, limit, offset)
+}
+
+func (s *Store) Drift(other *database.Database) (drift []string) {
+	drift = s.local.Drift(other)
+	return drift
+}
+
+func (s *Store) Inventory(conferenceID string) (report *database.InventoryReport, err error) {
+	report, err = s.local.Inventory(conferenceID)
+	return report, err
+}
+
+func (s *Store) CheckInventory() (problems []string) {
+	problems = s.local.CheckInventory()
+	return problems
+}
+
+// ResetDatabase resets the stored state too
+func (s *Store) ResetDatabase() {
+	if err := s.write(s.local.ResetDatabase); err != nil {
+		slog.Error("Reset not applied", "error", err)
+	}
+}
+
+func (s *Store) Snapshot() (snap database.Snapshot) {
+	snap = s.local.Snapshot()
+	return snap
+}
+
+// Import replaces the stored state too
+func (s *Store) Import(snap database.Snapshot) (err error) {
+	if writeErr := s.write(func() { err = s.local.Import(snap) }); writeErr != nil {
+		return writeErr
+	}
+	return err
+}
@@ -0,0 +1,196 @@
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"booking-system/database"
+	"booking-system/models"
+)
+
+// Store is a database.Store whose state lives in the SQL tables. The local database.Database
+// still runs every call (its locking, holds, queue and booking rules are unchanged), and each
+// call that changes something commits the rows it touched (see database.ChangesSince) in one
+// transaction before returning. Changes the server makes on its own, such as expiring holds
+// and offering the freed seats down the queue, are committed as they happen.
+//
+// If a commit fails the call returns database.ErrUnavailable and the local state is reloaded
+// from the tables, dropping what the call did, so nothing is reported done that a restart
+// would lose.
+type Store struct {
+	local *database.Database
+	p     *Persister
+
+	mu     sync.Mutex            // one call at a time, so each commit holds exactly what it did
+	cursor database.ChangeCursor // the local changes already in the tables
+	stale  bool                  // a commit failed; the tables must be reloaded before the next call
+
+	done      chan struct{}
+	flushed   chan struct{}
+	closeOnce sync.Once
+}
+
+var _ database.Store = (*Store)(nil)
+
+// Open restores local from p, or seeds p with local's state when it is empty so a fresh
+// install keeps its sample data, and from then on writes local's changes through to p.
+// seeded reports which of the two happened.
+func Open(ctx context.Context, local *database.Database, p *Persister) (s *Store, seeded bool, err error) {
+	s = &Store{local: local, p: p, done: make(chan struct{}), flushed: make(chan struct{})}
+	empty, err := p.Empty(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("open store: %w", err)
+	}
+	if empty {
+		s.cursor = local.Cursor() // taken first: changes made during the save are written again
+		if err := p.Save(ctx, local.Snapshot()); err != nil {
+			return nil, false, fmt.Errorf("seed store: %w", err)
+		}
+	} else if err := s.restoreLocked(ctx); err != nil {
+		return nil, false, err
+	}
+
+	events, unsubscribe := local.Subscribe()
+	go s.flushOn(events, unsubscribe)
+	return s, empty, nil
+}
+
+// Close commits anything still pending, stops the local database's background workers and
+// releases the connection pool
+func (s *Store) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		<-s.flushed
+		s.local.Close()
+		s.flush()
+		s.p.Close()
+	})
+}
+
+// unavailable marks err as a failure to reach the tables
+func unavailable(err error) error {
+	return fmt.Errorf("%w: %w", database.ErrUnavailable, err)
+}
+
+// write runs fn against the local state and commits what it changed before returning. An
+// error means fn's changes (if it ran) were not committed and have been discarded.
+func (s *Store) write(fn func()) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), database.PersistTimeout)
+	defer cancel()
+
+	if s.stale {
+		if err := s.restoreLocked(ctx); err != nil {
+			return unavailable(err)
+		}
+	}
+	fn()
+	if err := s.commitLocked(ctx); err != nil {
+		s.discardLocked(ctx, err)
+		return unavailable(err)
+	}
+	return nil
+}
+
+// flush commits the changes made outside any call, such as holds the janitor expired
+func (s *Store) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stale {
+		return // reloaded by the next call, which drops these changes anyway
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), database.PersistTimeout)
+	defer cancel()
+	if err := s.commitLocked(ctx); err != nil {
+		s.discardLocked(ctx, err)
+	}
+}
+
+// flushOn flushes after every local event until Close. A burst of events may be dropped on a
+// full channel, but the flush after the last one still commits them all.
+func (s *Store) flushOn(events <-chan database.Event, unsubscribe func()) {
+	defer close(s.flushed)
+	defer unsubscribe()
+	for {
+		select {
+		case <-events:
+			s.flush()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// commitLocked writes the local changes since the cursor in one transaction. Once the audit
+// log no longer reaches back to the cursor (after an import or reset) it saves everything;
+// caller must hold s.mu.
+func (s *Store) commitLocked(ctx context.Context) error {
+	changes, ok := s.local.ChangesSince(s.cursor)
+	if !ok {
+		next := s.local.Cursor()
+		if err := s.p.Save(ctx, s.local.Snapshot()); err != nil {
+			return err
+		}
+		s.cursor = next
+		return nil
+	}
+	if changes.Next == s.cursor {
+		return nil
+	}
+	if err := s.p.Write(ctx, changes.Delta); err != nil {
+		return err
+	}
+	s.cursor = changes.Next
+	return nil
+}
+
+// discardLocked drops local changes that failed to commit by reloading the tables, or marks the
+// state stale so the next call reloads it; caller must hold s.mu
+func (s *Store) discardLocked(ctx context.Context, cause error) {
+	slog.Error("Storage commit failed; discarding uncommitted changes", "error", cause)
+	s.stale = true
+	if err := s.restoreLocked(ctx); err != nil {
+		slog.Error("Failed to reload from storage", "error", err)
+	}
+}
+
+// restoreLocked replaces the local state with the tables' and deletes the rows of holds that
+// lapsed while nobody was serving them, which the restore drops; caller must hold s.mu unless
+// s is still being opened
+func (s *Store) restoreLocked(ctx context.Context) error {
+	snap, err := s.p.Load(ctx)
+	if err != nil {
+		return err
+	}
+	s.local.Restore(snap)
+	cursor := s.local.Cursor()
+
+	lapsed := database.Snapshot{Reservations: make(map[string]*models.SeatReservation)}
+	for id := range snap.Reservations {
+		if _, err := s.local.GetReservation(id); err != nil {
+			lapsed.Reservations[id] = nil
+		}
+	}
+	if len(lapsed.Reservations) > 0 {
+		if err := s.p.Write(ctx, lapsed); err != nil {
+			return err
+		}
+	}
+	s.cursor = cursor
+	s.stale = false
+	return nil
+}
+
+// read runs a lookup against the tables, answering from the local state when they can't be
+// reached (the two only differ while a commit is in flight)
+func (s *Store) read(what string, query func(ctx context.Context) error, fallback func()) {
+	ctx, cancel := context.WithTimeout(context.Background(), database.PersistTimeout)
+	defer cancel()
+	if err := query(ctx); err != nil {
+		slog.Warn("Storage read failed, answering from local state", "read", what, "error", err)
+		fallback()
+	}
+}
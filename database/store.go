@@ -0,0 +1,105 @@
+package database
+
+import (
+	"time"
+
+	"booking-system/models"
+)
+
+// Store is everything the HTTP handlers need from the booking backend. Database is the
+// in-memory implementation; another backend (Postgres, Redis, ...) satisfies the same
+// contract, including the ErrNotFound/ErrConflict error kinds the handlers map to statuses.
+type Store interface {
+	// Users
+	CreateUser(name, email string) (*models.User, error)
+	CreateUsers(inputs []UserInput) []UserResult
+	RegisterUser(name, email, password, role string) (*models.User, error)
+	Authenticate(email, password string) (*models.User, error)
+	GetUser(userID string) (*models.User, error)
+	GetUserByEmail(email string) (*models.User, bool)
+	GetAllUsers() []*models.User
+	SetUserRole(userID, role string) (*models.User, error)
+	SetUserPriority(userID string, priority int) (*models.User, error)
+	GetAllowance(userID, conferenceID string) (Allowance, error)
+
+	// Conferences
+	CreateConference(name, location string, totalTickets int, price float64, date time.Time, organizerID string, opts ConferenceOptions) (*models.Conference, error)
+	UpdateConference(conferenceID string, update ConferenceUpdate) (*models.Conference, error)
+	DeleteConference(conferenceID string) error
+	GetConference(conferenceID string) (*models.Conference, error)
+	GetAllConferences() []*models.Conference
+	QueryConferences(q ConferenceQuery) []*models.Conference
+	SearchConferences(query string) []ConferenceMatch
+	SeatMap(conferenceID string) (*SeatMap, error)
+	GetConferenceStats() map[string]ConferenceStats
+	GetConferenceAnalytics() map[string]ConferenceAnalytics
+	ReleaseHoldback(conferenceID string, count int) (*models.Conference, error)
+
+	// Bookings
+	CreateBooking(userID, conferenceID string, ticketCount int, opts BookingOptions) (*models.Booking, error)
+	CreateBookingIdempotent(key, userID, conferenceID string, ticketCount int, opts BookingOptions) (booking *models.Booking, replayed bool, err error)
+	GetBooking(id string) *models.Booking
+	GetUserBookings(userID string, limit, offset int) ([]*models.Booking, int)
+	GetAllBookings(filter BookingFilter, limit, offset int) ([]map[string]interface{}, int)
+	CancelBooking(bookingID string) (*models.Booking, error)
+	ExportBookings(conferenceID string) [][]string
+	BookingTickets(bookingID string) ([]models.Ticket, error)
+	GetTicket(id string) (*models.Ticket, error)
+	CheckInTicket(ticketID string, opts CheckInOptions) (*models.Ticket, error)
+	CheckInStats(conferenceID string) (*CheckInStats, error)
+
+	// Reservations
+	CreateReservation(userID, conferenceID string, ticketCount int, opts ReservationOptions) (*models.SeatReservation, error)
+	CreateReservationIdempotent(key, userID, conferenceID string, ticketCount int, opts ReservationOptions) (res *models.SeatReservation, replayed bool, err error)
+	CreateReservationBundle(userID string, items []ReservationItem, opts ReservationOptions) ([]*models.SeatReservation, error)
+	GetReservation(reservationID string) (*models.SeatReservation, error)
+	GetUserReservations(userID string) []*models.SeatReservation
+	GetUserReservationHistory(userID string) []*models.SeatReservation
+	ListReservations(filter ReservationFilter) []*models.SeatReservation
+	ConfirmReservation(reservationID string, opts BookingOptions) (*models.Booking, error)
+	CancelReservation(reservationID string) error
+	ExtendReservationOnce(reservationID string) (*models.SeatReservation, error)
+	ExtendReservation(reservationID string) (*models.SeatReservation, error)
+	AcceptOffer(reservationID string) (*models.SeatReservation, error)
+	AttachPayment(reservationID, paymentID, clientSecret string) (*models.SeatReservation, error)
+	PrepareConfirmation(reservationID string, opts BookingOptions) (*models.SeatReservation, error)
+	CompletePayment(paymentID string) (*models.Booking, error)
+	ExtensionsLeft(reservation *models.SeatReservation) int
+
+	// Carts
+	CreateCart(userID string) (*models.Cart, error)
+	GetCart(cartID string) (*models.Cart, error)
+	SetCartItem(cartID string, item ReservationItem) (*models.Cart, error)
+	RemoveCartItem(cartID, conferenceID string) (*models.Cart, error)
+	CheckoutCart(cartID string) (*models.Cart, []*models.SeatReservation, error)
+	ConfirmCart(cartID string, opts BookingOptions) (*models.Cart, []*models.Booking, error)
+	AttachCartPayment(cartID, paymentID, clientSecret string) (*models.Cart, error)
+	CancelCart(cartID string) (*models.Cart, error)
+
+	// Wait queue
+	EnqueueWait(userID, conferenceID string, ticketCount int, tier string) (int, error)
+	BulkEnqueue(conferenceID string, requests []QueueRequest) ([]int, error)
+	DequeueWait(userID, conferenceID string) bool
+	GetQueuePosition(userID, conferenceID string) QueueStatus
+	ListQueue(conferenceID string) ([]WaitEntry, error)
+	ClaimNext(userID, conferenceID string, partial bool) (*models.SeatReservation, error)
+
+	// Waiting rooms
+	JoinWaitingRoom(userID, conferenceID string) (RoomStatus, error)
+	WaitingRoomStatus(userID, conferenceID string) (RoomStatus, error)
+
+	// Observability and administration
+	Subscribe() (<-chan Event, func())
+	GetMetrics() Metrics
+	GetAuditLog() []AuditEntry
+	QueryAuditLog(filter AuditFilter, limit, offset int) ([]AuditEntry, int)
+	Drift(other *Database) []string
+	Inventory(conferenceID string) (*InventoryReport, error)
+	CheckInventory() []string
+	ResetDatabase()
+	Snapshot() Snapshot
+	Import(snap Snapshot) error
+	Close()
+}
+
+var _ Store = (*Database)(nil)
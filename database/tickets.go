@@ -0,0 +1,91 @@
+package database
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"booking-system/models"
+)
+
+// ErrTicketNotFound is returned for a ticket ID no booking issued
+var ErrTicketNotFound = fmt.Errorf("ticket %w", ErrNotFound)
+
+// ErrTicketVoid is returned when downloading or admitting a ticket whose booking was cancelled
+var ErrTicketVoid = conflictf("ticket is void; its booking was cancelled")
+
+// ticketID names ticket number n of a booking
+func ticketID(bookingID string, n int) string {
+	return bookingID + "-" + strconv.Itoa(n)
+}
+
+// ticketsForLocked lists the tickets a booking issued, one per ticket booked, pairing each
+// with the attendee and seat at the same position; caller must hold the lock
+func (db *Database) ticketsForLocked(b *models.Booking) []models.Ticket {
+	tickets := make([]models.Ticket, b.TicketsBooked)
+	for i := range tickets {
+		t := models.Ticket{
+			ID:           ticketID(b.ID, i+1),
+			BookingID:    b.ID,
+			ConferenceID: b.ConferenceID,
+			UserID:       b.UserID,
+			Number:       i + 1,
+			Tier:         b.Tier,
+			Status:       models.TicketStatusValid,
+			IssuedAt:     b.BookedAt,
+		}
+		if i < len(b.Attendees) {
+			t.Attendee = b.Attendees[i]
+		}
+		if i < len(b.SeatIDs) {
+			t.SeatID = b.SeatIDs[i]
+		}
+		if in, ok := db.CheckIns[t.ID]; ok {
+			at := in.CheckedInAt
+			t.CheckedInAt = &at
+			t.Status = models.TicketStatusCheckedIn
+		}
+		if b.Status == "cancelled" {
+			t.Status = models.TicketStatusVoid
+		}
+		tickets[i] = t
+	}
+	return tickets
+}
+
+// BookingTickets returns the tickets of a booking in number order
+func (db *Database) BookingTickets(bookingID string) ([]models.Ticket, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	b, ok := db.Bookings[bookingID]
+	if !ok {
+		return nil, ErrBookingNotFound
+	}
+	return db.ticketsForLocked(b), nil
+}
+
+// GetTicket looks a ticket up by its ID, as read from a scanned QR code
+func (db *Database) GetTicket(id string) (*models.Ticket, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	return db.ticketLocked(id)
+}
+
+// ticketLocked finds ticket id; caller must hold the lock
+func (db *Database) ticketLocked(id string) (*models.Ticket, error) {
+	i := strings.LastIndexByte(id, '-')
+	if i <= 0 {
+		return nil, ErrTicketNotFound
+	}
+	n, err := strconv.Atoi(id[i+1:])
+	if err != nil {
+		return nil, ErrTicketNotFound
+	}
+	b, ok := db.Bookings[id[:i]]
+	if !ok || n < 1 || n > b.TicketsBooked {
+		return nil, ErrTicketNotFound
+	}
+	t := db.ticketsForLocked(b)[n-1]
+	return &t, nil
+}
@@ -0,0 +1,124 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+
+	"booking-system/models"
+)
+
+// ErrTierNotFound is returned when a booking or reservation names a tier the conference doesn't sell
+var ErrTierNotFound = fmt.Errorf("tier %w", ErrNotFound)
+
+// findTier returns the conference's tier with the given name (case-insensitive), or nil
+func findTier(conf *models.Conference, name string) *models.Tier {
+	for i := range conf.Tiers {
+		if strings.EqualFold(conf.Tiers[i].Name, name) {
+			return &conf.Tiers[i]
+		}
+	}
+	return nil
+}
+
+// pickTier resolves an optional tier name against a conference: it returns the tier (nil for
+// the seats outside every tier, see checkUntieredLocked) and the ticket price that applies
+func pickTier(conf *models.Conference, name string) (*models.Tier, float64, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, conf.Price, nil
+	}
+	tier := findTier(conf, name)
+	if tier == nil {
+		return nil, 0, fmt.Errorf("%w: %s has no %q tier", ErrTierNotFound, conf.ID, name)
+	}
+	return tier, tier.Price, nil
+}
+
+// reservedForTierLocked sums the tickets held by unexpired reservations for one tier;
+// caller must hold the lock
+func (db *Database) reservedForTierLocked(conferenceID, tierName string) int {
+	now := db.now()
+	reserved := 0
+	for _, r := range db.Reservations {
+		if r.ConferenceID == conferenceID && strings.EqualFold(r.Tier, tierName) && now.Before(r.ExpiresAt) {
+			reserved += r.TicketCount
+		}
+	}
+	return reserved
+}
+
+// untieredRoomLocked is how many seats a sale in no tier can take at a tiered conference: the
+// free seats outside every tier, less the holds already made on them. Tier seats are only
+// sold through their tier, so the aggregate never hands them out at the base price. Caller
+// must hold the lock.
+func (db *Database) untieredRoomLocked(conf *models.Conference) int {
+	room := db.availableLocked(conf) - db.reservedForTierLocked(conf.ID, "")
+	for i := range conf.Tiers {
+		room -= db.tierAvailableLocked(conf, &conf.Tiers[i])
+	}
+	return room
+}
+
+// checkUntieredLocked refuses a sale of ticketCount seats in no tier that would reach into
+// the tiers' seats; conferences without tiers sell their whole pool. Caller must hold the lock.
+func (db *Database) checkUntieredLocked(conf *models.Conference, ticketCount int) error {
+	if len(conf.Tiers) == 0 || db.untieredRoomLocked(conf) >= ticketCount {
+		return nil
+	}
+	return soldOutf("not enough tickets available outside the tiers, choose a tier")
+}
+
+// normalizeTiers checks the tiers a conference is created with and returns them trimmed, with
+// every tier's seats available. Names must be unique (case-insensitive) and the tiers may not
+// add up to more seats than the conference has; seats outside every tier stay in the
+// aggregate pool.
+func normalizeTiers(tiers []models.Tier, totalTickets int) ([]models.Tier, error) {
+	var out []models.Tier
+	seats := 0
+	for _, t := range tiers {
+		t.Name = strings.TrimSpace(t.Name)
+		switch {
+		case t.Name == "":
+			return nil, fmt.Errorf("tier name is required")
+		case t.TotalTickets <= 0:
+			return nil, fmt.Errorf("tier %s must have at least one ticket", t.Name)
+		case t.Price < 0:
+			return nil, fmt.Errorf("tier %s price cannot be negative", t.Name)
+		}
+		for _, seen := range out {
+			if strings.EqualFold(seen.Name, t.Name) {
+				return nil, fmt.Errorf("tier %s is listed twice", t.Name)
+			}
+		}
+		seats += t.TotalTickets
+		t.AvailableTickets = t.TotalTickets
+		out = append(out, t)
+	}
+	if seats > totalTickets {
+		return nil, fmt.Errorf("tiers have %d tickets but the conference only %d", seats, totalTickets)
+	}
+	return out, nil
+}
+
+// queueTier resolves the tier a queue entry waits for to the conference's spelling of it;
+// empty stays empty (the aggregate pool)
+func queueTier(conf *models.Conference, name string) (string, error) {
+	tier, _, err := pickTier(conf, name)
+	if err != nil || tier == nil {
+		return "", err
+	}
+	return tier.Name, nil
+}
+
+// queueRoomLocked is how many seats the queue can hand entry right now: the conference's
+// free seats outside active holds and the holdback, and for an entry waiting on a tier no more
+// than that tier has free. Caller must hold the lock.
+func (db *Database) queueRoomLocked(conf *models.Conference, entry *WaitEntry) int {
+	room := db.availableLocked(conf) - conf.ReservedHoldback - db.reservedForConferenceLocked(conf.ID)
+	if tier := findTier(conf, entry.Tier); entry.Tier != "" && tier != nil {
+		room = min(room, db.tierAvailableLocked(conf, tier)-db.reservedForTierLocked(conf.ID, tier.Name))
+	} else if len(conf.Tiers) > 0 {
+		room = min(room, db.untieredRoomLocked(conf))
+	}
+	return room
+}
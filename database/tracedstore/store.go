@@ -0,0 +1,437 @@
+// Package tracedstore wraps a database.Store so each call shows up as a span in the trace of
+// the request that made it, showing where a request's time goes between the handler and the
+// store
+package tracedstore
+
+import (
+	"context"
+	"time"
+
+	"booking-system/database"
+	"booking-system/models"
+	"booking-system/tracing"
+)
+
+// Store is a database.Store recording a "store.<Method>" span around every call. Subscribe,
+// Close and ExtensionsLeft pass straight through.
+type Store struct {
+	inner  database.Store
+	tracer *tracing.Tracer
+	ctx    context.Context
+}
+
+var _ database.Store = (*Store)(nil)
+
+// New wraps inner. Calls made on it directly start traces of their own; use Bind for calls
+// made on behalf of a request.
+func New(inner database.Store, tracer *tracing.Tracer) *Store {
+	return &Store{inner: inner, tracer: tracer, ctx: context.Background()}
+}
+
+// Bind returns a view of the store whose spans are children of the span ctx carries
+func (s *Store) Bind(ctx context.Context) *Store {
+	return &Store{inner: s.inner, tracer: s.tracer, ctx: ctx}
+}
+
+func (s *Store) start(method string) *tracing.Span {
+	_, span := s.tracer.Start(s.ctx, "store."+method, tracing.KindInternal)
+	return span
+}
+
+// end finishes span, marking it failed if err points at an error
+func end(span *tracing.Span, err *error) {
+	if err != nil {
+		span.Finish(*err)
+		return
+	}
+	span.Finish(nil)
+}
+
+// LockSpans turns the database's write-lock timings (see database.SetLockTracer) into
+// "db.write_lock" spans covering how long the lock was held, with the method that held it,
+// how long it waited for it and the request it did it for. They are root spans, as the lock
+// doesn't know which request span it ran under; request_id ties them back.
+func LockSpans(tracer *tracing.Tracer) func(database.LockTiming) {
+	return func(t database.LockTiming) {
+		attrs := []tracing.Attr{
+			{Key: "method", Value: t.Method},
+			{Key: "wait_ms", Value: float64(t.Acquired.Sub(t.Requested).Microseconds()) / 1000},
+		}
+		if t.RequestID != "" {
+			attrs = append(attrs, tracing.Attr{Key: "request_id", Value: t.RequestID})
+		}
+		tracer.Record("db.write_lock", t.Acquired, t.Released, attrs...)
+	}
+}
+
+func (s *Store) CreateUser(name, email string) (user *models.User, err error) {
+	defer end(s.start("CreateUser"), &err)
+	return s.inner.CreateUser(name, email)
+}
+
+func (s *Store) CreateUsers(inputs []database.UserInput) (results []database.UserResult) {
+	defer end(s.start("CreateUsers"), nil)
+	return s.inner.CreateUsers(inputs)
+}
+
+func (s *Store) RegisterUser(name, email, password, role string) (user *models.User, err error) {
+	defer end(s.start("RegisterUser"), &err)
+	return s.inner.RegisterUser(name, email, password, role)
+}
+
+func (s *Store) Authenticate(email, password string) (user *models.User, err error) {
+	defer end(s.start("Authenticate"), &err)
+	return s.inner.Authenticate(email, password)
+}
+
+func (s *Store) GetUser(userID string) (user *models.User, err error) {
+	defer end(s.start("GetUser"), &err)
+	return s.inner.GetUser(userID)
+}
+
+func (s *Store) GetUserByEmail(email string) (user *models.User, ok bool) {
+	defer end(s.start("GetUserByEmail"), nil)
+	return s.inner.GetUserByEmail(email)
+}
+
+func (s *Store) GetAllUsers() (users []*models.User) {
+	defer end(s.start("GetAllUsers"), nil)
+	return s.inner.GetAllUsers()
+}
+
+func (s *Store) SetUserRole(userID, role string) (user *models.User, err error) {
+	defer end(s.start("SetUserRole"), &err)
+	return s.inner.SetUserRole(userID, role)
+}
+
+func (s *Store) SetUserPriority(userID string, priority int) (user *models.User, err error) {
+	defer end(s.start("SetUserPriority"), &err)
+	return s.inner.SetUserPriority(userID, priority)
+}
+
+func (s *Store) GetAllowance(userID, conferenceID string) (allowance database.Allowance, err error) {
+	defer end(s.start("GetAllowance"), &err)
+	return s.inner.GetAllowance(userID, conferenceID)
+}
+
+func (s *Store) CreateConference(name, location string, totalTickets int, price float64, date time.Time, organizerID string, opts database.ConferenceOptions) (conf *models.Conference, err error) {
+	defer end(s.start("CreateConference"), &err)
+	return s.inner.CreateConference(name, location, totalTickets, price, date, organizerID, opts)
+}
+
+func (s *Store) UpdateConference(conferenceID string, update database.ConferenceUpdate) (conf *models.Conference, err error) {
+	defer end(s.start("UpdateConference"), &err)
+	return s.inner.UpdateConference(conferenceID, update)
+}
+
+func (s *Store) DeleteConference(conferenceID string) (err error) {
+	defer end(s.start("DeleteConference"), &err)
+	return s.inner.DeleteConference(conferenceID)
+}
+
+func (s *Store) GetConference(conferenceID string) (conf *models.Conference, err error) {
+	defer end(s.start("GetConference"), &err)
+	return s.inner.GetConference(conferenceID)
+}
+
+func (s *Store) GetAllConferences() (confs []*models.Conference) {
+	defer end(s.start("GetAllConferences"), nil)
+	return s.inner.GetAllConferences()
+}
+
+func (s *Store) QueryConferences(q database.ConferenceQuery) (confs []*models.Conference) {
+	defer end(s.start("QueryConferences"), nil)
+	return s.inner.QueryConferences(q)
+}
+
+func (s *Store) SearchConferences(query string) (matches []database.ConferenceMatch) {
+	defer end(s.start("SearchConferences"), nil)
+	return s.inner.SearchConferences(query)
+}
+
+func (s *Store) SeatMap(conferenceID string) (m *database.SeatMap, err error) {
+	defer end(s.start("SeatMap"), &err)
+	return s.inner.SeatMap(conferenceID)
+}
+
+func (s *Store) GetConferenceStats() (stats map[string]database.ConferenceStats) {
+	defer end(s.start("GetConferenceStats"), nil)
+	return s.inner.GetConferenceStats()
+}
+
+func (s *Store) GetConferenceAnalytics() (analytics map[string]database.ConferenceAnalytics) {
+	defer end(s.start("GetConferenceAnalytics"), nil)
+	return s.inner.GetConferenceAnalytics()
+}
+
+func (s *Store) ReleaseHoldback(conferenceID string, count int) (conf *models.Conference, err error) {
+	defer end(s.start("ReleaseHoldback"), &err)
+	return s.inner.ReleaseHoldback(conferenceID, count)
+}
+
+func (s *Store) CreateBooking(userID, conferenceID string, ticketCount int, opts database.BookingOptions) (booking *models.Booking, err error) {
+	defer end(s.start("CreateBooking"), &err)
+	return s.inner.CreateBooking(userID, conferenceID, ticketCount, opts)
+}
+
+func (s *Store) CreateBookingIdempotent(key, userID, conferenceID string, ticketCount int, opts database.BookingOptions) (booking *models.Booking, replayed bool, err error) {
+	defer end(s.start("CreateBookingIdempotent"), &err)
+	return s.inner.CreateBookingIdempotent(key, userID, conferenceID, ticketCount, opts)
+}
+
+func (s *Store) GetBooking(id string) (booking *models.Booking) {
+	defer end(s.start("GetBooking"), nil)
+	return s.inner.GetBooking(id)
+}
+
+func (s *Store) BookingTickets(bookingID string) (tickets []models.Ticket, err error) {
+	defer end(s.start("BookingTickets"), &err)
+	return s.inner.BookingTickets(bookingID)
+}
+
+func (s *Store) GetTicket(id string) (ticket *models.Ticket, err error) {
+	defer end(s.start("GetTicket"), &err)
+	return s.inner.GetTicket(id)
+}
+
+func (s *Store) CheckInTicket(ticketID string, opts database.CheckInOptions) (ticket *models.Ticket, err error) {
+	defer end(s.start("CheckInTicket"), &err)
+	return s.inner.CheckInTicket(ticketID, opts)
+}
+
+func (s *Store) CheckInStats(conferenceID string) (stats *database.CheckInStats, err error) {
+	defer end(s.start("CheckInStats"), &err)
+	return s.inner.CheckInStats(conferenceID)
+}
+
+func (s *Store) GetUserBookings(userID string, limit, offset int) (bookings []*models.Booking, total int) {
+	defer end(s.start("GetUserBookings"), nil)
+	return s.inner.GetUserBookings(userID, limit, offset)
+}
+
+func (s *Store) GetAllBookings(filter database.BookingFilter, limit, offset int) (bookings []map[string]interface{}, total int) {
+	defer end(s.start("GetAllBookings"), nil)
+	return s.inner.GetAllBookings(filter, limit, offset)
+}
+
+func (s *Store) CancelBooking(bookingID string) (booking *models.Booking, err error) {
+	defer end(s.start("CancelBooking"), &err)
+	return s.inner.CancelBooking(bookingID)
+}
+
+func (s *Store) ExportBookings(conferenceID string) (rows [][]string) {
+	defer end(s.start("ExportBookings"), nil)
+	return s.inner.ExportBookings(conferenceID)
+}
+
+func (s *Store) CreateReservation(userID, conferenceID string, ticketCount int, opts database.ReservationOptions) (res *models.SeatReservation, err error) {
+	defer end(s.start("CreateReservation"), &err)
+	return s.inner.CreateReservation(userID, conferenceID, ticketCount, opts)
+}
+
+func (s *Store) CreateReservationIdempotent(key, userID, conferenceID string, ticketCount int, opts database.ReservationOptions) (res *models.SeatReservation, replayed bool, err error) {
+	defer end(s.start("CreateReservationIdempotent"), &err)
+	return s.inner.CreateReservationIdempotent(key, userID, conferenceID, ticketCount, opts)
+}
+
+func (s *Store) CreateReservationBundle(userID string, items []database.ReservationItem, opts database.ReservationOptions) (res []*models.SeatReservation, err error) {
+	defer end(s.start("CreateReservationBundle"), &err)
+	return s.inner.CreateReservationBundle(userID, items, opts)
+}
+
+func (s *Store) GetReservation(reservationID string) (res *models.SeatReservation, err error) {
+	defer end(s.start("GetReservation"), &err)
+	return s.inner.GetReservation(reservationID)
+}
+
+func (s *Store) GetUserReservations(userID string) (res []*models.SeatReservation) {
+	defer end(s.start("GetUserReservations"), nil)
+	return s.inner.GetUserReservations(userID)
+}
+
+func (s *Store) GetUserReservationHistory(userID string) (res []*models.SeatReservation) {
+	defer end(s.start("GetUserReservationHistory"), nil)
+	return s.inner.GetUserReservationHistory(userID)
+}
+
+func (s *Store) ListReservations(filter database.ReservationFilter) (res []*models.SeatReservation) {
+	defer end(s.start("ListReservations"), nil)
+	return s.inner.ListReservations(filter)
+}
+
+func (s *Store) ConfirmReservation(reservationID string, opts database.BookingOptions) (booking *models.Booking, err error) {
+	defer end(s.start("ConfirmReservation"), &err)
+	return s.inner.ConfirmReservation(reservationID, opts)
+}
+
+func (s *Store) CancelReservation(reservationID string) (err error) {
+	defer end(s.start("CancelReservation"), &err)
+	return s.inner.CancelReservation(reservationID)
+}
+
+func (s *Store) ExtendReservationOnce(reservationID string) (res *models.SeatReservation, err error) {
+	defer end(s.start("ExtendReservationOnce"), &err)
+	return s.inner.ExtendReservationOnce(reservationID)
+}
+
+func (s *Store) ExtendReservation(reservationID string) (res *models.SeatReservation, err error) {
+	defer end(s.start("ExtendReservation"), &err)
+	return s.inner.ExtendReservation(reservationID)
+}
+
+func (s *Store) AcceptOffer(reservationID string) (res *models.SeatReservation, err error) {
+	defer end(s.start("AcceptOffer"), &err)
+	return s.inner.AcceptOffer(reservationID)
+}
+
+func (s *Store) AttachPayment(reservationID, paymentID, clientSecret string) (res *models.SeatReservation, err error) {
+	defer end(s.start("AttachPayment"), &err)
+	return s.inner.AttachPayment(reservationID, paymentID, clientSecret)
+}
+
+func (s *Store) PrepareConfirmation(reservationID string, opts database.BookingOptions) (res *models.SeatReservation, err error) {
+	defer end(s.start("PrepareConfirmation"), &err)
+	return s.inner.PrepareConfirmation(reservationID, opts)
+}
+
+func (s *Store) CompletePayment(paymentID string) (booking *models.Booking, err error) {
+	defer end(s.start("CompletePayment"), &err)
+	return s.inner.CompletePayment(paymentID)
+}
+
+func (s *Store) CreateCart(userID string) (cart *models.Cart, err error) {
+	defer end(s.start("CreateCart"), &err)
+	return s.inner.CreateCart(userID)
+}
+
+func (s *Store) GetCart(cartID string) (cart *models.Cart, err error) {
+	defer end(s.start("GetCart"), &err)
+	return s.inner.GetCart(cartID)
+}
+
+func (s *Store) SetCartItem(cartID string, item database.ReservationItem) (cart *models.Cart, err error) {
+	defer end(s.start("SetCartItem"), &err)
+	return s.inner.SetCartItem(cartID, item)
+}
+
+func (s *Store) RemoveCartItem(cartID, conferenceID string) (cart *models.Cart, err error) {
+	defer end(s.start("RemoveCartItem"), &err)
+	return s.inner.RemoveCartItem(cartID, conferenceID)
+}
+
+func (s *Store) CheckoutCart(cartID string) (cart *models.Cart, res []*models.SeatReservation, err error) {
+	defer end(s.start("CheckoutCart"), &err)
+	return s.inner.CheckoutCart(cartID)
+}
+
+func (s *Store) ConfirmCart(cartID string, opts database.BookingOptions) (cart *models.Cart, bookings []*models.Booking, err error) {
+	defer end(s.start("ConfirmCart"), &err)
+	return s.inner.ConfirmCart(cartID, opts)
+}
+
+func (s *Store) AttachCartPayment(cartID, paymentID, clientSecret string) (cart *models.Cart, err error) {
+	defer end(s.start("AttachCartPayment"), &err)
+	return s.inner.AttachCartPayment(cartID, paymentID, clientSecret)
+}
+
+func (s *Store) CancelCart(cartID string) (cart *models.Cart, err error) {
+	defer end(s.start("CancelCart"), &err)
+	return s.inner.CancelCart(cartID)
+}
+
+func (s *Store) ExtensionsLeft(reservation *models.SeatReservation) int {
+	return s.inner.ExtensionsLeft(reservation)
+}
+
+func (s *Store) EnqueueWait(userID, conferenceID string, ticketCount int, tier string) (position int, err error) {
+	defer end(s.start("EnqueueWait"), &err)
+	return s.inner.EnqueueWait(userID, conferenceID, ticketCount, tier)
+}
+
+func (s *Store) BulkEnqueue(conferenceID string, requests []database.QueueRequest) (positions []int, err error) {
+	defer end(s.start("BulkEnqueue"), &err)
+	return s.inner.BulkEnqueue(conferenceID, requests)
+}
+
+func (s *Store) DequeueWait(userID, conferenceID string) (removed bool) {
+	defer end(s.start("DequeueWait"), nil)
+	return s.inner.DequeueWait(userID, conferenceID)
+}
+
+func (s *Store) GetQueuePosition(userID, conferenceID string) (status database.QueueStatus) {
+	defer end(s.start("GetQueuePosition"), nil)
+	return s.inner.GetQueuePosition(userID, conferenceID)
+}
+
+func (s *Store) ListQueue(conferenceID string) (entries []database.WaitEntry, err error) {
+	defer end(s.start("ListQueue"), &err)
+	return s.inner.ListQueue(conferenceID)
+}
+
+func (s *Store) ClaimNext(userID, conferenceID string, partial bool) (res *models.SeatReservation, err error) {
+	defer end(s.start("ClaimNext"), &err)
+	return s.inner.ClaimNext(userID, conferenceID, partial)
+}
+
+func (s *Store) JoinWaitingRoom(userID, conferenceID string) (status database.RoomStatus, err error) {
+	defer end(s.start("JoinWaitingRoom"), &err)
+	return s.inner.JoinWaitingRoom(userID, conferenceID)
+}
+
+func (s *Store) WaitingRoomStatus(userID, conferenceID string) (status database.RoomStatus, err error) {
+	defer end(s.start("WaitingRoomStatus"), &err)
+	return s.inner.WaitingRoomStatus(userID, conferenceID)
+}
+
+func (s *Store) Subscribe() (<-chan database.Event, func()) {
+	return s.inner.Subscribe()
+}
+
+func (s *Store) GetMetrics() (metrics database.Metrics) {
+	defer end(s.start("GetMetrics"), nil)
+	return s.inner.GetMetrics()
+}
+
+func (s *Store) GetAuditLog() (entries []database.AuditEntry) {
+	defer end(s.start("GetAuditLog"), nil)
+	return s.inner.GetAuditLog()
+}
+
+func (s *Store) QueryAuditLog(filter database.AuditFilter, limit, offset int) (entries []database.AuditEntry, total int) {
+	defer end(s.start("QueryAuditLog"), nil)
+	return s.inner.QueryAuditLog(filter, limit, offset)
+}
+
+func (s *Store) Drift(other *database.Database) (drift []string) {
+	defer end(s.start("Drift"), nil)
+	return s.inner.Drift(other)
+}
+
+func (s *Store) Inventory(conferenceID string) (report *database.InventoryReport, err error) {
+	defer end(s.start("Inventory"), &err)
+	return s.inner.Inventory(conferenceID)
+}
+
+func (s *Store) CheckInventory() []string {
+	defer end(s.start("CheckInventory"), nil)
+	return s.inner.CheckInventory()
+}
+
+func (s *Store) ResetDatabase() {
+	defer end(s.start("ResetDatabase"), nil)
+	s.inner.ResetDatabase()
+}
+
+func (s *Store) Snapshot() database.Snapshot {
+	defer end(s.start("Snapshot"), nil)
+	return s.inner.Snapshot()
+}
+
+func (s *Store) Import(snap database.Snapshot) (err error) {
+	defer end(s.start("Import"), &err)
+	return s.inner.Import(snap)
+}
+
+func (s *Store) Close() {
+	s.inner.Close()
+}
@@ -0,0 +1,44 @@
+package database
+
+import (
+	"math"
+	"time"
+
+	"booking-system/models"
+)
+
+// TurnoverSmoothing is the weight the newest hold gets in a conference's turnover average
+const TurnoverSmoothing = 0.3
+
+// recordTurnoverLocked folds how long a finished hold kept each of its seats (created until
+// confirmed or expired) into the conference's moving average; caller must hold write lock
+func (db *Database) recordTurnoverLocked(reservation *models.SeatReservation, finished time.Time) {
+	if reservation.TicketCount <= 0 {
+		return
+	}
+	perSeat := finished.Sub(reservation.CreatedAt).Seconds() / float64(reservation.TicketCount)
+	if perSeat < 0 {
+		perSeat = 0
+	}
+	if avg, ok := db.turnover[reservation.ConferenceID]; ok {
+		perSeat = TurnoverSmoothing*perSeat + (1-TurnoverSmoothing)*avg
+	}
+	db.turnover[reservation.ConferenceID] = perSeat
+}
+
+// secondsPerSeatLocked is the conference's average turnover per seat, or the full hold time
+// before any hold there has finished; caller must hold the lock
+func (db *Database) secondsPerSeatLocked(conferenceID string) float64 {
+	if avg, ok := db.turnover[conferenceID]; ok {
+		return avg
+	}
+	if conf, ok := db.Conferences[conferenceID]; ok {
+		return db.holdFor(conf).Seconds()
+	}
+	return ReservationHold.Seconds()
+}
+
+// estimateWaitLocked guesses how long until the seats requested ahead of an entry turn over
+func (db *Database) estimateWaitLocked(conferenceID string, ticketsAhead int) int {
+	return int(math.Ceil(float64(ticketsAhead) * db.secondsPerSeatLocked(conferenceID)))
+}
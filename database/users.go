@@ -0,0 +1,45 @@
+package database
+
+import "booking-system/models"
+
+// UserInput is one row of a bulk user import
+type UserInput struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// UserResult reports the outcome of one bulk import row: the created user or why it failed
+type UserResult struct {
+	Index int          `json:"index"`
+	User  *models.User `json:"user,omitempty"`
+	Error string       `json:"error,omitempty"`
+}
+
+// CreateUsers creates each input as a user under a single write lock. Rows fail individually
+// (missing name, malformed email, email already taken by an existing user or an earlier row)
+// without affecting the rest.
+func (db *Database) CreateUsers(inputs []UserInput) []UserResult {
+	db.lock()
+	defer db.unlock()
+
+	results := make([]UserResult, len(inputs))
+	for i, in := range inputs {
+		results[i].Index = i
+		if err := validateUserInput(in); err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		user, err := db.createUserLocked(in.Name, in.Email, models.RoleUser)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].User = user
+	}
+	return results
+}
+
+// validateUserInput checks a bulk row with the same rules as a single signup
+func validateUserInput(in UserInput) error {
+	return models.ValidateUserInput(in.Name, in.Email)
+}
@@ -0,0 +1,212 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"booking-system/models"
+)
+
+// DefaultAdmissionWindow is how long an admitted visitor may hold or book seats when the
+// conference's waiting room doesn't set AdmissionSeconds
+const DefaultAdmissionWindow = 10 * time.Minute
+
+// Bounds on a waiting room's settings
+const (
+	MaxAdmitPerMinute   = 60000
+	MaxAdmissionSeconds = 24 * 60 * 60
+)
+
+// ErrNoWaitingRoom is returned when joining the waiting room of a conference that has none
+var ErrNoWaitingRoom = conflictf("conference has no waiting room")
+
+// RoomVisitor is a user in a conference's waiting room. Their turn is fixed when they join,
+// one admission interval after the visitor before them, so the rate holds however many poll.
+type RoomVisitor struct {
+	UserID     string    `json:"user_id"`
+	JoinedAt   time.Time `json:"joined_at"`
+	AdmitAt    time.Time `json:"admit_at"`    // when they are let in
+	AdmitUntil time.Time `json:"admit_until"` // when their admission lapses
+}
+
+// WaitingRoomLine is a conference's waiting room in admission order. Visitors stay in it until
+// their admission lapses, so joining again meanwhile keeps their place.
+type WaitingRoomLine struct {
+	Visitors []*RoomVisitor `json:"visitors"`
+	NextSlot time.Time      `json:"next_slot"` // the earliest the next visitor to join is let in
+}
+
+// RoomStatus is where a user stands in a conference's waiting room
+type RoomStatus struct {
+	ConferenceID string `json:"conference_id"`
+	Position     int    `json:"position"` // 1-based among the visitors still waiting; 0 when not waiting
+	Waiting      int    `json:"waiting"`  // visitors not let in yet
+	Admitted     bool   `json:"admitted"`
+	// When the user is let in (while waiting) and when that admission lapses
+	AdmitAt              *time.Time `json:"admit_at,omitempty"`
+	AdmitUntil           *time.Time `json:"admit_until,omitempty"`
+	EstimatedWaitSeconds int        `json:"estimated_wait_seconds"`
+}
+
+// normalizeWaitingRoom validates waiting room settings and returns a copy of them; nil or an
+// AdmitPerMinute of zero means no waiting room
+func normalizeWaitingRoom(room *models.WaitingRoom) (*models.WaitingRoom, error) {
+	if room == nil || room.AdmitPerMinute == 0 {
+		return nil, nil
+	}
+	if room.AdmitPerMinute < 0 || room.AdmitPerMinute > MaxAdmitPerMinute {
+		return nil, fmt.Errorf("waiting room must admit between 1 and %d visitors a minute", MaxAdmitPerMinute)
+	}
+	if room.AdmissionSeconds < 0 || room.AdmissionSeconds > MaxAdmissionSeconds {
+		return nil, fmt.Errorf("admission must last between 0 and %d seconds", MaxAdmissionSeconds)
+	}
+	return copyWaitingRoom(room), nil
+}
+
+// copyWaitingRoom copies waiting room settings so callers can't change a conference's
+func copyWaitingRoom(room *models.WaitingRoom) *models.WaitingRoom {
+	if room == nil {
+		return nil
+	}
+	cp := *room
+	return &cp
+}
+
+// admissionWindow is how long a visitor let into room stays admitted
+func admissionWindow(room *models.WaitingRoom) time.Duration {
+	if room.AdmissionSeconds > 0 {
+		return time.Duration(room.AdmissionSeconds) * time.Second
+	}
+	return DefaultAdmissionWindow
+}
+
+// JoinWaitingRoom puts a user in line for a conference's on-sale and reports where they stand.
+// Joining again keeps their place, and once let in they stay admitted for the room's
+// admission window; after it lapses, joining puts them at the back.
+func (db *Database) JoinWaitingRoom(userID, conferenceID string) (RoomStatus, error) {
+	db.lock()
+	defer db.unlock()
+
+	conf, ok := db.Conferences[conferenceID]
+	if !ok {
+		return RoomStatus{}, ErrConferenceNotFound
+	}
+	if conf.WaitingRoom == nil {
+		return RoomStatus{}, ErrNoWaitingRoom
+	}
+	if _, ok := db.Users[userID]; !ok {
+		return RoomStatus{}, ErrUserNotFound
+	}
+	now := db.now()
+	if err := checkUpcoming(conf, now); err != nil {
+		return RoomStatus{}, err
+	}
+	line := db.WaitingRooms[conferenceID]
+	if line == nil {
+		line = &WaitingRoomLine{}
+		db.WaitingRooms[conferenceID] = line
+	}
+	if line.prune(now) {
+		db.writes++
+	}
+	if line.find(userID) == nil {
+		slot := now
+		if line.NextSlot.After(now) {
+			slot = line.NextSlot
+		}
+		line.Visitors = append(line.Visitors, &RoomVisitor{
+			UserID: userID, JoinedAt: now, AdmitAt: slot, AdmitUntil: slot.Add(admissionWindow(conf.WaitingRoom)),
+		})
+		line.NextSlot = slot.Add(conf.WaitingRoom.AdmitInterval())
+		db.writes++
+	}
+	return line.status(conferenceID, userID, now), nil
+}
+
+// WaitingRoomStatus reports where a user stands in a conference's waiting room; Position is
+// zero and Admitted false when they haven't joined or their admission lapsed
+func (db *Database) WaitingRoomStatus(userID, conferenceID string) (RoomStatus, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	conf, ok := db.Conferences[conferenceID]
+	if !ok {
+		return RoomStatus{}, ErrConferenceNotFound
+	}
+	if conf.WaitingRoom == nil {
+		return RoomStatus{}, ErrNoWaitingRoom
+	}
+	line := db.WaitingRooms[conferenceID]
+	if line == nil {
+		return RoomStatus{ConferenceID: conferenceID}, nil
+	}
+	return line.status(conferenceID, userID, db.now()), nil
+}
+
+// WaitingRoomLine returns a copy of a conference's waiting room line, nil when nobody has
+// joined it
+func (db *Database) WaitingRoomLine(conferenceID string) *WaitingRoomLine {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	if line := db.WaitingRooms[conferenceID]; line != nil {
+		return line.clone()
+	}
+	return nil
+}
+
+// clone deep-copies the line
+func (line *WaitingRoomLine) clone() *WaitingRoomLine {
+	cp := WaitingRoomLine{NextSlot: line.NextSlot, Visitors: make([]*RoomVisitor, len(line.Visitors))}
+	for i, v := range line.Visitors {
+		visitor := *v
+		cp.Visitors[i] = &visitor
+	}
+	return &cp
+}
+
+// prune drops visitors whose admission lapsed, reporting whether any were
+func (line *WaitingRoomLine) prune(now time.Time) bool {
+	kept := line.Visitors[:0]
+	for _, v := range line.Visitors {
+		if now.Before(v.AdmitUntil) {
+			kept = append(kept, v)
+		}
+	}
+	pruned := len(kept) != len(line.Visitors)
+	clear(line.Visitors[len(kept):])
+	line.Visitors = kept
+	return pruned
+}
+
+// find returns a user's place in the line, nil when they aren't in it
+func (line *WaitingRoomLine) find(userID string) *RoomVisitor {
+	for _, v := range line.Visitors {
+		if v.UserID == userID {
+			return v
+		}
+	}
+	return nil
+}
+
+// status counts the visitors still waiting and places userID among them
+func (line *WaitingRoomLine) status(conferenceID, userID string, now time.Time) RoomStatus {
+	status := RoomStatus{ConferenceID: conferenceID}
+	for _, v := range line.Visitors {
+		waiting := now.Before(v.AdmitAt)
+		if waiting {
+			status.Waiting++
+		}
+		if v.UserID != userID || !now.Before(v.AdmitUntil) {
+			continue
+		}
+		admitAt, admitUntil := v.AdmitAt, v.AdmitUntil
+		status.AdmitAt, status.AdmitUntil = &admitAt, &admitUntil
+		if waiting {
+			status.Position = status.Waiting
+			status.EstimatedWaitSeconds = int((v.AdmitAt.Sub(now) + time.Second - 1) / time.Second)
+		} else {
+			status.Admitted = true
+		}
+	}
+	return status
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"booking-system/database"
+	"booking-system/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireAdmin guards the admin routes. A request passes with a bearer token of a user with
+// the admin role (403 for other users) or, without an Authorization header, with the
+// X-Admin-Token for scripts (see RequireAdminToken).
+func (app *BookingApp) RequireAdmin(adminToken string) gin.HandlerFunc {
+	return app.requireRole(adminToken, (*models.User).IsAdmin, "admin role required")
+}
+
+// RequireOrganizer guards the conference management routes like RequireAdmin, letting
+// organizers through as well
+func (app *BookingApp) RequireOrganizer(adminToken string) gin.HandlerFunc {
+	return app.requireRole(adminToken, (*models.User).CanOrganize, "organizer role required")
+}
+
+// requireRole passes bearer tokens of users for whom allowed holds, or the admin token
+func (app *BookingApp) requireRole(adminToken string, allowed func(*models.User) bool, denied string) gin.HandlerFunc {
+	byToken := RequireAdminToken(adminToken)
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" {
+			byToken(c)
+			return
+		}
+		user, ok := app.bearerUser(c)
+		if !ok {
+			return
+		}
+		if !allowed(user) {
+			c.AbortWithStatusJSON(http.StatusForbidden, errorBody(c, CodeForbidden, denied))
+			return
+		}
+		c.Set(authUserKey, user)
+		c.Next()
+	}
+}
+
+// ListUsers pages through every user, oldest first
+func (app *BookingApp) ListUsers(c *gin.Context) {
+	limit, offset, ok := pageParams(c)
+	if !ok {
+		return
+	}
+	users := app.store(c).GetAllUsers()
+	total := len(users)
+	start, end := min(offset, total), min(offset+limit, total)
+
+	views := make([]userView, 0, end-start)
+	for _, user := range users[start:end] {
+		views = append(views, userView{User: user, Self: selfLink("users", user.ID)})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"users":  views,
+		"count":  len(views),
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// SetUserRole changes a user's role ({role: "user" | "organizer" | "admin"})
+func (app *BookingApp) SetUserRole(c *gin.Context) {
+	var req struct {
+		Role string `json:"role" binding:"required,oneof=user organizer admin"`
+	}
+	if !bindJSON(c, &req) {
+		return
+	}
+	user, err := app.store(c).SetUserRole(c.Param("userID"), req.Role)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "user": userView{User: user, Self: selfLink("users", user.ID)}})
+}
+
+// SetUserPriority sets the queue priority class the user's own queue joins get ({priority: 0-9}),
+// e.g. for members
+func (app *BookingApp) SetUserPriority(c *gin.Context) {
+	var req struct {
+		Priority *int `json:"priority" binding:"required,min=0,max=9"`
+	}
+	if !bindJSON(c, &req) {
+		return
+	}
+	user, err := app.store(c).SetUserPriority(c.Param("userID"), *req.Priority)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "user": userView{User: user, Self: selfLink("users", user.ID)}})
+}
+
+// allowConference checks the caller may manage conference id: its organizer or an admin.
+// Requests let in by the admin token carry no user and may manage any conference.
+func (app *BookingApp) allowConference(c *gin.Context, id string) bool {
+	user := authUser(c)
+	if user == nil || user.IsAdmin() {
+		return true
+	}
+	conf, err := app.store(c).GetConference(id)
+	if err != nil {
+		respondError(c, err)
+		return false
+	}
+	if conf.OrganizerID != user.ID {
+		c.JSON(http.StatusForbidden, errorBody(c, CodeForbidden, "you can only manage conferences you organize"))
+		return false
+	}
+	return true
+}
+
+// ExportSnapshot downloads the whole state (users, conferences, bookings, holds, queues, promo
+// codes, carts and check-ins) as a JSON snapshot that ImportSnapshot or SNAPSHOT_PATH can load.
+// It carries password hashes, so keep it as safe as the server.
+func (app *BookingApp) ExportSnapshot(c *gin.Context) {
+	snap := app.store(c).Snapshot()
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "snapshot-"+snap.SavedAt.UTC().Format("20060102-150405")+".json"))
+	c.JSON(http.StatusOK, snap)
+}
+
+// ImportSnapshot replaces the whole state with a snapshot from ExportSnapshot. Holds that have
+// expired since it was taken are dropped and the audit log starts afresh; a snapshot that
+// contradicts itself is refused with 400 and changes nothing.
+func (app *BookingApp) ImportSnapshot(c *gin.Context) {
+	var snap database.Snapshot
+	if !bindJSON(c, &snap) {
+		return
+	}
+	if err := app.store(c).Import(snap); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":      "success",
+		"message":     "Snapshot imported.",
+		"users":       len(snap.Users),
+		"conferences": len(snap.Conferences),
+		"bookings":    len(snap.Bookings),
+	})
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"booking-system/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetAuditLog pages through the audit log, newest first. Filters: op (a name such as
+// reservation.expire, or a prefix ending in "." such as reservation.), user_id, conference_id,
+// reservation_id, booking_id, actor, request_id, from/to (RFC3339) and after_seq, which
+// returns only entries recorded since an earlier call saw that seq.
+func (app *BookingApp) GetAuditLog(c *gin.Context) {
+	limit, offset, ok := pageParams(c)
+	if !ok {
+		return
+	}
+	filter := database.AuditFilter{
+		Op:            c.Query("op"),
+		UserID:        c.Query("user_id"),
+		ConferenceID:  c.Query("conference_id"),
+		ReservationID: c.Query("reservation_id"),
+		BookingID:     c.Query("booking_id"),
+		Actor:         c.Query("actor"),
+		RequestID:     c.Query("request_id"),
+	}
+	if filter.From, filter.To, ok = timeRangeParams(c); !ok {
+		return
+	}
+	if v := c.Query("after_seq"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, errorBody(c, CodeBadRequest, "after_seq must be a non-negative integer"))
+			return
+		}
+		filter.AfterSeq = n
+	}
+
+	entries, total := app.store(c).QueryAuditLog(filter, limit, offset)
+	c.JSON(http.StatusOK, gin.H{
+		"entries": entries,
+		"count":   len(entries),
+		"total":   total,
+		"limit":   limit,
+		"offset":  offset,
+	})
+}
+
+// GetInventory replays a conference's inventory (opened, resized, reserved, released,
+// confirmed, sold, refunded) from the audit log and sets what it adds up to against the live
+// counters. consistent is false when a change reached the counters without being logged.
+func (app *BookingApp) GetInventory(c *gin.Context) {
+	report, err := app.store(c).Inventory(c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "inventory": report})
+}
@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"booking-system/database"
+	"booking-system/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// DefaultTokenTTL is how long a login token stays valid unless configured otherwise
+const DefaultTokenTTL = 24 * time.Hour
+
+// tokenIssuer goes in the iss claim and is required when verifying
+const tokenIssuer = "booking-system"
+
+// authUserKey is where RequireAuth leaves the authenticated *models.User in the Gin context
+const authUserKey = "auth_user"
+
+// TokenIssuer signs and verifies the HS256 JWTs handed out by Register and Login
+type TokenIssuer struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+// NewTokenIssuer signs with secret; tokens expire ttl after they are issued
+func NewTokenIssuer(secret []byte, ttl time.Duration) *TokenIssuer {
+	return &TokenIssuer{secret: secret, ttl: ttl}
+}
+
+// RandomSecret returns a fresh 256-bit signing key, for when none is configured; tokens
+// signed with it stop working when the process exits
+func RandomSecret() []byte {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(fmt.Sprintf("read random secret: %v", err))
+	}
+	return secret
+}
+
+// Issue signs a token for userID, returning it with its expiry
+func (t *TokenIssuer) Issue(userID string) (string, time.Time, error) {
+	now := time.Now()
+	expires := now.Add(t.ttl)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Issuer:    tokenIssuer,
+		Subject:   userID,
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(expires),
+	})
+	signed, err := token.SignedString(t.secret)
+	return signed, expires, err
+}
+
+// Verify checks the signature, issuer and expiry of token and returns the user ID it carries
+func (t *TokenIssuer) Verify(token string) (string, error) {
+	var claims jwt.RegisteredClaims
+	_, err := jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (interface{}, error) {
+		return t.secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer(tokenIssuer), jwt.WithExpirationRequired())
+	if err != nil {
+		return "", err
+	}
+	if claims.Subject == "" {
+		return "", errors.New("token has no subject")
+	}
+	return claims.Subject, nil
+}
+
+// UseTokens replaces the token issuer; by default tokens are signed with a per-process
+// random secret
+func (app *BookingApp) UseTokens(tokens *TokenIssuer) {
+	app.tokens = tokens
+}
+
+// UseAdminEmails makes accounts registered with one of emails admins, so a fresh install
+// can bootstrap its first admin. The registration must also carry adminToken in
+// X-Admin-Token; otherwise whoever signed up first with the address would get the role.
+// With an empty adminToken the emails can't be registered at all.
+func (app *BookingApp) UseAdminEmails(emails []string, adminToken string) {
+	app.adminToken = adminToken
+	app.adminEmails = make(map[string]bool, len(emails))
+	for _, email := range emails {
+		app.adminEmails[strings.ToLower(strings.TrimSpace(email))] = true
+	}
+}
+
+// respondWithToken writes user together with a fresh login token
+func (app *BookingApp) respondWithToken(c *gin.Context, code int, user *models.User) {
+	token, expires, err := app.tokens.Issue(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorBody(c, CodeInternal, "could not issue token"))
+		return
+	}
+	c.JSON(code, gin.H{
+		"status":     "success",
+		"user":       userView{User: user, Self: selfLink("users", user.ID)},
+		"token":      token,
+		"token_type": "Bearer",
+		"expires_at": expires,
+	})
+}
+
+// Register creates a user with a password and logs them in
+func (app *BookingApp) Register(c *gin.Context) {
+	var req struct {
+		Name     string `json:"name"`
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	if !bindJSON(c, &req) {
+		return
+	}
+	if err := models.ValidateRegistration(req.Name, req.Email, req.Password); err != nil {
+		respondInvalidFields(c, err)
+		return
+	}
+	role := models.RoleUser
+	if app.adminEmails[strings.ToLower(strings.TrimSpace(req.Email))] {
+		given := c.GetHeader("X-Admin-Token")
+		if app.adminToken == "" || subtle.ConstantTimeCompare([]byte(given), []byte(app.adminToken)) != 1 {
+			c.JSON(http.StatusForbidden, errorBody(c, CodeForbidden, "this email is reserved for an admin: register it with the X-Admin-Token header"))
+			return
+		}
+		role = models.RoleAdmin
+	}
+	user, err := app.store(c).RegisterUser(req.Name, req.Email, req.Password, role)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	setLocation(c, "users", user.ID)
+	app.respondWithToken(c, http.StatusCreated, user)
+}
+
+// Login exchanges an email and password for a token
+func (app *BookingApp) Login(c *gin.Context) {
+	var req struct {
+		Email    string `json:"email" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+	if !bindJSON(c, &req) {
+		return
+	}
+	user, err := app.store(c).Authenticate(req.Email, req.Password)
+	if errors.Is(err, database.ErrInvalidCredentials) {
+		c.JSON(http.StatusUnauthorized, errorBody(c, codeFor(err), err.Error()))
+		return
+	}
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	app.respondWithToken(c, http.StatusOK, user)
+}
+
+// RequireAuth rejects requests without a valid "Authorization: Bearer <token>" header with
+// 401 and puts the token's user in the context for the ownership checks in the handlers
+func (app *BookingApp) RequireAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := app.bearerUser(c)
+		if !ok {
+			return
+		}
+		c.Set(authUserKey, user)
+		c.Next()
+	}
+}
+
+// bearerUser returns the user the request's bearer token was issued to, or aborts with 401
+func (app *BookingApp) bearerUser(c *gin.Context) (*models.User, bool) {
+	scheme, token, _ := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Bearer") || token == "" {
+		unauthorized(c, "missing bearer token")
+		return nil, false
+	}
+	userID, err := app.tokens.Verify(strings.TrimSpace(token))
+	if err != nil {
+		unauthorized(c, "invalid or expired token")
+		return nil, false
+	}
+	user, err := app.store(c).GetUser(userID)
+	if err != nil {
+		unauthorized(c, "user no longer exists")
+		return nil, false
+	}
+	return user, true
+}
+
+func unauthorized(c *gin.Context, msg string) {
+	c.Header("WWW-Authenticate", `Bearer realm="booking-system"`)
+	c.AbortWithStatusJSON(http.StatusUnauthorized, errorBody(c, CodeUnauthorized, msg))
+}
+
+// authUser returns the caller RequireAuth verified, nil when the route isn't behind it
+func authUser(c *gin.Context) *models.User {
+	if v, ok := c.Get(authUserKey); ok {
+		return v.(*models.User)
+	}
+	return nil
+}
+
+// authUserID is the signed-in caller's user ID, empty for the admin token and open routes
+func authUserID(c *gin.Context) string {
+	if user := authUser(c); user != nil {
+		return user.ID
+	}
+	return ""
+}
+
+// allowUser reports whether the caller may act for userID, writing a 403 if not. Admins
+// may act for anyone, and routes without RequireAuth in front allow anyone.
+func allowUser(c *gin.Context, userID string) bool {
+	if user := authUser(c); user != nil && user.ID != userID && !user.IsAdmin() {
+		c.JSON(http.StatusForbidden, errorBody(c, CodeForbidden, "you can only act on your own bookings and reservations"))
+		return false
+	}
+	return true
+}
+
+// allowReservation checks the caller owns reservation id, writing the lookup error or a 403
+// if not. Without RequireAuth in front it skips the lookup and allows anyone.
+func (app *BookingApp) allowReservation(c *gin.Context, id string) bool {
+	if authUser(c) == nil {
+		return true
+	}
+	reservation, err := app.store(c).GetReservation(id)
+	if err != nil {
+		respondReservationLookupError(c, err)
+		return false
+	}
+	return allowUser(c, reservation.UserID)
+}
+
+// allowBooking checks the caller owns booking id, writing a 404 or 403 if not. Without
+// RequireAuth in front it skips the lookup and allows anyone.
+func (app *BookingApp) allowBooking(c *gin.Context, id string) bool {
+	if authUser(c) == nil {
+		return true
+	}
+	booking := app.store(c).GetBooking(id)
+	if booking == nil {
+		respondError(c, database.ErrBookingNotFound)
+		return false
+	}
+	return allowUser(c, booking.UserID)
+}
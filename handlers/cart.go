@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"net/http"
+
+	"booking-system/database"
+	"booking-system/models"
+	"booking-system/payments"
+
+	"github.com/gin-gonic/gin"
+)
+
+// allowCart checks the caller owns cart id, writing a 404 or 403 if not. Without RequireAuth
+// in front it skips the lookup and allows anyone.
+func (app *BookingApp) allowCart(c *gin.Context, id string) bool {
+	if authUser(c) == nil {
+		return true
+	}
+	cart, err := app.store(c).GetCart(id)
+	if err != nil {
+		respondError(c, err)
+		return false
+	}
+	return allowUser(c, cart.UserID)
+}
+
+// respondCart writes a cart with a 200 and message
+func respondCart(c *gin.Context, cart *models.Cart, message string) {
+	c.JSON(http.StatusOK, gin.H{"status": "success", "cart": cart, "message": message})
+}
+
+// CreateCart starts an empty cart for collecting tickets to several conferences
+func (app *BookingApp) CreateCart(c *gin.Context) {
+	var req struct {
+		UserID string `json:"user_id" binding:"required"`
+	}
+	if !bindJSON(c, &req) || !allowUser(c, req.UserID) {
+		return
+	}
+
+	cart, err := app.store(c).CreateCart(req.UserID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"status":  "success",
+		"cart":    cart,
+		"self":    setLocation(c, "carts", cart.ID),
+		"message": "Cart created. Add items, then check out to hold every seat at once.",
+	})
+}
+
+// GetCart returns a cart
+func (app *BookingApp) GetCart(c *gin.Context) {
+	cartID := c.Param("id")
+	if !app.allowCart(c, cartID) {
+		return
+	}
+	cart, err := app.store(c).GetCart(cartID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "cart": cart})
+}
+
+// SetCartItem adds a conference to an open cart or changes how many tickets it has for one
+func (app *BookingApp) SetCartItem(c *gin.Context) {
+	cartID := c.Param("id")
+	var req database.ReservationItem
+	if !bindJSON(c, &req) || !app.allowCart(c, cartID) {
+		return
+	}
+
+	cart, err := app.store(c).SetCartItem(cartID, req)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondCart(c, cart, "Cart updated.")
+}
+
+// RemoveCartItem drops a conference from an open cart
+func (app *BookingApp) RemoveCartItem(c *gin.Context) {
+	cartID := c.Param("id")
+	if !app.allowCart(c, cartID) {
+		return
+	}
+	cart, err := app.store(c).RemoveCartItem(cartID, c.Param("conferenceID"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	respondCart(c, cart, "Item removed.")
+}
+
+// CheckoutCart holds the seats for every item in the cart at once, or none of them. With a
+// payment provider in use the cart also gets one payment intent for its total.
+func (app *BookingApp) CheckoutCart(c *gin.Context) {
+	cartID := c.Param("id")
+	if !app.allowCart(c, cartID) {
+		return
+	}
+	if cart, err := app.store(c).GetCart(cartID); err == nil {
+		conferenceIDs := make([]string, len(cart.Items))
+		for i, item := range cart.Items {
+			conferenceIDs[i] = item.ConferenceID
+		}
+		if !app.requireAdmission(c, cart.UserID, conferenceIDs...) {
+			return
+		}
+	}
+
+	cart, reservations, err := app.store(c).CheckoutCart(cartID)
+	if err != nil {
+		respondReservationError(c, err)
+		return
+	}
+	if cart, ok := app.startCartPayment(c, cart); ok {
+		c.JSON(http.StatusOK, gin.H{
+			"status":       "success",
+			"cart":         cart,
+			"reservations": reservations,
+			"message":      "Every seat in the cart is held. Confirm the cart to pay for them together before expires_at.",
+		})
+	}
+}
+
+// ConfirmCart books every hold in a checked-out cart with one payment. If any hold lapsed or
+// sold out, nothing is booked and the cart reopens with its holds released.
+func (app *BookingApp) ConfirmCart(c *gin.Context) {
+	cartID := c.Param("id")
+	if !app.allowCart(c, cartID) {
+		return
+	}
+	if app.payments != nil {
+		cart, err := app.store(c).GetCart(cartID)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		if cart.Status != models.CartStatusReserved {
+			respondError(c, database.ErrCartNotReserved)
+			return
+		}
+		if cart, ok := app.startCartPayment(c, cart); ok {
+			c.JSON(http.StatusAccepted, gin.H{
+				"status": "pending",
+				"cart":   cart,
+				"payment": gin.H{
+					"id":            cart.PaymentID,
+					"client_secret": cart.PaymentClientSecret,
+				},
+				"message": "Complete the payment; the bookings are confirmed once the payment provider reports it.",
+			})
+		}
+		return
+	}
+
+	cart, bookings, err := app.store(c).ConfirmCart(cartID, database.BookingOptions{RequestID: requestID(c), ActorID: authUserID(c)})
+	if err != nil {
+		respondReservationLookupError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":   "success",
+		"cart":     cart,
+		"bookings": bookings,
+		"message":  "Payment confirmed! Every booking in the cart was created.",
+	})
+}
+
+// CancelCart abandons a cart, releasing any seats it holds
+func (app *BookingApp) CancelCart(c *gin.Context) {
+	cartID := c.Param("id")
+	if !app.allowCart(c, cartID) {
+		return
+	}
+	cart, err := app.store(c).CancelCart(cartID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if app.payments != nil && cart.PaymentID != "" {
+		if err := app.payments.CancelIntent(c.Request.Context(), cart.PaymentID); err != nil {
+			requestLog(c).Warn("Cancel payment failed", "payment_id", cart.PaymentID, "cart_id", cart.ID, "error", err)
+		}
+	}
+	respondCart(c, cart, "Cart cancelled.")
+}
+
+// startCartPayment gives a checked-out cart one payment intent for its total unless it has one
+// or no provider is in use, returning the updated cart. If the provider fails it writes a 502;
+// the holds stay so confirming can try again.
+func (app *BookingApp) startCartPayment(c *gin.Context, cart *models.Cart) (*models.Cart, bool) {
+	if app.payments == nil || cart.PaymentID != "" {
+		return cart, true
+	}
+	intent, err := app.payments.CreateIntent(c.Request.Context(), payments.Charge{
+		Amount:   cart.TotalAmount,
+		Currency: app.currency,
+		CartID:   cart.ID,
+		UserID:   cart.UserID,
+	})
+	if err != nil {
+		requestLog(c).Error("Create payment failed", "cart_id", cart.ID, "error", err)
+		c.JSON(http.StatusBadGateway, errorBody(c, CodePaymentProvider, "could not start the payment, please try again"))
+		return nil, false
+	}
+	updated, err := app.store(c).AttachCartPayment(cart.ID, intent.ID, intent.ClientSecret)
+	if err != nil {
+		respondError(c, err)
+		return nil, false
+	}
+	return updated, true
+}
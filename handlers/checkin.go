@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"booking-system/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CheckIn admits the ticket a scanned QR code names ({code, conference_id?}). The code's
+// signature is checked first, so forged or mistyped codes never reach the store. Organizers
+// may only check in tickets for conferences they organize; naming conference_id also turns
+// away tickets for another event. A ticket gets in once: scanning it again is a 409 that
+// says when it was admitted.
+func (app *BookingApp) CheckIn(c *gin.Context) {
+	var req struct {
+		Code         string `json:"code" binding:"required"`
+		ConferenceID string `json:"conference_id"`
+	}
+	if !bindJSON(c, &req) {
+		return
+	}
+	ticketID, err := app.tickets.Verify(req.Code)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	ticket, err := app.store(c).GetTicket(ticketID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if !app.allowConference(c, ticket.ConferenceID) {
+		return
+	}
+
+	opts := database.CheckInOptions{ConferenceID: req.ConferenceID, StaffID: authUserID(c), RequestID: requestID(c)}
+	ticket, err = app.store(c).CheckInTicket(ticketID, opts)
+	if errors.Is(err, database.ErrAlreadyCheckedIn) {
+		body := errorBody(c, codeFor(err), err.Error())
+		body["ticket"] = ticket
+		c.JSON(http.StatusConflict, body)
+		return
+	}
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "ticket": ticket, "message": "Checked in."})
+}
+
+// GetCheckIns reports a conference's live attendance: tickets issued, how many have been
+// checked in, per tier, and the latest check-ins
+func (app *BookingApp) GetCheckIns(c *gin.Context) {
+	conferenceID := c.Param("id")
+	if !app.allowConference(c, conferenceID) {
+		return
+	}
+	stats, err := app.store(c).CheckInStats(conferenceID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "checkins": stats})
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DrainRetryAfter is the Retry-After, in seconds, sent with requests turned away while
+// draining: long enough for a load balancer to have moved the client to another instance
+const DrainRetryAfter = 5
+
+// StartDraining marks the server as shutting down: RejectWhileDraining turns away new holds,
+// bookings and queue entries and the health check reports 503, while requests already in
+// flight, confirmations and cancellations carry on
+func (app *BookingApp) StartDraining() {
+	app.draining.Store(true)
+}
+
+// Draining reports whether StartDraining was called
+func (app *BookingApp) Draining() bool {
+	return app.draining.Load()
+}
+
+// RejectWhileDraining answers 503 with Retry-After once the server is draining, so nothing
+// new is started that shutdown would cut off
+func (app *BookingApp) RejectWhileDraining() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if app.Draining() {
+			c.Header("Retry-After", strconv.Itoa(DrainRetryAfter))
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, errorBody(c, CodeShuttingDown, "server is shutting down"))
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"booking-system/database"
+	"booking-system/payments"
+	"booking-system/tickets"
+	"booking-system/webhooks"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Error codes every error body carries in "code". Unlike the message they never change, so
+// clients can switch on them. Domain errors get a specific code (see errorCodes); the rest
+// name the kind of failure.
+const (
+	CodeBadRequest        = "bad_request"
+	CodeInvalidJSON       = "invalid_json"
+	CodeValidation        = "validation_failed"
+	CodeUnauthorized      = "unauthorized"
+	CodeForbidden         = "forbidden"
+	CodeNotFound          = "not_found"
+	CodeConflict          = "conflict"
+	CodeBodyTooLarge      = "body_too_large"
+	CodeRateLimited       = "rate_limited"
+	CodeAdmissionRequired = "admission_required"
+	CodeTooManyHeld       = "too_many_held"
+	CodeShuttingDown      = "shutting_down"
+	CodeUnavailable       = "unavailable"
+	CodePaymentProvider   = "payment_provider_error"
+	CodePaymentRequired   = "payment_required"
+	CodeInvalidWebhook    = "invalid_webhook"
+	CodeReplayFailed      = "replay_failed"
+	CodeInternal          = "internal_error"
+)
+
+// errorCodes maps domain errors to their codes, most specific first; the first match wins
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{database.ErrUserNotFound, "user_not_found"},
+	{database.ErrConferenceNotFound, "conference_not_found"},
+	{database.ErrBookingNotFound, "booking_not_found"},
+	{database.ErrReservationNotFound, "reservation_not_found"},
+	{database.ErrTierNotFound, "tier_not_found"},
+	{database.ErrCartNotFound, "cart_not_found"},
+	{database.ErrSeatNotFound, "seat_not_found"},
+	{database.ErrTicketNotFound, "ticket_not_found"},
+	{webhooks.ErrSubscriptionNotFound, "webhook_not_found"},
+	{database.ErrReservationExpired, "reservation_expired"},
+	{database.ErrSeatTaken, "seat_taken"},
+	{database.ErrSoldOut, "sold_out"},
+	{database.ErrNotYourTurn, "not_your_turn"},
+	{database.ErrDroppedFromQueue, "dropped_from_queue"},
+	{database.ErrQueueFull, "queue_full"},
+	{database.ErrAlreadyHasSeats, "already_has_seats"},
+	{database.ErrVersionConflict, "version_conflict"},
+	{database.ErrIdempotencyMismatch, "idempotency_key_reused"},
+	{database.ErrTooManyHeld, CodeTooManyHeld},
+	{database.ErrTicketLimit, "ticket_limit_reached"},
+	{database.ErrExtensionsExhausted, "extensions_exhausted"},
+	{database.ErrMaxHoldReached, "max_hold_reached"},
+	{database.ErrAlreadyExtended, "already_extended"},
+	{database.ErrNotInExtendWindow, "not_in_extend_window"},
+	{database.ErrHoldOversold, "hold_oversold"},
+	{database.ErrNotAnOffer, "not_an_offer"},
+	{database.ErrOfferPending, "offer_pending"},
+	{database.ErrBookingCancelled, "booking_cancelled"},
+	{database.ErrTicketVoid, "ticket_void"},
+	{database.ErrAlreadyCheckedIn, "already_checked_in"},
+	{database.ErrWrongConference, "wrong_conference"},
+	{tickets.ErrBadSignature, "invalid_ticket_code"},
+	{webhooks.ErrInvalidSubscription, "invalid_webhook_subscription"},
+	{database.ErrCapacityBelowSold, "capacity_below_sold"},
+	{database.ErrConferencePast, "conference_past"},
+	{database.ErrNoWaitingRoom, "no_waiting_room"},
+	{database.ErrInvalidPromoCode, "invalid_promo_code"},
+	{database.ErrInvalidAttendees, "invalid_attendees"},
+	{database.ErrInvalidSnapshot, "invalid_snapshot"},
+	{database.ErrInvalidCredentials, "invalid_credentials"},
+	{database.ErrPaymentFailed, "payment_failed"},
+	{database.ErrPaymentMismatch, "payment_mismatch"},
+	{database.ErrCartNotOpen, "cart_not_open"},
+	{database.ErrCartNotReserved, "cart_not_checked_out"},
+	{payments.ErrProvider, CodePaymentProvider},
+	{database.ErrUnavailable, CodeUnavailable},
+	{database.ErrNotFound, CodeNotFound},
+	{database.ErrConflict, CodeConflict},
+}
+
+// codeFor picks err's code from errorCodes, CodeBadRequest when none matches
+func codeFor(err error) string {
+	for _, ec := range errorCodes {
+		if errors.Is(err, ec.err) {
+			return ec.code
+		}
+	}
+	return CodeBadRequest
+}
+
+// statusFor maps a database error to its HTTP status: 404 for missing entities, 410 for an
+// expired hold, 409 for conflicts with existing state (a sold-out conference, someone else's
+// turn in the queue), 402 for a declined payment, 502 when the payment provider fails, 503
+// when a shared backend is unreachable, 400 for everything else
+func statusFor(err error) int {
+	switch {
+	case errors.Is(err, database.ErrPaymentFailed):
+		return http.StatusPaymentRequired
+	case errors.Is(err, database.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, database.ErrReservationExpired):
+		return http.StatusGone
+	case errors.Is(err, database.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, payments.ErrProvider):
+		return http.StatusBadGateway
+	case errors.Is(err, database.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// errorBody is the JSON body of an error response: {status: "error", code, error}, plus the
+// request's ID when RequestID is in use so a report can be matched to the server logs
+func errorBody(c *gin.Context, code, msg string) gin.H {
+	body := gin.H{"status": "error", "code": code, "error": msg}
+	if id := requestID(c); id != "" {
+		body["request_id"] = id
+	}
+	return body
+}
+
+// respondError writes err with the status statusFor and the code codeFor pick. A failed
+// compare-and-swap also carries the conference's current_version, so the client can retry
+// without re-reading.
+func respondError(c *gin.Context, err error) {
+	body := errorBody(c, codeFor(err), err.Error())
+	var conflict *database.VersionConflict
+	if errors.As(err, &conflict) {
+		body["current_version"] = conflict.Current
+	}
+	c.JSON(statusFor(err), body)
+}
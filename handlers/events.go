@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"booking-system/database"
+	"booking-system/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EventsHeartbeat is how often an idle event stream sends a comment line so proxies
+// don't time the connection out
+const EventsHeartbeat = 15 * time.Second
+
+// StreamEvents serves live booking, reservation and queue events as Server-Sent Events.
+// Each event is named after its type and carries the event as JSON. Behind RequireAuth,
+// signed-in users other than admins only see the user, reservation, booking and request IDs
+// of their own events.
+func (app *BookingApp) StreamEvents(c *gin.Context) {
+	viewer := authUser(c)
+	events, unsubscribe := app.store(c).Subscribe()
+	defer unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // keep nginx-style proxies from buffering the stream
+
+	heartbeat := time.NewTicker(EventsHeartbeat)
+	defer heartbeat.Stop()
+
+	// Flush the headers straight away so clients see the stream open before the first event
+	fmt.Fprint(c.Writer, ": connected\n\n")
+	c.Writer.Flush()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return false
+			}
+			c.SSEvent(ev.Type, eventFor(viewer, ev))
+			return true
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+// eventFor is ev as viewer may see it: whole for admins, the event's own user and routes
+// without RequireAuth, otherwise without anything that identifies who acted
+func eventFor(viewer *models.User, ev database.Event) database.Event {
+	if viewer == nil || viewer.IsAdmin() || viewer.ID == ev.UserID {
+		return ev
+	}
+	ev.UserID, ev.ReservationID, ev.BookingID, ev.RequestID = "", "", "", ""
+	return ev
+}
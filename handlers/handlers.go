@@ -1,10 +1,24 @@
 package handlers
 
 import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"booking-system/config"
 	"booking-system/database"
+	"booking-system/database/tracedstore"
+	"booking-system/models"
+	"booking-system/payments"
+	"booking-system/tickets"
+	"booking-system/webhooks"
 
 	"github.com/gin-gonic/gin"
 )
@@ -19,107 +33,539 @@ func min(a, b int) int {
 
 // BookingApp holds the database instance and provides HTTP handlers
 type BookingApp struct {
-	db *database.Database
+	db          database.Store
+	tokens      *TokenIssuer         // signs and checks login tokens; see UseTokens
+	tickets     *tickets.Signer      // signs and checks ticket QR payloads; see UseTickets
+	adminEmails map[string]bool      // registering with one of these makes an admin; see UseAdminEmails
+	adminToken  string               // X-Admin-Token a registration for one of adminEmails must carry
+	payments    payments.Provider    // takes payment for holds when set; see UsePayments
+	currency    string               // ISO currency code payments are taken in
+	traced      *tracedstore.Store   // db with a span per call, bound per request; see UseTracing
+	webhooks    *webhooks.Dispatcher // webhook subscriptions and their delivery; see UseWebhooks
+	admissions  *AdmissionIssuer     // signs and checks waiting room admissions; see UseAdmissions
+	draining    atomic.Bool          // set once shutdown begins; see StartDraining
 }
 
 // NewBookingApp creates a new booking application with database
 func NewBookingApp() *BookingApp {
+	return NewBookingAppWithDatabase(database.NewDatabase())
+}
+
+// NewBookingAppWithDatabase creates a booking application backed by an existing store
+func NewBookingAppWithDatabase(db database.Store) *BookingApp {
 	return &BookingApp{
-		db: database.NewDatabase(),
+		db:         db,
+		tokens:     NewTokenIssuer(RandomSecret(), DefaultTokenTTL),
+		tickets:    tickets.NewSigner(RandomSecret()),
+		webhooks:   webhooks.New(db, webhooks.Options{}),
+		admissions: NewAdmissionIssuer(RandomSecret()),
 	}
 }
 
 // HealthCheck returns the health status of the API
 func (app *BookingApp) HealthCheck(c *gin.Context) {
+	// A draining instance fails its health check so load balancers stop sending it traffic
+	if app.Draining() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status": "draining",
+			"time":   time.Now(),
+		})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"status": "healthy",
 		"time":   time.Now(),
 	})
 }
 
-// GetConferences returns all available conferences
+// conferenceView serializes a conference with its price shown per the requested display mode
+type conferenceView struct {
+	*models.Conference
+	Price     float64 `json:"price"`
+	BasePrice float64 `json:"base_price"`
+}
+
+// APIPrefix is where the API routes are mounted
+const APIPrefix = "/api/v1"
+
+// setLocation points the Location header at a newly created (or already existing) resource
+// and returns its canonical path for the body's self link
+func setLocation(c *gin.Context, collection, id string) string {
+	self := selfLink(collection, id)
+	c.Header("Location", self)
+	return self
+}
+
+// selfLink is the canonical API path of a resource
+func selfLink(collection, id string) string {
+	return APIPrefix + "/" + collection + "/" + id
+}
+
+// userView serializes a user with a link to itself
+type userView struct {
+	*models.User
+	Self string `json:"self"`
+}
+
+// bookingView serializes a booking with a link to itself
+type bookingView struct {
+	*models.Booking
+	Self string `json:"self"`
+}
+
+// ServeConfig returns the effective configuration with secrets redacted
+func ServeConfig(cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "success", "config": cfg.Redacted()})
+	}
+}
+
+// GetConferences returns a page of conferences (?limit=&offset=, with the total matched).
+// Optional filters: location, available=true, from/to (RFC3339) bound the date and
+// min_price/max_price the price; sort and order pick the order.
+// price_display=inclusive shows prices with fees and taxes; exclusive (default) shows the bare price.
 func (app *BookingApp) GetConferences(c *gin.Context) {
-	conferences := app.db.GetAllConferences()
-	stats := app.db.GetConferenceStats()
+	display := c.DefaultQuery("price_display", "exclusive")
+	if display != "exclusive" && display != "inclusive" {
+		c.JSON(http.StatusBadRequest, errorBody(c, CodeBadRequest, "price_display must be inclusive or exclusive"))
+		return
+	}
+	
+	query := database.ConferenceQuery{
+		Location: c.Query("location"),
+		Sort:     strings.ToLower(c.DefaultQuery("sort", database.SortByID)),
+	}
+	if v := c.Query("available"); v != "" {
+		available, err := strconv.ParseBool(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, errorBody(c, CodeBadRequest, "available must be true or false"))
+			return
+		}
+		query.AvailableOnly = available
+	}
+	switch order := strings.ToLower(c.DefaultQuery("order", "asc")); order {
+	case "asc":
+	case "desc":
+		query.Descending = true
+	default:
+		c.JSON(http.StatusBadRequest, errorBody(c, CodeBadRequest, "order must be asc or desc"))
+		return
+	}
+	var warning string
+	if !database.ValidConferenceSort(query.Sort) {
+		warning = fmt.Sprintf("unknown sort %q, sorted by id", query.Sort)
+		query.Sort = database.SortByID
+	}
+	var ok bool
+	if query.From, query.To, ok = timeRangeParams(c); !ok {
+		return
+	}
+	if query.MinPrice, ok = priceParam(c, "min_price"); !ok {
+		return
+	}
+	if query.MaxPrice, ok = priceParam(c, "max_price"); !ok {
+		return
+	}
+	if query.MinPrice != nil && query.MaxPrice != nil && *query.MinPrice > *query.MaxPrice {
+		c.JSON(http.StatusBadRequest, errorBody(c, CodeBadRequest, "min_price must not be above max_price"))
+		return
+	}
+	limit, offset, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	conferences := app.store(c).QueryConferences(query)
+	total := len(conferences)
+	conferences = conferences[min(offset, total):min(offset+limit, total)]
+	views := make([]conferenceView, 0, len(conferences))
+	for _, conf := range conferences {
+		view := conferenceView{Conference: conf, Price: conf.Price, BasePrice: conf.Price}
+		if display == "inclusive" {
+			view.Price = conf.PriceWithFees()
+		}
+		views = append(views, view)
+	}
+	stats := app.store(c).GetConferenceStats()
+	resp := gin.H{
+		"conferences":   views,
+		"count":         len(views),
+		"total":         total,
+		"limit":         limit,
+		"offset":        offset,
+		"stats":         stats,
+		"price_display": display,
+		"sort":          query.Sort,
+	}
+	if warning != "" {
+		resp["warning"] = warning
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// searchResult is a conference found by SearchConferences with its relevance score
+type searchResult struct {
+	*models.Conference
+	Score float64 `json:"score"`
+}
+
+// SearchConferences finds conferences by the words of ?q= in their name, location, tags and
+// description, most relevant first, a page at a time (?limit=&offset=, with the total found)
+func (app *BookingApp) SearchConferences(c *gin.Context) {
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		c.JSON(http.StatusBadRequest, errorBody(c, CodeBadRequest, "q is required"))
+		return
+	}
+	limit, offset, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	matches := app.store(c).SearchConferences(query)
+	total := len(matches)
+	matches = matches[min(offset, total):min(offset+limit, total)]
+	results := make([]searchResult, 0, len(matches))
+	for _, m := range matches {
+		results = append(results, searchResult{Conference: m.Conference, Score: m.Score})
+	}
 	c.JSON(http.StatusOK, gin.H{
-		"conferences": conferences,
-		"count":       len(conferences),
-		"stats":       stats,
+		"query":   query,
+		"results": results,
+		"count":   len(results),
+		"total":   total,
+		"limit":   limit,
+		"offset":  offset,
+	})
+}
+
+// GetSeatMap lists every seat of a conference as free, held or booked, for picking seat_ids
+// when reserving; a conference without a seat map has no seats
+func (app *BookingApp) GetSeatMap(c *gin.Context) {
+	seats, err := app.store(c).SeatMap(c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, seats)
+}
+
+// CreateConference adds a conference at runtime (organizers and admins); the caller becomes its organizer
+func (app *BookingApp) CreateConference(c *gin.Context) {
+	var req struct {
+		Name         string    `json:"name" binding:"required"`
+		Location     string    `json:"location" binding:"required"`
+		TotalTickets int       `json:"total_tickets" binding:"required,min=1"`
+		Price        float64   `json:"price" binding:"min=0"`
+		Date         time.Time `json:"date" binding:"required"`
+		Description  string    `json:"description" binding:"max=2000"`
+		Tags         []string  `json:"tags" binding:"max=20,dive,max=50"`
+		Tiers        []struct {
+			Name         string  `json:"name" binding:"required"`
+			Price        float64 `json:"price" binding:"min=0"`
+			TotalTickets int     `json:"total_tickets" binding:"required,min=1"`
+		} `json:"tiers" binding:"max=10,dive"` // e.g. VIP / standard / student, each with its own price and seats
+		// Optional seat map for assigned seating; its seats must add up to total_tickets
+		Sections []struct {
+			Name        string `json:"name" binding:"required,max=50"`
+			Rows        int    `json:"rows" binding:"required,min=1,max=500"`
+			SeatsPerRow int    `json:"seats_per_row" binding:"required,min=1,max=500"`
+		} `json:"sections" binding:"max=50,dive"`
+		// Sell only to visitors let in through a waiting room at this rate
+		WaitingRoom *waitingRoomRequest `json:"waiting_room"`
+	}
+	if !bindJSON(c, &req) {
+		return
+	}
+	tiers := make([]models.Tier, len(req.Tiers))
+	for i, t := range req.Tiers {
+		tiers[i] = models.Tier{Name: t.Name, Price: t.Price, TotalTickets: t.TotalTickets}
+	}
+	sections := make([]models.Section, len(req.Sections))
+	for i, s := range req.Sections {
+		sections[i] = models.Section{Name: s.Name, Rows: s.Rows, SeatsPerRow: s.SeatsPerRow}
+	}
+	organizerID := ""
+	if user := authUser(c); user != nil {
+		organizerID = user.ID
+	}
+	conf, err := app.store(c).CreateConference(req.Name, req.Location, req.TotalTickets, req.Price, req.Date, organizerID,
+		database.ConferenceOptions{Description: req.Description, Tags: req.Tags, Tiers: tiers, Sections: sections, WaitingRoom: req.WaitingRoom.settings()})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"status": "success", "conference": conf})
+}
+
+// UpdateConference changes a conference's details, price and/or capacity (its organizer or an admin)
+func (app *BookingApp) UpdateConference(c *gin.Context) {
+	var req struct {
+		Name            *string    `json:"name" binding:"omitempty,min=1"`
+		Location        *string    `json:"location" binding:"omitempty,min=1"`
+		Date            *time.Time `json:"date"`
+		Price           *float64   `json:"price" binding:"omitempty,min=0"`
+		TotalTickets    *int       `json:"total_tickets" binding:"omitempty,min=1"`
+		HoldSeconds     *int       `json:"hold_seconds" binding:"omitempty,min=0,max=3600"`
+		Description     *string    `json:"description" binding:"omitempty,max=2000"`
+		Tags            *[]string  `json:"tags" binding:"omitempty,max=20,dive,max=50"`
+		WaitingRoom     *waitingRoomRequest `json:"waiting_room"` // admit_per_minute 0 turns the room off
+		ExpectedVersion *int       `json:"expected_version"` // compare-and-swap against Conference.Version
+	}
+	if !bindJSON(c, &req) {
+		return
+	}
+	if req.Name == nil && req.Location == nil && req.Date == nil && req.Price == nil && req.TotalTickets == nil && req.HoldSeconds == nil &&
+		req.Description == nil && req.Tags == nil && req.WaitingRoom == nil {
+		c.JSON(http.StatusBadRequest, errorBody(c, CodeBadRequest, "nothing to update: set name, location, date, price, total_tickets, hold_seconds, description, tags and/or waiting_room"))
+		return
+	}
+	if !app.allowConference(c, c.Param("id")) {
+		return
+	}
+	conf, err := app.store(c).UpdateConference(c.Param("id"), database.ConferenceUpdate{
+		Name:            req.Name,
+		Location:        req.Location,
+		Date:            req.Date,
+		Price:           req.Price,
+		TotalTickets:    req.TotalTickets,
+		HoldSeconds:     req.HoldSeconds,
+		Description:     req.Description,
+		Tags:            req.Tags,
+		WaitingRoom:     req.WaitingRoom.settings(),
+		ExpectedVersion: req.ExpectedVersion,
 	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "conference": conf})
+}
+
+// DeleteConference removes a conference without confirmed bookings (its organizer or an admin)
+func (app *BookingApp) DeleteConference(c *gin.Context) {
+	if !app.allowConference(c, c.Param("id")) {
+		return
+	}
+	if err := app.store(c).DeleteConference(c.Param("id")); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "message": "Conference deleted."})
+}
+
+// GetAnalytics returns sales analytics for every conference, ordered by conference ID
+func (app *BookingApp) GetAnalytics(c *gin.Context) {
+	stats := app.store(c).GetConferenceAnalytics()
+	ids := make([]string, 0, len(stats))
+	for id := range stats {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	analytics := make([]database.ConferenceAnalytics, 0, len(ids))
+	for _, id := range ids {
+		analytics = append(analytics, stats[id])
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "analytics": analytics, "count": len(analytics)})
+}
+
+// GetConferenceAnalytics returns sales analytics for one conference
+func (app *BookingApp) GetConferenceAnalytics(c *gin.Context) {
+	stats, ok := app.store(c).GetConferenceAnalytics()[c.Param("id")]
+	if !ok {
+		respondError(c, database.ErrConferenceNotFound)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "analytics": stats})
 }
 
 // CreateUser creates a new user account
 func (app *BookingApp) CreateUser(c *gin.Context) {
 	var req struct {
-		Name  string `json:"name" binding:"required"`
-		Email string `json:"email" binding:"required,email"`
+		Name  string `json:"name"`
+		Email string `json:"email"`
 	}
 	
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindJSON(c, &req) {
+		return
+	}
+	if err := models.ValidateUserInput(req.Name, req.Email); err != nil {
+		respondInvalidFields(c, err)
 		return
 	}
 	
 	// If user exists by email, return 409 with existing user to keep entries unique
-	if existing, ok := app.db.GetUserByEmail(req.Email); ok {
-		c.JSON(http.StatusConflict, existing)
+	if existing, ok := app.store(c).GetUserByEmail(req.Email); ok {
+		self := setLocation(c, "users", existing.ID)
+		c.JSON(http.StatusConflict, userView{User: existing, Self: self})
 		return
 	}
 
-	user, err := app.db.CreateUser(req.Name, req.Email)
+	user, err := app.store(c).CreateUser(req.Name, req.Email)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusCreated, user)
+	self := setLocation(c, "users", user.ID)
+	c.JSON(http.StatusCreated, userView{User: user, Self: self})
 }
 
-// GetUserBookings returns all bookings for a specific user
+// GetUser returns a single user by ID
+func (app *BookingApp) GetUser(c *gin.Context) {
+	userID := c.Param("userID")
+	if !allowUser(c, userID) {
+		return
+	}
+	user, err := app.store(c).GetUser(userID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, userView{User: user, Self: selfLink("users", user.ID)})
+}
+
+// FindUser looks a user up by email (?email=), matched case- and whitespace-insensitively
+// like signup does
+func (app *BookingApp) FindUser(c *gin.Context) {
+	email := c.Query("email")
+	if strings.TrimSpace(email) == "" {
+		c.JSON(http.StatusBadRequest, errorBody(c, CodeBadRequest, "email query parameter required"))
+		return
+	}
+	// Checked before the lookup so a 404 doesn't tell others which emails have accounts
+	if caller := authUser(c); caller != nil && !caller.IsAdmin() && !strings.EqualFold(strings.TrimSpace(email), caller.Email) {
+		c.JSON(http.StatusForbidden, errorBody(c, CodeForbidden, "you can only look up your own account"))
+		return
+	}
+	user, ok := app.store(c).GetUserByEmail(email)
+	if !ok {
+		respondError(c, database.ErrUserNotFound)
+		return
+	}
+	c.JSON(http.StatusOK, userView{User: user, Self: selfLink("users", user.ID)})
+}
+
+// MaxBulkUsers caps how many users one bulk import may create
+const MaxBulkUsers = 1000
+
+// CreateUsers imports a JSON array of {name, email} users and reports a result per row.
+// Responds 201 when every row was created and 207 Multi-Status when any row failed.
+func (app *BookingApp) CreateUsers(c *gin.Context) {
+	var inputs []database.UserInput
+	if !bindJSON(c, &inputs) {
+		return
+	}
+	if len(inputs) == 0 || len(inputs) > MaxBulkUsers {
+		c.JSON(http.StatusBadRequest, errorBody(c, CodeBadRequest, fmt.Sprintf("send between 1 and %d users", MaxBulkUsers)))
+		return
+	}
+
+	results := app.store(c).CreateUsers(inputs)
+	created := 0
+	for _, r := range results {
+		if r.User != nil {
+			created++
+		}
+	}
+	code, status := http.StatusCreated, "success"
+	if created < len(results) {
+		code, status = http.StatusMultiStatus, "partial"
+	}
+	c.JSON(code, gin.H{
+		"status":  status,
+		"results": results,
+		"created": created,
+		"failed":  len(results) - created,
+	})
+}
+
+// GetUserBookings returns a page of bookings for a specific user (?limit=&offset=)
 func (app *BookingApp) GetUserBookings(c *gin.Context) {
+	limit, offset, ok := pageParams(c)
+	if !ok {
+		return
+	}
 	userID := c.Param("userID")
-	bookings := app.db.GetUserBookings(userID)
+	if !allowUser(c, userID) {
+		return
+	}
+	bookings, total := app.store(c).GetUserBookings(userID, limit, offset)
 	c.JSON(http.StatusOK, gin.H{
 		"bookings": bookings,
 		"count":    len(bookings),
+		"total":    total,
+		"limit":    limit,
+		"offset":   offset,
 	})
 }
 
-// CreateBooking creates a new booking (direct booking without reservation)
+// GetAllowance returns how many more tickets a user may book for a conference
+func (app *BookingApp) GetAllowance(c *gin.Context) {
+	if !allowUser(c, c.Param("userID")) {
+		return
+	}
+	allowance, err := app.store(c).GetAllowance(c.Param("userID"), c.Param("conferenceID"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "allowance": allowance})
+}
+
+// CreateBooking creates a new booking (direct booking without reservation).
+// Honors an Idempotency-Key header so client retries don't book twice. With a payment provider
+// in use seats are only sold through a paid hold, so direct booking is refused with 409.
 func (app *BookingApp) CreateBooking(c *gin.Context) {
 	var req struct {
 		UserID       string `json:"user_id" binding:"required"`
 		ConferenceID string `json:"conference_id" binding:"required"`
 		TicketCount  int    `json:"ticket_count" binding:"required,min=1"`
+		Attendees       []string `json:"attendees"`
+		ExpectedVersion *int     `json:"expected_version"` // compare-and-swap against Conference.Version
+		Tier            string   `json:"tier"`             // optional tier name, e.g. "VIP"
 	}
 	
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindJSON(c, &req) || !allowUser(c, req.UserID) || !app.requireAdmission(c, req.UserID, req.ConferenceID) {
+		return
+	}
+	if app.payments != nil {
+		c.JSON(http.StatusConflict, errorBody(c, CodePaymentRequired, "tickets are paid for: make a reservation and complete its payment"))
 		return
 	}
 	
-	booking, err := app.db.CreateBooking(req.UserID, req.ConferenceID, req.TicketCount)
+	// A retried request with the same Idempotency-Key gets the original booking back with 200
+	opts := database.BookingOptions{Attendees: req.Attendees, ExpectedVersion: req.ExpectedVersion, Tier: req.Tier, RequestID: requestID(c), ActorID: authUserID(c)}
+	booking, replayed, err := app.store(c).CreateBookingIdempotent(c.GetHeader("Idempotency-Key"), req.UserID, req.ConferenceID, req.TicketCount, opts)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondError(c, err)
+		return
+	}
+	view := bookingView{Booking: booking, Self: setLocation(c, "bookings", booking.ID)}
+	if replayed {
+		c.JSON(http.StatusOK, view)
 		return
 	}
 	
-	c.JSON(http.StatusCreated, booking)
+	c.JSON(http.StatusCreated, view)
 }
 
 // GetBooking retrieves a booking with full details
 func (app *BookingApp) GetBooking(c *gin.Context) {
 	bookingID := c.Param("id")
 	
-	booking := app.db.GetBooking(bookingID)
+	booking := app.store(c).GetBooking(bookingID)
 	if booking == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "booking not found"})
+		respondError(c, database.ErrBookingNotFound)
+		return
+	}
+	if !allowUser(c, booking.UserID) {
 		return
 	}
 	
 	// Get additional details
-	user, _ := app.db.GetUser(booking.UserID)
-	conference, _ := app.db.GetConference(booking.ConferenceID)
+	user, _ := app.store(c).GetUser(booking.UserID)
+	conference, _ := app.store(c).GetConference(booking.ConferenceID)
 	
 	c.JSON(http.StatusOK, gin.H{
 		"booking":    booking,
@@ -128,56 +574,286 @@ func (app *BookingApp) GetBooking(c *gin.Context) {
 	})
 }
 
-// GetAllBookings returns all bookings with user and conference details
+// CancelBooking cancels a confirmed booking and returns its tickets to sale. A booking paid
+// through the payment provider is refunded first, and stays booked if the refund fails.
+func (app *BookingApp) CancelBooking(c *gin.Context) {
+	if !app.allowBooking(c, c.Param("id")) {
+		return
+	}
+	if err := app.refundBooking(c, c.Param("id")); err != nil {
+		respondError(c, err)
+		return
+	}
+	booking, err := app.store(c).CancelBooking(c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"booking": booking,
+		"message": "Booking cancelled; tickets released.",
+	})
+}
+
+// ExportBookings downloads bookings as CSV, optionally for one conference (?conference_id=)
+func (app *BookingApp) ExportBookings(c *gin.Context) {
+	conferenceID := c.Query("conference_id")
+	filename := "bookings.csv"
+	if conferenceID != "" {
+		if _, err := app.store(c).GetConference(conferenceID); err != nil {
+			respondError(c, err)
+			return
+		}
+		filename = "bookings-" + conferenceID + ".csv"
+	}
+	rows := app.store(c).ExportBookings(conferenceID)
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Status(http.StatusOK)
+	w := csv.NewWriter(c.Writer)
+	w.Write(database.BookingExportColumns)
+	// WriteAll flushes; the status is already sent, so a failed write can only be recorded
+	if err := w.WriteAll(rows); err != nil {
+		c.Error(err)
+	}
+}
+
+// GetAllBookings returns a page of bookings with user and conference details.
+// Optional query params: from/to (RFC3339) bound BookedAt, status filters by booking status,
+// limit/offset select the page.
 func (app *BookingApp) GetAllBookings(c *gin.Context) {
-	bookings := app.db.GetAllBookings()
+	limit, offset, ok := pageParams(c)
+	if !ok {
+		return
+	}
+	filter := database.BookingFilter{Status: c.Query("status")}
+	if filter.From, filter.To, ok = timeRangeParams(c); !ok {
+		return
+	}
+	
+	bookings, total := app.store(c).GetAllBookings(filter, limit, offset)
 	c.JSON(http.StatusOK, gin.H{
 		"bookings": bookings,
 		"count":    len(bookings),
+		"total":    total,
+		"limit":    limit,
+		"offset":   offset,
 	})
 }
 
+// timeRangeParams reads the optional from/to RFC3339 bounds from the query, zero when absent.
+// Writes a 400 and returns false if either is malformed or from is after to.
+func timeRangeParams(c *gin.Context) (from, to time.Time, ok bool) {
+	for _, bound := range []struct {
+		name string
+		t    *time.Time
+	}{{"from", &from}, {"to", &to}} {
+		v := c.Query(bound.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, errorBody(c, CodeBadRequest, bound.name+" must be an RFC3339 timestamp"))
+			return time.Time{}, time.Time{}, false
+		}
+		*bound.t = t
+	}
+	if !from.IsZero() && !to.IsZero() && from.After(to) {
+		c.JSON(http.StatusBadRequest, errorBody(c, CodeBadRequest, "from must not be after to"))
+		return time.Time{}, time.Time{}, false
+	}
+	return from, to, true
+}
+
+// priceParam reads an optional non-negative price bound from the query, nil when absent.
+// Writes a 400 and returns false if it is not a valid number.
+func priceParam(c *gin.Context, name string) (*float64, bool) {
+	v := c.Query(name)
+	if v == "" {
+		return nil, true
+	}
+	price, err := strconv.ParseFloat(v, 64)
+	if err != nil || price < 0 || math.IsNaN(price) || math.IsInf(price, 0) {
+		c.JSON(http.StatusBadRequest, errorBody(c, CodeBadRequest, name+" must be a non-negative number"))
+		return nil, false
+	}
+	return &price, true
+}
+
+// Page size defaults for list endpoints
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 200
+)
+
+// pageParams reads limit (default DefaultPageLimit, capped at MaxPageLimit) and offset
+// (default 0) from the query. Writes a 400 and returns false if either is not a valid number.
+func pageParams(c *gin.Context) (limit, offset int, ok bool) {
+	limit, offset = DefaultPageLimit, 0
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, errorBody(c, CodeBadRequest, "limit must be a positive integer"))
+			return 0, 0, false
+		}
+		limit = min(n, MaxPageLimit)
+	}
+	if v := c.Query("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, errorBody(c, CodeBadRequest, "offset must be a non-negative integer"))
+			return 0, 0, false
+		}
+		offset = n
+	}
+	return limit, offset, true
+}
+
 
 
-// CreateReservation creates a temporary seat reservation
+// CreateReservation creates a temporary seat reservation.
+// Honors an Idempotency-Key header so client retries don't hold seats twice.
 func (app *BookingApp) CreateReservation(c *gin.Context) {
 	var req struct {
-		UserID       string `json:"user_id" binding:"required"`
-		ConferenceID string `json:"conference_id" binding:"required"`
-		TicketCount  int    `json:"ticket_count" binding:"required,min=1"`
+		UserID          string `json:"user_id" binding:"required"`
+		ConferenceID    string `json:"conference_id" binding:"required"`
+		TicketCount     int    `json:"ticket_count" binding:"required,min=1"`
+		PromoCode       string `json:"promo_code"`
+		Tier            string `json:"tier"`
+		ExpectedVersion *int   `json:"expected_version"`
+		// Seats to lock at a conference with a seat map, one per ticket; omitted takes the first free ones
+		SeatIDs []string `json:"seat_ids" binding:"max=50"`
 	}
 	
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindJSON(c, &req) || !allowUser(c, req.UserID) || !app.requireAdmission(c, req.UserID, req.ConferenceID) {
 		return
 	}
 	
-	reservation, err := app.db.CreateReservation(req.UserID, req.ConferenceID, req.TicketCount)
+	// A retried request with the same Idempotency-Key gets the original hold back with 200
+	reservation, replayed, err := app.store(c).CreateReservationIdempotent(c.GetHeader("Idempotency-Key"), req.UserID, req.ConferenceID, req.TicketCount,
+		database.ReservationOptions{PromoCode: req.PromoCode, Tier: req.Tier, RequestID: requestID(c), ActorID: authUserID(c), ExpectedVersion: req.ExpectedVersion, SeatIDs: req.SeatIDs})
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"status": "error", "error": err.Error()})
+		respondReservationError(c, err)
 		return
 	}
+	code := http.StatusOK
+	if !replayed {
+		code = http.StatusCreated
+		var ok bool
+		if reservation, ok = app.startPayment(c, reservation, true); !ok {
+			return
+		}
+	}
 
-	conf, _ := app.db.GetConference(req.ConferenceID)
-	c.JSON(http.StatusCreated, gin.H{
+	conf, _ := app.store(c).GetConference(req.ConferenceID)
+	c.JSON(code, gin.H{
 		"status":      "success",
 		"reservation": reservation,
+		"self":        setLocation(c, "reservations", reservation.ID),
 		"conference":  conf,
-		"message":     "Seats reserved for 15 seconds. Complete payment to confirm booking.",
+		"message":     fmt.Sprintf("Seats reserved for %d seconds. Complete payment to confirm booking.", reservation.HoldSeconds),
 	})
 }
 
+// CreateReservationBundle holds seats at several conferences in one all-or-nothing request
+func (app *BookingApp) CreateReservationBundle(c *gin.Context) {
+	var req struct {
+		UserID string                     `json:"user_id" binding:"required"`
+		Items  []database.ReservationItem `json:"items" binding:"required,min=1,dive"`
+	}
+	if !bindJSON(c, &req) || !allowUser(c, req.UserID) {
+		return
+	}
+	conferenceIDs := make([]string, len(req.Items))
+	for i, item := range req.Items {
+		conferenceIDs[i] = item.ConferenceID
+	}
+	if !app.requireAdmission(c, req.UserID, conferenceIDs...) {
+		return
+	}
+
+	reservations, err := app.store(c).CreateReservationBundle(req.UserID, req.Items,
+		database.ReservationOptions{RequestID: requestID(c), ActorID: authUserID(c)})
+	if err != nil {
+		respondReservationError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"status":       "success",
+		"reservations": reservations,
+		"count":        len(reservations),
+		"message":      "Seats reserved. Complete payment to confirm each booking before its hold_seconds run out.",
+	})
+}
+
+// respondReservationError writes a failed hold attempt; a held-cap rejection becomes 429
+// with a Retry-After hint, anything else goes through respondError
+func respondReservationError(c *gin.Context, err error) {
+	var capErr *database.HeldCapError
+	if errors.As(err, &capErr) {
+		retry := capErr.RetryAfterSeconds()
+		c.Header("Retry-After", strconv.Itoa(retry))
+		body := errorBody(c, codeFor(err), err.Error())
+		body["retry_after"] = retry
+		c.JSON(http.StatusTooManyRequests, body)
+		return
+	}
+	respondError(c, err)
+}
+
+// respondReservationLookupError writes a failed reservation lookup: 410 with the expiry time
+// for a recently expired hold, otherwise whatever respondError picks (404 for an unknown ID)
+func respondReservationLookupError(c *gin.Context, err error) {
+	var expErr *database.ReservationExpiredError
+	if errors.As(err, &expErr) {
+		body := errorBody(c, codeFor(err), err.Error())
+		body["expired_at"] = expErr.ExpiredAt
+		c.JSON(http.StatusGone, body)
+		return
+	}
+	respondError(c, err)
+}
+
 // ConfirmReservation converts a reservation to a confirmed booking
 func (app *BookingApp) ConfirmReservation(c *gin.Context) {
 	reservationID := c.Param("id")
 	
-	booking, err := app.db.ConfirmReservation(reservationID)
+	// The body is optional so existing clients can keep confirming without one
+	var req struct {
+		Attendees       []string `json:"attendees"`
+		ExpectedVersion *int     `json:"expected_version"`
+	}
+	if c.Request.ContentLength != 0 && !bindJSON(c, &req) {
+		return
+	}
+	if !app.allowReservation(c, reservationID) {
+		return
+	}
+	opts := database.BookingOptions{Attendees: req.Attendees, ExpectedVersion: req.ExpectedVersion, RequestID: requestID(c), ActorID: authUserID(c)}
+	if app.payments != nil {
+		// The booking waits for the provider, so the attendees are kept with the hold and the
+		// version is checked now, against the conference the caller saw
+		reservation, err := app.store(c).PrepareConfirmation(reservationID, opts)
+		if err != nil {
+			respondReservationLookupError(c, err)
+			return
+		}
+		if reservation, ok := app.startPayment(c, reservation, false); ok {
+			respondPaymentPending(c, reservation)
+		}
+		return
+	}
+	
+	booking, err := app.store(c).ConfirmReservation(reservationID, opts)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"status": "error", "error": err.Error()})
+		respondReservationLookupError(c, err)
 		return
 	}
 
-	conf, _ := app.db.GetConference(booking.ConferenceID)
+	conf, _ := app.store(c).GetConference(booking.ConferenceID)
 	c.JSON(http.StatusOK, gin.H{
 		"status":  "success",
 		"booking": booking,
@@ -189,12 +865,17 @@ func (app *BookingApp) ConfirmReservation(c *gin.Context) {
 // CancelReservation cancels a seat reservation
 func (app *BookingApp) CancelReservation(c *gin.Context) {
 	reservationID := c.Param("id")
+	if !app.allowReservation(c, reservationID) {
+		return
+	}
+	reservation, _ := app.store(c).GetReservation(reservationID)
 	
-	err := app.db.CancelReservation(reservationID)
+	err := app.store(c).CancelReservation(reservationID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"status": "error", "error": err.Error()})
+		respondError(c, err)
 		return
 	}
+	app.cancelPayment(c, reservation)
 
 	c.JSON(http.StatusOK, gin.H{
 		"status":  "success",
@@ -202,13 +883,109 @@ func (app *BookingApp) CancelReservation(c *gin.Context) {
 	})
 }
 
+// ExtendReservationOnce grants the one-time extension to a reservation whose owner is still paying
+func (app *BookingApp) ExtendReservationOnce(c *gin.Context) {
+	reservationID := c.Param("id")
+	if !app.allowReservation(c, reservationID) {
+		return
+	}
+
+	reservation, err := app.store(c).ExtendReservationOnce(reservationID)
+	if err != nil {
+		respondReservationLookupError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":         "success",
+		"reservation":    reservation,
+		"expires_at":     reservation.ExpiresAt,
+		"remaining_time": time.Until(reservation.ExpiresAt).Seconds(),
+		"message":        "Hold extended once. Complete payment before it expires.",
+	})
+}
+
+// ExtendReservation buys a reservation another hold period, a limited number of times
+func (app *BookingApp) ExtendReservation(c *gin.Context) {
+	if !app.allowReservation(c, c.Param("id")) {
+		return
+	}
+	reservation, err := app.store(c).ExtendReservation(c.Param("id"))
+	if err != nil {
+		respondReservationLookupError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":          "success",
+		"reservation":     reservation,
+		"expires_at":      reservation.ExpiresAt,
+		"remaining_time":  time.Until(reservation.ExpiresAt).Seconds(),
+		"extensions_left": app.store(c).ExtensionsLeft(reservation),
+		"message":         "Hold extended. Complete payment before it expires.",
+	})
+}
+
+// AcceptOffer takes up seats the wait queue offered, turning the offer into a hold to pay for.
+// Like any hold it needs an admission token when the conference sells through a waiting room.
+func (app *BookingApp) AcceptOffer(c *gin.Context) {
+	if !app.allowReservation(c, c.Param("id")) {
+		return
+	}
+	if offer, err := app.store(c).GetReservation(c.Param("id")); err == nil && !app.requireAdmission(c, offer.UserID, offer.ConferenceID) {
+		return
+	}
+	reservation, err := app.store(c).AcceptOffer(c.Param("id"))
+	if err != nil {
+		respondReservationLookupError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":         "success",
+		"reservation":    reservation,
+		"remaining_time": time.Until(reservation.ExpiresAt).Seconds(),
+		"message":        "Offer accepted. Complete payment before the hold expires.",
+	})
+}
+
+// clockInfo reports the server time and, when the client sends X-Client-Time (RFC3339 or unix
+// milliseconds), the client-minus-server skew in seconds so the client can correct its countdown.
+// remaining_time stays the authoritative countdown. Writes a 400 and returns false on a bad header.
+func clockInfo(c *gin.Context) (gin.H, bool) {
+	now := time.Now()
+	info := gin.H{"server_time": now}
+	header := c.GetHeader("X-Client-Time")
+	if header == "" {
+		return info, true
+	}
+	clientTime, err := time.Parse(time.RFC3339Nano, header)
+	if err != nil {
+		ms, convErr := strconv.ParseInt(header, 10, 64)
+		if convErr != nil {
+			c.JSON(http.StatusBadRequest, errorBody(c, CodeBadRequest, "X-Client-Time must be RFC3339 or unix milliseconds"))
+			return nil, false
+		}
+		clientTime = time.UnixMilli(ms)
+	}
+	info["clock_skew_seconds"] = clientTime.Sub(now).Seconds()
+	return info, true
+}
+
 // GetReservation gets a reservation with remaining time
 func (app *BookingApp) GetReservation(c *gin.Context) {
 	reservationID := c.Param("id")
+	clock, ok := clockInfo(c)
+	if !ok {
+		return
+	}
 	
-	reservation, err := app.db.GetReservation(reservationID)
+	reservation, err := app.store(c).GetReservation(reservationID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"status": "error", "error": err.Error()})
+		respondReservationLookupError(c, err)
+		return
+	}
+	if !allowUser(c, reservation.UserID) {
 		return
 	}
 	
@@ -218,21 +995,29 @@ func (app *BookingApp) GetReservation(c *gin.Context) {
 		remainingTime = 0
 	}
 	
-	conf, _ := app.db.GetConference(reservation.ConferenceID)
-	c.JSON(http.StatusOK, gin.H{
+	conf, _ := app.store(c).GetConference(reservation.ConferenceID)
+	resp := gin.H{
 		"status":         "success",
 		"reservation":    reservation,
 		"conference":     conf,
 		"remaining_time": remainingTime.Seconds(),
 		"expired":        remainingTime <= 0,
-	})
+	}
+	for k, v := range clock {
+		resp[k] = v
+	}
+	c.JSON(http.StatusOK, resp)
 }
 
 // GetUserReservations gets all active reservations for a user
 func (app *BookingApp) GetUserReservations(c *gin.Context) {
 	userID := c.Param("userID")
+	clock, ok := clockInfo(c)
+	if !ok || !allowUser(c, userID) {
+		return
+	}
 	
-	reservations := app.db.GetUserReservations(userID)
+	reservations := app.store(c).GetUserReservations(userID)
 	
 	// Add remaining time for each reservation
 	var result []gin.H
@@ -242,7 +1027,7 @@ func (app *BookingApp) GetUserReservations(c *gin.Context) {
 			remainingTime = 0
 		}
 
-		conf, _ := app.db.GetConference(reservation.ConferenceID)
+		conf, _ := app.store(c).GetConference(reservation.ConferenceID)
 		result = append(result, gin.H{
 			"reservation":    reservation,
 			"conference":     conf,
@@ -251,6 +1036,36 @@ func (app *BookingApp) GetUserReservations(c *gin.Context) {
 		})
 	}
 	
+	resp := gin.H{
+		"status":       "success",
+		"reservations": result,
+		"count":        len(result),
+	}
+	for k, v := range clock {
+		resp[k] = v
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// ListReservations lists active reservations across conferences, soonest to expire first.
+// Optional query params conference_id and user_id narrow the list.
+func (app *BookingApp) ListReservations(c *gin.Context) {
+	reservations := app.store(c).ListReservations(database.ReservationFilter{
+		ConferenceID: c.Query("conference_id"),
+		UserID:       c.Query("user_id"),
+	})
+
+	result := make([]gin.H, 0, len(reservations))
+	for _, reservation := range reservations {
+		remainingTime := time.Until(reservation.ExpiresAt)
+		if remainingTime < 0 {
+			remainingTime = 0
+		}
+		result = append(result, gin.H{
+			"reservation":    reservation,
+			"remaining_time": remainingTime.Seconds(),
+		})
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"status":       "success",
 		"reservations": result,
@@ -258,49 +1073,225 @@ func (app *BookingApp) GetUserReservations(c *gin.Context) {
 	})
 }
 
+// GetUserReservationHistory lists a user's reservations in every status, newest first
+func (app *BookingApp) GetUserReservationHistory(c *gin.Context) {
+	if !allowUser(c, c.Param("userID")) {
+		return
+	}
+	reservations := app.store(c).GetUserReservationHistory(c.Param("userID"))
+	c.JSON(http.StatusOK, gin.H{
+		"status":       "success",
+		"reservations": reservations,
+		"count":        len(reservations),
+	})
+}
+
 // Queue endpoints
-// Enqueue user for conference waitlist
+// Enqueue user for conference waitlist, optionally for seats in one tier
 func (app *BookingApp) EnqueueWait(c *gin.Context) {
 	var req struct {
 		UserID       string `json:"user_id" binding:"required"`
 		ConferenceID string `json:"conference_id" binding:"required"`
 		TicketCount  int    `json:"ticket_count" binding:"required,min=1"`
+		Tier         string `json:"tier"`
+	}
+	if !bindJSON(c, &req) || !allowUser(c, req.UserID) {
+		return
 	}
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"status": "error", "error": err.Error()})
+	pos, err := app.store(c).EnqueueWait(req.UserID, req.ConferenceID, req.TicketCount, req.Tier)
+	if err != nil {
+		respondError(c, err)
 		return
 	}
-	pos := app.db.EnqueueWait(req.UserID, req.ConferenceID, req.TicketCount)
 	c.JSON(http.StatusOK, gin.H{"status": "success", "position": pos})
 }
 
+// BulkEnqueue appends several users to a conference queue in one operation (test setup); an
+// entry's priority overrides the class the user's own joins would get
+func (app *BookingApp) BulkEnqueue(c *gin.Context) {
+	var req struct {
+		ConferenceID string                  `json:"conference_id" binding:"required"`
+		Entries      []database.QueueRequest `json:"entries" binding:"required,min=1,dive"`
+	}
+	if !bindJSON(c, &req) {
+		return
+	}
+	positions, err := app.store(c).BulkEnqueue(req.ConferenceID, req.Entries)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "positions": positions})
+}
+
+// LeaveQueue removes a user (?user_id=) from a conference waitlist, named by the :conferenceID
+// path param or, on /queue/leave, by ?conference_id=
+func (app *BookingApp) LeaveQueue(c *gin.Context) {
+	userID := c.Query("user_id")
+	conferenceID := c.Param("conferenceID")
+	if conferenceID == "" {
+		conferenceID = c.Query("conference_id")
+	}
+	if userID == "" || conferenceID == "" {
+		c.JSON(http.StatusBadRequest, errorBody(c, CodeBadRequest, "user_id and conference_id required"))
+		return
+	}
+	if !allowUser(c, userID) {
+		return
+	}
+	if !app.store(c).DequeueWait(userID, conferenceID) {
+		c.JSON(http.StatusNotFound, errorBody(c, CodeNotFound, "not in queue"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "message": "Left the queue."})
+}
+
+// GetQueue lists a conference's whole wait queue for admins, head first
+func (app *BookingApp) GetQueue(c *gin.Context) {
+	entries, err := app.store(c).ListQueue(c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	result := make([]gin.H, 0, len(entries))
+	ahead := 0
+	for i, e := range entries {
+		result = append(result, gin.H{"position": i + 1, "ahead_count": ahead, "entry": e})
+		ahead += e.TicketCount
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":       "success",
+		"queue":        result,
+		"count":        len(result),
+		"ticket_count": ahead,
+	})
+}
+
 // Get user's queue position
 func (app *BookingApp) GetQueuePosition(c *gin.Context) {
 	userID := c.Query("user_id")
 	conferenceID := c.Param("conferenceID")
 	if userID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"status": "error", "error": "user_id required"})
+		c.JSON(http.StatusBadRequest, errorBody(c, CodeBadRequest, "user_id required"))
 		return
 	}
-	pos := app.db.GetQueuePosition(userID, conferenceID)
-	c.JSON(http.StatusOK, gin.H{"status": "success", "position": pos})
+	if !allowUser(c, userID) {
+		return
+	}
+	status := app.store(c).GetQueuePosition(userID, conferenceID)
+	if status.Position == 0 && status.Offer != nil {
+		c.JSON(http.StatusOK, gin.H{"status": "success", "queued": false, "position": 0, "offer": status.Offer, "message": "seats offered; accept or confirm before the offer expires"})
+		return
+	}
+	if status.Position == 0 {
+		c.JSON(http.StatusOK, gin.H{"status": "success", "queued": false, "position": 0, "message": "not queued"})
+		return
+	}
+	resp := gin.H{
+		"status":                 "success",
+		"queued":                 true,
+		"position":               status.Position,
+		"ticket_count":           status.TicketCount,
+		"ahead_count":            status.AheadCount,
+		"claimable":              status.Claimable,
+		"estimated_wait_seconds": status.EstimatedWaitSeconds,
+	}
+	if status.Tier != "" {
+		resp["tier"] = status.Tier
+	}
+	if status.ClaimableUntil != nil {
+		resp["claimable_until"] = status.ClaimableUntil
+	}
+	if status.MissedClaims > 0 {
+		resp["missed_claims"] = status.MissedClaims
+	}
+	if status.Priority > 0 {
+		resp["priority"] = status.Priority
+	}
+	c.JSON(http.StatusOK, resp)
 }
 
-// Claim next in queue to create a reservation when it's user's turn
+// Claim next in queue to create a reservation when it's user's turn; the hold needs an
+// admission token when the conference sells through a waiting room
 func (app *BookingApp) ClaimNext(c *gin.Context) {
 	var req struct {
 		UserID       string `json:"user_id" binding:"required"`
 		ConferenceID string `json:"conference_id" binding:"required"`
+		Partial      bool   `json:"partial"` // accept fewer seats and re-queue the rest
+	}
+	if !bindJSON(c, &req) || !allowUser(c, req.UserID) || !app.requireAdmission(c, req.UserID, req.ConferenceID) {
+		return
+	}
+	reservation, err := app.store(c).ClaimNext(req.UserID, req.ConferenceID, req.Partial)
+	if err != nil {
+		respondReservationError(c, err)
+		return
+	}
+	conf, _ := app.store(c).GetConference(req.ConferenceID)
+	c.JSON(http.StatusOK, gin.H{
+		"status":      "success",
+		"reservation": reservation,
+		"conference":  conf,
+		"granted":     reservation.TicketCount,
+		"position":    app.store(c).GetQueuePosition(req.UserID, req.ConferenceID).Position, // >0 when a shortfall was re-queued
+	})
+}
+// ResetDatabase wipes all data back to the sample conferences so load-test harnesses can start
+// each run fresh without restarting the server
+func (app *BookingApp) ResetDatabase(c *gin.Context) {
+	app.store(c).ResetDatabase()
+	c.JSON(http.StatusOK, gin.H{
+		"status":      "success",
+		"message":     "Database reset to sample data.",
+		"conferences": len(app.store(c).GetAllConferences()),
+	})
+}
+
+// ReplayAudit rebuilds state from an audit log in a scratch database and reports drift
+// against the live data. The body may carry {"entries": [...]}; without it the live log is used.
+// inventory lists where the live seat counts break the inventory replayed from the live log.
+func (app *BookingApp) ReplayAudit(c *gin.Context) {
+	var req struct {
+		Entries []database.AuditEntry `json:"entries"`
+	}
+	if c.Request.ContentLength > 0 && !bindJSON(c, &req) {
+		return
 	}
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"status": "error", "error": err.Error()})
+	entries := req.Entries
+	if entries == nil {
+		entries = app.store(c).GetAuditLog()
+	}
+
+	replayed := database.NewDatabase()
+	defer replayed.Close()
+	if err := replayed.ReplayAudit(entries); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errorBody(c, CodeReplayFailed, err.Error()))
+		return
+	}
+
+	drift := app.store(c).Drift(replayed)
+	inventory := app.store(c).CheckInventory()
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "success",
+		"replayed":  len(entries),
+		"drift":     drift,
+		"inventory": inventory,
+		"in_sync":   len(drift) == 0 && len(inventory) == 0,
+	})
+}
+
+// ReleaseHoldback releases held-back seats of a conference into general availability
+func (app *BookingApp) ReleaseHoldback(c *gin.Context) {
+	var req struct {
+		Count int `json:"count" binding:"required,min=1"`
+	}
+	if !bindJSON(c, &req) {
 		return
 	}
-	reservation, err := app.db.ClaimNext(req.UserID, req.ConferenceID)
+	conf, err := app.store(c).ReleaseHoldback(c.Param("id"), req.Count)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"status": "error", "error": err.Error()})
+		respondError(c, err)
 		return
 	}
-	conf, _ := app.db.GetConference(req.ConferenceID)
-	c.JSON(http.StatusOK, gin.H{"status": "success", "reservation": reservation, "conference": conf})
-}
\ No newline at end of file
+	c.JSON(http.StatusOK, gin.H{"status": "success", "conference": conf})
+}
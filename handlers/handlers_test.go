@@ -0,0 +1,2145 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"booking-system/database"
+	"booking-system/database/tracedstore"
+	"booking-system/models"
+	"booking-system/payments"
+	"booking-system/tickets"
+	"booking-system/tracing"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// newTestApp builds an app whose database janitor stops when the test ends
+func newTestApp(t *testing.T) *BookingApp {
+	t.Helper()
+	app, _ := newTestAppWithDB(t)
+	return app
+}
+
+// newTestAppWithDB also returns the in-memory store, for tests that reach into its state
+func newTestAppWithDB(t *testing.T) (*BookingApp, *database.Database) {
+	t.Helper()
+	db := database.NewDatabase()
+	t.Cleanup(db.Close)
+	return NewBookingAppWithDatabase(db), db
+}
+
+// serve registers a single handler on a fresh router and runs one request through it
+func serve(method, route string, h gin.HandlerFunc, path, body string) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.Handle(method, route, h)
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestCreateUserReportsEveryInvalidField(t *testing.T) {
+	app := newTestApp(t)
+	w := serve(http.MethodPost, "/users", app.CreateUser, "/users", `{"name":"","email":"not-an-email"}`)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d", w.Code)
+	}
+	var resp struct {
+		Fields map[string]string `json:"fields"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("bad json: %v", err)
+	}
+	if resp.Fields["name"] == "" || resp.Fields["email"] == "" {
+		t.Fatalf("expected errors for both name and email, got %v", resp.Fields)
+	}
+
+	// stricter than the binding's email check, and names must already be trimmed
+	w = serve(http.MethodPost, "/users", app.CreateUser, "/users", `{"name":" Alice","email":"a@b"}`)
+	resp.Fields = nil
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 with json, got %d: %v", w.Code, err)
+	}
+	if resp.Fields["name"] != "must not start or end with whitespace" || resp.Fields["email"] != "must be a valid email address" {
+		t.Fatalf("expected precise per-field messages, got %v", resp.Fields)
+	}
+}
+
+func TestGetReservationExpiredVersusUnknown(t *testing.T) {
+	app, db := newTestAppWithDB(t)
+	user, _ := db.CreateUser("Alice", "alice@example.com")
+	res, err := db.CreateReservation(user.ID, "conf-1", 1, database.ReservationOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expiredAt := time.Now().Add(-time.Second).UTC().Truncate(time.Second)
+	db.Reservations[res.ID].ExpiresAt = expiredAt
+
+	w := serve(http.MethodGet, "/reservations/:id", app.GetReservation, "/reservations/"+res.ID, "")
+	if w.Code != http.StatusGone {
+		t.Fatalf("expected 410 for expired reservation, got %d", w.Code)
+	}
+	var resp struct {
+		ExpiredAt time.Time `json:"expired_at"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("bad json: %v", err)
+	}
+	if !resp.ExpiredAt.Equal(expiredAt) {
+		t.Fatalf("expected expired_at %v, got %v", expiredAt, resp.ExpiredAt)
+	}
+
+	w = serve(http.MethodGet, "/reservations/:id", app.GetReservation, "/reservations/does-not-exist", "")
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown reservation, got %d", w.Code)
+	}
+}
+
+func TestGetConferencesInclusivePricing(t *testing.T) {
+	app, db := newTestAppWithDB(t)
+	db.Conferences["conf-1"].FeePercent = 10
+
+	var resp struct {
+		Conferences []struct {
+			ID        string  `json:"id"`
+			Price     float64 `json:"price"`
+			BasePrice float64 `json:"base_price"`
+		} `json:"conferences"`
+	}
+	for _, mode := range []string{"exclusive", "inclusive"} {
+		w := serve(http.MethodGet, "/conferences", app.GetConferences, "/conferences?price_display="+mode, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", mode, w.Code)
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("bad json: %v", err)
+		}
+		conf := resp.Conferences[0]
+		if conf.ID != "conf-1" || conf.BasePrice != 299.99 {
+			t.Fatalf("%s: expected base price 299.99 for conf-1, got %+v", mode, conf)
+		}
+		want := 299.99
+		if mode == "inclusive" {
+			want = 299.99 * 1.1
+		}
+		if math.Abs(conf.Price-want) > 1e-9 {
+			t.Fatalf("%s: expected price %v, got %v", mode, want, conf.Price)
+		}
+	}
+}
+
+func TestGetConferencesPagesAndFilters(t *testing.T) {
+	app := newTestApp(t)
+	var resp struct {
+		Conferences []struct {
+			ID string `json:"id"`
+		} `json:"conferences"`
+		Count int `json:"count"`
+		Total int `json:"total"`
+	}
+	get := func(query string) int {
+		w := serve(http.MethodGet, "/conferences", app.GetConferences, "/conferences?"+query, "")
+		resp.Conferences = nil
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code
+	}
+
+	if code := get("sort=price&limit=1&offset=1"); code != http.StatusOK || resp.Total != 3 || resp.Count != 1 || resp.Conferences[0].ID != "conf-1" {
+		t.Fatalf("expected the second cheapest of 3, got %d %+v", code, resp)
+	}
+	if code := get("min_price=250&max_price=400&sort=price&order=desc"); code != http.StatusOK || resp.Total != 2 || resp.Conferences[0].ID != "conf-2" {
+		t.Fatalf("expected conf-2 then conf-1 in the price range, got %d %+v", code, resp)
+	}
+	if code := get("offset=10"); code != http.StatusOK || resp.Total != 3 || resp.Count != 0 {
+		t.Fatalf("expected an empty page past the end, got %d %+v", code, resp)
+	}
+	for _, bad := range []string{"min_price=cheap", "min_price=300&max_price=200", "from=tomorrow", "limit=0"} {
+		if code := get(bad); code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", bad, code)
+		}
+	}
+}
+
+func TestGetReservationReportsClockSkew(t *testing.T) {
+	app := newTestApp(t)
+	user, _ := app.db.CreateUser("Alice", "alice@example.com")
+	res, err := app.db.CreateReservation(user.ID, "conf-1", 1, database.ReservationOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	router := gin.New()
+	router.GET("/reservations/:id", app.GetReservation)
+	req := httptest.NewRequest(http.MethodGet, "/reservations/"+res.ID, nil)
+	req.Header.Set("X-Client-Time", time.Now().Add(30*time.Second).Format(time.RFC3339Nano))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var resp struct {
+		Skew *float64 `json:"clock_skew_seconds"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("bad json: %v", err)
+	}
+	if resp.Skew == nil || math.Abs(*resp.Skew-30) > 1 {
+		t.Fatalf("expected skew of about 30s, got %v", resp.Skew)
+	}
+}
+
+func TestMissingEntitiesAre404AndConflictsAre409(t *testing.T) {
+	app := newTestApp(t)
+	user, _ := app.db.CreateUser("Alice", "alice@example.com")
+
+	w := serve(http.MethodPost, "/bookings", app.CreateBooking, "/bookings",
+		`{"user_id":"`+user.ID+`","conference_id":"nope","ticket_count":1}`)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown conference, got %d", w.Code)
+	}
+	w = serve(http.MethodPost, "/reservations/:id/confirm", app.ConfirmReservation, "/reservations/nope/confirm", "")
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 confirming unknown reservation, got %d", w.Code)
+	}
+	w = serve(http.MethodDelete, "/reservations/:id", app.CancelReservation, "/reservations/nope", "")
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 cancelling unknown reservation, got %d", w.Code)
+	}
+
+	body := `{"user_id":"` + user.ID + `","conference_id":"conf-1","ticket_count":1}`
+	if w = serve(http.MethodPost, "/reservations", app.CreateReservation, "/reservations", body); w.Code != http.StatusCreated {
+		t.Fatalf("expected first reservation to succeed, got %d", w.Code)
+	}
+	if w = serve(http.MethodPost, "/reservations", app.CreateReservation, "/reservations", body); w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for a second active reservation, got %d", w.Code)
+	}
+	if w = serve(http.MethodPost, "/queue/enqueue", app.EnqueueWait, "/queue/enqueue", body); w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 queueing while holding a reservation, got %d", w.Code)
+	}
+	w = serve(http.MethodPost, "/bookings", app.CreateBooking, "/bookings",
+		`{"user_id":"`+user.ID+`","conference_id":"conf-1","ticket_count":0}`)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for a bad ticket count, got %d", w.Code)
+	}
+	w = serve(http.MethodPost, "/bookings", app.CreateBooking, "/bookings", `{"user_id":`)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for malformed JSON, got %d", w.Code)
+	}
+}
+
+func TestStreamEventsSendsReservationEvents(t *testing.T) {
+	app := newTestApp(t)
+	user, _ := app.db.CreateUser("Alice", "alice@example.com")
+	other, _ := app.db.CreateUser("Bob", "bob@example.com")
+
+	router := gin.New()
+	router.GET("/events", app.RequireAuth(), app.StreamEvents)
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close) // after the streams below close
+
+	resp, err := http.Get(srv.URL + "/events")
+	if err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a token, got %d", resp.StatusCode)
+	}
+
+	// next reads the data line of the next event named name from a stream opened as userID
+	open := func(userID string) (next func(name string) database.Event) {
+		token, _, err := app.tokens.Issue(userID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/events", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("connect failed: %v", err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+			t.Fatalf("expected text/event-stream, got %q", ct)
+		}
+		lines := bufio.NewScanner(resp.Body)
+		deadline := time.AfterFunc(2*time.Second, func() { resp.Body.Close() })
+		t.Cleanup(func() { deadline.Stop() })
+		return func(name string) database.Event {
+			for lines.Scan() {
+				if lines.Text() != "event:"+name || !lines.Scan() {
+					continue
+				}
+				var ev database.Event
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(lines.Text(), "data:")), &ev); err != nil {
+					t.Fatalf("bad event data %q: %v", lines.Text(), err)
+				}
+				return ev
+			}
+			t.Fatalf("stream ended without a %s event", name)
+			return database.Event{}
+		}
+	}
+	own, others := open(user.ID), open(other.ID)
+
+	res, err := app.db.CreateReservation(user.ID, "conf-1", 1, database.ReservationOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ev := own("reservation.create"); ev.UserID != user.ID || ev.ReservationID != res.ID {
+		t.Fatalf("expected alice to see her own hold in full, got %+v", ev)
+	}
+	if ev := others("reservation.create"); ev.UserID != "" || ev.ReservationID != "" || ev.ConferenceID != "conf-1" || ev.TicketCount != 1 {
+		t.Fatalf("expected bob to see the hold without alice's IDs, got %+v", ev)
+	}
+}
+
+func TestLiveSeatsSendsSnapshotThenChanges(t *testing.T) {
+	app := newTestApp(t)
+	user, _ := app.db.CreateUser("Alice", "alice@example.com")
+
+	router := gin.New()
+	router.GET("/ws", app.LiveSeats)
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	if w := serve(http.MethodGet, "/ws", app.LiveSeats, "/ws?conference_id=nope", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown conference, got %d", w.Code)
+	}
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?conference_id=conf-1", "", srv.URL)
+	if err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	defer ws.Close()
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	var update seatUpdate
+	if err := websocket.JSON.Receive(ws, &update); err != nil || update.Type != "snapshot" || update.ConferenceID != "conf-1" {
+		t.Fatalf("expected a snapshot of conf-1 first, got %+v (%v)", update, err)
+	}
+	before := update.BookableTickets
+
+	if _, err := app.db.CreateReservation(user.ID, "conf-1", 2, database.ReservationOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := app.db.CreateReservation(user.ID, "conf-2", 1, database.ReservationOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := websocket.JSON.Receive(ws, &update); err != nil {
+		t.Fatalf("feed ended early: %v", err)
+	}
+	if update.Type != database.OpReservationCreate || update.ConferenceID != "conf-1" || update.ReservedTickets != 2 || update.BookableTickets != before-2 {
+		t.Fatalf("expected the conf-1 hold with 2 seats reserved, got %+v", update)
+	}
+}
+
+func TestRequestIDIsEchoedLoggedAndRecorded(t *testing.T) {
+	app, db := newTestAppWithDB(t)
+	user, _ := db.CreateUser("Alice", "alice@example.com")
+	var buf bytes.Buffer
+	router := gin.New()
+	router.Use(RequestID(), RequestLogger(slog.New(slog.NewJSONHandler(&buf, nil))))
+	router.POST("/reservations", app.CreateReservation)
+
+	post := func(id, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/reservations", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if id != "" {
+			req.Header.Set(RequestIDHeader, id)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// A generated ID comes back in the header, the error body and the log line
+	w := post("", `{"user_id":"`+user.ID+`","conference_id":"nope","ticket_count":1}`)
+	id := w.Header().Get(RequestIDHeader)
+	var failed struct {
+		RequestID string `json:"request_id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &failed)
+	if w.Code != http.StatusNotFound || id == "" || failed.RequestID != id {
+		t.Fatalf("expected a 404 carrying the generated request ID %q, got %d %s", id, w.Code, w.Body.String())
+	}
+	if !strings.Contains(buf.String(), `"request_id":"`+id+`"`) {
+		t.Fatalf("expected the request log to carry the ID, got %s", buf.String())
+	}
+
+	// A client's well-formed ID is kept and lands on the audit entry; a malformed one is replaced
+	if w := post("client-42", `{"user_id":"`+user.ID+`","conference_id":"conf-1","ticket_count":1}`); w.Code != http.StatusCreated || w.Header().Get(RequestIDHeader) != "client-42" {
+		t.Fatalf("expected the client's request ID to be kept, got %d %q", w.Code, w.Header().Get(RequestIDHeader))
+	}
+	audit := db.GetAuditLog()
+	if last := audit[len(audit)-1]; last.Op != database.OpReservationCreate || last.RequestID != "client-42" {
+		t.Fatalf("expected the hold's audit entry to carry the request ID, got %+v", last)
+	}
+	if w := post("bad id\n", `{}`); w.Header().Get(RequestIDHeader) == "bad id\n" {
+		t.Fatalf("expected a malformed request ID to be replaced")
+	}
+}
+
+// spanRecorder keeps exported spans; the janitor may export lock spans concurrently
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []*tracing.Span
+}
+
+func (r *spanRecorder) Export(span *tracing.Span) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, span)
+}
+
+func (r *spanRecorder) named(name string) *tracing.Span {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, span := range r.spans {
+		if span.Name == name {
+			return span
+		}
+	}
+	return nil
+}
+
+func TestTracingNestsStoreSpansUnderTheRequest(t *testing.T) {
+	app, db := newTestAppWithDB(t)
+	spans := &spanRecorder{}
+	tracer := tracing.New(spans, 100)
+	db.SetLockTracer(tracedstore.LockSpans(tracer))
+	app.UseTracing(tracedstore.New(db, tracer))
+	router := gin.New()
+	router.Use(RequestID(), Tracing(tracer))
+	router.POST("/users", app.CreateUser)
+
+	req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(`{"name":"Alice","email":"alice@example.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(RequestIDHeader, "trace-1")
+	req.Header.Set(tracing.TraceParentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d %s", w.Code, w.Body.String())
+	}
+
+	server, store, lock := spans.named("POST /users"), spans.named("store.CreateUser"), spans.named("db.write_lock")
+	if server == nil || store == nil || lock == nil {
+		t.Fatalf("expected request, store and lock spans, got %+v", spans.spans)
+	}
+	if w.Header().Get(tracing.TraceParentHeader) != server.TraceParent() || !strings.Contains(server.TraceParent(), "4bf92f3577b34da6a3ce929d0e0e4736") {
+		t.Fatalf("expected the response to name the request span in the caller's trace, got %q", w.Header().Get(tracing.TraceParentHeader))
+	}
+	if store.TraceID != server.TraceID || store.ParentID != server.SpanID {
+		t.Fatal("expected the store call to be a child of the request span")
+	}
+	if !hasAttr(server, "http.response.status_code", http.StatusCreated) || !hasAttr(server, "request_id", "trace-1") {
+		t.Fatalf("expected status and request ID on the request span, got %+v", server.Attrs)
+	}
+	if !hasAttr(lock, "method", "CreateUser") {
+		t.Fatalf("expected the lock span to name the method holding it, got %+v", lock.Attrs)
+	}
+}
+
+func hasAttr(span *tracing.Span, key string, value interface{}) bool {
+	for _, a := range span.Attrs {
+		if a.Key == key && a.Value == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRequestLoggerWritesJSONLine(t *testing.T) {
+	var buf bytes.Buffer
+	router := gin.New()
+	router.Use(RequestLogger(slog.New(slog.NewJSONHandler(&buf, nil))))
+	router.POST("/queue/claim", func(c *gin.Context) {
+		var req struct {
+			UserID string `json:"user_id"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || req.UserID != "u-1" {
+			t.Errorf("expected the handler to still see the body, got %q (%v)", req.UserID, err)
+		}
+		c.Status(http.StatusNotFound)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/queue/claim", strings.NewReader(`{"user_id":"u-1","conference_id":"conf-1"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	var line struct {
+		Level        string  `json:"level"`
+		Method       string  `json:"method"`
+		Path         string  `json:"path"`
+		Status       int     `json:"status"`
+		LatencyMS    float64 `json:"latency_ms"`
+		UserID       string  `json:"user_id"`
+		ConferenceID string  `json:"conference_id"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("expected one JSON line, got %q: %v", buf.String(), err)
+	}
+	if line.Level != "WARN" || line.Method != http.MethodPost || line.Path != "/queue/claim" || line.Status != http.StatusNotFound {
+		t.Fatalf("unexpected log line %+v", line)
+	}
+	if line.UserID != "u-1" || line.ConferenceID != "conf-1" {
+		t.Fatalf("expected IDs from the body, got %+v", line)
+	}
+}
+
+func TestMetricsExposition(t *testing.T) {
+	app := newTestApp(t)
+	user, _ := app.db.CreateUser("Alice", "alice@example.com")
+	res, _ := app.db.CreateReservation(user.ID, "conf-1", 1, database.ReservationOptions{})
+	if _, err := app.db.ConfirmReservation(res.ID, database.BookingOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := app.db.EnqueueWait(user.ID, "conf-2", 1, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	w := serve(http.MethodGet, "/metrics", app.Metrics, "/metrics", "")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("expected 200 text/plain, got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		"# TYPE booking_bookings_created_total counter",
+		"booking_bookings_created_total 1\n",
+		`booking_reservations_total{event="created"} 1`,
+		`booking_reservations_total{event="confirmed"} 1`,
+		"booking_active_reservations 0\n",
+		`booking_queue_length{conference_id="conf-2"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected %q in metrics output:\n%s", want, body)
+		}
+	}
+}
+
+func TestRateLimiterRejectsBursts(t *testing.T) {
+	limiter := NewRateLimiter(5, 10)
+	router := gin.New()
+	router.POST("/reservations", limiter.Middleware(), func(c *gin.Context) { c.Status(http.StatusCreated) })
+
+	limited := 0
+	for i := 0; i < 20; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/reservations", strings.NewReader(`{"user_id":"u-1"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code == http.StatusTooManyRequests {
+			limited++
+			if w.Header().Get("Retry-After") == "" {
+				t.Fatalf("expected a Retry-After header on 429")
+			}
+		}
+	}
+	if limited == 0 {
+		t.Fatalf("expected some of 20 rapid requests to be limited")
+	}
+
+	// another user has a bucket of their own
+	req := httptest.NewRequest(http.MethodPost, "/reservations", strings.NewReader(`{"user_id":"u-2"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected a different user to pass, got %d", w.Code)
+	}
+
+	limiter.now = func() time.Time { return time.Now().Add(time.Minute) }
+	if removed := limiter.Cleanup(); removed != 2 {
+		t.Fatalf("expected both refilled buckets to be cleaned up, got %d", removed)
+	}
+}
+
+func TestRateLimiterGroupsAndCapsEachIP(t *testing.T) {
+	limiter := NewRateLimiter(100, 100)
+	limiter.SetGroup(RateLimitQueue, 1, 2)
+	limiter.SetIPMultiplier(2)
+	router := gin.New()
+	router.POST("/queue/claim", limiter.Group(RateLimitQueue), func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.POST("/reservations", limiter.Group(RateLimitBookings), func(c *gin.Context) { c.Status(http.StatusCreated) })
+	send := func(path, userID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"user_id":"`+userID+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// the queue group's burst of 2, then 429 with about a second to wait
+	for i := 0; i < 2; i++ {
+		if w := send("/queue/claim", "u-1"); w.Code != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d", i, w.Code)
+		}
+	}
+	w := send("/queue/claim", "u-1")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+		t.Fatalf("expected 429 with Retry-After 1, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	// other groups keep buckets of their own
+	if w := send("/reservations", "u-1"); w.Code != http.StatusCreated {
+		t.Fatalf("expected the bookings group to be unaffected, got %d", w.Code)
+	}
+	// the IP allows twice the group's burst however many users it names
+	passed := 0
+	for i := 0; i < 6; i++ {
+		if send("/queue/claim", fmt.Sprintf("u-%d", i+2)).Code == http.StatusOK {
+			passed++
+		}
+	}
+	if passed != 2 {
+		t.Fatalf("expected the IP's 4 tokens to leave 2 for other users, %d passed", passed)
+	}
+}
+
+func TestCORSAllowedOrigins(t *testing.T) {
+	cases := []struct {
+		name        string
+		allowed     []string
+		method      string
+		origin      string
+		wantCode    int
+		wantOrigin  string
+		wantCredits string
+	}{
+		{"wildcard get", nil, http.MethodGet, "https://evil.example", http.StatusOK, "*", ""},
+		{"wildcard preflight", nil, http.MethodOptions, "https://app.example", http.StatusNoContent, "*", ""},
+		{"allowed get", []string{"https://app.example"}, http.MethodGet, "https://app.example", http.StatusOK, "https://app.example", "true"},
+		{"allowed preflight", []string{"https://app.example"}, http.MethodOptions, "https://app.example", http.StatusNoContent, "https://app.example", "true"},
+		{"disallowed get", []string{"https://app.example"}, http.MethodGet, "https://evil.example", http.StatusOK, "", ""},
+		{"disallowed preflight", []string{"https://app.example"}, http.MethodOptions, "https://evil.example", http.StatusForbidden, "", ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(CORS(tc.allowed))
+			router.GET("/conferences", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			req := httptest.NewRequest(tc.method, "/conferences", nil)
+			req.Header.Set("Origin", tc.origin)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tc.wantCode {
+				t.Fatalf("expected %d, got %d", tc.wantCode, w.Code)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tc.wantOrigin {
+				t.Fatalf("expected Allow-Origin %q, got %q", tc.wantOrigin, got)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Credentials"); got != tc.wantCredits {
+				t.Fatalf("expected Allow-Credentials %q, got %q", tc.wantCredits, got)
+			}
+		})
+	}
+}
+
+func TestCreatedResourcesCarryLocation(t *testing.T) {
+	app := newTestApp(t)
+
+	var user struct {
+		ID   string `json:"id"`
+		Self string `json:"self"`
+	}
+	w := serve(http.MethodPost, "/users", app.CreateUser, "/users", `{"name":"Alice","email":"alice@example.com"}`)
+	if err := json.Unmarshal(w.Body.Bytes(), &user); err != nil || w.Code != http.StatusCreated {
+		t.Fatalf("expected 201 with a user, got %d %s", w.Code, w.Body)
+	}
+	if loc := w.Header().Get("Location"); loc != "/api/v1/users/"+user.ID || user.Self != loc {
+		t.Fatalf("expected Location and self for user %s, got %q and %q", user.ID, loc, user.Self)
+	}
+	w = serve(http.MethodPost, "/users", app.CreateUser, "/users", `{"name":"Alice","email":"ALICE@example.com"}`)
+	if w.Code != http.StatusConflict || w.Header().Get("Location") != "/api/v1/users/"+user.ID {
+		t.Fatalf("expected 409 pointing at the existing user, got %d %q", w.Code, w.Header().Get("Location"))
+	}
+
+	var booking struct {
+		ID string `json:"id"`
+	}
+	w = serve(http.MethodPost, "/bookings", app.CreateBooking, "/bookings",
+		`{"user_id":"`+user.ID+`","conference_id":"conf-1","ticket_count":1}`)
+	if err := json.Unmarshal(w.Body.Bytes(), &booking); err != nil || w.Header().Get("Location") != "/api/v1/bookings/"+booking.ID {
+		t.Fatalf("expected Location for booking %s, got %q", booking.ID, w.Header().Get("Location"))
+	}
+
+	var created struct {
+		Reservation struct {
+			ID string `json:"id"`
+		} `json:"reservation"`
+		Self string `json:"self"`
+	}
+	w = serve(http.MethodPost, "/reservations", app.CreateReservation, "/reservations",
+		`{"user_id":"`+user.ID+`","conference_id":"conf-2","ticket_count":1}`)
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("unexpected body %s", w.Body)
+	}
+	if loc := w.Header().Get("Location"); loc != "/api/v1/reservations/"+created.Reservation.ID || created.Self != loc {
+		t.Fatalf("expected Location and self for reservation %s, got %q and %q", created.Reservation.ID, loc, created.Self)
+	}
+}
+
+func TestExportBookingsCSV(t *testing.T) {
+	app, db := newTestAppWithDB(t)
+	alice, _ := app.db.CreateUser("Alice", "alice@example.com")
+	bob, _ := app.db.CreateUser("Bob", "bob@example.com")
+	app.db.CreateBooking(alice.ID, "conf-1", 2, database.BookingOptions{})
+	app.db.CreateBooking(bob.ID, "conf-1", 1, database.BookingOptions{})
+	app.db.CreateBooking(bob.ID, "conf-2", 1, database.BookingOptions{})
+	delete(db.Users, bob.ID) // bookings outlive a removed user
+
+	w := serve(http.MethodGet, "/bookings/export", app.ExportBookings, "/bookings/export?conference_id=conf-1", "")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Disposition"), "attachment") {
+		t.Fatalf("expected a CSV attachment, got %d %q", w.Code, w.Header().Get("Content-Disposition"))
+	}
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("expected valid CSV: %v", err)
+	}
+	if len(rows) != 3 || rows[0][0] != "booking_id" {
+		t.Fatalf("expected a header and 2 conf-1 rows, got %v", rows)
+	}
+	blank := 0
+	for _, row := range rows[1:] {
+		if row[1] == "" && row[2] == "" {
+			blank++
+		}
+	}
+	if blank != 1 {
+		t.Fatalf("expected the deleted user's row to have blank name and email, got %v", rows)
+	}
+
+	w = serve(http.MethodGet, "/bookings/export", app.ExportBookings, "/bookings/export", "")
+	if rows, _ := csv.NewReader(w.Body).ReadAll(); len(rows) != 4 {
+		t.Fatalf("expected a header and 3 rows for every conference, got %d", len(rows))
+	}
+}
+
+func TestResetRequiresAdminToken(t *testing.T) {
+	app := newTestApp(t)
+	user, _ := app.db.CreateUser("Alice", "alice@example.com")
+
+	reset := func(token, header string) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.POST("/admin/reset", RequireAdminToken(token), app.ResetDatabase)
+		req := httptest.NewRequest(http.MethodPost, "/admin/reset", nil)
+		if header != "" {
+			req.Header.Set("X-Admin-Token", header)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := reset("", "anything"); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 while ADMIN_TOKEN is unset, got %d", w.Code)
+	}
+	if w := reset("s3cret", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without the token, got %d", w.Code)
+	}
+	if _, err := app.db.GetUser(user.ID); err != nil {
+		t.Fatalf("expected rejected resets to leave data alone, got %v", err)
+	}
+
+	w := reset("s3cret", "s3cret")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 with the token, got %d: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Conferences int `json:"conferences"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Conferences != 3 {
+		t.Fatalf("expected the 3 sample conferences, got %s", w.Body)
+	}
+	if _, err := app.db.GetUser(user.ID); err == nil {
+		t.Fatalf("expected users to be cleared by the reset")
+	}
+}
+
+func TestOversizedBodyIs413(t *testing.T) {
+	app := newTestApp(t)
+	router := gin.New()
+	router.Use(LimitBody(64))
+	router.POST("/bookings", app.CreateBooking)
+
+	big := `{"user_id":"` + strings.Repeat("x", 100) + `","conference_id":"conf-1","ticket_count":1}`
+	req := httptest.NewRequest(http.MethodPost, "/bookings", strings.NewReader(big))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413 for a declared oversized body, got %d", w.Code)
+	}
+
+	// without a Content-Length the cap is hit while binding
+	req = httptest.NewRequest(http.MethodPost, "/bookings", strings.NewReader(big))
+	req.Header.Set("Content-Type", "application/json")
+	req.ContentLength = -1
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413 for a streamed oversized body, got %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestDeclinedPaymentIs402(t *testing.T) {
+	app, db := newTestAppWithDB(t)
+	db.Payments = database.NewSimulatedPayments(100)
+	user, _ := app.db.CreateUser("Alice", "alice@example.com")
+	res, err := app.db.CreateReservation(user.ID, "conf-1", 1, database.ReservationOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	w := serve(http.MethodPost, "/reservations/:id/confirm", app.ConfirmReservation, "/reservations/"+res.ID+"/confirm", "")
+	if w.Code != http.StatusPaymentRequired {
+		t.Fatalf("expected 402, got %d: %s", w.Code, w.Body)
+	}
+	if _, err := app.db.GetReservation(res.ID); err != nil {
+		t.Fatalf("expected the reservation to survive a declined payment, got %v", err)
+	}
+}
+
+func TestHoldsPaidThroughProviderBookOnlyWhenPaymentCompletes(t *testing.T) {
+	app := newTestApp(t)
+	provider := payments.NewMock()
+	app.UsePayments(provider, "usd")
+	user, _ := app.db.CreateUser("Alice", "alice@example.com")
+
+	w := serve(http.MethodPost, "/reservations", app.CreateReservation, "/reservations",
+		`{"user_id":"`+user.ID+`","conference_id":"conf-1","ticket_count":2}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body)
+	}
+	var created struct {
+		Reservation struct {
+			ID                  string `json:"id"`
+			PaymentID           string `json:"payment_id"`
+			PaymentClientSecret string `json:"payment_client_secret"`
+		} `json:"reservation"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("bad json: %v", err)
+	}
+	res := created.Reservation
+	status, charge, ok := provider.Status(res.PaymentID)
+	if !ok || res.PaymentClientSecret == "" || charge.ReservationID != res.ID || charge.Currency != "usd" || status != "requires_payment_method" {
+		t.Fatalf("expected an open intent for the hold, got %+v (%s, %+v)", res, status, charge)
+	}
+
+	// the version is checked on the confirm call, not when the payment arrives
+	conf, _ := app.db.GetConference("conf-1")
+	w = serve(http.MethodPost, "/reservations/:id/confirm", app.ConfirmReservation, "/reservations/"+res.ID+"/confirm",
+		fmt.Sprintf(`{"expected_version":%d}`, conf.Version-1))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected a stale version refused with 409, got %d: %s", w.Code, w.Body)
+	}
+
+	// confirming only says the payment is pending
+	w = serve(http.MethodPost, "/reservations/:id/confirm", app.ConfirmReservation, "/reservations/"+res.ID+"/confirm",
+		fmt.Sprintf(`{"attendees":["Alice","Bob"],"expected_version":%d}`, conf.Version))
+	if w.Code != http.StatusAccepted || !strings.Contains(w.Body.String(), res.PaymentClientSecret) {
+		t.Fatalf("expected 202 with the client secret, got %d: %s", w.Code, w.Body)
+	}
+	if bookings, _ := app.db.GetUserBookings(user.ID, 0, 0); len(bookings) != 0 {
+		t.Fatalf("expected no booking before the payment completes, got %d", len(bookings))
+	}
+
+	booking, err := app.db.CompletePayment(res.PaymentID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if booking.PaymentID != res.PaymentID || booking.TicketsBooked != 2 || !slices.Equal(booking.Attendees, []string{"Alice", "Bob"}) {
+		t.Fatalf("expected the paid hold booked with the attendees named on confirm, got %+v", booking)
+	}
+
+	// cancelling a hold abandons its intent
+	other, _ := app.db.CreateReservation(user.ID, "conf-2", 1, database.ReservationOptions{})
+	w = serve(http.MethodPost, "/reservations/:id/confirm", app.ConfirmReservation, "/reservations/"+other.ID+"/confirm", "")
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected a hold made without an intent to get one on confirm, got %d: %s", w.Code, w.Body)
+	}
+	held, _ := app.db.GetReservation(other.ID)
+	w = serve(http.MethodDelete, "/reservations/:id", app.CancelReservation, "/reservations/"+other.ID, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if status, _, _ := provider.Status(held.PaymentID); status != "canceled" {
+		t.Fatalf("expected the intent canceled with the hold, got %q", status)
+	}
+
+	// seats can't be booked around the payment
+	w = serve(http.MethodPost, "/bookings", app.CreateBooking, "/bookings",
+		`{"user_id":"`+user.ID+`","conference_id":"conf-3","ticket_count":1}`)
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), CodePaymentRequired) {
+		t.Fatalf("expected a direct booking refused with 409, got %d: %s", w.Code, w.Body)
+	}
+
+	// a provider outage releases the seats instead of leaving them held
+	provider.FailWith = errors.New("connection refused")
+	w = serve(http.MethodPost, "/reservations", app.CreateReservation, "/reservations",
+		`{"user_id":"`+user.ID+`","conference_id":"conf-3","ticket_count":1,"tier":"General"}`)
+	if w.Code != http.StatusBadGateway {
+		t.Fatalf("expected 502, got %d: %s", w.Code, w.Body)
+	}
+	if holds := app.db.GetUserReservations(user.ID); len(holds) != 0 {
+		t.Fatalf("expected the hold released after the provider failed, got %d", len(holds))
+	}
+}
+
+func TestPaymentWebhookBooksOnceAndRefundsLapsedHolds(t *testing.T) {
+	app, db := newTestAppWithDB(t)
+	provider := payments.NewMock()
+	provider.WebhookSecret = "whsec_test"
+	app.UsePayments(provider, "usd")
+	user, _ := app.db.CreateUser("Alice", "alice@example.com")
+
+	deliver := func(payload []byte, signature string) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.POST("/payments/webhook", app.PaymentWebhook)
+		req := httptest.NewRequest(http.MethodPost, "/payments/webhook", bytes.NewReader(payload))
+		req.Header.Set(payments.SignatureHeader, signature)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	hold := func(conferenceID string) *models.SeatReservation {
+		w := serve(http.MethodPost, "/reservations", app.CreateReservation, "/reservations",
+			`{"user_id":"`+user.ID+`","conference_id":"`+conferenceID+`","ticket_count":1}`)
+		var body struct {
+			Reservation *models.SeatReservation `json:"reservation"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusCreated || body.Reservation.PaymentID == "" {
+			t.Fatalf("expected a hold with a payment intent, got %d: %s", w.Code, w.Body)
+		}
+		return body.Reservation
+	}
+
+	paid := hold("conf-1")
+	payload, signature := provider.Webhook("evt_1", payments.EventPaymentSucceeded, paid.PaymentID)
+	if w := deliver(payload, "t=1,v1=forged"); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected a forged signature rejected with 400, got %d", w.Code)
+	}
+	if w := deliver(payload, signature); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "booked") {
+		t.Fatalf("expected the payment booked, got %d: %s", w.Code, w.Body)
+	}
+	// the provider delivers at least once
+	if w := deliver(payload, signature); w.Code != http.StatusOK {
+		t.Fatalf("expected a repeated delivery acknowledged, got %d: %s", w.Code, w.Body)
+	}
+	if bookings, _ := app.db.GetUserBookings(user.ID, 0, 0); len(bookings) != 1 || bookings[0].PaymentID != paid.PaymentID {
+		t.Fatalf("expected exactly one booking for the payment, got %+v", bookings)
+	}
+
+	// a cancellation event for a hold that is still waiting releases it
+	abandoned := hold("conf-2")
+	payload, signature = provider.Webhook("evt_2", payments.EventPaymentCanceled, abandoned.PaymentID)
+	if w := deliver(payload, signature); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "hold released") {
+		t.Fatalf("expected the hold released, got %d: %s", w.Code, w.Body)
+	}
+
+	// paying after the hold lapsed gets the money back instead of seats
+	late := hold("conf-1")
+	db.SetClock(func() time.Time { return time.Now().Add(time.Hour) })
+	payload, signature = provider.Webhook("evt_3", payments.EventPaymentSucceeded, late.PaymentID)
+	if w := deliver(payload, signature); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "refunded") {
+		t.Fatalf("expected a refund, got %d: %s", w.Code, w.Body)
+	}
+	if status, _, _ := provider.Status(late.PaymentID); status != "refunded" {
+		t.Fatalf("expected the late payment refunded, got %q", status)
+	}
+}
+
+func TestCartIsPaidWithOneIntentThatBooksEveryItem(t *testing.T) {
+	app := newTestApp(t)
+	provider := payments.NewMock()
+	provider.WebhookSecret = "whsec_test"
+	app.UsePayments(provider, "usd")
+	user, _ := app.db.CreateUser("Alice", "alice@example.com")
+
+	w := serve(http.MethodPost, "/carts", app.CreateCart, "/carts", `{"user_id":"`+user.ID+`"}`)
+	var created struct {
+		Cart *models.Cart `json:"cart"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil || w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body)
+	}
+	cartID := created.Cart.ID
+	for _, item := range []string{`{"conference_id":"conf-1","ticket_count":1}`, `{"conference_id":"conf-2","ticket_count":2}`} {
+		if w := serve(http.MethodPut, "/carts/:id/items", app.SetCartItem, "/carts/"+cartID+"/items", item); w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+		}
+	}
+	if w := serve(http.MethodPut, "/carts/:id/items", app.SetCartItem, "/carts/"+cartID+"/items", `{"conference_id":"nope","ticket_count":1}`); w.Code != http.StatusNotFound {
+		t.Fatalf("expected an unknown conference to 404, got %d: %s", w.Code, w.Body)
+	}
+
+	w = serve(http.MethodPost, "/carts/:id/checkout", app.CheckoutCart, "/carts/"+cartID+"/checkout", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	cart, _ := app.db.GetCart(cartID)
+	status, charge, ok := provider.Status(cart.PaymentID)
+	if !ok || charge.CartID != cartID || charge.Amount != cart.TotalAmount || status != "requires_payment_method" {
+		t.Fatalf("expected one intent for the cart total, got %+v (%s, %+v)", cart, status, charge)
+	}
+	for _, id := range cart.ReservationIDs {
+		if res, _ := app.db.GetReservation(id); res.PaymentID != cart.PaymentID {
+			t.Fatalf("expected every hold to carry the cart's intent, got %+v", res)
+		}
+	}
+	if w := serve(http.MethodPost, "/carts/:id/confirm", app.ConfirmCart, "/carts/"+cartID+"/confirm", ""); w.Code != http.StatusAccepted {
+		t.Fatalf("expected 202 while the payment is pending, got %d: %s", w.Code, w.Body)
+	}
+
+	router := gin.New()
+	router.POST("/payments/webhook", app.PaymentWebhook)
+	payload, signature := provider.Webhook("evt_1", payments.EventPaymentSucceeded, cart.PaymentID)
+	req := httptest.NewRequest(http.MethodPost, "/payments/webhook", bytes.NewReader(payload))
+	req.Header.Set(payments.SignatureHeader, signature)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "booked") {
+		t.Fatalf("expected the cart booked, got %d: %s", w.Code, w.Body)
+	}
+	if bookings, _ := app.db.GetUserBookings(user.ID, 0, 0); len(bookings) != 2 {
+		t.Fatalf("expected both items booked by the one payment, got %d", len(bookings))
+	}
+	if cart, _ := app.db.GetCart(cartID); cart.Status != models.CartStatusCompleted {
+		t.Fatalf("expected the cart completed, got %q", cart.Status)
+	}
+	if w := serve(http.MethodDelete, "/carts/:id", app.CancelCart, "/carts/"+cartID, ""); w.Code != http.StatusConflict {
+		t.Fatalf("expected a booked cart to refuse cancelling, got %d: %s", w.Code, w.Body)
+	}
+
+	// cancelling a booking refunds the payment first, and keeps the booking if that fails
+	bookings, _ := app.db.GetUserBookings(user.ID, 0, 0)
+	provider.FailWith = errors.New("connection refused")
+	w = serve(http.MethodDelete, "/bookings/:id", app.CancelBooking, "/bookings/"+bookings[0].ID, "")
+	if w.Code != http.StatusBadGateway || !strings.Contains(w.Body.String(), CodePaymentProvider) {
+		t.Fatalf("expected a failed refund to 502, got %d: %s", w.Code, w.Body)
+	}
+	if booking := app.db.GetBooking(bookings[0].ID); booking.Status == "cancelled" {
+		t.Fatalf("expected the booking kept when the refund failed")
+	}
+	provider.FailWith = nil
+	w = serve(http.MethodDelete, "/bookings/:id", app.CancelBooking, "/bookings/"+bookings[0].ID, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if status, _, _ := provider.Status(cart.PaymentID); status != "refunded" {
+		t.Fatalf("expected the cart's payment refunded, got %q", status)
+	}
+	for _, b := range bookings {
+		if booking := app.db.GetBooking(b.ID); booking.Status != "cancelled" {
+			t.Fatalf("expected every booking paid by the refunded payment cancelled, got %+v", booking)
+		}
+	}
+}
+
+func TestSeatMapShowsHeldSeatsAndRejectsTakenOnes(t *testing.T) {
+	app := newTestApp(t)
+	alice, _ := app.db.CreateUser("Alice", "alice@example.com")
+	bob, _ := app.db.CreateUser("Bob", "bob@example.com")
+	conf, err := app.db.CreateConference("Seated", "Berlin", 4, 50, time.Now().AddDate(0, 1, 0), "", database.ConferenceOptions{
+		Sections: []models.Section{{Name: "Stalls", Rows: 2, SeatsPerRow: 2}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	reserve := func(userID string) *httptest.ResponseRecorder {
+		return serve(http.MethodPost, "/reservations", app.CreateReservation, "/reservations",
+			`{"user_id":"`+userID+`","conference_id":"`+conf.ID+`","ticket_count":1,"seat_ids":["Stalls-2-1"]}`)
+	}
+	if w := reserve(alice.ID); w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body)
+	}
+	w := reserve(bob.ID)
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), `"code":"seat_taken"`) {
+		t.Fatalf("expected 409 seat_taken, got %d: %s", w.Code, w.Body)
+	}
+
+	w = serve(http.MethodGet, "/conferences/:id/seats", app.GetSeatMap, "/conferences/"+conf.ID+"/seats", "")
+	var seats database.SeatMap
+	if err := json.Unmarshal(w.Body.Bytes(), &seats); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if seats.Free != 3 || seats.Held != 1 || seats.Seats[2].ID != "Stalls-2-1" || seats.Seats[2].State != database.SeatHeld {
+		t.Fatalf("expected Stalls-2-1 held, got %+v", seats)
+	}
+}
+
+func TestBookingTicketsCarrySignedQRCodesAndDownload(t *testing.T) {
+	app := newTestApp(t)
+	alice, _ := app.db.CreateUser("Alice", "alice@example.com")
+	conf, _ := app.db.CreateConference("Seated", "Berlin", 2, 50, time.Now().AddDate(0, 1, 0), "", database.ConferenceOptions{
+		Sections: []models.Section{{Name: "Stalls", Rows: 1, SeatsPerRow: 2}},
+	})
+	booking, err := app.db.CreateBooking(alice.ID, conf.ID, 2, database.BookingOptions{Attendees: []string{"Alice", "Carol"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	other, _ := app.db.CreateBooking(alice.ID, "conf-1", 1, database.BookingOptions{})
+
+	list := func() []ticketView {
+		w := serve(http.MethodGet, "/bookings/:id/tickets", app.GetBookingTickets, "/bookings/"+booking.ID+"/tickets", "")
+		var body struct {
+			Tickets []ticketView `json:"tickets"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+		}
+		return body.Tickets
+	}
+	issued := list()
+	if len(issued) != 2 || issued[1].Attendee != "Carol" || issued[1].SeatID != "Stalls-1-2" || issued[1].Status != models.TicketStatusValid {
+		t.Fatalf("unexpected tickets %+v", issued)
+	}
+	if id, err := app.tickets.Verify(issued[1].QRPayload); err != nil || id != issued[1].ID {
+		t.Fatalf("expected payload signed for %s, got %q, %v", issued[1].ID, id, err)
+	}
+
+	download := func(bookingID, ticketID, format string) *httptest.ResponseRecorder {
+		return serve(http.MethodGet, "/bookings/:id/tickets/:ticketID/download", app.DownloadTicket,
+			"/bookings/"+bookingID+"/tickets/"+ticketID+"/download?format="+format, "")
+	}
+	if w := download(booking.ID, issued[0].ID, "png"); w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" || !strings.HasPrefix(w.Body.String(), "\x89PNG") {
+		t.Fatalf("expected a PNG, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	if w := download(booking.ID, issued[0].ID, "pdf"); w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), "%PDF") || !strings.Contains(w.Body.String(), "Seat: Stalls-1-1") {
+		t.Fatalf("expected a PDF, got %d", w.Code)
+	}
+	if w := download(booking.ID, issued[0].ID, "gif"); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown format, got %d", w.Code)
+	}
+	if w := download(other.ID, issued[0].ID, "png"); w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), `"code":"ticket_not_found"`) {
+		t.Fatalf("expected 404 for another booking's ticket, got %d: %s", w.Code, w.Body)
+	}
+
+	if _, err := app.db.CancelBooking(booking.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if voided := list(); voided[0].Status != models.TicketStatusVoid || voided[0].QRPayload != "" {
+		t.Fatalf("expected void tickets without payloads, got %+v", voided[0])
+	}
+	if w := download(booking.ID, issued[0].ID, "png"); w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), `"code":"ticket_void"`) {
+		t.Fatalf("expected 409 ticket_void, got %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestCheckInAdmitsSignedTicketsOnce(t *testing.T) {
+	app := newTestApp(t)
+	alice, _ := app.db.CreateUser("Alice", "alice@example.com")
+	booking, _ := app.db.CreateBooking(alice.ID, "conf-2", 2, database.BookingOptions{})
+	issued, _ := app.db.BookingTickets(booking.ID)
+
+	checkIn := func(code string) *httptest.ResponseRecorder {
+		return serve(http.MethodPost, "/checkin", app.CheckIn, "/checkin", `{"code":"`+code+`","conference_id":"conf-2"}`)
+	}
+	if w := checkIn(app.tickets.Sign(issued[0].ID)); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"status":"checked_in"`) {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	w := checkIn(app.tickets.Sign(issued[0].ID))
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), `"code":"already_checked_in"`) || !strings.Contains(w.Body.String(), `"checked_in_at"`) {
+		t.Fatalf("expected 409 already_checked_in with the ticket, got %d: %s", w.Code, w.Body)
+	}
+	forged := tickets.NewSigner([]byte("someone else")).Sign(issued[1].ID)
+	if w := checkIn(forged); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"code":"invalid_ticket_code"`) {
+		t.Fatalf("expected 400 invalid_ticket_code, got %d: %s", w.Code, w.Body)
+	}
+
+	w = serve(http.MethodGet, "/conferences/:id/checkins", app.GetCheckIns, "/conferences/conf-2/checkins", "")
+	var body struct {
+		CheckIns database.CheckInStats `json:"checkins"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if body.CheckIns.Tickets != 2 || body.CheckIns.CheckedIn != 1 || body.CheckIns.NotArrived != 1 {
+		t.Fatalf("unexpected attendance %+v", body.CheckIns)
+	}
+}
+
+func TestGetUserByIDAndEmail(t *testing.T) {
+	app := newTestApp(t)
+	user, _ := app.db.CreateUser("Alice", "Alice@Example.com")
+
+	decode := func(w *httptest.ResponseRecorder) (id, self string) {
+		var body struct {
+			ID   string `json:"id"`
+			Self string `json:"self"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("bad json: %v", err)
+		}
+		return body.ID, body.Self
+	}
+
+	w := serve(http.MethodGet, "/users/:userID", app.GetUser, "/users/"+user.ID, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if id, self := decode(w); id != user.ID || self != "/api/v1/users/"+user.ID {
+		t.Fatalf("expected the user with its self link, got %s %s", id, self)
+	}
+	if w := serve(http.MethodGet, "/users/:userID", app.GetUser, "/users/nope", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown ID, got %d", w.Code)
+	}
+
+	w = serve(http.MethodGet, "/users", app.FindUser, "/users?email=%20ALICE@example.COM%20", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for a differently cased email, got %d", w.Code)
+	}
+	if id, _ := decode(w); id != user.ID {
+		t.Fatalf("expected to find %s, got %s", user.ID, id)
+	}
+	if w := serve(http.MethodGet, "/users", app.FindUser, "/users?email=bob@example.com", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown email, got %d", w.Code)
+	}
+	if w := serve(http.MethodGet, "/users", app.FindUser, "/users", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without an email, got %d", w.Code)
+	}
+}
+
+func TestRegisterLoginAndReservationOwnership(t *testing.T) {
+	app := newTestApp(t)
+	router := gin.New()
+	auth := app.RequireAuth()
+	router.POST("/auth/register", app.Register)
+	router.POST("/auth/login", app.Login)
+	router.POST("/reservations", auth, app.CreateReservation)
+	router.POST("/reservations/:id/confirm", auth, app.ConfirmReservation)
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	type session struct {
+		Token string `json:"token"`
+		User  struct {
+			ID string `json:"id"`
+		} `json:"user"`
+	}
+	decode := func(w *httptest.ResponseRecorder) session {
+		var s session
+		if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil || s.Token == "" {
+			t.Fatalf("expected a token, got %d: %s", w.Code, w.Body)
+		}
+		return s
+	}
+
+	if w := do(http.MethodPost, "/auth/register", "", `{"name":"Alice","email":"alice@example.com","password":"short"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for a short password, got %d", w.Code)
+	}
+	w := do(http.MethodPost, "/auth/register", "", `{"name":"Alice","email":"alice@example.com","password":"correct horse"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body)
+	}
+	alice := decode(w)
+	if w := do(http.MethodPost, "/auth/register", "", `{"name":"Alice","email":"alice@example.com","password":"correct horse"}`); w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for a taken email, got %d", w.Code)
+	}
+	bob := decode(do(http.MethodPost, "/auth/register", "", `{"name":"Bob","email":"bob@example.com","password":"battery staple"}`))
+
+	if w := do(http.MethodPost, "/auth/login", "", `{"email":"alice@example.com","password":"wrong password"}`); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a wrong password, got %d", w.Code)
+	}
+	if w := do(http.MethodPost, "/auth/login", "", `{"email":"ALICE@example.com","password":"correct horse"}`); w.Code != http.StatusOK || decode(w).User.ID != alice.User.ID {
+		t.Fatalf("expected login to return Alice's token, got %d: %s", w.Code, w.Body)
+	}
+
+	hold := `{"user_id":"` + alice.User.ID + `","conference_id":"conf-1","ticket_count":1}`
+	if w := do(http.MethodPost, "/reservations", "", hold); w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Fatalf("expected 401 with a challenge without a token, got %d", w.Code)
+	}
+	if w := do(http.MethodPost, "/reservations", "not.a.token", hold); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a bad token, got %d", w.Code)
+	}
+	if w := do(http.MethodPost, "/reservations", bob.Token, hold); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 holding seats for someone else, got %d", w.Code)
+	}
+	w = do(http.MethodPost, "/reservations", alice.Token, hold)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body)
+	}
+	var created struct {
+		Reservation struct {
+			ID string `json:"id"`
+		} `json:"reservation"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+
+	confirm := "/reservations/" + created.Reservation.ID + "/confirm"
+	if w := do(http.MethodPost, confirm, bob.Token, ""); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 confirming someone else's hold, got %d", w.Code)
+	}
+	if w := do(http.MethodPost, confirm, alice.Token, ""); w.Code != http.StatusOK {
+		t.Fatalf("expected the owner to confirm, got %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestTokensFromAnotherKeyOrExpiredAreRejected(t *testing.T) {
+	issuer := NewTokenIssuer([]byte("key one"), time.Hour)
+	token, _, err := issuer.Issue("user-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if id, err := issuer.Verify(token); err != nil || id != "user-1" {
+		t.Fatalf("expected user-1, got %q, %v", id, err)
+	}
+	if _, err := NewTokenIssuer([]byte("key two"), time.Hour).Verify(token); err == nil {
+		t.Fatalf("expected a token signed with another key to be rejected")
+	}
+	expired, _, _ := NewTokenIssuer([]byte("key one"), -time.Minute).Issue("user-1")
+	if _, err := issuer.Verify(expired); err == nil {
+		t.Fatalf("expected an expired token to be rejected")
+	}
+}
+
+func TestAdminRoutesNeedAdminRoleOrToken(t *testing.T) {
+	app := newTestApp(t)
+	app.UseAdminEmails([]string{" Root@Example.com "}, "s3cret")
+	router := gin.New()
+	router.POST("/auth/register", app.Register)
+	admin := router.Group("/admin", app.RequireAdmin("s3cret"))
+	admin.GET("/users", app.ListUsers)
+	admin.PUT("/users/:userID/role", app.SetUserRole)
+	router.GET("/users/:userID/reservations", app.RequireAuth(), app.GetUserReservations)
+	router.GET("/users", app.RequireAuth(), app.FindUser)
+	router.GET("/users/:userID", app.RequireAuth(), app.GetUser)
+	router.POST("/users/bulk", app.RequireAdmin("s3cret"), app.CreateUsers)
+	do := func(method, path, token, body string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if len(header) == 2 {
+			req.Header.Set(header[0], header[1])
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	register := func(name, email string, header ...string) (id, token, role string) {
+		w := do(http.MethodPost, "/auth/register", "", `{"name":"`+name+`","email":"`+email+`","password":"long enough"}`, header...)
+		var resp struct {
+			Token string `json:"token"`
+			User  struct {
+				ID   string `json:"id"`
+				Role string `json:"role"`
+			} `json:"user"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusCreated {
+			t.Fatalf("register %s: got %d: %s", email, w.Code, w.Body)
+		}
+		return resp.User.ID, resp.Token, resp.User.Role
+	}
+	// an admin email only registers together with the admin token
+	if w := do(http.MethodPost, "/auth/register", "", `{"name":"Root","email":"root@example.com","password":"long enough"}`); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 registering an admin email without the token, got %d: %s", w.Code, w.Body)
+	}
+	rootID, rootToken, rootRole := register("Root", "root@example.com", "X-Admin-Token", "s3cret")
+	userID, userToken, userRole := register("Ann", "ann@example.com")
+	if rootRole != "admin" || userRole != "user" {
+		t.Fatalf("expected root to be admin and Ann a user, got %q and %q", rootRole, userRole)
+	}
+
+	// user lookups are the caller's own unless they are an admin
+	if w := do(http.MethodGet, "/users/"+userID, "", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 looking up a user without a token, got %d", w.Code)
+	}
+	if w := do(http.MethodGet, "/users/"+rootID, userToken, ""); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 looking up another user, got %d", w.Code)
+	}
+	if w := do(http.MethodGet, "/users/"+userID, userToken, ""); w.Code != http.StatusOK {
+		t.Fatalf("expected users to see themselves, got %d", w.Code)
+	}
+	if w := do(http.MethodGet, "/users?email=root@example.com", userToken, ""); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 finding another user by email, got %d", w.Code)
+	}
+	if w := do(http.MethodGet, "/users?email=nobody@example.com", userToken, ""); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 rather than 404 for an unknown email, got %d", w.Code)
+	}
+	if w := do(http.MethodGet, "/users?email=+ANN@example.com", userToken, ""); w.Code != http.StatusOK {
+		t.Fatalf("expected users to find themselves by email, got %d", w.Code)
+	}
+	if w := do(http.MethodGet, "/users?email=ann@example.com", rootToken, ""); w.Code != http.StatusOK {
+		t.Fatalf("expected admins to find anyone, got %d", w.Code)
+	}
+
+	if w := do(http.MethodGet, "/admin/users", "", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without credentials, got %d", w.Code)
+	}
+	if w := do(http.MethodGet, "/admin/users", userToken, ""); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for a regular user, got %d", w.Code)
+	}
+	if w := do(http.MethodGet, "/admin/users", "", "", "X-Admin-Token", "s3cret"); w.Code != http.StatusOK {
+		t.Fatalf("expected the admin token to still work, got %d", w.Code)
+	}
+	w := do(http.MethodGet, "/admin/users?limit=1&offset=1", rootToken, "")
+	var page struct {
+		Users []struct {
+			ID string `json:"id"`
+		} `json:"users"`
+		Total int `json:"total"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if page.Total != 2 || len(page.Users) != 1 || page.Users[0].ID != userID {
+		t.Fatalf("expected the second of 2 users to be Ann, got %+v", page)
+	}
+
+	if w := do(http.MethodGet, "/users/"+userID+"/reservations", rootToken, ""); w.Code != http.StatusOK {
+		t.Fatalf("expected admins to see other users' reservations, got %d", w.Code)
+	}
+	if w := do(http.MethodGet, "/users/"+rootID+"/reservations", userToken, ""); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for another user's reservations, got %d", w.Code)
+	}
+	if w := do(http.MethodPost, "/users/bulk", userToken, `[{"name":"Eve","email":"eve@example.com"}]`); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for a bulk import by a regular user, got %d", w.Code)
+	}
+	if w := do(http.MethodPost, "/users/bulk", rootToken, `[{"name":"Eve","email":"eve@example.com"}]`); w.Code != http.StatusCreated {
+		t.Fatalf("expected admins to import users, got %d: %s", w.Code, w.Body)
+	}
+
+	if w := do(http.MethodPut, "/admin/users/"+userID+"/role", rootToken, `{"role":"owner"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for an unknown role, got %d", w.Code)
+	}
+	if w := do(http.MethodPut, "/admin/users/"+userID+"/role", rootToken, `{"role":"admin"}`); w.Code != http.StatusOK {
+		t.Fatalf("expected the promotion to succeed, got %d: %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodGet, "/admin/users", userToken, ""); w.Code != http.StatusOK {
+		t.Fatalf("expected the promoted user to reach admin routes, got %d", w.Code)
+	}
+}
+
+func TestOrganizersManageOnlyTheirOwnConferences(t *testing.T) {
+	app := newTestApp(t)
+	router := gin.New()
+	router.POST("/auth/register", app.Register)
+	organizers := app.RequireOrganizer("s3cret")
+	router.POST("/conferences", organizers, app.CreateConference)
+	router.PUT("/conferences/:id", organizers, app.UpdateConference)
+	router.DELETE("/conferences/:id", organizers, app.DeleteConference)
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	register := func(name, email, role string) string {
+		w := do(http.MethodPost, "/auth/register", "", `{"name":"`+name+`","email":"`+email+`","password":"long enough"}`)
+		var resp struct {
+			Token string `json:"token"`
+			User  struct {
+				ID string `json:"id"`
+			} `json:"user"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusCreated {
+			t.Fatalf("register %s: got %d: %s", email, w.Code, w.Body)
+		}
+		if _, err := app.db.SetUserRole(resp.User.ID, role); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return resp.Token
+	}
+	owner := register("Olga", "olga@example.com", "organizer")
+	rival := register("Rick", "rick@example.com", "organizer")
+	attendee := register("Ann", "ann@example.com", "user")
+
+	date := time.Now().AddDate(0, 1, 0).Format(time.RFC3339)
+	body := `{"name":"GoLab","location":"Florence","total_tickets":50,"price":200,"date":"` + date + `"}`
+	if w := do(http.MethodPost, "/conferences", attendee, body); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for a regular user, got %d", w.Code)
+	}
+	past := `{"name":"GoLab","location":"Florence","total_tickets":50,"price":200,"date":"2000-01-01T00:00:00Z"}`
+	if w := do(http.MethodPost, "/conferences", owner, past); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a past date, got %d", w.Code)
+	}
+	w := do(http.MethodPost, "/conferences", owner, body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body)
+	}
+	var created struct {
+		Conference struct {
+			ID          string `json:"id"`
+			OrganizerID string `json:"organizer_id"`
+		} `json:"conference"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+	if created.Conference.OrganizerID == "" {
+		t.Fatalf("expected the creator to be recorded as organizer: %s", w.Body)
+	}
+	path := "/conferences/" + created.Conference.ID
+
+	if w := do(http.MethodPut, path, rival, `{"name":"RivalCon"}`); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 editing another organizer's conference, got %d", w.Code)
+	}
+	if w := do(http.MethodDelete, path, rival, ""); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 deleting another organizer's conference, got %d", w.Code)
+	}
+	if w := do(http.MethodPut, path, owner, `{"name":"GoLab 2","location":"Rome"}`); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"GoLab 2"`) {
+		t.Fatalf("expected the organizer to rename it, got %d: %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodDelete, path, owner, ""); w.Code != http.StatusOK {
+		t.Fatalf("expected the organizer to delete it, got %d: %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodDelete, path, owner, ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 once deleted, got %d", w.Code)
+	}
+}
+
+func TestDrainingTurnsAwayNewHoldsButConfirmsExistingOnes(t *testing.T) {
+	app, db := newTestAppWithDB(t)
+	user, _ := db.CreateUser("Alice", "alice@example.com")
+	res, err := db.CreateReservation(user.ID, "conf-1", 1, database.ReservationOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	router := gin.New()
+	router.GET("/health", app.HealthCheck)
+	router.POST("/reservations", app.RejectWhileDraining(), app.CreateReservation)
+	router.POST("/reservations/:id/confirm", app.ConfirmReservation)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	app.StartDraining()
+	if w := do(http.MethodGet, "/health", ""); w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "draining") {
+		t.Fatalf("expected the health check to report draining, got %d %s", w.Code, w.Body.String())
+	}
+	w := do(http.MethodPost, "/reservations", `{"user_id":"`+user.ID+`","conference_id":"conf-2","ticket_count":1}`)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected a new hold to get 503 with Retry-After, got %d %s", w.Code, w.Body.String())
+	}
+	if w := do(http.MethodPost, "/reservations/"+res.ID+"/confirm", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected the existing hold to still confirm, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestStaleExpectedVersionReturnsConflictWithCurrentVersion(t *testing.T) {
+	app, db := newTestAppWithDB(t)
+	user, _ := db.CreateUser("Alice", "alice@example.com")
+	conf, _ := db.GetConference("conf-1")
+	if _, err := db.CreateBooking(user.ID, conf.ID, 1, database.BookingOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	body := fmt.Sprintf(`{"user_id":%q,"conference_id":"conf-1","ticket_count":1,"expected_version":%d}`, user.ID, conf.Version)
+	w := serve(http.MethodPost, "/reservations", app.CreateReservation, "/reservations", body)
+	var resp struct {
+		CurrentVersion int `json:"current_version"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusConflict || resp.CurrentVersion != conf.Version+1 {
+		t.Fatalf("expected 409 with current_version %d, got %d %s", conf.Version+1, w.Code, w.Body.String())
+	}
+}
+
+func TestSearchConferencesReturnsRankedPage(t *testing.T) {
+	app := newTestApp(t)
+	var resp struct {
+		Results []struct {
+			ID    string  `json:"id"`
+			Score float64 `json:"score"`
+		} `json:"results"`
+		Total int `json:"total"`
+	}
+	w := serve(http.MethodGet, "/conferences/search", app.SearchConferences, "/conferences/search?q=kubernetes+seattle", "")
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusOK || resp.Total != 1 || resp.Results[0].ID != "conf-3" || resp.Results[0].Score <= 0 {
+		t.Fatalf("expected conf-3 with a score, got %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(http.MethodGet, "/conferences/search", app.SearchConferences, "/conferences/search?q=+", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without a query, got %d", w.Code)
+	}
+}
+
+func TestErrorsCarryStableCodes(t *testing.T) {
+	app, db := newTestAppWithDB(t)
+	alice, _ := db.CreateUser("Alice", "alice@example.com")
+	bob, _ := db.CreateUser("Bob", "bob@example.com")
+	var body struct {
+		Status string `json:"status"`
+		Code   string `json:"code"`
+		Error  string `json:"error"`
+	}
+	check := func(w *httptest.ResponseRecorder, status int, code string) {
+		t.Helper()
+		json.Unmarshal(w.Body.Bytes(), &body)
+		if w.Code != status || body.Status != "error" || body.Code != code || body.Error == "" {
+			t.Fatalf("expected %d %s, got %d %s", status, code, w.Code, w.Body.String())
+		}
+	}
+
+	check(serve(http.MethodPost, "/reservations", app.CreateReservation, "/reservations",
+		`{"user_id":"`+alice.ID+`","conference_id":"conf-2","ticket_count":76}`), http.StatusConflict, "sold_out")
+	check(serve(http.MethodPost, "/reservations", app.CreateReservation, "/reservations",
+		`{"user_id":"`+alice.ID+`","conference_id":"nope","ticket_count":1}`), http.StatusNotFound, "conference_not_found")
+	check(serve(http.MethodPost, "/reservations", app.CreateReservation, "/reservations", `{"user_id":`), http.StatusBadRequest, CodeInvalidJSON)
+	check(serve(http.MethodPost, "/reservations", app.CreateReservation, "/reservations",
+		`{"user_id":"`+alice.ID+`","conference_id":"conf-1","ticket_count":0}`), http.StatusUnprocessableEntity, CodeValidation)
+
+	if _, err := db.EnqueueWait(alice.ID, "conf-1", 1, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := db.EnqueueWait(bob.ID, "conf-1", 1, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	check(serve(http.MethodPost, "/queue/claim", app.ClaimNext, "/queue/claim",
+		`{"user_id":"`+bob.ID+`","conference_id":"conf-1"}`), http.StatusConflict, "not_your_turn")
+
+	clock := time.Now()
+	db.SetClock(func() time.Time { return clock })
+	res, err := db.CreateReservation(bob.ID, "conf-3", 1, database.ReservationOptions{Tier: "General"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	clock = clock.Add(time.Hour)
+	check(serve(http.MethodPost, "/reservations/:id/confirm", app.ConfirmReservation, "/reservations/"+res.ID+"/confirm", ""),
+		http.StatusGone, "reservation_expired")
+}
+
+func TestOpenAPISpecDescribesTheRoutes(t *testing.T) {
+	w := serve(http.MethodGet, "/openapi.json", ServeOpenAPI(), "/openapi.json", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var spec struct {
+		OpenAPI    string                                       `json:"openapi"`
+		Paths      map[string]map[string]map[string]interface{} `json:"paths"`
+		Components struct {
+			Schemas map[string]interface{} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &spec); err != nil {
+		t.Fatalf("spec is not JSON: %v", err)
+	}
+	if spec.OpenAPI != "3.0.3" {
+		t.Fatalf("expected openapi 3.0.3, got %q", spec.OpenAPI)
+	}
+	for path, method := range map[string]string{
+		"/conferences":               "get",
+		"/reservations":              "post",
+		"/reservations/{id}/confirm": "post",
+		"/queue/claim":               "post",
+		"/users/{userID}/bookings":   "get",
+		"/admin/users/{userID}/role": "put",
+	} {
+		if _, ok := spec.Paths[path][method]; !ok {
+			t.Errorf("expected %s %s to be documented", method, path)
+		}
+	}
+
+	// Every $ref must resolve, and every {param} in a path must be declared
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if r, ok := v["$ref"].(string); ok {
+				if _, ok := spec.Components.Schemas[strings.TrimPrefix(r, "#/components/schemas/")]; !ok {
+					t.Errorf("dangling $ref %s", r)
+				}
+			}
+			for _, child := range v {
+				walk(child)
+			}
+		case []interface{}:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	var raw map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &raw)
+	walk(raw)
+	for path, ops := range spec.Paths {
+		for method, op := range ops {
+			params, _ := op["parameters"].([]interface{})
+			for _, seg := range strings.Split(path, "/") {
+				if !strings.HasPrefix(seg, "{") {
+					continue
+				}
+				name, found := strings.Trim(seg, "{}"), false
+				for _, p := range params {
+					if p := p.(map[string]interface{}); p["in"] == "path" && p["name"] == name {
+						found = true
+					}
+				}
+				if !found {
+					t.Errorf("%s %s does not declare path parameter %s", method, path, name)
+				}
+			}
+		}
+	}
+
+	// The models come from the structs the handlers serialize
+	reservation := spec.Components.Schemas["Reservation"].(map[string]interface{})["properties"].(map[string]interface{})
+	if _, ok := reservation["expires_at"]; !ok {
+		t.Fatalf("expected Reservation to have expires_at, got %v", reservation)
+	}
+
+	docs := serve(http.MethodGet, "/docs", ServeDocs, "/docs", "")
+	if docs.Code != http.StatusOK || !strings.Contains(docs.Body.String(), "/openapi.json") {
+		t.Fatalf("expected Swagger UI pointed at /openapi.json, got %d %s", docs.Code, docs.Body.String())
+	}
+}
+
+func TestConferencesAreCreatedWithTiersThatQueuesCanWaitFor(t *testing.T) {
+	app, db := newTestAppWithDB(t)
+	date := time.Now().AddDate(0, 1, 0).Format(time.RFC3339)
+	body := `{"name":"GoLab","location":"Florence","total_tickets":50,"price":200,"date":"` + date + `",` +
+		`"tiers":[{"name":"VIP","price":500,"total_tickets":5},{"name":"Student","price":80,"total_tickets":10}]}`
+	w := serve(http.MethodPost, "/conferences", app.CreateConference, "/conferences", body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body)
+	}
+	var created struct {
+		Conference models.Conference `json:"conference"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("bad body: %v", err)
+	}
+	if tiers := created.Conference.Tiers; len(tiers) != 2 || tiers[0].Name != "VIP" || tiers[0].AvailableTickets != 5 {
+		t.Fatalf("expected the VIP and Student tiers with every seat available, got %+v", tiers)
+	}
+
+	noSeats := `{"name":"GoLab","location":"Florence","total_tickets":50,"price":200,"date":"` + date + `","tiers":[{"name":"VIP","price":500}]}`
+	if w := serve(http.MethodPost, "/conferences", app.CreateConference, "/conferences", noSeats); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for a tier without seats, got %d", w.Code)
+	}
+	tooMany := `{"name":"GoLab","location":"Florence","total_tickets":5,"price":200,"date":"` + date + `","tiers":[{"name":"VIP","price":500,"total_tickets":6}]}`
+	if w := serve(http.MethodPost, "/conferences", app.CreateConference, "/conferences", tooMany); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for tiers larger than the conference, got %d", w.Code)
+	}
+
+	user, _ := db.CreateUser("Ann", "ann@example.com")
+	enqueue := `{"user_id":"` + user.ID + `","conference_id":"` + created.Conference.ID + `","ticket_count":1,"tier":"student"}`
+	if w := serve(http.MethodPost, "/queue/enqueue", app.EnqueueWait, "/queue/enqueue", enqueue); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	w = serve(http.MethodGet, "/queue/:conferenceID/position", app.GetQueuePosition,
+		"/queue/"+created.Conference.ID+"/position?user_id="+user.ID, "")
+	var position struct {
+		Tier string `json:"tier"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &position); err != nil || position.Tier != "Student" {
+		t.Fatalf("expected the queue entry to wait for Student, got %d: %s", w.Code, w.Body)
+	}
+	balcony := `{"user_id":"` + user.ID + `","conference_id":"` + created.Conference.ID + `","ticket_count":1,"tier":"Balcony"}`
+	if w := serve(http.MethodPost, "/queue/enqueue", app.EnqueueWait, "/queue/enqueue", balcony); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown tier, got %d", w.Code)
+	}
+}
+
+func TestWebhooksAreManagedByTheirConferencesOrganizers(t *testing.T) {
+	app := newTestApp(t)
+	router := gin.New()
+	router.POST("/auth/register", app.Register)
+	organizers := app.RequireOrganizer("s3cret")
+	router.POST("/conferences", organizers, app.CreateConference)
+	router.POST("/webhooks", organizers, app.CreateWebhook)
+	router.GET("/webhooks", organizers, app.ListWebhooks)
+	router.DELETE("/webhooks/:id", organizers, app.DeleteWebhook)
+	router.GET("/webhooks/:id/deliveries", organizers, app.GetWebhookDeliveries)
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		} else {
+			req.Header.Set("X-Admin-Token", "s3cret")
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	register := func(name, email string) string {
+		w := do(http.MethodPost, "/auth/register", "", `{"name":"`+name+`","email":"`+email+`","password":"long enough"}`)
+		var resp struct {
+			Token string `json:"token"`
+			User  struct {
+				ID string `json:"id"`
+			} `json:"user"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusCreated {
+			t.Fatalf("register %s: got %d: %s", email, w.Code, w.Body)
+		}
+		app.db.SetUserRole(resp.User.ID, models.RoleOrganizer)
+		return resp.Token
+	}
+	owner := register("Olga", "olga@example.com")
+	rival := register("Rick", "rick@example.com")
+	date := time.Now().AddDate(0, 1, 0).Format(time.RFC3339)
+	w := do(http.MethodPost, "/conferences", owner, `{"name":"GoLab","location":"Florence","total_tickets":50,"price":200,"date":"`+date+`"}`)
+	var created struct {
+		Conference struct {
+			ID string `json:"id"`
+		} `json:"conference"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil || w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body)
+	}
+	confID := created.Conference.ID
+
+	if w := do(http.MethodPost, "/webhooks", owner, `{"url":"https://hooks.example.com/in"}`); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for an organizer subscribing to every conference, got %d: %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodPost, "/webhooks", rival, `{"url":"https://hooks.example.com/in","conference_id":"`+confID+`"}`); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for another organizer's conference, got %d", w.Code)
+	}
+	if w := do(http.MethodPost, "/webhooks", owner, `{"url":"ftp://hooks.example.com","conference_id":"`+confID+`"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for a non-http URL, got %d: %s", w.Code, w.Body)
+	}
+	w = do(http.MethodPost, "/webhooks", owner, `{"url":"https://hooks.example.com/in","conference_id":"`+confID+`","events":["booking.shipped"]}`)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"code":"invalid_webhook_subscription"`) {
+		t.Fatalf("expected 400 invalid_webhook_subscription for an unknown event, got %d: %s", w.Code, w.Body)
+	}
+
+	w = do(http.MethodPost, "/webhooks", owner, `{"url":"https://hooks.example.com/in","conference_id":"`+confID+`","events":["booking.created"]}`)
+	var resp struct {
+		Webhook struct {
+			ID     string   `json:"id"`
+			Secret string   `json:"secret"`
+			Events []string `json:"events"`
+		} `json:"webhook"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body)
+	}
+	if !strings.HasPrefix(resp.Webhook.Secret, "whsec_") || w.Header().Get("Location") != "/api/v1/webhooks/"+resp.Webhook.ID {
+		t.Fatalf("expected the secret and a Location, got %s (%s)", w.Body, w.Header().Get("Location"))
+	}
+	if w := do(http.MethodPost, "/webhooks", "", `{"url":"https://ops.example.com/all"}`); w.Code != http.StatusCreated {
+		t.Fatalf("expected admins to subscribe to every conference, got %d: %s", w.Code, w.Body)
+	}
+
+	// the secret is shown once; listings only show what the caller may manage
+	w = do(http.MethodGet, "/webhooks", owner, "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"count":1`) || strings.Contains(w.Body.String(), "whsec_") {
+		t.Fatalf("expected the owner's one webhook without its secret, got %d: %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodGet, "/webhooks", rival, ""); !strings.Contains(w.Body.String(), `"count":0`) {
+		t.Fatalf("expected no webhooks for another organizer, got %s", w.Body)
+	}
+	if w := do(http.MethodGet, "/webhooks", "", ""); !strings.Contains(w.Body.String(), `"count":2`) {
+		t.Fatalf("expected the admin to see both, got %s", w.Body)
+	}
+
+	if w := do(http.MethodGet, "/webhooks/"+resp.Webhook.ID+"/deliveries", rival, ""); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for another organizer's deliveries, got %d", w.Code)
+	}
+	if w := do(http.MethodGet, "/webhooks/"+resp.Webhook.ID+"/deliveries", owner, ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"deliveries":[]`) {
+		t.Fatalf("expected an empty delivery log, got %d: %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodDelete, "/webhooks/"+resp.Webhook.ID, rival, ""); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 deleting another organizer's webhook, got %d", w.Code)
+	}
+	if w := do(http.MethodDelete, "/webhooks/"+resp.Webhook.ID, owner, ""); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodDelete, "/webhooks/"+resp.Webhook.ID, owner, ""); w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), `"code":"webhook_not_found"`) {
+		t.Fatalf("expected 404 webhook_not_found, got %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestAdminAuditLogFilters(t *testing.T) {
+	app := newTestApp(t)
+	alice, _ := app.db.CreateUser("Alice", "alice@example.com")
+	app.db.CreateBooking(alice.ID, "conf-2", 1, database.BookingOptions{RequestID: "req-1"})
+	app.db.CreateReservation(alice.ID, "conf-1", 1, database.ReservationOptions{})
+
+	var body struct {
+		Entries []database.AuditEntry `json:"entries"`
+		Total   int                   `json:"total"`
+	}
+	w := serve(http.MethodGet, "/admin/audit", app.GetAuditLog, "/admin/audit?user_id="+alice.ID, "")
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if body.Total != 3 || body.Entries[0].Op != database.OpReservationCreate || body.Entries[2].Op != database.OpUserCreate {
+		t.Fatalf("expected alice's 3 entries newest first, got %+v", body.Entries)
+	}
+
+	w = serve(http.MethodGet, "/admin/audit", app.GetAuditLog, "/admin/audit?op=booking.&conference_id=conf-2", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Total != 1 {
+		t.Fatalf("expected the one booking, got %d: %s", w.Code, w.Body)
+	}
+	if e := body.Entries[0]; e.RequestID != "req-1" || e.Actor != alice.ID || e.Available == nil || e.Available.Before-e.Available.After != 1 {
+		t.Fatalf("expected the request, actor and the ticket taken, got %+v", e)
+	}
+
+	for _, query := range []string{"after_seq=-1", "from=yesterday", "limit=0"} {
+		if w := serve(http.MethodGet, "/admin/audit", app.GetAuditLog, "/admin/audit?"+query, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, w.Code)
+		}
+	}
+}
+
+func TestAdminInventoryReplaysTheConference(t *testing.T) {
+	app := newTestApp(t)
+	alice, _ := app.db.CreateUser("Alice", "alice@example.com")
+	app.db.CreateBooking(alice.ID, "conf-2", 2, database.BookingOptions{})
+	app.db.CreateReservation(alice.ID, "conf-2", 1, database.ReservationOptions{})
+
+	var body struct {
+		Inventory database.InventoryReport `json:"inventory"`
+	}
+	w := serve(http.MethodGet, "/admin/conferences/:id/inventory", app.GetInventory, "/admin/conferences/conf-2/inventory", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	inv := body.Inventory
+	if !inv.Consistent || inv.Derived != inv.Live || inv.Derived.Held != 1 || len(inv.Events) != 2 || inv.Events[0].Kind != database.InventorySell {
+		t.Fatalf("expected a sale and a hold matching the live counts, got %+v", inv)
+	}
+	if inv.Derived.Available != inv.Derived.Capacity-inv.Derived.Sold {
+		t.Fatalf("expected available to be capacity less sold, got %+v", inv.Derived)
+	}
+
+	if w := serve(http.MethodGet, "/admin/conferences/:id/inventory", app.GetInventory, "/admin/conferences/nope/inventory", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", w.Code)
+	}
+}
+
+func TestAdminExportImportRoundTrip(t *testing.T) {
+	app := newTestApp(t)
+	alice, _ := app.db.CreateUser("Alice", "alice@example.com")
+	booking, _ := app.db.CreateBooking(alice.ID, "conf-2", 2, database.BookingOptions{})
+
+	w := serve(http.MethodGet, "/admin/export", app.ExportSnapshot, "/admin/export", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Header().Get("Content-Disposition"), "attachment") {
+		t.Fatalf("expected a snapshot download, got %d %v", w.Code, w.Header())
+	}
+	exported := w.Body.String()
+
+	app.db.ResetDatabase()
+	if app.db.GetBooking(booking.ID) != nil {
+		t.Fatalf("expected the reset to drop the booking")
+	}
+	w = serve(http.MethodPost, "/admin/import", app.ImportSnapshot, "/admin/import", exported)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if app.db.GetBooking(booking.ID) == nil {
+		t.Fatalf("expected the import to bring the booking back")
+	}
+
+	w = serve(http.MethodPost, "/admin/import", app.ImportSnapshot, "/admin/import", `{"conferences":{}}`)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"code":"invalid_snapshot"`) {
+		t.Fatalf("expected 400 invalid_snapshot, got %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestWaitingRoomAdmissionTokenUnlocksHolds(t *testing.T) {
+	app, db := newTestAppWithDB(t)
+	alice, _ := db.CreateUser("Alice", "alice@example.com")
+	bob, _ := db.CreateUser("Bob", "bob@example.com")
+	if _, err := db.UpdateConference("conf-2", database.ConferenceUpdate{WaitingRoom: &models.WaitingRoom{AdmitPerMinute: 1}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	router := gin.New()
+	router.POST("/waiting-room/:conferenceID", app.JoinWaitingRoom)
+	router.GET("/waiting-room/:conferenceID", app.GetWaitingRoom)
+	router.POST("/reservations", app.CreateReservation)
+	router.POST("/reservations/:id/accept", app.AcceptOffer)
+	router.POST("/queue/claim", app.ClaimNext)
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set(AdmissionHeader, token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	hold := `{"user_id":"` + alice.ID + `","conference_id":"conf-2","ticket_count":1}`
+
+	if w := do(http.MethodPost, "/reservations", "", hold); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), CodeAdmissionRequired) {
+		t.Fatalf("expected 403 admission_required without a token, got %d %s", w.Code, w.Body)
+	}
+	var joined struct {
+		WaitingRoom    database.RoomStatus `json:"waiting_room"`
+		AdmissionToken string              `json:"admission_token"`
+	}
+	w := do(http.MethodPost, "/waiting-room/conf-2", "", `{"user_id":"`+alice.ID+`"}`)
+	if err := json.Unmarshal(w.Body.Bytes(), &joined); err != nil || !joined.WaitingRoom.Admitted || joined.AdmissionToken == "" {
+		t.Fatalf("expected the first visitor admitted with a token, got %d %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodPost, "/waiting-room/conf-2", "", `{"user_id":"`+bob.ID+`"}`); strings.Contains(w.Body.String(), "admission_token") {
+		t.Fatalf("expected the second visitor to wait, got %s", w.Body)
+	}
+	if w := do(http.MethodGet, "/waiting-room/conf-2?user_id="+bob.ID, "", ""); !strings.Contains(w.Body.String(), `"position":1`) {
+		t.Fatalf("expected bob first in line, got %s", w.Body)
+	}
+
+	bobsHold := `{"user_id":"` + bob.ID + `","conference_id":"conf-2","ticket_count":1}`
+	if w := do(http.MethodPost, "/reservations", joined.AdmissionToken, bobsHold); w.Code != http.StatusForbidden {
+		t.Fatalf("expected alice's token not to admit bob, got %d", w.Code)
+	}
+	if w := do(http.MethodPost, "/reservations", "other, "+joined.AdmissionToken, hold); w.Code != http.StatusCreated {
+		t.Fatalf("expected the admitted hold to go through, got %d %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodPost, "/waiting-room/conf-1", "", `{"user_id":"`+alice.ID+`"}`); w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for a conference without a waiting room, got %d", w.Code)
+	}
+
+	// claiming from the queue and accepting an offer hold seats too, so they need the token
+	bobsClaim := `{"user_id":"` + bob.ID + `","conference_id":"conf-2"}`
+	if w := do(http.MethodPost, "/queue/claim", "", bobsClaim); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), CodeAdmissionRequired) {
+		t.Fatalf("expected 403 admission_required for a claim without a token, got %d %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodPost, "/queue/claim", joined.AdmissionToken, `{"user_id":"`+alice.ID+`","conference_id":"conf-2"}`); w.Code == http.StatusForbidden {
+		t.Fatalf("expected an admitted claim to reach the queue, got %d %s", w.Code, w.Body)
+	}
+	bobsReservation, err := db.CreateReservation(bob.ID, "conf-2", 1, database.ReservationOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	accept := "/reservations/" + bobsReservation.ID + "/accept"
+	if w := do(http.MethodPost, accept, "", ""); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), CodeAdmissionRequired) {
+		t.Fatalf("expected 403 admission_required for an accept without a token, got %d %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodPost, accept, joined.AdmissionToken, ""); w.Code != http.StatusForbidden {
+		t.Fatalf("expected alice's token not to admit bob's accept, got %d", w.Code)
+	}
+}
+
+func TestLeavingTheQueueMovesEveryoneUpAndAdminsSeeTheWholeQueue(t *testing.T) {
+	app, db := newTestAppWithDB(t)
+	var users []string
+	for _, name := range []string{"alice", "bob", "carol"} {
+		u, _ := db.CreateUser(name, name+"@example.com")
+		if _, err := db.EnqueueWait(u.ID, "conf-1", 1, ""); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		users = append(users, u.ID)
+	}
+
+	if w := serve(http.MethodDelete, "/queue/:conferenceID", app.LeaveQueue, "/queue/conf-1?user_id="+users[0], ""); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", w.Code, w.Body)
+	}
+	if w := serve(http.MethodDelete, "/queue/:conferenceID", app.LeaveQueue, "/queue/conf-1?user_id="+users[0], ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for leaving twice, got %d", w.Code)
+	}
+	if pos := db.GetQueuePosition(users[2], "conf-1").Position; pos != 2 {
+		t.Fatalf("expected carol to move up to 2, got %d", pos)
+	}
+
+	var body struct {
+		Queue []struct {
+			Position int                `json:"position"`
+			Entry    database.WaitEntry `json:"entry"`
+		} `json:"queue"`
+		Count int `json:"count"`
+	}
+	w := serve(http.MethodGet, "/admin/conferences/:id/queue", app.GetQueue, "/admin/conferences/conf-1/queue", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if body.Count != 2 || body.Queue[0].Entry.UserID != users[1] || body.Queue[1].Position != 2 || body.Queue[1].Entry.UserID != users[2] {
+		t.Fatalf("expected bob then carol, got %+v", body)
+	}
+	if w := serve(http.MethodGet, "/admin/conferences/:id/queue", app.GetQueue, "/admin/conferences/nope/queue", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", w.Code)
+	}
+}
+
+func TestSelfServiceJoinsTakeTheUsersPriorityClass(t *testing.T) {
+	app, db := newTestAppWithDB(t)
+	alice, _ := db.CreateUser("Alice", "alice@example.com")
+	bob, _ := db.CreateUser("Bob", "bob@example.com")
+	db.EnqueueWait(alice.ID, "conf-1", 1, "")
+
+	if w := serve(http.MethodPut, "/admin/users/:userID/priority", app.SetUserPriority, "/admin/users/"+bob.ID+"/priority", `{"priority":10}`); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for a priority above 9, got %d %s", w.Code, w.Body)
+	}
+	if w := serve(http.MethodPut, "/admin/users/:userID/priority", app.SetUserPriority, "/admin/users/nope/priority", `{"priority":2}`); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown user, got %d %s", w.Code, w.Body)
+	}
+	w := serve(http.MethodPut, "/admin/users/:userID/priority", app.SetUserPriority, "/admin/users/"+bob.ID+"/priority", `{"priority":2}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"queue_priority":2`) {
+		t.Fatalf("expected 200 with bob's class, got %d %s", w.Code, w.Body)
+	}
+
+	body := `{"user_id":"` + bob.ID + `","conference_id":"conf-1","ticket_count":1}`
+	if w := serve(http.MethodPost, "/queue/enqueue", app.EnqueueWait, "/queue/enqueue", body); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"position":1`) {
+		t.Fatalf("expected bob's own join served ahead of alice, got %d %s", w.Code, w.Body)
+	}
+	w = serve(http.MethodGet, "/queue/:conferenceID/position", app.GetQueuePosition, "/queue/conf-1/position?user_id="+bob.ID, "")
+	if !strings.Contains(w.Body.String(), `"priority":2`) {
+		t.Fatalf("expected the position to report bob's class, got %s", w.Body)
+	}
+}
+
+func TestBulkEnqueueGivesEntriesAPriorityClass(t *testing.T) {
+	app, db := newTestAppWithDB(t)
+	alice, _ := db.CreateUser("Alice", "alice@example.com")
+	bob, _ := db.CreateUser("Bob", "bob@example.com")
+	db.EnqueueWait(alice.ID, "conf-1", 1, "")
+
+	body := `{"conference_id":"conf-1","entries":[{"user_id":"` + bob.ID + `","ticket_count":1,"priority":10}]}`
+	if w := serve(http.MethodPost, "/queue/bulk-enqueue", app.BulkEnqueue, "/queue/bulk-enqueue", body); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for a priority above 9, got %d %s", w.Code, w.Body)
+	}
+	body = strings.Replace(body, `"priority":10`, `"priority":1`, 1)
+	if w := serve(http.MethodPost, "/queue/bulk-enqueue", app.BulkEnqueue, "/queue/bulk-enqueue", body); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"positions":[1]`) {
+		t.Fatalf("expected bob served ahead of alice, got %d %s", w.Code, w.Body)
+	}
+	w := serve(http.MethodGet, "/queue/:conferenceID/position", app.GetQueuePosition, "/queue/conf-1/position?user_id="+bob.ID, "")
+	if !strings.Contains(w.Body.String(), `"priority":1`) {
+		t.Fatalf("expected the position to report bob's class, got %s", w.Body)
+	}
+}
+
+func TestExtendOnceRefusalsAreConflicts(t *testing.T) {
+	app, db := newTestAppWithDB(t)
+	user, _ := db.CreateUser("Alice", "alice@example.com")
+	res, err := db.CreateReservation(user.ID, "conf-1", 1, database.ReservationOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	w := serve(http.MethodPost, "/reservations/:id/extend-once", app.ExtendReservationOnce, "/reservations/"+res.ID+"/extend-once", "")
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), `"code":"not_in_extend_window"`) {
+		t.Fatalf("expected 409 not_in_extend_window, got %d %s", w.Code, w.Body)
+	}
+}
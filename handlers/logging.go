@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// logBodyPeekLimit caps how much of a JSON body RequestLogger reads looking for IDs
+const logBodyPeekLimit = 4 << 10
+
+// RequestIDHeader carries a request's ID in both directions: a client or proxy may set it to
+// correlate its own logs, and every response echoes the ID the server used
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDKey is where RequestID leaves the ID in the Gin context
+const requestIDKey = "request_id"
+
+// validRequestID is what an incoming X-Request-ID must look like to be kept; anything else is
+// replaced so clients can't inject arbitrary text into the logs
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+// RequestID gives every request an ID, keeping a well-formed X-Request-ID from the client and
+// generating one otherwise. The ID goes back in the X-Request-ID response header and into
+// RequestLogger's line, error responses and the audit entries of bookings and reservations.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		c.Set(requestIDKey, id)
+		c.Header(RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestID returns the ID RequestID gave the request, empty when it isn't in use
+func requestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+// requestLog is the default logger tagged with the request's ID
+func requestLog(c *gin.Context) *slog.Logger {
+	if id := requestID(c); id != "" {
+		return slog.Default().With("request_id", id)
+	}
+	return slog.Default()
+}
+
+// RequestLogger logs one JSON line per request with method, path, status and latency, plus
+// request_id when RequestID runs first and user_id and conference_id when they appear in the
+// route, query or a small JSON body. 4xx responses log at warn and 5xx at error.
+func RequestLogger(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		userID, conferenceID := subjectIDs(c)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+		attrs := []slog.Attr{
+			slog.String("method", c.Request.Method),
+			slog.String("path", c.Request.URL.Path),
+			slog.Int("status", status),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("client_ip", c.ClientIP()),
+		}
+		if id := requestID(c); id != "" {
+			attrs = append(attrs, slog.String("request_id", id))
+		}
+		if userID != "" {
+			attrs = append(attrs, slog.String("user_id", userID))
+		}
+		if conferenceID != "" {
+			attrs = append(attrs, slog.String("conference_id", conferenceID))
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, slog.String("errors", c.Errors.String()))
+		}
+		logger.LogAttrs(c.Request.Context(), level, "request", attrs...)
+	}
+}
+
+// subjectIDs finds the user and conference a request is about. Route params and the query
+// are checked first; a small JSON body is only read (and restored) when they come up empty.
+func subjectIDs(c *gin.Context) (userID, conferenceID string) {
+	userID = firstNonEmpty(c.Param("userID"), c.Query("user_id"))
+	conferenceID = firstNonEmpty(c.Param("conferenceID"), c.Query("conference_id"))
+	if userID != "" && conferenceID != "" {
+		return userID, conferenceID
+	}
+
+	req := c.Request
+	if req.Body == nil || req.ContentLength <= 0 || req.ContentLength > logBodyPeekLimit ||
+		!strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+		return userID, conferenceID
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return userID, conferenceID
+	}
+	var ids struct {
+		UserID       string `json:"user_id"`
+		ConferenceID string `json:"conference_id"`
+	}
+	if json.Unmarshal(body, &ids) == nil {
+		userID = firstNonEmpty(userID, ids.UserID)
+		conferenceID = firstNonEmpty(conferenceID, ids.ConferenceID)
+	}
+	return userID, conferenceID
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Metrics renders the database counters and gauges in the Prometheus text exposition format
+func (app *BookingApp) Metrics(c *gin.Context) {
+	m := app.store(c).GetMetrics()
+
+	var b strings.Builder
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	metric("booking_bookings_created_total", "counter", "Bookings created, directly or by confirming a reservation.")
+	fmt.Fprintf(&b, "booking_bookings_created_total %d\n", m.BookingsCreated)
+	metric("booking_reservations_total", "counter", "Reservations by outcome.")
+	fmt.Fprintf(&b, "booking_reservations_total{event=\"created\"} %d\n", m.ReservationsCreated)
+	fmt.Fprintf(&b, "booking_reservations_total{event=\"confirmed\"} %d\n", m.ReservationsConfirmed)
+	fmt.Fprintf(&b, "booking_reservations_total{event=\"expired\"} %d\n", m.ReservationsExpired)
+	fmt.Fprintf(&b, "booking_reservations_total{event=\"cancelled\"} %d\n", m.ReservationsCancelled)
+	metric("booking_active_reservations", "gauge", "Unexpired seat holds awaiting payment.")
+	fmt.Fprintf(&b, "booking_active_reservations %d\n", m.ActiveReservations)
+	metric("booking_queue_length", "gauge", "Wait queue length per conference.")
+	for _, q := range m.QueueLengths {
+		fmt.Fprintf(&b, "booking_queue_length{conference_id=%q} %d\n", q.ConferenceID, q.Length)
+	}
+
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(b.String()))
+}
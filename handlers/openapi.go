@@ -0,0 +1,650 @@
+package handlers
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"booking-system/database"
+	"booking-system/models"
+	"booking-system/webhooks"
+
+	"github.com/gin-gonic/gin"
+)
+
+// schema is one JSON Schema (OpenAPI 3.0 dialect) node of the API document
+type schema = map[string]interface{}
+
+// Shorthands for the schemas the API document is built from
+var (
+	stringSchema   = schema{"type": "string"}
+	integerSchema  = schema{"type": "integer"}
+	numberSchema   = schema{"type": "number"}
+	booleanSchema  = schema{"type": "boolean"}
+	dateTimeSchema = schema{"type": "string", "format": "date-time"}
+)
+
+// ref points at one of the document's component schemas
+func ref(name string) schema {
+	return schema{"$ref": "#/components/schemas/" + name}
+}
+
+// arrayOf is a JSON array of items
+func arrayOf(items schema) schema {
+	return schema{"type": "array", "items": items}
+}
+
+// object is a JSON object with the given properties, of which required must be present
+func object(props schema, required ...string) schema {
+	s := schema{"type": "object", "properties": props}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+// success is a {"status": "success", ...} response body with the given extra properties
+func success(props schema) schema {
+	all := schema{"status": schema{"type": "string", "example": "success"}}
+	for name, s := range props {
+		all[name] = s
+	}
+	return object(all, "status")
+}
+
+// schemaOf derives the schema of a Go type from its json tags, so the models in the
+// document can't drift from what the handlers actually serialize. Embedded structs are
+// flattened the way encoding/json flattens them.
+func schemaOf(t reflect.Type) schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return dateTimeSchema
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return stringSchema
+	case reflect.Bool:
+		return booleanSchema
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return integerSchema
+	case reflect.Float32, reflect.Float64:
+		return numberSchema
+	case reflect.Slice, reflect.Array:
+		return arrayOf(schemaOf(t.Elem()))
+	case reflect.Map:
+		return schema{"type": "object", "additionalProperties": schemaOf(t.Elem())}
+	case reflect.Struct:
+		props := schema{}
+		addFields(t, props)
+		return object(props)
+	}
+	return schema{}
+}
+
+// addFields adds the json-visible fields of struct type t to props
+func addFields(t reflect.Type, props schema) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" || (!f.IsExported() && !f.Anonymous) {
+			continue
+		}
+		if f.Anonymous && name == "" {
+			embedded := f.Type
+			for embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				addFields(embedded, props)
+				continue
+			}
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = schemaOf(f.Type)
+	}
+}
+
+// apiParam is a query parameter of an operation
+type apiParam struct {
+	name, description string
+	schema            schema
+	required          bool
+}
+
+// query is an optional string query parameter
+func query(name, description string) apiParam {
+	return apiParam{name: name, description: description, schema: stringSchema}
+}
+
+// pageQuery is the limit/offset pair every paged listing takes (see pageParams)
+var pageQuery = []apiParam{
+	{name: "limit", description: "Page size, at most 200", schema: schema{"type": "integer", "default": 50, "minimum": 1, "maximum": 200}},
+	{name: "offset", description: "Items to skip", schema: schema{"type": "integer", "default": 0, "minimum": 0}},
+}
+
+// waitingRoomBody is a conference's waiting_room in create and update requests
+var waitingRoomBody = object(schema{
+	"admit_per_minute":  schema{"type": "integer", "minimum": 0, "description": "Visitors let in a minute; 0 turns the waiting room off"},
+	"admission_seconds": schema{"type": "integer", "minimum": 0, "description": "How long an admission lasts; 0 uses the server default"},
+}, "admit_per_minute")
+
+// Who may call an operation, in the order the middleware checks them
+const (
+	accessPublic    = ""
+	accessUser      = "user"
+	accessOrganizer = "organizer"
+	accessAdmin     = "admin"
+)
+
+// apiOperation documents one route. Path uses gin's :param syntax; path parameters are
+// picked up from it.
+type apiOperation struct {
+	method, path string
+	tag, summary string
+	access       string
+	idempotent   bool // honors an Idempotency-Key header
+	admission    bool // takes X-Admission-Token for conferences with a waiting room
+	params       []apiParam
+	body         schema // request body, nil when there is none
+	status       int    // success status
+	result       schema // success response body
+	errors       []int  // error statuses besides the ones access implies
+}
+
+// apiOperations is every route main.go registers, grouped like the router
+var apiOperations = []apiOperation{
+	{method: "GET", path: "/health", tag: "system", summary: "Liveness check; 503 while draining on shutdown",
+		status: http.StatusOK, result: object(schema{"status": stringSchema, "time": dateTimeSchema}), errors: []int{503}},
+	{method: "GET", path: "/events", tag: "system", summary: "Server-sent stream of seat events; other users' IDs are left out for non-admins", access: accessUser,
+		params: []apiParam{query("conference_id", "Only events of this conference")}, status: http.StatusOK,
+		result: schema{"type": "string", "description": "text/event-stream"}},
+
+	{method: "GET", path: "/conferences", tag: "conferences", summary: "List conferences, filtered, sorted and paged",
+		params: append([]apiParam{
+			query("location", "Part of the location"),
+			{name: "available", description: "Only conferences with tickets left", schema: booleanSchema},
+			{name: "sort", schema: schema{"type": "string", "enum": []string{"id", "name", "price", "date"}, "default": "id"}},
+			{name: "order", schema: schema{"type": "string", "enum": []string{"asc", "desc"}, "default": "asc"}},
+			{name: "from", description: "Earliest date, RFC3339", schema: dateTimeSchema},
+			{name: "to", description: "Latest date, RFC3339", schema: dateTimeSchema},
+			{name: "min_price", schema: numberSchema},
+			{name: "max_price", schema: numberSchema},
+			{name: "price_display", description: "Show prices with fees and taxes", schema: schema{"type": "string", "enum": []string{"exclusive", "inclusive"}, "default": "exclusive"}},
+		}, pageQuery...),
+		status: http.StatusOK, result: success(schema{
+			"conferences": arrayOf(ref("ConferenceView")), "count": integerSchema, "total": integerSchema,
+			"limit": integerSchema, "offset": integerSchema, "stats": schema{"type": "object"},
+			"price_display": stringSchema, "sort": stringSchema,
+		})},
+	{method: "GET", path: "/conferences/search", tag: "conferences", summary: "Search conferences by name, location, tags and description, most relevant first",
+		params: append([]apiParam{{name: "q", description: "Search words", schema: stringSchema, required: true}}, pageQuery...),
+		status: http.StatusOK, result: success(schema{
+			"query": stringSchema, "results": arrayOf(ref("ConferenceResult")), "count": integerSchema,
+			"total": integerSchema, "limit": integerSchema, "offset": integerSchema,
+		})},
+	{method: "GET", path: "/conferences/:id/analytics", tag: "conferences", summary: "Sales analytics of one conference",
+		status: http.StatusOK, result: success(schema{"analytics": schema{"type": "object"}})},
+	{method: "GET", path: "/conferences/:id/seats", tag: "conferences", summary: "Every seat of a conference's seat map and whether it is free, held or booked",
+		status: http.StatusOK, result: ref("SeatMap"), errors: []int{404}},
+	{method: "GET", path: "/analytics", tag: "conferences", summary: "Sales analytics of every conference",
+		status: http.StatusOK, result: success(schema{"analytics": arrayOf(schema{"type": "object"}), "count": integerSchema})},
+	{method: "POST", path: "/conferences", tag: "conferences", summary: "Create a conference; the caller becomes its organizer", access: accessOrganizer,
+		body: object(schema{
+			"name": stringSchema, "location": stringSchema,
+			"total_tickets": schema{"type": "integer", "minimum": 1},
+			"price":         schema{"type": "number", "minimum": 0},
+			"date":          dateTimeSchema,
+			"description":   schema{"type": "string", "maxLength": 2000},
+			"tags":          schema{"type": "array", "maxItems": 20, "items": schema{"type": "string", "maxLength": 50}},
+			"tiers": schema{"type": "array", "maxItems": 10, "description": "Named ticket types; their seats may not add up to more than total_tickets",
+				"items": object(schema{
+					"name": stringSchema, "price": schema{"type": "number", "minimum": 0}, "total_tickets": schema{"type": "integer", "minimum": 1},
+				}, "name", "total_tickets")},
+			"sections": schema{"type": "array", "maxItems": 50, "description": "Seat map for assigned seating; its seats must add up to total_tickets",
+				"items": object(schema{
+					"name": schema{"type": "string", "maxLength": 50}, "rows": schema{"type": "integer", "minimum": 1, "maximum": 500},
+					"seats_per_row": schema{"type": "integer", "minimum": 1, "maximum": 500},
+				}, "name", "rows", "seats_per_row")},
+			"waiting_room": waitingRoomBody,
+		}, "name", "location", "total_tickets", "date"),
+		status: http.StatusCreated, result: success(schema{"conference": ref("Conference")})},
+	{method: "PUT", path: "/conferences/:id", tag: "conferences", summary: "Change a conference's details, price or capacity", access: accessOrganizer,
+		body: object(schema{
+			"name": stringSchema, "location": stringSchema, "date": dateTimeSchema,
+			"price":            schema{"type": "number", "minimum": 0},
+			"total_tickets":    schema{"type": "integer", "minimum": 1},
+			"hold_seconds":     schema{"type": "integer", "minimum": 0, "maximum": 3600},
+			"description":      schema{"type": "string", "maxLength": 2000},
+			"tags":             schema{"type": "array", "maxItems": 20, "items": schema{"type": "string", "maxLength": 50}},
+			"waiting_room":     waitingRoomBody,
+			"expected_version": schema{"type": "integer", "description": "Fail with 409 unless the conference is at this version"},
+		}),
+		status: http.StatusOK, result: success(schema{"conference": ref("Conference")}), errors: []int{404, 409}},
+	{method: "DELETE", path: "/conferences/:id", tag: "conferences", summary: "Delete a conference", access: accessOrganizer,
+		status: http.StatusOK, result: success(schema{"message": stringSchema}), errors: []int{404, 409}},
+	{method: "GET", path: "/conferences/:id/checkins", tag: "conferences", summary: "Live attendance: tickets issued, checked in and the latest check-ins", access: accessOrganizer,
+		status: http.StatusOK, result: success(schema{"checkins": ref("CheckInStats")}), errors: []int{404}},
+	{method: "POST", path: "/checkin", tag: "conferences", summary: "Admit the ticket a scanned QR code names; each ticket gets in once", access: accessOrganizer,
+		body: object(schema{
+			"code":          schema{"type": "string", "description": "The ticket's qr_payload"},
+			"conference_id": schema{"type": "string", "description": "Turn away tickets for other conferences with 409 wrong_conference"},
+		}, "code"),
+		status: http.StatusOK, result: success(schema{"ticket": ref("Ticket"), "message": stringSchema}), errors: []int{404, 409}},
+
+	{method: "POST", path: "/webhooks", tag: "webhooks", summary: "Register a URL for signed event deliveries; the response carries the signing secret", access: accessOrganizer,
+		body: object(schema{
+			"url":           schema{"type": "string", "format": "uri", "maxLength": 2048, "description": "Absolute http or https URL deliveries are POSTed to"},
+			"conference_id": schema{"type": "string", "description": "Only this conference's events; omit (admins only) for every conference"},
+			"events":        schema{"type": "array", "maxItems": 20, "items": schema{"type": "string", "enum": webhooks.EventTypes}, "description": "Event types to receive; omit for all"},
+		}, "url"),
+		status: http.StatusCreated, result: success(schema{"webhook": ref("Webhook"), "message": stringSchema}), errors: []int{404}},
+	{method: "GET", path: "/webhooks", tag: "webhooks", summary: "List the webhooks the caller may manage", access: accessOrganizer,
+		params: []apiParam{query("conference_id", "Only webhooks of this conference")},
+		status: http.StatusOK, result: success(schema{"webhooks": arrayOf(ref("Webhook")), "count": integerSchema})},
+	{method: "DELETE", path: "/webhooks/:id", tag: "webhooks", summary: "Delete a webhook; pending deliveries are dropped", access: accessOrganizer,
+		status: http.StatusOK, result: success(schema{"message": stringSchema}), errors: []int{404}},
+	{method: "GET", path: "/webhooks/:id/deliveries", tag: "webhooks", summary: "The latest delivery attempts of a webhook, newest first", access: accessOrganizer,
+		status: http.StatusOK, result: success(schema{"webhook_id": stringSchema, "deliveries": arrayOf(ref("WebhookDelivery")), "count": integerSchema}), errors: []int{404}},
+
+	{method: "POST", path: "/auth/register", tag: "auth", summary: "Create an account and log in",
+		body:   object(schema{"name": stringSchema, "email": stringSchema, "password": stringSchema}, "name", "email", "password"),
+		status: http.StatusCreated, result: ref("Token"), errors: []int{409, 429}},
+	{method: "POST", path: "/auth/login", tag: "auth", summary: "Exchange an email and password for a token",
+		body:   object(schema{"email": stringSchema, "password": stringSchema}, "email", "password"),
+		status: http.StatusOK, result: ref("Token"), errors: []int{401, 429}},
+
+	{method: "POST", path: "/users", tag: "users", summary: "Create a user without a password", access: accessAdmin,
+		body:   object(schema{"name": stringSchema, "email": stringSchema}, "name", "email"),
+		status: http.StatusCreated, result: ref("User"), errors: []int{409}},
+	{method: "GET", path: "/users", tag: "users", summary: "Find a user by email", access: accessUser,
+		params: []apiParam{{name: "email", schema: stringSchema, required: true}},
+		status: http.StatusOK, result: ref("User"), errors: []int{404}},
+	{method: "GET", path: "/users/:userID", tag: "users", summary: "Get a user", access: accessUser,
+		status: http.StatusOK, result: ref("User"), errors: []int{404}},
+	{method: "POST", path: "/users/bulk", tag: "users", summary: "Create several users; each succeeds or fails on its own", access: accessAdmin,
+		body:   arrayOf(object(schema{"name": stringSchema, "email": stringSchema}, "name", "email")),
+		status: http.StatusOK, result: object(schema{"status": stringSchema, "results": arrayOf(schema{"type": "object"}), "created": integerSchema, "failed": integerSchema})},
+	{method: "GET", path: "/users/:userID/bookings", tag: "users", summary: "A user's bookings, paged", access: accessUser, params: pageQuery,
+		status: http.StatusOK, result: success(schema{"bookings": arrayOf(ref("Booking")), "count": integerSchema, "total": integerSchema, "limit": integerSchema, "offset": integerSchema})},
+	{method: "GET", path: "/users/:userID/reservations", tag: "users", summary: "A user's active holds", access: accessUser,
+		status: http.StatusOK, result: success(schema{"reservations": arrayOf(ref("ReservationStatus")), "count": integerSchema})},
+	{method: "GET", path: "/users/:userID/reservations/history", tag: "users", summary: "Every reservation a user has made", access: accessUser,
+		status: http.StatusOK, result: success(schema{"reservations": arrayOf(ref("Reservation")), "count": integerSchema})},
+	{method: "GET", path: "/users/:userID/allowance/:conferenceID", tag: "users", summary: "How many more tickets a user may get at a conference", access: accessUser,
+		status: http.StatusOK, result: success(schema{"allowance": schema{"type": "object"}}), errors: []int{404}},
+
+	{method: "POST", path: "/bookings", tag: "bookings", summary: "Book tickets directly, without a hold", access: accessUser, idempotent: true, admission: true,
+		body: object(schema{
+			"user_id": stringSchema, "conference_id": stringSchema,
+			"ticket_count":     schema{"type": "integer", "minimum": 1},
+			"attendees":        arrayOf(stringSchema),
+			"expected_version": integerSchema,
+			"tier":             stringSchema,
+		}, "user_id", "conference_id", "ticket_count"),
+		status: http.StatusCreated, result: ref("BookingView"), errors: []int{404, 409, 429, 503}},
+	{method: "GET", path: "/bookings/export", tag: "bookings", summary: "Bookings as CSV", access: accessAdmin,
+		params: []apiParam{query("conference_id", "Only bookings of this conference")},
+		status: http.StatusOK, result: schema{"type": "string", "description": "text/csv"}},
+	{method: "GET", path: "/bookings/:id", tag: "bookings", summary: "A booking with its user and conference", access: accessUser,
+		status: http.StatusOK, result: success(schema{"booking": ref("Booking"), "user": ref("User"), "conference": ref("Conference")}), errors: []int{404}},
+	{method: "DELETE", path: "/bookings/:id", tag: "bookings", summary: "Cancel a booking, refunding its payment, and release its tickets", access: accessUser,
+		status: http.StatusOK, result: success(schema{"booking": ref("Booking"), "message": stringSchema}), errors: []int{404, 409, 429, 502}},
+	{method: "GET", path: "/bookings/:id/tickets", tag: "bookings", summary: "A booking's tickets, each with a signed QR payload to show at the door", access: accessUser,
+		status: http.StatusOK, result: success(schema{"booking_id": stringSchema, "tickets": arrayOf(ref("Ticket"))}), errors: []int{404}},
+	{method: "GET", path: "/bookings/:id/tickets/:ticketID/download", tag: "bookings", summary: "One ticket as a QR code PNG or a printable PDF", access: accessUser,
+		params: []apiParam{{name: "format", schema: schema{"type": "string", "enum": []string{"png", "pdf"}, "default": "png"}}},
+		status: http.StatusOK, result: schema{"type": "string", "format": "binary", "description": "image/png or application/pdf"}, errors: []int{404, 409}},
+
+	{method: "POST", path: "/reservations", tag: "reservations", summary: "Hold seats until they are paid for", access: accessUser, idempotent: true, admission: true,
+		body: object(schema{
+			"user_id": stringSchema, "conference_id": stringSchema,
+			"ticket_count":     schema{"type": "integer", "minimum": 1},
+			"promo_code":       stringSchema,
+			"tier":             stringSchema,
+			"expected_version": schema{"type": "integer", "description": "Fail with 409 unless the conference is at this version"},
+			"seat_ids": schema{"type": "array", "maxItems": 50, "items": stringSchema,
+				"description": "Seats to lock at a conference with a seat map, one per ticket; 409 seat_taken if someone else has one"},
+		}, "user_id", "conference_id", "ticket_count"),
+		status: http.StatusCreated, result: success(schema{"reservation": ref("Reservation"), "self": stringSchema, "conference": ref("Conference"), "message": stringSchema}),
+		errors: []int{404, 409, 429, 503}},
+	{method: "POST", path: "/reservations/bundle", tag: "reservations", summary: "Hold seats at several conferences, all or nothing", access: accessUser, admission: true,
+		body: object(schema{
+			"user_id": stringSchema,
+			"items": schema{"type": "array", "minItems": 1, "items": object(schema{
+				"conference_id": stringSchema, "ticket_count": schema{"type": "integer", "minimum": 1}, "tier": stringSchema,
+			}, "conference_id", "ticket_count")},
+		}, "user_id", "items"),
+		status: http.StatusCreated, result: success(schema{"reservations": arrayOf(ref("Reservation")), "count": integerSchema, "message": stringSchema}),
+		errors: []int{404, 409, 429, 503}},
+	{method: "GET", path: "/reservations/:id", tag: "reservations", summary: "A hold and how long it has left", access: accessUser,
+		status: http.StatusOK, result: ref("ReservationStatus"), errors: []int{404}},
+	{method: "POST", path: "/reservations/:id/confirm", tag: "reservations", summary: "Pay for a hold and turn it into a booking", access: accessUser,
+		body:   object(schema{"attendees": arrayOf(stringSchema), "expected_version": integerSchema}),
+		status: http.StatusOK, result: success(schema{"booking": ref("Booking"), "conference": ref("Conference"), "message": stringSchema}),
+		errors: []int{402, 404, 409, 410, 429, 502}},
+	{method: "DELETE", path: "/reservations/:id", tag: "reservations", summary: "Release a hold", access: accessUser,
+		status: http.StatusOK, result: success(schema{"message": stringSchema}), errors: []int{404, 429}},
+	{method: "POST", path: "/reservations/:id/extend-once", tag: "reservations", summary: "Use a hold's one automatic extension", access: accessUser,
+		status: http.StatusOK, result: success(schema{"reservation": ref("Reservation"), "expires_at": dateTimeSchema, "remaining_time": numberSchema, "message": stringSchema}),
+		errors: []int{404, 409, 410, 429}},
+	{method: "POST", path: "/reservations/:id/extend", tag: "reservations", summary: "Extend a hold, up to the conference's limit", access: accessUser,
+		status: http.StatusOK, result: success(schema{"reservation": ref("Reservation"), "expires_at": dateTimeSchema, "remaining_time": numberSchema, "extensions_left": integerSchema, "message": stringSchema}),
+		errors: []int{404, 409, 410, 429}},
+	{method: "POST", path: "/reservations/:id/accept", tag: "reservations", summary: "Accept a waitlist offer", access: accessUser, admission: true,
+		status: http.StatusOK, result: success(schema{"reservation": ref("Reservation"), "remaining_time": numberSchema, "message": stringSchema}),
+		errors: []int{404, 409, 410, 429, 503}},
+
+	{method: "POST", path: "/carts", tag: "carts", summary: "Start a cart for tickets to several conferences", access: accessUser,
+		body:   object(schema{"user_id": stringSchema}, "user_id"),
+		status: http.StatusCreated, result: success(schema{"cart": ref("Cart"), "self": stringSchema, "message": stringSchema}), errors: []int{404}},
+	{method: "GET", path: "/carts/:id", tag: "carts", summary: "A cart and what it holds", access: accessUser,
+		status: http.StatusOK, result: success(schema{"cart": ref("Cart")}), errors: []int{404}},
+	{method: "PUT", path: "/carts/:id/items", tag: "carts", summary: "Add a conference to an open cart or change its ticket count", access: accessUser,
+		body: object(schema{
+			"conference_id": stringSchema, "ticket_count": schema{"type": "integer", "minimum": 1}, "tier": stringSchema,
+		}, "conference_id", "ticket_count"),
+		status: http.StatusOK, result: success(schema{"cart": ref("Cart"), "message": stringSchema}), errors: []int{404, 409, 429}},
+	{method: "DELETE", path: "/carts/:id/items/:conferenceID", tag: "carts", summary: "Remove a conference from an open cart", access: accessUser,
+		status: http.StatusOK, result: success(schema{"cart": ref("Cart"), "message": stringSchema}), errors: []int{404, 409}},
+	{method: "POST", path: "/carts/:id/checkout", tag: "carts", summary: "Hold every seat in the cart, all or nothing", access: accessUser, admission: true,
+		status: http.StatusOK, result: success(schema{"cart": ref("Cart"), "reservations": arrayOf(ref("Reservation")), "message": stringSchema}),
+		errors: []int{404, 409, 429, 502, 503}},
+	{method: "POST", path: "/carts/:id/confirm", tag: "carts", summary: "Pay for every hold in the cart at once; a lapsed hold releases them all", access: accessUser,
+		status: http.StatusOK, result: success(schema{"cart": ref("Cart"), "bookings": arrayOf(ref("Booking")), "message": stringSchema}),
+		errors: []int{402, 404, 409, 410, 429, 502}},
+	{method: "DELETE", path: "/carts/:id", tag: "carts", summary: "Abandon a cart, releasing its holds", access: accessUser,
+		status: http.StatusOK, result: success(schema{"cart": ref("Cart"), "message": stringSchema}), errors: []int{404, 409, 429}},
+
+	{method: "POST", path: "/payments/webhook", tag: "payments", summary: "Payment provider callback; signed by the provider",
+		body: schema{"type": "object"}, status: http.StatusOK, result: schema{"type": "object"}},
+
+	{method: "POST", path: "/queue/enqueue", tag: "queue", summary: "Join a conference's waitlist, optionally for one tier", access: accessUser,
+		body: object(schema{
+			"user_id": stringSchema, "conference_id": stringSchema, "ticket_count": schema{"type": "integer", "minimum": 1},
+			"tier": schema{"type": "string", "description": "Wait for seats in this tier, offered at its price"},
+		}, "user_id", "conference_id", "ticket_count"),
+		status: http.StatusOK, result: success(schema{"position": integerSchema}), errors: []int{404, 409, 429, 503}},
+	{method: "GET", path: "/queue/:conferenceID/position", tag: "queue", summary: "Where a user stands in a waitlist", access: accessUser,
+		params: []apiParam{{name: "user_id", schema: stringSchema, required: true}},
+		status: http.StatusOK, result: success(schema{
+			"queued": booleanSchema, "position": integerSchema, "ticket_count": integerSchema, "tier": stringSchema, "ahead_count": integerSchema,
+			"claimable": booleanSchema, "estimated_wait_seconds": numberSchema, "missed_claims": integerSchema, "priority": integerSchema,
+			"claimable_until": schema{"type": "string", "format": "date-time", "description": "Deadline to claim, once the head is told seats are free"},
+		}), errors: []int{429}},
+	{method: "POST", path: "/queue/claim", tag: "queue", summary: "Turn the head of the waitlist into a hold", access: accessUser, admission: true,
+		body: object(schema{
+			"user_id": stringSchema, "conference_id": stringSchema,
+			"partial": schema{"type": "boolean", "description": "Accept fewer seats and re-queue the rest"},
+		}, "user_id", "conference_id"),
+		status: http.StatusOK, result: success(schema{"reservation": ref("Reservation"), "conference": ref("Conference"), "granted": integerSchema, "position": integerSchema}),
+		errors: []int{404, 409, 429, 503}},
+	{method: "DELETE", path: "/queue/leave", tag: "queue", summary: "Leave a waitlist", access: accessUser,
+		params: []apiParam{{name: "user_id", schema: stringSchema, required: true}, {name: "conference_id", schema: stringSchema, required: true}},
+		status: http.StatusOK, result: success(schema{"message": stringSchema}), errors: []int{404, 429}},
+	{method: "DELETE", path: "/queue/:conferenceID", tag: "queue", summary: "Leave a waitlist; everyone behind moves up", access: accessUser,
+		params: []apiParam{{name: "user_id", schema: stringSchema, required: true}},
+		status: http.StatusOK, result: success(schema{"message": stringSchema}), errors: []int{404, 429}},
+	{method: "POST", path: "/waiting-room/:conferenceID", tag: "queue", summary: "Join a conference's waiting room, or see where you stand in it", access: accessUser,
+		body:   object(schema{"user_id": stringSchema}, "user_id"),
+		status: http.StatusOK, result: ref("WaitingRoomStatus"), errors: []int{404, 409, 429, 503}},
+	{method: "GET", path: "/waiting-room/:conferenceID", tag: "queue", summary: "Where a user stands in a waiting room, with their admission token once let in", access: accessUser,
+		params: []apiParam{{name: "user_id", schema: stringSchema, required: true}},
+		status: http.StatusOK, result: ref("WaitingRoomStatus"), errors: []int{404, 409, 429}},
+	{method: "POST", path: "/queue/bulk-enqueue", tag: "queue", summary: "Append several users to a waitlist in order", access: accessAdmin,
+		body: object(schema{
+			"conference_id": stringSchema,
+			"entries": schema{"type": "array", "minItems": 1, "items": object(schema{
+				"user_id": stringSchema, "ticket_count": schema{"type": "integer", "minimum": 1}, "tier": stringSchema,
+				"priority": schema{"type": "integer", "minimum": 0, "maximum": database.MaxQueuePriority, "description": "Class served first, highest first; 0 is general"},
+			}, "user_id", "ticket_count")},
+		}, "conference_id", "entries"),
+		status: http.StatusOK, result: success(schema{"positions": arrayOf(integerSchema)}), errors: []int{404}},
+
+	{method: "GET", path: "/admin/users", tag: "admin", summary: "Every user, paged", access: accessAdmin, params: pageQuery,
+		status: http.StatusOK, result: success(schema{"users": arrayOf(ref("User")), "count": integerSchema, "total": integerSchema, "limit": integerSchema, "offset": integerSchema})},
+	{method: "PUT", path: "/admin/users/:userID/role", tag: "admin", summary: "Change a user's role", access: accessAdmin,
+		body:   object(schema{"role": schema{"type": "string", "enum": []string{models.RoleUser, models.RoleOrganizer, models.RoleAdmin}}}, "role"),
+		status: http.StatusOK, result: success(schema{"user": ref("User")}), errors: []int{404}},
+	{method: "PUT", path: "/admin/users/:userID/priority", tag: "admin", summary: "Set the queue priority class a user's own queue joins get", access: accessAdmin,
+		body:   object(schema{"priority": schema{"type": "integer", "minimum": 0, "maximum": database.MaxQueuePriority, "description": "Class served first, highest first; 0 is general"}}, "priority"),
+		status: http.StatusOK, result: success(schema{"user": ref("User")}), errors: []int{404}},
+	{method: "GET", path: "/admin/bookings", tag: "admin", summary: "Every booking, filtered and paged", access: accessAdmin,
+		params: append([]apiParam{
+			query("status", "Only bookings in this status"),
+			{name: "from", description: "Booked at or after, RFC3339", schema: dateTimeSchema},
+			{name: "to", description: "Booked at or before, RFC3339", schema: dateTimeSchema},
+		}, pageQuery...),
+		status: http.StatusOK, result: success(schema{"bookings": arrayOf(ref("Booking")), "count": integerSchema, "total": integerSchema, "limit": integerSchema, "offset": integerSchema})},
+	{method: "GET", path: "/admin/audit", tag: "admin", summary: "The audit log, newest first, filtered and paged", access: accessAdmin,
+		params: append([]apiParam{
+			query("op", `An operation such as reservation.expire, or a prefix ending in "." such as reservation.`),
+			query("user_id", "Only changes concerning this user"),
+			query("conference_id", "Only changes at this conference"),
+			query("reservation_id", "Only changes to this reservation"),
+			query("booking_id", "Only changes to this booking"),
+			query("actor", "Only changes made by this user, or by system"),
+			query("request_id", "Only changes caused by this API request"),
+			{name: "from", description: "At or after, RFC3339", schema: dateTimeSchema},
+			{name: "to", description: "At or before, RFC3339", schema: dateTimeSchema},
+			{name: "after_seq", description: "Only entries recorded after this seq", schema: integerSchema},
+		}, pageQuery...),
+		status: http.StatusOK, result: success(schema{"entries": arrayOf(ref("AuditEntry")), "count": integerSchema, "total": integerSchema, "limit": integerSchema, "offset": integerSchema})},
+	{method: "GET", path: "/admin/conferences/:id/inventory", tag: "admin", summary: "A conference's inventory replayed from the audit log, against its live counts", access: accessAdmin,
+		status: http.StatusOK, result: success(schema{"inventory": ref("InventoryReport")}), errors: []int{404}},
+	{method: "GET", path: "/admin/conferences/:id/queue", tag: "admin", summary: "A conference's whole waitlist, head first", access: accessAdmin,
+		status: http.StatusOK, result: success(schema{
+			"queue": arrayOf(object(schema{"position": integerSchema, "ahead_count": integerSchema, "entry": ref("WaitEntry")})),
+			"count": integerSchema, "ticket_count": integerSchema,
+		}), errors: []int{404}},
+	{method: "GET", path: "/admin/reservations", tag: "admin", summary: "Active holds", access: accessAdmin,
+		params: []apiParam{query("conference_id", "Only holds at this conference"), query("user_id", "Only holds of this user")},
+		status: http.StatusOK, result: success(schema{"reservations": arrayOf(schema{"type": "object"}), "count": integerSchema})},
+	{method: "POST", path: "/admin/conferences/:id/release-holdback", tag: "admin", summary: "Release held-back seats into general sale", access: accessAdmin,
+		body:   object(schema{"count": schema{"type": "integer", "minimum": 1}}, "count"),
+		status: http.StatusOK, result: success(schema{"conference": ref("Conference")}), errors: []int{404}},
+	{method: "POST", path: "/admin/reset", tag: "admin", summary: "Reset the database to the sample data", access: accessAdmin,
+		status: http.StatusOK, result: success(schema{"message": stringSchema, "conferences": integerSchema})},
+	{method: "GET", path: "/admin/export", tag: "admin", summary: "Download the whole state as a JSON snapshot", access: accessAdmin,
+		status: http.StatusOK, result: ref("Snapshot")},
+	{method: "POST", path: "/admin/import", tag: "admin", summary: "Replace the whole state with a snapshot from /admin/export", access: accessAdmin,
+		body:   ref("Snapshot"),
+		status: http.StatusOK, result: success(schema{"message": stringSchema, "users": integerSchema, "conferences": integerSchema, "bookings": integerSchema})},
+	{method: "GET", path: "/config", tag: "admin", summary: "Effective configuration, secrets redacted", access: accessAdmin,
+		status: http.StatusOK, result: success(schema{"config": schema{"type": "object"}})},
+	{method: "POST", path: "/debug/replay", tag: "admin", summary: "Replay an audit log and report drift from the live data", access: accessAdmin,
+		body:   object(schema{"entries": arrayOf(schema{"type": "object"})}),
+		status: http.StatusOK, result: success(schema{"replayed": integerSchema, "drift": arrayOf(stringSchema), "inventory": arrayOf(stringSchema), "in_sync": booleanSchema})},
+}
+
+// apiComponents are the shared schemas operations refer to with ref
+func apiComponents() schema {
+	conferenceView := schemaOf(reflect.TypeOf(conferenceView{}))
+	conferenceView["properties"].(schema)["base_price"] = schema{"type": "number", "description": "Price before fees and taxes"}
+	return schema{
+		"Error": object(schema{
+			"status":          schema{"type": "string", "example": "error"},
+			"code":            schema{"type": "string", "description": "Stable error code, e.g. sold_out or not_your_turn"},
+			"error":           schema{"type": "string", "description": "Human-readable message; may change"},
+			"request_id":      stringSchema,
+			"current_version": schema{"type": "integer", "description": "Set on a version_conflict"},
+		}, "status", "code", "error"),
+		"User":             schemaOf(reflect.TypeOf(userView{})),
+		"Conference":       schemaOf(reflect.TypeOf(models.Conference{})),
+		"ConferenceView":   conferenceView,
+		"ConferenceResult": schemaOf(reflect.TypeOf(searchResult{})),
+		"Booking":          schemaOf(reflect.TypeOf(models.Booking{})),
+		"BookingView":      schemaOf(reflect.TypeOf(bookingView{})),
+		"Reservation":      schemaOf(reflect.TypeOf(models.SeatReservation{})),
+		"Cart":             schemaOf(reflect.TypeOf(models.Cart{})),
+		"SeatMap":          schemaOf(reflect.TypeOf(database.SeatMap{})),
+		"Ticket":           schemaOf(reflect.TypeOf(ticketView{})),
+		"CheckInStats":     schemaOf(reflect.TypeOf(database.CheckInStats{})),
+		"Webhook":          schemaOf(reflect.TypeOf(webhookView{})),
+		"AuditEntry":       schemaOf(reflect.TypeOf(database.AuditEntry{})),
+		"InventoryReport":  schemaOf(reflect.TypeOf(database.InventoryReport{})),
+		"WaitEntry":        schemaOf(reflect.TypeOf(database.WaitEntry{})),
+		"Snapshot":         schemaOf(reflect.TypeOf(database.Snapshot{})),
+		"WebhookDelivery":  schemaOf(reflect.TypeOf(webhooks.Delivery{})),
+		"WaitingRoomStatus": success(schema{
+			"waiting_room":    schemaOf(reflect.TypeOf(database.RoomStatus{})),
+			"admission_token": schema{"type": "string", "description": "Set once admitted; send it in " + AdmissionHeader + " until admit_until"},
+		}),
+		"ReservationStatus": success(schema{
+			"reservation": ref("Reservation"), "conference": ref("Conference"),
+			"remaining_time": numberSchema, "expired": booleanSchema,
+		}),
+		"Token": success(schema{"user": ref("User"), "token": stringSchema, "token_type": stringSchema, "expires_at": dateTimeSchema}),
+	}
+}
+
+// openAPIPath turns gin's /users/:userID into OpenAPI's /users/{userID} and lists the
+// parameters it names
+func openAPIPath(path string) (string, []string) {
+	segments := strings.Split(path, "/")
+	var params []string
+	for i, s := range segments {
+		if strings.HasPrefix(s, ":") {
+			params = append(params, s[1:])
+			segments[i] = "{" + s[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// operationDoc renders op as an OpenAPI operation object
+func operationDoc(op apiOperation) schema {
+	_, pathParams := openAPIPath(op.path)
+	var params []schema
+	for _, name := range pathParams {
+		params = append(params, schema{"name": name, "in": "path", "required": true, "schema": stringSchema})
+	}
+	for _, p := range op.params {
+		param := schema{"name": p.name, "in": "query", "schema": p.schema}
+		if p.description != "" {
+			param["description"] = p.description
+		}
+		if p.required {
+			param["required"] = true
+		}
+		params = append(params, param)
+	}
+	if op.idempotent {
+		params = append(params, schema{"name": "Idempotency-Key", "in": "header", "schema": stringSchema,
+			"description": "Retries with the same key return the first result instead of acting twice"})
+	}
+	if op.admission {
+		params = append(params, schema{"name": AdmissionHeader, "in": "header", "schema": stringSchema,
+			"description": "Admission tokens from the waiting rooms of the conferences involved, comma-separated; 403 admission_required without one"})
+	}
+
+	errorResponse := func(description string) schema {
+		return schema{"description": description, "content": schema{"application/json": schema{"schema": ref("Error")}}}
+	}
+	responses := schema{
+		strconv.Itoa(op.status): schema{"description": "Success", "content": schema{"application/json": schema{"schema": op.result}}},
+	}
+	statuses := op.errors
+	if op.body != nil || len(op.params) > 0 {
+		statuses = append(statuses, http.StatusBadRequest)
+	}
+	if op.body != nil {
+		statuses = append(statuses, http.StatusUnprocessableEntity)
+	}
+	if op.access != accessPublic {
+		statuses = append(statuses, http.StatusUnauthorized, http.StatusForbidden)
+	}
+	for _, status := range statuses {
+		responses[strconv.Itoa(status)] = errorResponse(http.StatusText(status))
+	}
+
+	doc := schema{
+		"tags":        []string{op.tag},
+		"summary":     op.summary,
+		"operationId": strings.ToLower(op.method) + strings.NewReplacer("/", "_", ":", "", "-", "_").Replace(op.path),
+		"responses":   responses,
+	}
+	if len(params) > 0 {
+		doc["parameters"] = params
+	}
+	if op.body != nil {
+		doc["requestBody"] = schema{"required": true, "content": schema{"application/json": schema{"schema": op.body}}}
+	}
+	switch op.access {
+	case accessUser:
+		doc["security"] = []schema{{"bearer": []string{}}}
+	case accessOrganizer, accessAdmin:
+		doc["security"] = []schema{{"bearer": []string{}}, {"adminToken": []string{}}}
+		doc["description"] = "Requires the " + op.access + " role, or the admin token."
+	}
+	return doc
+}
+
+// OpenAPISpec builds the OpenAPI 3 document of the API from apiOperations
+func OpenAPISpec() schema {
+	paths := schema{}
+	for _, op := range apiOperations {
+		path, _ := openAPIPath(op.path)
+		item, ok := paths[path].(schema)
+		if !ok {
+			item = schema{}
+			paths[path] = item
+		}
+		item[strings.ToLower(op.method)] = operationDoc(op)
+	}
+	return schema{
+		"openapi": "3.0.3",
+		"info": schema{
+			"title":       "Conference booking API",
+			"version":     "1",
+			"description": "Holds, bookings and waitlists for conference tickets. Every error body carries a stable code.",
+		},
+		"servers": []schema{{"url": APIPrefix}},
+		"paths":   paths,
+		"components": schema{
+			"schemas": apiComponents(),
+			"securitySchemes": schema{
+				"bearer":     schema{"type": "http", "scheme": "bearer", "description": "Token from /auth/login or /auth/register"},
+				"adminToken": schema{"type": "apiKey", "in": "header", "name": "X-Admin-Token"},
+			},
+		},
+	}
+}
+
+// ServeOpenAPI serves the OpenAPI document as JSON. It is built once; the routes don't
+// change at runtime.
+func ServeOpenAPI() gin.HandlerFunc {
+	spec := OpenAPISpec()
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, spec)
+	}
+}
+
+// docsPage is Swagger UI pointed at the OpenAPI document
+const docsPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Conference booking API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js" crossorigin></script>
+  <script>
+    window.ui = SwaggerUIBundle({ url: "/openapi.json", dom_id: "#swagger-ui" });
+  </script>
+</body>
+</html>
+`
+
+// ServeDocs serves Swagger UI for the OpenAPI document at /openapi.json
+func ServeDocs(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(docsPage))
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"booking-system/database"
+	"booking-system/models"
+	"booking-system/payments"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UsePayments makes reservations be paid through provider in currency (ISO code, lower case).
+// Without it confirming a hold books it straight away, charging through the database's
+// PaymentProcessor if one is set.
+func (app *BookingApp) UsePayments(provider payments.Provider, currency string) {
+	app.payments = provider
+	app.currency = currency
+}
+
+// startPayment gives reservation a payment intent unless it has one or no provider is in use,
+// returning the updated reservation. If the provider fails it writes a 502, and with
+// cancelOnFailure also cancels the hold so the seats aren't stuck behind a payment that can't
+// be made.
+func (app *BookingApp) startPayment(c *gin.Context, reservation *models.SeatReservation, cancelOnFailure bool) (*models.SeatReservation, bool) {
+	if app.payments == nil || reservation.PaymentID != "" {
+		return reservation, true
+	}
+	intent, err := app.payments.CreateIntent(c.Request.Context(), payments.Charge{
+		Amount:        reservation.TotalAmount,
+		Currency:      app.currency,
+		ReservationID: reservation.ID,
+		UserID:        reservation.UserID,
+	})
+	if err != nil {
+		if cancelOnFailure {
+			if cancelErr := app.store(c).CancelReservation(reservation.ID); cancelErr != nil {
+				requestLog(c).Error("Cancel reservation after failed payment setup", "reservation_id", reservation.ID, "error", cancelErr)
+			}
+		}
+		requestLog(c).Error("Create payment failed", "reservation_id", reservation.ID, "error", err)
+		c.JSON(http.StatusBadGateway, errorBody(c, CodePaymentProvider, "could not start the payment, please try again"))
+		return nil, false
+	}
+	updated, err := app.store(c).AttachPayment(reservation.ID, intent.ID, intent.ClientSecret)
+	if err != nil {
+		respondReservationLookupError(c, err)
+		return nil, false
+	}
+	return updated, true
+}
+
+// respondPaymentPending answers a confirm call for a hold paid through the provider: the
+// booking is made when the provider reports the payment, not now
+func respondPaymentPending(c *gin.Context, reservation *models.SeatReservation) {
+	c.JSON(http.StatusAccepted, gin.H{
+		"status":      "pending",
+		"reservation": reservation,
+		"payment": gin.H{
+			"id":            reservation.PaymentID,
+			"client_secret": reservation.PaymentClientSecret,
+		},
+		"message": "Complete the payment; the booking is confirmed once the payment provider reports it.",
+	})
+}
+
+// refundBooking refunds the provider payment a booking was paid through before it is cancelled.
+// The whole intent is refunded once; a cart's other bookings are cancelled with it. Bookings
+// paid without the provider, or already cancelled, have nothing to refund.
+func (app *BookingApp) refundBooking(c *gin.Context, bookingID string) error {
+	booking := app.store(c).GetBooking(bookingID)
+	if booking == nil {
+		return database.ErrBookingNotFound
+	}
+	if booking.PaymentID == "" || booking.Status == "cancelled" {
+		return nil
+	}
+	if app.payments == nil {
+		return fmt.Errorf("%w: no provider configured to refund payment %s", payments.ErrProvider, booking.PaymentID)
+	}
+	if err := app.payments.Refund(c.Request.Context(), booking.PaymentID); err != nil {
+		requestLog(c).Error("Refund failed, booking kept", "booking_id", bookingID, "payment_id", booking.PaymentID, "error", err)
+		return err
+	}
+	requestLog(c).Info("Refunded cancelled booking", "booking_id", bookingID, "payment_id", booking.PaymentID)
+	return nil
+}
+
+// cancelPayment abandons a cancelled hold's unpaid intent; failures are only logged since the
+// hold is gone either way and an intent nobody pays simply lapses
+func (app *BookingApp) cancelPayment(c *gin.Context, reservation *models.SeatReservation) {
+	if app.payments == nil || reservation == nil || reservation.PaymentID == "" {
+		return
+	}
+	if err := app.payments.CancelIntent(c.Request.Context(), reservation.PaymentID); err != nil {
+		requestLog(c).Warn("Cancel payment failed", "payment_id", reservation.PaymentID, "reservation_id", reservation.ID, "error", err)
+	}
+}
+
+// PaymentWebhook takes the provider's reports of what became of a payment. The signature is
+// checked before anything is read from the body. Deliveries repeat and can arrive out of
+// order, so each one is applied against the current state: a payment that already booked is
+// acknowledged again, and a payment whose hold can no longer be booked is refunded. Only a
+// failure worth retrying (the store or the provider being unreachable) gets a non-2xx answer,
+// which makes the provider deliver it again later.
+func (app *BookingApp) PaymentWebhook(c *gin.Context) {
+	if app.payments == nil {
+		c.JSON(http.StatusNotFound, errorBody(c, CodeNotFound, "payments are not enabled"))
+		return
+	}
+	payload, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorBody(c, CodeBadRequest, "could not read the request body"))
+		return
+	}
+	event, err := app.payments.ParseWebhook(payload, c.Request.Header)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorBody(c, CodeInvalidWebhook, err.Error()))
+		return
+	}
+
+	result, err := app.applyPaymentEvent(c, event)
+	if err != nil {
+		requestLog(c).Error("Payment webhook failed, provider will retry", "event_id", event.ID, "type", event.Type, "payment_id", event.IntentID, "error", err)
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "event_id": event.ID, "result": result})
+}
+
+// applyPaymentEvent moves the hold (or cart of holds) an event is about forward and describes
+// what it did
+func (app *BookingApp) applyPaymentEvent(c *gin.Context, event *payments.Event) (string, error) {
+	if event.ReservationID == "" && event.CartID == "" {
+		return "ignored: not a reservation payment", nil
+	}
+	switch event.Type {
+	case payments.EventPaymentSucceeded:
+		booking, err := app.store(c).CompletePayment(event.IntentID)
+		if err == nil {
+			return "booked " + booking.ID, nil
+		}
+		if errors.Is(err, database.ErrUnavailable) {
+			return "", err
+		}
+		// The money was taken but the seats are gone (the hold lapsed, was cancelled or the
+		// conference sold out): give it back. The refund is idempotent per intent.
+		if refundErr := app.payments.Refund(c.Request.Context(), event.IntentID); refundErr != nil {
+			return "", refundErr
+		}
+		requestLog(c).Info("Refunded payment that could not be booked", "payment_id", event.IntentID, "reservation_id", event.ReservationID, "error", err)
+		return "refunded: " + err.Error(), nil
+
+	case payments.EventPaymentCanceled:
+		if event.CartID != "" {
+			return app.releaseCart(c, event)
+		}
+		reservation, err := app.store(c).GetReservation(event.ReservationID)
+		if err != nil || reservation.PaymentID != event.IntentID {
+			return "ignored: hold already gone", nil
+		}
+		if err := app.store(c).CancelReservation(reservation.ID); err != nil {
+			if errors.Is(err, database.ErrUnavailable) {
+				return "", err
+			}
+			return "ignored: hold already gone", nil
+		}
+		return "hold released", nil
+
+	case payments.EventPaymentFailed:
+		// the customer can retry with the same intent until the hold lapses
+		return "hold kept", nil
+	}
+	return "ignored: " + event.Type, nil
+}
+
+// releaseCart gives back the seats of a cart whose payment was abandoned, if the cart is still
+// waiting on that payment
+func (app *BookingApp) releaseCart(c *gin.Context, event *payments.Event) (string, error) {
+	cart, err := app.store(c).GetCart(event.CartID)
+	if err != nil || cart.PaymentID != event.IntentID || cart.Status != models.CartStatusReserved {
+		return "ignored: cart already settled", nil
+	}
+	if _, err := app.store(c).CancelCart(cart.ID); err != nil {
+		if errors.Is(err, database.ErrUnavailable) {
+			return "", err
+		}
+		return "ignored: cart already settled", nil
+	}
+	return "cart released", nil
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimitCleanupInterval is how often idle rate-limit buckets are forgotten
+const RateLimitCleanupInterval = time.Minute
+
+// Route groups main limits apart, each with buckets of its own
+const (
+	RateLimitAuth     = "auth"     // register, login and user creation
+	RateLimitBookings = "bookings" // bookings, reservations and carts
+	RateLimitQueue    = "queue"    // joining, polling, claiming and leaving wait queues
+)
+
+// RateLimiter gives each client a token bucket: tokens refill at rate per second up to burst,
+// and every request spends one. Clients are keyed by the signed-in user, else by the user_id
+// the request names, otherwise by client IP. Route groups (see Group) can have limits and
+// buckets of their own.
+type RateLimiter struct {
+	limit  rateLimit
+	groups map[string]rateLimit // per route group; others use limit
+	ipMult float64              // see SetIPMultiplier
+	now    func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// rateLimit is a bucket's refill rate per second and size
+type rateLimit struct {
+	rate  float64
+	burst float64
+}
+
+func newRateLimit(rate float64, burst int) rateLimit {
+	return rateLimit{rate: rate, burst: float64(max(burst, 1))}
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  rateLimit
+}
+
+// refill tops the bucket up for the time since it was last used
+func (b *bucket) refill(now time.Time) {
+	b.tokens = math.Min(b.limit.burst, b.tokens+now.Sub(b.last).Seconds()*b.limit.rate)
+	b.last = now
+}
+
+// NewRateLimiter returns a limiter allowing rate requests per second with bursts of up to
+// burst; a rate of zero or less disables limiting
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	return &RateLimiter{limit: newRateLimit(rate, burst), groups: make(map[string]rateLimit), now: time.Now, buckets: make(map[string]*bucket)}
+}
+
+// SetGroup gives a route group its own rate and burst in place of the limiter's; a rate of
+// zero or less leaves the group unlimited. Call it before serving.
+func (l *RateLimiter) SetGroup(name string, rate float64, burst int) {
+	l.groups[name] = newRateLimit(rate, burst)
+}
+
+// SetIPMultiplier also limits requests made as a user by their client IP, at n times the
+// user's rate and burst, so one client can't escape the limit by naming a fresh user in every
+// request while a few users behind one NAT still fit. Zero (the default) turns it off. Call it
+// before serving.
+func (l *RateLimiter) SetIPMultiplier(n int) {
+	l.ipMult = float64(max(n, 0))
+}
+
+// Allow spends a token from key's bucket. When the bucket is empty it reports false and how
+// long until a token is available.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	return l.take(l.limit, key)
+}
+
+// take spends a token from every key's bucket, or from none when any of them is empty, and
+// then reports the longest wait. The first key gets limit and any others limit scaled by the
+// IP multiplier.
+func (l *RateLimiter) take(limit rateLimit, keys ...string) (bool, time.Duration) {
+	if limit.rate <= 0 {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	buckets := make([]*bucket, len(keys))
+	var wait time.Duration
+	for i, key := range keys {
+		b, ok := l.buckets[key]
+		if !ok {
+			lim := limit
+			if i > 0 {
+				lim = rateLimit{rate: limit.rate * l.ipMult, burst: limit.burst * l.ipMult}
+			}
+			b = &bucket{tokens: lim.burst, last: now, limit: lim}
+			l.buckets[key] = b
+		}
+		b.refill(now)
+		if b.tokens < 1 {
+			wait = max(wait, time.Duration((1-b.tokens)/b.limit.rate*float64(time.Second)))
+		}
+		buckets[i] = b
+	}
+	if wait > 0 {
+		return false, wait
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return true, 0
+}
+
+// Middleware limits requests against the limiter's own rate and burst
+func (l *RateLimiter) Middleware() gin.HandlerFunc {
+	return l.Group("")
+}
+
+// Group limits requests to one route group, such as "queue" or "reservations", with the rate
+// and burst SetGroup gave it and buckets apart from other groups'. Requests over the limit
+// get 429 with a Retry-After header.
+func (l *RateLimiter) Group(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, ok := l.groups[name]
+		if !ok {
+			limit = l.limit
+		}
+		prefix := ""
+		if name != "" {
+			prefix = name + "|"
+		}
+		ipKey := prefix + "ip:" + c.ClientIP()
+		keys := []string{ipKey}
+		userID := authUserID(c)
+		if userID == "" {
+			userID, _ = subjectIDs(c)
+		}
+		if userID != "" {
+			keys[0] = prefix + "user:" + userID
+			if l.ipMult > 0 {
+				keys = append(keys, ipKey)
+			}
+		}
+		ok, wait := l.take(limit, keys...)
+		if !ok {
+			retry := int(math.Ceil(wait.Seconds()))
+			c.Header("Retry-After", strconv.Itoa(retry))
+			body := errorBody(c, CodeRateLimited, "rate limit exceeded")
+			body["retry_after"] = retry
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, body)
+			return
+		}
+		c.Next()
+	}
+}
+
+// Cleanup forgets buckets that have refilled completely, which behave exactly like a new
+// bucket, and returns how many were removed
+func (l *RateLimiter) Cleanup() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	removed := 0
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*b.limit.rate >= b.limit.burst {
+			delete(l.buckets, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// StartCleanup runs Cleanup every interval until the returned stop func is called
+func (l *RateLimiter) StartCleanup(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				l.Cleanup()
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"booking-system/database"
+	"booking-system/models"
+	"booking-system/tickets"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ticketPNGScale is how many pixels wide each QR module is in a PNG download
+const ticketPNGScale = 8
+
+// UseTickets replaces the ticket signer; by default QR payloads are signed with a per-process
+// random key, so printed tickets stop scanning after a restart
+func (app *BookingApp) UseTickets(signer *tickets.Signer) {
+	app.tickets = signer
+}
+
+// ticketView serializes a ticket with what its QR code carries and where to download it.
+// Void tickets get neither.
+type ticketView struct {
+	models.Ticket
+	QRPayload string            `json:"qr_payload,omitempty"`
+	Downloads map[string]string `json:"downloads,omitempty"`
+}
+
+func (app *BookingApp) viewTicket(t models.Ticket) ticketView {
+	view := ticketView{Ticket: t}
+	if t.Status != models.TicketStatusVoid {
+		download := selfLink("bookings", t.BookingID) + "/tickets/" + t.ID + "/download"
+		view.QRPayload = app.tickets.Sign(t.ID)
+		view.Downloads = map[string]string{"png": download + "?format=png", "pdf": download + "?format=pdf"}
+	}
+	return view
+}
+
+// GetBookingTickets lists a booking's tickets, one per admission, each with a signed QR
+// payload to show at the door
+func (app *BookingApp) GetBookingTickets(c *gin.Context) {
+	bookingID := c.Param("id")
+	if !app.allowBooking(c, bookingID) {
+		return
+	}
+	list, err := app.store(c).BookingTickets(bookingID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	views := make([]ticketView, len(list))
+	for i, t := range list {
+		views[i] = app.viewTicket(t)
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "booking_id": bookingID, "tickets": views})
+}
+
+// DownloadTicket serves one ticket as a QR code PNG (?format=png, the default) or a printable
+// PDF (?format=pdf). Tickets of a cancelled booking are void and can't be downloaded.
+func (app *BookingApp) DownloadTicket(c *gin.Context) {
+	bookingID := c.Param("id")
+	format := c.DefaultQuery("format", "png")
+	if format != "png" && format != "pdf" {
+		c.JSON(http.StatusBadRequest, errorBody(c, CodeBadRequest, "format must be png or pdf"))
+		return
+	}
+	if !app.allowBooking(c, bookingID) {
+		return
+	}
+	ticket, err := app.store(c).GetTicket(c.Param("ticketID"))
+	if err == nil && ticket.BookingID != bookingID {
+		err = database.ErrTicketNotFound
+	}
+	if err == nil && ticket.Status == models.TicketStatusVoid {
+		err = database.ErrTicketVoid
+	}
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	payload := app.tickets.Sign(ticket.ID)
+	var data []byte
+	if format == "pdf" {
+		data, err = tickets.PDF(payload, app.ticketLines(c, ticket))
+	} else {
+		var q *tickets.QR
+		if q, err = tickets.EncodeQR([]byte(payload)); err == nil {
+			data, err = q.PNG(ticketPNGScale)
+		}
+	}
+	if err != nil {
+		requestLog(c).Error("Render ticket failed", "ticket_id", ticket.ID, "error", err)
+		c.JSON(http.StatusInternalServerError, errorBody(c, CodeInternal, "could not render the ticket"))
+		return
+	}
+
+	contentType := "image/png"
+	if format == "pdf" {
+		contentType = "application/pdf"
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="ticket-%s.%s"`, ticket.ID, format))
+	c.Data(http.StatusOK, contentType, data)
+}
+
+// ticketLines is the text printed above the QR code of a PDF ticket
+func (app *BookingApp) ticketLines(c *gin.Context, t *models.Ticket) []string {
+	lines := []string{t.ConferenceID}
+	if conf, err := app.store(c).GetConference(t.ConferenceID); err == nil {
+		lines[0] = conf.Name
+		if !conf.Date.IsZero() {
+			lines = append(lines, conf.Date.Format("Monday, 2 January 2006 15:04"))
+		}
+		if conf.Location != "" {
+			lines = append(lines, conf.Location)
+		}
+	}
+	if t.Attendee != "" {
+		lines = append(lines, "Attendee: "+t.Attendee)
+	}
+	if t.SeatID != "" {
+		lines = append(lines, "Seat: "+t.SeatID)
+	}
+	if t.Tier != "" {
+		lines = append(lines, "Tier: "+t.Tier)
+	}
+	return append(lines, fmt.Sprintf("Ticket %d - %s", t.Number, t.ID))
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"booking-system/database"
+	"booking-system/database/tracedstore"
+	"booking-system/tracing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UseTracing routes request handlers' store calls through store, so each shows up as a span
+// under the request's span from Tracing
+func (app *BookingApp) UseTracing(store *tracedstore.Store) {
+	app.traced = store
+}
+
+// store is the store a request's handler should use: bound to the request's span when
+// tracing is in use, the plain store otherwise
+func (app *BookingApp) store(c *gin.Context) database.Store {
+	if app.traced != nil {
+		return app.traced.Bind(c.Request.Context())
+	}
+	return app.db
+}
+
+// Tracing starts a server span per request named after its method and route, continuing the
+// caller's trace when it sends a traceparent header. The span carries the route, status and
+// request ID, is marked failed on a 5xx, and is left in the request's context for the handler's
+// store calls. The response's traceparent names it, so a client can look its trace up.
+func Tracing(tracer *tracing.Tracer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx := tracing.ContextWithTraceParent(c.Request.Context(), c.GetHeader(tracing.TraceParentHeader))
+		ctx, span := tracer.Start(ctx, c.Request.Method+" "+route, tracing.KindServer)
+		c.Request = c.Request.WithContext(ctx)
+		if span != nil {
+			c.Header(tracing.TraceParentHeader, span.TraceParent())
+		}
+
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttr("http.request.method", c.Request.Method)
+		span.SetAttr("http.route", route)
+		span.SetAttr("http.response.status_code", status)
+		if id := requestID(c); id != "" {
+			span.SetAttr("request_id", id)
+		}
+		var err error
+		if status >= 500 {
+			err = fmt.Errorf("%d %s", status, http.StatusText(status))
+		}
+		span.Finish(err)
+	}
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+// bindJSON binds the request body into req. On failure it writes the error and returns false:
+// 413 when the body is over the LimitBody cap, 400 when it is not valid JSON for req, and 422
+// when it parses but fails validation, listing every invalid field at once under "fields"
+// (json name -> message).
+func bindJSON(c *gin.Context, req interface{}) bool {
+	err := c.ShouldBindJSON(req)
+	if err == nil {
+		return true
+	}
+
+	var tooLarge *http.MaxBytesError
+	var verrs validator.ValidationErrors
+	switch {
+	case errors.As(err, &tooLarge):
+		respondBodyTooLarge(c, tooLarge.Limit)
+	case errors.As(err, &verrs):
+		respondInvalidFields(c, fieldErrors(req, verrs))
+	default:
+		c.JSON(http.StatusBadRequest, errorBody(c, CodeInvalidJSON, "invalid JSON: "+err.Error()))
+	}
+	return false
+}
+
+// respondInvalidFields writes a 422 listing what is wrong with each field
+func respondInvalidFields(c *gin.Context, fields interface{}) {
+	body := errorBody(c, CodeValidation, "validation failed")
+	body["fields"] = fields
+	c.JSON(http.StatusUnprocessableEntity, body)
+}
+
+// respondBodyTooLarge writes a 413 naming the body size cap
+func respondBodyTooLarge(c *gin.Context, limit int64) {
+	c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, errorBody(c, CodeBodyTooLarge, fmt.Sprintf("request body exceeds %d bytes", limit)))
+}
+
+// fieldErrors maps each failed field to a readable message keyed by its JSON name
+func fieldErrors(req interface{}, verrs validator.ValidationErrors) map[string]string {
+	t := reflect.TypeOf(req)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	fields := make(map[string]string, len(verrs))
+	for _, fe := range verrs {
+		name := fe.Field()
+		if sf, ok := t.FieldByName(fe.StructField()); ok {
+			if tag := strings.Split(sf.Tag.Get("json"), ",")[0]; tag != "" && tag != "-" {
+				name = tag
+			}
+		}
+		fields[name] = validationMessage(fe)
+	}
+	return fields
+}
+
+// validationMessage describes a single failed validation rule
+func validationMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "http_url":
+		return "must be an absolute http or https URL"
+	case "min":
+		return fmt.Sprintf("must be at least %s", fe.Param())
+	case "max":
+		return fmt.Sprintf("must be at most %s", fe.Param())
+	case "oneof":
+		return fmt.Sprintf("must be one of: %s", fe.Param())
+	default:
+		return fmt.Sprintf("failed %s validation", fe.Tag())
+	}
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"booking-system/database"
+	"booking-system/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// AdmissionHeader carries the admission tokens of conferences selling through a waiting room,
+// comma-separated when a request holds seats at several
+const AdmissionHeader = "X-Admission-Token"
+
+// admissionIssuer goes in the iss claim of admission tokens, so a login token can't pass for one
+const admissionIssuer = "booking-system/admission"
+
+// AdmissionIssuer signs and verifies the HS256 JWTs that let a user out of a conference's
+// waiting room: the subject is the user, the audience the conference, and the token expires
+// with the admission
+type AdmissionIssuer struct {
+	secret []byte
+}
+
+// NewAdmissionIssuer signs with secret; every instance taking holds must share it
+func NewAdmissionIssuer(secret []byte) *AdmissionIssuer {
+	return &AdmissionIssuer{secret: secret}
+}
+
+// Issue signs an admission of userID to conferenceID lasting until until
+func (a *AdmissionIssuer) Issue(userID, conferenceID string, until time.Time) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Issuer:    admissionIssuer,
+		Subject:   userID,
+		Audience:  jwt.ClaimStrings{conferenceID},
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		ExpiresAt: jwt.NewNumericDate(until),
+	})
+	return token.SignedString(a.secret)
+}
+
+// Verify checks that token admits userID to conferenceID and hasn't expired
+func (a *AdmissionIssuer) Verify(token, userID, conferenceID string) error {
+	var claims jwt.RegisteredClaims
+	_, err := jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (interface{}, error) {
+		return a.secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer(admissionIssuer),
+		jwt.WithAudience(conferenceID), jwt.WithSubject(userID), jwt.WithExpirationRequired())
+	return err
+}
+
+// UseAdmissions replaces the admission token issuer; by default tokens are signed with a
+// per-process random secret
+func (app *BookingApp) UseAdmissions(admissions *AdmissionIssuer) {
+	app.admissions = admissions
+}
+
+// waitingRoomRequest is a conference's waiting_room in create and update requests
+type waitingRoomRequest struct {
+	AdmitPerMinute   int `json:"admit_per_minute" binding:"min=0"`
+	AdmissionSeconds int `json:"admission_seconds" binding:"min=0"`
+}
+
+// settings converts the request for the store, nil when none was sent
+func (r *waitingRoomRequest) settings() *models.WaitingRoom {
+	if r == nil {
+		return nil
+	}
+	return &models.WaitingRoom{AdmitPerMinute: r.AdmitPerMinute, AdmissionSeconds: r.AdmissionSeconds}
+}
+
+// JoinWaitingRoom puts the user ({user_id}) in line for a conference's on-sale. The response
+// is the same as GetWaitingRoom's.
+func (app *BookingApp) JoinWaitingRoom(c *gin.Context) {
+	var req struct {
+		UserID string `json:"user_id" binding:"required"`
+	}
+	if !bindJSON(c, &req) || !allowUser(c, req.UserID) {
+		return
+	}
+	status, err := app.store(c).JoinWaitingRoom(req.UserID, c.Param("conferenceID"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	app.respondRoomStatus(c, req.UserID, status)
+}
+
+// GetWaitingRoom reports where a user (?user_id=) stands in a conference's waiting room. Once
+// they are let in the response carries the admission token to send in X-Admission-Token with
+// their holds and bookings until admit_until; clients poll it until then.
+func (app *BookingApp) GetWaitingRoom(c *gin.Context) {
+	userID := c.Query("user_id")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, errorBody(c, CodeBadRequest, "user_id required"))
+		return
+	}
+	if !allowUser(c, userID) {
+		return
+	}
+	status, err := app.store(c).WaitingRoomStatus(userID, c.Param("conferenceID"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	app.respondRoomStatus(c, userID, status)
+}
+
+// respondRoomStatus writes a waiting room status, signing the admission of an admitted user
+func (app *BookingApp) respondRoomStatus(c *gin.Context, userID string, status database.RoomStatus) {
+	resp := gin.H{"status": "success", "waiting_room": status}
+	if status.Admitted {
+		token, err := app.admissions.Issue(userID, status.ConferenceID, *status.AdmitUntil)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, errorBody(c, CodeInternal, "could not sign admission"))
+			return
+		}
+		resp["admission_token"] = token
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// requireAdmission checks that a request holding or booking seats for userID carries an
+// admission token for each of the conferences that sell through a waiting room, writing 403
+// when one is missing. Unknown conferences are left to the store to reject.
+func (app *BookingApp) requireAdmission(c *gin.Context, userID string, conferenceIDs ...string) bool {
+	var tokens []string
+	for _, token := range strings.Split(c.GetHeader(AdmissionHeader), ",") {
+		if token = strings.TrimSpace(token); token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	for _, id := range conferenceIDs {
+		conf, err := app.store(c).GetConference(id)
+		if err != nil || conf.WaitingRoom == nil {
+			continue
+		}
+		if !app.admitted(tokens, userID, id) {
+			c.JSON(http.StatusForbidden, errorBody(c, CodeAdmissionRequired,
+				fmt.Sprintf("conference %s sells through a waiting room: join it and send the admission token in %s", id, AdmissionHeader)))
+			return false
+		}
+	}
+	return true
+}
+
+// admitted reports whether any of tokens admits userID to conferenceID
+func (app *BookingApp) admitted(tokens []string, userID, conferenceID string) bool {
+	for _, token := range tokens {
+		if app.admissions.Verify(token, userID, conferenceID) == nil {
+			return true
+		}
+	}
+	return false
+}
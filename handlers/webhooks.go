@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"net/http"
+
+	"booking-system/webhooks"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UseWebhooks replaces the webhook dispatcher. The default one keeps subscriptions but is
+// never started, so it delivers nothing.
+func (app *BookingApp) UseWebhooks(dispatcher *webhooks.Dispatcher) {
+	app.webhooks = dispatcher
+}
+
+// webhookView serializes a subscription with a link to itself. Secret is only filled in
+// when the subscription is created.
+type webhookView struct {
+	*webhooks.Subscription
+	Secret string `json:"secret,omitempty"`
+	Self   string `json:"self"`
+}
+
+// CreateWebhook registers a URL to receive signed event payloads ({url, conference_id?,
+// events?}). Organizers subscribe to conferences they organize; only admins may subscribe to
+// every conference. The response carries the signing secret, which isn't shown again.
+func (app *BookingApp) CreateWebhook(c *gin.Context) {
+	var req struct {
+		URL          string   `json:"url" binding:"required,http_url,max=2048"`
+		ConferenceID string   `json:"conference_id"`
+		Events       []string `json:"events" binding:"max=20"`
+	}
+	if !bindJSON(c, &req) {
+		return
+	}
+	user := authUser(c)
+	if req.ConferenceID == "" {
+		if user != nil && !user.IsAdmin() {
+			c.JSON(http.StatusForbidden, errorBody(c, CodeForbidden, "only admins can subscribe to every conference; set conference_id"))
+			return
+		}
+	} else {
+		if _, err := app.store(c).GetConference(req.ConferenceID); err != nil {
+			respondError(c, err)
+			return
+		}
+		if !app.allowConference(c, req.ConferenceID) {
+			return
+		}
+	}
+
+	sub := webhooks.Subscription{URL: req.URL, ConferenceID: req.ConferenceID, Events: req.Events}
+	if user != nil {
+		sub.CreatedBy = user.ID
+	}
+	created, err := app.webhooks.Add(sub)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	self := setLocation(c, "webhooks", created.ID)
+	c.JSON(http.StatusCreated, gin.H{
+		"status":  "success",
+		"webhook": webhookView{Subscription: created, Secret: created.Secret, Self: self},
+		"message": "Keep the secret: it signs every delivery and isn't shown again.",
+	})
+}
+
+// ListWebhooks lists the subscriptions the caller may manage, oldest first; ?conference_id=
+// narrows them to one conference
+func (app *BookingApp) ListWebhooks(c *gin.Context) {
+	conferenceID := c.Query("conference_id")
+	views := []webhookView{}
+	for _, sub := range app.webhooks.List() {
+		if conferenceID != "" && sub.ConferenceID != conferenceID {
+			continue
+		}
+		if !app.canManageWebhook(c, &sub) {
+			continue
+		}
+		views = append(views, webhookView{Subscription: &sub, Self: selfLink("webhooks", sub.ID)})
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "webhooks": views, "count": len(views)})
+}
+
+// DeleteWebhook removes a subscription; deliveries still pending for it are dropped
+func (app *BookingApp) DeleteWebhook(c *gin.Context) {
+	sub, ok := app.allowWebhook(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	if err := app.webhooks.Remove(sub.ID); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "message": "Webhook deleted."})
+}
+
+// GetWebhookDeliveries returns the latest delivery attempts of a subscription, newest first,
+// with the status each got back and when failed ones will be retried
+func (app *BookingApp) GetWebhookDeliveries(c *gin.Context) {
+	sub, ok := app.allowWebhook(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	deliveries, err := app.webhooks.Deliveries(sub.ID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "webhook_id": sub.ID, "deliveries": deliveries, "count": len(deliveries)})
+}
+
+// allowWebhook looks up subscription id and checks the caller may manage it, writing the
+// error response when not
+func (app *BookingApp) allowWebhook(c *gin.Context, id string) (*webhooks.Subscription, bool) {
+	sub, err := app.webhooks.Get(id)
+	if err != nil {
+		respondError(c, err)
+		return nil, false
+	}
+	if !app.canManageWebhook(c, sub) {
+		c.JSON(http.StatusForbidden, errorBody(c, CodeForbidden, "you can only manage webhooks of conferences you organize"))
+		return nil, false
+	}
+	return sub, true
+}
+
+// canManageWebhook reports whether the caller registered sub, organizes its conference or
+// is an admin. Requests let in by the admin token carry no user and may manage any.
+func (app *BookingApp) canManageWebhook(c *gin.Context, sub *webhooks.Subscription) bool {
+	user := authUser(c)
+	if user == nil || user.IsAdmin() || sub.CreatedBy == user.ID {
+		return true
+	}
+	if sub.ConferenceID == "" {
+		return false
+	}
+	conf, err := app.store(c).GetConference(sub.ConferenceID)
+	return err == nil && conf.OrganizerID == user.ID
+}
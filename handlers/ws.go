@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"booking-system/database"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+)
+
+// seatUpdate is one message on the live seat feed: what happened and the conference's seats
+// right after it
+type seatUpdate struct {
+	Type             string    `json:"type"` // "snapshot", "ping" or an event type, e.g. "reservation.create"
+	At               time.Time `json:"at"`
+	ConferenceID     string    `json:"conference_id,omitempty"`
+	TicketCount      int       `json:"ticket_count,omitempty"`
+	AvailableTickets int       `json:"available_tickets"`
+	ReservedTickets  int       `json:"reserved_tickets"`
+	BookableTickets  int       `json:"bookable_tickets"` // available less what active holds keep back
+	QueueLength      int       `json:"queue_length"`
+}
+
+// seatsFor fills in the current seat counts of update's conference; false once it is gone
+func (app *BookingApp) seatsFor(update *seatUpdate, stats map[string]database.ConferenceStats) bool {
+	conf, err := app.db.GetConference(update.ConferenceID)
+	if err != nil {
+		return false
+	}
+	st := stats[conf.ID]
+	update.AvailableTickets = conf.AvailableTickets
+	update.ReservedTickets = st.Reserved
+	update.BookableTickets = max(conf.AvailableTickets-st.Reserved, 0)
+	update.QueueLength = st.Queue
+	return true
+}
+
+// LiveSeats serves the live seat feed over a WebSocket. A client first gets a "snapshot"
+// message per conference, then one message for every booking, reservation, queue or
+// conference change with the affected conference's seat counts after it. ?conference_id=
+// follows a single conference. Clients only need to read; anything they send is ignored. The
+// feed is public, so it carries seat counts but never whose reservation or booking changed.
+func (app *BookingApp) LiveSeats(c *gin.Context) {
+	only := c.Query("conference_id")
+	if only != "" {
+		if _, err := app.store(c).GetConference(only); err != nil {
+			respondError(c, err)
+			return
+		}
+	}
+
+	server := websocket.Server{
+		// The feed is public and read-only, so any origin may open it
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler:   func(ws *websocket.Conn) { app.feedSeats(c.Request.Context(), ws, only) },
+	}
+	server.ServeHTTP(c.Writer, c.Request)
+}
+
+// feedSeats writes seat updates to ws until the client goes away or ctx is cancelled (the
+// server shutting down: the socket is hijacked, so Shutdown doesn't wait for it)
+func (app *BookingApp) feedSeats(ctx context.Context, ws *websocket.Conn, only string) {
+	defer ws.Close()
+	// Subscribe before the snapshot so nothing that happens in between is missed
+	events, unsubscribe := app.db.Subscribe()
+	defer unsubscribe()
+
+	// The client closing the socket (or dropping) ends the read loop
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		var discard string
+		for websocket.Message.Receive(ws, &discard) == nil {
+		}
+	}()
+
+	stats := app.db.GetConferenceStats()
+	for _, conf := range app.db.GetAllConferences() {
+		if only != "" && conf.ID != only {
+			continue
+		}
+		update := seatUpdate{Type: "snapshot", At: time.Now(), ConferenceID: conf.ID}
+		if app.seatsFor(&update, stats) && websocket.JSON.Send(ws, update) != nil {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(EventsHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		var update seatUpdate
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			if ev.ConferenceID == "" || (only != "" && ev.ConferenceID != only) {
+				continue
+			}
+			update = seatUpdate{
+				Type:         ev.Type,
+				At:           ev.At,
+				ConferenceID: ev.ConferenceID,
+				TicketCount:  ev.TicketCount,
+			}
+			// A deleted conference is still reported, with no seats
+			app.seatsFor(&update, app.db.GetConferenceStats())
+		case <-heartbeat.C:
+			update = seatUpdate{Type: "ping", At: time.Now()}
+		case <-gone:
+			return
+		case <-ctx.Done():
+			return
+		}
+		if err := websocket.JSON.Send(ws, update); err != nil {
+			return
+		}
+	}
+}
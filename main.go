@@ -1,72 +1,369 @@
 package main
 
 import (
-	"fmt"
-	"log"
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"booking-system/config"
+	"booking-system/database"
+	"booking-system/database/postgres"
+	"booking-system/database/redisstore"
+	"booking-system/database/sqlite"
+	"booking-system/database/sqlstore"
+	"booking-system/database/tracedstore"
 	"booking-system/handlers"
+	"booking-system/notifications"
+	"booking-system/payments"
+	"booking-system/tickets"
+	"booking-system/tracing"
+	"booking-system/webhooks"
 
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
+	// --seed starts from a fixtures file or directory instead of FIXTURES_FILE/FIXTURES_DIR
+	// and ignores a saved SNAPSHOT_PATH, so each demo or load test picks its own data set
+	seed := flag.String("seed", "", "fixtures file (JSON or YAML) or directory to start from, ignoring any saved snapshot")
+	flag.Parse()
+	
+	// Everything logs JSON lines through slog, including packages still using the log package
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+	
+	// Resolve all environment-driven settings in one place
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+	
+	// Create the database, optionally seeded from a fixture set for demos
+	db := database.NewDatabase()
+	db.MaxTicketsPerUser = cfg.MaxTicketsPerUser
+	db.MaxTicketsPerUserGlobal = cfg.MaxTicketsPerUserGlobal
+	db.MaxFailedClaims = cfg.MaxFailedClaims
+	db.HoldTime = cfg.ReservationHold
+	db.MaxExtensions = cfg.MaxReservationExtensions
+	db.MaxHoldTime = cfg.MaxHoldTime
+	db.PaymentHoldTime = cfg.PaymentHoldTime
+	db.OfferWindow = cfg.QueueOfferWindow
+	db.ClaimWindow = cfg.QueueClaimWindow
+	db.MaxClaimRequeues = cfg.QueueClaimRequeues
+	db.QueueCapFactor = cfg.QueueCapFactor
+	db.MaxQueueLength = cfg.MaxQueueLength
+	db.MaxAuditEntries = cfg.MaxAuditEntries
+	db.Payments = database.NewSimulatedPayments(float64(cfg.PaymentFailPercent))
+	db.SetJanitorInterval(cfg.ReservationSweepInterval)
+	fixtures := cfg.Fixtures()
+	if *seed != "" {
+		fixtures = *seed
+	}
+	if fixtures != "" {
+		fx, err := database.LoadFixtures(fixtures)
+		if err != nil {
+			fatal("Failed to load fixtures", "path", fixtures, "error", err)
+		}
+		db.UseFixtures(fx)
+	}
+	
+	// Restore the previous run's state if a snapshot exists, and with SNAPSHOT_INTERVAL keep
+	// saving it while running so a crash loses at most that much
+	stopSnapshots := func() {}
+	if cfg.SnapshotPath != "" {
+		if *seed != "" {
+			slog.Info("Starting from the seed, not the saved snapshot", "path", cfg.SnapshotPath, "seed", *seed)
+		} else if err := db.LoadSnapshot(cfg.SnapshotPath); err == nil {
+			slog.Info("Restored state from snapshot", "path", cfg.SnapshotPath)
+		} else if errors.Is(err, os.ErrNotExist) {
+			slog.Info("No snapshot yet; starting fresh", "path", cfg.SnapshotPath)
+		} else {
+			fatal("Failed to load snapshot", "path", cfg.SnapshotPath, "error", err)
+		}
+		if cfg.SnapshotInterval > 0 {
+			stopSnapshots = db.SyncTo(database.SnapshotFile(cfg.SnapshotPath), cfg.SnapshotInterval)
+		}
+	}
+	
+	// With a durable STORAGE_BACKEND, Postgres or a SQLite file holds the state: restore it
+	// (or seed it on first run), then commit every change to it before answering
+	backend, err := cfg.Backend()
+	if err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+	var backendStore database.Store = db
+	var store *sqlstore.Store
+	if backend != config.BackendMemory {
+		ctx, cancel := context.WithTimeout(context.Background(), database.PersistTimeout)
+		var tables *sqlstore.Persister
+		if backend == config.BackendSQLite {
+			tables, err = sqlite.Open(ctx, cfg.SQLitePath)
+		} else {
+			tables, err = postgres.Open(ctx, cfg.DatabaseURL)
+		}
+		if err != nil {
+			fatal("Failed to open storage", "backend", backend, "error", err)
+		}
+		var seeded bool
+		store, seeded, err = sqlstore.Open(ctx, db, tables)
+		cancel()
+		if err != nil {
+			fatal("Failed to load from storage", "backend", backend, "error", err)
+		}
+		if seeded {
+			slog.Info("Seeded empty storage", "backend", backend)
+		} else {
+			slog.Info("Restored state from storage", "backend", backend)
+		}
+		backendStore = store
+	}
+	
+	// With REDIS_URL, instances behind a load balancer share their state through Redis
+	var shared *redisstore.Shared
+	if cfg.RedisURL != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), redisstore.OpTimeout)
+		shared, err = redisstore.Open(ctx, cfg.RedisURL, db)
+		cancel()
+		if err != nil {
+			fatal("Failed to join shared state in Redis", "error", err)
+		}
+		shared.SetSweepInterval(cfg.ReservationSweepInterval)
+		backendStore = shared
+		slog.Info("Sharing state through Redis")
+	}
+	
+	// Periodically release holds left behind by users that no longer exist; with REDIS_URL
+	// the elected sweeper releases them along with the lapsed holds
+	stopSweeper := func() {}
+	if shared == nil {
+		stopSweeper = db.StartOrphanSweeper(cfg.OrphanSweepInterval)
+	}
+	
 	// Create the booking application
-	app := handlers.NewBookingApp()
+	app := handlers.NewBookingAppWithDatabase(backendStore)
+	
+	// Email users about their bookings, holds and queue turns through SMTP_HOST
+	var sender notifications.EmailSender = notifications.Noop{}
+	if cfg.SMTPHost != "" {
+		sender = notifications.NewSMTP(notifications.SMTPConfig{
+			Host: cfg.SMTPHost, Port: cfg.SMTPPort, Username: cfg.SMTPUsername, Password: cfg.SMTPPassword, From: cfg.EmailFrom,
+		})
+	} else {
+		slog.Info("SMTP_HOST not set; notification emails are discarded")
+	}
+	// Notifications and webhooks follow this instance's own changes (db, not backendStore) so
+	// that, with REDIS_URL, each change is acted on once by the instance that made it
+	events, unsubscribe := db.Subscribe()
+	stopNotifier := notifications.New(backendStore, sender, notifications.Options{
+		Workers: cfg.EmailWorkers, ExpiryWarning: cfg.EmailExpiryWarning,
+	}).Start(events, unsubscribe, cfg.ShutdownTimeout)
+	
+	// Signed event deliveries to the webhook URLs integrators register
+	dispatcher := webhooks.New(backendStore, webhooks.Options{
+		Workers: cfg.WebhookWorkers, Attempts: cfg.WebhookMaxAttempts, RetryDelay: cfg.WebhookRetryDelay, Timeout: cfg.WebhookTimeout,
+	})
+	webhookEvents, unsubscribeWebhooks := db.Subscribe()
+	stopWebhooks := dispatcher.Start(webhookEvents, unsubscribeWebhooks, cfg.ShutdownTimeout)
+	app.UseWebhooks(dispatcher)
+	
+	// With an OTLP endpoint, requests, store calls and write-lock holds are traced
+	var exporter *tracing.OTLPExporter
+	var tracer *tracing.Tracer
+	if url := cfg.TracesURL(); url != "" {
+		exporter = tracing.NewOTLPExporter(url, cfg.ServiceName, cfg.CollectorHeaders())
+		tracer = tracing.New(exporter, cfg.TraceSamplePercent)
+		db.SetLockTracer(tracedstore.LockSpans(tracer))
+		app.UseTracing(tracedstore.New(backendStore, tracer))
+		slog.Info("Exporting traces", "url", url, "sample_percent", cfg.TraceSamplePercent)
+	}
+	
+	// Login tokens; with several instances they must share JWT_SECRET
+	jwtSecret := []byte(cfg.JWTSecret)
+	if len(jwtSecret) == 0 {
+		slog.Warn("JWT_SECRET not set; using a random key, so tokens stop working on restart")
+		jwtSecret = handlers.RandomSecret()
+	}
+	app.UseTokens(handlers.NewTokenIssuer(jwtSecret, cfg.JWTTTL))
+	// Ticket QR codes; every instance checking tickets at the door must share the key
+	ticketSecret := []byte(cfg.TicketSecret)
+	if len(ticketSecret) == 0 {
+		ticketSecret = jwtSecret
+	}
+	app.UseTickets(tickets.NewSigner(ticketSecret))
+	// Waiting room admissions are JWTs too, told apart from login tokens by their issuer
+	app.UseAdmissions(handlers.NewAdmissionIssuer(jwtSecret))
+	app.UseAdminEmails(cfg.Admins(), cfg.AdminToken)
+	if cfg.StripeSecretKey != "" {
+		app.UsePayments(payments.NewStripe(cfg.StripeSecretKey, cfg.StripeWebhookSecret), strings.ToLower(cfg.PaymentCurrency))
+	}
+	auth := app.RequireAuth()
+	// New holds, bookings and queue entries are turned away with 503 once shutdown begins
+	accepting := app.RejectWhileDraining()
+	
+	// Admin routes take an admin user's bearer token, or X-Admin-Token matching ADMIN_TOKEN;
+	// conference management lets organizers in too
+	adminOnly := app.RequireAdmin(cfg.AdminToken)
+	organizers := app.RequireOrganizer(cfg.AdminToken)
+	
+	// Per-client token buckets for the auth, booking (bookings, reservations, carts) and queue
+	// routes, each group limited apart; RATE_LIMITS overrides a group's rate and burst
+	limiter := handlers.NewRateLimiter(float64(cfg.RateLimitPerSecond), cfg.RateLimitBurst)
+	rateLimits, err := cfg.RateLimits()
+	if err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+	for group, l := range rateLimits {
+		limiter.SetGroup(group, l.PerSecond, l.Burst)
+	}
+	limiter.SetIPMultiplier(cfg.RateLimitIPMultiplier)
+	stopLimiterCleanup := limiter.StartCleanup(handlers.RateLimitCleanupInterval)
+	authLimit := limiter.Group(handlers.RateLimitAuth)
+	limit := limiter.Group(handlers.RateLimitBookings)
+	queueLimit := limiter.Group(handlers.RateLimitQueue)
 	
 	// Create Gin router
-	router := gin.Default()
+	router := gin.New()
 	
-	// Middleware for logging
-	router.Use(gin.Logger())
+	// Request IDs, request spans, structured JSON request logs (warn on 4xx, error on 5xx) and panic recovery
+	router.Use(handlers.RequestID())
+	if tracer != nil {
+		router.Use(handlers.Tracing(tracer))
+	}
+	router.Use(handlers.RequestLogger(slog.Default()))
 	router.Use(gin.Recovery())
 	
-	// CORS middleware for frontend integration
-	router.Use(func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(http.StatusNoContent)
-			return
-		}
-		
-		c.Next()
-	})
+	// Refuse request bodies over MAX_BODY_BYTES (default 1MB) with 413
+	router.Use(handlers.LimitBody(int64(cfg.MaxBodyBytes)))
+	
+	// CORS for frontend integration; any origin unless ALLOWED_ORIGINS lists them
+	router.Use(handlers.CORS(cfg.Origins()))
 	
 	// API Routes
-	api := router.Group("/api/v1")
+	api := router.Group(handlers.APIPrefix)
 	{
 		// Health check
 		api.GET("/health", app.HealthCheck)
 		
+		// Live events (Server-Sent Events) for dashboards
+		api.GET("/events", auth, app.StreamEvents)
+		
 		// Conferences
 		api.GET("/conferences", app.GetConferences)
+		api.GET("/conferences/search", app.SearchConferences)
+		api.GET("/conferences/:id/analytics", app.GetConferenceAnalytics)
+		api.GET("/conferences/:id/seats", app.GetSeatMap)
+		api.GET("/analytics", app.GetAnalytics)
+		
+		// Conference management; organizers may only change the ones they created
+		api.POST("/conferences", organizers, app.CreateConference)
+		api.PUT("/conferences/:id", organizers, app.UpdateConference)
+		api.DELETE("/conferences/:id", organizers, app.DeleteConference)
+		api.GET("/conferences/:id/checkins", organizers, app.GetCheckIns)
+		
+		// Door staff (organizers) scan tickets; each gets in once
+		api.POST("/checkin", organizers, app.CheckIn)
+		
+		// Webhooks: organizers subscribe to their conferences' events, admins to every conference's
+		api.POST("/webhooks", organizers, app.CreateWebhook)
+		api.GET("/webhooks", organizers, app.ListWebhooks)
+		api.DELETE("/webhooks/:id", organizers, app.DeleteWebhook)
+		api.GET("/webhooks/:id/deliveries", organizers, app.GetWebhookDeliveries)
+		
+		// Accounts: register or log in to get a bearer token for the routes marked auth
+		api.POST("/auth/register", authLimit, app.Register)
+		api.POST("/auth/login", authLimit, app.Login)
 		
-		// Users
-		api.POST("/users", app.CreateUser)
-		api.GET("/users/:userID/bookings", app.GetUserBookings)
-		api.GET("/users/:userID/reservations", app.GetUserReservations)
+		// Users; accounts without a password are only created by admins, everyone else registers
+		api.POST("/users", adminOnly, app.CreateUser)
+		api.GET("/users", auth, app.FindUser)
+		api.GET("/users/:userID", auth, app.GetUser)
+		api.POST("/users/bulk", adminOnly, app.CreateUsers)
+		api.GET("/users/:userID/bookings", auth, app.GetUserBookings)
+		api.GET("/users/:userID/reservations", auth, app.GetUserReservations)
+		api.GET("/users/:userID/reservations/history", auth, app.GetUserReservationHistory)
+		api.GET("/users/:userID/allowance/:conferenceID", auth, app.GetAllowance)
 		
-		// Bookings (direct booking - old way)
-		api.POST("/bookings", app.CreateBooking)
-		api.GET("/bookings", app.GetAllBookings)  // Get all bookings for testing
-		api.GET("/bookings/:id", app.GetBooking)
+		// Bookings (direct booking - old way); callers may only touch their own
+		api.POST("/bookings", auth, accepting, limit, app.CreateBooking)
+		api.GET("/bookings/export", adminOnly, app.ExportBookings)
+		api.GET("/bookings/:id", auth, app.GetBooking)
+		api.DELETE("/bookings/:id", auth, limit, app.CancelBooking)
+		api.GET("/bookings/:id/tickets", auth, app.GetBookingTickets)
+		api.GET("/bookings/:id/tickets/:ticketID/download", auth, app.DownloadTicket)
 		
-		// Reservations (new payment queue system)
-		api.POST("/reservations", app.CreateReservation)
-		api.GET("/reservations/:id", app.GetReservation)
-		api.POST("/reservations/:id/confirm", app.ConfirmReservation)
-		api.DELETE("/reservations/:id", app.CancelReservation)
+		// Reservations (new payment queue system); callers may only touch their own
+		api.POST("/reservations", auth, accepting, limit, app.CreateReservation)
+		api.POST("/reservations/bundle", auth, accepting, limit, app.CreateReservationBundle)
+		api.GET("/reservations/:id", auth, app.GetReservation)
+		api.POST("/reservations/:id/confirm", auth, limit, app.ConfirmReservation)
+		api.DELETE("/reservations/:id", auth, limit, app.CancelReservation)
+		api.POST("/reservations/:id/extend-once", auth, limit, app.ExtendReservationOnce)
+		api.POST("/reservations/:id/extend", auth, limit, app.ExtendReservation)
+		api.POST("/reservations/:id/accept", auth, accepting, limit, app.AcceptOffer)
+
+		// Carts: tickets for several conferences held together and paid for at once
+		api.POST("/carts", auth, app.CreateCart)
+		api.GET("/carts/:id", auth, app.GetCart)
+		api.PUT("/carts/:id/items", auth, limit, app.SetCartItem)
+		api.DELETE("/carts/:id/items/:conferenceID", auth, app.RemoveCartItem)
+		api.POST("/carts/:id/checkout", auth, accepting, limit, app.CheckoutCart)
+		api.POST("/carts/:id/confirm", auth, limit, app.ConfirmCart)
+		api.DELETE("/carts/:id", auth, limit, app.CancelCart)
+
+		// Payment provider callbacks, authenticated by their signature rather than a login
+		api.POST("/payments/webhook", app.PaymentWebhook)
 
 		// Wait queue
-		api.POST("/queue/enqueue", app.EnqueueWait)
-		api.GET("/queue/:conferenceID/position", app.GetQueuePosition)
-		api.POST("/queue/claim", app.ClaimNext)
+		api.POST("/queue/enqueue", auth, accepting, queueLimit, app.EnqueueWait)
+		api.GET("/queue/:conferenceID/position", auth, queueLimit, app.GetQueuePosition)
+		api.POST("/queue/claim", auth, accepting, queueLimit, app.ClaimNext)
+		api.DELETE("/queue/leave", auth, queueLimit, app.LeaveQueue)
+		api.DELETE("/queue/:conferenceID", auth, queueLimit, app.LeaveQueue)
+		
+		// Waiting rooms: conferences with one only take holds and bookings carrying an
+		// admission token, handed out to the visitors in line at the room's rate
+		api.POST("/waiting-room/:conferenceID", auth, accepting, queueLimit, app.JoinWaitingRoom)
+		api.GET("/waiting-room/:conferenceID", auth, queueLimit, app.GetWaitingRoom)
+
+		// Admin and debugging
+		api.POST("/queue/bulk-enqueue", adminOnly, app.BulkEnqueue)
+		
+		admin := api.Group("/admin", adminOnly)
+		admin.GET("/users", app.ListUsers)
+		admin.PUT("/users/:userID/role", app.SetUserRole)
+		admin.PUT("/users/:userID/priority", app.SetUserPriority)
+		admin.GET("/bookings", app.GetAllBookings)
+		admin.GET("/reservations", app.ListReservations)
+		admin.GET("/audit", app.GetAuditLog)
+		admin.GET("/conferences/:id/inventory", app.GetInventory)
+		admin.GET("/conferences/:id/queue", app.GetQueue)
+		admin.POST("/conferences/:id/release-holdback", app.ReleaseHoldback)
+		admin.POST("/reset", app.ResetDatabase)
+		admin.GET("/export", app.ExportSnapshot)
+		admin.POST("/import", app.ImportSnapshot)
+		api.GET("/config", adminOnly, handlers.ServeConfig(cfg))
+		
+		debug := api.Group("/debug", adminOnly)
+		debug.POST("/replay", app.ReplayAudit)
 	}
 	
+	// Prometheus-style metrics for load tests
+	router.GET("/metrics", app.Metrics)
+	
+	// Live seat availability over a WebSocket for the test UI and dashboards
+	router.GET("/ws", app.LiveSeats)
+	
+	// OpenAPI document of the routes above, and Swagger UI to browse it
+	router.GET("/openapi.json", handlers.ServeOpenAPI())
+	router.GET("/docs", handlers.ServeDocs)
+	
 	// Serve static files and frontend
 	router.Static("/static", "./")
 	router.StaticFile("/", "./index.html")
@@ -74,27 +371,87 @@ func main() {
     
 	
 	// Start server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	addr := cfg.Addr()
+	slog.Info("Booking System Server starting", "addr", addr,
+		"frontend", "http://"+addr, "api", "http://"+addr+handlers.APIPrefix+"/")
+	
+	// Streaming handlers (SSE) watch their request context; cancelling the base context at
+	// shutdown lets them return instead of holding Shutdown open until the timeout
+	baseCtx, cancelStreams := context.WithCancel(context.Background())
+	server := &http.Server{
+		Addr:        addr,
+		Handler:     router,
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
 	}
 	
-	// Support both local development and cloud deployment
-	host := os.Getenv("HOST")
-	if host == "" {
-		host = "127.0.0.1"
-		if os.Getenv("RAILWAY_ENVIRONMENT") != "" || os.Getenv("RENDER") != "" || os.Getenv("DOCKER_ENV") == "true" {
-			host = "0.0.0.0" // Listen on all interfaces for cloud deployment or Docker
+	serverErr := make(chan error, 1)
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- err
 		}
+	}()
+	
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-serverErr:
+		fatal("Failed to start server", "error", err)
+	case received := <-sig:
+		slog.Info("Shutting down, draining requests", "signal", received.String(), "timeout", cfg.ShutdownTimeout.String())
 	}
 	
-	addr := fmt.Sprintf("%s:%s", host, port)
-	log.Printf("🚀 Booking System Server starting on %s", addr)
-	log.Printf("🌐 Frontend: http://%s", addr)
-	log.Printf("🔌 API: http://%s/api/v1/", addr)
-	log.Printf("🧪 Ready for multiplayer concurrency testing!")
+	// Turn away new holds and fail the health check, then give load balancers
+	// SHUTDOWN_DRAIN_DELAY to notice before the listener closes
+	app.StartDraining()
+	if cfg.ShutdownDrainDelay > 0 {
+		slog.Info("Waiting for load balancers to stop routing here", "delay", cfg.ShutdownDrainDelay.String())
+		time.Sleep(cfg.ShutdownDrainDelay)
+	}
 	
-	if err := router.Run(addr); err != nil {
-		log.Fatal("Failed to start server:", err)
+	// Stop accepting connections and let in-flight requests finish
+	cancelStreams()
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Warn("HTTP server did not drain cleanly", "error", err)
+	} else {
+		slog.Info("HTTP server stopped; all requests drained")
 	}
-}
\ No newline at end of file
+	
+	// Stop background goroutines before taking the final snapshot so state is quiescent
+	stopNotifier()
+	stopWebhooks()
+	stopSweeper()
+	stopLimiterCleanup()
+	db.Close()
+	if shared != nil {
+		shared.Close()
+	}
+	slog.Info("Background workers stopped")
+	
+	if cfg.SnapshotPath != "" {
+		stopSnapshots()
+		if err := db.SaveSnapshot(cfg.SnapshotPath); err != nil {
+			fatal("Failed to save snapshot", "path", cfg.SnapshotPath, "error", err)
+		}
+		slog.Info("Saved snapshot", "path", cfg.SnapshotPath)
+	}
+	if store != nil {
+		store.Close()
+		slog.Info("Closed storage", "backend", backend)
+	}
+	if exporter != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), tracing.ExportTimeout)
+		if err := exporter.Shutdown(ctx); err != nil {
+			slog.Warn("Traces not flushed", "error", err)
+		}
+		cancel()
+	}
+	slog.Info("Shutdown complete")
+}
+
+// fatal logs msg at error level and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
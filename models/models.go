@@ -8,6 +8,25 @@ type User struct {
 	Name    string    `json:"name"`
 	Email   string    `json:"email"`
 	Created time.Time `json:"created"`
+	Role    string    `json:"role"` // RoleUser, RoleOrganizer or RoleAdmin; empty (older records) means RoleUser
+	QueuePriority int `json:"queue_priority,omitempty"` // class the user's own queue joins get, e.g. for members; set by admins, 0 is general
+}
+
+// User roles
+const (
+	RoleUser      = "user"
+	RoleOrganizer = "organizer" // may create conferences and manage the ones they created
+	RoleAdmin     = "admin"
+)
+
+// IsAdmin reports whether the user may use the admin routes
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+// CanOrganize reports whether the user may create conferences
+func (u *User) CanOrganize() bool {
+	return u.Role == RoleOrganizer || u.Role == RoleAdmin
 }
 
 // Conference represents a conference that can be booked
@@ -19,6 +38,67 @@ type Conference struct {
 	AvailableTickets int       `json:"available_tickets"`
 	Price            float64   `json:"price"`
 	Date             time.Time `json:"date"`
+	// Free text and lower-case keywords, searched along with the name and location
+	Description string   `json:"description,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	// Caps on tickets held in unconfirmed reservations at once; zero disables a cap
+	MaxHeldTickets int     `json:"max_held_tickets,omitempty"`
+	MaxHeldPercent int     `json:"max_held_percent,omitempty"` // percent of AvailableTickets
+	FeePercent     float64 `json:"fee_percent,omitempty"`      // fees and taxes on top of Price
+	// Seats set aside for press/sponsors that public bookings and the queue cannot take
+	ReservedHoldback int `json:"reserved_holdback"`
+	// Bumped whenever AvailableTickets changes; clients may send it back as expected_version
+	Version int `json:"version"`
+	// Optional priced sub-pools (General, VIP, ...); TotalTickets and AvailableTickets stay
+	// the aggregate across every tier and untiered seats
+	Tiers []Tier `json:"tiers,omitempty"`
+	// User who created the conference and may edit or delete it; empty for sample data and
+	// conferences created with the admin token
+	OrganizerID string `json:"organizer_id,omitempty"`
+	// How long reservations for this conference hold seats; zero uses the server default
+	HoldSeconds int `json:"hold_seconds,omitempty"`
+	// Optional seat map; with one, every hold and booking is for specific seats
+	Sections []Section `json:"sections,omitempty"`
+	// With a waiting room, holds and bookings take an admission token; nil sells to anyone
+	WaitingRoom *WaitingRoom `json:"waiting_room,omitempty"`
+}
+
+// WaitingRoom gates a high-demand on-sale: visitors join a line and are let in one at a time,
+// AdmitPerMinute of them a minute, and each may then hold or book seats for AdmissionSeconds
+type WaitingRoom struct {
+	AdmitPerMinute   int `json:"admit_per_minute"`
+	AdmissionSeconds int `json:"admission_seconds,omitempty"` // zero uses the server default
+}
+
+// AdmitInterval is the time between two admissions
+func (w *WaitingRoom) AdmitInterval() time.Duration {
+	return time.Minute / time.Duration(w.AdmitPerMinute)
+}
+
+// Section is a block of numbered seats laid out in rows. Its seats are named
+// <section>-<row>-<seat>, counting rows and seats from 1.
+type Section struct {
+	Name        string `json:"name"`
+	Rows        int    `json:"rows"`
+	SeatsPerRow int    `json:"seats_per_row"`
+}
+
+// Tier is a named block of seats with its own price
+type Tier struct {
+	Name             string  `json:"name"`
+	Price            float64 `json:"price"`
+	TotalTickets     int     `json:"total_tickets"`
+	AvailableTickets int     `json:"available_tickets"`
+}
+
+// PriceWithFees returns the all-in ticket price including fees and taxes
+func (c *Conference) PriceWithFees() float64 {
+	return c.Price * (1 + c.FeePercent/100)
+}
+
+// HoldDuration is the conference's own reservation hold time, zero when it uses the default
+func (c *Conference) HoldDuration() time.Duration {
+	return time.Duration(c.HoldSeconds) * time.Second
 }
 
 // Booking represents a booking made by a user for a conference
@@ -30,6 +110,10 @@ type Booking struct {
 	TotalAmount   float64   `json:"total_amount"`
 	Status        string    `json:"status"`
 	BookedAt      time.Time `json:"booked_at"`
+	Attendees     []string  `json:"attendees"` // badge names, one per ticket when given
+	Tier          string    `json:"tier,omitempty"` // tier the seats came from; empty is the aggregate pool
+	PaymentID     string    `json:"payment_id,omitempty"` // provider payment that paid for it, if any
+	SeatIDs       []string  `json:"seat_ids,omitempty"` // assigned seats, for conferences with a seat map
 }
 
 // SeatReservation represents a temporary seat hold during payment
@@ -41,5 +125,135 @@ type SeatReservation struct {
 	TotalAmount  float64   `json:"total_amount"`
 	ExpiresAt    time.Time `json:"expires_at"`
 	CreatedAt    time.Time `json:"created_at"`
+	Extended     bool      `json:"extended"` // the one-time automatic extension has been used
+	Source       string    `json:"source"`   // ReservationSourceDirect or ReservationSourceQueue
+	Status       string    `json:"status"`   // one of the ReservationStatus values
+	// ExtensionCount is how many times ExtendReservation has pushed ExpiresAt back
+	ExtensionCount int    `json:"extension_count"`
+	PromoCode      string `json:"promo_code,omitempty"` // code that discounted TotalAmount
+	Tier           string `json:"tier,omitempty"`       // tier the seats are held in; empty is the aggregate pool
+	// HoldSeconds is the length of the hold (or offer window) ExpiresAt was last set from, so
+	// clients can draw the countdown without assuming a hold time
+	HoldSeconds int `json:"hold_seconds"`
+	// Provider payment intent the client pays with ClientSecret; once set, the hold is booked
+	// when the provider reports the payment rather than on a confirm call
+	PaymentID           string `json:"payment_id,omitempty"`
+	PaymentClientSecret string `json:"payment_client_secret,omitempty"`
+	// Seats the hold locks, for conferences with a seat map
+	SeatIDs []string `json:"seat_ids,omitempty"`
+	// Attendees named on a confirm call while the payment is pending; the booking made when the
+	// provider reports the payment carries them
+	Attendees []string `json:"attendees,omitempty"`
+	// The wait queue entry an offer was made to, so an offer that lapses unanswered can put the
+	// user back in line; nil for other holds
+	QueueTurn *QueueTurn `json:"queue_turn,omitempty"`
 }
 
+// QueueTurn is what an offered reservation keeps of the wait queue entry it was made to
+type QueueTurn struct {
+	EntryID      string `json:"entry_id"`
+	Priority     int    `json:"priority,omitempty"`
+	MissedClaims int    `json:"missed_claims,omitempty"` // turns the entry let pass before this one
+}
+
+// PromoCode discounts a reservation's TotalAmount by a percentage or a fixed amount
+type PromoCode struct {
+	Code         string    `json:"code"`
+	PercentOff   float64   `json:"percent_off,omitempty"`   // e.g. 20 for 20% off
+	AmountOff    float64   `json:"amount_off,omitempty"`    // fixed discount per reservation
+	ConferenceID string    `json:"conference_id,omitempty"` // empty applies to every conference
+	MaxUses      int       `json:"max_uses,omitempty"`      // confirmed uses allowed; zero is unlimited
+	Uses         int       `json:"uses"`                    // confirmed bookings that used the code
+	ExpiresAt    time.Time `json:"expires_at,omitempty"`    // zero never expires
+}
+
+// Discount returns what the code takes off amount, never more than amount itself
+func (p *PromoCode) Discount(amount float64) float64 {
+	off := amount*p.PercentOff/100 + p.AmountOff
+	if off > amount {
+		return amount
+	}
+	return off
+}
+
+// Reservation sources
+const (
+	ReservationSourceDirect = "direct" // created via CreateReservation
+	ReservationSourceQueue  = "queue"  // created by claiming the head of a wait queue
+)
+
+// Reservation statuses; only active reservations hold seats
+const (
+	ReservationStatusActive    = "active"
+	ReservationStatusOffered   = "offered" // seats the wait queue handed over; accept or confirm before it expires
+	ReservationStatusExpired   = "expired"
+	ReservationStatusConfirmed = "confirmed"
+	ReservationStatusCancelled = "cancelled"
+)
+
+// CartItem is one conference's line in a cart
+type CartItem struct {
+	ConferenceID string `json:"conference_id"`
+	TicketCount  int    `json:"ticket_count"`
+	Tier         string `json:"tier,omitempty"` // empty uses the aggregate pool
+}
+
+// Cart collects tickets at several conferences that are held together at checkout and then
+// booked with a single payment
+type Cart struct {
+	ID     string     `json:"id"`
+	UserID string     `json:"user_id"`
+	Items  []CartItem `json:"items"`
+	Status string     `json:"status"` // one of the CartStatus values
+	// One hold per item once checked out, in item order; cleared if the checkout is rolled back
+	ReservationIDs []string  `json:"reservation_ids,omitempty"`
+	BookingIDs     []string  `json:"booking_ids,omitempty"`
+	TotalAmount    float64   `json:"total_amount"`         // what the holds cost, set at checkout
+	ExpiresAt      time.Time `json:"expires_at,omitempty"` // when the first hold lapses
+	// Provider payment intent covering every hold; see SeatReservation.PaymentID
+	PaymentID           string    `json:"payment_id,omitempty"`
+	PaymentClientSecret string    `json:"payment_client_secret,omitempty"`
+	CreatedAt           time.Time `json:"created_at"`
+	UpdatedAt           time.Time `json:"updated_at"`
+}
+
+// Cart statuses
+const (
+	CartStatusOpen      = "open"      // items may be added and removed
+	CartStatusReserved  = "reserved"  // every item is held; confirm to book them all
+	CartStatusCompleted = "completed" // every hold was booked
+	CartStatusCancelled = "cancelled"
+)
+
+// Ticket is one admission of a booking, what an attendee shows at the door. Tickets aren't
+// stored: they are numbered 1..TicketsBooked from the booking and void once it is cancelled.
+type Ticket struct {
+	ID           string     `json:"id"` // booking ID, a dash and Number
+	BookingID    string     `json:"booking_id"`
+	ConferenceID string     `json:"conference_id"`
+	UserID       string     `json:"user_id"`
+	Number       int        `json:"number"`
+	Attendee     string     `json:"attendee,omitempty"` // badge name, when the booking gave one
+	SeatID       string     `json:"seat_id,omitempty"`
+	Tier         string     `json:"tier,omitempty"`
+	Status       string     `json:"status"` // one of the TicketStatus values
+	IssuedAt     time.Time  `json:"issued_at"`
+	CheckedInAt  *time.Time `json:"checked_in_at,omitempty"`
+}
+
+// Ticket statuses
+const (
+	TicketStatusValid     = "valid"
+	TicketStatusCheckedIn = "checked_in" // admitted at the door; it can't be used again
+	TicketStatusVoid      = "void"       // the booking was cancelled
+)
+
+// CheckIn records a ticket being admitted at the door
+type CheckIn struct {
+	TicketID     string    `json:"ticket_id"`
+	BookingID    string    `json:"booking_id"`
+	ConferenceID string    `json:"conference_id"`
+	UserID       string    `json:"user_id"`            // ticket holder
+	StaffID      string    `json:"staff_id,omitempty"` // who scanned it; empty for the admin token
+	CheckedInAt  time.Time `json:"checked_in_at"`
+}
@@ -0,0 +1,125 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Limits on user input; the email limit is the longest address SMTP can carry
+const (
+	MaxNameLength  = 100
+	MaxEmailLength = 254
+)
+
+// Password length limits; bcrypt ignores everything past 72 bytes, so longer ones are refused
+// rather than silently truncated
+const (
+	MinPasswordLength = 8
+	MaxPasswordLength = 72
+)
+
+// emailPattern accepts a dot-atom local part and a domain of at least two labels ending in
+// an alphabetic TLD, which rules out addresses like a@b that mail.ParseAddress lets through
+var emailPattern = regexp.MustCompile(`^[A-Za-z0-9!#$%&'*+/=?^_` + "`" + `{|}~-]+(\.[A-Za-z0-9!#$%&'*+/=?^_` + "`" + `{|}~-]+)*` +
+	`@([A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?\.)+[A-Za-z]{2,63}$`)
+
+// FieldErrors maps each invalid field (by JSON name) to what is wrong with it
+type FieldErrors map[string]string
+
+func (e FieldErrors) Error() string {
+	fields := make([]string, 0, len(e))
+	for f := range e {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	parts := make([]string, len(fields))
+	for i, f := range fields {
+		parts[i] = f + " " + e[f]
+	}
+	return strings.Join(parts, "; ")
+}
+
+// ValidateUserInput checks a signup's name and email, reporting every invalid field at once
+// as FieldErrors. Names are checked after Unicode normalization (see NormalizeName); emails
+// may carry surrounding whitespace, which is dropped when the user is stored.
+func ValidateUserInput(name, email string) error {
+	errs := FieldErrors{}
+	if msg := nameProblem(name); msg != "" {
+		errs["name"] = msg
+	}
+	if msg := emailProblem(strings.TrimSpace(email)); msg != "" {
+		errs["email"] = msg
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// ValidateRegistration checks a signup with a password, reporting every invalid field at once
+func ValidateRegistration(name, email, password string) error {
+	errs := FieldErrors{}
+	if err := ValidateUserInput(name, email); err != nil {
+		errs = err.(FieldErrors)
+	}
+	if msg := passwordProblem(password); msg != "" {
+		errs["password"] = msg
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// NormalizeName puts a name in Unicode NFC form so visually identical names compare equal
+func NormalizeName(name string) string {
+	return norm.NFC.String(name)
+}
+
+func nameProblem(name string) string {
+	switch {
+	case name == "":
+		return "is required"
+	case !utf8.ValidString(name):
+		return "must be valid UTF-8"
+	case strings.TrimSpace(name) != name:
+		return "must not start or end with whitespace"
+	case strings.IndexFunc(name, unicode.IsControl) >= 0:
+		return "must not contain control characters"
+	case utf8.RuneCountInString(NormalizeName(name)) > MaxNameLength:
+		return fmt.Sprintf("must be at most %d characters", MaxNameLength)
+	}
+	return ""
+}
+
+func emailProblem(email string) string {
+	switch {
+	case email == "":
+		return "is required"
+	case len(email) > MaxEmailLength:
+		return fmt.Sprintf("must be at most %d characters", MaxEmailLength)
+	case !emailPattern.MatchString(email):
+		return "must be a valid email address"
+	case strings.Index(email, "@") > 64:
+		return "must have at most 64 characters before the @"
+	}
+	return ""
+}
+
+func passwordProblem(password string) string {
+	switch {
+	case password == "":
+		return "is required"
+	case utf8.RuneCountInString(password) < MinPasswordLength:
+		return fmt.Sprintf("must be at least %d characters", MinPasswordLength)
+	case len(password) > MaxPasswordLength:
+		return fmt.Sprintf("must be at most %d bytes", MaxPasswordLength)
+	}
+	return ""
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateUserInputRejectsMalformedInput(t *testing.T) {
+	cases := []struct {
+		name, email string
+		badField    string // empty means the input is valid
+	}{
+		{"Alice", "alice@example.com", ""},
+		{"Zo\u00eb O'Brien", "zoe.obrien+conf@mail.example.co.uk", ""},
+		{"Alice", "  alice@example.com ", ""},
+		{"", "alice@example.com", "name"},
+		{" Alice", "alice@example.com", "name"},
+		{"Alice\t", "alice@example.com", "name"},
+		{"Al\x00ice", "alice@example.com", "name"},
+		{"Alice\u0007", "alice@example.com", "name"},
+		{strings.Repeat("a", MaxNameLength+1), "alice@example.com", "name"},
+		{"Alice", "", "email"},
+		{"Alice", "a@b", "email"},
+		{"Alice", "alice@example", "email"},
+		{"Alice", "alice..smith@example.com", "email"},
+		{"Alice", ".alice@example.com", "email"},
+		{"Alice", "alice@-example.com", "email"},
+		{"Alice", "alice smith@example.com", "email"},
+		{"Alice", "Alice <alice@example.com>", "email"},
+		{"Alice", strings.Repeat("a", 65) + "@example.com", "email"},
+		{"Alice", "a@" + strings.Repeat("b", 63) + "." + strings.Repeat("c", 63) + "." + strings.Repeat("d", 63) + "." + strings.Repeat("e", 60) + ".com", "email"},
+	}
+	for _, tc := range cases {
+		err := ValidateUserInput(tc.name, tc.email)
+		if tc.badField == "" {
+			if err != nil {
+				t.Errorf("ValidateUserInput(%q, %q): unexpected error %v", tc.name, tc.email, err)
+			}
+			continue
+		}
+		var fields FieldErrors
+		if !errors.As(err, &fields) || fields[tc.badField] == "" || len(fields) != 1 {
+			t.Errorf("ValidateUserInput(%q, %q): expected only %s to be rejected, got %v", tc.name, tc.email, tc.badField, err)
+		}
+	}
+}
+
+func TestNormalizeNameComposesUnicode(t *testing.T) {
+	if got := NormalizeName("Zoe\u0308"); got != "Zo\u00eb" {
+		t.Fatalf("expected the decomposed name in NFC form, got %q", got)
+	}
+}
+
+func TestValidateRegistrationChecksPassword(t *testing.T) {
+	cases := []struct {
+		password string
+		valid    bool
+	}{
+		{"correct horse", true},
+		{"", false},
+		{"short", false},
+		{strings.Repeat("\u00e9", MinPasswordLength), true},
+		{strings.Repeat("x", MaxPasswordLength+1), false},
+	}
+	for _, tc := range cases {
+		err := ValidateRegistration("Alice", "alice@example.com", tc.password)
+		var fields FieldErrors
+		if tc.valid != (err == nil) || (err != nil && (!errors.As(err, &fields) || fields["password"] == "")) {
+			t.Errorf("ValidateRegistration with password %q: got %v", tc.password, err)
+		}
+	}
+
+	err := ValidateRegistration("", "nope", "short")
+	var fields FieldErrors
+	if !errors.As(err, &fields) || len(fields) != 3 {
+		t.Fatalf("expected name, email and password all reported, got %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+// Package notifications emails users about their bookings: a confirmation when one is made,
+// a warning shortly before a hold lapses, a "your turn" message when the wait queue reaches
+// them and a receipt when a booking is cancelled. A Notifier turns the store's live events
+// into emails and hands them to an EmailSender from a small pool of workers, so a slow mail
+// server never holds up a request.
+package notifications
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Email kinds, one per template
+const (
+	KindBookingConfirmed = "booking_confirmed"
+	KindExpiryWarning    = "expiry_warning"
+	KindYourTurn         = "your_turn"
+	KindCancellation     = "cancellation"
+)
+
+// ErrSend wraps every failure to hand an email to the mail server
+var ErrSend = errors.New("send email")
+
+// Email is one plain-text message to one recipient
+type Email struct {
+	Kind    string // one of the Kind values; not sent, it names the template the email came from
+	To      string
+	Subject string
+	Body    string
+}
+
+// EmailSender delivers emails. Send may be called from several goroutines at once.
+type EmailSender interface {
+	Send(ctx context.Context, email Email) error
+}
+
+// Noop is an EmailSender that discards every email, for deployments without a mail server
+type Noop struct{}
+
+// Send discards email
+func (Noop) Send(context.Context, Email) error { return nil }
+
+// Mock is an in-memory EmailSender for tests that records what was sent
+type Mock struct {
+	mu   sync.Mutex
+	sent []Email
+	// Fail, when set, is returned from every Send instead of recording the email
+	Fail error
+}
+
+// NewMock returns an empty Mock
+func NewMock() *Mock {
+	return &Mock{}
+}
+
+// Send records email, or returns Fail
+func (m *Mock) Send(_ context.Context, email Email) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Fail != nil {
+		return m.Fail
+	}
+	m.sent = append(m.sent, email)
+	return nil
+}
+
+// Sent returns a copy of every email sent so far, in sending order
+func (m *Mock) Sent() []Email {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Email(nil), m.sent...)
+}
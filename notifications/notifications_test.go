@@ -0,0 +1,201 @@
+package notifications
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"booking-system/database"
+	"booking-system/models"
+)
+
+func TestTemplatesRenderEveryKind(t *testing.T) {
+	user := &models.User{Name: "Alice", Email: "alice@example.com"}
+	conf := &models.Conference{Name: "GopherCon", Location: "Berlin", Date: time.Date(2026, 11, 3, 9, 0, 0, 0, time.UTC)}
+	booking := &models.Booking{ID: "bk-1", TicketsBooked: 2, TotalAmount: 398, Tier: "VIP", SeatIDs: []string{"A-1-1", "A-1-2"}}
+	hold := &models.SeatReservation{ID: "res-1", TicketCount: 1, ExpiresAt: time.Date(2026, 10, 1, 12, 30, 0, 0, time.UTC)}
+
+	for _, tc := range []struct {
+		msg     Message
+		subject string
+		body    []string
+	}{
+		{Message{Kind: KindBookingConfirmed, Booking: booking}, "Your booking for GopherCon is confirmed",
+			[]string{"Hi Alice", "Tuesday, 3 November 2026 09:00 UTC", "Tickets: 2 (VIP)", "Seats: A-1-1, A-1-2", "Total paid: 398.00", "bk-1"}},
+		{Message{Kind: KindExpiryWarning, Reservation: hold}, "Your seats for GopherCon are about to be released",
+			[]string{"holding 1 seat for you", "ends at 12:30:00 UTC", "res-1"}},
+		{Message{Kind: KindYourTurn, Reservation: hold}, "It's your turn: seats for GopherCon are waiting",
+			[]string{"1 seat is being held for you until 12:30:00 UTC", "res-1"}},
+		{Message{Kind: KindYourTurn}, "It's your turn: seats for GopherCon are waiting", []string{"Claim your seats now"}},
+		{Message{Kind: KindCancellation, Booking: booking}, "Your booking for GopherCon was cancelled",
+			[]string{"Tickets cancelled: 2", "Amount: 398.00", "void"}},
+	} {
+		tc.msg.User, tc.msg.Conference = user, conf
+		email, err := Render(tc.msg)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.msg.Kind, err)
+		}
+		if email.To != "alice@example.com" || email.Subject != tc.subject || email.Kind != tc.msg.Kind {
+			t.Errorf("%s: unexpected email %+v", tc.msg.Kind, email)
+		}
+		for _, want := range tc.body {
+			if !strings.Contains(email.Body, want) {
+				t.Errorf("%s: body is missing %q:\n%s", tc.msg.Kind, want, email.Body)
+			}
+		}
+	}
+	if _, err := Render(Message{Kind: "newsletter", User: user, Conference: conf}); err == nil {
+		t.Fatalf("expected an error for an unknown kind")
+	}
+}
+
+// waitFor polls until mock has sent an email of kind to to, failing after a second
+func waitFor(t *testing.T, mock *Mock, kind, to string) Email {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		for _, e := range mock.Sent() {
+			if e.Kind == kind && e.To == to {
+				return e
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("no %s email to %s; sent %+v", kind, to, mock.Sent())
+	return Email{}
+}
+
+func TestNotifierEmailsUsersAboutTheirBookings(t *testing.T) {
+	db := database.NewDatabase()
+	t.Cleanup(db.Close)
+	db.OfferWindow = time.Minute
+	db.HoldTime = time.Second
+	mock := NewMock()
+	events, unsubscribe := db.Subscribe()
+	stop := New(db, mock, Options{ExpiryWarning: 150 * time.Millisecond}).Start(events, unsubscribe, time.Second)
+	defer stop()
+
+	alice, _ := db.CreateUser("Alice", "alice@example.com")
+	bob, _ := db.CreateUser("Bob", "bob@example.com")
+	conf, _ := db.CreateConference("Tiny", "Berlin", 1, 10, time.Now().AddDate(0, 1, 0), "", database.ConferenceOptions{})
+
+	booking, err := db.CreateBooking(bob.ID, conf.ID, 1, database.BookingOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if email := waitFor(t, mock, KindBookingConfirmed, "bob@example.com"); !strings.Contains(email.Body, booking.ID) {
+		t.Fatalf("expected the booking reference, got %s", email.Body)
+	}
+
+	// alice waits; bob cancelling offers her the seat
+	if _, err := db.EnqueueWait(alice.ID, conf.ID, 1, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := db.CancelBooking(booking.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	waitFor(t, mock, KindCancellation, "bob@example.com")
+	waitFor(t, mock, KindYourTurn, "alice@example.com")
+
+	// a one-second hold is warned about 150ms before it lapses
+	carol, _ := db.CreateUser("Carol", "carol@example.com")
+	conf2, _ := db.CreateConference("Short holds", "Paris", 5, 10, time.Now().AddDate(0, 1, 0), "", database.ConferenceOptions{})
+	if _, err := db.CreateReservation(carol.ID, conf2.ID, 1, database.ReservationOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(mock.Sent()) != 3 {
+		t.Fatalf("expected no warning yet, got %+v", mock.Sent())
+	}
+	if email := waitFor(t, mock, KindExpiryWarning, "carol@example.com"); !strings.Contains(email.Subject, "Short holds") {
+		t.Fatalf("unexpected warning %+v", email)
+	}
+}
+
+// fakeSMTP accepts one connection on a local port and speaks just enough SMTP to take one
+// message, which it sends on the returned channel
+func fakeSMTP(t *testing.T) (port string, got <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	out := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		reply("220 localhost ready")
+		var transcript strings.Builder
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			transcript.WriteString(line)
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case cmd == "DATA":
+				reply("354 go ahead")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					transcript.WriteString(line)
+				}
+				reply("250 queued")
+			case cmd == "QUIT":
+				reply("221 bye")
+				out <- transcript.String()
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+	_, port, _ = net.SplitHostPort(ln.Addr().String())
+	return port, out
+}
+
+func TestSMTPSenderDeliversPlainTextEmail(t *testing.T) {
+	port, got := fakeSMTP(t)
+	sender := NewSMTP(SMTPConfig{Host: "127.0.0.1", Port: port, From: "Bookings <no-reply@example.com>"})
+	err := sender.Send(context.Background(), Email{
+		Kind: KindBookingConfirmed, To: "alice@example.com", Subject: "Zürich is confirmed", Body: "line one\nline two\n",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	transcript := <-got
+	for _, want := range []string{
+		"MAIL FROM:<no-reply@example.com>", "RCPT TO:<alice@example.com>",
+		"From: Bookings <no-reply@example.com>\r\n", "To: alice@example.com\r\n",
+		"Subject: =?utf-8?q?Z=C3=BCrich_is_confirmed?=\r\n", "\r\nline one\r\nline two\r\n",
+	} {
+		if !strings.Contains(transcript, want) {
+			t.Errorf("transcript is missing %q:\n%s", want, transcript)
+		}
+	}
+
+	// nothing listening: the failure wraps ErrSend
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	_, closed, _ := net.SplitHostPort(ln.Addr().String())
+	ln.Close()
+	err = NewSMTP(SMTPConfig{Host: "127.0.0.1", Port: closed, From: "no-reply@example.com"}).Send(context.Background(), Email{To: "a@example.com"})
+	if !errors.Is(err, ErrSend) {
+		t.Fatalf("expected ErrSend, got %v", err)
+	}
+}
@@ -0,0 +1,275 @@
+package notifications
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"booking-system/database"
+	"booking-system/models"
+)
+
+// Directory looks up what an email is about; database.Store satisfies it
+type Directory interface {
+	GetUser(userID string) (*models.User, error)
+	GetConference(conferenceID string) (*models.Conference, error)
+	GetBooking(id string) *models.Booking
+	GetReservation(reservationID string) (*models.SeatReservation, error)
+}
+
+// Options tunes a Notifier; zero fields take the defaults below
+type Options struct {
+	Workers       int           // emails sent at once; default 2
+	QueueSize     int           // emails waiting for a worker before new ones are dropped; default 256
+	Attempts      int           // tries per email; default 3
+	RetryDelay    time.Duration // wait after the first failed try, doubling each time; default 2s
+	ExpiryWarning time.Duration // how long before a hold lapses to warn; 0 sends no warnings
+}
+
+// Notifier sends an email for each event that concerns a user. Emails are built and sent by
+// worker goroutines; a full queue drops new emails (logged) rather than falling behind the
+// event stream, which would drop events instead.
+type Notifier struct {
+	dir    Directory
+	sender EmailSender
+	opts   Options
+
+	jobs    chan func() (Email, bool)
+	ctx     context.Context
+	cancel  context.CancelFunc
+	workers sync.WaitGroup
+
+	mu       sync.Mutex
+	warnings map[string]*time.Timer // reservation ID -> its pending expiry warning
+	stopped  bool
+}
+
+// New returns a Notifier looking things up in dir and sending through sender
+func New(dir Directory, sender EmailSender, opts Options) *Notifier {
+	if opts.Workers <= 0 {
+		opts.Workers = 2
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 256
+	}
+	if opts.Attempts <= 0 {
+		opts.Attempts = 3
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = 2 * time.Second
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Notifier{
+		dir:      dir,
+		sender:   sender,
+		opts:     opts,
+		jobs:     make(chan func() (Email, bool), opts.QueueSize),
+		ctx:      ctx,
+		cancel:   cancel,
+		warnings: make(map[string]*time.Timer),
+	}
+}
+
+// Start sends emails for events until the returned stop func is called. Stop unsubscribes,
+// cancels pending expiry warnings and waits up to timeout for queued emails to go out.
+func (n *Notifier) Start(events <-chan database.Event, unsubscribe func(), timeout time.Duration) (stop func()) {
+	for i := 0; i < n.opts.Workers; i++ {
+		n.workers.Add(1)
+		go n.work()
+	}
+	dispatched := make(chan struct{})
+	go func() {
+		defer close(dispatched)
+		for ev := range events {
+			n.Handle(ev)
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			unsubscribe()
+			<-dispatched
+			n.mu.Lock()
+			n.stopped = true
+			for id, t := range n.warnings {
+				t.Stop()
+				delete(n.warnings, id)
+			}
+			close(n.jobs)
+			n.mu.Unlock()
+
+			done := make(chan struct{})
+			go func() {
+				n.workers.Wait()
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(timeout):
+				slog.Warn("Gave up waiting for queued emails", "timeout", timeout.String())
+				n.cancel()
+				<-done
+			}
+			n.cancel()
+		})
+	}
+}
+
+// Handle queues the emails ev calls for and keeps expiry warnings in step with holds
+func (n *Notifier) Handle(ev database.Event) {
+	switch ev.Type {
+	case database.OpBookingCreate, database.OpReservationConfirm:
+		n.enqueue(n.bookingEmail(KindBookingConfirmed, ev))
+		n.cancelWarning(ev.ReservationID)
+	case database.OpBookingCancel:
+		n.enqueue(n.bookingEmail(KindCancellation, ev))
+	case database.OpQueueOffer:
+		n.enqueue(n.reservationEmail(KindYourTurn, ev.ReservationID, 0))
+	case database.OpQueueNotify:
+		n.enqueue(n.claimEmail(ev))
+	case database.OpReservationCreate, database.OpQueueClaim, database.OpOfferAccept,
+		database.OpReservationExtend, database.OpReservationRenew:
+		n.scheduleWarning(ev.ReservationID)
+	case database.OpReservationCancel, database.OpReservationExpire:
+		n.cancelWarning(ev.ReservationID)
+	}
+}
+
+// enqueue hands job to the workers unless the queue is full or stopped
+func (n *Notifier) enqueue(job func() (Email, bool)) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.stopped {
+		return
+	}
+	select {
+	case n.jobs <- job:
+	default:
+		slog.Warn("Email queue full; dropping email", "queue_size", n.opts.QueueSize)
+	}
+}
+
+// work builds and sends queued emails, retrying failed sends with a doubling delay
+func (n *Notifier) work() {
+	defer n.workers.Done()
+	for job := range n.jobs {
+		email, ok := job()
+		if !ok {
+			continue
+		}
+		delay := n.opts.RetryDelay
+		for attempt := 1; ; attempt++ {
+			err := n.sender.Send(n.ctx, email)
+			if err == nil {
+				break
+			}
+			if attempt >= n.opts.Attempts || n.ctx.Err() != nil {
+				slog.Error("Send email failed", "kind", email.Kind, "attempts", attempt, "error", err)
+				break
+			}
+			select {
+			case <-time.After(delay):
+			case <-n.ctx.Done():
+			}
+			delay *= 2
+		}
+	}
+}
+
+// render looks up the user and conference of msg and renders it; false skips the email
+func (n *Notifier) render(msg Message, userID, conferenceID string) (Email, bool) {
+	user, err := n.dir.GetUser(userID)
+	if err != nil {
+		return Email{}, false // deleted since; nobody to tell
+	}
+	msg.User = user
+	if msg.Conference, err = n.dir.GetConference(conferenceID); err != nil {
+		return Email{}, false
+	}
+	email, err := Render(msg)
+	if err != nil {
+		slog.Error("Render email failed", "kind", msg.Kind, "error", err)
+		return Email{}, false
+	}
+	return email, true
+}
+
+// bookingEmail is a kind email about the booking ev names
+func (n *Notifier) bookingEmail(kind string, ev database.Event) func() (Email, bool) {
+	return func() (Email, bool) {
+		booking := n.dir.GetBooking(ev.BookingID)
+		if booking == nil {
+			return Email{}, false
+		}
+		cp := *booking
+		return n.render(Message{Kind: kind, Booking: &cp}, cp.UserID, cp.ConferenceID)
+	}
+}
+
+// reservationEmail is a kind email about reservation id, sent only while it still holds seats.
+// A positive within also skips it unless the hold lapses within that long, so a warning
+// that fires after the hold was extended elsewhere stays quiet.
+func (n *Notifier) reservationEmail(kind, id string, within time.Duration) func() (Email, bool) {
+	return func() (Email, bool) {
+		res, err := n.dir.GetReservation(id)
+		if err != nil || res.Status == models.ReservationStatusConfirmed || !time.Now().Before(res.ExpiresAt) {
+			return Email{}, false
+		}
+		if within > 0 && time.Until(res.ExpiresAt) > within+time.Second {
+			return Email{}, false
+		}
+		cp := *res
+		return n.render(Message{Kind: kind, Reservation: &cp}, cp.UserID, cp.ConferenceID)
+	}
+}
+
+// claimEmail tells the head of a queue it may claim; no seats are held for it yet
+func (n *Notifier) claimEmail(ev database.Event) func() (Email, bool) {
+	return func() (Email, bool) {
+		return n.render(Message{Kind: KindYourTurn}, ev.UserID, ev.ConferenceID)
+	}
+}
+
+// scheduleWarning (re)arms the expiry warning of reservation id for ExpiryWarning before it
+// lapses. Holds shorter than that get none.
+func (n *Notifier) scheduleWarning(id string) {
+	if n.opts.ExpiryWarning <= 0 || id == "" {
+		return
+	}
+	res, err := n.dir.GetReservation(id)
+	if err != nil {
+		return
+	}
+	wait := time.Until(res.ExpiresAt) - n.opts.ExpiryWarning
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if t, ok := n.warnings[id]; ok {
+		t.Stop()
+		delete(n.warnings, id)
+	}
+	if n.stopped || wait <= 0 {
+		return
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(wait, func() {
+		n.mu.Lock()
+		if n.warnings[id] == timer { // not re-armed since
+			delete(n.warnings, id)
+		}
+		n.mu.Unlock()
+		n.enqueue(n.reservationEmail(KindExpiryWarning, id, n.opts.ExpiryWarning))
+	})
+	n.warnings[id] = timer // set under n.mu, so the func above sees it
+}
+
+// cancelWarning drops the pending expiry warning of reservation id, if any
+func (n *Notifier) cancelWarning(id string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if t, ok := n.warnings[id]; ok {
+		t.Stop()
+		delete(n.warnings, id)
+	}
+}
@@ -0,0 +1,115 @@
+package notifications
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SMTPTimeout bounds a whole delivery: connecting, the SMTP exchange and the message itself
+const SMTPTimeout = 30 * time.Second
+
+// SMTPConfig says where and as whom an SMTPSender sends
+type SMTPConfig struct {
+	Host     string
+	Port     string // 465 uses TLS from the start; other ports upgrade with STARTTLS when offered
+	Username string // empty skips authentication
+	Password string
+	From     string // e.g. "Bookings <no-reply@example.com>"
+}
+
+// SMTPSender delivers emails through an SMTP server, one connection per email
+type SMTPSender struct {
+	cfg SMTPConfig
+}
+
+var _ EmailSender = (*SMTPSender)(nil)
+
+// NewSMTP returns an SMTPSender for cfg
+func NewSMTP(cfg SMTPConfig) *SMTPSender {
+	return &SMTPSender{cfg: cfg}
+}
+
+// Send delivers email, giving up at SMTPTimeout or when ctx is done
+func (s *SMTPSender) Send(ctx context.Context, email Email) error {
+	ctx, cancel := context.WithTimeout(ctx, SMTPTimeout)
+	defer cancel()
+	if err := s.send(ctx, email); err != nil {
+		return fmt.Errorf("%w to %s: %v", ErrSend, email.To, err)
+	}
+	return nil
+}
+
+func (s *SMTPSender) send(ctx context.Context, email Email) error {
+	from, err := mail.ParseAddress(s.cfg.From)
+	if err != nil {
+		return fmt.Errorf("from address: %v", err)
+	}
+	addr := net.JoinHostPort(s.cfg.Host, s.cfg.Port)
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if s.cfg.Port == "465" {
+		conn = tls.Client(conn, &tls.Config{ServerName: s.cfg.Host})
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, s.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && s.cfg.Port != "465" {
+		if err := client.StartTLS(&tls.Config{ServerName: s.cfg.Host}); err != nil {
+			return err
+		}
+	}
+	if s.cfg.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.Host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := client.Rcpt(email.To); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(s.message(email)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// message renders email with the headers mail clients expect, the subject encoded so it may
+// hold any characters
+func (s *SMTPSender) message(email Email) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", s.cfg.From)
+	fmt.Fprintf(&b, "To: %s\r\n", email.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", email.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(email.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return []byte(b.String())
+}
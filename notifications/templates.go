@@ -0,0 +1,127 @@
+package notifications
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"booking-system/models"
+)
+
+// Message is what an email's templates are rendered from. Booking is set for confirmations
+// and cancellations, Reservation for expiry warnings and queue offers.
+type Message struct {
+	Kind        string
+	User        *models.User
+	Conference  *models.Conference
+	Booking     *models.Booking
+	Reservation *models.SeatReservation
+}
+
+// emailTemplate is the subject and body of one kind of email
+type emailTemplate struct {
+	subject, body *template.Template
+}
+
+var templateFuncs = template.FuncMap{
+	"when":  func(t time.Time) string { return t.UTC().Format("Monday, 2 January 2006 15:04 MST") },
+	"clock": func(t time.Time) string { return t.UTC().Format("15:04:05 MST") },
+	"money": func(amount float64) string { return fmt.Sprintf("%.2f", amount) },
+	"join":  strings.Join,
+}
+
+func mustTemplate(subject, body string) emailTemplate {
+	return emailTemplate{
+		subject: template.Must(template.New("subject").Funcs(templateFuncs).Parse(subject)),
+		body:    template.Must(template.New("body").Funcs(templateFuncs).Parse(body)),
+	}
+}
+
+// conferenceLines is shared by every body: where and when the conference is
+const conferenceLines = `{{.Conference.Name}}
+{{when .Conference.Date}}
+{{.Conference.Location}}`
+
+var templates = map[string]emailTemplate{
+	KindBookingConfirmed: mustTemplate(
+		`Your booking for {{.Conference.Name}} is confirmed`,
+		`Hi {{.User.Name}},
+
+You're going! Your booking is confirmed.
+
+`+conferenceLines+`
+
+Tickets: {{.Booking.TicketsBooked}}{{with .Booking.Tier}} ({{.}}){{end}}
+{{- with .Booking.SeatIDs}}
+Seats: {{join . ", "}}{{end}}
+{{- with .Booking.Attendees}}
+Attendees: {{join . ", "}}{{end}}
+Total paid: {{money .Booking.TotalAmount}}
+Booking reference: {{.Booking.ID}}
+
+Your tickets, with the QR codes to show at the door, are listed under your booking.
+`),
+	KindExpiryWarning: mustTemplate(
+		`Your seats for {{.Conference.Name}} are about to be released`,
+		`Hi {{.User.Name}},
+
+We're holding {{.Reservation.TicketCount}} {{if eq .Reservation.TicketCount 1}}seat{{else}}seats{{end}} for you at:
+
+`+conferenceLines+`
+
+The hold ends at {{clock .Reservation.ExpiresAt}}. Confirm it before then to book the seats, or
+extend it if you need more time; otherwise they go back on sale.
+
+Reservation reference: {{.Reservation.ID}}
+`),
+	KindYourTurn: mustTemplate(
+		`It's your turn: seats for {{.Conference.Name}} are waiting`,
+		`Hi {{.User.Name}},
+
+Good news: you've reached the front of the wait queue for:
+
+`+conferenceLines+`
+{{with .Reservation}}
+{{.TicketCount}} {{if eq .TicketCount 1}}seat is{{else}}seats are{{end}} being held for you until {{clock .ExpiresAt}}. Accept the offer
+before then to keep them; if you don't, they are offered to the next person in line.
+
+Reservation reference: {{.ID}}
+{{- else}}
+Claim your seats now: the turn passes to the next person in line if you don't.
+{{- end}}
+`),
+	KindCancellation: mustTemplate(
+		`Your booking for {{.Conference.Name}} was cancelled`,
+		`Hi {{.User.Name}},
+
+This confirms that your booking was cancelled and its tickets released.
+
+`+conferenceLines+`
+
+Tickets cancelled: {{.Booking.TicketsBooked}}
+Amount: {{money .Booking.TotalAmount}}
+Booking reference: {{.Booking.ID}}
+
+Tickets of this booking are void and won't be admitted at the door.
+`),
+}
+
+// Render fills in the templates for msg.Kind and addresses the email to msg.User
+func Render(msg Message) (Email, error) {
+	tmpl, ok := templates[msg.Kind]
+	if !ok {
+		return Email{}, fmt.Errorf("no email template for %q", msg.Kind)
+	}
+	if msg.User == nil || msg.Conference == nil {
+		return Email{}, fmt.Errorf("%s email needs a user and a conference", msg.Kind)
+	}
+	var subject, body strings.Builder
+	if err := tmpl.subject.Execute(&subject, msg); err != nil {
+		return Email{}, err
+	}
+	if err := tmpl.body.Execute(&body, msg); err != nil {
+		return Email{}, err
+	}
+	return Email{Kind: msg.Kind, To: msg.User.Email, Subject: subject.String(), Body: body.String()}, nil
+}
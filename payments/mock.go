@@ -0,0 +1,117 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Mock is an in-memory Provider for tests and demos. Intents stay in requires_payment_method
+// until a test settles them; nothing is ever charged. Webhooks are signed like Stripe's, with
+// WebhookSecret.
+type Mock struct {
+	// FailWith, when set, is returned (wrapped in ErrProvider) by every call
+	FailWith      error
+	WebhookSecret string
+
+	mu      sync.Mutex
+	intents map[string]*mockIntent
+	byRes   map[string]string // Charge.key -> intent ID
+}
+
+type mockIntent struct {
+	Intent
+	Charge Charge
+}
+
+// NewMock returns an empty mock provider
+func NewMock() *Mock {
+	return &Mock{intents: make(map[string]*mockIntent), byRes: make(map[string]string)}
+}
+
+var _ Provider = (*Mock)(nil)
+
+// CreateIntent records charge and returns a fake intent
+func (m *Mock) CreateIntent(_ context.Context, charge Charge) (*Intent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailWith != nil {
+		return nil, fmt.Errorf("%w: %w", ErrProvider, m.FailWith)
+	}
+	if id, ok := m.byRes[charge.key()]; ok {
+		cp := m.intents[id].Intent
+		return &cp, nil
+	}
+	id := fmt.Sprintf("pi_mock_%d", len(m.intents)+1)
+	in := &mockIntent{
+		Intent: Intent{ID: id, ClientSecret: id + "_secret", Status: "requires_payment_method"},
+		Charge: charge,
+	}
+	m.intents[id] = in
+	m.byRes[charge.key()] = id
+	cp := in.Intent
+	return &cp, nil
+}
+
+// CancelIntent marks an intent canceled
+func (m *Mock) CancelIntent(_ context.Context, intentID string) error {
+	return m.setStatus(intentID, "canceled")
+}
+
+// Refund marks an intent refunded
+func (m *Mock) Refund(_ context.Context, intentID string) error {
+	return m.setStatus(intentID, "refunded")
+}
+
+func (m *Mock) setStatus(intentID, status string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.FailWith != nil {
+		return fmt.Errorf("%w: %w", ErrProvider, m.FailWith)
+	}
+	in, ok := m.intents[intentID]
+	if !ok {
+		return fmt.Errorf("%w: no such payment intent: %s", ErrProvider, intentID)
+	}
+	in.Status = status
+	return nil
+}
+
+// ParseWebhook verifies payload like Stripe.ParseWebhook does
+func (m *Mock) ParseWebhook(payload []byte, header http.Header) (*Event, error) {
+	return parseSignedEvent(payload, header.Get(SignatureHeader), m.WebhookSecret, time.Now())
+}
+
+// Webhook builds a signed delivery of eventType for an intent created by the mock, as the
+// provider would send it
+func (m *Mock) Webhook(eventID, eventType, intentID string) (payload []byte, signature string) {
+	m.mu.Lock()
+	var metadata map[string]string
+	if in, ok := m.intents[intentID]; ok {
+		metadata = map[string]string{"reservation_id": in.Charge.ReservationID, "user_id": in.Charge.UserID}
+		if in.Charge.CartID != "" {
+			metadata["cart_id"] = in.Charge.CartID
+		}
+	}
+	m.mu.Unlock()
+	payload, _ = json.Marshal(map[string]interface{}{
+		"id":   eventID,
+		"type": eventType,
+		"data": map[string]interface{}{"object": map[string]interface{}{"id": intentID, "metadata": metadata}},
+	})
+	return payload, SignatureFor(payload, m.WebhookSecret, time.Now())
+}
+
+// Status reports an intent's status and charge, ok false if it was never created
+func (m *Mock) Status(intentID string) (status string, charge Charge, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	in, ok := m.intents[intentID]
+	if !ok {
+		return "", Charge{}, false
+	}
+	return in.Status, in.Charge, true
+}
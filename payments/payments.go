@@ -0,0 +1,55 @@
+// Package payments collects money for reservations through an external provider. A hold gets a
+// payment intent when it is made; the booking is only confirmed once the provider reports the
+// intent paid, so the provider, not the client, decides when seats are sold.
+package payments
+
+import (
+	"context"
+	"errors"
+	"math"
+)
+
+// ErrProvider wraps every failure reported by, or talking to, the payment provider
+var ErrProvider = errors.New("payment provider error")
+
+// Charge describes what a payment intent collects
+type Charge struct {
+	Amount        float64 // in major units, e.g. dollars
+	Currency      string  // ISO code, lower case
+	ReservationID string
+	CartID        string // set instead of ReservationID when the payment covers a whole cart
+	UserID        string
+}
+
+// key identifies what a charge pays for, so creating its intent twice returns the same one
+func (c Charge) key() string {
+	if c.CartID != "" {
+		return "cart-" + c.CartID
+	}
+	return "reservation-" + c.ReservationID
+}
+
+// Intent is a provider-side payment the client completes with ClientSecret
+type Intent struct {
+	ID           string `json:"id"`
+	ClientSecret string `json:"client_secret"`
+	Status       string `json:"status"`
+}
+
+// Provider creates and settles payment intents, and verifies the webhooks reporting what became
+// of them
+type Provider interface {
+	WebhookParser
+	// CreateIntent starts collecting charge; calling it again for the same reservation
+	// returns the same intent
+	CreateIntent(ctx context.Context, charge Charge) (*Intent, error)
+	// CancelIntent abandons an unpaid intent, e.g. when its hold is cancelled
+	CancelIntent(ctx context.Context, intentID string) error
+	// Refund returns the money of a paid intent whose seats could not be booked
+	Refund(ctx context.Context, intentID string) error
+}
+
+// minorUnits converts an amount to the integer minor units (cents) providers charge in
+func minorUnits(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
@@ -0,0 +1,110 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StripeAPI is the base URL of Stripe's REST API
+const StripeAPI = "https://api.stripe.com"
+
+// Stripe is a Provider backed by Stripe PaymentIntents. It talks to the REST API directly, so
+// it needs nothing beyond a secret key.
+type Stripe struct {
+	BaseURL string       // StripeAPI unless pointed at a test server
+	Client  *http.Client // defaults to a client with a 10s timeout
+
+	secretKey     string
+	webhookSecret string
+}
+
+var _ Provider = (*Stripe)(nil)
+
+// NewStripe returns a provider authenticating with secretKey (sk_live_... or sk_test_...) and
+// accepting webhooks signed with webhookSecret (whsec_...)
+func NewStripe(secretKey, webhookSecret string) *Stripe {
+	return &Stripe{
+		BaseURL:       StripeAPI,
+		Client:        &http.Client{Timeout: 10 * time.Second},
+		secretKey:     secretKey,
+		webhookSecret: webhookSecret,
+	}
+}
+
+// CreateIntent creates a PaymentIntent tagged with the reservation (or cart) and user. That ID
+// is the idempotency key, so a retried request returns the intent Stripe already made.
+func (s *Stripe) CreateIntent(ctx context.Context, charge Charge) (*Intent, error) {
+	form := url.Values{
+		"amount":                             {strconv.FormatInt(minorUnits(charge.Amount), 10)},
+		"currency":                           {charge.Currency},
+		"automatic_payment_methods[enabled]": {"true"},
+		"metadata[reservation_id]":           {charge.ReservationID},
+		"metadata[user_id]":                  {charge.UserID},
+	}
+	if charge.CartID != "" {
+		form.Set("metadata[cart_id]", charge.CartID)
+	}
+	var intent Intent
+	if err := s.post(ctx, "/v1/payment_intents", form, charge.key(), &intent); err != nil {
+		return nil, err
+	}
+	return &intent, nil
+}
+
+// CancelIntent cancels an unpaid PaymentIntent
+func (s *Stripe) CancelIntent(ctx context.Context, intentID string) error {
+	return s.post(ctx, "/v1/payment_intents/"+url.PathEscape(intentID)+"/cancel", url.Values{}, "", nil)
+}
+
+// Refund refunds a paid PaymentIntent in full
+func (s *Stripe) Refund(ctx context.Context, intentID string) error {
+	return s.post(ctx, "/v1/refunds", url.Values{"payment_intent": {intentID}}, "refund-"+intentID, nil)
+}
+
+// post sends a form-encoded request and decodes a successful response into out (if non-nil)
+func (s *Stripe) post(ctx context.Context, path string, form url.Values, idempotencyKey string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.BaseURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrProvider, err)
+	}
+	req.SetBasicAuth(s.secretKey, "")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrProvider, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("%w: read response: %w", ErrProvider, err)
+	}
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Error.Message != "" {
+			return fmt.Errorf("%w: stripe %d: %s", ErrProvider, resp.StatusCode, apiErr.Error.Message)
+		}
+		return fmt.Errorf("%w: stripe %d", ErrProvider, resp.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("%w: decode response: %w", ErrProvider, err)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStripeCreatesIntentsWithTheReservationAsIdempotencyKey(t *testing.T) {
+	var got *http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		got = r
+		w.Write([]byte(`{"id":"pi_123","client_secret":"pi_123_secret_abc","status":"requires_payment_method"}`))
+	}))
+	defer srv.Close()
+	s := NewStripe("sk_test_key", "whsec_test")
+	s.BaseURL = srv.URL
+
+	intent, err := s.CreateIntent(context.Background(), Charge{Amount: 299.99, Currency: "usd", ReservationID: "res-1", UserID: "user-1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if intent.ID != "pi_123" || intent.ClientSecret != "pi_123_secret_abc" {
+		t.Fatalf("unexpected intent %+v", intent)
+	}
+	if key, _, _ := got.BasicAuth(); key != "sk_test_key" || got.URL.Path != "/v1/payment_intents" {
+		t.Fatalf("expected an authenticated call to /v1/payment_intents, got key %q path %s", key, got.URL.Path)
+	}
+	if got.Header.Get("Idempotency-Key") != "reservation-res-1" {
+		t.Fatalf("expected the reservation as idempotency key, got %q", got.Header.Get("Idempotency-Key"))
+	}
+	if got.PostForm.Get("amount") != "29999" || got.PostForm.Get("metadata[reservation_id]") != "res-1" {
+		t.Fatalf("expected the amount in cents and the reservation in metadata, got %v", got.PostForm)
+	}
+}
+
+func TestStripeErrorsWrapErrProvider(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusPaymentRequired)
+		w.Write([]byte(`{"error":{"message":"Your card was declined."}}`))
+	}))
+	defer srv.Close()
+	s := NewStripe("sk_test_key", "whsec_test")
+	s.BaseURL = srv.URL
+
+	err := s.Refund(context.Background(), "pi_123")
+	if !errors.Is(err, ErrProvider) || err.Error() != "payment provider error: stripe 402: Your card was declined." {
+		t.Fatalf("expected a wrapped provider error with Stripe's message, got %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package payments
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Payment event types, named as Stripe names them
+const (
+	EventPaymentSucceeded = "payment_intent.succeeded"
+	EventPaymentFailed    = "payment_intent.payment_failed"
+	EventPaymentCanceled  = "payment_intent.canceled"
+)
+
+// SignatureTolerance is how far a webhook's signed timestamp may be from now, limiting how long
+// a captured delivery can be replayed
+const SignatureTolerance = 5 * time.Minute
+
+// SignatureHeader carries a webhook's timestamp and signatures
+const SignatureHeader = "Stripe-Signature"
+
+// ErrBadSignature is returned for a webhook that isn't signed with the webhook secret, or was
+// signed too long ago
+var ErrBadSignature = errors.New("invalid webhook signature")
+
+// Event is a payment webhook delivery. Deliveries may repeat and arrive out of order, so
+// handlers must act on the intent's current state rather than on the order of events.
+type Event struct {
+	ID            string
+	Type          string
+	IntentID      string
+	ReservationID string // from the intent's metadata; empty for intents this app didn't create
+	CartID        string // likewise, for an intent paying for a whole cart
+}
+
+// WebhookParser verifies and decodes payment webhooks
+type WebhookParser interface {
+	ParseWebhook(payload []byte, header http.Header) (*Event, error)
+}
+
+// ParseWebhook checks the Stripe-Signature header against the webhook secret and decodes the
+// event
+func (s *Stripe) ParseWebhook(payload []byte, header http.Header) (*Event, error) {
+	return parseSignedEvent(payload, header.Get(SignatureHeader), s.webhookSecret, time.Now())
+}
+
+// parseSignedEvent verifies a Stripe-style signature ("t=<unix>,v1=<hex hmac>") over
+// "<t>.<payload>" and decodes the payload as a payment intent event
+func parseSignedEvent(payload []byte, signature, secret string, now time.Time) (*Event, error) {
+	if secret == "" {
+		return nil, fmt.Errorf("%w: no webhook secret configured", ErrBadSignature)
+	}
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(signature, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return nil, fmt.Errorf("%w: malformed %s header", ErrBadSignature, SignatureHeader)
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > SignatureTolerance || age < -SignatureTolerance {
+		return nil, fmt.Errorf("%w: timestamp outside the %s tolerance", ErrBadSignature, SignatureTolerance)
+	}
+	expected := sign(payload, secret, timestamp)
+	valid := false
+	for _, sig := range signatures {
+		if hmac.Equal([]byte(sig), []byte(expected)) {
+			valid = true
+		}
+	}
+	if !valid {
+		return nil, ErrBadSignature
+	}
+
+	var raw struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+		Data struct {
+			Object struct {
+				ID       string            `json:"id"`
+				Metadata map[string]string `json:"metadata"`
+			} `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		return nil, fmt.Errorf("decode webhook: %w", err)
+	}
+	return &Event{
+		ID:            raw.ID,
+		Type:          raw.Type,
+		IntentID:      raw.Data.Object.ID,
+		ReservationID: raw.Data.Object.Metadata["reservation_id"],
+		CartID:        raw.Data.Object.Metadata["cart_id"],
+	}, nil
+}
+
+// sign is the hex HMAC-SHA256 of "<timestamp>.<payload>" under secret
+func sign(payload []byte, secret, timestamp string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignatureFor builds the Stripe-Signature header value for payload signed at t, for tests and
+// tools that send webhooks
+func SignatureFor(payload []byte, secret string, t time.Time) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + timestamp + ",v1=" + sign(payload, secret, timestamp)
+}
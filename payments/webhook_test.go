@@ -0,0 +1,35 @@
+package payments
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSignedEventsVerifyAndDecode(t *testing.T) {
+	payload := []byte(`{"id":"evt_1","type":"payment_intent.succeeded","data":{"object":{"id":"pi_1","metadata":{"reservation_id":"res-1"}}}}`)
+	now := time.Now()
+
+	event, err := parseSignedEvent(payload, SignatureFor(payload, "whsec_test", now), "whsec_test", now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if event.ID != "evt_1" || event.Type != EventPaymentSucceeded || event.IntentID != "pi_1" || event.ReservationID != "res-1" {
+		t.Fatalf("unexpected event %+v", event)
+	}
+
+	cases := map[string]struct {
+		payload   []byte
+		signature string
+	}{
+		"wrong secret":  {payload, SignatureFor(payload, "whsec_other", now)},
+		"tampered body": {[]byte(`{"id":"evt_2"}`), SignatureFor(payload, "whsec_test", now)},
+		"stale":         {payload, SignatureFor(payload, "whsec_test", now.Add(-SignatureTolerance-time.Minute))},
+		"no signature":  {payload, ""},
+	}
+	for name, tc := range cases {
+		if _, err := parseSignedEvent(tc.payload, tc.signature, "whsec_test", now); !errors.Is(err, ErrBadSignature) {
+			t.Errorf("%s: expected ErrBadSignature, got %v", name, err)
+		}
+	}
+}
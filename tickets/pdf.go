@@ -0,0 +1,88 @@
+package tickets
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Page layout of a PDF ticket, in points on an A6 page
+const (
+	pageWidth  = 298
+	pageHeight = 420
+	qrSide     = 200
+)
+
+// PDF renders a one-page ticket: the lines of text at the top and the QR code for payload
+// below them. The code is drawn as vector squares, so it prints sharp at any size.
+func PDF(payload string, lines []string) ([]byte, error) {
+	q, err := EncodeQR([]byte(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	var content bytes.Buffer
+	y := pageHeight - 40
+	for i, line := range lines {
+		size := 11
+		if i == 0 {
+			size = 16
+		}
+		fmt.Fprintf(&content, "BT /F1 %d Tf 24 %d Td (%s) Tj ET\n", size, y, pdfEscape(line))
+		y -= size + 8
+	}
+
+	module := float64(qrSide) / float64(q.Size)
+	left, top := float64(pageWidth-qrSide)/2, float64(y-16)
+	content.WriteString("0 g\n")
+	for row := 0; row < q.Size; row++ {
+		for col := 0; col < q.Size; col++ {
+			if q.Dark(col, row) {
+				fmt.Fprintf(&content, "%.2f %.2f %.2f %.2f re\n",
+					left+float64(col)*module, top-float64(row+1)*module, module, module)
+			}
+		}
+	}
+	content.WriteString("f\n")
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 4 0 R >> >> /Contents 5 0 R >>", pageWidth, pageHeight),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()),
+	}
+
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return out.Bytes(), nil
+}
+
+// pdfEscape makes s safe inside a PDF string literal. Helvetica here only covers ASCII, so
+// anything else is shown as a question mark.
+func pdfEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20 || r > 0x7E:
+			b.WriteByte('?')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
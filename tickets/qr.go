@@ -0,0 +1,81 @@
+package tickets
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// QR codes are encoded at error correction level M (15% of the code can be lost), in the
+// smallest version that fits.
+const qrLevel = qrcode.Medium
+
+// ErrTooLong is returned for data that doesn't fit the largest QR version
+var ErrTooLong = errors.New("qr: data too long")
+
+// ErrNoData is returned for empty data, which QR codes can't hold
+var ErrNoData = errors.New("qr: no data")
+
+// QR is an encoded QR code: a square of dark (true) and light modules
+type QR struct {
+	Version int
+	Size    int
+	modules [][]bool
+}
+
+// Dark reports whether the module at column x, row y is dark
+func (q *QR) Dark(x, y int) bool {
+	return q.modules[y][x]
+}
+
+// EncodeQR encodes data as a QR code
+func EncodeQR(data []byte) (*QR, error) {
+	if len(data) == 0 {
+		return nil, ErrNoData
+	}
+	code, err := qrcode.New(string(data), qrLevel)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTooLong, err)
+	}
+	code.DisableBorder = true // Image adds the quiet zone
+	modules := code.Bitmap()
+	return &QR{Version: code.VersionNumber, Size: len(modules), modules: modules}, nil
+}
+
+// Image renders the code with scale pixels per module and the four-module quiet zone
+// scanners need around it
+func (q *QR) Image(scale int) image.Image {
+	const quiet = 4
+	side := (q.Size + 2*quiet) * scale
+	img := image.NewGray(image.Rect(0, 0, side, side))
+	for i := range img.Pix {
+		img.Pix[i] = 0xFF
+	}
+	for y := 0; y < q.Size; y++ {
+		for x := 0; x < q.Size; x++ {
+			if !q.modules[y][x] {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					img.SetGray((x+quiet)*scale+dx, (y+quiet)*scale+dy, color.Gray{})
+				}
+			}
+		}
+	}
+	return img
+}
+
+// PNG encodes Image(scale) as a PNG
+func (q *QR) PNG(scale int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, q.Image(scale)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
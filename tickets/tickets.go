@@ -0,0 +1,57 @@
+// Package tickets makes the scannable part of a ticket: a signed payload naming the ticket,
+// the QR code that carries it, and PNG and PDF renderings to download. Only the ticket ID is
+// signed; whether the ticket is still valid is looked up when it is scanned.
+package tickets
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// payloadPrefix marks a ticket payload and its format version
+const payloadPrefix = "BKT1"
+
+// ErrBadSignature is returned for a payload that wasn't signed with this key or isn't a ticket
+var ErrBadSignature = errors.New("ticket signature is not valid")
+
+// Signer signs ticket IDs into QR payloads and verifies them at the door
+type Signer struct {
+	key []byte
+}
+
+// NewSigner returns a Signer using key; every instance checking tickets must share it
+func NewSigner(key []byte) *Signer {
+	return &Signer{key: key}
+}
+
+// mac is the truncated HMAC-SHA256 of ticketID, base64url encoded
+func (s *Signer) mac(ticketID string) string {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(payloadPrefix + "." + ticketID))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:16])
+}
+
+// Sign returns the payload a ticket's QR code carries: BKT1.<ticket id>.<signature>
+func (s *Signer) Sign(ticketID string) string {
+	return payloadPrefix + "." + ticketID + "." + s.mac(ticketID)
+}
+
+// Verify returns the ticket ID a payload was signed for, or ErrBadSignature
+func (s *Signer) Verify(payload string) (string, error) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(payload), payloadPrefix+".")
+	if !ok {
+		return "", ErrBadSignature
+	}
+	i := strings.LastIndexByte(rest, '.')
+	if i <= 0 {
+		return "", ErrBadSignature
+	}
+	ticketID, sig := rest[:i], rest[i+1:]
+	if !hmac.Equal([]byte(sig), []byte(s.mac(ticketID))) {
+		return "", ErrBadSignature
+	}
+	return ticketID, nil
+}
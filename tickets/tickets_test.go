@@ -0,0 +1,95 @@
+package tickets
+
+import (
+	"bytes"
+	"errors"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestSignedPayloadsVerify(t *testing.T) {
+	signer := NewSigner([]byte("ticket-key"))
+	payload := signer.Sign("bk-1-2")
+	if !strings.HasPrefix(payload, "BKT1.bk-1-2.") {
+		t.Fatalf("unexpected payload %q", payload)
+	}
+	if id, err := signer.Verify(payload); err != nil || id != "bk-1-2" {
+		t.Fatalf("expected bk-1-2, got %q, %v", id, err)
+	}
+
+	for name, p := range map[string]string{
+		"other key":      NewSigner([]byte("other-key")).Sign("bk-1-2"),
+		"other ticket":   strings.Replace(payload, "bk-1-2", "bk-1-3", 1),
+		"no signature":   "BKT1.bk-1-2",
+		"not a ticket":   "hello",
+		"empty":          "",
+		"wrong version":  strings.Replace(payload, "BKT1", "BKT2", 1),
+		"truncated sig":  payload[:len(payload)-2],
+		"missing ticket": "BKT1." + payload[strings.LastIndexByte(payload, '.')+1:],
+	} {
+		if _, err := signer.Verify(p); !errors.Is(err, ErrBadSignature) {
+			t.Errorf("%s: expected ErrBadSignature, got %v", name, err)
+		}
+	}
+}
+
+func TestQRCodesHaveTheirFunctionPatterns(t *testing.T) {
+	inputs := []string{"A", NewSigner([]byte("ticket-key")).Sign("bk-1-1"), strings.Repeat("z", 500)}
+	for _, data := range inputs {
+		q, err := EncodeQR([]byte(data))
+		if err != nil {
+			t.Fatalf("%q: %v", data, err)
+		}
+		if q.Size != 17+4*q.Version {
+			t.Fatalf("%q: size %d for version %d", data, q.Size, q.Version)
+		}
+		for _, c := range [][2]int{{3, 3}, {q.Size - 4, 3}, {3, q.Size - 4}} {
+			if !q.Dark(c[0], c[1]) || q.Dark(c[0]+2, c[1]) || !q.Dark(c[0]+3, c[1]) {
+				t.Fatalf("%q: no finder pattern around %v", data, c)
+			}
+		}
+		for i := 8; i < q.Size-8; i++ {
+			if q.Dark(i, 6) != (i%2 == 0) || q.Dark(6, i) != (i%2 == 0) {
+				t.Fatalf("%q: broken timing pattern at %d", data, i)
+			}
+		}
+		if !q.Dark(8, q.Size-8) {
+			t.Fatalf("%q: missing dark module", data)
+		}
+	}
+	if _, err := EncodeQR(make([]byte, 4000)); !errors.Is(err, ErrTooLong) {
+		t.Fatalf("expected ErrTooLong, got %v", err)
+	}
+	if _, err := EncodeQR(nil); !errors.Is(err, ErrNoData) {
+		t.Fatalf("expected ErrNoData, got %v", err)
+	}
+}
+
+func TestTicketRenderings(t *testing.T) {
+	q, err := EncodeQR([]byte("BKT1.bk-1-1.sig"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := q.PNG(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if side := (q.Size + 8) * 4; img.Bounds().Dx() != side || img.Bounds().Dy() != side {
+		t.Fatalf("expected a %dpx square, got %v", side, img.Bounds())
+	}
+
+	pdf, err := PDF("BKT1.bk-1-1.sig", []string{"GopherCon (2026)", "Seat A-1-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"%PDF-1.4", "GopherCon \\(2026\\)", "Seat A-1-1", " re\n", "%%EOF"} {
+		if !bytes.Contains(pdf, []byte(want)) {
+			t.Errorf("PDF is missing %q", want)
+		}
+	}
+}
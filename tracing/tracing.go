@@ -0,0 +1,189 @@
+// Package tracing records OpenTelemetry-style spans and exports them to an OTLP/HTTP collector
+// (see OTLPExporter). It covers what the server needs, nested spans carried in a context, W3C
+// traceparent propagation, attributes and sampling, without the OpenTelemetry SDK.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	mrand "math/rand/v2"
+	"strings"
+	"time"
+)
+
+// TraceParentHeader is the W3C Trace Context header naming the caller's span
+const TraceParentHeader = "traceparent"
+
+// SpanKind says what side of a call a span describes; the values match OTLP's
+type SpanKind int
+
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+// Exporter receives spans as they end. It must not block; a slow exporter drops spans.
+type Exporter interface {
+	Export(span *Span)
+}
+
+// Tracer starts spans and hands the sampled ones to its exporter. A nil *Tracer is valid and
+// records nothing, so callers don't need to check whether tracing is configured.
+type Tracer struct {
+	exporter      Exporter
+	samplePercent int
+}
+
+// New returns a tracer exporting to exporter. samplePercent (0-100) of new traces are
+// recorded; spans join their parent's decision, so a trace is recorded whole or not at all.
+func New(exporter Exporter, samplePercent int) *Tracer {
+	return &Tracer{exporter: exporter, samplePercent: min(max(samplePercent, 0), 100)}
+}
+
+// Attr is one span attribute; Value is a string, bool, int, int64 or float64
+type Attr struct {
+	Key   string
+	Value interface{}
+}
+
+// Span is one timed operation. It is not safe for concurrent use; all methods accept nil.
+type Span struct {
+	Name     string
+	Kind     SpanKind
+	TraceID  [16]byte
+	SpanID   [8]byte
+	ParentID [8]byte // zero for a root span
+	Start    time.Time
+	End      time.Time
+	Attrs    []Attr
+	Error    string // set by RecordError; marks the span's status as an error
+
+	tracer  *Tracer
+	sampled bool
+	ended   bool
+}
+
+type spanKey struct{}
+
+// remoteParent is a caller's span, known only by its IDs from a traceparent header
+type remoteParent struct {
+	traceID [16]byte
+	spanID  [8]byte
+	sampled bool
+}
+
+type remoteKey struct{}
+
+// SpanFromContext returns the span ctx carries, nil if none
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// ContextWithTraceParent returns ctx with the caller's span from a W3C traceparent header
+// value as the parent of the next span started; a missing or malformed value leaves ctx as is
+func ContextWithTraceParent(ctx context.Context, header string) context.Context {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return ctx
+	}
+	var p remoteParent
+	flags, err1 := hex.DecodeString(parts[3])
+	_, err2 := hex.Decode(p.traceID[:], []byte(parts[1]))
+	_, err3 := hex.Decode(p.spanID[:], []byte(parts[2]))
+	if err1 != nil || err2 != nil || err3 != nil || p.traceID == [16]byte{} || p.spanID == [8]byte{} {
+		return ctx
+	}
+	p.sampled = flags[0]&1 == 1
+	return context.WithValue(ctx, remoteKey{}, p)
+}
+
+// Start begins a span named name as a child of the span in ctx (or of a remote parent from
+// ContextWithTraceParent) and returns ctx carrying it. End the span when the work is done.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	span := &Span{Name: name, Kind: kind, Start: time.Now(), tracer: t}
+	rand.Read(span.SpanID[:])
+	if parent := SpanFromContext(ctx); parent != nil {
+		span.TraceID, span.ParentID, span.sampled = parent.TraceID, parent.SpanID, parent.sampled
+	} else if remote, ok := ctx.Value(remoteKey{}).(remoteParent); ok {
+		span.TraceID, span.ParentID, span.sampled = remote.traceID, remote.spanID, remote.sampled
+	} else {
+		rand.Read(span.TraceID[:])
+		span.sampled = t.sample()
+	}
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// Record exports a root span that has already happened, for timings measured where no
+// context is at hand
+func (t *Tracer) Record(name string, start, end time.Time, attrs ...Attr) {
+	if t == nil || !t.sample() {
+		return
+	}
+	span := &Span{Name: name, Kind: KindInternal, Start: start, Attrs: attrs, tracer: t, sampled: true}
+	rand.Read(span.TraceID[:])
+	rand.Read(span.SpanID[:])
+	span.finish(end)
+}
+
+func (t *Tracer) sample() bool {
+	return t.samplePercent >= 100 || (t.samplePercent > 0 && mrand.IntN(100) < t.samplePercent)
+}
+
+// SetAttr adds an attribute to the span
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s != nil {
+		s.Attrs = append(s.Attrs, Attr{Key: key, Value: value})
+	}
+}
+
+// RecordError marks the span as failed with err; a nil err changes nothing
+func (s *Span) RecordError(err error) {
+	if s != nil && err != nil {
+		s.Error = err.Error()
+	}
+}
+
+// Finish ends the span, recording err if there is one. Only the first call counts.
+func (s *Span) Finish(err error) {
+	if s == nil {
+		return
+	}
+	s.RecordError(err)
+	s.finish(time.Now())
+}
+
+func (s *Span) finish(end time.Time) {
+	if s.ended {
+		return
+	}
+	s.ended = true
+	s.End = end
+	if s.sampled && s.tracer.exporter != nil {
+		s.tracer.exporter.Export(s)
+	}
+}
+
+// TraceParent formats the span as a W3C traceparent header value, for passing it downstream
+// or back to the client
+func (s *Span) TraceParent() string {
+	if s == nil {
+		return ""
+	}
+	flags := 0
+	if s.sampled {
+		flags = 1
+	}
+	return fmt.Sprintf("00-%s-%s-%02x", hex.EncodeToString(s.TraceID[:]), hex.EncodeToString(s.SpanID[:]), flags)
+}
+
+// Sampled reports whether the span will be exported
+func (s *Span) Sampled() bool {
+	return s != nil && s.sampled
+}
@@ -0,0 +1,114 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// collector keeps exported spans in memory
+type collector struct{ spans []*Span }
+
+func (c *collector) Export(span *Span) { c.spans = append(c.spans, span) }
+
+func TestSpansContinueTheCallersTraceAndNest(t *testing.T) {
+	spans := &collector{}
+	tracer := New(spans, 100)
+	ctx := ContextWithTraceParent(context.Background(), "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	ctx, server := tracer.Start(ctx, "GET /conferences", KindServer)
+	_, child := tracer.Start(ctx, "store.GetAllConferences", KindInternal)
+	child.Finish(errors.New("boom"))
+	server.Finish(nil)
+
+	if len(spans.spans) != 2 {
+		t.Fatalf("expected 2 spans exported, got %d", len(spans.spans))
+	}
+	if got := server.TraceParent()[:36]; got != "00-4bf92f3577b34da6a3ce929d0e0e4736-" {
+		t.Fatalf("expected the caller's trace to continue, got %s", server.TraceParent())
+	}
+	if server.ParentID != [8]byte{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7} {
+		t.Fatalf("expected the caller's span as parent, got %x", server.ParentID)
+	}
+	if child.TraceID != server.TraceID || child.ParentID != server.SpanID {
+		t.Fatal("expected the store span to be a child of the server span")
+	}
+	if child.Error != "boom" || server.Error != "" {
+		t.Fatalf("expected only the child to have failed, got %q and %q", child.Error, server.Error)
+	}
+}
+
+func TestUnsampledTracesAreNotExported(t *testing.T) {
+	spans := &collector{}
+	tracer := New(spans, 100)
+	// The caller decided not to sample; its children follow
+	ctx := ContextWithTraceParent(context.Background(), "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	_, span := tracer.Start(ctx, "GET /health", KindServer)
+	span.Finish(nil)
+
+	_, dropped := New(spans, 0).Start(context.Background(), "GET /health", KindServer)
+	dropped.Finish(nil)
+
+	if len(spans.spans) != 0 {
+		t.Fatalf("expected nothing exported, got %d spans", len(spans.spans))
+	}
+
+	var none *Tracer
+	_, nothing := none.Start(context.Background(), "noop", KindInternal)
+	nothing.SetAttr("k", "v")
+	nothing.Finish(nil)
+}
+
+func TestMalformedTraceParentStartsANewTrace(t *testing.T) {
+	tracer := New(&collector{}, 100)
+	ctx := ContextWithTraceParent(context.Background(), "00-00000000000000000000000000000000-00f067aa0ba902b7-01")
+	_, span := tracer.Start(ctx, "GET /", KindServer)
+	if span.ParentID != [8]byte{} || span.TraceID == [16]byte{} {
+		t.Fatalf("expected a fresh root span, got trace %x parent %x", span.TraceID, span.ParentID)
+	}
+}
+
+func TestOTLPExporterPostsJSONBatches(t *testing.T) {
+	got := make(chan otlpRequest, 1)
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		var body otlpRequest
+		json.NewDecoder(r.Body).Decode(&body)
+		got <- body
+	}))
+	defer srv.Close()
+
+	exporter := NewOTLPExporter(srv.URL+"/v1/traces", "booking-test", map[string]string{"Authorization": "Bearer t"})
+	tracer := New(exporter, 100)
+	start := time.Unix(1700000000, 0)
+	tracer.Record("db.write_lock", start, start.Add(time.Millisecond), Attr{Key: "method", Value: "CreateBooking"}, Attr{Key: "wait_ms", Value: 0.5})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := exporter.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	body := <-got
+	if auth != "Bearer t" {
+		t.Fatalf("expected the configured headers to be sent, got %q", auth)
+	}
+	rs := body.ResourceSpans[0]
+	if rs.Resource.Attributes[0].Value["stringValue"] != "booking-test" {
+		t.Fatalf("expected service.name booking-test, got %+v", rs.Resource.Attributes)
+	}
+	span := rs.ScopeSpans[0].Spans[0]
+	if span.Name != "db.write_lock" || span.Start != "1700000000000000000" || span.End != "1700000000001000000" {
+		t.Fatalf("unexpected span %+v", span)
+	}
+	if len(span.TraceID) != 32 || len(span.SpanID) != 16 || span.ParentSpanID != "" {
+		t.Fatalf("expected hex IDs and no parent, got %+v", span)
+	}
+	if span.Attributes[0].Value["stringValue"] != "CreateBooking" || span.Attributes[1].Value["doubleValue"] != 0.5 {
+		t.Fatalf("unexpected attributes %+v", span.Attributes)
+	}
+}
@@ -0,0 +1,451 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"booking-system/database"
+	"booking-system/models"
+
+	"github.com/google/uuid"
+)
+
+// DeliveryLogSize is how many attempts each subscription's delivery log keeps
+const DeliveryLogSize = 100
+
+// Directory looks up what a payload carries; database.Store satisfies it
+type Directory interface {
+	GetBooking(id string) *models.Booking
+	GetReservation(reservationID string) (*models.SeatReservation, error)
+}
+
+// Options tunes a Dispatcher; zero fields take the defaults below
+type Options struct {
+	Workers    int           // deliveries in flight at once; default 4
+	QueueSize  int           // deliveries waiting for a worker before new ones are dropped; default 1024
+	Attempts   int           // tries per delivery; default 5
+	RetryDelay time.Duration // wait after the first failed try, doubling each time; default 5s
+	Timeout    time.Duration // how long one attempt may take; default 10s
+	Client     *http.Client  // sends the requests; default one with Timeout that doesn't follow redirects
+	// AllowPrivate lets the default client deliver to loopback, private and link-local
+	// addresses, for tests and local development. Otherwise they are refused, so a
+	// subscription can't turn the delivery log into a probe of the server's own network.
+	AllowPrivate bool
+}
+
+// Dispatcher keeps the webhook subscriptions and delivers events to them. Deliveries are
+// sent by worker goroutines; a failed one is put back on the queue after its backoff, so
+// slow or broken endpoints never hold up the others.
+type Dispatcher struct {
+	dir    Directory
+	opts   Options
+	client *http.Client
+
+	jobs    chan *job
+	ctx     context.Context
+	cancel  context.CancelFunc
+	workers sync.WaitGroup
+
+	mu      sync.Mutex
+	subs    map[string]*Subscription
+	logs    map[string][]Delivery // subscription ID -> attempts, newest first
+	retries map[*time.Timer]bool  // deliveries waiting out their backoff
+	stopped bool
+}
+
+// job is one event on its way to one subscription
+type job struct {
+	subscriptionID string
+	deliveryID     string
+	event          string
+	body           []byte
+	attempt        int
+}
+
+// New returns a Dispatcher looking payload data up in dir. It keeps subscriptions but
+// delivers nothing until started.
+func New(dir Directory, opts Options) *Dispatcher {
+	if opts.Workers <= 0 {
+		opts.Workers = 4
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 1024
+	}
+	if opts.Attempts <= 0 {
+		opts.Attempts = 5
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = 5 * time.Second
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	client := opts.Client
+	if client == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		if !opts.AllowPrivate {
+			// The address is checked once resolved, on every connection, so a public name
+			// that later resolves to an internal address is refused too. A proxy would do
+			// the resolving itself, past the check, so none is used.
+			dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: refusePrivate}
+			transport.DialContext = dialer.DialContext
+			transport.Proxy = nil
+		}
+		client = &http.Client{
+			Transport: transport,
+			Timeout:   opts.Timeout,
+			// A redirect is an answer from the wrong endpoint; report it rather than follow it
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Dispatcher{
+		dir:     dir,
+		opts:    opts,
+		client:  client,
+		jobs:    make(chan *job, opts.QueueSize),
+		ctx:     ctx,
+		cancel:  cancel,
+		subs:    make(map[string]*Subscription),
+		logs:    make(map[string][]Delivery),
+		retries: make(map[*time.Timer]bool),
+	}
+}
+
+// Add registers sub, filling in its ID, secret and creation time. The URL must be absolute
+// http(s) and every event one of EventTypes. Unless AllowPrivate is set, URLs naming a
+// non-public address outright are refused here; host names are checked as they resolve.
+func (d *Dispatcher) Add(sub Subscription) (*Subscription, error) {
+	u, err := url.Parse(sub.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: url must be an absolute http or https URL", ErrInvalidSubscription)
+	}
+	if !d.opts.AllowPrivate {
+		host := strings.TrimSuffix(u.Hostname(), ".")
+		if ip := net.ParseIP(host); strings.EqualFold(host, "localhost") || (ip != nil && privateIP(ip)) {
+			return nil, fmt.Errorf("%w: url must point to a public address", ErrInvalidSubscription)
+		}
+	}
+	seen := make(map[string]bool, len(sub.Events))
+	events := make([]string, 0, len(sub.Events))
+	for _, e := range sub.Events {
+		if _, known := knownEvents[e]; !known {
+			return nil, fmt.Errorf("%w: unknown event %q", ErrInvalidSubscription, e)
+		}
+		if !seen[e] {
+			seen[e] = true
+			events = append(events, e)
+		}
+	}
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	sub.Events = events
+	sub.ID = "wh-" + uuid.New().String()
+	sub.Secret = "whsec_" + hex.EncodeToString(secret)
+	sub.CreatedAt = time.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.subs[sub.ID] = &sub
+	cp := sub
+	return &cp, nil
+}
+
+// Get returns subscription id
+func (d *Dispatcher) Get(id string) (*Subscription, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	sub, ok := d.subs[id]
+	if !ok {
+		return nil, ErrSubscriptionNotFound
+	}
+	cp := *sub
+	return &cp, nil
+}
+
+// List returns every subscription, oldest first
+func (d *Dispatcher) List() []Subscription {
+	d.mu.Lock()
+	list := make([]Subscription, 0, len(d.subs))
+	for _, sub := range d.subs {
+		list = append(list, *sub)
+	}
+	d.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.Before(list[j].CreatedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// Remove deletes subscription id and its delivery log; deliveries still queued for it are
+// dropped
+func (d *Dispatcher) Remove(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.subs[id]; !ok {
+		return ErrSubscriptionNotFound
+	}
+	delete(d.subs, id)
+	delete(d.logs, id)
+	return nil
+}
+
+// Deliveries returns the latest attempts to deliver to subscription id, newest first
+func (d *Dispatcher) Deliveries(id string) ([]Delivery, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.subs[id]; !ok {
+		return nil, ErrSubscriptionNotFound
+	}
+	return append([]Delivery{}, d.logs[id]...), nil
+}
+
+// Start delivers events until the returned stop func is called. Stop unsubscribes, drops
+// deliveries waiting to be retried and waits up to timeout for queued ones to go out.
+func (d *Dispatcher) Start(events <-chan database.Event, unsubscribe func(), timeout time.Duration) (stop func()) {
+	for i := 0; i < d.opts.Workers; i++ {
+		d.workers.Add(1)
+		go d.work()
+	}
+	dispatched := make(chan struct{})
+	go func() {
+		defer close(dispatched)
+		for ev := range events {
+			d.Handle(ev)
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			unsubscribe()
+			<-dispatched
+			d.mu.Lock()
+			d.stopped = true
+			if len(d.retries) > 0 {
+				slog.Warn("Dropping webhook deliveries waiting to be retried", "count", len(d.retries))
+			}
+			for t := range d.retries {
+				t.Stop()
+				delete(d.retries, t)
+			}
+			close(d.jobs)
+			d.mu.Unlock()
+
+			done := make(chan struct{})
+			go func() {
+				d.workers.Wait()
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(timeout):
+				slog.Warn("Gave up waiting for queued webhook deliveries", "timeout", timeout.String())
+				d.cancel()
+				<-done
+			}
+			d.cancel()
+		})
+	}
+}
+
+// Handle queues a delivery of ev to every subscription that wants it. The payload is built
+// once, as things stand when the event is handled, and sent unchanged to each of them.
+func (d *Dispatcher) Handle(ev database.Event) {
+	event, ok := eventTypes[ev.Type]
+	if !ok {
+		return
+	}
+	d.mu.Lock()
+	var targets []string
+	for id, sub := range d.subs {
+		if sub.Wants(event, ev.ConferenceID) {
+			targets = append(targets, id)
+		}
+	}
+	d.mu.Unlock()
+	if len(targets) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(Payload{ID: "evt-" + uuid.New().String(), Event: event, CreatedAt: ev.At, Data: d.data(ev)})
+	if err != nil {
+		slog.Error("Encode webhook payload failed", "event", event, "error", err)
+		return
+	}
+	for _, id := range targets {
+		d.enqueue(&job{subscriptionID: id, deliveryID: "whd-" + uuid.New().String(), event: event, body: body, attempt: 1})
+	}
+}
+
+// data is what ev's payload carries: its IDs, and the booking and reservation it names
+// while they can still be found
+func (d *Dispatcher) data(ev database.Event) Data {
+	data := Data{
+		ConferenceID:  ev.ConferenceID,
+		UserID:        ev.UserID,
+		BookingID:     ev.BookingID,
+		ReservationID: ev.ReservationID,
+		TicketCount:   ev.TicketCount,
+		RequestID:     ev.RequestID,
+	}
+	if ev.BookingID != "" {
+		if b := d.dir.GetBooking(ev.BookingID); b != nil {
+			cp := *b
+			data.Booking = &cp
+		}
+	}
+	if ev.ReservationID != "" {
+		if res, err := d.dir.GetReservation(ev.ReservationID); err == nil {
+			cp := *res
+			cp.PaymentClientSecret = "" // only the paying client gets to see it
+			data.Reservation = &cp
+		}
+	}
+	return data
+}
+
+// enqueue hands j to the workers unless the queue is full or stopped
+func (d *Dispatcher) enqueue(j *job) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stopped {
+		return
+	}
+	select {
+	case d.jobs <- j:
+	default:
+		slog.Warn("Webhook queue full; dropping delivery", "subscription_id", j.subscriptionID, "event", j.event,
+			"queue_size", d.opts.QueueSize)
+	}
+}
+
+func (d *Dispatcher) work() {
+	defer d.workers.Done()
+	for j := range d.jobs {
+		d.deliver(j)
+	}
+}
+
+// deliver makes one attempt at j, logs it and schedules the next attempt if it failed
+func (d *Dispatcher) deliver(j *job) {
+	d.mu.Lock()
+	sub, ok := d.subs[j.subscriptionID]
+	var url, secret string
+	if ok {
+		url, secret = sub.URL, sub.Secret
+	}
+	d.mu.Unlock()
+	if !ok {
+		return // removed since
+	}
+
+	start := time.Now()
+	status, err := d.post(url, secret, j)
+	delivery := Delivery{
+		ID:             j.deliveryID,
+		SubscriptionID: j.subscriptionID,
+		Event:          j.event,
+		Attempt:        j.attempt,
+		At:             start,
+		StatusCode:     status,
+		DurationMS:     time.Since(start).Milliseconds(),
+		Succeeded:      err == nil,
+	}
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.subs[j.subscriptionID]; !ok {
+		return
+	}
+	if err != nil && j.attempt < d.opts.Attempts && !d.stopped {
+		delay := d.opts.RetryDelay << (j.attempt - 1)
+		next := start.Add(delay)
+		delivery.NextRetryAt = &next
+		retry := *j
+		retry.attempt++
+		var timer *time.Timer
+		timer = time.AfterFunc(delay, func() {
+			d.mu.Lock()
+			delete(d.retries, timer)
+			d.mu.Unlock()
+			d.enqueue(&retry)
+		})
+		d.retries[timer] = true // set under d.mu, so the func above sees it
+	} else if err != nil {
+		slog.Error("Webhook delivery failed", "subscription_id", j.subscriptionID, "event", j.event,
+			"attempts", j.attempt, "error", err)
+	}
+	log := append([]Delivery{delivery}, d.logs[j.subscriptionID]...)
+	if len(log) > DeliveryLogSize {
+		log = log[:DeliveryLogSize]
+	}
+	d.logs[j.subscriptionID] = log
+}
+
+// post sends j's body to url signed with secret; any status outside 2xx is a failure
+func (d *Dispatcher) post(url, secret string, j *job) (int, error) {
+	ctx, cancel := context.WithTimeout(d.ctx, d.opts.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(j.body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "booking-system-webhooks/1")
+	req.Header.Set(EventHeader, j.event)
+	req.Header.Set(DeliveryHeader, j.deliveryID)
+	req.Header.Set(SignatureHeader, Sign(j.body, secret, time.Now()))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// refusePrivate is the dialer Control hook of the default client: it fails connections to
+// addresses privateIP reports
+func refusePrivate(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || privateIP(ip) {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, host)
+	}
+	return nil
+}
+
+// privateIP reports whether ip is loopback, private, link-local (which covers the cloud
+// metadata address 169.254.169.254), multicast or unspecified
+func privateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+}
@@ -0,0 +1,186 @@
+// Package webhooks lets integrators follow bookings from their own systems. A Subscription
+// names a URL, optionally a conference and the events it wants; a Dispatcher turns the
+// store's live events into signed JSON POSTs to every matching subscription, retries failed
+// deliveries with backoff and keeps a log of recent attempts per subscription.
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"booking-system/database"
+	"booking-system/models"
+)
+
+// Event types subscribers receive. They are named for what happened rather than after the
+// store operations behind them, several of which may map to one event.
+const (
+	EventBookingCreated       = "booking.created"
+	EventBookingCancelled     = "booking.cancelled"
+	EventReservationCreated   = "reservation.created"
+	EventReservationExpired   = "reservation.expired"
+	EventReservationCancelled = "reservation.cancelled"
+	EventQueuePromoted        = "queue.promoted"
+	EventTicketCheckedIn      = "ticket.checked_in"
+)
+
+// EventTypes lists every event type, in the order the docs give them
+var EventTypes = []string{
+	EventBookingCreated, EventBookingCancelled, EventReservationCreated, EventReservationExpired,
+	EventReservationCancelled, EventQueuePromoted, EventTicketCheckedIn,
+}
+
+// knownEvents is EventTypes as a set, for validating subscriptions
+var knownEvents = func() map[string]struct{} {
+	m := make(map[string]struct{}, len(EventTypes))
+	for _, e := range EventTypes {
+		m[e] = struct{}{}
+	}
+	return m
+}()
+
+// eventTypes maps store operations to the event subscribers see. A confirmed hold is a new
+// booking; a queue offer or a notification to claim both mean the queue reached the user.
+var eventTypes = map[string]string{
+	database.OpBookingCreate:      EventBookingCreated,
+	database.OpReservationConfirm: EventBookingCreated,
+	database.OpBookingCancel:      EventBookingCancelled,
+	database.OpReservationCreate:  EventReservationCreated,
+	database.OpQueueClaim:         EventReservationCreated,
+	database.OpReservationExpire:  EventReservationExpired,
+	database.OpReservationCancel:  EventReservationCancelled,
+	database.OpQueueOffer:         EventQueuePromoted,
+	database.OpQueueNotify:        EventQueuePromoted,
+	database.OpTicketCheckIn:      EventTicketCheckedIn,
+}
+
+// Headers sent with every delivery
+const (
+	SignatureHeader = "X-Webhook-Signature" // "t=<unix>,v1=<hex HMAC-SHA256 of "<t>.<body>">"
+	EventHeader     = "X-Webhook-Event"     // the event type, also in the body
+	DeliveryHeader  = "X-Webhook-Delivery"  // the delivery ID, the same on every retry
+)
+
+// SignatureTolerance is how old a signature Verify still accepts
+const SignatureTolerance = 5 * time.Minute
+
+var (
+	ErrSubscriptionNotFound = fmt.Errorf("webhook subscription %w", database.ErrNotFound)
+	ErrInvalidSubscription  = errors.New("invalid webhook subscription")
+	ErrBlockedAddress       = errors.New("webhook address is not public")
+	ErrBadSignature         = errors.New("invalid webhook signature")
+)
+
+// Subscription is where and which events to deliver. Secret signs every delivery; it is
+// only shown when the subscription is created.
+type Subscription struct {
+	ID           string    `json:"id"`
+	URL          string    `json:"url"`
+	ConferenceID string    `json:"conference_id,omitempty"` // empty: every conference
+	Events       []string  `json:"events,omitempty"`        // empty: every event type
+	Secret       string    `json:"-"`
+	CreatedBy    string    `json:"created_by,omitempty"` // user who registered it; empty for the admin token
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Wants reports whether the subscription receives event about conferenceID
+func (s *Subscription) Wants(event, conferenceID string) bool {
+	if s.ConferenceID != "" && s.ConferenceID != conferenceID {
+		return false
+	}
+	if len(s.Events) == 0 {
+		return true
+	}
+	for _, e := range s.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Payload is the JSON body of a delivery. Data carries the IDs of what changed and, while
+// they still exist, the booking or reservation itself.
+type Payload struct {
+	ID        string    `json:"id"` // the event's ID, the same on every retry and to every subscription
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"` // when the change happened, not when it was sent
+	Data      Data      `json:"data"`
+}
+
+// Data is what a payload is about
+type Data struct {
+	ConferenceID  string                  `json:"conference_id,omitempty"`
+	UserID        string                  `json:"user_id,omitempty"`
+	BookingID     string                  `json:"booking_id,omitempty"`
+	ReservationID string                  `json:"reservation_id,omitempty"`
+	TicketCount   int                     `json:"ticket_count,omitempty"`
+	RequestID     string                  `json:"request_id,omitempty"`
+	Booking       *models.Booking         `json:"booking,omitempty"`
+	Reservation   *models.SeatReservation `json:"reservation,omitempty"`
+}
+
+// Delivery is one attempt to deliver an event to a subscription
+type Delivery struct {
+	ID             string     `json:"id"` // shared by every attempt at the same event
+	SubscriptionID string     `json:"subscription_id"`
+	Event          string     `json:"event"`
+	Attempt        int        `json:"attempt"` // 1 for the first try
+	At             time.Time  `json:"at"`
+	StatusCode     int        `json:"status_code,omitempty"` // 0 when no response came back
+	Error          string     `json:"error,omitempty"`
+	DurationMS     int64      `json:"duration_ms"`
+	Succeeded      bool       `json:"succeeded"`
+	NextRetryAt    *time.Time `json:"next_retry_at,omitempty"` // set when the attempt failed and another will follow
+}
+
+// Sign builds the SignatureHeader value for body sent at t
+func Sign(body []byte, secret string, t time.Time) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + timestamp + ",v1=" + signature(body, secret, timestamp)
+}
+
+// Verify checks a SignatureHeader value against body, for receivers and tests. Signatures
+// older (or newer) than SignatureTolerance are refused so captured deliveries can't be
+// replayed later.
+func Verify(body []byte, header, secret string, now time.Time) error {
+	var timestamp string
+	var sigs []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			sigs = append(sigs, value)
+		}
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(sigs) == 0 {
+		return fmt.Errorf("%w: malformed %s header", ErrBadSignature, SignatureHeader)
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > SignatureTolerance || age < -SignatureTolerance {
+		return fmt.Errorf("%w: timestamp outside the %s tolerance", ErrBadSignature, SignatureTolerance)
+	}
+	expected := signature(body, secret, timestamp)
+	for _, sig := range sigs {
+		if hmac.Equal([]byte(sig), []byte(expected)) {
+			return nil
+		}
+	}
+	return ErrBadSignature
+}
+
+// signature is the hex HMAC-SHA256 of "<timestamp>.<body>" under secret
+func signature(body []byte, secret, timestamp string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
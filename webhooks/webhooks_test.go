@@ -0,0 +1,183 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"booking-system/database"
+)
+
+func TestSignaturesVerifyOnlyTheSignedBody(t *testing.T) {
+	body := []byte(`{"event":"booking.created"}`)
+	now := time.Unix(1_700_000_000, 0)
+	header := Sign(body, "whsec_test", now)
+	if err := Verify(body, header, "whsec_test", now.Add(time.Minute)); err != nil {
+		t.Fatalf("expected a valid signature, got %v", err)
+	}
+	for name, err := range map[string]error{
+		"tampered body": Verify([]byte(`{"event":"booking.cancelled"}`), header, "whsec_test", now),
+		"other secret":  Verify(body, header, "whsec_other", now),
+		"too old":       Verify(body, header, "whsec_test", now.Add(SignatureTolerance+time.Second)),
+		"malformed":     Verify(body, "v1=abc", "whsec_test", now),
+	} {
+		if !errors.Is(err, ErrBadSignature) {
+			t.Errorf("%s: expected ErrBadSignature, got %v", name, err)
+		}
+	}
+}
+
+func TestDeliveriesToPrivateAddressesAreRefused(t *testing.T) {
+	db := database.NewDatabase()
+	t.Cleanup(db.Close)
+	hits := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { hits++ }))
+	defer server.Close()
+
+	d := New(db, Options{})
+	for _, u := range []string{"http://127.0.0.1:8080/in", "http://localhost/in", "http://10.0.0.7/in", "http://[::1]/in",
+		"http://169.254.169.254/latest/meta-data", "http://0.0.0.0/in"} {
+		if _, err := d.Add(Subscription{URL: u}); !errors.Is(err, ErrInvalidSubscription) {
+			t.Errorf("%s: expected ErrInvalidSubscription, got %v", u, err)
+		}
+	}
+	if _, err := d.Add(Subscription{URL: "https://hooks.example.com/in"}); err != nil {
+		t.Fatalf("expected a public host accepted, got %v", err)
+	}
+
+	// names are checked once resolved, whatever Add let through
+	port := server.URL[strings.LastIndex(server.URL, ":")+1:]
+	for _, u := range []string{server.URL, "http://localhost:" + port} {
+		if _, err := d.post(u, "whsec_test", &job{event: EventBookingCreated, deliveryID: "whd-1", body: []byte(`{}`)}); !errors.Is(err, ErrBlockedAddress) {
+			t.Errorf("%s: expected ErrBlockedAddress, got %v", u, err)
+		}
+	}
+	if hits != 0 {
+		t.Fatalf("expected nothing delivered, got %d requests", hits)
+	}
+}
+
+// received is what a test endpoint was sent
+type received struct {
+	event, delivery string
+	payload         Payload
+}
+
+func TestDispatcherDeliversSignedEventsAndRetries(t *testing.T) {
+	db := database.NewDatabase()
+	t.Cleanup(db.Close)
+
+	var mu sync.Mutex
+	var got []received
+	failures := 1 // the first delivery fails, so it is retried
+	var secret string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		if err := Verify(body, r.Header.Get(SignatureHeader), secret, time.Now()); err != nil {
+			t.Errorf("delivery failed verification: %v", err)
+		}
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var p Payload
+		if err := json.Unmarshal(body, &p); err != nil {
+			t.Errorf("bad payload: %v", err)
+		}
+		got = append(got, received{event: r.Header.Get(EventHeader), delivery: r.Header.Get(DeliveryHeader), payload: p})
+	}))
+	defer server.Close()
+
+	d := New(db, Options{RetryDelay: 20 * time.Millisecond, AllowPrivate: true}) // the test server is on loopback
+	if _, err := d.Add(Subscription{URL: "mailto:ops@example.com"}); !errors.Is(err, ErrInvalidSubscription) {
+		t.Fatalf("expected ErrInvalidSubscription for a non-http URL, got %v", err)
+	}
+	sub, err := d.Add(Subscription{URL: server.URL, ConferenceID: "conf-2", Events: []string{EventBookingCreated, EventBookingCancelled}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	mu.Lock()
+	secret = sub.Secret
+	mu.Unlock()
+	events, unsubscribe := db.Subscribe()
+	stop := d.Start(events, unsubscribe, time.Second)
+	defer stop()
+
+	alice, _ := db.CreateUser("Alice", "alice@example.com")
+	db.CreateBooking(alice.ID, "conf-1", 1, database.BookingOptions{}) // another conference
+	res, _ := db.CreateReservation(alice.ID, "conf-2", 1, database.ReservationOptions{})
+	booking, err := db.ConfirmReservation(res.ID, database.BookingOptions{}) // booking.created; the hold isn't subscribed to
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	db.CancelBooking(booking.ID)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		n := len(got)
+		mu.Unlock()
+		logged, _ := d.Deliveries(sub.ID) // an attempt is logged once its response is in
+		if n == 2 && len(logged) == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 2 deliveries in 3 attempts, got %d in %d", n, len(logged))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	mu.Lock()
+	byEvent := map[string]received{}
+	for _, r := range got {
+		byEvent[r.event] = r
+	}
+	mu.Unlock()
+	created, cancelled := byEvent[EventBookingCreated], byEvent[EventBookingCancelled]
+	if created.payload.Data.BookingID != booking.ID || created.payload.Data.Booking == nil || created.payload.Data.ReservationID != res.ID ||
+		created.payload.Data.ConferenceID != "conf-2" {
+		t.Fatalf("unexpected booking.created payload %+v", created.payload)
+	}
+	if cancelled.payload.Event != EventBookingCancelled || cancelled.payload.Data.BookingID != booking.ID {
+		t.Fatalf("unexpected booking.cancelled payload %+v", cancelled.payload)
+	}
+
+	// one of them took two attempts under the same delivery ID
+	log, err := d.Deliveries(sub.ID)
+	if err != nil || len(log) != 3 {
+		t.Fatalf("expected 3 attempts logged, got %+v (%v)", log, err)
+	}
+	var failed *Delivery
+	for i := range log {
+		if !log[i].Succeeded {
+			failed = &log[i]
+		}
+	}
+	if failed == nil || failed.StatusCode != http.StatusServiceUnavailable || failed.NextRetryAt == nil || failed.Attempt != 1 {
+		t.Fatalf("expected a failed first attempt with a retry time, got %+v", log)
+	}
+	retried := false
+	for _, l := range log {
+		if l.ID == failed.ID && l.Succeeded && l.Attempt == 2 {
+			retried = true
+		}
+	}
+	if !retried {
+		t.Fatalf("expected the failed delivery to succeed on its second attempt, got %+v", log)
+	}
+
+	if err := d.Remove(sub.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := d.Deliveries(sub.ID); !errors.Is(err, ErrSubscriptionNotFound) {
+		t.Fatalf("expected ErrSubscriptionNotFound, got %v", err)
+	}
+}